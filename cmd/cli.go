@@ -7,6 +7,7 @@ import (
 	"runtime"
 	"time"
 
+	"github.com/sanix-darker/git-ci/internal/config"
 	"github.com/sanix-darker/git-ci/internal/handlers"
 	cli "github.com/urfave/cli/v2"
 )
@@ -20,7 +21,20 @@ var (
 )
 
 func main() {
-	app := &cli.App{
+	if err := newApp().Run(os.Args); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// newApp returns the git-ci command line application
+func newApp() *cli.App {
+	// -v is --verbose, the version is only printed with --version
+	cli.VersionFlag = &cli.BoolFlag{
+		Name:  "version",
+		Usage: "print the version",
+	}
+
+	return &cli.App{
 		Name:     "git-ci",
 		Usage:    "Run CI/CD pipelines locally",
 		Version:  formatVersion(),
@@ -37,20 +51,16 @@ func main() {
 		Flags:                globalFlags(),
 		Commands:             commands(),
 	}
-
-	if err := app.Run(os.Args); err != nil {
-		log.Fatal(err)
-	}
 }
 
 func globalFlags() []cli.Flag {
 	return []cli.Flag{
-		//&cli.BoolFlag{
-		//	Name:    "verbose",
-		//	Aliases: []string{"v"},
-		//	Usage:   "Enable verbose output",
-		//	EnvVars: []string{"GIT_CI_VERBOSE"},
-		//},
+		&cli.BoolFlag{
+			Name:    "verbose",
+			Aliases: []string{"v"},
+			Usage:   "Enable verbose output",
+			EnvVars: []string{"GIT_CI_VERBOSE"},
+		},
 		&cli.BoolFlag{
 			Name:    "debug",
 			Usage:   "Enable debug mode",
@@ -75,6 +85,31 @@ func globalFlags() []cli.Flag {
 			EnvVars: []string{"GIT_CI_WORKDIR"},
 			Value:   ".",
 		},
+		&cli.StringFlag{
+			Name:    "theme",
+			Usage:   "Output theme (default, high-contrast, mono)",
+			EnvVars: []string{"GIT_CI_THEME"},
+		},
+		&cli.BoolFlag{
+			Name:    "offline",
+			Usage:   "Resolve remote and template includes, and actions, from the local cache only",
+			EnvVars: []string{"GIT_CI_OFFLINE"},
+		},
+		&cli.BoolFlag{
+			Name:    "merge-yaml-anchors",
+			Usage:   "Let GitLab files use the YAML anchors of the local files they include",
+			EnvVars: []string{"GIT_CI_MERGE_YAML_ANCHORS"},
+		},
+		&cli.BoolFlag{
+			Name:    "ascii",
+			Usage:   "Use plain ASCII status symbols ([OK], [FAIL], [SKIP])",
+			EnvVars: []string{"GIT_CI_ASCII"},
+		},
+		&cli.BoolFlag{
+			Name:    "no-color",
+			Usage:   "Disable colors (also when NO_COLOR is set or stdout is not a terminal)",
+			EnvVars: []string{"GIT_CI_NO_COLOR"},
+		},
 	}
 }
 
@@ -97,6 +132,68 @@ func commands() []*cli.Command {
 					Usage: "Output format (tree, json, yaml)",
 					Value: "tree",
 				},
+				&cli.BoolFlag{
+					Name:  "mr",
+					Usage: "Preview the jobs of a merge request pipeline from the current branch",
+				},
+				&cli.StringFlag{
+					Name:  "target-branch",
+					Usage: "Target branch of the --mr merge request (default: the default branch)",
+				},
+				&cli.StringFlag{
+					Name:  "changes-base",
+					Usage: "Commit or branch the changes rules compare to (default: the --mr target, the upstream branch or the previous commit)",
+				},
+			},
+		},
+		{
+			Name:   "graph",
+			Usage:  "Print the job graph (needs, dependencies, stages)",
+			Action: handlers.CmdGraph,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    "file",
+					Aliases: []string{"f"},
+					Usage:   "Pipeline file path",
+					EnvVars: []string{"GIT_CI_FILE"},
+				},
+				&cli.StringFlag{
+					Name:  "format",
+					Usage: "Output format (dot, mermaid)",
+					Value: "dot",
+				},
+				&cli.StringFlag{
+					Name:    "output",
+					Aliases: []string{"o"},
+					Usage:   "Output file path (default: stdout)",
+				},
+				&cli.BoolFlag{
+					Name:  "no-stages",
+					Usage: "Don't group the jobs by stage",
+				},
+			},
+		},
+		{
+			Name:   "convert",
+			Usage:  "Convert a pipeline to another provider",
+			Action: handlers.CmdConvert,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    "file",
+					Aliases: []string{"f"},
+					Usage:   "Pipeline file path",
+					EnvVars: []string{"GIT_CI_FILE"},
+				},
+				&cli.StringFlag{
+					Name:     "to",
+					Usage:    "Target provider (github, gitlab)",
+					Required: true,
+				},
+				&cli.StringFlag{
+					Name:    "output",
+					Aliases: []string{"o"},
+					Usage:   "Output file path (default: stdout)",
+				},
 			},
 		},
 		{
@@ -104,7 +201,7 @@ func commands() []*cli.Command {
 			Aliases: []string{"r", "exec"},
 			Usage:   "Run jobs or pipelines",
 			Action:  handlers.CmdRun,
-			Flags: []cli.Flag{
+			Flags: append([]cli.Flag{
 				&cli.StringFlag{
 					Name:    "file",
 					Aliases: []string{"f"},
@@ -123,6 +220,76 @@ func commands() []*cli.Command {
 					Usage:   "Stage name to run",
 					EnvVars: []string{"GIT_CI_STAGE"},
 				},
+				&cli.StringFlag{
+					Name:  "environment",
+					Usage: "Run only the jobs deploying to this environment (name or pattern with *)",
+				},
+				&cli.StringFlag{
+					Name:  "from",
+					Usage: "Start at this job, assuming its predecessors succeeded in the last run",
+				},
+				&cli.StringFlag{
+					Name:  "until",
+					Usage: "Stop after this job and its predecessors",
+				},
+				&cli.StringFlag{
+					Name:    "event",
+					Usage:   "Pipeline source seen by rules as CI_PIPELINE_SOURCE (push, merge_request_event, schedule, web, ...)",
+					EnvVars: []string{"GIT_CI_EVENT"},
+					Value:   "push",
+				},
+				&cli.BoolFlag{
+					Name:  "mr",
+					Usage: "Simulate a merge request pipeline from the current branch (merge request variables, changes rules matched against its diff)",
+				},
+				&cli.StringFlag{
+					Name:  "target-branch",
+					Usage: "Target branch of the --mr merge request (default: the default branch)",
+				},
+				&cli.StringFlag{
+					Name:  "changes-base",
+					Usage: "Commit or branch the changes rules compare to (default: the --mr target, the upstream branch or the previous commit)",
+				},
+				&cli.Float64Flag{
+					Name:  "coverage-threshold",
+					Usage: "Fail the run when the coverage of a job is below this percentage",
+				},
+				&cli.StringFlag{
+					Name:  "output",
+					Usage: "Output format (text, json: the run record on stdout, the job output on stderr)",
+					Value: "text",
+				},
+				&cli.StringFlag{
+					Name:  "report",
+					Usage: "Write a test report of the run, each job a suite of its steps (junit)",
+				},
+				&cli.StringFlag{
+					Name:  "report-file",
+					Usage: "Path of the test report",
+					Value: "report.xml",
+				},
+				&cli.BoolFlag{
+					Name:  "pin-images",
+					Usage: "Run each job in the image digest recorded by its previous run",
+				},
+				&cli.BoolFlag{
+					Name:  "force-all",
+					Usage: "Run jobs excluded by rules or only/except",
+				},
+				&cli.BoolFlag{
+					Name:  "fail-on-filtered",
+					Usage: "Exit with an error when workflow rules keep the pipeline from being created",
+				},
+				&cli.BoolFlag{
+					Name:  "include-delayed",
+					Usage: "Run `when: delayed` jobs after their start_in, scaled by --delay-scale",
+				},
+				&cli.Float64Flag{
+					Name:    "delay-scale",
+					Usage:   "Factor applied to the start_in of delayed jobs (1 waits the full delay)",
+					EnvVars: []string{"GIT_CI_DELAY_SCALE"},
+					Value:   0.001,
+				},
 				&cli.StringSliceFlag{
 					Name:    "only",
 					Usage:   "Run only these jobs",
@@ -144,12 +311,43 @@ func commands() []*cli.Command {
 					Usage:   "Use Podman runner",
 					EnvVars: []string{"GIT_CI_PODMAN"},
 				},
+				&cli.StringFlag{
+					Name:    "ssh",
+					Usage:   "Run jobs on a remote host over SSH ([user@]host[:port])",
+					EnvVars: []string{"GIT_CI_SSH"},
+				},
+				&cli.StringFlag{
+					Name:    "ssh-key",
+					Usage:   "Private key for --ssh (default: SSH agent, then ~/.ssh/id_*)",
+					EnvVars: []string{"GIT_CI_SSH_KEY"},
+				},
+				&cli.BoolFlag{
+					Name:  "ssh-insecure",
+					Usage: "Don't verify the --ssh host against known_hosts",
+				},
+				&cli.StringFlag{
+					Name:    "secrets-file",
+					Usage:   "JSON or YAML file with the values of the job secrets (by variable name or Vault path)",
+					EnvVars: []string{"GIT_CI_SECRETS_FILE"},
+				},
 				&cli.BoolFlag{
 					Name:    "dry-run",
 					Aliases: []string{"n"},
 					Usage:   "Perform a dry run",
 					EnvVars: []string{"GIT_CI_DRY_RUN"},
 				},
+				&cli.BoolFlag{
+					Name:  "print-script",
+					Usage: "Print each job as a standalone shell script instead of running it",
+				},
+				&cli.StringFlag{
+					Name:  "script-dir",
+					Usage: "Write the scripts of --print-script to <dir>/<job>.sh",
+				},
+				&cli.BoolFlag{
+					Name:  "detach",
+					Usage: "Run in the background, logging to the run's log file (see attach and cancel)",
+				},
 				&cli.BoolFlag{
 					Name:    "parallel",
 					Aliases: []string{"p"},
@@ -167,47 +365,20 @@ func commands() []*cli.Command {
 					Usage:   "Continue running on error",
 					EnvVars: []string{"GIT_CI_CONTINUE_ON_ERROR"},
 				},
-				&cli.IntFlag{
-					Name:    "timeout",
-					Aliases: []string{"t"},
-					Usage:   "Job timeout in minutes",
-					EnvVars: []string{"GIT_CI_TIMEOUT"},
-					Value:   30,
-				},
 				&cli.StringSliceFlag{
-					Name:    "env",
-					Aliases: []string{"e"},
-					Usage:   "Set environment variables (KEY=VALUE)",
-					EnvVars: []string{"GIT_CI_ENV"},
+					Name:  "var",
+					Usage: "Set a pipeline variable (KEY=VALUE), checked against its options",
 				},
 				&cli.StringFlag{
-					Name:    "env-file",
-					Usage:   "Environment file path",
-					EnvVars: []string{"GIT_CI_ENV_FILE"},
+					Name:    "compose",
+					Usage:   "Start a docker compose stack for the run; docker jobs join its network",
+					EnvVars: []string{"GIT_CI_COMPOSE"},
 				},
 				&cli.BoolFlag{
-					Name:    "pull",
-					Usage:   "Pull docker images",
-					EnvVars: []string{"GIT_CI_PULL"},
-					Value:   true,
+					Name:  "keep-services",
+					Usage: "Keep the --compose stack running after the run",
 				},
-				&cli.BoolFlag{
-					Name:    "no-cache",
-					Usage:   "Disable cache",
-					EnvVars: []string{"GIT_CI_NO_CACHE"},
-				},
-				&cli.StringSliceFlag{
-					Name:    "volume",
-					Aliases: []string{"V"},
-					Usage:   "Bind mount volumes",
-				},
-				&cli.StringFlag{
-					Name:    "network",
-					Usage:   "Docker network mode",
-					EnvVars: []string{"GIT_CI_NETWORK"},
-					Value:   "bridge",
-				},
-			},
+			}, runnerFlags()...),
 		},
 		{
 			Name:    "validate",
@@ -233,6 +404,73 @@ func commands() []*cli.Command {
 				},
 			},
 		},
+		{
+			Name:   "lint-actions",
+			Usage:  "Check the inputs given to actions against the inputs they declare",
+			Action: handlers.CmdLintActions,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    "file",
+					Aliases: []string{"f"},
+					Usage:   "Pipeline file path",
+					EnvVars: []string{"GIT_CI_FILE"},
+				},
+				&cli.BoolFlag{
+					Name:  "resolve-remote-actions",
+					Usage: "Read the action.yml of remote actions at their exact ref (network)",
+				},
+			},
+		},
+		{
+			Name:  "history",
+			Usage: "Inspect recorded runs",
+			Subcommands: []*cli.Command{
+				{
+					Name:   "show",
+					Usage:  "List recorded runs",
+					Action: handlers.CmdHistoryShow,
+					Flags: []cli.Flag{
+						&cli.BoolFlag{
+							Name:  "images",
+							Usage: "Show the image digests of each job over time",
+						},
+						&cli.StringFlag{
+							Name:  "pipeline",
+							Usage: "Only show runs of this pipeline",
+						},
+						&cli.IntFlag{
+							Name:  "limit",
+							Usage: "Maximum number of runs (0 for all)",
+							Value: 20,
+						},
+					},
+				},
+			},
+		},
+		{
+			Name:      "attach",
+			Usage:     "Follow the output and status of a detached run",
+			ArgsUsage: "<run-id>",
+			Action:    handlers.CmdAttach,
+		},
+		{
+			Name:      "cancel",
+			Usage:     "Cancel a detached run",
+			ArgsUsage: "<run-id>",
+			Action:    handlers.CmdCancel,
+		},
+		{
+			Name:      "schema",
+			Usage:     "Print the JSON schema of exported documents",
+			ArgsUsage: "pipeline|run",
+			Action:    handlers.CmdSchema,
+			Flags: []cli.Flag{
+				&cli.BoolFlag{
+					Name:  "generate",
+					Usage: "Generate the schema from the current types instead of the published one",
+				},
+			},
+		},
 		{
 			Name:   "init",
 			Usage:  "Initialize a new pipeline",
@@ -283,6 +521,10 @@ func commands() []*cli.Command {
 					Name:  "cache",
 					Usage: "Clean cache only",
 				},
+				&cli.BoolFlag{
+					Name:  "stale",
+					Usage: "Clean the containers, locks and partial records of crashed runs",
+				},
 				&cli.BoolFlag{
 					Name:    "force",
 					Aliases: []string{"f"},
@@ -329,6 +571,60 @@ func commands() []*cli.Command {
 						},
 					},
 				},
+				{
+					Name:   "resolve",
+					Usage:  "Show the expanded variables of a job or step",
+					Action: handlers.CmdEnvResolve,
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "file",
+							Aliases: []string{"f"},
+							Usage:   "Pipeline file path",
+							EnvVars: []string{"GIT_CI_FILE"},
+						},
+						&cli.StringFlag{
+							Name:     "job",
+							Aliases:  []string{"j"},
+							Usage:    "Job to resolve",
+							Required: true,
+						},
+						&cli.IntFlag{
+							Name:  "step",
+							Usage: "Also show the env of step `N` (from 1)",
+						},
+						&cli.StringSliceFlag{
+							Name:    "env",
+							Aliases: []string{"e"},
+							Usage:   "Set environment variables (KEY=VALUE)",
+						},
+					},
+				},
+				{
+					Name:      "stop",
+					Usage:     "Run the job that stops an environment",
+					Action:    handlers.CmdEnvStop,
+					ArgsUsage: "<environment>",
+					Flags: append([]cli.Flag{
+						&cli.StringFlag{
+							Name:    "file",
+							Aliases: []string{"f"},
+							Usage:   "Pipeline file path",
+							EnvVars: []string{"GIT_CI_FILE"},
+						},
+						&cli.BoolFlag{
+							Name:    "docker",
+							Aliases: []string{"d"},
+							Usage:   "Use Docker runner",
+							EnvVars: []string{"GIT_CI_DOCKER"},
+						},
+						&cli.BoolFlag{
+							Name:    "dry-run",
+							Aliases: []string{"n"},
+							Usage:   "Perform a dry run",
+							EnvVars: []string{"GIT_CI_DRY_RUN"},
+						},
+					}, runnerFlags()...),
+				},
 			},
 		},
 		{
@@ -362,6 +658,67 @@ func commands() []*cli.Command {
 	}
 }
 
+// runnerFlags returns the flags setting up the runners of the jobs, shared
+// by the commands running jobs
+func runnerFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.IntFlag{
+			Name:    "timeout",
+			Aliases: []string{"t"},
+			Usage:   "Job timeout in minutes",
+			EnvVars: []string{"GIT_CI_TIMEOUT"},
+			Value:   30,
+		},
+		&cli.DurationFlag{
+			Name:    "heartbeat",
+			Usage:   "Report steps that produce no output for this long (0 disables)",
+			EnvVars: []string{"GIT_CI_HEARTBEAT"},
+			Value:   30 * time.Second,
+		},
+		&cli.StringSliceFlag{
+			Name:    "env",
+			Aliases: []string{"e"},
+			Usage:   "Set environment variables (KEY=VALUE)",
+			EnvVars: []string{"GIT_CI_ENV"},
+		},
+		&cli.StringFlag{
+			Name:    "env-file",
+			Usage:   "Environment file path",
+			EnvVars: []string{"GIT_CI_ENV_FILE"},
+		},
+		&cli.BoolFlag{
+			Name:    "pull",
+			Usage:   "Pull docker images",
+			EnvVars: []string{"GIT_CI_PULL"},
+			Value:   true,
+		},
+		&cli.GenericFlag{
+			Name:    "no-cache",
+			Usage:   "Disable job caches (--no-cache=all also bypasses parse/metadata/image caches)",
+			EnvVars: []string{"GIT_CI_NO_CACHE"},
+			Value:   &config.NoCacheFlag{},
+			Action: func(c *cli.Context, value interface{}) error {
+				return value.(*config.NoCacheFlag).Err()
+			},
+		},
+		&cli.BoolFlag{
+			Name:    "no-workspace",
+			Usage:   "Run the jobs in the checkout itself instead of a copy of their own",
+			EnvVars: []string{"GIT_CI_NO_WORKSPACE"},
+		},
+		&cli.StringSliceFlag{
+			Name:    "volume",
+			Aliases: []string{"V"},
+			Usage:   "Bind mount volumes",
+		},
+		&cli.StringFlag{
+			Name:    "network",
+			Usage:   "Network of the job containers: bridge (default), host, none or a network name",
+			EnvVars: []string{"GIT_CI_NETWORK"},
+		},
+	}
+}
+
 func beforeAction(c *cli.Context) error {
 	// Setup environment
 	setupEnvironment()
@@ -371,6 +728,9 @@ func beforeAction(c *cli.Context) error {
 		return fmt.Errorf("failed to load config: %w", err)
 	}
 
+	// Point out leftovers of crashed runs
+	handlers.WarnStaleRuns(c)
+
 	return nil
 }
 
@@ -0,0 +1,649 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sanix-darker/git-ci/pkg/types"
+)
+
+// newRepo creates a git repository holding files, committed
+func newRepo(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"add", "-A"},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-qm", "init"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	return dir
+}
+
+// runCLI runs git-ci with args on the repository dir. Its cache lives in
+// a directory of the test unless GIT_CI_CACHE_DIR is already set.
+func runCLI(t *testing.T, dir string, args ...string) error {
+	t.Helper()
+
+	if os.Getenv("GIT_CI_CACHE_DIR") == "" {
+		t.Setenv("GIT_CI_CACHE_DIR", t.TempDir())
+	}
+	return newApp().Run(append([]string{"git-ci", "--workdir", dir}, args...))
+}
+
+func TestRunEmptyDependenciesStartClean(t *testing.T) {
+	dir := newRepo(t, map[string]string{
+		".gitlab-ci.yml": `
+stages: [build, deploy]
+build:
+  stage: build
+  script:
+    - mkdir -p build && echo built > build/out.txt
+  artifacts:
+    paths: [build/]
+deploy:
+  stage: deploy
+  dependencies: []
+  script:
+    - test ! -e build
+docs:
+  stage: deploy
+  script:
+    - test "$(cat build/out.txt)" = built
+`,
+	})
+
+	if err := runCLI(t, dir, "run", "-f", filepath.Join(dir, ".gitlab-ci.yml")); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "build")); !os.IsNotExist(err) {
+		t.Errorf("the job files reached the checkout: %v", err)
+	}
+}
+
+func TestRunNoWorkspaceRunsInTheCheckout(t *testing.T) {
+	dir := newRepo(t, map[string]string{
+		".gitlab-ci.yml": `
+build:
+  script:
+    - mkdir -p build && echo built > build/out.txt
+`,
+	})
+
+	if err := runCLI(t, dir, "run", "--no-workspace", "-f", filepath.Join(dir, ".gitlab-ci.yml")); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "build", "out.txt")); err != nil {
+		t.Errorf("the job did not run in the checkout: %v", err)
+	}
+}
+
+func TestRunNoCacheLeavesCacheUnwritten(t *testing.T) {
+	dir := newRepo(t, map[string]string{
+		".gitlab-ci.yml": `
+build:
+  script:
+    - mkdir -p deps && echo v1 > deps/marker
+  cache:
+    key: deps
+    paths: [deps/]
+`,
+	})
+
+	for _, flag := range []string{"--no-cache", "--no-cache=all"} {
+		t.Run(flag, func(t *testing.T) {
+			cacheDir := t.TempDir()
+			t.Setenv("GIT_CI_CACHE_DIR", cacheDir)
+
+			if err := runCLI(t, dir, "run", flag, "-f", filepath.Join(dir, ".gitlab-ci.yml")); err != nil {
+				t.Fatalf("run failed: %v", err)
+			}
+			for _, kind := range []string{"jobs", "includes", "actions"} {
+				if _, err := os.Stat(filepath.Join(cacheDir, kind)); !os.IsNotExist(err) {
+					t.Errorf("%s wrote the %s cache", flag, kind)
+				}
+			}
+		})
+	}
+
+	// The same run writes the job cache when caching is on
+	cacheDir := t.TempDir()
+	t.Setenv("GIT_CI_CACHE_DIR", cacheDir)
+	if err := runCLI(t, dir, "run", "-f", filepath.Join(dir, ".gitlab-ci.yml")); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(cacheDir, "jobs")); err != nil {
+		t.Errorf("the job cache was not written: %v", err)
+	}
+}
+
+func TestRunInvalidNoCacheValue(t *testing.T) {
+	dir := newRepo(t, map[string]string{
+		".gitlab-ci.yml": "build:\n  script: [echo build]\n",
+	})
+
+	err := runCLI(t, dir, "run", "--no-cache=bogus", "-f", filepath.Join(dir, ".gitlab-ci.yml"))
+	want := `invalid --no-cache value "bogus" (expected true, false or all)`
+	if err == nil || err.Error() != want {
+		t.Errorf("error %v, want %s", err, want)
+	}
+}
+
+func TestEnvStopTakesRunnerFlags(t *testing.T) {
+	dir := newRepo(t, map[string]string{
+		".gitlab-ci.yml": `
+deploy:
+  script: [echo deploy]
+  environment:
+    name: review
+    on_stop: stop
+stop:
+  script: [echo stop]
+  when: manual
+  environment:
+    name: review
+    action: stop
+`,
+	})
+
+	err := runCLI(t, dir, "env", "stop", "--timeout", "5", "--pull=false", "-f", filepath.Join(dir, ".gitlab-ci.yml"), "review")
+	if err != nil {
+		t.Fatalf("env stop failed: %v", err)
+	}
+}
+
+func TestInspectCommandsFetchNothing(t *testing.T) {
+	dir := newRepo(t, map[string]string{
+		".github/workflows/ci.yml": `
+name: CI
+on: push
+jobs:
+  build:
+    uses: git-ci-test/missing/.github/workflows/build.yml@main
+  test:
+    runs-on: ubuntu-latest
+    needs: build
+    steps:
+      - run: echo test
+`,
+	})
+	file := filepath.Join(dir, ".github", "workflows", "ci.yml")
+
+	for _, command := range []string{"list", "validate", "graph"} {
+		t.Run(command, func(t *testing.T) {
+			cacheDir := t.TempDir()
+			t.Setenv("GIT_CI_CACHE_DIR", cacheDir)
+
+			if err := runCLI(t, dir, command, "-f", file); err != nil {
+				t.Fatalf("%s failed: %v", command, err)
+			}
+			if _, err := os.Stat(filepath.Join(cacheDir, "actions")); !os.IsNotExist(err) {
+				t.Errorf("%s fetched the remote workflow", command)
+			}
+		})
+	}
+}
+
+func TestRunRestoresJobCachesOfItsProject(t *testing.T) {
+	cacheDir := t.TempDir()
+	t.Setenv("GIT_CI_CACHE_DIR", cacheDir)
+
+	files := map[string]string{
+		".gitlab-ci.yml": `
+build:
+  script:
+    - mkdir -p deps && echo "$MARKER" > deps/marker
+  cache:
+    key: deps
+    paths: [deps/]
+`,
+		"restore.gitlab-ci.yml": `
+build:
+  script:
+    - test "$(cat deps/marker 2>/dev/null)" = "$MARKER"
+  cache:
+    key: deps
+    paths: [deps/]
+    policy: pull
+`,
+	}
+	withOrigin := func(dir, url string) string {
+		cmd := exec.Command("git", "remote", "add", "origin", url)
+		cmd.Dir = dir
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git remote add: %v\n%s", err, out)
+		}
+		return dir
+	}
+	run := func(dir, file, marker string) error {
+		return runCLI(t, dir, "run", "-e", "MARKER="+marker, "-f", filepath.Join(dir, file))
+	}
+
+	project := withOrigin(newRepo(t, files), "https://example.com/group/project.git")
+	if err := run(project, ".gitlab-ci.yml", "project"); err != nil {
+		t.Fatalf("first run failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(cacheDir, "jobs", "deps.tar.gz")); !os.IsNotExist(err) {
+		t.Error("the cache was written where every project reads it")
+	}
+
+	// The next run of the project, and of its clones, restores it
+	if err := run(project, "restore.gitlab-ci.yml", "project"); err != nil {
+		t.Errorf("the second run didn't restore the cache: %v", err)
+	}
+	clone := withOrigin(newRepo(t, files), "https://example.com/group/project.git")
+	if err := run(clone, "restore.gitlab-ci.yml", "project"); err != nil {
+		t.Errorf("a clone of the project didn't restore its cache: %v", err)
+	}
+
+	// Other projects don't see it, with or without a remote
+	other := withOrigin(newRepo(t, files), "https://example.com/group/other.git")
+	if err := run(other, "restore.gitlab-ci.yml", ""); err != nil {
+		t.Errorf("another project restored the cache: %v", err)
+	}
+	if err := run(newRepo(t, files), "restore.gitlab-ci.yml", ""); err != nil {
+		t.Errorf("a project without remote restored the cache: %v", err)
+	}
+}
+
+func TestRunGitlabRulesIfIsNotAGithubCondition(t *testing.T) {
+	dir := newRepo(t, map[string]string{
+		".gitlab-ci.yml": `
+stages: [build, test]
+variables:
+  DEPLOY: "yes"
+build:
+  stage: build
+  rules:
+    - if: '$DEPLOY == "yes"'
+  script:
+    - echo built
+test:
+  stage: test
+  script:
+    - echo tested
+`,
+	})
+
+	if err := runCLI(t, dir, "run", "-f", filepath.Join(dir, ".gitlab-ci.yml")); err != nil {
+		t.Fatalf("a job with a matching rules:if failed the run: %v", err)
+	}
+}
+
+func TestRunContinueOnErrorSkipsDownstreamJobs(t *testing.T) {
+	for _, mode := range [][]string{nil, {"--parallel"}} {
+		t.Run(fmt.Sprintf("%v", mode), func(t *testing.T) {
+			ran := t.TempDir()
+			dir := newRepo(t, map[string]string{
+				".github/workflows/ci.yml": fmt.Sprintf(`
+name: ci
+on: push
+jobs:
+  broken:
+    runs-on: ubuntu-latest
+    steps:
+      - run: exit 1
+  downstream:
+    runs-on: ubuntu-latest
+    needs: broken
+    steps:
+      - run: touch %[1]s/downstream
+  independent:
+    runs-on: ubuntu-latest
+    steps:
+      - run: touch %[1]s/independent
+`, ran),
+			})
+
+			args := append([]string{"run", "--continue-on-error"}, mode...)
+			args = append(args, "-f", filepath.Join(dir, ".github", "workflows", "ci.yml"))
+			if err := runCLI(t, dir, args...); err == nil {
+				t.Error("the run succeeded with a failed job")
+			}
+			if _, err := os.Stat(filepath.Join(ran, "independent")); err != nil {
+				t.Errorf("the independent job did not run: %v", err)
+			}
+			if _, err := os.Stat(filepath.Join(ran, "downstream")); !os.IsNotExist(err) {
+				t.Errorf("the job needing the failed one ran: %v", err)
+			}
+		})
+	}
+}
+
+// captureStdout returns what fn writes to stdout, through a pipe
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	done := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		done <- string(data)
+	}()
+
+	fn()
+	w.Close()
+	return <-done
+}
+
+func TestRunPipedOutputHasNoColors(t *testing.T) {
+	t.Setenv("NO_COLOR", "")
+	t.Setenv("GIT_CI_NO_COLOR", "")
+	dir := newRepo(t, map[string]string{
+		".gitlab-ci.yml": `
+stages: [build, test]
+build:
+  stage: build
+  script: [echo built]
+test:
+  stage: test
+  script: [echo tested, exit 1]
+`,
+	})
+
+	var err error
+	out := captureStdout(t, func() {
+		err = runCLI(t, dir, "run", "-f", filepath.Join(dir, ".gitlab-ci.yml"))
+	})
+	if err == nil {
+		t.Fatal("run succeeded with a failing job")
+	}
+	if !strings.Contains(out, "tested") {
+		t.Fatalf("job output missing:\n%s", out)
+	}
+	if strings.Contains(out, "\x1b[") {
+		t.Errorf("ANSI escape codes in piped output:\n%q", out)
+	}
+}
+
+func TestRunSelectedJobNotDumped(t *testing.T) {
+	dir := newRepo(t, map[string]string{
+		".gitlab-ci.yml": `
+build:
+  script: [echo built]
+lint:
+  script: [echo linted]
+`,
+	})
+
+	var err error
+	out := captureStdout(t, func() {
+		err = runCLI(t, dir, "run", "-j", "build", "-f", filepath.Join(dir, ".gitlab-ci.yml"))
+	})
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if !strings.Contains(out, "built") || strings.Contains(out, "linted") {
+		t.Fatalf("want only the build job run:\n%s", out)
+	}
+	if strings.Contains(out, "&{") {
+		t.Errorf("job struct printed:\n%s", out)
+	}
+}
+
+func TestRunVerbose(t *testing.T) {
+	dir := newRepo(t, map[string]string{
+		".gitlab-ci.yml": `
+build:
+  variables:
+    GREETING: hello
+  script: [echo built]
+`,
+	})
+	file := filepath.Join(dir, ".gitlab-ci.yml")
+
+	tests := []struct {
+		name    string
+		env     string
+		args    []string
+		verbose bool
+	}{
+		{"default", "", []string{"run", "-f", file}, false},
+		{"--verbose", "", []string{"--verbose", "run", "-f", file}, true},
+		{"-v", "", []string{"-v", "run", "-f", file}, true},
+		{"GIT_CI_VERBOSE", "true", []string{"run", "-f", file}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("GIT_CI_VERBOSE", tt.env)
+
+			var err error
+			out := captureStdout(t, func() {
+				err = runCLI(t, dir, tt.args...)
+			})
+			if err != nil {
+				t.Fatalf("run failed: %v", err)
+			}
+			if got := strings.Contains(out, "Environment Variables") && strings.Contains(out, "GREETING"); got != tt.verbose {
+				t.Errorf("environment section printed: %v, want %v:\n%s", got, tt.verbose, out)
+			}
+		})
+	}
+}
+
+func TestRunJUnitReport(t *testing.T) {
+	dir := newRepo(t, map[string]string{
+		".gitlab-ci.yml": `
+stages: [build, test, deploy]
+build:
+  stage: build
+  script:
+    - echo compiling
+    - echo linking
+test:
+  stage: test
+  script:
+    - echo testing
+    - exit 3
+deploy:
+  stage: deploy
+  script: [echo deploy]
+`,
+	})
+	report := filepath.Join(t.TempDir(), "results.xml")
+
+	if err := runCLI(t, dir, "run", "--report", "junit", "--report-file", report, "-f", filepath.Join(dir, ".gitlab-ci.yml")); err == nil {
+		t.Fatal("run succeeded with a failing job")
+	}
+
+	data, err := os.ReadFile(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	type testCase struct {
+		Name    string `xml:"name,attr"`
+		Failure *struct {
+			Message string `xml:"message,attr"`
+			Text    string `xml:",chardata"`
+		} `xml:"failure"`
+		Skipped *struct{} `xml:"skipped"`
+	}
+	var suites struct {
+		XMLName  xml.Name `xml:"testsuites"`
+		Tests    int      `xml:"tests,attr"`
+		Failures int      `xml:"failures,attr"`
+		Skipped  int      `xml:"skipped,attr"`
+		Suites   []struct {
+			Name     string     `xml:"name,attr"`
+			Tests    int        `xml:"tests,attr"`
+			Failures int        `xml:"failures,attr"`
+			Cases    []testCase `xml:"testcase"`
+		} `xml:"testsuite"`
+	}
+	if err := xml.Unmarshal(data, &suites); err != nil {
+		t.Fatalf("invalid JUnit XML: %v\n%s", err, data)
+	}
+
+	if suites.Tests != 5 || suites.Failures != 1 || suites.Skipped != 1 {
+		t.Errorf("%d tests, %d failures, %d skipped, want 5, 1 and 1:\n%s", suites.Tests, suites.Failures, suites.Skipped, data)
+	}
+	var names []string
+	for _, suite := range suites.Suites {
+		names = append(names, fmt.Sprintf("%s:%d", suite.Name, suite.Tests))
+	}
+	if got := strings.Join(names, ","); got != "build:2,deploy:1,test:2" {
+		t.Errorf("suites %s, want build:2,deploy:1,test:2:\n%s", got, data)
+	}
+
+	var failure *testCase
+	for _, suite := range suites.Suites {
+		for i, tc := range suite.Cases {
+			if tc.Failure != nil {
+				failure = &suite.Cases[i]
+			}
+		}
+	}
+	if failure == nil || failure.Failure.Text != "exit code 3" {
+		t.Errorf("failure %+v, want the failed step with exit code 3:\n%s", failure, data)
+	}
+}
+
+func TestRunPreAndPostStagesFirstAndLast(t *testing.T) {
+	dir := newRepo(t, map[string]string{
+		".gitlab-ci.yml": `
+stages: [build, test]
+notify:
+  stage: .post
+  script: [echo marker-notify]
+unit:
+  stage: test
+  script: [echo marker-unit]
+build:
+  stage: build
+  script: [echo marker-build]
+lint:
+  stage: .pre
+  script: [echo marker-lint]
+`,
+	})
+
+	var err error
+	out := captureStdout(t, func() {
+		err = runCLI(t, dir, "run", "-f", filepath.Join(dir, ".gitlab-ci.yml"))
+	})
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+
+	last := -1
+	for _, job := range []string{"lint", "build", "unit", "notify"} {
+		i := strings.Index(out, "\n  marker-"+job)
+		if i < 0 {
+			t.Fatalf("job %s did not run:\n%s", job, out)
+		}
+		if i < last {
+			t.Errorf("job %s ran out of order:\n%s", job, out)
+		}
+		last = i
+	}
+}
+
+func TestRunJSONOutputFailedJob(t *testing.T) {
+	dir := newRepo(t, map[string]string{
+		".gitlab-ci.yml": `
+stages: [build, deploy]
+build:
+  stage: build
+  script:
+    - echo building
+    - exit 7
+deploy:
+  stage: deploy
+  script: [echo deploy]
+`,
+	})
+
+	var err error
+	out := captureStdout(t, func() {
+		err = runCLI(t, dir, "run", "--output", "json", "-f", filepath.Join(dir, ".gitlab-ci.yml"))
+	})
+	if err == nil {
+		t.Fatal("run succeeded with a failing job")
+	}
+
+	var run types.PipelineRun
+	if err := json.Unmarshal([]byte(out), &run); err != nil {
+		t.Fatalf("stdout is not a JSON run: %v\n%s", err, out)
+	}
+	if run.Status != types.StatusFailed {
+		t.Errorf("run status %s, want failed", run.Status)
+	}
+
+	build := run.Jobs["build"]
+	if build == nil || build.Status != types.StatusFailed || build.ExitCode != 7 {
+		t.Fatalf("build job %+v, want failed with exit code 7", build)
+	}
+	if n := len(build.Steps); n != 2 || build.Steps[1].Status != types.StatusFailed || build.Steps[1].ExitCode != 7 {
+		t.Errorf("build steps %+v, want the second failed with exit code 7", build.Steps)
+	}
+	if deploy := run.Jobs["deploy"]; deploy == nil || deploy.Status != types.StatusSkipped {
+		t.Errorf("deploy job %+v, want skipped", deploy)
+	}
+}
+
+func TestRunComposeStack(t *testing.T) {
+	if testing.Short() {
+		t.Skip("the compose stack pulls images")
+	}
+	if err := exec.Command("docker", "info").Run(); err != nil {
+		t.Skipf("no Docker daemon: %v", err)
+	}
+	if exec.Command("docker", "compose", "version").Run() != nil {
+		if _, err := exec.LookPath("docker-compose"); err != nil {
+			t.Skip("no docker compose")
+		}
+	}
+
+	dir := newRepo(t, map[string]string{
+		"docker-compose.ci.yml": `
+services:
+  web:
+    image: nginx:alpine
+    healthcheck:
+      test: ["CMD", "wget", "-qO-", "http://localhost/"]
+      interval: 1s
+      retries: 30
+`,
+		".gitlab-ci.yml": `
+check:
+  image: curlimages/curl:8.8.0
+  script:
+    - curl -sf http://web/ | grep -q nginx
+`,
+	})
+
+	if err := runCLI(t, dir, "run", "--docker", "--compose", filepath.Join(dir, "docker-compose.ci.yml"),
+		"-f", filepath.Join(dir, ".gitlab-ci.yml")); err != nil {
+		t.Fatalf("job could not reach the compose service: %v", err)
+	}
+}
@@ -0,0 +1,320 @@
+package conformance
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sanix-darker/git-ci/internal/config"
+	"github.com/sanix-darker/git-ci/internal/parsers"
+	"github.com/sanix-darker/git-ci/internal/runners"
+	"github.com/sanix-darker/git-ci/pkg/types"
+)
+
+// update rewrites the golden files from the results of the bash runner
+var update = flag.Bool("update", false, "rewrite the expected results of the cases from the bash runner")
+
+// Files of a case directory
+const (
+	githubFile   = "workflow.yml"
+	gitlabFile   = ".gitlab-ci.yml"
+	expectedFile = "expected"
+	outputFile   = "conformance.out"
+	minuteFile   = "minute"
+)
+
+// runnerFactory creates the runner a case runs with
+type runnerFactory func(cfg *config.RunnerConfig) (types.Runner, error)
+
+func TestBash(t *testing.T) {
+	runCases(t, "bash", func(cfg *config.RunnerConfig) (types.Runner, error) {
+		return runners.NewBashRunner(cfg), nil
+	})
+}
+
+func TestDocker(t *testing.T) {
+	if testing.Short() {
+		t.Skip("the Docker runner pulls images")
+	}
+	if _, err := runners.NewDockerRunner(quietConfig()); err != nil {
+		t.Skipf("no Docker daemon: %v", err)
+	}
+	if *update {
+		t.Skip("golden files are written from the bash runner")
+	}
+
+	runCases(t, "docker", func(cfg *config.RunnerConfig) (types.Runner, error) {
+		return runners.NewDockerRunner(cfg)
+	})
+}
+
+// runCases runs every case of testdata with the runners of newRunner and
+// compares the results with the expected ones
+func runCases(t *testing.T, runner string, newRunner runnerFactory) {
+	cases, err := os.ReadDir("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range cases {
+		if !c.IsDir() {
+			continue
+		}
+		name := c.Name()
+		t.Run(name, func(t *testing.T) {
+			dir := filepath.Join("testdata", name)
+			got := runCase(t, dir, newRunner)
+
+			golden := filepath.Join(dir, expectedFile)
+			if *update {
+				if err := os.WriteFile(golden, []byte(got), 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("no expected results (go test ./conformance/... -run TestBash -update writes them): %v", err)
+			}
+			if diff := diffLines(string(want), got); diff != "" {
+				t.Errorf("the %s runner diverges from %s (- expected, + %s):\n%s", runner, golden, runner, diff)
+			}
+		})
+	}
+}
+
+// runCase runs each job of a case, by name, in a fresh copy of the case
+// directory and describes the results
+func runCase(t *testing.T, dir string, newRunner runnerFactory) string {
+	t.Helper()
+
+	pipeline, err := parseCase(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	minute, err := caseMinute(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := make([]string, 0, len(pipeline.Jobs))
+	for name := range pipeline.Jobs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var out strings.Builder
+	for _, name := range names {
+		workdir := t.TempDir()
+		if err := copyCase(dir, workdir); err != nil {
+			t.Fatal(err)
+		}
+
+		cfg := quietConfig()
+		cfg.WorkDir = workdir
+		cfg.Provider = pipeline.Provider
+		cfg.PipelineEnv = pipeline.Environment
+		cfg.Minute = minute
+
+		runner, err := newRunner(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		job := pipeline.Jobs[name]
+		if job.Name == "" {
+			job.Name = name
+		}
+		jobErr := runner.RunJob(job, workdir)
+		runner.Cleanup()
+
+		var steps []types.StepStatus
+		if reporter, ok := runner.(interface{ StepStatuses() []types.StepStatus }); ok {
+			steps = reporter.StepStatuses()
+		}
+		output, err := os.ReadFile(filepath.Join(workdir, outputFile))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			t.Fatal(err)
+		}
+		describeJob(&out, name, job, jobErr, steps, string(output))
+	}
+	return out.String()
+}
+
+// describeJob writes the outcome of a job, of its steps and its output
+// file, leaving out what legitimately differs between runners (times,
+// error texts)
+func describeJob(out *strings.Builder, name string, job *types.Job, err error, steps []types.StepStatus, output string) {
+	fmt.Fprintf(out, "job %s: %s\n", name, outcome(job, err))
+	for _, step := range steps {
+		status := string(step.Status)
+		if step.ExitCode != 0 {
+			status += fmt.Sprintf(" (exit %d)", step.ExitCode)
+		}
+		fmt.Fprintf(out, "  step %s: %s\n", step.Name, status)
+	}
+	for _, line := range strings.Split(strings.TrimRight(output, "\n"), "\n") {
+		if line != "" {
+			fmt.Fprintf(out, "  > %s\n", line)
+		}
+	}
+}
+
+// outcome describes how a job ended; a failure keeps the pipeline going
+// when the job or its exit code is allowed to fail
+func outcome(job *types.Job, err error) string {
+	if err == nil {
+		return "success"
+	}
+
+	var allowed *runners.AllowedFailureError
+	status := "failed"
+	if job.AllowFailure || job.ContinueOnErr || errors.As(err, &allowed) {
+		status = "allowed failure"
+	}
+	if code := runners.ExitCode(err); code != 0 {
+		status += fmt.Sprintf(" (exit %d)", code)
+	}
+	return status
+}
+
+// caseMinute returns how long a minute of the timeouts of a case lasts:
+// the duration its minute file holds, else a minute
+func caseMinute(dir string) (time.Duration, error) {
+	data, err := os.ReadFile(filepath.Join(dir, minuteFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	minute, err := time.ParseDuration(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", minuteFile, err)
+	}
+	return minute, nil
+}
+
+// parseCase parses the pipeline of a case directory
+func parseCase(dir string) (*types.Pipeline, error) {
+	if _, err := os.Stat(filepath.Join(dir, githubFile)); err == nil {
+		return parsers.NewGithubParser().Parse(filepath.Join(dir, githubFile))
+	}
+	p := parsers.NewGitlabParser()
+	p.SetNoCache(true)
+	return p.Parse(filepath.Join(dir, gitlabFile))
+}
+
+// quietConfig returns the runner configuration of the cases: no caches,
+// colors or heartbeats, nothing pulled twice
+func quietConfig() *config.RunnerConfig {
+	cfg := config.DefaultConfig()
+	cfg.Quiet = true
+	cfg.NoColor = true
+	cfg.NoCache = true
+	cfg.Cache = config.NewCachePolicy()
+	cfg.Cache.SetAll(false)
+	return cfg
+}
+
+// copyCase copies the files of a case directory, the expected results
+// left out, into dst
+func copyCase(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		switch {
+		case d.IsDir():
+			return os.MkdirAll(target, 0755)
+		case rel == expectedFile:
+			return nil
+		}
+
+		in, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		out, err := os.Create(target)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(out, in); err != nil {
+			out.Close()
+			return err
+		}
+		return out.Close()
+	})
+}
+
+// diffLines returns a line diff of want and got, "" when they are equal.
+// Lines only in want start with "-", lines only in got with "+".
+func diffLines(want, got string) string {
+	if want == got {
+		return ""
+	}
+	a := strings.Split(strings.TrimRight(want, "\n"), "\n")
+	b := strings.Split(strings.TrimRight(got, "\n"), "\n")
+
+	// lcs[i][j] is the longest common subsequence of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var out strings.Builder
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			fmt.Fprintf(&out, "  %s\n", a[i])
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			fmt.Fprintf(&out, "- %s\n", a[i])
+			i++
+		default:
+			fmt.Fprintf(&out, "+ %s\n", b[j])
+			j++
+		}
+	}
+	return out.String()
+}
+
+func TestDiffLines(t *testing.T) {
+	want := "job a: success\n  step one: success\n  > out\n"
+	got := "job a: failed (exit 1)\n  step one: failed (exit 1)\n  > out\n"
+
+	if diff := diffLines(want, want); diff != "" {
+		t.Errorf("equal results differ:\n%s", diff)
+	}
+	expected := "- job a: success\n-   step one: success\n+ job a: failed (exit 1)\n+   step one: failed (exit 1)\n    > out\n"
+	if diff := diffLines(want, got); diff != expected {
+		t.Errorf("got diff\n%s\nwant\n%s", diff, expected)
+	}
+}
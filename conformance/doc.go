@@ -0,0 +1,8 @@
+// Package conformance holds the runner conformance suite: small pipelines
+// run by the bash and Docker runners, whose job and step results and key
+// outputs must match the golden file of each case. Run it with
+//
+//	go test ./conformance/...
+//
+// README.md explains how to add a case.
+package conformance
@@ -0,0 +1,186 @@
+// Package actions knows the inputs of GitHub Actions, from an embedded
+// database of popular actions or from their action.yml, to lint the
+// `with:` keys of workflow steps without running them, and fetches the
+// actions the runners run
+package actions
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+//go:generate go run gen.go
+
+// database holds the inputs of popular actions at their major version,
+// generated by gen.go from actions.txt
+//
+//go:embed inputs.json
+var database []byte
+
+// Action describes the inputs an action declares in its action.yml
+type Action struct {
+	Inputs   []string `json:"inputs"`
+	Required []string `json:"required,omitempty"` // Required inputs without a default
+}
+
+// knownActions is the decoded database, keyed by owner/repo[/path]@vN
+var knownActions map[string]*Action
+
+func init() {
+	if err := json.Unmarshal(database, &knownActions); err != nil {
+		panic(fmt.Sprintf("invalid embedded action database: %v", err))
+	}
+}
+
+// SplitUses splits a `uses:` reference into the action path and its ref:
+// "actions/checkout@v4.1.1" gives "actions/checkout" and "v4.1.1". Local
+// (./) and docker:// actions have no ref.
+func SplitUses(uses string) (string, string, bool) {
+	if strings.HasPrefix(uses, "./") || strings.HasPrefix(uses, "docker://") {
+		return "", "", false
+	}
+	at := strings.LastIndex(uses, "@")
+	if at <= 0 || at == len(uses)-1 {
+		return "", "", false
+	}
+	return uses[:at], uses[at+1:], true
+}
+
+// majorVersion returns the major version of a tag ("v4.1.1" gives "v4"),
+// or "" for branches and commit SHAs
+func majorVersion(ref string) string {
+	if len(ref) < 2 || ref[0] != 'v' || ref[1] < '0' || ref[1] > '9' {
+		return ""
+	}
+	if dot := strings.Index(ref, "."); dot >= 0 {
+		return ref[:dot]
+	}
+	return ref
+}
+
+// Known returns the database entry of an action at the major version of
+// its ref
+func Known(uses string) (*Action, bool) {
+	path, ref, ok := SplitUses(uses)
+	if !ok {
+		return nil, false
+	}
+	major := majorVersion(ref)
+	if major == "" {
+		return nil, false
+	}
+	action, ok := knownActions[strings.ToLower(path)+"@"+major]
+	return action, ok
+}
+
+// ParseMetadata reads the inputs of an action from its action.yml
+func ParseMetadata(data []byte) (*Action, error) {
+	var metadata struct {
+		Inputs map[string]struct {
+			Required interface{} `yaml:"required"`
+			Default  interface{} `yaml:"default"`
+		} `yaml:"inputs"`
+	}
+	if err := yaml.Unmarshal(data, &metadata); err != nil {
+		return nil, fmt.Errorf("invalid action metadata: %w", err)
+	}
+
+	action := &Action{Inputs: []string{}}
+	for name, input := range metadata.Inputs {
+		action.Inputs = append(action.Inputs, name)
+		// `required` is a boolean, sometimes written as a string
+		required := fmt.Sprint(input.Required) == "true"
+		if required && input.Default == nil {
+			action.Required = append(action.Required, name)
+		}
+	}
+	sort.Strings(action.Inputs)
+	sort.Strings(action.Required)
+	return action, nil
+}
+
+// Check returns the problems of the `with:` keys given to the action:
+// unknown inputs, with the closest known one, and missing required
+// inputs. Input names are case-insensitive, as on GitHub.
+func (a *Action) Check(with map[string]string) []string {
+	known := make(map[string]bool, len(a.Inputs))
+	for _, input := range a.Inputs {
+		known[strings.ToLower(input)] = true
+	}
+	given := make(map[string]bool, len(with))
+	for key := range with {
+		given[strings.ToLower(key)] = true
+	}
+
+	var problems []string
+	for _, key := range sortedKeys(with) {
+		if known[strings.ToLower(key)] {
+			continue
+		}
+		if suggestion := a.suggest(key); suggestion != "" {
+			problems = append(problems, fmt.Sprintf("unknown input '%s' (did you mean '%s'?)", key, suggestion))
+		} else {
+			problems = append(problems, fmt.Sprintf("unknown input '%s'", key))
+		}
+	}
+	for _, input := range a.Required {
+		if !given[strings.ToLower(input)] {
+			problems = append(problems, fmt.Sprintf("missing required input '%s'", input))
+		}
+	}
+	return problems
+}
+
+// suggest returns the input closest to an unknown name, or "" when none
+// is close enough
+func (a *Action) suggest(name string) string {
+	name = strings.ToLower(name)
+
+	best, bestDistance := "", -1
+	for _, input := range a.Inputs {
+		lower := strings.ToLower(input)
+		distance := editDistance(name, lower)
+		// A name part of the input (depth, fetch-depth) is a likely miss
+		close := distance <= max(2, len(name)/3) ||
+			strings.Contains(lower, name) || strings.Contains(name, lower)
+		if close && (bestDistance < 0 || distance < bestDistance) {
+			best, bestDistance = input, distance
+		}
+	}
+	return best
+}
+
+// editDistance returns the Levenshtein distance between two strings
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
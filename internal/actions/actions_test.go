@@ -0,0 +1,52 @@
+package actions
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestCheckSuggestsInputs(t *testing.T) {
+	tests := []struct {
+		uses string
+		with map[string]string
+		want []string
+	}{
+		{
+			uses: "actions/checkout@v4.1.1",
+			with: map[string]string{"depth": "0"},
+			want: []string{"unknown input 'depth' (did you mean 'fetch-depth'?)"},
+		},
+		{
+			uses: "actions/checkout@v4",
+			with: map[string]string{"fetch-dpeth": "0", "Submodules": "true"},
+			want: []string{"unknown input 'fetch-dpeth' (did you mean 'fetch-depth'?)"},
+		},
+		{
+			uses: "actions/setup-node@v4",
+			with: map[string]string{"version": "20"},
+			want: []string{"unknown input 'version' (did you mean 'node-version'?)"},
+		},
+		{
+			uses: "actions/checkout@v4",
+			with: map[string]string{"fetch-depth": "0"},
+		},
+	}
+
+	for _, tt := range tests {
+		action, ok := Known(tt.uses)
+		if !ok {
+			t.Fatalf("%s not in the action database", tt.uses)
+		}
+		if got := action.Check(tt.with); !slices.Equal(got, tt.want) {
+			t.Errorf("%s with %v: %q, want %q", tt.uses, tt.with, got, tt.want)
+		}
+	}
+}
+
+func TestKnownNeedsAMajorVersion(t *testing.T) {
+	for _, uses := range []string{"actions/checkout@main", "actions/checkout@8e5e7e5", "./local", "docker://alpine"} {
+		if _, ok := Known(uses); ok {
+			t.Errorf("%s found in the database", uses)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package actions
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/sanix-darker/git-ci/pkg/types"
+	"gopkg.in/yaml.v3"
+)
+
+// githubURL is where the repositories of remote actions are cloned from
+var githubURL = "https://github.com"
+
+// commitRef matches a full commit SHA, the only ref that never moves
+var commitRef = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// checkouts locks the cached checkouts while they are fetched, and
+// records those of moving refs fetched again by this process
+var checkouts sync.Map // repoDir -> *checkout
+
+// checkout is a cached checkout of a repository at a ref
+type checkout struct {
+	mu        sync.Mutex
+	refreshed bool
+}
+
+// Metadata is the action.yml of an action, as far as running it goes
+type Metadata struct {
+	Name    string                    `yaml:"name"`
+	Inputs  map[string]InputMetadata  `yaml:"inputs"`
+	Outputs map[string]OutputMetadata `yaml:"outputs"`
+	Runs    RunsMetadata              `yaml:"runs"`
+}
+
+// InputMetadata is an input an action declares
+type InputMetadata struct {
+	Required interface{} `yaml:"required"`
+	Default  interface{} `yaml:"default"`
+}
+
+// DefaultValue returns the default of an input as a string, false when
+// it has none
+func (i InputMetadata) DefaultValue() (string, bool) {
+	if i.Default == nil {
+		return "", false
+	}
+	return fmt.Sprint(i.Default), true
+}
+
+// IsRequired reports whether an input is required; `required` is a
+// boolean, sometimes written as a string
+func (i InputMetadata) IsRequired() bool {
+	return fmt.Sprint(i.Required) == "true"
+}
+
+// OutputMetadata is an output an action declares; composite actions map
+// it to the outputs of their steps with value
+type OutputMetadata struct {
+	Value string `yaml:"value"`
+}
+
+// RunsMetadata tells how an action runs: using composite (steps), docker
+// (image, entrypoint, args and env) or node (main)
+type RunsMetadata struct {
+	Using      string            `yaml:"using"`
+	Main       string            `yaml:"main"`
+	Pre        string            `yaml:"pre"`
+	Post       string            `yaml:"post"`
+	Image      string            `yaml:"image"`
+	Entrypoint string            `yaml:"entrypoint"`
+	Args       []string          `yaml:"args"`
+	Env        map[string]string `yaml:"env"`
+	Steps      []types.Step      `yaml:"steps"`
+}
+
+// ReadMetadata reads the action.yml (or action.yaml) of the action in dir
+func ReadMetadata(dir string) (*Metadata, error) {
+	for _, name := range []string{"action.yml", "action.yaml"} {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", name, err)
+		}
+
+		var metadata Metadata
+		if err := yaml.Unmarshal(data, &metadata); err != nil {
+			return nil, fmt.Errorf("invalid action metadata: %w", err)
+		}
+		return &metadata, nil
+	}
+	return nil, fmt.Errorf("no action.yml found in %s", dir)
+}
+
+// Download returns the directory of a remote action, or the file of a
+// remote reusable workflow, its repository being checked out at the ref
+// of uses under cacheDir. A checkout at a commit SHA is kept for good;
+// branches and tags may move, so their checkout is fetched again once per
+// process, the cached one being used when that fails. In offline mode the
+// cached checkout is the only source.
+func Download(uses, cacheDir string, offline bool) (string, error) {
+	path, ref, ok := SplitUses(uses)
+	if !ok {
+		return "", fmt.Errorf("'%s' is not a remote action", uses)
+	}
+	parts := strings.SplitN(path, "/", 3)
+	if len(parts) < 2 {
+		return "", fmt.Errorf("invalid action '%s', expected owner/repo[/path]", path)
+	}
+
+	repoDir := filepath.Join(cacheDir, parts[0], parts[1]+"@"+strings.ReplaceAll(ref, "/", "-"))
+	actionDir := repoDir
+	if len(parts) == 3 {
+		actionDir = filepath.Join(repoDir, filepath.FromSlash(parts[2]))
+	}
+
+	value, _ := checkouts.LoadOrStore(repoDir, &checkout{})
+	co := value.(*checkout)
+	co.mu.Lock()
+	defer co.mu.Unlock()
+
+	_, err := os.Stat(repoDir)
+	cached := err == nil
+	if cached && (offline || co.refreshed || commitRef.MatchString(ref)) {
+		return actionDir, nil
+	}
+	if offline {
+		return "", fmt.Errorf("%s is not cached; run once without --offline to fetch it", uses)
+	}
+
+	if err := fetchCheckout(uses, githubURL+"/"+parts[0]+"/"+parts[1], ref, repoDir); err != nil {
+		if !cached {
+			return "", err
+		}
+		fmt.Fprintf(os.Stderr, "Warning: using the cached checkout: %v\n", err)
+	}
+	co.refreshed = true
+	return actionDir, nil
+}
+
+// fetchCheckout checks the repository at url out at ref into repoDir,
+// replacing the checkout there
+func fetchCheckout(uses, url, ref, repoDir string) error {
+	// Checked out aside, then moved in place once complete
+	if err := os.MkdirAll(filepath.Dir(repoDir), 0755); err != nil {
+		return fmt.Errorf("failed to create the action cache: %w", err)
+	}
+	tmp, err := os.MkdirTemp(filepath.Dir(repoDir), ".download-")
+	if err != nil {
+		return fmt.Errorf("failed to create the action cache: %w", err)
+	}
+	defer os.RemoveAll(tmp)
+
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"fetch", "-q", "--depth", "1", url, ref},
+		{"checkout", "-q", "FETCH_HEAD"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = tmp
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to fetch %s: %s", uses, strings.TrimSpace(string(out)))
+		}
+	}
+	if err := os.RemoveAll(filepath.Join(tmp, ".git")); err != nil {
+		return fmt.Errorf("failed to fetch %s: %w", uses, err)
+	}
+
+	// The previous checkout is moved aside first: a directory only
+	// replaces an empty one
+	if _, err := os.Stat(repoDir); err == nil {
+		old, err := os.MkdirTemp(filepath.Dir(repoDir), ".old-")
+		if err != nil {
+			return fmt.Errorf("failed to cache %s: %w", uses, err)
+		}
+		defer os.RemoveAll(old)
+		if err := os.Rename(repoDir, filepath.Join(old, "checkout")); err != nil {
+			return fmt.Errorf("failed to cache %s: %w", uses, err)
+		}
+	}
+
+	// Another run may have cached it meanwhile
+	if err := os.Rename(tmp, repoDir); err != nil {
+		if _, statErr := os.Stat(repoDir); statErr != nil {
+			return fmt.Errorf("failed to cache %s: %w", uses, err)
+		}
+	}
+	return nil
+}
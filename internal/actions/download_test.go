@@ -0,0 +1,148 @@
+package actions
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// git runs git in dir
+func git(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+		"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com",
+	)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// actionRepo creates the repository owner/action served in place of
+// GitHub, with an action.yml named version on main, and returns a
+// function committing a new version
+func actionRepo(t *testing.T) (repo string, commit func(version string) string) {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	server := t.TempDir()
+	url := githubURL
+	githubURL = server
+	t.Cleanup(func() {
+		githubURL = url
+		checkouts.Clear()
+	})
+
+	repo = filepath.Join(server, "owner", "action")
+	if err := os.MkdirAll(repo, 0755); err != nil {
+		t.Fatal(err)
+	}
+	git(t, repo, "init", "-q", "-b", "main")
+	git(t, repo, "config", "uploadpack.allowAnySHA1InWant", "true")
+
+	commit = func(version string) string {
+		if err := os.WriteFile(filepath.Join(repo, "action.yml"), []byte("name: "+version+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		git(t, repo, "add", "action.yml")
+		git(t, repo, "commit", "-q", "-m", version)
+		return git(t, repo, "rev-parse", "HEAD")
+	}
+	return repo, commit
+}
+
+// downloaded returns the name of the action downloaded for uses
+func downloaded(t *testing.T, uses, cacheDir string, offline bool) string {
+	t.Helper()
+	dir, err := Download(uses, cacheDir, offline)
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadata, err := ReadMetadata(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return metadata.Name
+}
+
+func TestDownloadRefreshesMovingRefs(t *testing.T) {
+	_, commit := actionRepo(t)
+	cache := t.TempDir()
+
+	commit("v1")
+	if got := downloaded(t, "owner/action@main", cache, false); got != "v1" {
+		t.Fatalf("got %s, want v1", got)
+	}
+
+	// Fetched once per process
+	commit("v2")
+	if got := downloaded(t, "owner/action@main", cache, false); got != "v1" {
+		t.Errorf("fetched again in the same process: got %s", got)
+	}
+	if got := downloaded(t, "owner/action@main", cache, true); got != "v1" {
+		t.Errorf("offline: got %s, want the cached v1", got)
+	}
+
+	// A later run sees where the branch moved
+	checkouts.Clear()
+	if got := downloaded(t, "owner/action@main", cache, false); got != "v2" {
+		t.Errorf("a moved branch kept its old checkout: got %s, want v2", got)
+	}
+	// Offline, the cached checkout is the only source
+	checkouts.Clear()
+	if got := downloaded(t, "owner/action@main", cache, true); got != "v2" {
+		t.Errorf("offline: got %s, want the cached v2", got)
+	}
+}
+
+func TestDownloadKeepsCommits(t *testing.T) {
+	repo, commit := actionRepo(t)
+	cache := t.TempDir()
+
+	sha := commit("v1")
+	uses := "owner/action@" + sha
+	if got := downloaded(t, uses, cache, false); got != "v1" {
+		t.Fatalf("got %s, want v1", got)
+	}
+
+	// A commit never moves: its checkout serves without the repository
+	checkouts.Clear()
+	if err := os.RemoveAll(repo); err != nil {
+		t.Fatal(err)
+	}
+	if got := downloaded(t, uses, cache, false); got != "v1" {
+		t.Errorf("got %s, want the cached v1", got)
+	}
+}
+
+func TestDownloadFallsBackToTheCache(t *testing.T) {
+	repo, commit := actionRepo(t)
+	cache := t.TempDir()
+
+	commit("v1")
+	if got := downloaded(t, "owner/action@main", cache, false); got != "v1" {
+		t.Fatalf("got %s, want v1", got)
+	}
+
+	// The refresh fails, the cached checkout serves
+	checkouts.Clear()
+	if err := os.RemoveAll(repo); err != nil {
+		t.Fatal(err)
+	}
+	if got := downloaded(t, "owner/action@main", cache, false); got != "v1" {
+		t.Errorf("got %s, want the cached v1", got)
+	}
+
+	// Nothing cached, the failure stands
+	if _, err := Download("owner/action@v2", cache, false); err == nil {
+		t.Error("an unreachable action without checkout downloaded")
+	}
+}
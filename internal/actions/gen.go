@@ -0,0 +1,54 @@
+//go:build ignore
+
+// gen rebuilds inputs.json from the action.yml of each action listed in
+// actions.txt, at its major version tag. Run it with `make actions-db`.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/sanix-darker/git-ci/internal/actions"
+)
+
+func main() {
+	list, err := os.Open("actions.txt")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer list.Close()
+
+	database := make(map[string]*actions.Action)
+	scanner := bufio.NewScanner(list)
+	for scanner.Scan() {
+		uses := strings.TrimSpace(scanner.Text())
+		if uses == "" || strings.HasPrefix(uses, "#") {
+			continue
+		}
+
+		data, err := actions.Fetch(nil, uses)
+		if err != nil {
+			log.Fatal(err)
+		}
+		action, err := actions.ParseMetadata(data)
+		if err != nil {
+			log.Fatalf("%s: %v", uses, err)
+		}
+		database[strings.ToLower(uses)] = action
+		log.Printf("%s: %d input(s)", uses, len(action.Inputs))
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatal(err)
+	}
+
+	data, err := json.MarshalIndent(database, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile("inputs.json", append(data, '\n'), 0644); err != nil {
+		log.Fatal(err)
+	}
+}
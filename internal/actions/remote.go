@@ -0,0 +1,102 @@
+package actions
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// rawBaseURL serves the files of GitHub repositories at a ref
+const rawBaseURL = "https://raw.githubusercontent.com"
+
+// metadataURLs returns where the action.yml of an action may be found
+func metadataURLs(path, ref string) ([]string, error) {
+	parts := strings.SplitN(path, "/", 3)
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("invalid action '%s', expected owner/repo[/path]", path)
+	}
+	dir := rawBaseURL + "/" + parts[0] + "/" + parts[1] + "/" + ref
+	if len(parts) == 3 {
+		dir += "/" + parts[2]
+	}
+	return []string{dir + "/action.yml", dir + "/action.yaml"}, nil
+}
+
+// Fetch downloads the action.yml of an action at the exact ref of uses
+func Fetch(client *http.Client, uses string) ([]byte, error) {
+	path, ref, ok := SplitUses(uses)
+	if !ok {
+		return nil, fmt.Errorf("'%s' is not a remote action", uses)
+	}
+	urls, err := metadataURLs(path, ref)
+	if err != nil {
+		return nil, err
+	}
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+
+	for _, url := range urls {
+		resp, err := client.Get(url)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch metadata of %s: %w", uses, err)
+		}
+		data, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound {
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("failed to fetch metadata of %s: %s", uses, resp.Status)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read metadata of %s: %w", uses, err)
+		}
+		return data, nil
+	}
+	return nil, fmt.Errorf("no action.yml found for %s", uses)
+}
+
+// Resolve returns the inputs of an action at the exact ref of uses. A
+// copy of its action.yml is kept in cacheDir (none when empty) for
+// offline mode, where it is the only source.
+func Resolve(uses, cacheDir string, offline bool) (*Action, error) {
+	var copyPath string
+	if cacheDir != "" {
+		sum := sha256.Sum256([]byte(uses))
+		copyPath = filepath.Join(cacheDir, hex.EncodeToString(sum[:])+".yml")
+	}
+
+	if offline {
+		if copyPath == "" {
+			return nil, fmt.Errorf("metadata of %s is not available offline (metadata cache disabled)", uses)
+		}
+		data, err := os.ReadFile(copyPath)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil, fmt.Errorf("metadata of %s is not cached; run once without --offline to fetch it", uses)
+			}
+			return nil, fmt.Errorf("failed to read cached metadata of %s: %w", uses, err)
+		}
+		return ParseMetadata(data)
+	}
+
+	data, err := Fetch(nil, uses)
+	if err != nil {
+		return nil, err
+	}
+	if copyPath != "" {
+		if err := os.MkdirAll(cacheDir, 0755); err == nil {
+			_ = os.WriteFile(copyPath, data, 0644)
+		}
+	}
+	return ParseMetadata(data)
+}
@@ -0,0 +1,143 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// CacheKind identifies a category of cache used during a run
+type CacheKind string
+
+const (
+	CacheKindJob      CacheKind = "job"      // actions/cache, GitLab cache:
+	CacheKindParse    CacheKind = "parse"    // Parsed workflow and include files
+	CacheKindMetadata CacheKind = "metadata" // Action metadata (action.yml)
+	CacheKindImage    CacheKind = "image"    // Locally available container images
+)
+
+// AllCacheKinds lists every known cache kind
+var AllCacheKinds = []CacheKind{
+	CacheKindJob,
+	CacheKindParse,
+	CacheKindMetadata,
+	CacheKindImage,
+}
+
+// Values accepted by --no-cache
+const (
+	NoCacheOff  = ""
+	NoCacheJobs = "jobs" // --no-cache: job-level caches only
+	NoCacheAll  = "all"  // --no-cache=all: every cache kind
+)
+
+// CachePolicy decides which caches may be read from or written to
+type CachePolicy struct {
+	disabled map[CacheKind]bool
+}
+
+// NewCachePolicy returns a policy with every cache enabled
+func NewCachePolicy() *CachePolicy {
+	return &CachePolicy{
+		disabled: make(map[CacheKind]bool),
+	}
+}
+
+// Enabled reports whether the given cache kind may be used.
+// A nil policy enables everything.
+func (p *CachePolicy) Enabled(kind CacheKind) bool {
+	if p == nil {
+		return true
+	}
+	return !p.disabled[kind]
+}
+
+// Set enables or disables a single cache kind
+func (p *CachePolicy) Set(kind CacheKind, enabled bool) {
+	if enabled {
+		delete(p.disabled, kind)
+	} else {
+		p.disabled[kind] = true
+	}
+}
+
+// SetAll enables or disables every cache kind
+func (p *CachePolicy) SetAll(enabled bool) {
+	for _, kind := range AllCacheKinds {
+		p.Set(kind, enabled)
+	}
+}
+
+// ApplyNoCache applies a --no-cache mode on top of the policy
+func (p *CachePolicy) ApplyNoCache(mode string) {
+	switch mode {
+	case NoCacheJobs:
+		p.Set(CacheKindJob, false)
+	case NoCacheAll:
+		p.SetAll(false)
+	}
+}
+
+// Disabled returns the sorted list of disabled cache kinds
+func (p *CachePolicy) Disabled() []string {
+	if p == nil {
+		return nil
+	}
+	var kinds []string
+	for kind, off := range p.disabled {
+		if off {
+			kinds = append(kinds, string(kind))
+		}
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
+// NoCacheFlag is the value behind the --no-cache flag. It behaves like a
+// boolean flag (--no-cache) but also accepts --no-cache=all.
+type NoCacheFlag struct {
+	Mode string
+
+	// invalid is the last value Set refused, reported by Err: the flag
+	// package would wrap an error of Set in one of its own, as for a
+	// boolean
+	invalid string
+}
+
+// Set implements flag.Value
+func (f *NoCacheFlag) Set(value string) error {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "true", "1", "job", "jobs":
+		f.Mode = NoCacheJobs
+	case "", "false", "0":
+		f.Mode = NoCacheOff
+	case "all":
+		f.Mode = NoCacheAll
+	default:
+		f.invalid = value
+		return nil
+	}
+	f.invalid = ""
+	return nil
+}
+
+// Err returns the error of an invalid value of the flag, nil otherwise
+func (f *NoCacheFlag) Err() error {
+	if f == nil || f.invalid == "" {
+		return nil
+	}
+	return fmt.Errorf("invalid --no-cache value %q (expected true, false or all)", f.invalid)
+}
+
+// String implements flag.Value
+func (f *NoCacheFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	return f.Mode
+}
+
+// IsBoolFlag lets --no-cache be given without a value
+func (f *NoCacheFlag) IsBoolFlag() bool {
+	return true
+}
@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"path/filepath"
+	"time"
 )
 
 // RunnerConfig holds configuration for job runners
@@ -10,12 +11,43 @@ type RunnerConfig struct {
 	DryRun      bool              // Show what would be executed without running
 	Verbose     bool              // Enable verbose output
 	PullImages  bool              // Pull Docker images before running
-	NoCache     bool              // Disable caching
+	NoCache     bool              // Disable caching (see Cache for the per-kind policy)
+	Cache       *CachePolicy      // Which caches may be read/written
 	WorkDir     string            // Working directory for execution
 	Environment map[string]string // Additional environment variables
 	Timeout     int               // Timeout in minutes (0 = no timeout)
-	//Volumes     []string          // Docker volumes to mount
-	//Network     string            // Docker network mode
+	Quiet       bool              // Suppress non-essential output
+	Parallel    bool              // Jobs may run side by side, sharing the terminal
+	Heartbeat   time.Duration     // Report steps silent for this long (0 = disabled)
+	PinImages   map[string]string // Image references (image@sha256:...) replacing each job's image
+	Theme       string            // Output theme name ("" = default)
+	ASCII       bool              // Plain ASCII status symbols instead of Unicode glyphs
+	NoColor     bool              // No ANSI colors in the output
+	YAMLAnchors bool              // Share the YAML anchors of GitLab local includes (--merge-yaml-anchors)
+	Strict      bool              // Report unknown GitLab keys and invalid keyword values (validate --strict)
+	Offline     bool              // Resolve remote includes from the on-disk cache only
+	NoFetch     bool              // Inline only the cached remote reusable workflows, fetching none (list, validate, graph)
+	Event       string            // Simulated pipeline source for rules (CI_PIPELINE_SOURCE)
+	MRTarget    string            // Target branch of a simulated merge request pipeline (--mr), "" otherwise
+	Changes     []string          // Files changed by the pipeline for `changes:` rules, nil when unknown
+	Network     string            // Docker network job containers join (--network, --compose stack)
+	Provider    string            // Pipeline provider, deciding the variable reference syntax
+	PipelineEnv map[string]string // Pipeline-level variables, below the job ones
+	RunID       string            // Run the containers are labelled with, for stale cleanup
+	NoWorkspace bool              // Jobs run in the checkout itself, not in a copy of their own (--no-workspace)
+	SSHHost     string            // Remote host jobs run on ([user@]host[:port], --ssh)
+	SSHKey      string            // Private key for the SSH runner ("" = agent and default keys)
+	SSHInsecure bool              // Skip the known_hosts check of the SSH host
+	SecretsFile string            // Local values of the job secrets (--secrets-file)
+	Secrets     []string          // Resolved secret values, masked in the output
+	Volumes     []string          // Bind mounts (src:dst[:ro]) of the job containers (--volume, docker.volumes)
+	Minute      time.Duration     // How long a minute of timeout lasts (0 = a minute), shortened by the conformance suite
+
+	// Registry credentials of the configuration file (docker.auth:
+	// username, password, identity_token, registry_token), for the
+	// docker.registry host or, when unset, any registry
+	Registry     string
+	RegistryAuth map[string]string
 }
 
 // DefaultConfig returns a RunnerConfig with sensible defaults
@@ -27,12 +59,38 @@ func DefaultConfig() *RunnerConfig {
 		Verbose:     false, // maybe should be false... willl see
 		PullImages:  true,
 		NoCache:     false,
+		Cache:       NewCachePolicy(),
 		WorkDir:     workDir,
 		Environment: make(map[string]string),
 		Timeout:     30, // 30 minutes default timeout
-		//Volumes:     []string{},
-		//Network:     "",
+		Heartbeat:   30 * time.Second,
+	}
+}
+
+// CacheEnabled reports whether the given cache kind may be used for this run.
+// Every cache consumer should go through this helper.
+func (c *RunnerConfig) CacheEnabled(kind CacheKind) bool {
+	if c == nil {
+		return true
+	}
+	return c.Cache.Enabled(kind)
+}
+
+// HeartbeatInterval returns the effective heartbeat interval, taking quiet
+// mode into account
+func (c *RunnerConfig) HeartbeatInterval() time.Duration {
+	if c == nil || c.Quiet {
+		return 0
+	}
+	return c.Heartbeat
+}
+
+// Minutes returns the duration of a timeout of n minutes
+func (c *RunnerConfig) Minutes(n int) time.Duration {
+	if c == nil || c.Minute <= 0 {
+		return time.Duration(n) * time.Minute
 	}
+	return time.Duration(n) * c.Minute
 }
 
 // GetCacheDir returns the cache directory for git-ci
@@ -0,0 +1,283 @@
+// Package convert writes a parsed pipeline back as the configuration of
+// another provider (GitHub Actions or GitLab CI). Features the target
+// can't represent are dropped with a warning.
+package convert
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/sanix-darker/git-ci/pkg/types"
+	"gopkg.in/yaml.v3"
+)
+
+// Targets are the providers a pipeline can be converted to
+var Targets = []string{"github", "gitlab"}
+
+// Convert returns the pipeline as the YAML configuration of the target
+// provider, and warnings about what could not be converted
+func Convert(pipeline *types.Pipeline, target string) ([]byte, []string, error) {
+	c := &converter{
+		pipeline: pipeline,
+		warned:   make(map[string]bool),
+		upstream: make(map[string][]string),
+	}
+
+	var root *yamlMap
+	switch target {
+	case "github":
+		root = c.toGithub()
+	case "gitlab":
+		root = c.toGitlab()
+	default:
+		return nil, nil, fmt.Errorf("unknown target '%s' (%s)", target, strings.Join(Targets, ", "))
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(root.node); err != nil {
+		return nil, nil, fmt.Errorf("failed to write %s configuration: %w", target, err)
+	}
+	if err := enc.Close(); err != nil {
+		return nil, nil, fmt.Errorf("failed to write %s configuration: %w", target, err)
+	}
+	return buf.Bytes(), c.warnings, nil
+}
+
+// converter holds the state of one conversion
+type converter struct {
+	pipeline *types.Pipeline
+	warnings []string
+	warned   map[string]bool
+	upstream map[string][]string // upstreamJobs of the jobs, once computed
+}
+
+// warn records a warning, once
+func (c *converter) warn(format string, args ...interface{}) {
+	warning := fmt.Sprintf(format, args...)
+	if !c.warned[warning] {
+		c.warned[warning] = true
+		c.warnings = append(c.warnings, warning)
+	}
+}
+
+// orderedJobs returns the job keys in run order: by stage on GitLab, by
+// depth in the needs graph otherwise, then by name
+func (c *converter) orderedJobs() []string {
+	names := make([]string, 0, len(c.pipeline.Jobs))
+	for name := range c.pipeline.Jobs {
+		names = append(names, name)
+	}
+
+	rank := make(map[string]int, len(names))
+	if c.pipeline.Provider == "gitlab" {
+		stages := make(map[string]int, len(c.pipeline.Stages))
+		for i, stage := range c.pipeline.Stages {
+			stages[stage] = i
+		}
+		for _, name := range names {
+			rank[name] = stages[c.pipeline.Jobs[name].Stage]
+		}
+	} else {
+		for name, level := range c.levels() {
+			rank[name] = level
+		}
+	}
+
+	sort.Slice(names, func(i, j int) bool {
+		if rank[names[i]] != rank[names[j]] {
+			return rank[names[i]] < rank[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	return names
+}
+
+// levels returns the depth of each job in the needs graph: 0 for jobs
+// needing none, one more than their deepest need otherwise
+func (c *converter) levels() map[string]int {
+	levels := make(map[string]int, len(c.pipeline.Jobs))
+	visiting := make(map[string]bool)
+
+	var level func(name string) int
+	level = func(name string) int {
+		if l, ok := levels[name]; ok {
+			return l
+		}
+		job, ok := c.pipeline.Jobs[name]
+		if !ok || visiting[name] {
+			return -1
+		}
+		visiting[name] = true
+		l := 0
+		for _, need := range job.NeedNames() {
+			l = max(l, level(need)+1)
+		}
+		visiting[name] = false
+		levels[name] = l
+		return l
+	}
+
+	for name := range c.pipeline.Jobs {
+		level(name)
+	}
+	return levels
+}
+
+// upstreamJobs returns the jobs a job waits for: its needs, or on GitLab
+// without needs the jobs of the previous stage having jobs, and those of
+// earlier stages they don't wait for themselves
+func (c *converter) upstreamJobs(name string) []string {
+	if upstream, ok := c.upstream[name]; ok {
+		return upstream
+	}
+	upstream := c.findUpstreamJobs(name)
+	c.upstream[name] = upstream
+	return upstream
+}
+
+func (c *converter) findUpstreamJobs(name string) []string {
+	job := c.pipeline.Jobs[name]
+	if job.HasNeeds() || c.pipeline.Provider != "gitlab" {
+		var needs []string
+		for _, need := range job.NeedNames() {
+			if _, exists := c.pipeline.Jobs[need]; exists {
+				needs = append(needs, need)
+			}
+		}
+		return needs
+	}
+
+	byStage := make(map[string][]string)
+	for _, other := range c.orderedJobs() {
+		stage := c.pipeline.Jobs[other].Stage
+		byStage[stage] = append(byStage[stage], other)
+	}
+	var earlier, previous []string
+	for _, stage := range c.pipeline.Stages {
+		if stage == job.Stage {
+			break
+		}
+		if len(byStage[stage]) > 0 {
+			earlier = append(earlier, previous...)
+			previous = byStage[stage]
+		}
+	}
+	if len(previous) == 0 || !slices.Contains(c.pipeline.Stages, job.Stage) {
+		return nil
+	}
+
+	// A job needing only some jobs of the earlier stages lets the others
+	// run on, where GitLab waits for all of them
+	upstream := append([]string{}, previous...)
+	waited := c.waitedFor(previous)
+	for i := len(earlier) - 1; i >= 0; i-- {
+		if !waited[earlier[i]] {
+			upstream = append(upstream, earlier[i])
+			for other := range c.waitedFor(earlier[i : i+1]) {
+				waited[other] = true
+			}
+		}
+	}
+	return upstream
+}
+
+// waitedFor returns the jobs the given jobs wait for, directly or not,
+// them included
+func (c *converter) waitedFor(names []string) map[string]bool {
+	waited := make(map[string]bool)
+	pending := append([]string{}, names...)
+	for len(pending) > 0 {
+		name := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if waited[name] {
+			continue
+		}
+		waited[name] = true
+		pending = append(pending, c.upstreamJobs(name)...)
+	}
+	return waited
+}
+
+// stepLines returns the shell lines of a step
+func stepLines(step types.Step) []string {
+	if len(step.Script) > 0 {
+		return step.Script
+	}
+	if run := strings.TrimRight(step.Run, "\n"); run != "" {
+		return []string{run}
+	}
+	if step.Command != "" {
+		return []string{strings.TrimRight(step.Command, "\n")}
+	}
+	return nil
+}
+
+// actionName returns the action of a `uses:` reference, without its
+// version: actions/checkout@v4 is actions/checkout
+func actionName(uses string) string {
+	name, _, _ := strings.Cut(uses, "@")
+	return strings.ToLower(name)
+}
+
+// yamlMap builds a YAML mapping keeping the order its keys are set in
+type yamlMap struct {
+	node *yaml.Node
+}
+
+// newMap returns an empty mapping
+func newMap() *yamlMap {
+	return &yamlMap{node: &yaml.Node{Kind: yaml.MappingNode}}
+}
+
+// set adds a key to the mapping, unless its value is empty (zero, empty
+// string, list or map)
+func (m *yamlMap) set(key string, value interface{}) {
+	var node *yaml.Node
+	switch v := value.(type) {
+	case *yamlMap:
+		if v == nil || len(v.node.Content) == 0 {
+			return
+		}
+		node = v.node
+	case *yaml.Node:
+		node = v
+	case []*yamlMap:
+		if len(v) == 0 {
+			return
+		}
+		node = &yaml.Node{Kind: yaml.SequenceNode}
+		for _, item := range v {
+			node.Content = append(node.Content, item.node)
+		}
+	default:
+		rv := reflect.ValueOf(value)
+		if !rv.IsValid() || rv.IsZero() || ((rv.Kind() == reflect.Slice || rv.Kind() == reflect.Map) && rv.Len() == 0) {
+			return
+		}
+		node = &yaml.Node{}
+		if err := node.Encode(value); err != nil {
+			return
+		}
+	}
+
+	m.node.Content = append(m.node.Content,
+		&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key},
+		node,
+	)
+}
+
+// len returns the number of keys of the mapping
+func (m *yamlMap) len() int {
+	return len(m.node.Content) / 2
+}
+
+// falseNode returns a false value, which set would leave out
+func falseNode() *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: "false"}
+}
@@ -0,0 +1,190 @@
+package convert
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/sanix-darker/git-ci/internal/parsers"
+	"github.com/sanix-darker/git-ci/pkg/types"
+)
+
+// parse parses a configuration of the provider
+func parse(t *testing.T, provider string, content []byte) *types.Pipeline {
+	t.Helper()
+
+	var pipeline *types.Pipeline
+	var err error
+	switch provider {
+	case "gitlab":
+		p := parsers.NewGitlabParser()
+		p.SetFS(fstest.MapFS{".gitlab-ci.yml": &fstest.MapFile{Data: content}})
+		pipeline, err = p.Parse(".gitlab-ci.yml")
+	case "github":
+		path := filepath.Join(t.TempDir(), "ci.yml")
+		if err := os.WriteFile(path, content, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		pipeline, err = parsers.NewGithubParser().Parse(path)
+	}
+	if err != nil {
+		t.Fatalf("failed to parse %s configuration: %v\n%s", provider, err, content)
+	}
+	return pipeline
+}
+
+// summary is what a job does and waits for, in terms both providers share
+type summary struct {
+	image    string
+	commands string
+	waits    string // Jobs waited for, directly or not, sorted
+}
+
+// summarize returns the summary of each job of a pipeline. GitLab jobs
+// without needs wait for all the jobs of earlier stages.
+func summarize(pipeline *types.Pipeline) map[string]summary {
+	stage := make(map[string]int, len(pipeline.Stages))
+	for i, s := range pipeline.Stages {
+		stage[s] = i
+	}
+	upstream := func(name string) []string {
+		job := pipeline.Jobs[name]
+		if job.HasNeeds() || pipeline.Provider != "gitlab" {
+			return job.NeedNames()
+		}
+		var earlier []string
+		for other, o := range pipeline.Jobs {
+			if stage[o.Stage] < stage[job.Stage] {
+				earlier = append(earlier, other)
+			}
+		}
+		return earlier
+	}
+
+	summaries := make(map[string]summary, len(pipeline.Jobs))
+	for name, job := range pipeline.Jobs {
+		waited := map[string]bool{}
+		pending := upstream(name)
+		for len(pending) > 0 {
+			next := pending[0]
+			pending = pending[1:]
+			if !waited[next] {
+				waited[next] = true
+				pending = append(pending, upstream(next)...)
+			}
+		}
+		var waits []string
+		for other := range waited {
+			waits = append(waits, other)
+		}
+		sort.Strings(waits)
+
+		var commands []string
+		for _, step := range job.Steps {
+			if step.Uses == "" {
+				commands = append(commands, step.Run)
+			}
+		}
+
+		image := job.Image
+		if job.Container != nil {
+			image = job.Container.Image
+		}
+		summaries[name] = summary{
+			image:    image,
+			commands: strings.Join(commands, "\n"),
+			waits:    strings.Join(waits, ","),
+		}
+	}
+	return summaries
+}
+
+func TestConvertRoundTrip(t *testing.T) {
+	tests := []struct {
+		from, to string
+		config   string
+	}{
+		{"gitlab", "github", `
+stages: [build, test, deploy]
+image: golang:1.22
+build:
+  stage: build
+  script: [go build ./...]
+lint:
+  stage: build
+  image: golangci/golangci-lint:v1.59
+  script: [golangci-lint run]
+unit:
+  stage: test
+  needs: [build]
+  script: [go test ./..., go vet ./...]
+deploy:
+  stage: deploy
+  script: [echo deploy]
+`},
+		{"github", "gitlab", `
+name: CI
+on: push
+jobs:
+  build:
+    runs-on: ubuntu-latest
+    container: node:20
+    steps:
+      - uses: actions/checkout@v4
+      - run: npm ci
+      - run: npm run build
+  test:
+    runs-on: ubuntu-latest
+    container: node:20
+    needs: build
+    steps:
+      - run: npm test
+  e2e:
+    runs-on: ubuntu-latest
+    container: mcr.microsoft.com/playwright:v1.45.0
+    needs: build
+    steps:
+      - run: npx playwright test
+  release:
+    runs-on: ubuntu-latest
+    container: node:20
+    needs: [test, e2e]
+    steps:
+      - run: npm publish
+`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.from+" to "+tt.to, func(t *testing.T) {
+			original := parse(t, tt.from, []byte(tt.config))
+			out, _, err := Convert(original, tt.to)
+			if err != nil {
+				t.Fatal(err)
+			}
+			converted := parse(t, tt.to, out)
+
+			want, got := summarize(original), summarize(converted)
+			var wantNames, gotNames []string
+			for name := range want {
+				wantNames = append(wantNames, name)
+			}
+			for name := range got {
+				gotNames = append(gotNames, name)
+			}
+			sort.Strings(wantNames)
+			sort.Strings(gotNames)
+			if !slices.Equal(gotNames, wantNames) {
+				t.Fatalf("jobs %v, want %v:\n%s", gotNames, wantNames, out)
+			}
+			for _, name := range wantNames {
+				if got[name] != want[name] {
+					t.Errorf("job %s: %+v, want %+v:\n%s", name, got[name], want[name], out)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,549 @@
+package convert
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/sanix-darker/git-ci/pkg/types"
+)
+
+// githubVariables maps the predefined GitLab variables to the ones GitHub
+// runners set
+var githubVariables = map[string]string{
+	"CI_COMMIT_SHA":      "GITHUB_SHA",
+	"CI_COMMIT_REF_NAME": "GITHUB_REF_NAME",
+	"CI_COMMIT_BRANCH":   "GITHUB_REF_NAME",
+	"CI_COMMIT_TAG":      "GITHUB_REF_NAME",
+	"CI_PROJECT_DIR":     "GITHUB_WORKSPACE",
+	"CI_PROJECT_PATH":    "GITHUB_REPOSITORY",
+	"CI_JOB_NAME":        "GITHUB_JOB",
+	"CI_PIPELINE_ID":     "GITHUB_RUN_ID",
+	"CI_PIPELINE_IID":    "GITHUB_RUN_NUMBER",
+	"CI_PIPELINE_SOURCE": "GITHUB_EVENT_NAME",
+	"CI_SERVER_URL":      "GITHUB_SERVER_URL",
+	"GITLAB_USER_LOGIN":  "GITHUB_ACTOR",
+}
+
+// githubDefaultTimeout is the timeout of GitHub jobs, in minutes, which
+// the parser sets on the jobs without timeout-minutes
+const githubDefaultTimeout = 360
+
+// gitlabVariableRef matches a reference to a predefined GitLab variable
+var gitlabVariableRef = regexp.MustCompile(`\$(\{)?((?:CI|GITLAB)_[A-Z0-9_]+)(\})?`)
+
+// variableRef matches a $NAME or ${NAME} variable reference
+var variableRef = regexp.MustCompile(`\$(\{)?([A-Za-z_][A-Za-z0-9_]*)(\})?`)
+
+// githubJobID matches the characters a GitHub job ID can't hold
+var githubJobID = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+
+// toGithub returns the pipeline as a GitHub Actions workflow
+func (c *converter) toGithub() *yamlMap {
+	p := c.pipeline
+	ids := c.githubJobIDs()
+
+	root := newMap()
+	name := p.Name
+	if name == "" {
+		name = "CI"
+	}
+	root.set("name", name)
+	root.set("on", c.githubTriggers())
+	root.set("env", c.githubValues(p.Environment, "workflow"))
+
+	jobs := newMap()
+	for _, name := range c.orderedJobs() {
+		jobs.set(ids[name], c.githubJob(name, ids))
+	}
+	root.set("jobs", jobs)
+	return root
+}
+
+// githubJobIDs returns the GitHub ID of each job: its name with the
+// characters an ID can't hold replaced by -
+func (c *converter) githubJobIDs() map[string]string {
+	names := make([]string, 0, len(c.pipeline.Jobs))
+	for name := range c.pipeline.Jobs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	ids := make(map[string]string, len(names))
+	used := make(map[string]bool, len(names))
+	for _, name := range names {
+		id := strings.Trim(githubJobID.ReplaceAllString(name, "-"), "-")
+		if id == "" || (id[0] >= '0' && id[0] <= '9') || id[0] == '-' {
+			id = "job-" + id
+		}
+		base := id
+		for i := 2; used[id]; i++ {
+			id = fmt.Sprintf("%s-%d", base, i)
+		}
+		used[id] = true
+		ids[name] = id
+	}
+	return ids
+}
+
+// githubTriggers returns the events the workflow runs on
+func (c *converter) githubTriggers() []string {
+	if c.pipeline.Provider == "github" && len(c.pipeline.Triggers) > 0 {
+		if len(c.pipeline.Rules) > 0 {
+			c.warn("branch and path filters of the events are not converted")
+		}
+		return c.pipeline.Triggers
+	}
+	if len(c.pipeline.Rules) > 0 {
+		c.warn("workflow rules are not converted; the workflow runs on push and pull_request")
+	}
+	return []string{"push", "pull_request"}
+}
+
+// githubJob returns a job of the workflow, or nil when it can't be
+// converted
+func (c *converter) githubJob(name string, ids map[string]string) *yamlMap {
+	job := c.pipeline.Jobs[name]
+	fromGithub := c.pipeline.Provider == "github"
+
+	if job.Trigger != nil {
+		c.warn("job '%s' triggers a downstream pipeline, which GitHub Actions can't do; it is left out", name)
+		return nil
+	}
+	if job.WorkflowCall != nil {
+		c.warn("job '%s' calls the reusable workflow %s, which is not converted; it is left out", name, job.WorkflowCall.Uses)
+		return nil
+	}
+
+	m := newMap()
+	if ids[name] != name {
+		m.set("name", name)
+	} else if fromGithub && job.Name != name {
+		m.set("name", job.Name)
+	}
+
+	if fromGithub && job.RunsOn != "" {
+		m.set("runs-on", job.RunsOn)
+	} else {
+		m.set("runs-on", "ubuntu-latest")
+		if len(job.Tags) > 0 {
+			c.warn("tags of job '%s' (%s) are not converted; it runs on ubuntu-latest", name, strings.Join(job.Tags, ", "))
+		}
+	}
+
+	var needs []string
+	for _, upstream := range c.upstreamJobs(name) {
+		needs = append(needs, ids[upstream])
+	}
+	m.set("needs", needs)
+	m.set("if", c.githubCondition(name, job))
+	m.set("environment", c.githubEnvironment(job))
+	m.set("container", c.githubContainer(name, job))
+	m.set("services", c.githubServices(job))
+
+	strategy, matrixEnv := c.githubStrategy(name, job)
+	m.set("strategy", strategy)
+
+	env := c.githubValues(job.Environment, fmt.Sprintf("job '%s'", name))
+	for k, v := range matrixEnv {
+		if env == nil {
+			env = make(map[string]string)
+		}
+		env[k] = v
+	}
+	m.set("env", env)
+
+	if !fromGithub || job.TimeoutMin != githubDefaultTimeout {
+		m.set("timeout-minutes", job.TimeoutMin)
+	}
+	m.set("continue-on-error", job.ContinueOnErr || job.AllowFailure)
+	m.set("concurrency", job.ResourceGroup)
+	if job.Retry != nil && job.Retry.MaxAttempts > 0 {
+		c.warn("retry of job '%s' is not converted", name)
+	}
+	if len(job.SecretRefs) > 0 {
+		c.warn("secrets of job '%s' are not converted; store them as GitHub secrets", name)
+	}
+	if len(job.IDTokens) > 0 {
+		c.warn("id_tokens of job '%s' are not converted; GitHub jobs request OIDC tokens with the id-token: write permission", name)
+	}
+	if job.Coverage != "" {
+		c.warn("coverage of job '%s' is not converted", name)
+	}
+
+	m.set("steps", c.githubSteps(name, job, ids))
+	return m
+}
+
+// githubCondition returns the `if:` of a job
+func (c *converter) githubCondition(name string, job *types.Job) string {
+	if c.pipeline.Provider == "github" {
+		return job.If
+	}
+
+	if len(job.Rules) > 0 {
+		c.warn("rules of job '%s' are not converted", name)
+	}
+	if job.Only != nil || job.Except != nil {
+		c.warn("only/except of job '%s' are not converted", name)
+	}
+	switch job.When {
+	case "always":
+		return "always()"
+	case "on_failure":
+		return "failure()"
+	case "manual", "delayed":
+		c.warn("job '%s' is %s on GitLab but runs with the others on GitHub; an environment with required reviewers can gate it", name, job.When)
+	case "never":
+		return "false"
+	}
+	return ""
+}
+
+// githubEnvironment returns the environment a job deploys to
+func (c *converter) githubEnvironment(job *types.Job) interface{} {
+	if job.EnvironmentName == "" {
+		return nil
+	}
+	if job.Deployment != nil && job.Deployment.URL != "" {
+		env := newMap()
+		env.set("name", job.EnvironmentName)
+		env.set("url", c.githubValue(job.Deployment.URL, "environment of job '"+job.Name+"'"))
+		return env
+	}
+	return job.EnvironmentName
+}
+
+// githubContainer returns the container a job runs in: its image alone
+// or its settings
+func (c *converter) githubContainer(name string, job *types.Job) interface{} {
+	image := job.Image
+	if job.Container != nil && job.Container.Image != "" {
+		image = job.Container.Image
+	}
+	if image == "" {
+		return nil
+	}
+
+	container := newMap()
+	container.set("image", image)
+	if job.Container != nil {
+		container.set("env", job.Container.Env)
+		container.set("ports", job.Container.Ports)
+		container.set("volumes", job.Container.Volumes)
+		container.set("options", job.Container.Options)
+		if len(job.Container.Entrypoint) > 0 {
+			c.warn("the image entrypoint of job '%s' is not converted", name)
+		}
+	}
+	if container.len() == 1 {
+		return image
+	}
+	return container
+}
+
+// githubServices returns the service containers of a job, named by their
+// alias or image, the host names GitHub gives them
+func (c *converter) githubServices(job *types.Job) *yamlMap {
+	keys := make([]string, 0, len(job.Services))
+	for key := range job.Services {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	services := newMap()
+	for _, key := range keys {
+		svc := job.Services[key]
+		if svc == nil || svc.Image == "" {
+			continue
+		}
+		name := svc.Alias
+		if name == "" && c.pipeline.Provider == "github" {
+			name = key
+		}
+		if name == "" {
+			name = path.Base(types.ImageRepository(svc.Image))
+		}
+
+		service := newMap()
+		service.set("image", svc.Image)
+		service.set("env", svc.Env)
+		service.set("ports", svc.Ports)
+		service.set("options", svc.Options)
+		services.set(strings.Trim(githubJobID.ReplaceAllString(name, "-"), "-"), service)
+	}
+	return services
+}
+
+// githubStrategy returns the matrix of a job and, for GitLab matrices,
+// the variables passing the matrix values to the scripts
+func (c *converter) githubStrategy(name string, job *types.Job) (*yamlMap, map[string]string) {
+	strategy := newMap()
+	matrix := newMap()
+
+	// Jobs expanded from a matrix keep fail-fast and max-parallel only
+	if s := job.Strategy; s != nil && (len(s.Matrix) > 0 || len(s.Include) > 0) {
+		keys := make([]string, 0, len(s.Matrix))
+		for key := range s.Matrix {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			matrix.set(key, s.Matrix[key])
+		}
+		matrix.set("include", s.Include)
+		matrix.set("exclude", s.Exclude)
+		strategy.set("matrix", matrix)
+		if !s.FailFast {
+			strategy.set("fail-fast", falseNode())
+		}
+		strategy.set("max-parallel", s.MaxParallel)
+		return strategy, nil
+	}
+
+	if job.Parallel == nil {
+		return nil, nil
+	}
+
+	env := make(map[string]string)
+	if len(job.Parallel.Matrix) > 0 {
+		// Each GitLab entry is the product of its values: the workflow
+		// lists every combination
+		var include []map[string]string
+		for _, entry := range job.Parallel.Matrix {
+			for _, combination := range matrixCombinations(entry) {
+				include = append(include, combination)
+				for key := range combination {
+					env[key] = fmt.Sprintf("${{ matrix.%s }}", key)
+				}
+			}
+		}
+		matrix.set("include", include)
+	} else if total := job.Parallel.Total; total > 0 {
+		indexes := make([]int, total)
+		for i := range indexes {
+			indexes[i] = i + 1
+		}
+		matrix.set("ci_node_index", indexes)
+		env["CI_NODE_INDEX"] = "${{ matrix.ci_node_index }}"
+		env["CI_NODE_TOTAL"] = fmt.Sprint(total)
+	}
+
+	strategy.set("matrix", matrix)
+	strategy.set("fail-fast", falseNode())
+	return strategy, env
+}
+
+// matrixCombinations returns the combinations of the values of a GitLab
+// matrix entry, each key holding a value or a list of them
+func matrixCombinations(entry map[string]interface{}) []map[string]string {
+	keys := make([]string, 0, len(entry))
+	for key := range entry {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	combinations := []map[string]string{{}}
+	for _, key := range keys {
+		values, ok := entry[key].([]interface{})
+		if !ok {
+			values = []interface{}{entry[key]}
+		}
+
+		var next []map[string]string
+		for _, combination := range combinations {
+			for _, value := range values {
+				extended := make(map[string]string, len(combination)+1)
+				for k, v := range combination {
+					extended[k] = v
+				}
+				extended[key] = fmt.Sprint(value)
+				next = append(next, extended)
+			}
+		}
+		combinations = next
+	}
+	return combinations
+}
+
+// githubSteps returns the steps of a job. Jobs of other providers check
+// out the repository first, download the artifacts they receive and
+// restore their caches, and upload their artifacts last.
+func (c *converter) githubSteps(name string, job *types.Job, ids map[string]string) []*yamlMap {
+	var steps []*yamlMap
+	fromGithub := c.pipeline.Provider == "github"
+
+	if !fromGithub {
+		checkout := newMap()
+		checkout.set("uses", "actions/checkout@v4")
+		steps = append(steps, checkout)
+
+		for _, upstream := range c.artifactSources(name) {
+			download := newMap()
+			download.set("name", fmt.Sprintf("Download %s artifacts", upstream))
+			download.set("uses", "actions/download-artifact@v4")
+			download.set("with", map[string]string{"name": ids[upstream]})
+			steps = append(steps, download)
+		}
+
+		for i, cache := range job.CacheConfigs() {
+			if cache == nil || len(cache.Paths) == 0 {
+				continue
+			}
+			key := c.githubExpressions(cache.Key, fmt.Sprintf("cache of job '%s'", name))
+			if key == "" {
+				key = fmt.Sprintf("%s-cache-%d", ids[name], i+1)
+			}
+			restore := newMap()
+			restore.set("uses", "actions/cache@v4")
+			restore.set("with", map[string]string{"path": strings.Join(cache.Paths, "\n"), "key": key})
+			steps = append(steps, restore)
+		}
+	}
+
+	context := fmt.Sprintf("job '%s'", name)
+	for _, step := range job.Steps {
+		s := newMap()
+		s.set("id", step.ID)
+		if !strings.Contains(step.Name, "\n") {
+			s.set("name", step.Name)
+		}
+
+		switch {
+		case step.Uses != "" && fromGithub:
+			s.set("if", step.If)
+			s.set("uses", step.Uses)
+			s.set("with", step.With)
+		case step.Uses != "":
+			c.warn("step '%s' of job '%s' uses %s, which is not converted", step.Name, name, step.Uses)
+			continue
+		default:
+			lines := stepLines(step)
+			if len(lines) == 0 {
+				continue
+			}
+			if fromGithub {
+				s.set("if", step.If)
+			} else if step.Phase == types.StepPhaseAfterScript {
+				s.set("if", "always()")
+			}
+			s.set("run", c.githubValue(strings.Join(lines, "\n"), context))
+			if fromGithub {
+				s.set("shell", step.Shell)
+			}
+			s.set("working-directory", step.WorkingDir)
+		}
+
+		s.set("env", c.githubValues(step.Env, context))
+		s.set("continue-on-error", step.ContinueOnErr)
+		s.set("timeout-minutes", step.TimeoutMin)
+		steps = append(steps, s)
+	}
+
+	if !fromGithub && job.Artifacts != nil && len(job.Artifacts.Paths) > 0 {
+		upload := newMap()
+		upload.set("name", "Upload artifacts")
+		switch job.Artifacts.When {
+		case "always":
+			upload.set("if", "always()")
+		case "on_failure":
+			upload.set("if", "failure()")
+		}
+		upload.set("uses", "actions/upload-artifact@v4")
+		upload.set("with", map[string]string{"name": ids[name], "path": strings.Join(job.Artifacts.Paths, "\n")})
+		steps = append(steps, upload)
+	}
+	if !fromGithub && job.Artifacts != nil && len(job.Artifacts.Reports) > 0 {
+		c.warn("artifact reports of job '%s' are not converted", name)
+	}
+	return steps
+}
+
+// artifactSources returns the jobs a GitLab job receives the artifacts
+// of: its dependencies when listed, the needs taking artifacts, or else
+// the jobs of all earlier stages. Jobs without artifacts are left out.
+func (c *converter) artifactSources(name string) []string {
+	job := c.pipeline.Jobs[name]
+
+	var sources []string
+	switch {
+	case job.DependenciesSet:
+		sources = job.Dependencies
+	case job.HasNeeds():
+		for _, need := range job.Needs {
+			if need.Artifacts {
+				sources = append(sources, need.Job)
+			}
+		}
+	default:
+		stages := make(map[string]int, len(c.pipeline.Stages))
+		for i, stage := range c.pipeline.Stages {
+			stages[stage] = i
+		}
+		for _, other := range c.orderedJobs() {
+			if stages[c.pipeline.Jobs[other].Stage] < stages[job.Stage] {
+				sources = append(sources, other)
+			}
+		}
+	}
+
+	var withArtifacts []string
+	for _, source := range sources {
+		if upstream, ok := c.pipeline.Jobs[source]; ok && upstream.Artifacts != nil && len(upstream.Artifacts.Paths) > 0 {
+			withArtifacts = append(withArtifacts, source)
+		}
+	}
+	return withArtifacts
+}
+
+// githubExpressions returns a value read by an action, which the shell
+// doesn't expand: variable references become ${{ env.NAME }} expressions
+func (c *converter) githubExpressions(value, context string) string {
+	return variableRef.ReplaceAllStringFunc(value, func(ref string) string {
+		m := variableRef.FindStringSubmatch(ref)
+		name := m[2]
+		if gitlabVariableRef.MatchString("$" + name) {
+			c.warn("%s uses %s, which can't be converted there", context, name)
+			return ref
+		}
+		if (m[1] == "") != (m[3] == "") {
+			return ref
+		}
+		return "${{ env." + name + " }}"
+	})
+}
+
+// githubValues converts the values of a variables map
+func (c *converter) githubValues(values map[string]string, context string) map[string]string {
+	if len(values) == 0 {
+		return nil
+	}
+	converted := make(map[string]string, len(values))
+	for k, v := range values {
+		converted[k] = c.githubValue(v, context)
+	}
+	return converted
+}
+
+// githubValue replaces the predefined GitLab variables a value or script
+// refers to by their GitHub counterparts. Others are kept, with a warning.
+func (c *converter) githubValue(value, context string) string {
+	if c.pipeline.Provider == "github" {
+		return value
+	}
+	return gitlabVariableRef.ReplaceAllStringFunc(value, func(ref string) string {
+		m := gitlabVariableRef.FindStringSubmatch(ref)
+		open, name, close := m[1], m[2], m[3]
+		github, ok := githubVariables[name]
+		if !ok {
+			c.warn("%s uses %s, which GitHub doesn't set", context, name)
+			return ref
+		}
+		if open != "" && close != "" {
+			return "${" + github + "}"
+		}
+		return "$" + github + close
+	})
+}
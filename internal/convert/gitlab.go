@@ -0,0 +1,613 @@
+package convert
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/sanix-darker/git-ci/internal/runners"
+	"github.com/sanix-darker/git-ci/pkg/types"
+	"gopkg.in/yaml.v3"
+)
+
+// gitlabContexts maps the GitHub contexts of `${{ }}` expressions to the
+// predefined GitLab variables holding the same values
+var gitlabContexts = map[string]string{
+	"github.sha":        "$CI_COMMIT_SHA",
+	"github.ref_name":   "$CI_COMMIT_REF_NAME",
+	"github.ref":        "$CI_COMMIT_REF_NAME",
+	"github.head_ref":   "$CI_MERGE_REQUEST_SOURCE_BRANCH_NAME",
+	"github.base_ref":   "$CI_MERGE_REQUEST_TARGET_BRANCH_NAME",
+	"github.workspace":  "$CI_PROJECT_DIR",
+	"github.repository": "$CI_PROJECT_PATH",
+	"github.run_id":     "$CI_PIPELINE_ID",
+	"github.run_number": "$CI_PIPELINE_IID",
+	"github.job":        "$CI_JOB_NAME",
+	"github.actor":      "$GITLAB_USER_LOGIN",
+	"github.event_name": "$CI_PIPELINE_SOURCE",
+	"github.server_url": "$CI_SERVER_URL",
+	"runner.os":         "Linux",
+	"runner.temp":       "/tmp",
+}
+
+// githubExpression matches a ${{ }} expression
+var githubExpression = regexp.MustCompile(`\$\{\{\s*(.*?)\s*\}\}`)
+
+// githubVariableRef matches an expression reading a variable: an env,
+// matrix, secret, input or configuration variable
+var githubVariableRef = regexp.MustCompile(`^(env|matrix|secrets|inputs|vars)\.([A-Za-z_][A-Za-z0-9_-]*)$`)
+
+// gitlabReserved are the top-level keywords a GitLab job can't be named
+var gitlabReserved = []string{
+	"after_script", "before_script", "cache", "default", "image", "include",
+	"pages", "services", "spec", "stages", "types", "variables", "workflow",
+}
+
+// toGitlab returns the pipeline as a GitLab CI configuration
+func (c *converter) toGitlab() *yamlMap {
+	p := c.pipeline
+	stages, jobStages := c.gitlabStages()
+	names := c.gitlabJobNames()
+
+	root := newMap()
+	root.set("stages", stages)
+	root.set("variables", c.gitlabValues(p.Environment, "workflow"))
+	if len(p.Triggers) > 0 {
+		for _, trigger := range p.Triggers {
+			if trigger != "push" && trigger != "pull_request" {
+				c.warn("the %s trigger is not converted; GitLab schedules and pipeline rules are set apart", trigger)
+			}
+		}
+	}
+	if p.Provider != "gitlab" && len(p.Rules) > 0 {
+		c.warn("branch and path filters of the events are not converted")
+	}
+
+	for _, name := range c.orderedJobs() {
+		root.set(names[name], c.gitlabJob(name, jobStages[name], names))
+	}
+	return root
+}
+
+// gitlabStages returns the stages of the configuration and the stage of
+// each job. GitLab pipelines keep theirs; others have a stage per depth
+// in the needs graph.
+func (c *converter) gitlabStages() ([]string, map[string]string) {
+	jobStages := make(map[string]string, len(c.pipeline.Jobs))
+
+	if c.pipeline.Provider == "gitlab" {
+		var stages []string
+		for _, stage := range c.pipeline.Stages {
+			if stage != ".pre" && stage != ".post" {
+				stages = append(stages, stage)
+			}
+		}
+		for name, job := range c.pipeline.Jobs {
+			jobStages[name] = job.Stage
+		}
+		return stages, jobStages
+	}
+
+	levels := c.levels()
+	depth := 0
+	for _, level := range levels {
+		depth = max(depth, level)
+	}
+	if depth == 0 {
+		for name := range c.pipeline.Jobs {
+			jobStages[name] = "test"
+		}
+		return []string{"test"}, jobStages
+	}
+
+	stages := make([]string, depth+1)
+	for i := range stages {
+		stages[i] = fmt.Sprintf("stage-%d", i+1)
+	}
+	for name, level := range levels {
+		jobStages[name] = stages[max(level, 0)]
+	}
+	return stages, jobStages
+}
+
+// gitlabJobNames returns the GitLab name of each job: its name, unless it
+// is a top-level keyword or hidden
+func (c *converter) gitlabJobNames() map[string]string {
+	names := make(map[string]string, len(c.pipeline.Jobs))
+	for name := range c.pipeline.Jobs {
+		renamed := name
+		if containsString(gitlabReserved, name) || strings.HasPrefix(name, ".") {
+			renamed = strings.TrimPrefix(name, ".") + "-job"
+			c.warn("job '%s' is renamed '%s', GitLab reserving its name", name, renamed)
+		}
+		names[name] = renamed
+	}
+	return names
+}
+
+// gitlabJob returns a job of the configuration, or nil when it can't be
+// converted
+func (c *converter) gitlabJob(name, stage string, names map[string]string) *yamlMap {
+	job := c.pipeline.Jobs[name]
+	fromGitlab := c.pipeline.Provider == "gitlab"
+	context := fmt.Sprintf("job '%s'", name)
+
+	if job.WorkflowCall != nil {
+		c.warn("job '%s' calls the reusable workflow %s, which is not converted; it is left out", name, job.WorkflowCall.Uses)
+		return nil
+	}
+	if job.Trigger != nil {
+		c.warn("the trigger of job '%s' is not converted; it is left out", name)
+		return nil
+	}
+
+	m := newMap()
+	m.set("stage", stage)
+	m.set("image", c.gitlabImage(name, job))
+	m.set("services", c.gitlabServices(job))
+	if fromGitlab {
+		m.set("tags", job.Tags)
+	}
+	if needs := c.gitlabNeeds(job, names); len(needs) == 0 && fromGitlab && job.NeedsSet {
+		// `needs: []` starts the job without waiting for earlier stages
+		m.set("needs", &yaml.Node{Kind: yaml.SequenceNode, Style: yaml.FlowStyle})
+	} else {
+		m.set("needs", needs)
+	}
+	if fromGitlab && job.DependenciesSet {
+		dependencies := &yaml.Node{Kind: yaml.SequenceNode, Style: yaml.FlowStyle}
+		for _, dep := range job.Dependencies {
+			dependencies.Content = append(dependencies.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: names[dep]})
+		}
+		m.set("dependencies", dependencies)
+	}
+
+	m.set("variables", c.gitlabValues(job.Environment, context))
+	c.gitlabSteps(name, job, m)
+
+	c.setGitlabConditions(name, job, m)
+	m.set("environment", c.gitlabEnvironment(job))
+
+	timeout := job.TimeoutMin
+	if !fromGitlab && timeout == githubDefaultTimeout {
+		timeout = 0
+	}
+	if timeout > 0 {
+		m.set("timeout", fmt.Sprintf("%d minutes", timeout))
+	}
+
+	switch {
+	case len(job.AllowExitCodes) > 0:
+		m.set("allow_failure", map[string][]int{"exit_codes": job.AllowExitCodes})
+	case job.AllowFailure || job.ContinueOnErr:
+		m.set("allow_failure", true)
+	}
+	if job.Retry != nil && job.Retry.MaxAttempts > 0 {
+		if job.Retry.MaxAttempts > 2 {
+			c.warn("job '%s' is retried at most twice on GitLab", name)
+		}
+		retry := newMap()
+		retry.set("max", min(job.Retry.MaxAttempts, 2))
+		retry.set("when", job.Retry.When)
+		m.set("retry", retry)
+	}
+	m.set("parallel", c.gitlabParallel(name, job))
+	m.set("interruptible", job.Interruptible)
+	m.set("resource_group", job.ResourceGroup)
+	m.set("coverage", job.Coverage)
+	if len(job.SecretRefs) > 0 {
+		c.warn("secrets of job '%s' are not converted", name)
+	}
+	if fromGitlab {
+		idTokens := make(map[string]map[string][]string, len(job.IDTokens))
+		for tokenName, token := range job.IDTokens {
+			idTokens[tokenName] = map[string][]string{"aud": token.Aud}
+		}
+		m.set("id_tokens", idTokens)
+	}
+	return m
+}
+
+// gitlabImage returns the image of a job, with its entrypoint when set.
+// Jobs of other providers without a container get an image matching their
+// runner label.
+func (c *converter) gitlabImage(name string, job *types.Job) interface{} {
+	image := job.Image
+	if job.Container != nil && job.Container.Image != "" {
+		image = job.Container.Image
+	}
+	if image == "" && c.pipeline.Provider != "gitlab" {
+		image = runners.JobImage(job)
+		c.warn("job '%s' runs on %s, converted to the %s image; the tools of the hosted runner are not in it", name, job.RunsOn, image)
+	}
+	if image == "" {
+		return nil
+	}
+
+	if job.Container != nil && len(job.Container.Entrypoint) > 0 {
+		m := newMap()
+		m.set("name", image)
+		m.set("entrypoint", job.Container.Entrypoint)
+		return m
+	}
+	return image
+}
+
+// gitlabServices returns the services of a job; their key becomes their
+// alias, the host name they are reached at on GitHub
+func (c *converter) gitlabServices(job *types.Job) []*yamlMap {
+	keys := make([]string, 0, len(job.Services))
+	for key := range job.Services {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var services []*yamlMap
+	for _, key := range keys {
+		svc := job.Services[key]
+		if svc == nil || svc.Image == "" {
+			continue
+		}
+		service := newMap()
+		service.set("name", svc.Image)
+		if svc.Alias != "" {
+			service.set("alias", svc.Alias)
+		} else if c.pipeline.Provider != "gitlab" {
+			service.set("alias", key)
+		}
+		service.set("entrypoint", svc.Entrypoint)
+		service.set("command", svc.Command)
+		service.set("variables", svc.Env)
+		if c.pipeline.Provider == "gitlab" && len(svc.PullPolicy) > 0 {
+			service.set("pull_policy", svc.PullPolicy)
+		}
+		services = append(services, service)
+	}
+	return services
+}
+
+// gitlabNeeds returns the needs of a job: names when they take the
+// artifacts of the job, settings otherwise
+func (c *converter) gitlabNeeds(job *types.Job, names map[string]string) []interface{} {
+	var needs []interface{}
+	for _, need := range job.Needs {
+		if _, exists := c.pipeline.Jobs[need.Job]; !exists && !need.Optional {
+			continue
+		}
+		needName := names[need.Job]
+		if needName == "" {
+			needName = need.Job
+		}
+		if need.Artifacts && !need.Optional {
+			needs = append(needs, needName)
+			continue
+		}
+		setting := map[string]interface{}{"job": needName}
+		if !need.Artifacts {
+			setting["artifacts"] = false
+		}
+		if need.Optional {
+			setting["optional"] = true
+		}
+		needs = append(needs, setting)
+	}
+	return needs
+}
+
+// gitlabSteps sets the scripts, artifacts and cache of a job from its
+// steps. Actions that have a GitLab counterpart are converted, checkout
+// and artifact downloads being implicit.
+func (c *converter) gitlabSteps(name string, job *types.Job, m *yamlMap) {
+	context := fmt.Sprintf("job '%s'", name)
+	var before, script, after []string
+	var caches []*yamlMap
+	artifacts := newMap()
+
+	if c.pipeline.Provider == "gitlab" {
+		for _, step := range job.Steps {
+			lines := stepLines(step)
+			switch {
+			case step.Phase == types.StepPhaseBeforeScript:
+				before = append(before, lines...)
+			case step.Phase == types.StepPhaseAfterScript:
+				after = append(after, lines...)
+			default:
+				script = append(script, lines...)
+			}
+		}
+		for _, cache := range job.CacheConfigs() {
+			caches = append(caches, gitlabCache(cache))
+		}
+		if a := job.Artifacts; a != nil {
+			artifacts.set("name", a.Name)
+			artifacts.set("paths", a.Paths)
+			artifacts.set("exclude", a.Exclude)
+			artifacts.set("untracked", a.Untracked)
+			artifacts.set("when", a.When)
+			artifacts.set("expire_in", a.ExpireIn)
+			reports := newMap()
+			for _, report := range a.Reports {
+				reports.set(report.Type, report.Paths)
+			}
+			artifacts.set("reports", reports)
+		}
+	} else {
+		for _, step := range job.Steps {
+			if step.Uses != "" {
+				c.gitlabAction(name, step, artifacts, &caches)
+				continue
+			}
+
+			lines := stepLines(step)
+			if len(lines) == 0 {
+				continue
+			}
+			if step.Shell != "" && step.Shell != "bash" && step.Shell != "sh" {
+				c.warn("step '%s' of job '%s' runs with %s, converted as a shell script", step.Name, name, step.Shell)
+			}
+			if step.ContinueOnErr {
+				c.warn("continue-on-error of step '%s' of job '%s' is not converted", step.Name, name)
+			}
+
+			run := c.gitlabValue(strings.Join(lines, "\n"), context)
+			if len(step.Env) > 0 || step.WorkingDir != "" {
+				run = c.scopedStep(step, run, context)
+			}
+
+			switch cond := strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(step.If, "${{"), "}}")); cond {
+			case "":
+				script = append(script, run)
+			case "always()":
+				after = append(after, run)
+			default:
+				c.warn("condition of step '%s' of job '%s' (%s) is not converted; it always runs", step.Name, name, cond)
+				script = append(script, run)
+			}
+		}
+	}
+
+	m.set("before_script", before)
+	m.set("script", script)
+	m.set("after_script", after)
+	m.set("artifacts", artifacts)
+	switch len(caches) {
+	case 0:
+	case 1:
+		m.set("cache", caches[0])
+	default:
+		m.set("cache", caches)
+	}
+	if len(script) == 0 {
+		c.warn("job '%s' has no script left; GitLab requires one", name)
+	}
+}
+
+// scopedStep returns the script of a step setting its variables and
+// working directory in a subshell, so they don't leak into the next steps
+func (c *converter) scopedStep(step types.Step, run, context string) string {
+	var b strings.Builder
+	b.WriteString("(\n")
+	if step.WorkingDir != "" {
+		fmt.Fprintf(&b, "cd %s\n", shellQuote(c.gitlabValue(step.WorkingDir, context)))
+	}
+	keys := make([]string, 0, len(step.Env))
+	for key := range step.Env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(&b, "export %s=\"%s\"\n", key, c.gitlabValue(step.Env[key], context))
+	}
+	b.WriteString(run)
+	b.WriteString("\n)")
+	return b.String()
+}
+
+// gitlabAction converts an action step: uploads become artifacts, caches
+// become cache blocks, checkouts and downloads are implicit on GitLab
+func (c *converter) gitlabAction(name string, step types.Step, artifacts *yamlMap, caches *[]*yamlMap) {
+	context := fmt.Sprintf("job '%s'", name)
+	switch actionName(step.Uses) {
+	case "actions/checkout", "actions/download-artifact":
+	case "actions/upload-artifact":
+		if artifacts.len() > 0 {
+			c.warn("job '%s' uploads several artifacts; only the first is converted", name)
+			return
+		}
+		artifacts.set("name", c.gitlabValue(step.With["name"], context))
+		artifacts.set("paths", splitLines(c.gitlabValue(step.With["path"], context)))
+		if strings.Contains(step.If, "always()") {
+			artifacts.set("when", "always")
+		}
+		if days := step.With["retention-days"]; days != "" {
+			artifacts.set("expire_in", days+" days")
+		}
+	case "actions/cache", "actions/cache/restore", "actions/cache/save":
+		cache := newMap()
+		cache.set("key", c.gitlabValue(step.With["key"], context))
+		cache.set("paths", splitLines(c.gitlabValue(step.With["path"], context)))
+		switch actionName(step.Uses) {
+		case "actions/cache/restore":
+			cache.set("policy", "pull")
+		case "actions/cache/save":
+			cache.set("policy", "push")
+		}
+		*caches = append(*caches, cache)
+	default:
+		c.warn("step '%s' of job '%s' uses %s, which is not converted; use an image providing what it sets up", step.Name, name, step.Uses)
+	}
+}
+
+// setGitlabConditions sets when a job runs: its rules, only/except and
+// when on GitLab, its `if:` when it has a GitLab counterpart otherwise
+func (c *converter) setGitlabConditions(name string, job *types.Job, m *yamlMap) {
+	if c.pipeline.Provider == "gitlab" {
+		var rules []*yamlMap
+		for _, rule := range job.Rules {
+			r := newMap()
+			r.set("if", rule.If)
+			r.set("changes", rule.Changes)
+			r.set("exists", rule.Exists)
+			r.set("variables", rule.Variables)
+			r.set("when", rule.When)
+			r.set("start_in", rule.StartIn)
+			r.set("allow_failure", rule.AllowFailure)
+			rules = append(rules, r)
+		}
+		m.set("rules", rules)
+		m.set("only", job.Only)
+		m.set("except", job.Except)
+		m.set("when", job.When)
+		m.set("start_in", job.StartIn)
+		return
+	}
+
+	switch cond := strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(job.If, "${{"), "}}")); cond {
+	case "":
+	case "always()":
+		m.set("when", "always")
+	case "failure()":
+		m.set("when", "on_failure")
+	default:
+		c.warn("condition of job '%s' (%s) is not converted; write it as rules", name, cond)
+	}
+}
+
+// gitlabEnvironment returns the environment a job deploys to
+func (c *converter) gitlabEnvironment(job *types.Job) interface{} {
+	if job.EnvironmentName == "" {
+		return nil
+	}
+	d := job.Deployment
+	if d == nil {
+		return job.EnvironmentName
+	}
+
+	env := newMap()
+	env.set("name", job.EnvironmentName)
+	env.set("url", c.gitlabValue(d.URL, "environment of job '"+job.Name+"'"))
+	env.set("action", d.Action)
+	env.set("on_stop", d.OnStop)
+	env.set("auto_stop_in", d.AutoStopIn)
+	env.set("deployment_tier", job.DeploymentTier)
+	if env.len() == 1 {
+		return job.EnvironmentName
+	}
+	return env
+}
+
+// gitlabParallel returns the parallel setting of a job: its own on
+// GitLab, its strategy matrix otherwise
+func (c *converter) gitlabParallel(name string, job *types.Job) interface{} {
+	if p := job.Parallel; p != nil {
+		if len(p.Matrix) > 0 {
+			return map[string]interface{}{"matrix": p.Matrix}
+		}
+		return p.Total
+	}
+
+	s := job.Strategy
+	if s == nil || (len(s.Matrix) == 0 && len(s.Include) == 0) {
+		return nil
+	}
+	if len(s.Exclude) > 0 {
+		c.warn("matrix exclusions of job '%s' are not converted", name)
+	}
+
+	var matrix []map[string]interface{}
+	if len(s.Matrix) > 0 {
+		entry := make(map[string]interface{}, len(s.Matrix))
+		for key, values := range s.Matrix {
+			strs := make([]string, len(values))
+			for i, value := range values {
+				strs[i] = fmt.Sprint(value)
+			}
+			entry[key] = strs
+		}
+		matrix = append(matrix, entry)
+	}
+	for _, include := range s.Include {
+		entry := make(map[string]interface{}, len(include))
+		for key, value := range include {
+			entry[key] = fmt.Sprint(value)
+		}
+		matrix = append(matrix, entry)
+	}
+	if len(s.Include) > 0 && len(s.Matrix) > 0 {
+		c.warn("matrix includes of job '%s' become separate combinations", name)
+	}
+	return map[string]interface{}{"matrix": matrix}
+}
+
+// gitlabCache returns a cache block
+func gitlabCache(cache *types.CacheConfig) *yamlMap {
+	m := newMap()
+	m.set("key", cache.Key)
+	m.set("paths", cache.Paths)
+	m.set("untracked", cache.Untracked)
+	m.set("policy", cache.Policy)
+	m.set("when", cache.When)
+	m.set("fallback_keys", cache.Fallback)
+	return m
+}
+
+// gitlabValues converts the values of a variables map
+func (c *converter) gitlabValues(values map[string]string, context string) map[string]string {
+	if len(values) == 0 {
+		return nil
+	}
+	converted := make(map[string]string, len(values))
+	for k, v := range values {
+		converted[k] = c.gitlabValue(v, context)
+	}
+	return converted
+}
+
+// gitlabValue replaces the ${{ }} expressions of a value or script by the
+// variables holding their value. Others are kept, with a warning.
+func (c *converter) gitlabValue(value, context string) string {
+	if c.pipeline.Provider == "gitlab" {
+		return value
+	}
+	return githubExpression.ReplaceAllStringFunc(value, func(expr string) string {
+		inner := githubExpression.FindStringSubmatch(expr)[1]
+		if m := githubVariableRef.FindStringSubmatch(inner); m != nil {
+			if m[1] == "secrets" {
+				c.warn("secret %s must be defined as a CI/CD variable", m[2])
+			}
+			return "${" + m[2] + "}"
+		}
+		if variable, ok := gitlabContexts[inner]; ok {
+			return variable
+		}
+		c.warn("%s uses the expression %s, which is not converted", context, expr)
+		return expr
+	})
+}
+
+// splitLines returns the non-empty lines of a value
+func splitLines(value string) []string {
+	var lines []string
+	for _, line := range strings.Split(value, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// shellQuote quotes a value for the shell, variables being expanded
+func shellQuote(value string) string {
+	return `"` + strings.ReplaceAll(value, `"`, `\"`) + `"`
+}
+
+// containsString reports whether list holds s
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
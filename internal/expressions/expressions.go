@@ -0,0 +1,409 @@
+// Package expressions evaluates GitHub Actions expressions, the `${{ }}`
+// syntax of workflow files and the bare conditions of `if:` keys.
+package expressions
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Context holds the named contexts an expression reads (github, env,
+// runner, ...). Values are strings, float64, bool, nil,
+// map[string]interface{} or []interface{}.
+type Context map[string]interface{}
+
+// lookup returns a context by name, names being case insensitive
+func (c Context) lookup(name string) interface{} {
+	if v, ok := c[name]; ok {
+		return v
+	}
+	for k, v := range c {
+		if strings.EqualFold(k, name) {
+			return v
+		}
+	}
+	return nil
+}
+
+// Status is the state of the job a condition is evaluated in, read by
+// the status functions
+type Status int
+
+const (
+	StatusSuccess   Status = iota // No step failed so far
+	StatusFailure                 // A previous step failed
+	StatusCancelled               // The job was cancelled
+)
+
+// statusFunctions are the functions whose presence in a condition
+// disables the implicit success()
+var statusFunctions = map[string]bool{"success": true, "failure": true, "always": true, "cancelled": true}
+
+// functions are the functions expressions may call, by lower case name
+var functions = map[string]bool{
+	"success": true, "failure": true, "cancelled": true, "always": true,
+	"contains": true, "startswith": true, "endswith": true, "format": true,
+	"join": true, "tojson": true, "fromjson": true, "hashfiles": true,
+}
+
+// Evaluate evaluates an expression, with or without its `${{ }}`
+// delimiters
+func Evaluate(expr string, ctx Context, status Status) (interface{}, error) {
+	expr = strip(expr)
+
+	tokens, err := tokenize(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression '%s': %w", expr, err)
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("empty expression")
+	}
+
+	p := &exprParser{tokens: tokens, ctx: ctx, status: status}
+	result, err := p.parseOr()
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression '%s': %w", expr, err)
+	}
+	if tok := p.peek(); tok != nil {
+		return nil, fmt.Errorf("invalid expression '%s': unexpected '%s'", expr, tok.text)
+	}
+	return result, nil
+}
+
+// EvalCondition evaluates an `if:` condition. As on GitHub, a condition
+// calling no status function only holds while the job succeeds, and an
+// empty one is success().
+func EvalCondition(cond string, ctx Context, status Status) (bool, error) {
+	cond = strip(cond)
+	if cond == "" {
+		return status == StatusSuccess, nil
+	}
+
+	tokens, err := tokenize(cond)
+	if err != nil {
+		return false, fmt.Errorf("invalid expression '%s': %w", cond, err)
+	}
+	explicit := false
+	for i, tok := range tokens {
+		if tok.kind == tokIdent && statusFunctions[strings.ToLower(tok.text)] &&
+			i+1 < len(tokens) && tokens[i+1].kind == tokLParen {
+			explicit = true
+			break
+		}
+	}
+	if !explicit && status != StatusSuccess {
+		return false, nil
+	}
+
+	result, err := Evaluate(cond, ctx, status)
+	if err != nil {
+		return false, err
+	}
+	return truthy(result), nil
+}
+
+// strip removes the `${{ }}` around a whole expression
+func strip(expr string) string {
+	expr = strings.TrimSpace(expr)
+	if strings.HasPrefix(expr, "${{") && strings.HasSuffix(expr, "}}") &&
+		strings.Count(expr, "${{") == 1 {
+		expr = strings.TrimSpace(expr[3 : len(expr)-2])
+	}
+	return expr
+}
+
+// call evaluates a function call; names are case insensitive
+func (p *exprParser) call(name string, args []interface{}) (interface{}, error) {
+	arity := func(n int) error {
+		if len(args) != n {
+			return fmt.Errorf("%s() takes %d argument(s), got %d", name, n, len(args))
+		}
+		return nil
+	}
+
+	switch strings.ToLower(name) {
+	case "success":
+		return p.status == StatusSuccess, arity(0)
+	case "failure":
+		return p.status == StatusFailure, arity(0)
+	case "cancelled":
+		return p.status == StatusCancelled, arity(0)
+	case "always":
+		return true, arity(0)
+
+	case "contains":
+		if err := arity(2); err != nil {
+			return nil, err
+		}
+		if items, ok := args[0].([]interface{}); ok {
+			for _, item := range items {
+				if equal(item, args[1]) {
+					return true, nil
+				}
+			}
+			return false, nil
+		}
+		return strings.Contains(strings.ToLower(toString(args[0])), strings.ToLower(toString(args[1]))), nil
+	case "startswith":
+		if err := arity(2); err != nil {
+			return nil, err
+		}
+		return strings.HasPrefix(strings.ToLower(toString(args[0])), strings.ToLower(toString(args[1]))), nil
+	case "endswith":
+		if err := arity(2); err != nil {
+			return nil, err
+		}
+		return strings.HasSuffix(strings.ToLower(toString(args[0])), strings.ToLower(toString(args[1]))), nil
+	case "format":
+		if len(args) == 0 {
+			return nil, fmt.Errorf("format() takes at least 1 argument")
+		}
+		return format(toString(args[0]), args[1:])
+	case "join":
+		if len(args) != 1 && len(args) != 2 {
+			return nil, fmt.Errorf("join() takes 1 or 2 arguments, got %d", len(args))
+		}
+		return join(args), nil
+	case "tojson":
+		if err := arity(1); err != nil {
+			return nil, err
+		}
+		return toJSON(args[0])
+	case "fromjson":
+		if err := arity(1); err != nil {
+			return nil, err
+		}
+		return fromJSON(args[0])
+	case "hashfiles":
+		if len(args) == 0 {
+			return nil, fmt.Errorf("hashFiles() takes at least 1 argument")
+		}
+		return hashFiles(toString(property(p.ctx.lookup("github"), "workspace")), args)
+	}
+
+	return nil, fmt.Errorf("unknown function %s()", name)
+}
+
+// property returns a property of an object, nil for anything else
+func property(v interface{}, name string) interface{} {
+	switch obj := v.(type) {
+	case map[string]interface{}:
+		if value, ok := obj[name]; ok {
+			return value
+		}
+		for k, value := range obj {
+			if strings.EqualFold(k, name) {
+				return value
+			}
+		}
+	case map[string]string:
+		if value, ok := obj[name]; ok {
+			return value
+		}
+		for k, value := range obj {
+			if strings.EqualFold(k, name) {
+				return value
+			}
+		}
+	}
+	return nil
+}
+
+// filtered is the result of an object filter (.*), whose next property
+// and index accesses apply to each of its items
+type filtered []interface{}
+
+// filter returns the items of an array or the values of an object as a
+// filtered array, an empty one for anything else. Filtering a filtered
+// array flattens the arrays and objects it holds.
+func filter(v interface{}) filtered {
+	var items []interface{}
+	var source []interface{}
+	if f, ok := v.(filtered); ok {
+		source = f
+	} else {
+		source = []interface{}{v}
+	}
+	for _, item := range source {
+		switch x := item.(type) {
+		case []interface{}:
+			items = append(items, x...)
+		case map[string]interface{}:
+			for _, name := range sortedKeys(x) {
+				items = append(items, x[name])
+			}
+		case map[string]string:
+			names := make([]string, 0, len(x))
+			for name := range x {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				items = append(items, x[name])
+			}
+		}
+	}
+	return filtered(items)
+}
+
+// mapFiltered applies an access to v, or to each item of a filtered
+// array, leaving out the items where it finds nothing
+func mapFiltered(v interface{}, access func(interface{}) interface{}) interface{} {
+	items, ok := v.(filtered)
+	if !ok {
+		return access(v)
+	}
+	result := filtered{}
+	for _, item := range items {
+		if value := access(item); value != nil {
+			result = append(result, value)
+		}
+	}
+	return result
+}
+
+// sortedKeys returns the names of an object in order
+func sortedKeys(obj map[string]interface{}) []string {
+	names := make([]string, 0, len(obj))
+	for name := range obj {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// indexValue returns obj[index] for objects and arrays
+func indexValue(v, index interface{}) interface{} {
+	if items, ok := v.([]interface{}); ok {
+		n := toNumber(index)
+		if math.IsNaN(n) || n < 0 || int(n) >= len(items) {
+			return nil
+		}
+		return items[int(n)]
+	}
+	return property(v, toString(index))
+}
+
+// truthy follows GitHub: false, 0, -0, NaN, "" and null are falsy
+func truthy(v interface{}) bool {
+	switch x := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return x
+	case float64:
+		return x != 0 && !math.IsNaN(x)
+	case string:
+		return x != ""
+	}
+	return true
+}
+
+// toString converts a value the way GitHub prints it
+func toString(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return ""
+	case bool:
+		return strconv.FormatBool(x)
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64)
+	case string:
+		return x
+	case []interface{}:
+		return "Array"
+	}
+	return "Object"
+}
+
+// toNumber coerces a value for comparisons between different types
+func toNumber(v interface{}) float64 {
+	switch x := v.(type) {
+	case nil:
+		return 0
+	case bool:
+		if x {
+			return 1
+		}
+		return 0
+	case float64:
+		return x
+	case string:
+		s := strings.TrimSpace(x)
+		if s == "" {
+			return 0
+		}
+		if n, err := parseNumber(s); err == nil {
+			return n
+		}
+	}
+	return math.NaN()
+}
+
+// equal compares strings case-insensitively and values of different
+// types as numbers; objects and arrays are only equal to themselves
+func equal(a, b interface{}) bool {
+	switch x := a.(type) {
+	case nil:
+		if b == nil {
+			return true
+		}
+	case string:
+		if y, ok := b.(string); ok {
+			return strings.EqualFold(x, y)
+		}
+	case bool:
+		if y, ok := b.(bool); ok {
+			return x == y
+		}
+	case map[string]interface{}, map[string]string, []interface{}:
+		return isObject(b) && fmt.Sprintf("%p", a) == fmt.Sprintf("%p", b)
+	}
+	if isObject(b) {
+		return false
+	}
+	return toNumber(a) == toNumber(b)
+}
+
+func isObject(v interface{}) bool {
+	switch v.(type) {
+	case map[string]interface{}, map[string]string, []interface{}:
+		return true
+	}
+	return false
+}
+
+// compare applies <, <=, > or >=: strings between themselves
+// (case-insensitively), any other mix as numbers
+func compare(a, b interface{}, op string) bool {
+	var cmp int
+	x, xs := a.(string)
+	y, ys := b.(string)
+	if xs && ys {
+		cmp = strings.Compare(strings.ToLower(x), strings.ToLower(y))
+	} else {
+		nx, ny := toNumber(a), toNumber(b)
+		if math.IsNaN(nx) || math.IsNaN(ny) {
+			return false
+		}
+		switch {
+		case nx < ny:
+			cmp = -1
+		case nx > ny:
+			cmp = 1
+		}
+	}
+
+	switch op {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	}
+	return cmp >= 0
+}
@@ -0,0 +1,234 @@
+package expressions
+
+import (
+	"reflect"
+	"testing"
+)
+
+// pullRequest is a github context with the labels of a pull request
+func pullRequest() Context {
+	return Context{
+		"github": map[string]interface{}{
+			"event": map[string]interface{}{
+				"pull_request": map[string]interface{}{
+					"labels": []interface{}{
+						map[string]interface{}{"name": "bug"},
+						map[string]interface{}{"name": "ci"},
+						map[string]interface{}{"color": "red"},
+					},
+				},
+			},
+		},
+		"matrix": map[string]interface{}{
+			"os":   map[string]interface{}{"names": []interface{}{"linux", "macos"}},
+			"arch": map[string]interface{}{"names": []interface{}{"arm64"}},
+		},
+	}
+}
+
+func TestObjectFilters(t *testing.T) {
+	tests := []struct {
+		expr string
+		want interface{}
+	}{
+		{"github.event.pull_request.labels.*.name", []interface{}{"bug", "ci"}},
+		{"contains(github.event.pull_request.labels.*.name, 'bug')", true},
+		{"contains(github.event.pull_request.labels.*.name, 'docs')", false},
+		{"join(github.event.pull_request.labels.*.name, ', ')", "bug, ci"},
+		{"matrix.*.names", []interface{}{[]interface{}{"arm64"}, []interface{}{"linux", "macos"}}},
+		{"matrix.*.names.*", []interface{}{"arm64", "linux", "macos"}},
+		{"github.event.pull_request.labels.*.name[0]", []interface{}{}},
+		{"github.missing.*.name", []interface{}{}},
+	}
+	for _, tt := range tests {
+		got, err := Evaluate(tt.expr, pullRequest(), StatusSuccess)
+		if err != nil {
+			t.Errorf("%s: %v", tt.expr, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s = %#v, want %#v", tt.expr, got, tt.want)
+		}
+	}
+}
+
+// testContext is the context of the expression tests
+func testContext() Context {
+	return Context{
+		"github": map[string]interface{}{
+			"ref":        "refs/heads/main",
+			"event_name": "push",
+		},
+		"env": map[string]interface{}{
+			"NAME":  "World",
+			"COUNT": "3",
+			"EMPTY": "",
+			"JSON":  `{"os": ["linux", "macos"], "debug": true}`,
+		},
+		"needs": map[string]interface{}{
+			"build": map[string]interface{}{
+				"result":  "success",
+				"outputs": map[string]interface{}{"version": "1.2.0"},
+			},
+		},
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	tests := []struct {
+		name string
+		expr string
+		want interface{}
+	}{
+		// Literals and contexts
+		{"string", "'it''s'", "it's"},
+		{"number", "42", 42.0},
+		{"hex", "0xff", 255.0},
+		{"float", "-1.5", -1.5},
+		{"null", "null", nil},
+		{"boolean", "true", true},
+		{"property", "github.ref", "refs/heads/main"},
+		{"index", "github['event_name']", "push"},
+		{"nested", "needs.build.outputs.version", "1.2.0"},
+		{"case insensitive", "GITHUB.REF", "refs/heads/main"},
+		{"missing", "github.nothing.deeper", nil},
+		{"delimiters", "${{ env.NAME }}", "World"},
+
+		// Operators and precedence
+		{"equality", "github.event_name == 'push'", true},
+		{"case insensitive equality", "github.event_name == 'PUSH'", true},
+		{"inequality", "github.event_name != 'push'", false},
+		{"not", "!true", false},
+		{"double not", "!!env.NAME", true},
+		{"less", "1 < 2", true},
+		{"greater or equal", "2 >= 3", false},
+		{"string comparison", "'b' > 'A'", true},
+		{"and before or", "true || false && false", true},
+		{"parentheses", "(true || false) && false", false},
+		{"comparison before equality", "1 < 2 == true", true},
+		{"not before equality", "!false == true", true},
+		{"or returns the operand", "env.EMPTY || 'default'", "default"},
+		{"and returns the operand", "env.NAME && env.COUNT", "3"},
+		{"and stops at falsy", "env.EMPTY && env.NAME", ""},
+
+		// Short-circuits: the operand not needed would fail
+		{"or short-circuits", "true || fromJSON('{')", true},
+		{"and short-circuits", "false && fromJSON('{')", false},
+		{"nested short-circuit", "github.ref == 'refs/heads/main' || (format('{9}') && true)", true},
+
+		// Functions
+		{"contains string", "contains('Hello World', 'world')", true},
+		{"contains array", "contains(fromJSON(env.JSON).os, 'macos')", true},
+		{"contains array miss", "contains(fromJSON(env.JSON).os, 'mac')", false},
+		{"startsWith", "startsWith(github.ref, 'refs/heads/')", true},
+		{"startsWith case", "startsWith('Hello', 'HE')", true},
+		{"endsWith", "endsWith(github.ref, '/main')", true},
+		{"format", "format('Hello {0}, {1} times', env.NAME, 3)", "Hello World, 3 times"},
+		{"format braces", "format('{{0}} {0}', 'x')", "{0} x"},
+		{"join", "join(fromJSON(env.JSON).os)", "linux,macos"},
+		{"join separator", "join(fromJSON(env.JSON).os, ' | ')", "linux | macos"},
+		{"join string", "join('single')", "single"},
+		{"toJSON", "toJSON(fromJSON('[1, \"a\"]'))", "[\n  1,\n  \"a\"\n]"},
+		{"fromJSON object", "fromJSON(env.JSON).debug", true},
+		{"fromJSON number", "fromJSON('3') == 3", true},
+		{"success", "success()", true},
+		{"failure", "failure()", false},
+		{"always", "always()", true},
+		{"function names", "STARTSWITH('ab', 'a')", true},
+
+		// null and type coercion
+		{"null equals null", "null == null", true},
+		{"null is zero", "null == 0", true},
+		{"null and empty string are zero", "null == ''", true},
+		{"string to number", "env.COUNT == 3", true},
+		{"empty string is zero", "'' == 0", true},
+		{"true is one", "true == 1", true},
+		{"false is zero", "false == 0", true},
+		{"number strings", "'0x10' == 16", true},
+		{"not a number", "'abc' == 0", false},
+		{"NaN comparison", "'abc' < 1", false},
+		{"object only equals itself", "fromJSON('{}') == fromJSON('{}')", false},
+		{"missing is null", "env.MISSING == null", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Evaluate(tt.expr, testContext(), StatusSuccess)
+			if err != nil {
+				t.Fatalf("%s: %v", tt.expr, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("%s = %#v, want %#v", tt.expr, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvaluateErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		expr string
+	}{
+		{"empty", ""},
+		{"unterminated string", "'abc"},
+		{"unexpected character", "env.NAME == $X"},
+		{"missing operand", "1 =="},
+		{"unclosed parenthesis", "(true"},
+		{"trailing token", "true false"},
+		{"unknown function", "nope()"},
+		{"unknown function skipped", "true || nope()"},
+		{"arity", "contains('a')"},
+		{"invalid JSON", "fromJSON('{')"},
+		{"format placeholder", "format('{1}', 'a')"},
+		{"property name", "github."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := Evaluate(tt.expr, testContext(), StatusSuccess); err == nil {
+				t.Errorf("%s = %#v, want an error", tt.expr, got)
+			}
+		})
+	}
+}
+
+func TestEvalCondition(t *testing.T) {
+	tests := []struct {
+		cond   string
+		status Status
+		want   bool
+	}{
+		{"", StatusSuccess, true},
+		{"", StatusFailure, false},
+		{"github.event_name == 'push'", StatusSuccess, true},
+		{"github.event_name == 'push'", StatusFailure, false},
+		{"always()", StatusFailure, true},
+		{"failure()", StatusFailure, true},
+		{"failure()", StatusSuccess, false},
+		{"success()", StatusFailure, false},
+		{"cancelled()", StatusCancelled, true},
+		{"${{ always() && env.NAME == 'World' }}", StatusFailure, true},
+		{"env.EMPTY", StatusSuccess, false},
+	}
+
+	for _, tt := range tests {
+		got, err := EvalCondition(tt.cond, testContext(), tt.status)
+		if err != nil {
+			t.Errorf("%q: %v", tt.cond, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%q with status %d = %v, want %v", tt.cond, tt.status, got, tt.want)
+		}
+	}
+}
+
+func TestInterpolate(t *testing.T) {
+	got, unresolved := Interpolate("Hello ${{ env.NAME }}, ${{ env.MISSING }}${{ format('{0}}}', 'x') }}", testContext(), StatusSuccess)
+	if want := "Hello World, x}"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if !reflect.DeepEqual(unresolved, []string{"env.MISSING"}) {
+		t.Errorf("unresolved %v, want [env.MISSING]", unresolved)
+	}
+}
@@ -0,0 +1,211 @@
+package expressions
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// format replaces the {0}, {1}... placeholders of a format string by the
+// arguments; {{ and }} stand for braces
+func format(pattern string, args []interface{}) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		ch := pattern[i]
+		switch {
+		case ch == '{' && i+1 < len(pattern) && pattern[i+1] == '{':
+			b.WriteByte('{')
+			i++
+		case ch == '}' && i+1 < len(pattern) && pattern[i+1] == '}':
+			b.WriteByte('}')
+			i++
+		case ch == '{':
+			end := strings.IndexByte(pattern[i:], '}')
+			if end < 0 {
+				return "", fmt.Errorf("format(): unclosed '{' in '%s'", pattern)
+			}
+			index, err := strconv.Atoi(pattern[i+1 : i+end])
+			if err != nil || index < 0 || index >= len(args) {
+				return "", fmt.Errorf("format(): invalid placeholder '%s' in '%s'", pattern[i:i+end+1], pattern)
+			}
+			b.WriteString(toString(args[index]))
+			i += end
+		case ch == '}':
+			return "", fmt.Errorf("format(): unescaped '}' in '%s'", pattern)
+		default:
+			b.WriteByte(ch)
+		}
+	}
+	return b.String(), nil
+}
+
+// join joins the items of an array with a separator, ',' by default; any
+// other value is returned as a string
+func join(args []interface{}) string {
+	separator := ","
+	if len(args) > 1 {
+		separator = toString(args[1])
+	}
+	items, ok := args[0].([]interface{})
+	if !ok {
+		return toString(args[0])
+	}
+	strs := make([]string, len(items))
+	for i, item := range items {
+		strs[i] = toString(item)
+	}
+	return strings.Join(strs, separator)
+}
+
+// toJSON returns a value as pretty-printed JSON
+func toJSON(v interface{}) (string, error) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("toJSON(): %w", err)
+	}
+	return string(data), nil
+}
+
+// fromJSON parses a JSON string; numbers become float64 like the
+// literals of expressions
+func fromJSON(v interface{}) (interface{}, error) {
+	var result interface{}
+	if err := json.Unmarshal([]byte(toString(v)), &result); err != nil {
+		return nil, fmt.Errorf("fromJSON(): %w", err)
+	}
+	return result, nil
+}
+
+// hashFiles returns the SHA-256 of the files of the workspace matching
+// the patterns, "" when none does. Patterns are globs where ** spans
+// directories; a pattern starting with ! excludes the files it matches.
+func hashFiles(workspace string, patterns []interface{}) (string, error) {
+	if workspace == "" {
+		workspace = "."
+	}
+
+	type globPattern struct {
+		re      *regexp.Regexp
+		exclude bool
+	}
+	var globs []globPattern
+	for _, arg := range patterns {
+		pattern := strings.TrimSpace(toString(arg))
+		exclude := strings.HasPrefix(pattern, "!")
+		pattern = strings.TrimPrefix(strings.TrimPrefix(pattern, "!"), "./")
+		re, err := globRegexp(pattern)
+		if err != nil {
+			return "", fmt.Errorf("hashFiles(): invalid pattern '%s'", pattern)
+		}
+		globs = append(globs, globPattern{re: re, exclude: exclude})
+	}
+
+	var files []string
+	err := filepath.WalkDir(workspace, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(workspace, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		matched := false
+		for _, glob := range globs {
+			if glob.re.MatchString(rel) {
+				matched = !glob.exclude
+			}
+		}
+		if matched {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("hashFiles(): %w", err)
+	}
+	if len(files) == 0 {
+		return "", nil
+	}
+	sort.Strings(files)
+
+	// As on GitHub, the hash of the file hashes
+	total := sha256.New()
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return "", fmt.Errorf("hashFiles(): %w", err)
+		}
+		sum := sha256.Sum256(data)
+		total.Write(sum[:])
+	}
+	return hex.EncodeToString(total.Sum(nil)), nil
+}
+
+// globRegexp compiles a glob to an anchored regular expression: * and ?
+// within a directory, ** across directories
+func globRegexp(pattern string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch ch := pattern[i]; {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			b.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case ch == '*':
+			b.WriteString("[^/]*")
+		case ch == '?':
+			b.WriteString("[^/]")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(ch)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
+
+// EnvContext returns the contexts read from the variables of a job: env,
+// github and runner from the GITHUB_* and RUNNER_* variables (GITHUB_REF
+// is github.ref), and inputs from the INPUT_* ones of reusable workflows
+func EnvContext(env map[string]string) Context {
+	vars := make(map[string]interface{}, len(env))
+	github := make(map[string]interface{})
+	runner := make(map[string]interface{})
+	inputs := make(map[string]interface{})
+	for k, v := range env {
+		vars[k] = v
+		switch {
+		case strings.HasPrefix(k, "GITHUB_"):
+			github[strings.ToLower(strings.TrimPrefix(k, "GITHUB_"))] = v
+		case strings.HasPrefix(k, "RUNNER_"):
+			runner[strings.ToLower(strings.TrimPrefix(k, "RUNNER_"))] = v
+		case strings.HasPrefix(k, "INPUT_"):
+			inputs[strings.ToLower(strings.TrimPrefix(k, "INPUT_"))] = v
+		}
+	}
+
+	return Context{
+		"env":    vars,
+		"github": github,
+		"runner": runner,
+		"inputs": inputs,
+	}
+}
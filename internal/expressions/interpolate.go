@@ -0,0 +1,51 @@
+package expressions
+
+import "strings"
+
+// Interpolate replaces the `${{ }}` expressions of s by their value as a
+// string. As on GitHub, an expression that fails or is null expands to an
+// empty string; those are returned as unresolved.
+func Interpolate(s string, ctx Context, status Status) (string, []string) {
+	if !strings.Contains(s, "${{") {
+		return s, nil
+	}
+
+	var out strings.Builder
+	var unresolved []string
+	for {
+		start := strings.Index(s, "${{")
+		if start < 0 {
+			break
+		}
+		end := expressionEnd(s, start+3)
+		if end < 0 {
+			break
+		}
+
+		out.WriteString(s[:start])
+		expr := strings.TrimSpace(s[start+3 : end])
+		value, err := Evaluate(expr, ctx, status)
+		if err != nil || value == nil {
+			unresolved = append(unresolved, expr)
+		}
+		out.WriteString(toString(value))
+		s = s[end+2:]
+	}
+	out.WriteString(s)
+	return out.String(), unresolved
+}
+
+// expressionEnd returns the index of the `}}` closing the expression
+// starting at i, outside of string literals, or -1
+func expressionEnd(s string, i int) int {
+	quoted := false
+	for ; i < len(s); i++ {
+		switch {
+		case s[i] == '\'':
+			quoted = !quoted
+		case !quoted && strings.HasPrefix(s[i:], "}}"):
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,401 @@
+package expressions
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokString tokenKind = iota
+	tokNumber
+	tokIdent
+	tokOperator
+	tokLParen
+	tokRParen
+	tokLBracket
+	tokRBracket
+	tokDot
+	tokComma
+	tokStar
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+// operators are matched longest first
+var operators = []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!"}
+
+func tokenize(expr string) ([]token, error) {
+	var tokens []token
+
+	for i := 0; i < len(expr); {
+		ch := expr[i]
+		switch {
+		case ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r':
+			i++
+
+		case ch == '(':
+			tokens = append(tokens, token{kind: tokLParen, text: "("})
+			i++
+		case ch == ')':
+			tokens = append(tokens, token{kind: tokRParen, text: ")"})
+			i++
+		case ch == '[':
+			tokens = append(tokens, token{kind: tokLBracket, text: "["})
+			i++
+		case ch == ']':
+			tokens = append(tokens, token{kind: tokRBracket, text: "]"})
+			i++
+		case ch == ',':
+			tokens = append(tokens, token{kind: tokComma, text: ","})
+			i++
+		case ch == '*':
+			tokens = append(tokens, token{kind: tokStar, text: "*"})
+			i++
+
+		case ch == '.' && (i+1 >= len(expr) || !isDigit(expr[i+1])):
+			tokens = append(tokens, token{kind: tokDot, text: "."})
+			i++
+
+		case ch == '\'':
+			// Quotes are escaped by doubling them: 'it''s'
+			var sb strings.Builder
+			j := i + 1
+			for {
+				if j >= len(expr) {
+					return nil, fmt.Errorf("unterminated string")
+				}
+				if expr[j] == '\'' {
+					if j+1 < len(expr) && expr[j+1] == '\'' {
+						sb.WriteByte('\'')
+						j += 2
+						continue
+					}
+					break
+				}
+				sb.WriteByte(expr[j])
+				j++
+			}
+			tokens = append(tokens, token{kind: tokString, text: sb.String()})
+			i = j + 1
+
+		case isDigit(ch) || ch == '.' || (ch == '-' && i+1 < len(expr) && (isDigit(expr[i+1]) || expr[i+1] == '.')):
+			j := i + 1
+			for j < len(expr) && (isNameChar(expr[j]) || expr[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, token{kind: tokNumber, text: expr[i:j]})
+			i = j
+
+		case isNameStart(ch):
+			j := i + 1
+			for j < len(expr) && (isNameChar(expr[j]) || expr[j] == '-') {
+				j++
+			}
+			tokens = append(tokens, token{kind: tokIdent, text: expr[i:j]})
+			i = j
+
+		default:
+			op := ""
+			for _, candidate := range operators {
+				if strings.HasPrefix(expr[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected character '%c'", ch)
+			}
+			tokens = append(tokens, token{kind: tokOperator, text: op})
+			i += len(op)
+		}
+	}
+
+	return tokens, nil
+}
+
+func isDigit(ch byte) bool {
+	return ch >= '0' && ch <= '9'
+}
+
+func isNameStart(ch byte) bool {
+	return ch == '_' || (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z')
+}
+
+func isNameChar(ch byte) bool {
+	return isNameStart(ch) || isDigit(ch)
+}
+
+// exprParser evaluates the tokens while parsing them, by precedence from
+// lowest to highest: ||, &&, == and !=, comparisons, !, then operands
+// with their property accesses
+type exprParser struct {
+	tokens []token
+	pos    int
+	ctx    Context
+	status Status
+
+	// skipping is above 0 while parsing operands && and || don't
+	// evaluate: their functions aren't called
+	skipping int
+}
+
+// parseSkipped parses an operand of && or || whose value is not needed
+func (p *exprParser) parseSkipped(parse func() (interface{}, error)) error {
+	p.skipping++
+	defer func() { p.skipping-- }()
+	_, err := parse()
+	return err
+}
+
+func (p *exprParser) peek() *token {
+	if p.pos < len(p.tokens) {
+		return &p.tokens[p.pos]
+	}
+	return nil
+}
+
+// accept consumes the next token if it has the given kind and text
+func (p *exprParser) accept(kind tokenKind, text string) bool {
+	if tok := p.peek(); tok != nil && tok.kind == kind && tok.text == text {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *exprParser) expect(kind tokenKind, text string) error {
+	if !p.accept(kind, text) {
+		if tok := p.peek(); tok != nil {
+			return fmt.Errorf("expected '%s', found '%s'", text, tok.text)
+		}
+		return fmt.Errorf("expected '%s' at end of expression", text)
+	}
+	return nil
+}
+
+// parseOr returns the first truthy operand, or the last one; the
+// operands after a truthy one are not evaluated
+func (p *exprParser) parseOr() (interface{}, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept(tokOperator, "||") {
+		if truthy(left) {
+			if err := p.parseSkipped(p.parseAnd); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if left, err = p.parseAnd(); err != nil {
+			return nil, err
+		}
+	}
+	return left, nil
+}
+
+// parseAnd returns the first falsy operand, or the last one; the
+// operands after a falsy one are not evaluated
+func (p *exprParser) parseAnd() (interface{}, error) {
+	left, err := p.parseEquality()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept(tokOperator, "&&") {
+		if !truthy(left) {
+			if err := p.parseSkipped(p.parseEquality); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if left, err = p.parseEquality(); err != nil {
+			return nil, err
+		}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseEquality() (interface{}, error) {
+	left, err := p.parseComparison()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch {
+		case p.accept(tokOperator, "=="):
+			right, err := p.parseComparison()
+			if err != nil {
+				return nil, err
+			}
+			left = equal(left, right)
+		case p.accept(tokOperator, "!="):
+			right, err := p.parseComparison()
+			if err != nil {
+				return nil, err
+			}
+			left = !equal(left, right)
+		default:
+			return left, nil
+		}
+	}
+}
+
+func (p *exprParser) parseComparison() (interface{}, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok := p.peek()
+		if tok == nil || tok.kind != tokOperator {
+			return left, nil
+		}
+		switch tok.text {
+		case "<", "<=", ">", ">=":
+		default:
+			return left, nil
+		}
+		p.pos++
+
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = compare(left, right, tok.text)
+	}
+}
+
+func (p *exprParser) parseUnary() (interface{}, error) {
+	if p.accept(tokOperator, "!") {
+		v, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return !truthy(v), nil
+	}
+	return p.parsePostfix()
+}
+
+// parsePostfix parses an operand followed by .property and [index]
+// accesses, and .* object filters
+func (p *exprParser) parsePostfix() (interface{}, error) {
+	v, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch {
+		case p.accept(tokDot, "."):
+			if p.accept(tokStar, "*") {
+				v = filter(v)
+				continue
+			}
+			tok := p.peek()
+			if tok == nil || tok.kind != tokIdent {
+				return nil, fmt.Errorf("expected a property name or '*' after '.'")
+			}
+			p.pos++
+			v = mapFiltered(v, func(item interface{}) interface{} { return property(item, tok.text) })
+		case p.accept(tokLBracket, "["):
+			index, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(tokRBracket, "]"); err != nil {
+				return nil, err
+			}
+			v = mapFiltered(v, func(item interface{}) interface{} { return indexValue(item, index) })
+		default:
+			if items, ok := v.(filtered); ok {
+				return []interface{}(items), nil
+			}
+			return v, nil
+		}
+	}
+}
+
+func (p *exprParser) parseOperand() (interface{}, error) {
+	tok := p.peek()
+	if tok == nil {
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	p.pos++
+
+	switch tok.kind {
+	case tokString:
+		return tok.text, nil
+	case tokNumber:
+		n, err := parseNumber(tok.text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number '%s'", tok.text)
+		}
+		return n, nil
+	case tokLParen:
+		v, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(tokRParen, ")"); err != nil {
+			return nil, err
+		}
+		return v, nil
+	case tokIdent:
+		switch tok.text {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null":
+			return nil, nil
+		}
+		if p.accept(tokLParen, "(") {
+			return p.parseCall(tok.text)
+		}
+		return p.ctx.lookup(tok.text), nil
+	}
+
+	return nil, fmt.Errorf("unexpected '%s'", tok.text)
+}
+
+// parseCall parses the arguments of a function call, its name and '('
+// being consumed
+func (p *exprParser) parseCall(name string) (interface{}, error) {
+	var args []interface{}
+	if !p.accept(tokRParen, ")") {
+		for {
+			arg, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+			if p.accept(tokRParen, ")") {
+				break
+			}
+			if err := p.expect(tokComma, ","); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if p.skipping > 0 {
+		if !functions[strings.ToLower(name)] {
+			return nil, fmt.Errorf("unknown function %s()", name)
+		}
+		return nil, nil
+	}
+	return p.call(name, args)
+}
+
+func parseNumber(text string) (float64, error) {
+	lower := strings.ToLower(text)
+	if strings.HasPrefix(lower, "0x") {
+		n, err := strconv.ParseInt(lower[2:], 16, 64)
+		return float64(n), err
+	}
+	return strconv.ParseFloat(text, 64)
+}
@@ -0,0 +1,324 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/sanix-darker/git-ci/internal/config"
+	"github.com/sanix-darker/git-ci/pkg/types"
+	cli "github.com/urfave/cli/v2"
+)
+
+// artifactStore keeps the artifacts produced by each job of a run and
+// hands them to downstream jobs following the provider's rules
+type artifactStore struct {
+	dir      string
+	pipeline *types.Pipeline
+	dryRun   bool
+
+	mu        sync.Mutex
+	collected map[string]bool // Jobs whose artifacts are in the store
+}
+
+// newArtifactStore creates a store for a single pipeline run
+func newArtifactStore(runID string, pipeline *types.Pipeline, cfg *config.RunnerConfig) *artifactStore {
+	return &artifactStore{
+		dir:       filepath.Join(config.GetCacheDir(), "artifacts", runID),
+		pipeline:  pipeline,
+		dryRun:    cfg.DryRun,
+		collected: make(map[string]bool),
+	}
+}
+
+// upstreamJobs returns the jobs whose artifacts the given job receives.
+//
+// GitLab: an explicit `dependencies` list (even empty) names the jobs;
+// otherwise a job with `needs` receives those of the needed jobs, except
+// the ones with `artifacts: false`, and a job without every job from an
+// earlier stage.
+// Bitbucket: a step receives those of every earlier step of its pipeline,
+// unless it sets `artifacts: download: false` (kept in dependencies).
+// CircleCI: a job attaching the workspace receives what its upstream
+// jobs persisted to it (kept in dependencies).
+// GitHub: artifacts are only shared through actions/download-artifact,
+// so nothing is propagated implicitly.
+func (s *artifactStore) upstreamJobs(jobName string, job *types.Job) []string {
+	if s.pipeline.Provider == "bitbucket" || s.pipeline.Provider == "circleci" {
+		return job.Dependencies
+	}
+	if s.pipeline.Provider != "gitlab" {
+		return nil
+	}
+
+	if job.DependenciesSet {
+		return job.Dependencies
+	}
+
+	if job.HasNeeds() {
+		var upstream []string
+		for _, need := range job.Needs {
+			if need.Artifacts {
+				upstream = append(upstream, need.Job)
+			}
+		}
+		return upstream
+	}
+
+	stageIndex := make(map[string]int)
+	for i, stage := range s.pipeline.Stages {
+		stageIndex[stage] = i
+	}
+
+	current, ok := stageIndex[job.Stage]
+	if !ok {
+		return nil
+	}
+
+	var upstream []string
+	for name, other := range s.pipeline.Jobs {
+		if name == jobName || other.Artifacts == nil {
+			continue
+		}
+		if idx, ok := stageIndex[other.Stage]; ok && idx < current {
+			upstream = append(upstream, name)
+		}
+	}
+	sort.Strings(upstream)
+	return upstream
+}
+
+// restore copies upstream artifacts into the job workspace
+func (s *artifactStore) restore(c *cli.Context, jobName string, job *types.Job, workdir string) error {
+	if s == nil || s.dryRun {
+		return nil
+	}
+
+	var contributors []string
+	for _, upstream := range s.upstreamJobs(jobName, job) {
+		s.mu.Lock()
+		available := s.collected[upstream]
+		s.mu.Unlock()
+		if !available {
+			continue
+		}
+
+		if err := copyTree(filepath.Join(s.dir, upstream), workdir); err != nil {
+			return fmt.Errorf("failed to restore artifacts from job '%s': %w", upstream, err)
+		}
+		if err := s.loadDotenv(c, upstream, job); err != nil {
+			return err
+		}
+		contributors = append(contributors, upstream)
+	}
+
+	if len(contributors) > 0 {
+		printVerbose(c, "Artifacts for job '%s' received from: %s\n", jobName, strings.Join(contributors, ", "))
+	} else {
+		printVerbose(c, "Job '%s' receives no artifacts\n", jobName)
+	}
+
+	return nil
+}
+
+// collect stores the artifacts and reports declared by a job after it ran
+func (s *artifactStore) collect(c *cli.Context, jobName string, job *types.Job, workdir string, succeeded bool) error {
+	if s == nil || s.dryRun || job.Artifacts == nil {
+		return nil
+	}
+
+	// GitLab defaults to on_success
+	collectPaths := len(job.Artifacts.Paths) > 0
+	switch job.Artifacts.When {
+	case "on_failure":
+		collectPaths = collectPaths && !succeeded
+	case "always":
+	default:
+		collectPaths = collectPaths && succeeded
+	}
+
+	if !collectPaths && len(job.Artifacts.Reports) == 0 {
+		return nil
+	}
+
+	if collectPaths {
+		count, err := copyMatches(workdir, job.Artifacts.Paths, filepath.Join(s.dir, jobName))
+		if err != nil {
+			return fmt.Errorf("failed to store artifacts of job '%s': %w", jobName, err)
+		}
+		printVerbose(c, "Stored %d artifact path(s) from job '%s'\n", count, jobName)
+	}
+
+	// Reports are collected whatever the job result, as on GitLab
+	stored := 0
+	for _, report := range job.Artifacts.Reports {
+		count, err := copyMatches(workdir, report.Paths, s.reportsDir(jobName))
+		if err != nil {
+			return fmt.Errorf("failed to store %s report of job '%s': %w", report.Type, jobName, err)
+		}
+		if count == 0 {
+			printVerbose(c, "Warning: job '%s' produced no %s report (%s)\n", jobName, report.Type, strings.Join(report.Paths, ", "))
+			continue
+		}
+		stored++
+	}
+	if stored > 0 {
+		printVerbose(c, "Stored %d report(s) from job '%s' in %s\n", stored, jobName, s.reportsDir(jobName))
+	}
+
+	s.mu.Lock()
+	s.collected[jobName] = true
+	s.mu.Unlock()
+
+	return nil
+}
+
+// reportsDir returns where the reports of a job are stored, apart from
+// the artifacts handed to downstream jobs
+func (s *artifactStore) reportsDir(jobName string) string {
+	return filepath.Join(s.dir, ".reports", jobName)
+}
+
+// loadDotenv adds the variables of the dotenv reports of an upstream job
+// to the variables of job, as GitLab passes them along with artifacts
+func (s *artifactStore) loadDotenv(c *cli.Context, upstream string, job *types.Job) error {
+	upstreamJob := s.pipeline.Jobs[upstream]
+	if upstreamJob == nil || upstreamJob.Artifacts == nil {
+		return nil
+	}
+
+	for _, report := range upstreamJob.Artifacts.Reports {
+		if report.Type != types.ReportTypeDotenv {
+			continue
+		}
+		for _, pattern := range report.Paths {
+			matches, _ := filepath.Glob(filepath.Join(s.reportsDir(upstream), pattern))
+			for _, match := range matches {
+				vars, err := loadEnvFile(match)
+				if err != nil {
+					return fmt.Errorf("failed to load dotenv report of job '%s': %w", upstream, err)
+				}
+
+				// Copy the variables, jobs may share them
+				env := make(map[string]string, len(job.Environment)+len(vars))
+				for k, v := range job.Environment {
+					env[k] = v
+				}
+				for k, v := range vars {
+					env[k] = v
+				}
+				job.Environment = env
+
+				printVerbose(c, "Loaded %d variable(s) from the dotenv report of job '%s'\n", len(vars), upstream)
+			}
+		}
+	}
+	return nil
+}
+
+// borrow makes the artifacts a job stored in a previous run available to
+// this run, for jobs skipped with --from
+func (s *artifactStore) borrow(jobName string, job *types.Job, fromRun string) error {
+	if s == nil || s.dryRun || job.Artifacts == nil || (len(job.Artifacts.Paths) == 0 && len(job.Artifacts.Reports) == 0) {
+		return nil
+	}
+
+	src := filepath.Join(filepath.Dir(s.dir), fromRun, jobName)
+	reports := filepath.Join(filepath.Dir(s.dir), fromRun, ".reports", jobName)
+	if _, err := os.Stat(src); err != nil {
+		if _, reportsErr := os.Stat(reports); reportsErr != nil {
+			return fmt.Errorf("artifacts of job '%s' from run %s are not available in the store (%s)", jobName, fromRun, src)
+		}
+	}
+
+	if err := copyTree(src, filepath.Join(s.dir, jobName)); err != nil {
+		return fmt.Errorf("failed to borrow artifacts of job '%s' from run %s: %w", jobName, fromRun, err)
+	}
+	if err := copyTree(reports, s.reportsDir(jobName)); err != nil {
+		return fmt.Errorf("failed to borrow reports of job '%s' from run %s: %w", jobName, fromRun, err)
+	}
+
+	s.mu.Lock()
+	s.collected[jobName] = true
+	s.mu.Unlock()
+
+	return nil
+}
+
+// copyMatches copies the paths of workdir matching patterns into dest,
+// keeping their relative path, and returns how many it copied
+func copyMatches(workdir string, patterns []string, dest string) (int, error) {
+	count := 0
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(filepath.Join(workdir, pattern))
+		if err != nil {
+			return count, fmt.Errorf("invalid path '%s': %w", pattern, err)
+		}
+		for _, match := range matches {
+			rel, err := filepath.Rel(workdir, match)
+			if err != nil {
+				continue
+			}
+			if err := copyPath(match, filepath.Join(dest, rel)); err != nil {
+				return count, fmt.Errorf("failed to copy '%s': %w", rel, err)
+			}
+			count++
+		}
+	}
+	return count, nil
+}
+
+// copyTree copies the content of src into dst
+func copyTree(src, dst string) error {
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, entry := range entries {
+		if err := copyPath(filepath.Join(src, entry.Name()), filepath.Join(dst, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyPath copies a file or directory recursively
+func copyPath(src, dst string) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+
+	if info.IsDir() {
+		if err := os.MkdirAll(dst, info.Mode().Perm()); err != nil {
+			return err
+		}
+		return copyTree(src, dst)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	_, err = io.Copy(out, in)
+	return err
+}
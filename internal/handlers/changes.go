@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/sanix-darker/git-ci/internal/config"
+	cli "github.com/urfave/cli/v2"
+)
+
+// applyChanges computes the files the pipeline changes, which `changes:`
+// rules are matched against: the files changed from the base to HEAD and
+// those changed in the working tree. The base is --changes-base, the
+// target branch of --mr, else the upstream branch when HEAD is ahead of
+// it, else the previous commit. Without a base (no commit to compare to,
+// no git checkout) the changes are unknown and every `changes:` matches,
+// as for a new branch on GitLab.
+func applyChanges(c *cli.Context, cfg *config.RunnerConfig) error {
+	workdir := cfg.WorkDir
+	base := c.String("changes-base")
+	switch {
+	case base != "":
+		if gitOutput(workdir, "rev-parse", "--verify", "--quiet", base+"^{commit}") == "" {
+			return fmt.Errorf("changes base '%s' not found", base)
+		}
+	case cfg.MRTarget != "":
+		base = targetRef(workdir, cfg.MRTarget)
+	default:
+		base = defaultChangesBase(workdir)
+	}
+	if base == "" {
+		printVerbose(c, "No base to compare to, changes: rules always match\n")
+		return nil
+	}
+
+	seen := make(map[string]bool)
+	cfg.Changes = []string{}
+	for _, args := range [][]string{
+		{"diff", "--name-only", base + "...HEAD"},
+		{"diff", "--name-only", "HEAD"},
+		{"ls-files", "--others", "--exclude-standard"},
+	} {
+		for _, file := range strings.Split(gitOutput(workdir, args...), "\n") {
+			if file != "" && !seen[file] {
+				seen[file] = true
+				cfg.Changes = append(cfg.Changes, file)
+			}
+		}
+	}
+	sort.Strings(cfg.Changes)
+
+	printVerbose(c, "%d file(s) changed since %s\n", len(cfg.Changes), base)
+	for _, file := range cfg.Changes {
+		printVerbose(c, "  %s\n", file)
+	}
+	return nil
+}
+
+// defaultChangesBase returns the base changes are computed from without
+// --changes-base: the upstream branch when HEAD has commits it lacks, else
+// the previous commit, "" when there is none
+func defaultChangesBase(workdir string) string {
+	if upstream := gitOutput(workdir, "rev-parse", "--abbrev-ref", "@{upstream}"); upstream != "" {
+		if ahead, err := strconv.Atoi(gitOutput(workdir, "rev-list", "--count", upstream+"..HEAD")); err == nil && ahead > 0 {
+			return upstream
+		}
+	}
+	if gitOutput(workdir, "rev-parse", "--verify", "--quiet", "HEAD~1") != "" {
+		return "HEAD~1"
+	}
+	return ""
+}
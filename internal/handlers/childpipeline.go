@@ -0,0 +1,251 @@
+package handlers
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sanix-darker/git-ci/internal/config"
+	"github.com/sanix-darker/git-ci/internal/parsers"
+	"github.com/sanix-darker/git-ci/pkg/types"
+	cli "github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// maxChildPipelineDepth is the nesting GitLab allows below a parent
+// pipeline
+const maxChildPipelineDepth = 2
+
+// childPipelineRunner runs the child pipeline of a GitLab trigger job
+// (`trigger:include`) as a nested pipeline of the run. The child jobs
+// run with the runner flags of the parent run and their output is
+// indented under the trigger job.
+type childPipelineRunner struct {
+	c      *cli.Context
+	cfg    *config.RunnerConfig
+	parent *runState
+}
+
+// newChildPipelineRunner returns the runner of a trigger job of the run
+// tracked by state, which may be nil
+func newChildPipelineRunner(c *cli.Context, cfg *config.RunnerConfig, state *runState) (types.Runner, error) {
+	if state != nil && state.depth >= maxChildPipelineDepth {
+		return nil, fmt.Errorf("child pipelines can't be nested more than %d levels deep", maxChildPipelineDepth)
+	}
+	return &childPipelineRunner{c: c, cfg: cfg, parent: state}, nil
+}
+
+// RunJob runs the child pipeline. Its failure only fails the trigger job
+// with `strategy: depend`, as on GitLab.
+func (r *childPipelineRunner) RunJob(job *types.Job, workdir string) error {
+	fmt.Printf("Starting child pipeline of job '%s'\n", job.Name)
+
+	err := r.runChild(job, workdir)
+	switch {
+	case err == nil:
+		fmt.Printf("Child pipeline of job '%s' succeeded\n", job.Name)
+	case job.Trigger.Strategy == "depend":
+		return fmt.Errorf("child pipeline failed: %w", err)
+	default:
+		fmt.Printf("Warning: child pipeline of job '%s' failed: %v (ignored without `strategy: depend`)\n", job.Name, err)
+	}
+	return nil
+}
+
+// runChild parses and runs the child pipeline of job
+func (r *childPipelineRunner) runChild(job *types.Job, workdir string) error {
+	pipeline, err := parseChildPipeline(job.Trigger.Include, workdir, r.cfg)
+	if err != nil {
+		return err
+	}
+
+	// The child sees the forwarded variables as its pipeline variables
+	var parentVars map[string]string
+	if r.parent != nil && r.parent.pipeline != nil {
+		parentVars = r.parent.pipeline.Environment
+	}
+	yamlVars := make(map[string]string, len(parentVars)+len(job.Environment))
+	for _, vars := range []map[string]string{parentVars, job.Environment} {
+		for k, v := range vars {
+			yamlVars[k] = v
+		}
+	}
+
+	cfg := *r.cfg
+	cfg.Event = "parent_pipeline"
+	cfg.Environment = job.Trigger.ForwardedVariables(yamlVars, r.cfg.Environment)
+	cfg.Provider = pipeline.Provider
+	cfg.PipelineEnv = pipelineEnvironment(pipeline, &cfg)
+	state := newChildRunState(r.parent, job.Name, pipeline, &cfg)
+
+	prefix := "  │ "
+	if cfg.ASCII {
+		prefix = "  | "
+	}
+	restore := indentOutput(prefix)
+	defer restore()
+
+	created, err := applyWorkflowRules(r.c, pipeline, workdir, &cfg)
+	if err != nil {
+		return err
+	}
+	if !created {
+		return fmt.Errorf("child pipeline not created: filtered out by its workflow rules")
+	}
+
+	jobs := excludeNonDefaultJobs(r.c, pipeline.Jobs)
+	applyLocalHints(r.c, jobs)
+
+	jobs, err = applyRules(r.c, pipeline, jobs, workdir, &cfg, state)
+	if err != nil {
+		return err
+	}
+	if len(jobs) == 0 {
+		state.printSkipped()
+		return fmt.Errorf("no jobs to run")
+	}
+
+	if err := expandJobVariables(r.c, pipeline, jobs, &cfg); err != nil {
+		return err
+	}
+
+	removeSecrets, err := resolveJobSecrets(r.c, jobs, workdir, &cfg)
+	defer removeSecrets()
+	if err != nil {
+		return err
+	}
+	cfg.Secrets = append(cfg.Secrets, secretValues(pipeline, jobs, &cfg)...)
+
+	graph, err := newJobGraph(pipeline, jobs)
+	if err != nil {
+		return err
+	}
+
+	if r.c.Bool("parallel") {
+		return runJobsParallel(r.c, jobs, graph, workdir, &cfg, state)
+	}
+	return runJobsSequential(r.c, jobs, graph, workdir, &cfg, state)
+}
+
+// RunStep is not supported: a child pipeline has no steps of its own
+func (r *childPipelineRunner) RunStep(step *types.Step, env map[string]string, workdir string) error {
+	return fmt.Errorf("a trigger job has no steps")
+}
+
+// Cleanup has nothing to clean, the child jobs clean up after themselves
+func (r *childPipelineRunner) Cleanup() error {
+	return nil
+}
+
+// GetRunnerType returns the runner type
+func (r *childPipelineRunner) GetRunnerType() types.RunnerType {
+	return types.RunnerTypePipeline
+}
+
+// parseChildPipeline parses the files of a child pipeline as one
+// configuration: a temporary root file in the project includes them, so
+// their own local includes resolve from the project root. Artifact files
+// were restored in workdir with the artifacts of the generating job.
+func parseChildPipeline(includes []types.TriggerInclude, workdir string, cfg *config.RunnerConfig) (*types.Pipeline, error) {
+	var entries []map[string]string
+	for _, inc := range includes {
+		switch {
+		case inc.Local != "":
+			path := strings.TrimPrefix(inc.Local, "/")
+			if _, err := os.Stat(filepath.Join(workdir, path)); err != nil {
+				return nil, fmt.Errorf("child pipeline file not found: %s", inc.Local)
+			}
+			entries = append(entries, map[string]string{"local": path})
+		case inc.Artifact != "":
+			if _, err := os.Stat(filepath.Join(workdir, inc.Artifact)); err != nil {
+				return nil, fmt.Errorf("child pipeline file %s not found in the artifacts of job '%s'", inc.Artifact, inc.Job)
+			}
+			entries = append(entries, map[string]string{"local": inc.Artifact})
+		case inc.Template != "":
+			entries = append(entries, map[string]string{"template": inc.Template})
+		case inc.Project != "":
+			return nil, fmt.Errorf("child pipeline files of project %s can't be run locally", inc.Project)
+		}
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("no child pipeline file to include")
+	}
+
+	data, err := yaml.Marshal(map[string]interface{}{"include": entries})
+	if err != nil {
+		return nil, err
+	}
+
+	root, err := os.CreateTemp(workdir, ".git-ci-child-*.yml")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the child pipeline file: %w", err)
+	}
+	defer os.Remove(root.Name())
+
+	_, err = root.Write(data)
+	if closeErr := root.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to write the child pipeline file: %w", err)
+	}
+
+	parser := parsers.NewGitlabParser()
+	cleanup, err := configureParser(parser, root.Name(), cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+	pipeline, err := parser.Parse(root.Name())
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse child pipeline: %w", err)
+	}
+	return pipeline, nil
+}
+
+// indentOutput prefixes each line written to stdout and stderr until the
+// returned function is called. The streams are process-wide: with
+// --parallel, jobs running beside the child pipeline are indented too.
+func indentOutput(prefix string) (restore func()) {
+	restoreStdout := indentStream(&os.Stdout, prefix)
+	restoreStderr := indentStream(&os.Stderr, prefix)
+	return func() {
+		restoreStderr()
+		restoreStdout()
+	}
+}
+
+// indentStream replaces *stream with a pipe copying its lines to the
+// original stream with prefix
+func indentStream(stream **os.File, prefix string) (restore func()) {
+	original := *stream
+	r, w, err := os.Pipe()
+	if err != nil {
+		return func() {}
+	}
+	*stream = w
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		reader := bufio.NewReader(r)
+		for {
+			line, err := reader.ReadString('\n')
+			if line != "" {
+				fmt.Fprint(original, prefix+line)
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	return func() {
+		*stream = original
+		w.Close()
+		<-done
+		r.Close()
+	}
+}
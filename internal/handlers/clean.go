@@ -22,18 +22,32 @@ func CmdClean(c *cli.Context) error {
 	images := c.Bool("images") || all
    // TODO: handle pod cleaning too, if needed
 	cache := c.Bool("cache") || all
+	stale := c.Bool("stale") || all
 	force := c.Bool("force")
 
-	if !containers && !images && !cache {
+	if !containers && !images && !cache && !stale {
 		fmt.Println("Nothing to clean. Use --all or specify what to clean.")
 		return nil
 	}
 
 	fmt.Println("Cleaning up resources...")
 
+	// Leftovers of crashed runs go first, their records need the cache
+	if stale {
+		workdir, err := getWorkdir(c)
+		if err != nil {
+			return err
+		}
+		if err := cleanStale(workdir); err != nil {
+			return fmt.Errorf("failed to clean stale runs: %w", err)
+		}
+	}
+
 	// Clean Docker resources if Docker is available
-	if err := cleanDockerResources(containers, images, force); err != nil {
-		printVerbose(c, "Warning: Docker cleanup failed: %v\n", err)
+	if containers || images {
+		if err := cleanDockerResources(containers, images, force); err != nil {
+			printVerbose(c, "Warning: Docker cleanup failed: %v\n", err)
+		}
 	}
 
 	// Clean cache
@@ -43,7 +57,7 @@ func CmdClean(c *cli.Context) error {
 		}
 	}
 
-	fmt.Println("✓ Cleanup completed")
+	fmt.Printf("%s Cleanup completed\n", okMark(c))
 	return nil
 }
 
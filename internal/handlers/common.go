@@ -8,12 +8,14 @@ import (
 
 	"github.com/sanix-darker/git-ci/internal/config"
 	"github.com/sanix-darker/git-ci/internal/parsers"
+	"github.com/sanix-darker/git-ci/internal/runners"
 	"github.com/sanix-darker/git-ci/pkg/types"
 	cli "github.com/urfave/cli/v2"
 )
 
-// parseInput parses the workflow file with auto-detection
-func parseInput(workflowFile string) (*types.Pipeline, error) {
+// parseInput parses the workflow file with auto-detection.
+// cfg may be nil, in which case parser defaults are used.
+func parseInput(workflowFile string, cfg *config.RunnerConfig) (*types.Pipeline, error) {
 	// Auto-detect parser based on file path
 	var parser types.Parser
 
@@ -21,10 +23,10 @@ func parseInput(workflowFile string) (*types.Pipeline, error) {
 		// Try to auto-detect workflow file
 		if _, err := os.Stat(".github/workflows/ci.yml"); err == nil {
 			workflowFile = ".github/workflows/ci.yml"
-			parser = &parsers.GithubParser{}
+			parser = parsers.NewGithubParser()
 		} else if _, err := os.Stat(".gitlab-ci.yml"); err == nil {
 			workflowFile = ".gitlab-ci.yml"
-			parser = &parsers.GitlabParser{}
+			parser = parsers.NewGitlabParser()
 		} else {
 			// Try to find any workflow file
 			patterns := []string{
@@ -35,6 +37,7 @@ func parseInput(workflowFile string) (*types.Pipeline, error) {
 				"bitbucket-pipelines.yml",
 				"azure-pipelines.yml",
 				".circleci/config.yml",
+				".circleci/config.yaml",
 			}
 
 			for _, pattern := range patterns {
@@ -56,6 +59,12 @@ func parseInput(workflowFile string) (*types.Pipeline, error) {
 		parser = detectParser(workflowFile)
 	}
 
+	cleanup, err := configureParser(parser, workflowFile, cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+
 	pipeline, err := parser.Parse(workflowFile)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse workflow: %w", err)
@@ -64,24 +73,68 @@ func parseInput(workflowFile string) (*types.Pipeline, error) {
 	return pipeline, nil
 }
 
+// configureParser applies the run configuration to the parser of
+// workflowFile. The returned function removes what the parse needed
+// only, once it is done.
+func configureParser(parser types.Parser, workflowFile string, cfg *config.RunnerConfig) (func(), error) {
+	cleanup := func() {}
+
+	// Bypass parse caches when the policy says so
+	if gl, ok := parser.(*parsers.GitlabParser); ok {
+		gl.SetNoCache(!cfg.CacheEnabled(config.CacheKindParse))
+		gl.SetRemoteCacheDir(filepath.Join(config.GetCacheDir(), "includes"))
+		gl.SetOffline(cfg != nil && cfg.Offline)
+		gl.SetMergeAnchors(cfg != nil && cfg.YAMLAnchors)
+		gl.SetStrict(cfg != nil && cfg.Strict)
+		gl.SetVariables(includeVariables(workflowFile, cfg))
+	}
+
+	// Remote reusable workflows share the checkouts of remote actions,
+	// fetched for the parse only without the metadata cache, as the
+	// runners do with actions
+	if gh, ok := parser.(*parsers.GithubParser); ok {
+		noFetch := cfg != nil && cfg.NoFetch
+		switch {
+		case cfg.CacheEnabled(config.CacheKindMetadata):
+			gh.SetRemoteCacheDir(filepath.Join(config.GetCacheDir(), "actions"))
+		case !noFetch:
+			dir, err := os.MkdirTemp("", "git-ci-workflows-")
+			if err != nil {
+				return nil, fmt.Errorf("failed to create the reusable workflow directory: %w", err)
+			}
+			gh.SetRemoteCacheDir(dir)
+			cleanup = func() { os.RemoveAll(dir) }
+		}
+		gh.SetOffline(cfg != nil && cfg.Offline)
+		gh.SetCachedOnly(noFetch)
+	}
+
+	// The checkout decides which Bitbucket pipeline runs by default
+	if bb, ok := parser.(*parsers.BitbucketParser); ok {
+		bb.SetVariables(includeVariables(workflowFile, cfg))
+	}
+	return cleanup, nil
+}
+
 // detectParser detects the appropriate parser based on file path
 func detectParser(filePath string) types.Parser {
 	dir := filepath.Dir(filePath)
 	base := filepath.Base(filePath)
 
 	if strings.Contains(dir, ".github/workflows") || strings.Contains(base, "github") {
-		return &parsers.GithubParser{}
+		return parsers.NewGithubParser()
 	} else if strings.Contains(base, "gitlab") || base == ".gitlab-ci.yml" || base == ".gitlab-ci.yaml" {
-		return &parsers.GitlabParser{}
+		return parsers.NewGitlabParser()
+	} else if strings.Contains(dir, ".circleci") || strings.Contains(base, "circleci") {
+		return parsers.NewCircleCIParser()
 	} else if strings.Contains(base, "bitbucket") {
-		// return &parsers.BitbucketParser{} // If implemented
-		return &parsers.GithubParser{} // Fallback
+		return parsers.NewBitbucketParser()
 	} else if strings.Contains(base, "azure") {
 		// return &parsers.AzureParser{} // If implemented
-		return &parsers.GithubParser{} // Fallback
+		return parsers.NewGithubParser() // Fallback
 	} else {
 		// Default to GitHub parser
-		return &parsers.GithubParser{}
+		return parsers.NewGithubParser()
 	}
 }
 
@@ -119,6 +172,17 @@ func buildRunnerConfig(c *cli.Context) *config.RunnerConfig {
 	cfg.DryRun = c.Bool("dry-run")
 	cfg.PullImages = c.Bool("pull")
 	cfg.Timeout = c.Int("timeout")
+	cfg.Quiet = c.Bool("quiet")
+	cfg.Parallel = c.Bool("parallel") && c.Int("max-parallel") != 1
+	cfg.Theme = c.String("theme")
+	cfg.ASCII = c.Bool("ascii")
+	cfg.NoColor = c.Bool("no-color") || !runners.ColorEnabled()
+	cfg.Offline = c.Bool("offline")
+	cfg.YAMLAnchors = c.Bool("merge-yaml-anchors")
+	cfg.Event = c.String("event")
+	if c.IsSet("heartbeat") {
+		cfg.Heartbeat = c.Duration("heartbeat")
+	}
 
 	// Set working directory
 	if workdir, err := getWorkdir(c); err == nil {
@@ -128,20 +192,74 @@ func buildRunnerConfig(c *cli.Context) *config.RunnerConfig {
 	// Parse environment variables
 	cfg.Environment = parseEnvironmentVars(c)
 
-	// FIXME: commenting out those for now
-	//// Parse volumes
-	//if volumes := c.StringSlice("volume"); len(volumes) > 0 {
-	//	cfg.Volumes = volumes
-	//}
+	// Resolve which caches may be used
+	cfg.Cache = resolveCachePolicy(c)
+	cfg.NoCache = len(cfg.Cache.Disabled()) > 0
+
+	// Jobs run in the checkout rather than in workspaces of their own
+	cfg.NoWorkspace = c.Bool("no-workspace")
+
+	// Bind mounts of the job containers
+	cfg.Volumes = c.StringSlice("volume")
+
+	// Registry credentials of the configuration file
+	cfg.Registry, cfg.RegistryAuth = registryCredentials(c)
+
+	// Set network
+	if network := c.String("network"); network != "" {
+		cfg.Network = network
+	}
+
+	// Remote host for the SSH runner
+	cfg.SSHHost = c.String("ssh")
+	cfg.SSHKey = c.String("ssh-key")
+	cfg.SSHInsecure = c.Bool("ssh-insecure")
 
-	//// Set network
-	//if network := c.String("network"); network != "" {
-	//	cfg.Network = network
-	//}
+	// Local values of the job secrets
+	cfg.SecretsFile = c.String("secrets-file")
 
 	return cfg
 }
 
+// registryCredentials returns the registry and the credentials of the
+// docker section of the configuration file
+func registryCredentials(c *cli.Context) (string, map[string]string) {
+	configFile := c.String("config")
+	if configFile == "" {
+		configFile = findConfigFile()
+	}
+	if configFile == "" {
+		return "", nil
+	}
+	fileConfig, err := loadConfig(configFile)
+	if err != nil {
+		return "", nil
+	}
+	return fileConfig.Docker.Registry, fileConfig.Docker.Auth
+}
+
+// resolveCachePolicy builds the cache policy from the config file and the
+// --no-cache flag. The flag always wins over the config file.
+func resolveCachePolicy(c *cli.Context) *config.CachePolicy {
+	policy := config.NewCachePolicy()
+
+	configFile := c.String("config")
+	if configFile == "" {
+		configFile = findConfigFile()
+	}
+	if configFile != "" {
+		if fileConfig, err := loadConfig(configFile); err == nil {
+			policy = fileConfig.Cache.Policy()
+		}
+	}
+
+	if flag, ok := c.Generic("no-cache").(*config.NoCacheFlag); ok && flag != nil {
+		policy.ApplyNoCache(flag.Mode)
+	}
+
+	return policy
+}
+
 // parseEnvironmentVars parses environment variables from context
 func parseEnvironmentVars(c *cli.Context) map[string]string {
 	env := make(map[string]string)
@@ -163,9 +281,35 @@ func parseEnvironmentVars(c *cli.Context) map[string]string {
 		}
 	}
 
+	// Add from --var flags
+	for _, v := range c.StringSlice("var") {
+		parts := strings.SplitN(v, "=", 2)
+		if len(parts) == 2 {
+			env[parts[0]] = parts[1]
+		}
+	}
+
 	return env
 }
 
+// checkPipelineVars validates --var values against the options of the
+// pipeline variables they set
+func checkPipelineVars(c *cli.Context, pipeline *types.Pipeline) error {
+	for _, v := range c.StringSlice("var") {
+		parts := strings.SplitN(v, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return fmt.Errorf("invalid --var '%s' (expected KEY=VALUE)", v)
+		}
+
+		variable := pipeline.Variables[parts[0]]
+		if !variable.Allows(parts[1]) {
+			return fmt.Errorf("invalid value '%s' for variable %s (options: %s)",
+				parts[1], parts[0], strings.Join(variable.Options, ", "))
+		}
+	}
+	return nil
+}
+
 // loadEnvFile loads environment variables from a file
 func loadEnvFile(filename string) (map[string]string, error) {
 	env := make(map[string]string)
@@ -277,6 +421,11 @@ func printVerbose(c *cli.Context, format string, args ...interface{}) {
 	}
 }
 
+// okMark returns the success symbol, in ASCII with --ascii
+func okMark(c *cli.Context) string {
+	return runners.GlyphText(runners.GlyphOK, c.Bool("ascii"))
+}
+
 // printDebug prints message if debug mode is enabled
 func printDebug(c *cli.Context, format string, args ...interface{}) {
 	if c.Bool("debug") {
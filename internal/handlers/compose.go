@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/sanix-darker/git-ci/internal/config"
+	"github.com/sanix-darker/git-ci/internal/runners"
+	"github.com/sanix-darker/git-ci/pkg/types"
+	cli "github.com/urfave/cli/v2"
+)
+
+// composeProjectInvalid matches the characters compose project names
+// can't hold
+var composeProjectInvalid = regexp.MustCompile(`[^a-z0-9_-]+`)
+
+// startCompose brings up the --compose stack before the first job and
+// makes job containers join its network
+func startCompose(c *cli.Context, jobs map[string]*types.Job, cfg *config.RunnerConfig, state *runState) error {
+	file := c.String("compose")
+	if file == "" {
+		return nil
+	}
+	if cfg.DryRun {
+		fmt.Printf("Would start compose stack %s\n", file)
+		return nil
+	}
+
+	project := "git-ci-" + composeProjectInvalid.ReplaceAllString(strings.ToLower(state.id), "-")
+	stack, err := runners.NewComposeStack(file, project, cfg)
+	if err != nil {
+		return err
+	}
+
+	// Registered before Up so a half started stack is removed too
+	state.compose = stack
+	state.keepCompose = c.Bool("keep-services")
+
+	if err := stack.Up(0); err != nil {
+		return err
+	}
+	if stack.Network != "" {
+		cfg.Network = stack.Network
+	}
+
+	services, err := stack.Services()
+	if err != nil {
+		printVerbose(c, "Warning: failed to list compose services: %v\n", err)
+		return nil
+	}
+	warnServiceConflicts(jobs, services)
+
+	return nil
+}
+
+// warnServiceConflicts reports job services named like a compose service;
+// the job's own service wins
+func warnServiceConflicts(jobs map[string]*types.Job, services []string) {
+	compose := make(map[string]bool, len(services))
+	for _, name := range services {
+		compose[name] = true
+	}
+
+	names := make([]string, 0, len(jobs))
+	for name := range jobs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		for key, svc := range jobs[name].Services {
+			service := key
+			if svc.Alias != "" {
+				service = svc.Alias
+			}
+			if compose[service] {
+				fmt.Printf("Warning: job '%s' service '%s' takes precedence over the compose service of the same name\n", name, service)
+			}
+		}
+	}
+}
+
+// stopCompose tears the compose stack down at the end of the run, unless
+// --keep-services asked to keep it
+func (s *runState) stopCompose() {
+	if s == nil || s.compose == nil {
+		return
+	}
+	stack := s.compose
+	s.compose = nil
+
+	if s.keepCompose {
+		fmt.Printf("Keeping compose stack %s (remove it with: docker compose -p %s down -v)\n", stack.Project, stack.Project)
+		return
+	}
+	if err := stack.Down(); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+}
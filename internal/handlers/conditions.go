@@ -0,0 +1,274 @@
+package handlers
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/sanix-darker/git-ci/internal/config"
+	"github.com/sanix-darker/git-ci/internal/expressions"
+	"github.com/sanix-darker/git-ci/internal/runners"
+	"github.com/sanix-darker/git-ci/pkg/types"
+	cli "github.com/urfave/cli/v2"
+)
+
+// jobResults are the results of the jobs done so far (success, failure
+// or skipped), which the needs context of job conditions reads
+type jobResults map[string]string
+
+// finished records the result of a job that ran; a job allowed to fail
+// succeeds for the jobs needing it
+func (r jobResults) finished(name string, job *types.Job, err error) {
+	if err != nil && !allowedToFail(job, err) {
+		r[name] = "failure"
+		return
+	}
+	r[name] = "success"
+}
+
+// allows evaluates the `if:` of a job, reporting whether it runs. Its
+// status functions read the jobs upstream: success() holds while none
+// failed, and a condition without status function doesn't run the job
+// after an upstream failure.
+func (r jobResults) allows(name string, jobs map[string]*types.Job, upstreamFailed bool, cfg *config.RunnerConfig) (bool, error) {
+	job := jobs[name]
+	ctx := r.context(name, jobs, cfg)
+
+	status := expressions.StatusSuccess
+	if upstreamFailed {
+		status = expressions.StatusFailure
+	}
+	return expressions.EvalCondition(job.If, ctx, status)
+}
+
+// interpolate resolves the `${{ }}` expressions of the variables, the
+// container and the services of a GitHub job before it runs. It reports
+// the expressions that didn't resolve, expanded to nothing, in verbose
+// mode.
+func (r jobResults) interpolate(c *cli.Context, name string, jobs map[string]*types.Job, cfg *config.RunnerConfig) {
+	job := jobs[name]
+	if cfg.Provider != "github" {
+		return
+	}
+
+	ctx := r.context(name, jobs, cfg)
+	var unresolved []string
+	expand := func(value string) string {
+		expanded, failed := expressions.Interpolate(value, ctx, expressions.StatusSuccess)
+		unresolved = append(unresolved, failed...)
+		return expanded
+	}
+	expandValues := func(values map[string]string) map[string]string {
+		if len(values) == 0 {
+			return values
+		}
+		expanded := make(map[string]string, len(values))
+		for k, v := range values {
+			expanded[k] = expand(v)
+		}
+		return expanded
+	}
+
+	job.Environment = expandValues(job.Environment)
+
+	if job.Container != nil {
+		container := *job.Container
+		container.Image = expand(container.Image)
+		container.Options = expand(container.Options)
+		container.Env = expandValues(container.Env)
+		container.Credentials = expandValues(container.Credentials)
+		job.Container = &container
+	}
+
+	if len(job.Services) > 0 {
+		services := make(map[string]*types.Service, len(job.Services))
+		for id, svc := range job.Services {
+			service := *svc
+			service.Image = expand(service.Image)
+			service.Options = expand(service.Options)
+			service.Env = expandValues(service.Env)
+			services[id] = &service
+		}
+		job.Services = services
+	}
+
+	for _, expr := range unresolved {
+		printVerbose(c, "Unresolved expression '${{ %s }}' in job '%s', expanded to an empty string\n", expr, name)
+	}
+}
+
+// contextSetter is implemented by runners evaluating the expressions of
+// the steps of a job, which read contexts of the run
+type contextSetter interface {
+	SetContexts(ctx expressions.Context)
+}
+
+// runContexts returns the contexts of the run the steps of a job read:
+// needs, and the inputs and secrets of a called workflow
+func (r jobResults) runContexts(name string, jobs map[string]*types.Job, cfg *config.RunnerConfig) expressions.Context {
+	ctx := r.context(name, jobs, cfg)
+	run := expressions.Context{"needs": ctx["needs"]}
+	if jobs[name].WorkflowCall != nil {
+		run["inputs"] = ctx["inputs"]
+		run["secrets"] = ctx["secrets"]
+	}
+	return run
+}
+
+// setRunContexts hands the contexts of the run to the runner of a job
+func setRunContexts(runner types.Runner, ctx expressions.Context) {
+	if setter, ok := runner.(contextSetter); ok {
+		setter.SetContexts(ctx)
+	}
+}
+
+// context returns the contexts of the expressions of a job: those of its
+// variables, needs, and for the jobs of a called workflow the inputs and
+// secrets of the call
+func (r jobResults) context(name string, jobs map[string]*types.Job, cfg *config.RunnerConfig) expressions.Context {
+	job := jobs[name]
+	env, err := runners.ExpandEnv(runners.JobVariables(job, cfg), nil, cfg.Provider)
+	if err != nil {
+		env = runners.JobVariables(job, cfg)
+	}
+	ctx := runners.ExpressionContext(env, cfg)
+	ctx["needs"] = r.jobsContext(job.NeedNames(), callPrefix(name, job), jobs)
+	if job.WorkflowCall != nil {
+		ctx["inputs"], ctx["secrets"] = workflowCallContext(job.WorkflowCall, ctx)
+	}
+	return ctx
+}
+
+// callPrefix returns the prefix of the ids of the jobs of the workflow a
+// job belongs to: "caller / " for the jobs of a called workflow, empty
+// for the jobs of the run workflow
+func callPrefix(name string, job *types.Job) string {
+	if job.WorkflowCall == nil {
+		return ""
+	}
+	if job.MatrixOf != "" {
+		name = job.MatrixOf
+	}
+	i := strings.LastIndex(name, types.CallSeparator)
+	if i < 0 {
+		return ""
+	}
+	return name[:i+len(types.CallSeparator)]
+}
+
+// jobsContext returns the result and outputs of jobs by the id they have
+// in the workflow of prefix, as the needs and jobs contexts read them:
+// the variants of a matrix job or the jobs of a called workflow are one
+// job, failed when one of them failed. The outputs of variants are
+// merged in order, those of a call are the outputs its workflow declares.
+func (r jobResults) jobsContext(names []string, prefix string, jobs map[string]*types.Job) map[string]interface{} {
+	context := make(map[string]interface{})
+	for _, name := range names {
+		upstream := jobs[name]
+		if upstream == nil {
+			continue
+		}
+
+		id := name
+		if upstream.MatrixOf != "" {
+			id = upstream.MatrixOf
+		}
+		// The needs of a caller reach the jobs of the workflow it calls
+		base := ""
+		if strings.HasPrefix(id, prefix) {
+			base, id = prefix, id[len(prefix):]
+		}
+		var call *types.WorkflowCall
+		if upstream.WorkflowCall != nil {
+			var nested bool
+			if id, _, nested = strings.Cut(id, types.CallSeparator); nested {
+				call = outerCall(upstream.WorkflowCall, strings.Count(base, types.CallSeparator))
+			}
+		}
+
+		result := r[name]
+		if result == "" {
+			result = "skipped"
+		}
+		entry, ok := context[id].(map[string]interface{})
+		if !ok {
+			entry = map[string]interface{}{"result": result, "outputs": map[string]interface{}{}}
+			if call != nil {
+				entry["outputs"] = r.workflowOutputs(call, base+id, jobs)
+			}
+			context[id] = entry
+		}
+		if result == "failure" || (result == "success" && entry["result"] == "skipped") {
+			entry["result"] = result
+		}
+		if call == nil {
+			outputs := entry["outputs"].(map[string]interface{})
+			for k, v := range upstream.Outputs {
+				outputs[k] = v
+			}
+		}
+	}
+	return context
+}
+
+// outerCall returns the call at a nesting depth of the chain ending with
+// call, 0 being the call of the run workflow
+func outerCall(call *types.WorkflowCall, depth int) *types.WorkflowCall {
+	var chain []*types.WorkflowCall
+	for ; call != nil; call = call.Caller {
+		chain = append([]*types.WorkflowCall{call}, chain...)
+	}
+	if depth >= len(chain) {
+		return nil
+	}
+	return chain[depth]
+}
+
+// workflowOutputs returns the outputs of a call to a reusable workflow,
+// its declared value expressions resolved with the jobs context of the
+// called workflow, whose jobs are keyed "caller / job"
+func (r jobResults) workflowOutputs(call *types.WorkflowCall, caller string, jobs map[string]*types.Job) map[string]interface{} {
+	var names []string
+	for name := range jobs {
+		if strings.HasPrefix(name, caller+types.CallSeparator) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	ctx := expressions.Context{"jobs": r.jobsContext(names, caller+types.CallSeparator, jobs)}
+	outputs := make(map[string]interface{}, len(call.Outputs))
+	for name, value := range call.Outputs {
+		outputs[name], _ = expressions.Interpolate(value, ctx, expressions.StatusSuccess)
+	}
+	return outputs
+}
+
+// workflowCallContext returns the inputs and secrets contexts of the jobs
+// of a called workflow: the inputs and `secrets:` of the call, resolved
+// with the contexts of its caller, or all of the caller's secrets with
+// `secrets: inherit`
+func workflowCallContext(call *types.WorkflowCall, ctx expressions.Context) (inputs, secrets map[string]interface{}) {
+	caller := ctx
+	if call.Caller != nil {
+		caller = make(expressions.Context, len(ctx))
+		for k, v := range ctx {
+			caller[k] = v
+		}
+		caller["inputs"], caller["secrets"] = workflowCallContext(call.Caller, ctx)
+	}
+
+	inputs = make(map[string]interface{}, len(call.Inputs))
+	for name, value := range call.Inputs {
+		inputs[name], _ = expressions.Interpolate(value, caller, expressions.StatusSuccess)
+	}
+
+	if call.InheritSecrets {
+		secrets, _ = caller["secrets"].(map[string]interface{})
+		return inputs, secrets
+	}
+	secrets = make(map[string]interface{}, len(call.Secrets))
+	for name, value := range call.Secrets {
+		secrets[name], _ = expressions.Interpolate(value, caller, expressions.StatusSuccess)
+	}
+	return inputs, secrets
+}
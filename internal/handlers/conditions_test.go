@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sanix-darker/git-ci/internal/config"
+	"github.com/sanix-darker/git-ci/internal/parsers"
+)
+
+func TestNeedsOutputsOfMatrixJobFollowMatrixOrder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ci.yml")
+	if err := os.WriteFile(path, []byte(`
+name: CI
+on: push
+jobs:
+  test:
+    runs-on: ubuntu-latest
+    strategy:
+      matrix:
+        go: ["1.22", "1.20", "1.21"]
+    outputs:
+      version: ${{ matrix.go }}
+    steps:
+      - run: echo test
+  deploy:
+    runs-on: ubuntu-latest
+    needs: test
+    steps:
+      - run: echo deploy
+`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	pipeline, err := parsers.NewGithubParser().Parse(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.DefaultConfig()
+	cfg.Provider = "github"
+
+	// Whatever order the combinations finish in, the last one of the
+	// matrix sets the output
+	for _, finished := range [][]string{
+		{"test (1.22)", "test (1.20)", "test (1.21)"},
+		{"test (1.21)", "test (1.20)", "test (1.22)"},
+	} {
+		results := jobResults{}
+		for _, name := range finished {
+			job := pipeline.Jobs[name]
+			if job == nil {
+				t.Fatalf("no job %s in %v", name, pipeline.Jobs)
+			}
+			job.Outputs = map[string]string{"version": job.Name[len("test (") : len(job.Name)-1]}
+			results.finished(name, job, nil)
+		}
+
+		needs := results.runContexts("deploy", pipeline.Jobs, cfg)["needs"].(map[string]interface{})
+		test := needs["test"].(map[string]interface{})
+		if got := test["outputs"].(map[string]interface{})["version"]; got != "1.21" {
+			t.Errorf("finished %v: needs.test.outputs.version = %v, want 1.21", finished, got)
+		}
+		if test["result"] != "success" {
+			t.Errorf("finished %v: needs.test.result = %v", finished, test["result"])
+		}
+	}
+}
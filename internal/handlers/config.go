@@ -6,6 +6,7 @@ import (
 	"path/filepath"
 	"strings"
 
+	"github.com/sanix-darker/git-ci/internal/config"
 	cli "github.com/urfave/cli/v2"
 	yaml "gopkg.in/yaml.v3"
 )
@@ -28,6 +29,8 @@ type DefaultsConfig struct {
 	MaxParallel     int    `yaml:"max_parallel,omitempty"`
 	ContinueOnError bool   `yaml:"continue_on_error,omitempty"`
 	Verbose         bool   `yaml:"verbose,omitempty"`
+	Theme           string `yaml:"theme,omitempty"`
+	ASCII           bool   `yaml:"ascii,omitempty"`
 }
 
 // DockerConfig represents Docker-specific configuration
@@ -41,9 +44,38 @@ type DockerConfig struct {
 
 // CacheConfig represents cache configuration
 type CacheConfig struct {
-	Enabled bool     `yaml:"enabled,omitempty"`
+	Enabled *bool    `yaml:"enabled,omitempty"`
 	Paths   []string `yaml:"paths,omitempty"`
 	Key     string   `yaml:"key,omitempty"`
+
+	// Per-kind toggles, applied after Enabled
+	Job      *bool `yaml:"job,omitempty"`
+	Parse    *bool `yaml:"parse,omitempty"`
+	Metadata *bool `yaml:"metadata,omitempty"`
+	Image    *bool `yaml:"image,omitempty"`
+}
+
+// Policy converts the cache configuration into a runner cache policy
+func (cc CacheConfig) Policy() *config.CachePolicy {
+	policy := config.NewCachePolicy()
+
+	if cc.Enabled != nil && !*cc.Enabled {
+		policy.SetAll(false)
+	}
+
+	toggles := map[config.CacheKind]*bool{
+		config.CacheKindJob:      cc.Job,
+		config.CacheKindParse:    cc.Parse,
+		config.CacheKindMetadata: cc.Metadata,
+		config.CacheKindImage:    cc.Image,
+	}
+	for kind, enabled := range toggles {
+		if enabled != nil {
+			policy.Set(kind, *enabled)
+		}
+	}
+
+	return policy
 }
 
 // ArtifactsConfig represents artifacts configuration
@@ -123,7 +155,7 @@ func CmdConfigInit(c *cli.Context) error {
 		return fmt.Errorf("failed to write configuration file: %w", err)
 	}
 
-	fmt.Printf("✓ Created configuration file: %s\n", configFile)
+	fmt.Printf("%s Created configuration file: %s\n", okMark(c), configFile)
 	fmt.Println("\nYou can now customize the configuration and run:")
 	fmt.Printf("  git-ci run --config %s\n", configFile)
 
@@ -216,7 +248,7 @@ func createDefaultConfig() *GitCIConfig {
 			Volumes: []string{},
 		},
 		Cache: CacheConfig{
-			Enabled: true,
+			Enabled: boolPtr(true),
 			Paths: []string{
 				"node_modules",
 				".cache",
@@ -265,11 +297,23 @@ func applyConfigToContext(c *cli.Context, config *GitCIConfig) {
 		c.Set("verbose", "true")
 	}
 
+	if !c.IsSet("theme") && config.Defaults.Theme != "" {
+		c.Set("theme", config.Defaults.Theme)
+	}
+
+	if !c.IsSet("ascii") && config.Defaults.ASCII {
+		c.Set("ascii", "true")
+	}
+
 	// Apply Docker configuration
 	if !c.IsSet("docker") && config.Defaults.Runner == "docker" {
 		c.Set("docker", "true")
 	}
 
+	if !c.IsSet("podman") && config.Defaults.Runner == "podman" {
+		c.Set("podman", "true")
+	}
+
 	if !c.IsSet("pull") && config.Docker.Pull {
 		c.Set("pull", "true")
 	}
@@ -292,3 +336,8 @@ func applyConfigToContext(c *cli.Context, config *GitCIConfig) {
 		}
 	}
 }
+
+// boolPtr returns a pointer to the given bool
+func boolPtr(b bool) *bool {
+	return &b
+}
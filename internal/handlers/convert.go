@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/sanix-darker/git-ci/internal/config"
+	"github.com/sanix-darker/git-ci/internal/convert"
+	cli "github.com/urfave/cli/v2"
+)
+
+// CmdConvert handles the convert command: it writes the pipeline as the
+// configuration of another provider, warning about what is lost
+func CmdConvert(c *cli.Context) error {
+	pipeline, err := parseInput(c.String("file"), &config.RunnerConfig{Offline: c.Bool("offline")})
+	if err != nil {
+		return fmt.Errorf("failed to parse workflow: %w", err)
+	}
+
+	out, warnings, err := convert.Convert(pipeline, c.String("to"))
+	if err != nil {
+		return err
+	}
+	for _, warning := range warnings {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
+	}
+
+	if path := c.String("output"); path != "" {
+		if err := os.WriteFile(path, out, 0o644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", path, err)
+		}
+		printVerbose(c, "Converted %s pipeline written to %s\n", pipeline.Provider, path)
+		return nil
+	}
+	_, err = os.Stdout.Write(out)
+	return err
+}
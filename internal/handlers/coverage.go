@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/sanix-darker/git-ci/pkg/types"
+)
+
+// coverageReporter is implemented by runners extracting the coverage of
+// a job from its output with its `coverage:` regex
+type coverageReporter interface {
+	Coverage() (float64, bool)
+}
+
+// recordCoverage stores the coverage the last job of runner reported
+func (s *runState) recordCoverage(jobName string, runner types.Runner) {
+	reporter, ok := runner.(coverageReporter)
+	if s == nil || !ok {
+		return
+	}
+
+	coverage, found := reporter.Coverage()
+	if !found {
+		return
+	}
+	s.record.jobCoverage(jobName, coverage)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.coverage[jobName] = coverage
+}
+
+// coverageSuffix returns the coverage of a job for its result line, or ""
+func (s *runState) coverageSuffix(jobName string) string {
+	if s == nil {
+		return ""
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	coverage, ok := s.coverage[jobName]
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf(" (coverage %.2f%%)", coverage)
+}
+
+// printCoverage lists the coverage of the jobs that reported one
+func (s *runState) printCoverage() {
+	if s == nil || len(s.coverage) == 0 {
+		return
+	}
+
+	names := make([]string, 0, len(s.coverage))
+	for name := range s.coverage {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Println("Coverage:")
+	for _, name := range names {
+		fmt.Printf("  %-30s %6.2f%%\n", name, s.coverage[name])
+	}
+}
+
+// checkCoverage returns an error naming the jobs whose coverage is below
+// threshold, for --coverage-threshold
+func (s *runState) checkCoverage(threshold float64) error {
+	if s == nil || threshold <= 0 {
+		return nil
+	}
+
+	var below []string
+	for name, coverage := range s.coverage {
+		if coverage < threshold {
+			below = append(below, fmt.Sprintf("%s (%.2f%%)", name, coverage))
+		}
+	}
+	if len(below) == 0 {
+		return nil
+	}
+
+	sort.Strings(below)
+	return fmt.Errorf("coverage below %.2f%%: %s", threshold, strings.Join(below, ", "))
+}
@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/sanix-darker/git-ci/pkg/types"
+)
+
+// jobDependencies returns the direct upstream jobs of a job. Explicit
+// needs win, `needs: []` included; otherwise GitLab jobs depend on every
+// job of earlier stages.
+func jobDependencies(pipeline *types.Pipeline, jobName string) []string {
+	job, ok := pipeline.Jobs[jobName]
+	if !ok {
+		return nil
+	}
+
+	if job.HasNeeds() || pipeline.Provider != "gitlab" {
+		var deps []string
+		for _, need := range job.NeedNames() {
+			if _, exists := pipeline.Jobs[need]; exists {
+				deps = append(deps, need)
+			}
+		}
+		return deps
+	}
+
+	stageIndex := make(map[string]int)
+	for i, stage := range pipeline.Stages {
+		stageIndex[stage] = i
+	}
+
+	current, ok := stageIndex[job.Stage]
+	if !ok {
+		return nil
+	}
+
+	var deps []string
+	for name, other := range pipeline.Jobs {
+		if idx, ok := stageIndex[other.Stage]; ok && idx < current {
+			deps = append(deps, name)
+		}
+	}
+	sort.Strings(deps)
+	return deps
+}
+
+// jobAncestors returns every job that must complete before jobName
+func jobAncestors(pipeline *types.Pipeline, jobName string) map[string]bool {
+	ancestors := make(map[string]bool)
+
+	var visit func(name string)
+	visit = func(name string) {
+		for _, dep := range jobDependencies(pipeline, name) {
+			if !ancestors[dep] {
+				ancestors[dep] = true
+				visit(dep)
+			}
+		}
+	}
+	visit(jobName)
+
+	return ancestors
+}
+
+// jobGraph is the dependency graph of the jobs selected for a run
+type jobGraph struct {
+	order []string            // Jobs in dependency order
+	deps  map[string][]string // Direct upstream jobs within the selection
+}
+
+// newJobGraph orders the selected jobs so each runs after its needs,
+// GitLab dependencies and earlier stages. Ties keep stage order, then
+// names. A cycle is an error naming it.
+func newJobGraph(pipeline *types.Pipeline, jobs map[string]*types.Job) (*jobGraph, error) {
+	g := &jobGraph{deps: make(map[string][]string, len(jobs))}
+
+	for name, job := range jobs {
+		seen := make(map[string]bool)
+		upstream := jobDependencies(pipeline, name)
+		if pipeline.Jobs[name] == nil {
+			upstream = job.NeedNames()
+		}
+		upstream = append(upstream, job.Dependencies...)
+		for _, dep := range upstream {
+			if _, selected := jobs[dep]; selected && dep != name && !seen[dep] {
+				seen[dep] = true
+				g.deps[name] = append(g.deps[name], dep)
+			}
+		}
+		sort.Strings(g.deps[name])
+	}
+
+	stageIndex := make(map[string]int, len(pipeline.Stages))
+	for i, stage := range pipeline.Stages {
+		stageIndex[stage] = i
+	}
+	before := func(a, b string) bool {
+		sa, sb := stageIndex[jobs[a].Stage], stageIndex[jobs[b].Stage]
+		if sa != sb {
+			return sa < sb
+		}
+		return a < b
+	}
+
+	// Kahn's algorithm, picking the first ready job each time
+	pending := make(map[string]int, len(jobs))
+	downstream := make(map[string][]string)
+	for name := range jobs {
+		pending[name] = len(g.deps[name])
+		for _, dep := range g.deps[name] {
+			downstream[dep] = append(downstream[dep], name)
+		}
+	}
+
+	var ready []string
+	for name, count := range pending {
+		if count == 0 {
+			ready = append(ready, name)
+		}
+	}
+
+	for len(ready) > 0 {
+		sort.Slice(ready, func(i, j int) bool { return before(ready[i], ready[j]) })
+		name := ready[0]
+		ready = ready[1:]
+		g.order = append(g.order, name)
+
+		for _, next := range downstream[name] {
+			pending[next]--
+			if pending[next] == 0 {
+				ready = append(ready, next)
+			}
+		}
+	}
+
+	if len(g.order) < len(jobs) {
+		return nil, fmt.Errorf("dependency cycle: %s", strings.Join(g.findCycle(pending), " → "))
+	}
+	return g, nil
+}
+
+// findCycle returns a dependency cycle among the jobs Kahn's algorithm
+// could not order, its first job repeated at the end
+func (g *jobGraph) findCycle(pending map[string]int) []string {
+	var names []string
+	for name, count := range pending {
+		if count > 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	// Every blocked job has a blocked upstream job: walking them must
+	// come back to a job already on the path
+	var path []string
+	onPath := make(map[string]int)
+	for name := names[0]; ; {
+		if i, ok := onPath[name]; ok {
+			return append(path[i:], name)
+		}
+		onPath[name] = len(path)
+		path = append(path, name)
+
+		for _, dep := range g.deps[name] {
+			if pending[dep] > 0 {
+				name = dep
+				break
+			}
+		}
+	}
+}
+
+// skipReason explains why a job is skipped because of the failed upstream
+// job dep: a job reached through stage order waits for the whole stage
+func skipReason(jobs map[string]*types.Job, name, dep string) string {
+	job, upstream := jobs[name], jobs[dep]
+	if job == nil || upstream == nil || upstream.Stage == "" || upstream.Stage == job.Stage {
+		return fmt.Sprintf("dependency '%s' failed", dep)
+	}
+	for _, need := range job.UpstreamNames() {
+		if need == dep {
+			return fmt.Sprintf("dependency '%s' failed", dep)
+		}
+	}
+	return fmt.Sprintf("stage '%s' failed", upstream.Stage)
+}
+
+// blockedBy returns the first upstream job of name found in failed
+func (g *jobGraph) blockedBy(name string, failed map[string]bool) string {
+	for _, dep := range g.deps[name] {
+		if failed[dep] {
+			return dep
+		}
+	}
+	return ""
+}
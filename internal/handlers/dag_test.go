@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sanix-darker/git-ci/pkg/types"
+)
+
+func TestJobDependenciesEmptyNeeds(t *testing.T) {
+	pipeline := &types.Pipeline{
+		Provider: "gitlab",
+		Stages:   []string{"build", "test", "deploy"},
+		Jobs: map[string]*types.Job{
+			"compile": {Stage: "build"},
+			"vet":     {Stage: "build"},
+			"unit":    {Stage: "test"},
+			"lint":    {Stage: "test", NeedsSet: true},
+			"e2e":     {Stage: "test", Needs: types.NeedsOf("compile"), NeedsSet: true},
+			"release": {Stage: "deploy"},
+		},
+	}
+
+	tests := map[string]string{
+		"compile": "",
+		"unit":    "compile,vet",
+		"lint":    "",
+		"e2e":     "compile",
+		"release": "compile,e2e,lint,unit,vet",
+	}
+	for job, want := range tests {
+		if got := strings.Join(jobDependencies(pipeline, job), ","); got != want {
+			t.Errorf("%s depends on [%s], want [%s]", job, got, want)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/sanix-darker/git-ci/pkg/types"
+	cli "github.com/urfave/cli/v2"
+)
+
+// runIDEnv passes the run ID to a detached run
+const runIDEnv = "GIT_CI_RUN_ID"
+
+// attachPollInterval is how often `attach` checks for new output
+const attachPollInterval = 500 * time.Millisecond
+
+// runLogPath returns the file receiving the output of a detached run
+func runLogPath(id string) string {
+	return filepath.Join(runsDir(), id+".log")
+}
+
+// isDetachedRun reports whether this process is a detached run
+func isDetachedRun() bool {
+	return os.Getenv(runIDEnv) != ""
+}
+
+// detachRun starts the same run in a background session writing to the
+// run's log file, and returns once it is started
+func detachRun(c *cli.Context) error {
+	id := time.Now().Format("20060102-150405.000")
+
+	if err := os.MkdirAll(runsDir(), 0755); err != nil {
+		return fmt.Errorf("failed to create runs directory: %w", err)
+	}
+	logFile, err := os.OpenFile(runLogPath(id), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create run log: %w", err)
+	}
+	defer logFile.Close()
+
+	executable, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to find git-ci executable: %w", err)
+	}
+
+	// Same command line without --detach
+	var args []string
+	for _, arg := range os.Args[1:] {
+		if arg == "--detach" || arg == "-detach" || arg == "--detach=true" {
+			continue
+		}
+		args = append(args, arg)
+	}
+
+	cmd := exec.Command(executable, args...)
+	cmd.Env = append(os.Environ(), runIDEnv+"="+id)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	cmd.SysProcAttr = detachedProcAttr()
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start detached run: %w", err)
+	}
+	pid := cmd.Process.Pid
+	_ = cmd.Process.Release()
+
+	fmt.Printf("Run %s started in the background (pid %d)\n", id, pid)
+	fmt.Printf("  Follow it:  git-ci attach %s\n", id)
+	fmt.Printf("  Cancel it:  git-ci cancel %s\n", id)
+	return nil
+}
+
+// handleInterrupt records the run as cancelled when the process is
+// interrupted (Ctrl+C, `git-ci cancel`) or terminated. The returned
+// function stops watching.
+func handleInterrupt(state *runState, save bool) func() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+
+	go func() {
+		select {
+		case sig := <-signals:
+			fmt.Printf("\nReceived %s, cancelling run %s\n", sig, state.id)
+			state.stopCompose()
+			if save {
+				if err := state.record.cancel(); err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: failed to save run record: %v\n", err)
+				}
+			}
+			os.Exit(130)
+		case <-done:
+		}
+	}()
+
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
+
+// CmdAttach follows the output and status of a detached run
+func CmdAttach(c *cli.Context) error {
+	id := c.Args().First()
+	if id == "" {
+		return fmt.Errorf("run id required")
+	}
+
+	logFile, err := os.Open(runLogPath(id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("no log for run %s (only detached runs can be attached)", id)
+		}
+		return fmt.Errorf("failed to open run log: %w", err)
+	}
+	defer logFile.Close()
+
+	for {
+		if _, err := io.Copy(os.Stdout, logFile); err != nil {
+			return fmt.Errorf("failed to read run log: %w", err)
+		}
+
+		// The record shows up once the run has parsed its pipeline
+		run, err := loadRun(id)
+		if err == nil && run.Status != types.StatusRunning {
+			_, _ = io.Copy(os.Stdout, logFile)
+			fmt.Printf("Run %s finished: %s\n", id, run.Status)
+			if run.Status != types.StatusSuccess {
+				return fmt.Errorf("run %s %s", id, run.Status)
+			}
+			return nil
+		}
+
+		if pid := runPID(run); pid > 0 && !processAlive(pid) {
+			_, _ = io.Copy(os.Stdout, logFile)
+			return fmt.Errorf("run %s stopped without finishing (process %d is gone)", id, pid)
+		}
+
+		time.Sleep(attachPollInterval)
+	}
+}
+
+// CmdCancel interrupts a detached run
+func CmdCancel(c *cli.Context) error {
+	id := c.Args().First()
+	if id == "" {
+		return fmt.Errorf("run id required")
+	}
+
+	run, err := loadRun(id)
+	if err != nil {
+		return err
+	}
+	if run.Status != types.StatusRunning {
+		return fmt.Errorf("run %s is not running (%s)", id, run.Status)
+	}
+
+	pid := runPID(run)
+	if pid <= 0 {
+		return fmt.Errorf("run %s has no recorded process", id)
+	}
+
+	if err := interruptProcess(pid); err != nil {
+		return fmt.Errorf("failed to cancel run %s: %w", id, err)
+	}
+
+	fmt.Printf("Cancelling run %s (pid %d)\n", id, pid)
+	return nil
+}
+
+// runPID returns the process of a run, 0 when unknown
+func runPID(run *types.PipelineRun) int {
+	if run == nil {
+		return 0
+	}
+	pid, _ := strconv.Atoi(run.Metadata["pid"])
+	return pid
+}
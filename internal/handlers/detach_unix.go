@@ -0,0 +1,26 @@
+//go:build !windows
+
+package handlers
+
+import "syscall"
+
+// detachedProcAttr starts the run in its own session, so it survives the
+// terminal closing (no SIGHUP) and leads its own process group
+func detachedProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setsid: true}
+}
+
+// processAlive reports whether a process exists
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
+
+// interruptProcess sends SIGINT to the run's process group, reaching the
+// job scripts as Ctrl+C would
+func interruptProcess(pid int) error {
+	if err := syscall.Kill(-pid, syscall.SIGINT); err == nil {
+		return nil
+	}
+	return syscall.Kill(pid, syscall.SIGINT)
+}
@@ -6,6 +6,8 @@ import (
 	"sort"
 	"strings"
 
+	"github.com/sanix-darker/git-ci/internal/runners"
+	"github.com/sanix-darker/git-ci/pkg/types"
 	cli "github.com/urfave/cli/v2"
 )
 
@@ -72,7 +74,7 @@ func CmdEnvSet(c *cli.Context) error {
 			return fmt.Errorf("failed to set %s: %w", key, err)
 		}
 
-		fmt.Printf("✓ Set %s=%s\n", key, value)
+		fmt.Printf("%s Set %s=%s\n", okMark(c), key, value)
 	}
 
 	// Optionally save to .env file
@@ -86,7 +88,7 @@ func CmdEnvSet(c *cli.Context) error {
 			return fmt.Errorf("failed to save to %s: %w", envFile, err)
 		}
 
-		fmt.Printf("\n✓ Saved to %s\n", envFile)
+		fmt.Printf("\n%s Saved to %s\n", okMark(c), envFile)
 	}
 
 	return nil
@@ -124,20 +126,128 @@ func CmdEnvLoad(c *cli.Context) error {
 			return fmt.Errorf("failed to set %s: %w", key, err)
 		}
 
-		// Mask sensitive values in output
-		displayValue := value
-		if isSensitive(key) && len(value) > 4 {
-			displayValue = value[:2] + strings.Repeat("*", len(value)-4) + value[len(value)-2:]
+		fmt.Printf("%-30s = %s\n", key, maskValue(key, value))
+	}
+
+	fmt.Printf("\n%s Loaded %d environment variable(s)\n", okMark(c), len(env))
+
+	return nil
+}
+
+// CmdEnvStop handles the env stop command: it runs the job that stops
+// the named environment (GitLab `environment: action: stop`)
+func CmdEnvStop(c *cli.Context) error {
+	if c.NArg() != 1 {
+		return fmt.Errorf("expected exactly one environment name. Usage: git-ci env stop <environment>")
+	}
+	envName := c.Args().First()
+
+	cfg := buildRunnerConfig(c)
+
+	pipeline, err := parseInput(c.String("file"), cfg)
+	if err != nil {
+		return fmt.Errorf("failed to parse pipeline: %w", err)
+	}
+	cfg.Provider = pipeline.Provider
+	cfg.PipelineEnv = pipelineEnvironment(pipeline, cfg)
+
+	stopJobs := make(map[string]*types.Job)
+	for name, job := range pipeline.Jobs {
+		if job.IsStopJob() {
+			stopJobs[name] = job
+		}
+	}
+
+	// Environment names may use variables (review/$CI_COMMIT_REF_NAME)
+	if err := expandJobVariables(c, pipeline, stopJobs, cfg); err != nil {
+		return err
+	}
+
+	jobs := make(map[string]*types.Job)
+	for name, job := range stopJobs {
+		if job.EnvironmentName == envName {
+			jobs[name] = job
 		}
+	}
+
+	if len(jobs) == 0 {
+		return fmt.Errorf("no stop job found for environment '%s'", envName)
+	}
 
-		fmt.Printf("%-30s = %s\n", key, displayValue)
+	workdir, err := getWorkdir(c)
+	if err != nil {
+		return err
 	}
 
-	fmt.Printf("\n✓ Loaded %d environment variable(s)\n", len(env))
+	graph, err := newJobGraph(pipeline, jobs)
+	if err != nil {
+		return err
+	}
+
+	return runJobsSequential(c, jobs, graph, workdir, cfg, nil)
+}
+
+// CmdEnvResolve handles the env resolve command: it prints the variables
+// of a job, and of one of its steps, with their references expanded
+func CmdEnvResolve(c *cli.Context) error {
+	jobName := c.String("job")
+
+	cfg := buildRunnerConfig(c)
+
+	pipeline, err := parseInput(c.String("file"), cfg)
+	if err != nil {
+		return fmt.Errorf("failed to parse pipeline: %w", err)
+	}
+	cfg.Provider = pipeline.Provider
+	cfg.PipelineEnv = pipelineEnvironment(pipeline, cfg)
+
+	job, ok := pipeline.Jobs[jobName]
+	if !ok {
+		return fmt.Errorf("job '%s' not found", jobName)
+	}
+
+	stepNum := c.Int("step")
+	if stepNum < 0 || stepNum > len(job.Steps) {
+		return fmt.Errorf("job '%s' has %d step(s), no step %d", jobName, len(job.Steps), stepNum)
+	}
+
+	jobEnv, stepEnvs, err := runners.ResolveEnv(job, cfg, nil)
+	if err != nil {
+		return fmt.Errorf("job '%s': %w", jobName, err)
+	}
+
+	fmt.Printf("Variables of job '%s':\n", jobName)
+	printResolvedEnv(jobEnv)
+
+	if stepNum > 0 {
+		step := job.Steps[stepNum-1]
+		fmt.Printf("\nEnv of step %d (%s):\n", stepNum, step.Name)
+		printResolvedEnv(stepEnvs[stepNum-1])
+	}
 
 	return nil
 }
 
+// printResolvedEnv prints variables sorted by name, masking sensitive ones
+func printResolvedEnv(env map[string]string) {
+	fmt.Println(strings.Repeat("-", 60))
+
+	if len(env) == 0 {
+		fmt.Println("(none)")
+		return
+	}
+
+	keys := make([]string, 0, len(env))
+	for k := range env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		fmt.Printf("%-30s = %s\n", key, maskValue(key, env[key]))
+	}
+}
+
 // saveEnvFile saves environment variables to a file
 func saveEnvFile(vars []string, filename string) error {
 	// Read existing file if it exists
@@ -223,23 +333,12 @@ func saveEnvFile(vars []string, filename string) error {
 	return os.WriteFile(filename, []byte(content.String()), 0644)
 }
 
-// isSensitive checks if an environment variable key is sensitive
-func isSensitive(key string) bool {
-	// TODO:
-	// not optimizal for now... will find a better way later on
-	sensitive := []string{
-		"PASSWORD", "SECRET", "TOKEN", "KEY", "CREDENTIAL",
-		"PRIVATE", "AUTH", "API_KEY", "ACCESS", "CERT",
+// maskValue hides most of the value of a sensitive variable
+func maskValue(key, value string) string {
+	if runners.IsSensitive(key) && len(value) > 4 {
+		return value[:2] + strings.Repeat("*", len(value)-4) + value[len(value)-2:]
 	}
-
-	upperKey := strings.ToUpper(key)
-	for _, s := range sensitive {
-		if strings.Contains(upperKey, s) {
-			return true
-		}
-	}
-
-	return false
+	return value
 }
 
 // needsQuoting checks if a value needs quoting in env file
@@ -0,0 +1,242 @@
+package handlers
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/sanix-darker/git-ci/internal/config"
+	"github.com/sanix-darker/git-ci/pkg/types"
+	cli "github.com/urfave/cli/v2"
+)
+
+// Kinds of edges of the job graph
+const (
+	edgeNeeds        = "needs"
+	edgeDependencies = "dependencies" // GitLab artifacts taken without needing the job
+	edgeStage        = "stage"        // Stage order of GitLab jobs without needs
+)
+
+// graphEdge is an edge of the job graph, from the upstream job
+type graphEdge struct {
+	from, to string
+	kind     string
+}
+
+// CmdGraph handles the graph command: it prints the job graph of the
+// pipeline as Graphviz DOT or Mermaid
+func CmdGraph(c *cli.Context) error {
+	pipeline, err := parseInput(c.String("file"), &config.RunnerConfig{
+		Offline: c.Bool("offline"),
+		NoFetch: true,
+		Cache:   resolveCachePolicy(c),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to parse workflow: %w", err)
+	}
+
+	stages := !c.Bool("no-stages")
+	var out string
+	switch format := c.String("format"); format {
+	case "dot":
+		out = renderDOT(pipeline, stages)
+	case "mermaid":
+		out = renderMermaid(pipeline, stages)
+	default:
+		return fmt.Errorf("unknown graph format '%s' (dot, mermaid)", format)
+	}
+
+	if path := c.String("output"); path != "" {
+		if err := os.WriteFile(path, []byte(out), 0o644); err != nil {
+			return fmt.Errorf("failed to write graph: %w", err)
+		}
+		printVerbose(c, "Graph written to %s\n", path)
+		return nil
+	}
+	fmt.Print(out)
+	return nil
+}
+
+// graphEdges returns the edges of the job graph: needs, GitLab
+// dependencies not needed, and for GitLab jobs without needs the jobs of
+// the previous stage with jobs
+func graphEdges(pipeline *types.Pipeline) []graphEdge {
+	byStage := make(map[string][]string)
+	for _, name := range sortedJobNames(pipeline) {
+		byStage[pipeline.Jobs[name].Stage] = append(byStage[pipeline.Jobs[name].Stage], name)
+	}
+
+	var edges []graphEdge
+	for _, name := range sortedJobNames(pipeline) {
+		job := pipeline.Jobs[name]
+
+		needed := make(map[string]bool)
+		for _, need := range job.NeedNames() {
+			if _, exists := pipeline.Jobs[need]; exists && !needed[need] {
+				needed[need] = true
+				edges = append(edges, graphEdge{from: need, to: name, kind: edgeNeeds})
+			}
+		}
+		for _, dep := range job.Dependencies {
+			if _, exists := pipeline.Jobs[dep]; exists && !needed[dep] {
+				needed[dep] = true
+				edges = append(edges, graphEdge{from: dep, to: name, kind: edgeDependencies})
+			}
+		}
+
+		if job.HasNeeds() || pipeline.Provider != "gitlab" {
+			continue
+		}
+		for _, upstream := range previousStageJobs(pipeline, job.Stage, byStage) {
+			if !needed[upstream] {
+				edges = append(edges, graphEdge{from: upstream, to: name, kind: edgeStage})
+			}
+		}
+	}
+	return edges
+}
+
+// previousStageJobs returns the jobs of the last stage before stage that
+// has jobs
+func previousStageJobs(pipeline *types.Pipeline, stage string, byStage map[string][]string) []string {
+	var previous []string
+	for _, s := range pipeline.Stages {
+		if s == stage {
+			return previous
+		}
+		if len(byStage[s]) > 0 {
+			previous = byStage[s]
+		}
+	}
+	return nil
+}
+
+// graphStages returns the stages of the pipeline having jobs, in order,
+// and the jobs of no stage
+func graphStages(pipeline *types.Pipeline) ([]string, map[string][]string, []string) {
+	byStage := make(map[string][]string)
+	known := make(map[string]bool, len(pipeline.Stages))
+	for _, stage := range pipeline.Stages {
+		known[stage] = true
+	}
+
+	var loose []string
+	for _, name := range sortedJobNames(pipeline) {
+		stage := pipeline.Jobs[name].Stage
+		if !known[stage] {
+			loose = append(loose, name)
+			continue
+		}
+		byStage[stage] = append(byStage[stage], name)
+	}
+
+	var stages []string
+	for _, stage := range pipeline.Stages {
+		if len(byStage[stage]) > 0 {
+			stages = append(stages, stage)
+		}
+	}
+	return stages, byStage, loose
+}
+
+// renderDOT renders the job graph as Graphviz DOT, stages as clusters
+func renderDOT(pipeline *types.Pipeline, withStages bool) string {
+	var b strings.Builder
+	b.WriteString("digraph pipeline {\n")
+	b.WriteString("  rankdir=LR;\n")
+	b.WriteString("  node [shape=box];\n")
+
+	stages, byStage, loose := graphStages(pipeline)
+	if !withStages {
+		stages, loose = nil, sortedJobNames(pipeline)
+	}
+	for i, stage := range stages {
+		fmt.Fprintf(&b, "  subgraph cluster_%d {\n", i)
+		fmt.Fprintf(&b, "    label=%s;\n", dotQuote(stage))
+		for _, name := range byStage[stage] {
+			fmt.Fprintf(&b, "    %s;\n", dotQuote(name))
+		}
+		b.WriteString("  }\n")
+	}
+	for _, name := range loose {
+		fmt.Fprintf(&b, "  %s;\n", dotQuote(name))
+	}
+
+	for _, edge := range graphEdges(pipeline) {
+		attrs := ""
+		switch edge.kind {
+		case edgeDependencies:
+			attrs = " [style=dashed]"
+		case edgeStage:
+			attrs = " [style=dotted]"
+		}
+		fmt.Fprintf(&b, "  %s -> %s%s;\n", dotQuote(edge.from), dotQuote(edge.to), attrs)
+	}
+
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// renderMermaid renders the job graph as a Mermaid flowchart, stages as
+// subgraphs
+func renderMermaid(pipeline *types.Pipeline, withStages bool) string {
+	ids := make(map[string]string, len(pipeline.Jobs))
+	for i, name := range sortedJobNames(pipeline) {
+		ids[name] = fmt.Sprintf("job%d", i)
+	}
+	node := func(name string) string {
+		return fmt.Sprintf("%s[\"%s\"]", ids[name], mermaidEscape(name))
+	}
+
+	var b strings.Builder
+	b.WriteString("flowchart LR\n")
+
+	stages, byStage, loose := graphStages(pipeline)
+	if !withStages {
+		stages, loose = nil, sortedJobNames(pipeline)
+	}
+	for i, stage := range stages {
+		fmt.Fprintf(&b, "  subgraph stage%d [\"%s\"]\n", i, mermaidEscape(stage))
+		for _, name := range byStage[stage] {
+			fmt.Fprintf(&b, "    %s\n", node(name))
+		}
+		b.WriteString("  end\n")
+	}
+	for _, name := range loose {
+		fmt.Fprintf(&b, "  %s\n", node(name))
+	}
+
+	for _, edge := range graphEdges(pipeline) {
+		arrow := "-->"
+		switch edge.kind {
+		case edgeDependencies:
+			arrow = "-.->|artifacts|"
+		case edgeStage:
+			arrow = "-.->"
+		}
+		fmt.Fprintf(&b, "  %s %s %s\n", ids[edge.from], arrow, ids[edge.to])
+	}
+	return b.String()
+}
+
+// sortedJobNames returns the job names of a pipeline, sorted
+func sortedJobNames(pipeline *types.Pipeline) []string {
+	names := make([]string, 0, len(pipeline.Jobs))
+	for name := range pipeline.Jobs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// dotQuote quotes a DOT identifier
+func dotQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return `"` + strings.ReplaceAll(s, `"`, `\"`) + `"`
+}
+
+// mermaidEscape escapes the quotes of a Mermaid label
+func mermaidEscape(s string) string {
+	return strings.ReplaceAll(s, `"`, "#quot;")
+}
@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sanix-darker/git-ci/pkg/types"
+)
+
+// graphPipeline is a GitLab pipeline with each kind of edge
+func graphPipeline() *types.Pipeline {
+	return &types.Pipeline{
+		Name:     "ci",
+		Provider: "gitlab",
+		Stages:   []string{"build", "test", "deploy"},
+		Jobs: map[string]*types.Job{
+			"compile": {Name: "compile", Stage: "build"},
+			"lint":    {Name: "lint", Stage: "build"},
+			"unit":    {Name: "unit", Stage: "test", Needs: types.NeedsOf("compile")},
+			"e2e":     {Name: "e2e", Stage: "test"},
+			"release": {Name: "release", Stage: "deploy", Needs: types.NeedsOf("unit"), Dependencies: []string{"compile"}},
+		},
+	}
+}
+
+func TestRenderDOTEdges(t *testing.T) {
+	out := renderDOT(graphPipeline(), true)
+
+	for _, edge := range []string{
+		`"compile" -> "unit";`,
+		`"unit" -> "release";`,
+		`"compile" -> "release" [style=dashed];`,
+		`"compile" -> "e2e" [style=dotted];`,
+		`"lint" -> "e2e" [style=dotted];`,
+	} {
+		if !strings.Contains(out, edge) {
+			t.Errorf("edge %s missing:\n%s", edge, out)
+		}
+	}
+	if got := strings.Count(out, "->"); got != 5 {
+		t.Errorf("%d edges, want 5:\n%s", got, out)
+	}
+
+	for _, cluster := range []string{"cluster_0 {\n    label=\"build\";\n    \"compile\";\n    \"lint\";", "label=\"deploy\";\n    \"release\";"} {
+		if !strings.Contains(out, cluster) {
+			t.Errorf("cluster %q missing:\n%s", cluster, out)
+		}
+	}
+	if out := renderDOT(graphPipeline(), false); strings.Contains(out, "subgraph") {
+		t.Errorf("clusters rendered without stages:\n%s", out)
+	}
+}
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/sanix-darker/git-ci/pkg/types"
+	cli "github.com/urfave/cli/v2"
+)
+
+// CmdHistoryShow lists recorded runs, or with --images the image digests
+// each job ran with over time
+func CmdHistoryShow(c *cli.Context) error {
+	runs, err := listRuns(c.String("pipeline"))
+	if err != nil {
+		return err
+	}
+	if limit := c.Int("limit"); limit > 0 && len(runs) > limit {
+		runs = runs[:limit]
+	}
+	if len(runs) == 0 {
+		fmt.Println("No runs recorded")
+		return nil
+	}
+
+	if c.Bool("images") {
+		printImageHistory(runs)
+		return nil
+	}
+
+	fmt.Printf("%-22s %-10s %-10s %s\n", "RUN", "STATUS", "DURATION", "PIPELINE")
+	for _, run := range runs {
+		duration := "-"
+		if run.Duration != nil {
+			duration = formatDuration(*run.Duration)
+		}
+		fmt.Printf("%-22s %-10s %-10s %s\n", run.ID, run.Status, duration, run.PipelineID)
+	}
+	return nil
+}
+
+// printImageHistory prints, per job, the image digests of each run
+func printImageHistory(runs []*types.PipelineRun) {
+	type entry struct {
+		run, image, digest string
+	}
+	history := make(map[string][]entry)
+
+	for _, run := range runs {
+		for name, job := range run.Jobs {
+			if job.ImageDigest != "" {
+				history[name] = append(history[name], entry{run.ID, job.Image, job.ImageDigest})
+			}
+		}
+	}
+	if len(history) == 0 {
+		fmt.Println("No image digests recorded (only Docker runs record them)")
+		return
+	}
+
+	names := make([]string, 0, len(history))
+	for name := range history {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fmt.Println(name)
+		entries := history[name]
+		for i, e := range entries {
+			marker := ""
+			if i+1 < len(entries) && entries[i+1].digest != e.digest {
+				marker = "  (changed)"
+			}
+			fmt.Printf("  %-22s %-30s %s%s\n", e.run, e.image, shortDigest(e.digest), marker)
+		}
+	}
+}
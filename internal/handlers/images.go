@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/sanix-darker/git-ci/pkg/types"
+	cli "github.com/urfave/cli/v2"
+)
+
+// imageResolver is implemented by runners running jobs in a container
+// image; it reports the image of the last job and its registry digest
+type imageResolver interface {
+	ResolvedImage() (string, string)
+}
+
+// recordImage stores the image digest a job ran with and warns when it
+// differs from the previous run of the job, e.g. after a floating tag
+// moved
+func (s *runState) recordImage(jobName string, runner types.Runner) {
+	resolver, ok := runner.(imageResolver)
+	if s == nil || !ok {
+		return
+	}
+
+	image, digest := resolver.ResolvedImage()
+	if digest == "" {
+		return
+	}
+	s.record.jobImage(jobName, image, digest)
+
+	if previous, ok := s.images[jobName]; ok && previous.ImageDigest != digest {
+		fmt.Printf("Warning: image drift detected: %s %s → %s (use --pin-images to reuse the previous one)\n",
+			image, shortDigest(previous.ImageDigest), shortDigest(digest))
+	}
+}
+
+// lastImages returns, for each job, the most recent recorded image digest
+func lastImages(pipelineID string) map[string]*types.JobStatus {
+	images := make(map[string]*types.JobStatus)
+
+	runs, err := listRuns(pipelineID)
+	if err != nil {
+		return images
+	}
+	for _, run := range runs {
+		for name, job := range run.Jobs {
+			if _, seen := images[name]; !seen && job.ImageDigest != "" {
+				images[name] = job
+			}
+		}
+	}
+	return images
+}
+
+// pinnedImages fixes the image of each job to the digest recorded by its
+// previous run, for --pin-images
+func pinnedImages(c *cli.Context, jobs map[string]*types.Job, state *runState) map[string]string {
+	pinned := make(map[string]string)
+
+	names := make([]string, 0, len(jobs))
+	for name := range jobs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		previous, ok := state.images[name]
+		if !ok {
+			printVerbose(c, "Job '%s' has no recorded image digest to pin\n", name)
+			continue
+		}
+		pinned[name] = types.PinnedImage(previous.Image, previous.ImageDigest)
+		fmt.Printf("Pinning job '%s' to %s\n", name, pinned[name])
+	}
+
+	return pinned
+}
+
+// shortDigest abbreviates a digest for display
+func shortDigest(digest string) string {
+	digest = strings.TrimPrefix(digest, "sha256:")
+	if len(digest) > 12 {
+		return digest[:12]
+	}
+	return digest
+}
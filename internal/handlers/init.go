@@ -52,7 +52,7 @@ func CmdInit(c *cli.Context) error {
 		return fmt.Errorf("failed to write file %s: %w", output, err)
 	}
 
-	fmt.Printf("✓ Created %s pipeline: %s\n", provider, output)
+	fmt.Printf("%s Created %s pipeline: %s\n", okMark(c), provider, output)
 	fmt.Printf("\nNext steps:\n")
 	fmt.Printf("  1. Review and customize the pipeline\n")
 	fmt.Printf("  2. Test locally: git-ci run -f %s\n", output)
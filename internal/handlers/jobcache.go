@@ -0,0 +1,380 @@
+package handlers
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/sanix-darker/git-ci/internal/config"
+	"github.com/sanix-darker/git-ci/internal/rules"
+	"github.com/sanix-darker/git-ci/internal/runners"
+	"github.com/sanix-darker/git-ci/pkg/types"
+	cli "github.com/urfave/cli/v2"
+)
+
+// Cache policies, GitLab's pull-push being the default
+const (
+	cachePolicyPull     = "pull"
+	cachePolicyPush     = "push"
+	cachePolicyPullPush = "pull-push"
+)
+
+// defaultCacheKey is the key of a cache without one, as on GitLab
+const defaultCacheKey = "default"
+
+// jobCache keeps the `cache:` paths of jobs between runs: one tarball
+// per cache key of the project, written after a job and extracted into
+// the workspace before the next job using the key, in this run or a later
+// one
+type jobCache struct {
+	dir     string
+	enabled bool
+	dryRun  bool
+}
+
+// newJobCache creates the cache store of a run, disabled by --no-cache
+func newJobCache(cfg *config.RunnerConfig) *jobCache {
+	s := &jobCache{
+		enabled: cfg.CacheEnabled(config.CacheKindJob),
+		dryRun:  cfg.DryRun,
+	}
+	if s.enabled && !s.dryRun {
+		s.dir = filepath.Join(config.GetCacheDir(), "jobs", projectCacheID(cfg.WorkDir))
+	}
+	return s
+}
+
+// projectCacheID names the job caches of the project checked out at
+// workdir, so that keys of other projects never collide with its own: a
+// hash of its origin remote, shared by its clones, else of its root
+func projectCacheID(workdir string) string {
+	project := gitOutput(workdir, "remote", "get-url", "origin")
+	if project == "" {
+		project = gitOutput(workdir, "rev-parse", "--show-toplevel")
+	}
+	if project == "" {
+		project, _ = filepath.Abs(workdir)
+	}
+	sum := sha256.Sum256([]byte(project))
+	return hex.EncodeToString(sum[:8])
+}
+
+// restore extracts the caches of a job into its workspace: for each cache
+// pulled, the tarball of its key, else of the first of its fallback keys
+// found
+func (s *jobCache) restore(c *cli.Context, jobName string, job *types.Job, workdir string, cfg *config.RunnerConfig) error {
+	if s == nil || !s.enabled || s.dryRun {
+		return nil
+	}
+
+	vars := cacheVariables(job, cfg)
+	for _, cache := range job.CacheConfigs() {
+		if cachePolicy(cache, vars) == cachePolicyPush {
+			continue
+		}
+
+		keys := append([]string{cacheKey(cache.Key, vars)}, cache.Fallback...)
+		restored := false
+		for _, key := range keys {
+			key = cacheKey(key, vars)
+			err := extractTarball(s.path(key), workdir)
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("failed to restore cache '%s' of job '%s': %w", key, jobName, err)
+			}
+			printVerbose(c, "Restored cache '%s' for job '%s'\n", key, jobName)
+			restored = true
+			break
+		}
+		if !restored {
+			printVerbose(c, "No cache '%s' for job '%s' yet\n", keys[0], jobName)
+		}
+	}
+	return nil
+}
+
+// save writes the cache paths of a job under their key once it ran:
+// after a success unless `when:` says on_failure or always. Caches with
+// the pull policy are only read.
+func (s *jobCache) save(c *cli.Context, jobName string, job *types.Job, workdir string, cfg *config.RunnerConfig, succeeded bool) error {
+	if s == nil || !s.enabled || s.dryRun {
+		return nil
+	}
+
+	vars := cacheVariables(job, cfg)
+	for _, cache := range job.CacheConfigs() {
+		if cachePolicy(cache, vars) == cachePolicyPull || len(cache.Paths) == 0 {
+			continue
+		}
+		switch cache.When {
+		case "on_failure":
+			if succeeded {
+				continue
+			}
+		case "always":
+		default:
+			if !succeeded {
+				continue
+			}
+		}
+
+		key := cacheKey(cache.Key, vars)
+		count, err := writeTarball(s.path(key), workdir, expandPaths(cache.Paths, vars))
+		if err != nil {
+			return fmt.Errorf("failed to save cache '%s' of job '%s': %w", key, jobName, err)
+		}
+		if count == 0 {
+			printVerbose(c, "Warning: job '%s' has nothing to cache under '%s' (%s)\n", jobName, key, strings.Join(cache.Paths, ", "))
+			continue
+		}
+		printVerbose(c, "Saved %d path(s) of job '%s' in cache '%s'\n", count, jobName, key)
+	}
+	return nil
+}
+
+// path returns the tarball of a cache key; keys are escaped into a file
+// name
+func (s *jobCache) path(key string) string {
+	return filepath.Join(s.dir, url.QueryEscape(key)+".tar.gz")
+}
+
+// cacheVariables returns the variables cache keys, paths and policies
+// expand
+func cacheVariables(job *types.Job, cfg *config.RunnerConfig) map[string]string {
+	vars, err := runners.ExpandEnv(runners.JobVariables(job, cfg), nil, cfg.Provider)
+	if err != nil {
+		return runners.JobVariables(job, cfg)
+	}
+	return vars
+}
+
+// cacheKey returns a cache key with its variables expanded, "default"
+// when empty
+func cacheKey(key string, vars map[string]string) string {
+	key = strings.TrimSpace(os.Expand(key, func(name string) string { return vars[name] }))
+	if key == "" {
+		return defaultCacheKey
+	}
+	return key
+}
+
+// cachePolicy returns the policy of a cache, which may be a variable
+func cachePolicy(cache *types.CacheConfig, vars map[string]string) string {
+	switch policy := os.Expand(cache.Policy, func(name string) string { return vars[name] }); policy {
+	case cachePolicyPull, cachePolicyPush:
+		return policy
+	default:
+		return cachePolicyPullPush
+	}
+}
+
+// expandPaths returns cache paths with their variables expanded
+func expandPaths(paths []string, vars map[string]string) []string {
+	expanded := make([]string, len(paths))
+	for i, path := range paths {
+		expanded[i] = os.Expand(path, func(name string) string { return vars[name] })
+	}
+	return expanded
+}
+
+// writeTarball archives the paths of workdir matching patterns, kept
+// relative to it, into dest and returns how many matched; ** matches at
+// any depth. Paths outside the workspace are not cached. The tarball
+// replaces the previous one only once complete.
+func writeTarball(dest, workdir string, patterns []string) (int, error) {
+	var matches []string
+	for _, pattern := range patterns {
+		if filepath.IsAbs(pattern) || strings.HasPrefix(pattern, "~") {
+			continue
+		}
+		found, err := rules.Glob(pattern, workdir)
+		if err != nil {
+			return 0, fmt.Errorf("invalid path '%s': %w", pattern, err)
+		}
+		for _, match := range found {
+			if rel, err := filepath.Rel(workdir, match); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				matches = append(matches, match)
+			}
+		}
+	}
+	if len(matches) == 0 {
+		return 0, nil
+	}
+	matches = outermostPaths(matches)
+
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return 0, err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(dest), ".cache-")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	gz := gzip.NewWriter(tmp)
+	tw := tar.NewWriter(gz)
+	for _, match := range matches {
+		err := filepath.WalkDir(match, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			return addTarEntry(tw, path, workdir)
+		})
+		if err != nil {
+			tmp.Close()
+			return 0, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	if err := gz.Close(); err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, err
+	}
+	return len(matches), os.Rename(tmp.Name(), dest)
+}
+
+// outermostPaths returns paths sorted without duplicates and without the
+// paths below another one, which the walk of that one archives
+func outermostPaths(paths []string) []string {
+	sort.Strings(paths)
+	var kept []string
+	for _, path := range paths {
+		if n := len(kept); n > 0 && (path == kept[n-1] || strings.HasPrefix(path, kept[n-1]+string(filepath.Separator))) {
+			continue
+		}
+		kept = append(kept, path)
+	}
+	return kept
+}
+
+// addTarEntry writes a file, directory or symlink of workdir to tw
+func addTarEntry(tw *tar.Writer, path, workdir string) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	var link string
+	if info.Mode()&os.ModeSymlink != 0 {
+		if link, err = os.Readlink(path); err != nil {
+			return err
+		}
+	}
+	header, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(workdir, path)
+	if err != nil {
+		return err
+	}
+	header.Name = filepath.ToSlash(rel)
+	if info.IsDir() {
+		header.Name += "/"
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// extractTarball extracts a tarball written by writeTarball into workdir,
+// replacing the files it holds. Entries leaving workdir are refused, and
+// so are entries below a symlink of the tarball, which would be written
+// wherever it points.
+func extractTarball(src, workdir string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	links := make(map[string]bool)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		name := filepath.FromSlash(header.Name)
+		if !filepath.IsLocal(name) {
+			return fmt.Errorf("invalid path '%s' in cache", header.Name)
+		}
+		for dir := filepath.Dir(name); dir != "."; dir = filepath.Dir(dir) {
+			if links[dir] {
+				return fmt.Errorf("invalid path '%s' in cache: below the symlink '%s'", header.Name, filepath.ToSlash(dir))
+			}
+		}
+		target := filepath.Join(workdir, name)
+		mode := header.FileInfo().Mode()
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, mode.Perm()); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			os.Remove(target)
+			if err := os.Symlink(header.Linkname, target); err != nil {
+				return err
+			}
+			links[name] = true
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			os.Remove(target)
+			out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode.Perm())
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(out, tr); err != nil {
+				out.Close()
+				return err
+			}
+			if err := out.Close(); err != nil {
+				return err
+			}
+		}
+	}
+}
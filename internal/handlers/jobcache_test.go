@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestJobCacheDoubleStarPaths(t *testing.T) {
+	workdir := t.TempDir()
+	writeFiles(t, workdir, map[string]string{
+		"a/one.cache":       "1",
+		"a/b/c/two.cache":   "2",
+		"a/b/keep.txt":      "not cached",
+		"vendor/x/y/z.go":   "package z",
+		"vendor/x/notes.md": "notes",
+	})
+
+	dest := filepath.Join(t.TempDir(), "key.tar.gz")
+	count, err := writeTarball(dest, workdir, []string{"**/*.cache", "vendor/**"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count == 0 {
+		t.Fatal("** matched nothing")
+	}
+
+	restored := t.TempDir()
+	if err := extractTarball(dest, restored); err != nil {
+		t.Fatal(err)
+	}
+	var files []string
+	filepath.WalkDir(restored, func(path string, d os.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			rel, _ := filepath.Rel(restored, path)
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	want := []string{"a/b/c/two.cache", "a/one.cache", "vendor/x/notes.md", "vendor/x/y/z.go"}
+	if !slices.Equal(files, want) {
+		t.Errorf("restored %v, want %v", files, want)
+	}
+}
+
+func TestJobCacheRefusesWritesThroughItsSymlinks(t *testing.T) {
+	outside := t.TempDir()
+	src := filepath.Join(t.TempDir(), "evil.tar.gz")
+	f, err := os.Create(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	for _, header := range []*tar.Header{
+		{Name: "deps", Typeflag: tar.TypeSymlink, Linkname: outside},
+		{Name: "deps/owned", Typeflag: tar.TypeReg, Mode: 0o644, Size: 5},
+	} {
+		if err := tw.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if header.Size > 0 {
+			tw.Write([]byte("owned"))
+		}
+	}
+	tw.Close()
+	gz.Close()
+	f.Close()
+
+	if err := extractTarball(src, t.TempDir()); err == nil {
+		t.Error("a file below a symlink of the cache was extracted")
+	}
+	if _, err := os.Stat(filepath.Join(outside, "owned")); !os.IsNotExist(err) {
+		t.Errorf("the cache wrote through its symlink: %v", err)
+	}
+}
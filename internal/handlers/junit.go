@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/sanix-darker/git-ci/pkg/types"
+)
+
+// stepReporter is implemented by runners keeping the step results of
+// their last job
+type stepReporter interface {
+	StepStatuses() []types.StepStatus
+}
+
+// recordSteps stores the step results of the last job of runner
+func (s *runState) recordSteps(jobName string, runner types.Runner) {
+	reporter, ok := runner.(stepReporter)
+	if s == nil || !ok {
+		return
+	}
+	if steps := reporter.StepStatuses(); len(steps) > 0 {
+		s.record.jobSteps(jobName, steps)
+	}
+}
+
+// checkReportFormat rejects an unknown --report format before the run
+func checkReportFormat(format string) error {
+	if format == "" || format == "junit" {
+		return nil
+	}
+	return fmt.Errorf("unknown report format '%s' (junit)", format)
+}
+
+// JUnit XML elements, as read by GitLab, Jenkins and most CI dashboards
+type (
+	junitTestSuites struct {
+		XMLName  xml.Name         `xml:"testsuites"`
+		Name     string           `xml:"name,attr"`
+		Tests    int              `xml:"tests,attr"`
+		Failures int              `xml:"failures,attr"`
+		Skipped  int              `xml:"skipped,attr"`
+		Time     string           `xml:"time,attr"`
+		Suites   []junitTestSuite `xml:"testsuite"`
+	}
+
+	junitTestSuite struct {
+		Name      string          `xml:"name,attr"`
+		Tests     int             `xml:"tests,attr"`
+		Failures  int             `xml:"failures,attr"`
+		Skipped   int             `xml:"skipped,attr"`
+		Time      string          `xml:"time,attr"`
+		Timestamp string          `xml:"timestamp,attr,omitempty"`
+		Cases     []junitTestCase `xml:"testcase"`
+	}
+
+	junitTestCase struct {
+		Name      string        `xml:"name,attr"`
+		ClassName string        `xml:"classname,attr"`
+		Time      string        `xml:"time,attr"`
+		Failure   *junitFailure `xml:"failure,omitempty"`
+		Skipped   *junitSkipped `xml:"skipped,omitempty"`
+	}
+
+	junitFailure struct {
+		Message string `xml:"message,attr"`
+		Type    string `xml:"type,attr,omitempty"`
+		Text    string `xml:",chardata"`
+	}
+
+	junitSkipped struct {
+		Message string `xml:"message,attr,omitempty"`
+	}
+)
+
+// writeReport writes the test report of the run
+func (r *runRecorder) writeReport(format, path string) error {
+	if r == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	report := junitReport(r.run)
+	r.mu.Unlock()
+
+	data, err := xml.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode %s report: %w", format, err)
+	}
+	data = append([]byte(xml.Header), data...)
+	data = append(data, '\n')
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write %s report: %w", format, err)
+	}
+	return nil
+}
+
+// junitReport returns the JUnit report of a run: a test suite per job,
+// a test case per step. Jobs without step results, skipped or failed
+// before their first step, are a single test case.
+func junitReport(run *types.PipelineRun) *junitTestSuites {
+	names := make([]string, 0, len(run.Jobs))
+	for name := range run.Jobs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	report := &junitTestSuites{Name: run.PipelineID, Time: junitTime(run.Duration)}
+
+	for _, name := range names {
+		suite := junitJobSuite(name, run.Jobs[name])
+		report.Tests += suite.Tests
+		report.Failures += suite.Failures
+		report.Skipped += suite.Skipped
+		report.Suites = append(report.Suites, suite)
+	}
+	return report
+}
+
+// junitJobSuite returns the test suite of a job
+func junitJobSuite(name string, job *types.JobStatus) junitTestSuite {
+	suite := junitTestSuite{Name: name, Time: junitTime(job.Duration)}
+	if job.StartTime != nil {
+		suite.Timestamp = job.StartTime.Format("2006-01-02T15:04:05")
+	}
+
+	stepFailed := false
+	for _, step := range job.Steps {
+		tc := junitTestCase{Name: step.Name, ClassName: name, Time: junitTime(step.Duration)}
+		switch {
+		case step.Skipped:
+			tc.Skipped = &junitSkipped{Message: step.Error}
+		case step.Status == types.StatusFailed:
+			stepFailed = true
+			tc.Failure = &junitFailure{Message: step.Error, Type: "StepFailure"}
+			if step.ExitCode != 0 {
+				tc.Failure.Text = fmt.Sprintf("exit code %d", step.ExitCode)
+			}
+		}
+		suite.addCase(tc)
+	}
+
+	// The job itself is a case when no step tells its outcome
+	switch {
+	case len(job.Steps) == 0 && job.Status == types.StatusSkipped:
+		suite.addCase(junitTestCase{Name: name, ClassName: name, Time: junitTime(nil), Skipped: &junitSkipped{Message: job.Message}})
+	case job.Status == types.StatusFailed && !stepFailed:
+		suite.addCase(junitTestCase{Name: name, ClassName: name, Time: junitTime(job.Duration), Failure: &junitFailure{Message: job.Message, Type: "JobFailure"}})
+	case len(job.Steps) == 0:
+		suite.addCase(junitTestCase{Name: name, ClassName: name, Time: junitTime(job.Duration)})
+	}
+	return suite
+}
+
+// addCase adds a test case to the suite and its counts
+func (s *junitTestSuite) addCase(tc junitTestCase) {
+	s.Tests++
+	if tc.Failure != nil {
+		s.Failures++
+	}
+	if tc.Skipped != nil {
+		s.Skipped++
+	}
+	s.Cases = append(s.Cases, tc)
+}
+
+// junitTime formats a duration in seconds, 0 when unknown
+func junitTime(d *time.Duration) string {
+	if d == nil {
+		return "0.000"
+	}
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
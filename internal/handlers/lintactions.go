@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/sanix-darker/git-ci/internal/actions"
+	"github.com/sanix-darker/git-ci/internal/config"
+	"github.com/sanix-darker/git-ci/pkg/types"
+	cli "github.com/urfave/cli/v2"
+)
+
+// CmdLintActions handles the lint-actions command: it checks the `with:`
+// keys of action steps against the inputs the actions declare, from the
+// embedded database or, with --resolve-remote-actions, their action.yml
+func CmdLintActions(c *cli.Context) error {
+	cfg := buildRunnerConfig(c)
+
+	pipeline, err := parseInput(c.String("file"), cfg)
+	if err != nil {
+		return fmt.Errorf("failed to parse pipeline: %w", err)
+	}
+
+	workdir, err := getWorkdir(c)
+	if err != nil {
+		return err
+	}
+
+	lookup := newActionLookup(c, cfg, workdir)
+
+	jobNames := make([]string, 0, len(pipeline.Jobs))
+	for name := range pipeline.Jobs {
+		jobNames = append(jobNames, name)
+	}
+	sort.Strings(jobNames)
+
+	var problems []string
+	checked := 0
+	unknown := make(map[string]bool)
+	for _, jobName := range jobNames {
+		for i, step := range pipeline.Jobs[jobName].Steps {
+			if step.Uses == "" || step.Type == types.StepTypeOrb || strings.HasPrefix(step.Uses, "docker://") {
+				continue
+			}
+
+			action, ok := lookup.find(step.Uses)
+			if !ok {
+				unknown[step.Uses] = true
+				continue
+			}
+			checked++
+
+			for _, problem := range action.Check(step.With) {
+				problems = append(problems, fmt.Sprintf("job '%s' step %d (%s): %s", jobName, i+1, step.Uses, problem))
+			}
+		}
+	}
+
+	if len(unknown) > 0 {
+		uses := make([]string, 0, len(unknown))
+		for u := range unknown {
+			uses = append(uses, u)
+		}
+		sort.Strings(uses)
+		printVerbose(c, "Not checked (unknown inputs, see --resolve-remote-actions): %s\n", strings.Join(uses, ", "))
+	}
+
+	if len(problems) > 0 {
+		fmt.Println("Action input problems found:")
+		fmt.Println(strings.Repeat("-", 60))
+		for i, problem := range problems {
+			fmt.Printf("%d. %s\n", i+1, problem)
+		}
+		fmt.Println(strings.Repeat("-", 60))
+		return fmt.Errorf("lint failed with %d problem(s)", len(problems))
+	}
+
+	fmt.Printf("%s Checked %d action step(s), no problem found\n", okMark(c), checked)
+	return nil
+}
+
+// actionLookup finds the inputs of the actions used by steps
+type actionLookup struct {
+	workdir  string
+	remote   bool   // Read action.yml at the exact ref of remote actions
+	offline  bool   // Remote action.yml come from cacheDir only
+	cacheDir string // Copies of remote action.yml, "" when disabled
+	found    map[string]*actions.Action
+}
+
+func newActionLookup(c *cli.Context, cfg *config.RunnerConfig, workdir string) *actionLookup {
+	lookup := &actionLookup{
+		workdir: workdir,
+		remote:  c.Bool("resolve-remote-actions"),
+		offline: cfg.Offline,
+		found:   make(map[string]*actions.Action),
+	}
+	if cfg.CacheEnabled(config.CacheKindMetadata) {
+		lookup.cacheDir = filepath.Join(config.GetCacheDir(), "actions")
+	}
+	return lookup
+}
+
+// find returns the inputs of an action: local actions are read from the
+// workdir, remote ones from their ref when resolving, else the database
+func (l *actionLookup) find(uses string) (*actions.Action, bool) {
+	if action, ok := l.found[uses]; ok {
+		return action, action != nil
+	}
+
+	action := l.load(uses)
+	l.found[uses] = action
+	return action, action != nil
+}
+
+func (l *actionLookup) load(uses string) *actions.Action {
+	if strings.HasPrefix(uses, "./") {
+		for _, name := range []string{"action.yml", "action.yaml"} {
+			data, err := os.ReadFile(filepath.Join(l.workdir, uses, name))
+			if err != nil {
+				continue
+			}
+			action, err := actions.ParseMetadata(data)
+			if err != nil {
+				fmt.Printf("Warning: %s: %v\n", uses, err)
+				return nil
+			}
+			return action
+		}
+		return nil
+	}
+
+	if l.remote {
+		action, err := actions.Resolve(uses, l.cacheDir, l.offline)
+		if err == nil {
+			return action
+		}
+		fmt.Printf("Warning: %v (using the action database)\n", err)
+	}
+
+	if action, ok := actions.Known(uses); ok {
+		return action
+	}
+	return nil
+}
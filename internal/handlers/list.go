@@ -2,9 +2,12 @@ package handlers
 
 import (
 	"fmt"
+	"os"
 	"sort"
+	"strconv"
 	"strings"
 
+	"github.com/sanix-darker/git-ci/internal/config"
 	"github.com/sanix-darker/git-ci/pkg/types"
 	cli "github.com/urfave/cli/v2"
 )
@@ -20,12 +23,37 @@ const (
 func CmdList(c *cli.Context) error {
 	workflowFile := c.String("file")
 
+	// --mr and --changes-base preview the jobs their pipeline runs
+	cfg := &config.RunnerConfig{Offline: c.Bool("offline"), NoFetch: true, Cache: resolveCachePolicy(c)}
+	preview := c.Bool("mr") || c.IsSet("changes-base")
+	if preview {
+		workdir, err := getWorkdir(c)
+		if err != nil {
+			return err
+		}
+		cfg.WorkDir = workdir
+		if err := applyMergeRequest(c, cfg); err != nil {
+			return err
+		}
+		if err := applyChanges(c, cfg); err != nil {
+			return err
+		}
+	} else if c.IsSet("target-branch") {
+		return fmt.Errorf("--target-branch requires --mr")
+	}
+
 	// Parse input
-	pipeline, err := parseInput(workflowFile)
+	pipeline, err := parseInput(workflowFile, cfg)
 	if err != nil {
 		return fmt.Errorf("failed to parse workflow: %w", err)
 	}
 
+	// A preview marks the jobs the rules leave out
+	var skipped map[string]string
+	if preview {
+		skipped = previewRules(c, pipeline, cfg)
+	}
+
 	// Display pipeline information
 	fmt.Printf("\nPipeline: %s\n", pipeline.Name)
 
@@ -37,6 +65,13 @@ func CmdList(c *cli.Context) error {
 		fmt.Printf("Description: %s\n", pipeline.Description)
 	}
 
+	if cfg.MRTarget != "" {
+		fmt.Printf("Merge request: into %s\n", cfg.MRTarget)
+	}
+	if preview && cfg.Changes != nil {
+		fmt.Printf("Changed files: %d\n", len(cfg.Changes))
+	}
+
 	// Display stages if available
 	if len(pipeline.Stages) > 0 {
 		fmt.Printf("\nStages:\n")
@@ -54,9 +89,9 @@ func CmdList(c *cli.Context) error {
 		fmt.Printf("\nTriggers:\n")
 		for i, trigger := range pipeline.Triggers {
 			if i == len(pipeline.Triggers)-1 {
-				fmt.Printf("%s %s\n", TreeEnd, trigger)
+				fmt.Printf("%s %s%s\n", TreeEnd, trigger, getTriggerFilters(pipeline, trigger))
 			} else {
-				fmt.Printf("%s %s\n", TreeBranch, trigger)
+				fmt.Printf("%s %s%s\n", TreeBranch, trigger, getTriggerFilters(pipeline, trigger))
 			}
 		}
 	}
@@ -67,14 +102,27 @@ func CmdList(c *cli.Context) error {
 		envKeys := getSortedKeys(pipeline.Environment)
 		for i, key := range envKeys {
 			value := pipeline.Environment[key]
+			branch, pipe := TreeBranch, TreePipe
 			if i == len(envKeys)-1 {
-				fmt.Printf("%s %s=%s\n", TreeEnd, key, value)
-			} else {
-				fmt.Printf("%s %s=%s\n", TreeBranch, key, value)
+				branch, pipe = TreeEnd, "   "
+			}
+			fmt.Printf("%s %s=%s\n", branch, key, value)
+
+			// Variables defined with description and options
+			if variable, ok := pipeline.Variables[key]; ok {
+				if variable.Description != "" {
+					fmt.Printf("%s   %s\n", pipe, variable.Description)
+				}
+				if len(variable.Options) > 0 {
+					fmt.Printf("%s   Options: %s\n", pipe, strings.Join(variable.Options, ", "))
+				}
 			}
 		}
 	}
 
+	// Display deployment environments with the jobs deploying to them
+	displayEnvironments(pipeline)
+
 	// Display jobs
 	fmt.Printf("\nJobs:\n")
 
@@ -100,18 +148,49 @@ func CmdList(c *cli.Context) error {
 		}
 
 		// Display job name and runner info
-		fmt.Printf("%s %s\n", jobPrefix, jobName)
+		if reason, ok := skipped[jobName]; ok {
+			fmt.Printf("%s %s (skipped: %s)\n", jobPrefix, jobName, reason)
+		} else {
+			fmt.Printf("%s %s\n", jobPrefix, jobName)
+		}
 
 		// Display job details
 		displayJobDetails(job, childPrefix)
 	}
 
 	// Display summary
-	fmt.Printf("\nTotal: %d jobs\n", len(pipeline.Jobs))
+	if preview {
+		fmt.Printf("\nTotal: %d jobs, %d in the pipeline\n", len(pipeline.Jobs), len(pipeline.Jobs)-len(skipped))
+	} else {
+		fmt.Printf("\nTotal: %d jobs\n", len(pipeline.Jobs))
+	}
 
 	return nil
 }
 
+// previewRules returns the jobs the rules of the pipeline leave out, with
+// why; all of them when its workflow rules don't create it
+func previewRules(c *cli.Context, pipeline *types.Pipeline, cfg *config.RunnerConfig) map[string]string {
+	state := &runState{skipped: make(map[string]string)}
+
+	created, err := applyWorkflowRules(c, pipeline, cfg.WorkDir, cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		return state.skipped
+	}
+	if !created {
+		for name := range pipeline.Jobs {
+			state.skipped[name] = "pipeline not created"
+		}
+		return state.skipped
+	}
+
+	if _, err := applyRules(c, pipeline, pipeline.Jobs, cfg.WorkDir, cfg, state); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+	return state.skipped
+}
+
 func displayJobDetails(job *types.Job, prefix string) {
 	details := []struct {
 		label string
@@ -119,11 +198,20 @@ func displayJobDetails(job *types.Job, prefix string) {
 		show  bool
 	}{
 		{"Stage", job.Stage, job.Stage != ""},
+		{"Extends", strings.Join(job.Extends, ", "), len(job.Extends) > 0},
 		{"Runner", getRunnerInfo(job), true},
 		{"Image", job.Image, job.Image != ""},
 		{"Timeout", fmt.Sprintf("%d minutes", job.TimeoutMin), job.TimeoutMin > 0},
-		{"Allow Failure", "true", job.AllowFailure || job.ContinueOnErr},
-		{"When", job.When, job.When != ""},
+		{"Allow Failure", getAllowFailureInfo(job), job.AllowFailure || job.ContinueOnErr || len(job.AllowExitCodes) > 0},
+		{"Interruptible", "true", job.Interruptible},
+		{"Resource Group", job.ResourceGroup, job.ResourceGroup != ""},
+		{"Matrix", getMatrixInfo(job), job.MatrixOf != ""},
+		{"Not Inherited", strings.Join(job.ExcludedVariables, ", "), len(job.ExcludedVariables) > 0},
+		{"When", getWhenInfo(job), job.When != ""},
+		{"Environment", getEnvironmentInfo(job), job.EnvironmentName != ""},
+		{"Trigger", getTriggerInfo(job.Trigger), job.Trigger != nil},
+		{"Secrets", getSecretsInfo(job.SecretRefs), len(job.SecretRefs) > 0},
+		{"Local hints", getLocalHintsInfo(job.Local), job.Local != nil},
 	}
 
 	// Add basic job info
@@ -140,7 +228,17 @@ func displayJobDetails(job *types.Job, prefix string) {
 
 	// Display dependencies
 	if len(job.Needs) > 0 {
-		fmt.Printf("%s%s Depends on: %s\n", prefix, TreeBranch, strings.Join(job.Needs, ", "))
+		needs := make([]string, len(job.Needs))
+		for i, need := range job.Needs {
+			needs[i] = need.Job
+			if need.Optional {
+				needs[i] += " (optional)"
+			}
+			if !need.Artifacts {
+				needs[i] += " (no artifacts)"
+			}
+		}
+		fmt.Printf("%s%s Depends on: %s\n", prefix, TreeBranch, strings.Join(needs, ", "))
 	}
 
 	// Display environment variables
@@ -187,15 +285,31 @@ func displayJobDetails(job *types.Job, prefix string) {
 		}
 	}
 
-	// Display cache
-	if job.Cache != nil && len(job.Cache.Paths) > 0 {
-		fmt.Printf("%s%s Cache:\n", prefix, TreeBranch)
-		for i, path := range job.Cache.Paths {
+	// Display caches
+	for _, cache := range job.CacheConfigs() {
+		header := "Cache"
+		if cache.Key != "" {
+			header += fmt.Sprintf(" (key: %s)", cache.Key)
+		}
+		fmt.Printf("%s%s %s:\n", prefix, TreeBranch, header)
+
+		var lines []string
+		lines = append(lines, cache.Paths...)
+		if cache.Untracked {
+			lines = append(lines, "untracked files")
+		}
+		if cache.Policy != "" {
+			lines = append(lines, "policy: "+cache.Policy)
+		}
+		if len(cache.Fallback) > 0 {
+			lines = append(lines, "fallback keys: "+strings.Join(cache.Fallback, ", "))
+		}
+		for i, line := range lines {
 			cachePrefix := TreeBranch
-			if i == len(job.Cache.Paths)-1 {
+			if i == len(lines)-1 {
 				cachePrefix = TreeEnd
 			}
-			fmt.Printf("%s%s  %s %s\n", prefix, TreePipe, cachePrefix, path)
+			fmt.Printf("%s%s  %s %s\n", prefix, TreePipe, cachePrefix, line)
 		}
 	}
 
@@ -255,6 +369,205 @@ func getRunnerInfo(job *types.Job) string {
 	return "default"
 }
 
+func getEnvironmentInfo(job *types.Job) string {
+	var parts []string
+	if job.EnvironmentAction != "" {
+		parts = append(parts, "action: "+job.EnvironmentAction)
+	}
+	if env := job.Deployment; env != nil {
+		if env.URL != "" {
+			parts = append(parts, "url: "+env.URL)
+		}
+		if env.DeploymentTier != "" {
+			parts = append(parts, "tier: "+env.DeploymentTier)
+		}
+		if env.OnStop != "" {
+			parts = append(parts, "on_stop: "+env.OnStop)
+		}
+		if env.AutoStopIn != "" {
+			parts = append(parts, "auto_stop_in: "+env.AutoStopIn)
+		}
+	}
+
+	if len(parts) == 0 {
+		return job.EnvironmentName
+	}
+	return fmt.Sprintf("%s (%s)", job.EnvironmentName, strings.Join(parts, ", "))
+}
+
+// displayEnvironments lists the deployment environments of the pipeline
+// and the jobs deploying to or stopping each
+func displayEnvironments(pipeline *types.Pipeline) {
+	deployers := make(map[string][]string)
+	for name, job := range pipeline.Jobs {
+		if job.EnvironmentName != "" {
+			deployers[job.EnvironmentName] = append(deployers[job.EnvironmentName], name)
+		}
+	}
+	if len(deployers) == 0 {
+		return
+	}
+
+	fmt.Printf("\nEnvironments:\n")
+	envNames := make([]string, 0, len(deployers))
+	for envName := range deployers {
+		envNames = append(envNames, envName)
+	}
+	sort.Strings(envNames)
+	for i, envName := range envNames {
+		jobNames := deployers[envName]
+		sort.Strings(jobNames)
+		for j, name := range jobNames {
+			if pipeline.Jobs[name].IsStopJob() {
+				jobNames[j] = name + " (stop)"
+			}
+		}
+
+		branch := TreeBranch
+		if i == len(envNames)-1 {
+			branch = TreeEnd
+		}
+		fmt.Printf("%s %s: %s\n", branch, envName, strings.Join(jobNames, ", "))
+	}
+}
+
+// getWhenInfo describes when a job runs, with the delay of delayed jobs
+func getWhenInfo(job *types.Job) string {
+	if job.When == "delayed" && job.StartIn != "" {
+		return fmt.Sprintf("delayed (start_in: %s)", job.StartIn)
+	}
+	return job.When
+}
+
+// getMatrixInfo describes the strategy matrix a job is a combination of
+func getMatrixInfo(job *types.Job) string {
+	info := "of " + job.MatrixOf
+	if s := job.Strategy; s != nil {
+		if s.MaxParallel > 0 {
+			info += fmt.Sprintf(", max-parallel %d", s.MaxParallel)
+		}
+		if s.FailFast {
+			info += ", fail-fast"
+		}
+	}
+	return info
+}
+
+func getAllowFailureInfo(job *types.Job) string {
+	if len(job.AllowExitCodes) == 0 || job.AllowFailure || job.ContinueOnErr {
+		return "true"
+	}
+	codes := make([]string, len(job.AllowExitCodes))
+	for i, code := range job.AllowExitCodes {
+		codes[i] = strconv.Itoa(code)
+	}
+	return "exit codes " + strings.Join(codes, ", ")
+}
+
+// getTriggerFilters describes when an event triggers the pipeline: the
+// cron schedules, or the branch, tag and path filters of its rule
+func getTriggerFilters(pipeline *types.Pipeline, trigger string) string {
+	if trigger == "schedule" && pipeline.Metadata["schedules"] != "" {
+		return " (cron: " + pipeline.Metadata["schedules"] + ")"
+	}
+
+	for _, rule := range pipeline.Rules {
+		if rule.If != fmt.Sprintf("github.event_name == '%s'", trigger) {
+			continue
+		}
+		var filters []string
+		for _, f := range []struct {
+			label    string
+			patterns []string
+		}{
+			{"branches", rule.Branches},
+			{"branches-ignore", rule.BranchesIgnore},
+			{"tags", rule.Tags},
+			{"tags-ignore", rule.TagsIgnore},
+			{"paths", rule.Changes},
+			{"paths-ignore", rule.ChangesIgnore},
+		} {
+			if len(f.patterns) > 0 {
+				filters = append(filters, f.label+": "+strings.Join(f.patterns, ", "))
+			}
+		}
+		return " (" + strings.Join(filters, "; ") + ")"
+	}
+	return ""
+}
+
+func getSecretsInfo(secrets []*types.Secret) string {
+	parts := make([]string, len(secrets))
+	for i, secret := range secrets {
+		parts[i] = secret.Name
+		if secret.ValueFrom != nil {
+			parts[i] += " (" + secret.ValueFrom.String() + ")"
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+func getTriggerInfo(trigger *types.TriggerConfig) string {
+	if trigger == nil {
+		return ""
+	}
+
+	var info string
+	if trigger.IsChildPipeline() {
+		files := make([]string, 0, len(trigger.Include))
+		for _, inc := range trigger.Include {
+			switch {
+			case inc.Local != "":
+				files = append(files, inc.Local)
+			case inc.Artifact != "":
+				files = append(files, fmt.Sprintf("%s from %s", inc.Artifact, inc.Job))
+			case inc.Template != "":
+				files = append(files, inc.Template)
+			default:
+				files = append(files, inc.Project+":"+inc.File)
+			}
+		}
+		info = fmt.Sprintf("child pipeline (%s)", strings.Join(files, ", "))
+	} else {
+		info = "project " + trigger.Project
+		if trigger.Branch != "" {
+			info += "@" + trigger.Branch
+		}
+	}
+
+	if trigger.Strategy != "" {
+		info += ", strategy: " + trigger.Strategy
+	}
+	return info
+}
+
+func getLocalHintsInfo(hints *types.LocalHints) string {
+	if hints == nil {
+		return ""
+	}
+
+	var parts []string
+	if hints.Skip {
+		parts = append(parts, "skip")
+	}
+	if hints.Runner != "" {
+		parts = append(parts, "runner="+hints.Runner)
+	}
+	if hints.Memory != "" {
+		parts = append(parts, "memory="+hints.Memory)
+	}
+	if len(hints.Env) > 0 {
+		parts = append(parts, "env="+strings.Join(getSortedKeys(hints.Env), ","))
+	}
+	if len(hints.ArtifactPaths) > 0 {
+		parts = append(parts, "artifacts="+strings.Join(hints.ArtifactPaths, ","))
+	}
+	if len(parts) == 0 {
+		return "none"
+	}
+	return strings.Join(parts, ", ")
+}
+
 func getSortedKeys(m map[string]string) []string {
 	keys := make([]string, 0, len(m))
 	for k := range m {
@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"fmt"
+
+	"github.com/sanix-darker/git-ci/pkg/types"
+)
+
+// matrixFailures are the GitHub strategy matrices stopped by fail-fast,
+// with the job whose failure stopped them. Jobs of a stopped matrix that
+// didn't start are skipped; those running finish.
+type matrixFailures map[string]string
+
+// record stops the matrix of a failed job when it fails fast
+func (f matrixFailures) record(name string, job *types.Job) {
+	if job.MatrixOf == "" || job.Strategy == nil || !job.Strategy.FailFast {
+		return
+	}
+	if _, stopped := f[job.MatrixOf]; !stopped {
+		f[job.MatrixOf] = name
+	}
+}
+
+// skipReason returns why a job of a stopped matrix doesn't start, "" for
+// any other job
+func (f matrixFailures) skipReason(job *types.Job) string {
+	if job.MatrixOf == "" {
+		return ""
+	}
+	if failed, stopped := f[job.MatrixOf]; stopped {
+		return fmt.Sprintf("fail-fast: matrix job '%s' failed", failed)
+	}
+	return ""
+}
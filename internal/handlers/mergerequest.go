@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"fmt"
+
+	"github.com/sanix-darker/git-ci/internal/config"
+	cli "github.com/urfave/cli/v2"
+)
+
+// localMergeRequestIID is the placeholder IID of a simulated merge request
+const localMergeRequestIID = "1"
+
+// applyMergeRequest sets up the merge request pipeline of --mr: the
+// pipeline source and the target branch, which changes: rules compare to
+func applyMergeRequest(c *cli.Context, cfg *config.RunnerConfig) error {
+	if !c.Bool("mr") {
+		if c.IsSet("target-branch") {
+			return fmt.Errorf("--target-branch requires --mr")
+		}
+		return nil
+	}
+
+	target := c.String("target-branch")
+	if target == "" {
+		target = defaultBranch(cfg.WorkDir)
+	}
+	if targetRef(cfg.WorkDir, target) == "" {
+		return fmt.Errorf("target branch '%s' not found (neither %s nor origin/%s exist)", target, target, target)
+	}
+
+	cfg.MRTarget = target
+	cfg.Event = "merge_request_event"
+	printVerbose(c, "Merge request pipeline into %s\n", target)
+	return nil
+}
+
+// targetRef returns the ref of a target branch: the local branch, else
+// the one of the origin remote, "" when neither exists
+func targetRef(workdir, target string) string {
+	for _, ref := range []string{target, "origin/" + target} {
+		if gitOutput(workdir, "rev-parse", "--verify", "--quiet", ref+"^{commit}") != "" {
+			return ref
+		}
+	}
+	return ""
+}
+
+// addMergeRequestVariables adds the predefined variables of a merge
+// request pipeline from the current branch into target. As on GitLab,
+// CI_COMMIT_BRANCH is not set.
+func addMergeRequestVariables(vars map[string]string, workdir, target string) {
+	delete(vars, "CI_COMMIT_BRANCH")
+
+	vars["CI_MERGE_REQUEST_ID"] = localMergeRequestIID
+	vars["CI_MERGE_REQUEST_IID"] = localMergeRequestIID
+	vars["CI_MERGE_REQUEST_EVENT_TYPE"] = "detached"
+	vars["CI_MERGE_REQUEST_TITLE"] = vars["CI_COMMIT_TITLE"]
+	vars["CI_MERGE_REQUEST_SOURCE_BRANCH_NAME"] = vars["CI_COMMIT_REF_NAME"]
+	vars["CI_MERGE_REQUEST_TARGET_BRANCH_NAME"] = target
+	vars["CI_MERGE_REQUEST_REF_PATH"] = "refs/merge-requests/" + localMergeRequestIID + "/head"
+	vars["CI_MERGE_REQUEST_PROJECT_PATH"] = vars["CI_PROJECT_PATH"]
+	vars["CI_MERGE_REQUEST_PROJECT_URL"] = vars["CI_PROJECT_URL"]
+	vars["CI_MERGE_REQUEST_SOURCE_PROJECT_PATH"] = vars["CI_PROJECT_PATH"]
+	vars["CI_MERGE_REQUEST_SOURCE_PROJECT_URL"] = vars["CI_PROJECT_URL"]
+	vars["CI_OPEN_MERGE_REQUESTS"] = vars["CI_PROJECT_PATH"] + "!" + localMergeRequestIID
+
+	if ref := targetRef(workdir, target); ref != "" {
+		if base := gitOutput(workdir, "merge-base", ref, "HEAD"); base != "" {
+			vars["CI_MERGE_REQUEST_DIFF_BASE_SHA"] = base
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"net/url"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/sanix-darker/git-ci/internal/config"
+)
+
+// Placeholders of the predefined variables only a GitLab server knows
+const (
+	localServerURL  = "https://gitlab.com"
+	localPipelineID = "1"
+)
+
+// addCommitVariables adds the predefined variables describing the commit
+// and its ref
+func addCommitVariables(vars map[string]string, workdir string) {
+	if ref := vars["CI_COMMIT_REF_NAME"]; ref != "" {
+		vars["CI_COMMIT_REF_SLUG"] = refSlug(ref)
+	}
+
+	// As on GitLab, the before SHA is zeros when the previous commit is
+	// unknown
+	vars["CI_COMMIT_BEFORE_SHA"] = strings.Repeat("0", 40)
+	if before := gitOutput(workdir, "rev-parse", "HEAD~1"); before != "" {
+		vars["CI_COMMIT_BEFORE_SHA"] = before
+	}
+
+	if message := gitOutput(workdir, "log", "-1", "--format=%B"); message != "" {
+		title, description, _ := strings.Cut(message, "\n")
+		vars["CI_COMMIT_MESSAGE"] = message
+		vars["CI_COMMIT_TITLE"] = title
+		vars["CI_COMMIT_DESCRIPTION"] = strings.TrimSpace(description)
+	}
+	if author := gitOutput(workdir, "log", "-1", "--format=%an <%ae>"); author != "" {
+		vars["CI_COMMIT_AUTHOR"] = author
+	}
+	if timestamp := gitOutput(workdir, "log", "-1", "--format=%cI"); timestamp != "" {
+		vars["CI_COMMIT_TIMESTAMP"] = timestamp
+	}
+}
+
+// addProjectVariables adds the predefined variables describing the
+// project, read from the origin remote, and placeholders for the pipeline
+// and the server
+func addProjectVariables(vars map[string]string, workdir string) {
+	serverURL, projectPath := remoteProject(gitOutput(workdir, "remote", "get-url", "origin"))
+	if projectPath == "" {
+		name := "project"
+		if top := gitOutput(workdir, "rev-parse", "--show-toplevel"); top != "" {
+			name = filepath.Base(top)
+		} else if abs, err := filepath.Abs(workdir); err == nil {
+			name = filepath.Base(abs)
+		}
+		projectPath = "local/" + name
+	}
+	if serverURL == "" {
+		serverURL = localServerURL
+	}
+	server, _ := url.Parse(serverURL)
+
+	vars["GITLAB_CI"] = "true"
+	vars["CI_SERVER"] = "yes"
+	vars["CI_SERVER_URL"] = serverURL
+	vars["CI_SERVER_HOST"] = server.Hostname()
+	vars["CI_SERVER_PROTOCOL"] = server.Scheme
+	vars["CI_PROJECT_PATH"] = projectPath
+	vars["CI_PROJECT_PATH_SLUG"] = refSlug(projectPath)
+	vars["CI_PROJECT_NAME"] = path.Base(projectPath)
+	vars["CI_PROJECT_NAMESPACE"] = path.Dir(projectPath)
+	vars["CI_PROJECT_ROOT_NAMESPACE"] = strings.Split(projectPath, "/")[0]
+	vars["CI_PROJECT_URL"] = serverURL + "/" + projectPath
+	vars["CI_REGISTRY"] = "registry." + server.Hostname()
+	vars["CI_REGISTRY_IMAGE"] = vars["CI_REGISTRY"] + "/" + strings.ToLower(projectPath)
+	vars["CI_PIPELINE_ID"] = localPipelineID
+	vars["CI_PIPELINE_IID"] = localPipelineID
+	vars["CI_PIPELINE_URL"] = vars["CI_PROJECT_URL"] + "/-/pipelines/" + localPipelineID
+	vars["CI_PIPELINE_CREATED_AT"] = time.Now().UTC().Format(time.RFC3339)
+}
+
+// remoteProject returns the server URL and the project path of a git
+// remote URL (https://host/group/project.git, git@host:group/project.git
+// or ssh://git@host:port/group/project.git), or "" when it has none
+func remoteProject(remote string) (serverURL, projectPath string) {
+	if remote == "" {
+		return "", ""
+	}
+
+	var host string
+	if u, err := url.Parse(remote); err == nil && u.Host != "" {
+		host, projectPath = u.Hostname(), u.Path
+		if u.Scheme == "http" || u.Scheme == "https" {
+			host = u.Host
+		}
+	} else if at, rest, ok := strings.Cut(remote, ":"); ok && !strings.Contains(at, "/") {
+		// scp-like syntax: [user@]host:path
+		if _, h, found := strings.Cut(at, "@"); found {
+			at = h
+		}
+		host, projectPath = at, rest
+	} else {
+		return "", ""
+	}
+
+	projectPath = strings.TrimSuffix(strings.Trim(projectPath, "/"), ".git")
+	if host == "" || !strings.Contains(projectPath, "/") {
+		return "", ""
+	}
+
+	scheme := "https"
+	if strings.HasPrefix(remote, "http://") {
+		scheme = "http"
+	}
+	return scheme + "://" + host, projectPath
+}
+
+// refSlug returns a ref or path as GitLab slugs it: lowercased, shortened
+// to 63 bytes, with anything but 0-9 and a-z replaced by - and no leading
+// or trailing -
+func refSlug(s string) string {
+	slug := []byte(strings.ToLower(s))
+	for i, c := range slug {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') {
+			slug[i] = '-'
+		}
+	}
+	if len(slug) > 63 {
+		slug = slug[:63]
+	}
+	return strings.Trim(string(slug), "-")
+}
+
+// githubEvents are the GitHub event names of the pipeline sources --event
+// takes that have another name there
+var githubEvents = map[string]string{
+	"merge_request_event": "pull_request",
+	"web":                 "workflow_dispatch",
+	"api":                 "repository_dispatch",
+	"trigger":             "repository_dispatch",
+}
+
+// githubVariables returns the default variables of a GitHub Actions
+// run, computed from the local git checkout; a simulated merge request
+// is a pull_request event
+func githubVariables(workdir string, cfg *config.RunnerConfig) map[string]string {
+	event := "push"
+	if cfg != nil && cfg.Event != "" {
+		event = cfg.Event
+	}
+	if name, ok := githubEvents[event]; ok {
+		event = name
+	}
+
+	vars := map[string]string{
+		"CI":                "true",
+		"GITHUB_ACTIONS":    "true",
+		"GITHUB_EVENT_NAME": event,
+		"GITHUB_RUN_ID":     localPipelineID,
+		"GITHUB_RUN_NUMBER": localPipelineID,
+		"RUNNER_OS":         map[string]string{"darwin": "macOS", "windows": "Windows"}[runtime.GOOS],
+		"RUNNER_ARCH":       map[string]string{"amd64": "X64", "386": "X86", "arm64": "ARM64", "arm": "ARM"}[runtime.GOARCH],
+	}
+	if vars["RUNNER_OS"] == "" {
+		vars["RUNNER_OS"] = "Linux"
+	}
+	if actor := gitOutput(workdir, "config", "user.name"); actor != "" {
+		vars["GITHUB_ACTOR"] = actor
+	}
+
+	if sha := gitOutput(workdir, "rev-parse", "HEAD"); sha != "" {
+		vars["GITHUB_SHA"] = sha
+	}
+	if branch := gitOutput(workdir, "symbolic-ref", "--short", "HEAD"); branch != "" {
+		vars["GITHUB_REF"] = "refs/heads/" + branch
+		vars["GITHUB_REF_NAME"] = branch
+		vars["GITHUB_REF_TYPE"] = "branch"
+	} else if tag := gitOutput(workdir, "describe", "--tags", "--exact-match"); tag != "" {
+		vars["GITHUB_REF"] = "refs/tags/" + tag
+		vars["GITHUB_REF_NAME"] = tag
+		vars["GITHUB_REF_TYPE"] = "tag"
+	}
+	if cfg != nil && cfg.MRTarget != "" {
+		vars["GITHUB_BASE_REF"] = cfg.MRTarget
+		vars["GITHUB_HEAD_REF"] = vars["GITHUB_REF_NAME"]
+		vars["GITHUB_REF"] = "refs/pull/1/merge"
+	}
+
+	serverURL, repository := remoteProject(gitOutput(workdir, "remote", "get-url", "origin"))
+	if serverURL == "" {
+		serverURL = "https://github.com"
+	}
+	if repository != "" {
+		vars["GITHUB_REPOSITORY"] = repository
+		vars["GITHUB_REPOSITORY_OWNER"] = strings.Split(repository, "/")[0]
+	}
+	vars["GITHUB_SERVER_URL"] = serverURL
+	return vars
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sanix-darker/git-ci/internal/config"
+	"github.com/sanix-darker/git-ci/internal/runners"
+	"github.com/sanix-darker/git-ci/pkg/types"
+)
+
+func TestPredefinedVariablesFromGit(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"main.go": "package main\n"})
+	gitRun(t, dir, "init", "-q", "-b", "feature/Login-Page")
+	gitRun(t, dir, "remote", "add", "origin", "git@gitlab.example.com:group/sub/project.git")
+	commit := func(message string) {
+		gitRun(t, dir, "add", "-A")
+		gitRun(t, dir, "-c", "user.name=Jane Doe", "-c", "user.email=jane@example.com", "commit", "-q", "--allow-empty", "-m", message)
+	}
+	commit("init")
+	first := gitOutput(dir, "rev-parse", "HEAD")
+	commit("Add the login page\n\nWith a form.")
+	sha := gitOutput(dir, "rev-parse", "HEAD")
+
+	vars := predefinedVariables(dir, &config.RunnerConfig{})
+	want := map[string]string{
+		"CI":                    "true",
+		"GITLAB_CI":             "true",
+		"CI_PIPELINE_SOURCE":    "push",
+		"CI_COMMIT_SHA":         sha,
+		"CI_COMMIT_SHORT_SHA":   sha[:8],
+		"CI_COMMIT_BEFORE_SHA":  first,
+		"CI_COMMIT_BRANCH":      "feature/Login-Page",
+		"CI_COMMIT_REF_NAME":    "feature/Login-Page",
+		"CI_COMMIT_REF_SLUG":    "feature-login-page",
+		"CI_COMMIT_TITLE":       "Add the login page",
+		"CI_COMMIT_DESCRIPTION": "With a form.",
+		"CI_COMMIT_AUTHOR":      "Jane Doe <jane@example.com>",
+		"CI_SERVER_URL":         "https://gitlab.example.com",
+		"CI_SERVER_HOST":        "gitlab.example.com",
+		"CI_PROJECT_PATH":       "group/sub/project",
+		"CI_PROJECT_PATH_SLUG":  "group-sub-project",
+		"CI_PROJECT_NAME":       "project",
+		"CI_PROJECT_NAMESPACE":  "group/sub",
+		"CI_PROJECT_URL":        "https://gitlab.example.com/group/sub/project",
+		"CI_REGISTRY_IMAGE":     "registry.gitlab.example.com/group/sub/project",
+		"CI_PIPELINE_ID":        localPipelineID,
+	}
+	for name, value := range want {
+		if vars[name] != value {
+			t.Errorf("%s = %q, want %q", name, vars[name], value)
+		}
+	}
+	if _, ok := vars["CI_COMMIT_TAG"]; ok {
+		t.Error("CI_COMMIT_TAG set in a branch pipeline")
+	}
+
+	// --env wins over the predefined variables
+	cfg := &config.RunnerConfig{
+		PipelineEnv: vars,
+		Environment: map[string]string{"CI_COMMIT_SHORT_SHA": "override"},
+	}
+	if got := runners.JobVariables(&types.Job{Name: "build"}, cfg)["CI_COMMIT_SHORT_SHA"]; got != "override" {
+		t.Errorf("CI_COMMIT_SHORT_SHA = %q with --env, want override", got)
+	}
+}
+
+func TestPredefinedVariablesWithoutGit(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "My App")
+	// Keep git from finding a repository above the temporary directory
+	t.Setenv("GIT_CEILING_DIRECTORIES", filepath.Dir(dir))
+	writeFiles(t, dir, map[string]string{"main.go": "package main\n"})
+
+	vars := predefinedVariables(dir, &config.RunnerConfig{Event: "schedule"})
+	want := map[string]string{
+		"CI_PIPELINE_SOURCE":   "schedule",
+		"CI_DEFAULT_BRANCH":    "main",
+		"CI_COMMIT_BEFORE_SHA": strings.Repeat("0", 40),
+		"CI_SERVER_URL":        localServerURL,
+		"CI_PROJECT_PATH":      "local/My App",
+		"CI_PROJECT_PATH_SLUG": "local-my-app",
+		"CI_PROJECT_NAME":      "My App",
+	}
+	for name, value := range want {
+		if vars[name] != value {
+			t.Errorf("%s = %q, want %q", name, vars[name], value)
+		}
+	}
+	for _, name := range []string{"CI_COMMIT_SHA", "CI_COMMIT_BRANCH", "CI_COMMIT_REF_SLUG"} {
+		if _, ok := vars[name]; ok {
+			t.Errorf("%s set outside a git repository", name)
+		}
+	}
+}
+
+func TestRemoteProject(t *testing.T) {
+	tests := map[string][2]string{
+		"https://gitlab.com/group/project.git":        {"https://gitlab.com", "group/project"},
+		"http://localhost:8080/group/project":         {"http://localhost:8080", "group/project"},
+		"git@gitlab.com:group/sub/project.git":        {"https://gitlab.com", "group/sub/project"},
+		"ssh://git@gitlab.com:2222/group/project.git": {"https://gitlab.com", "group/project"},
+		"https://gitlab.com/project.git":              {"", ""},
+		"/srv/git/project.git":                        {"", ""},
+		"":                                            {"", ""},
+	}
+	for remote, want := range tests {
+		server, path := remoteProject(remote)
+		if server != want[0] || path != want[1] {
+			t.Errorf("remoteProject(%q) = %q, %q, want %q, %q", remote, server, path, want[0], want[1])
+		}
+	}
+}
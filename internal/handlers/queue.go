@@ -0,0 +1,210 @@
+package handlers
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sanix-darker/git-ci/pkg/types"
+)
+
+// jobQueue is the state of the parallel scheduler: the jobs waiting for
+// upstream jobs, those ready to start, running and done
+type jobQueue struct {
+	jobs        map[string]*types.Job
+	graph       *jobGraph
+	maxParallel int
+
+	position map[string]int      // Index of each job in graph.order
+	pending  map[string]int      // Upstream jobs each job still waits for
+	down     map[string][]string // Direct downstream jobs
+	ready    []string            // Jobs waiting for a free slot
+	running  map[string]time.Time
+	done     map[string]bool
+
+	held    map[string]string        // Resource groups taken by running jobs
+	blocked map[string]time.Time     // Ready jobs kept back by a taken resource group, since when
+	waited  map[string]time.Duration // How long started jobs waited for their resource group
+}
+
+// newJobQueue returns the queue of a run, the jobs without upstream jobs
+// being ready
+func newJobQueue(jobs map[string]*types.Job, graph *jobGraph, maxParallel int) *jobQueue {
+	q := &jobQueue{
+		jobs:        jobs,
+		graph:       graph,
+		maxParallel: maxParallel,
+		position:    make(map[string]int, len(graph.order)),
+		pending:     make(map[string]int, len(graph.order)),
+		down:        make(map[string][]string),
+		running:     make(map[string]time.Time),
+		done:        make(map[string]bool),
+		held:        make(map[string]string),
+		blocked:     make(map[string]time.Time),
+		waited:      make(map[string]time.Duration),
+	}
+
+	for i, name := range graph.order {
+		q.position[name] = i
+		q.pending[name] = len(graph.deps[name])
+		for _, dep := range graph.deps[name] {
+			q.down[dep] = append(q.down[dep], name)
+		}
+		if q.pending[name] == 0 {
+			q.ready = append(q.ready, name)
+		}
+	}
+	return q
+}
+
+// next returns the first ready job in pipeline order when a slot is free,
+// skipping the jobs whose resource group is taken and those whose matrix
+// runs as many jobs as its max-parallel
+func (q *jobQueue) next() (string, bool) {
+	if len(q.ready) == 0 || len(q.running) >= q.maxParallel {
+		return "", false
+	}
+
+	sort.Slice(q.ready, func(i, j int) bool { return q.position[q.ready[i]] < q.position[q.ready[j]] })
+	for i, name := range q.ready {
+		if q.matrixFull(name) {
+			continue
+		}
+		if group := q.jobs[name].ResourceGroup; group != "" && q.held[group] != "" {
+			if _, ok := q.blocked[name]; !ok {
+				q.blocked[name] = time.Now()
+			}
+			continue
+		}
+		q.ready = append(q.ready[:i], q.ready[i+1:]...)
+		return name, true
+	}
+	return "", false
+}
+
+// start marks a job as running, taking its resource group
+func (q *jobQueue) start(name string) {
+	now := time.Now()
+	q.running[name] = now
+	if group := q.jobs[name].ResourceGroup; group != "" {
+		q.held[group] = name
+	}
+	if since, ok := q.blocked[name]; ok {
+		q.waited[name] = now.Sub(since)
+		delete(q.blocked, name)
+	}
+}
+
+// release marks a job done, frees its resource group and queues the jobs
+// it was the last dependency of
+func (q *jobQueue) release(name string) {
+	delete(q.running, name)
+	q.done[name] = true
+	if group := q.jobs[name].ResourceGroup; group != "" && q.held[group] == name {
+		delete(q.held, group)
+	}
+	for _, next := range q.down[name] {
+		q.pending[next]--
+		if q.pending[next] == 0 {
+			q.ready = append(q.ready, next)
+		}
+	}
+}
+
+// matrixFull reports whether the strategy matrix of a job already runs
+// its max-parallel jobs
+func (q *jobQueue) matrixFull(name string) bool {
+	job := q.jobs[name]
+	if job.MatrixOf == "" || job.Strategy == nil || job.Strategy.MaxParallel <= 0 {
+		return false
+	}
+
+	running := 0
+	for other := range q.running {
+		if q.jobs[other].MatrixOf == job.MatrixOf {
+			running++
+		}
+	}
+	return running >= job.Strategy.MaxParallel
+}
+
+// idle reports whether no job is running or ready anymore
+func (q *jobQueue) idle() bool {
+	return len(q.ready) == 0 && len(q.running) == 0
+}
+
+// blockedReason returns why a job that didn't start yet waits, or "" for
+// a job running or done
+func (q *jobQueue) blockedReason(name string) string {
+	if _, running := q.running[name]; running || q.done[name] {
+		return ""
+	}
+	if q.pending[name] == 0 {
+		if group := q.jobs[name].ResourceGroup; group != "" && q.held[group] != "" {
+			return fmt.Sprintf("resource group '%s' taken by %s", group, q.held[group])
+		}
+		if q.matrixFull(name) {
+			return fmt.Sprintf("matrix of %s at max-parallel %d", q.jobs[name].MatrixOf, q.jobs[name].Strategy.MaxParallel)
+		}
+		return fmt.Sprintf("waiting for a free slot (max %d)", q.maxParallel)
+	}
+
+	// Upstream jobs named by the job, the others come from stage order
+	needs := make(map[string]bool)
+	for _, need := range append(q.jobs[name].NeedNames(), q.jobs[name].Dependencies...) {
+		needs[need] = true
+	}
+
+	var named, stage []string
+	for _, dep := range q.graph.deps[name] {
+		if q.done[dep] {
+			continue
+		}
+		label := dep
+		if _, running := q.running[dep]; running {
+			label += " (running)"
+		}
+		if needs[dep] {
+			named = append(named, label)
+		} else {
+			stage = append(stage, label)
+		}
+	}
+
+	var reasons []string
+	if len(named) > 0 {
+		reasons = append(reasons, "needs "+strings.Join(named, ", "))
+	}
+	if len(stage) > 0 {
+		reasons = append(reasons, "earlier stages: "+strings.Join(stage, ", "))
+	}
+	return strings.Join(reasons, "; ")
+}
+
+// printStatus prints the running jobs with their elapsed time and the
+// waiting jobs with what they wait for
+func (q *jobQueue) printStatus() {
+	var running, waiting []string
+	for _, name := range q.graph.order {
+		if started, ok := q.running[name]; ok {
+			running = append(running, fmt.Sprintf("  running  %s (%s)", name, formatDuration(time.Since(started))))
+		} else if reason := q.blockedReason(name); reason != "" {
+			waiting = append(waiting, fmt.Sprintf("  waiting  %s: %s", name, reason))
+		}
+	}
+
+	fmt.Printf("Queue: %d running, %d waiting, %d done\n", len(running), len(waiting), len(q.done))
+	for _, line := range append(running, waiting...) {
+		fmt.Println(line)
+	}
+}
+
+// printResourceWaits prints how long jobs waited for their resource group
+func (q *jobQueue) printResourceWaits() {
+	for _, name := range q.graph.order {
+		if waited, ok := q.waited[name]; ok {
+			fmt.Printf("Job '%s' waited %s for resource group '%s'\n", name, formatDuration(waited), q.jobs[name].ResourceGroup)
+		}
+	}
+}
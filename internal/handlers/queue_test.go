@@ -0,0 +1,251 @@
+package handlers
+
+import (
+	"flag"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sanix-darker/git-ci/internal/config"
+	"github.com/sanix-darker/git-ci/internal/expressions"
+	"github.com/sanix-darker/git-ci/pkg/types"
+	cli "github.com/urfave/cli/v2"
+)
+
+// diamondPipeline returns build → (unit, lint) → deploy
+func diamondPipeline() *types.Pipeline {
+	return &types.Pipeline{
+		Provider: "github",
+		Jobs: map[string]*types.Job{
+			"build":  {},
+			"unit":   {Needs: types.NeedsOf("build")},
+			"lint":   {Needs: types.NeedsOf("build")},
+			"deploy": {Needs: types.NeedsOf("unit", "lint")},
+		},
+	}
+}
+
+// parallelRun is what runJobsParallel did with the jobs of a pipeline
+type parallelRun struct {
+	started []string // Jobs in start order
+	peak    int      // Most jobs running at once
+	err     error    // Result of the run
+}
+
+// runParallel runs a pipeline with runJobsParallel, the jobs sleeping
+// instead of running and those in failing failing. Every job must start
+// after its upstream jobs are done.
+func runParallel(t *testing.T, pipeline *types.Pipeline, maxParallel int, failing ...string) *parallelRun {
+	t.Helper()
+	t.Setenv("GIT_CI_CACHE_DIR", t.TempDir())
+
+	graph, err := newJobGraph(pipeline, pipeline.Jobs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	run := &parallelRun{}
+	var mu sync.Mutex
+	running := 0
+	done := make(map[string]bool)
+
+	defer func(original func(*cli.Context, string, *types.Job, string, *config.RunnerConfig, *runState, expressions.Context) jobResult) {
+		parallelJob = original
+	}(parallelJob)
+	parallelJob = func(c *cli.Context, name string, j *types.Job, workdir string, cfg *config.RunnerConfig, state *runState, contexts expressions.Context) jobResult {
+		mu.Lock()
+		for _, dep := range graph.deps[name] {
+			if !done[dep] {
+				t.Errorf("job %s started before %s was done", name, dep)
+			}
+		}
+		run.started = append(run.started, name)
+		running++
+		run.peak = max(run.peak, running)
+		mu.Unlock()
+
+		time.Sleep(50 * time.Millisecond)
+
+		mu.Lock()
+		running--
+		done[name] = true
+		mu.Unlock()
+
+		if slices.Contains(failing, name) {
+			return jobResult{name: name, err: fmt.Errorf("%s failed", name)}
+		}
+		return jobResult{name: name}
+	}
+
+	set := flag.NewFlagSet("run", flag.ContinueOnError)
+	set.Int("max-parallel", maxParallel, "")
+	c := cli.NewContext(nil, set, nil)
+
+	cfg := config.DefaultConfig()
+	cfg.Heartbeat = 0
+	run.err = runJobsParallel(c, pipeline.Jobs, graph, t.TempDir(), cfg, newRunState(pipeline, cfg))
+	return run
+}
+
+func TestRunJobsParallelDiamond(t *testing.T) {
+	tests := []struct {
+		maxParallel int
+		peak        int
+	}{
+		{4, 2},
+		{2, 2},
+		{1, 1},
+	}
+
+	for _, tt := range tests {
+		run := runParallel(t, diamondPipeline(), tt.maxParallel)
+		if run.err != nil {
+			t.Errorf("max %d: %v", tt.maxParallel, run.err)
+		}
+		if len(run.started) != 4 || run.started[0] != "build" || run.started[3] != "deploy" {
+			t.Errorf("max %d: started %v", tt.maxParallel, run.started)
+		}
+		if run.peak != tt.peak {
+			t.Errorf("max %d: %d jobs ran at once, want %d", tt.maxParallel, run.peak, tt.peak)
+		}
+	}
+
+	// One slot runs the ready jobs in pipeline order
+	if run := runParallel(t, diamondPipeline(), 1); strings.Join(run.started, ",") != "build,lint,unit,deploy" {
+		t.Errorf("max 1: started %v", run.started)
+	}
+}
+
+func TestRunJobsParallelSkipsDownstreamOfFailures(t *testing.T) {
+	tests := []struct {
+		failing string
+		started string
+	}{
+		{"build", "build"},
+		{"unit", "build,lint,unit"},
+		{"deploy", "build,lint,unit,deploy"},
+	}
+
+	for _, tt := range tests {
+		run := runParallel(t, diamondPipeline(), 4, tt.failing)
+		started := slices.Clone(run.started)
+		slices.Sort(started)
+		want := strings.Split(tt.started, ",")
+		slices.Sort(want)
+		if !slices.Equal(started, want) {
+			t.Errorf("%s failing: started %v, want %v", tt.failing, run.started, want)
+		}
+		if run.err == nil {
+			t.Errorf("%s failing: the run succeeded", tt.failing)
+		}
+	}
+}
+
+// stagePipeline returns a GitLab pipeline of three stages: compile and
+// assets, then unit (needing compile) and lint, then two deploy jobs
+// sharing a resource group
+func stagePipeline() *types.Pipeline {
+	return &types.Pipeline{
+		Provider: "gitlab",
+		Stages:   []string{"build", "test", "deploy"},
+		Jobs: map[string]*types.Job{
+			"compile":   {Stage: "build"},
+			"assets":    {Stage: "build"},
+			"unit":      {Stage: "test", Needs: types.NeedsOf("compile")},
+			"lint":      {Stage: "test"},
+			"deploy-eu": {Stage: "deploy", Needs: types.NeedsOf("unit"), ResourceGroup: "prod"},
+			"deploy-us": {Stage: "deploy", Needs: types.NeedsOf("unit"), ResourceGroup: "prod"},
+		},
+	}
+}
+
+func TestJobQueueBlockedReasons(t *testing.T) {
+	pipeline := stagePipeline()
+	graph, err := newJobGraph(pipeline, pipeline.Jobs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := newJobQueue(pipeline.Jobs, graph, 1)
+
+	// next starts the job it returns, as runJobsParallel does
+	next := func(want string) {
+		t.Helper()
+		name, ok := q.next()
+		if !ok || name != want {
+			t.Fatalf("next = %q, %v; want %s", name, ok, want)
+		}
+		q.start(name)
+	}
+	expect := func(state string, want map[string]string) {
+		t.Helper()
+		for name, reason := range want {
+			if got := q.blockedReason(name); got != reason {
+				t.Errorf("%s: %s waits for %q, want %q", state, name, got, reason)
+			}
+		}
+	}
+
+	next("assets")
+	expect("assets running", map[string]string{
+		"assets":    "",
+		"compile":   "waiting for a free slot (max 1)",
+		"unit":      "needs compile",
+		"lint":      "earlier stages: assets (running), compile",
+		"deploy-eu": "needs unit",
+	})
+
+	q.release("assets")
+	next("compile")
+	expect("compile running", map[string]string{
+		"assets": "",
+		"unit":   "needs compile (running)",
+		"lint":   "earlier stages: compile (running)",
+	})
+
+	q.release("compile")
+	next("lint")
+	q.release("lint")
+	next("unit")
+	expect("unit running", map[string]string{
+		"deploy-us": "needs unit (running)",
+	})
+
+	q.release("unit")
+	q.maxParallel = 2
+	next("deploy-eu")
+	if name, ok := q.next(); ok {
+		t.Fatalf("%s started while deploy-eu holds the resource group", name)
+	}
+	expect("deploy-eu running", map[string]string{
+		"deploy-eu": "",
+		"deploy-us": "resource group 'prod' taken by deploy-eu",
+	})
+}
+
+func TestJobQueueBlockedByMatrixMaxParallel(t *testing.T) {
+	strategy := &types.Strategy{MaxParallel: 1}
+	pipeline := &types.Pipeline{
+		Provider: "github",
+		Jobs: map[string]*types.Job{
+			"test-1": {MatrixOf: "test", Strategy: strategy},
+			"test-2": {MatrixOf: "test", Strategy: strategy},
+		},
+	}
+	graph, err := newJobGraph(pipeline, pipeline.Jobs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := newJobQueue(pipeline.Jobs, graph, 4)
+
+	name, _ := q.next()
+	q.start(name)
+	if other, ok := q.next(); ok {
+		t.Fatalf("%s started past the max-parallel of the matrix", other)
+	}
+	if got, want := q.blockedReason("test-2"), "matrix of test at max-parallel 1"; got != want {
+		t.Errorf("test-2 waits for %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,278 @@
+package handlers
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/sanix-darker/git-ci/internal/config"
+	"github.com/sanix-darker/git-ci/internal/expressions"
+	"github.com/sanix-darker/git-ci/internal/rules"
+	"github.com/sanix-darker/git-ci/internal/runners"
+	"github.com/sanix-darker/git-ci/pkg/types"
+	cli "github.com/urfave/cli/v2"
+)
+
+// applyRules evaluates the GitLab `rules:` of the selected jobs, or their
+// `only`/`except` when they have no rules. Jobs left out of the pipeline
+// (rules resolving to `never` or `manual`, unmatched only/except) are
+// dropped and reported in the summary, unless they were asked for with
+// --job or --force-all is set; the variables of the matching rule are
+// injected into the job.
+func applyRules(c *cli.Context, pipeline *types.Pipeline, jobs map[string]*types.Job, workdir string, cfg *config.RunnerConfig, state *runState) (map[string]*types.Job, error) {
+	if pipeline.Provider != "gitlab" {
+		return jobs, nil
+	}
+
+	predefined := predefinedVariables(workdir, cfg)
+	// --job names jobs of the parent pipeline, not of child pipelines
+	force := (c.String("job") != "" && state.depth == 0) || c.Bool("force-all")
+
+	selected := make(map[string]*types.Job)
+	for name, job := range jobs {
+		env := rulesEnvironment(pipeline, job, predefined, cfg)
+
+		if len(job.Rules) == 0 {
+			runs, reason, err := rules.EvaluateOnlyExcept(job.Only, job.Except, env, cfg.Changes)
+			if err != nil {
+				return nil, fmt.Errorf("job '%s': %w", name, err)
+			}
+			if !runs && !force {
+				state.skipped[name] = reason
+				continue
+			}
+			selected[name] = job
+			continue
+		}
+
+		result, err := rules.Evaluate(job.Rules, env, workdir, cfg.Changes)
+		if err != nil {
+			return nil, fmt.Errorf("job '%s': %w", name, err)
+		}
+
+		if !force {
+			switch result.When {
+			case rules.WhenNever:
+				state.skipped[name] = "rules: when never"
+				continue
+			case rules.WhenManual:
+				state.skipped[name] = "rules: when manual (use --job to run it)"
+				continue
+			case rules.WhenDelayed:
+				if !c.Bool("include-delayed") {
+					state.skipped[name] = "rules: when delayed (use --include-delayed to run it)"
+					continue
+				}
+			}
+		}
+		if result.When == rules.WhenDelayed {
+			job.When, job.StartIn = result.When, result.StartIn
+		}
+
+		if result.Rule >= 0 {
+			printVerbose(c, "Job '%s' matched rule %d (when: %s)\n", name, result.Rule+1, result.When)
+		}
+
+		if len(result.Variables) > 0 {
+			merged := make(map[string]string, len(job.Environment)+len(result.Variables))
+			for k, v := range job.Environment {
+				merged[k] = v
+			}
+			for k, v := range result.Variables {
+				merged[k] = v
+			}
+			job.Environment = merged
+		}
+		if result.AllowFailure {
+			job.AllowFailure = true
+		}
+		if len(result.AllowExitCodes) > 0 {
+			job.AllowExitCodes = result.AllowExitCodes
+		}
+
+		selected[name] = job
+	}
+
+	for name, reason := range state.skipped {
+		printVerbose(c, "Skipping job '%s': %s\n", name, reason)
+		state.record.jobSkipped(name, reason)
+	}
+
+	// Only optional needs may point at a job left out, as on GitLab
+	names := make([]string, 0, len(selected))
+	for name := range selected {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, need := range selected[name].Needs {
+			if reason, skipped := state.skipped[need.Job]; skipped && !need.Optional {
+				return nil, fmt.Errorf("job '%s' needs job '%s', which is not in the pipeline (%s); use `optional: true` if it may be missing",
+					name, need.Job, reason)
+			}
+		}
+	}
+
+	return selected, nil
+}
+
+// applyWorkflowRules evaluates the GitLab `workflow: rules:`, reporting
+// whether the pipeline is created; the variables of the matching rule are
+// added to the pipeline variables. --force-all ignores the rules.
+func applyWorkflowRules(c *cli.Context, pipeline *types.Pipeline, workdir string, cfg *config.RunnerConfig) (bool, error) {
+	if pipeline.Provider != "gitlab" || len(pipeline.Rules) == 0 {
+		return true, nil
+	}
+	if c.Bool("force-all") {
+		printVerbose(c, "Ignoring workflow rules (--force-all)\n")
+		return true, nil
+	}
+
+	env := rulesEnvironment(pipeline, &types.Job{}, predefinedVariables(workdir, cfg), cfg)
+	result, err := rules.Evaluate(pipeline.Rules, env, workdir, cfg.Changes)
+	if err != nil {
+		return false, fmt.Errorf("workflow: %w", err)
+	}
+
+	if result.When == rules.WhenNever {
+		reason := "no workflow rule matched"
+		if result.Rule >= 0 {
+			reason = fmt.Sprintf("workflow rule %d: when never", result.Rule+1)
+		}
+		fmt.Printf("Pipeline not created (%s, CI_PIPELINE_SOURCE=%s)\n", reason, env["CI_PIPELINE_SOURCE"])
+		return false, nil
+	}
+	printVerbose(c, "Pipeline matched workflow rule %d\n", result.Rule+1)
+
+	if len(result.Variables) > 0 {
+		merged := make(map[string]string, len(pipeline.Environment)+len(result.Variables))
+		for k, v := range pipeline.Environment {
+			merged[k] = v
+		}
+		for k, v := range result.Variables {
+			merged[k] = v
+		}
+		pipeline.Environment = merged
+		cfg.PipelineEnv = pipelineEnvironment(pipeline, cfg)
+	}
+	return true, nil
+}
+
+// rulesEnvironment returns the variables visible to a job's rules, from
+// lowest to highest precedence: pipeline variables, job variables,
+// predefined CI variables and --env
+func rulesEnvironment(pipeline *types.Pipeline, job *types.Job, predefined map[string]string, cfg *config.RunnerConfig) map[string]string {
+	env := make(map[string]string)
+	for _, vars := range []map[string]string{pipeline.Environment, job.Environment, predefined, cfg.Environment} {
+		for k, v := range vars {
+			env[k] = v
+		}
+	}
+	return env
+}
+
+// pipelineEnvironment returns the pipeline-level variables jobs see: the
+// predefined CI variables on GitLab and the default ones on GitHub, then
+// the pipeline variables
+func pipelineEnvironment(pipeline *types.Pipeline, cfg *config.RunnerConfig) map[string]string {
+	env := make(map[string]string)
+	switch pipeline.Provider {
+	case "gitlab":
+		env = predefinedVariables(cfg.WorkDir, cfg)
+	case "github":
+		return workflowEnvironment(pipeline.Environment, githubVariables(cfg.WorkDir, cfg), cfg)
+	}
+	for k, v := range pipeline.Environment {
+		env[k] = v
+	}
+	return env
+}
+
+// workflowEnvironment adds the `env:` of a GitHub workflow to its default
+// variables, with its ${{ }} expressions resolved
+func workflowEnvironment(workflowEnv, env map[string]string, cfg *config.RunnerConfig) map[string]string {
+	expanded, err := runners.ExpandEnv(workflowEnv, env, "github")
+	if err != nil {
+		expanded = workflowEnv
+	}
+	ctx := runners.ExpressionContext(env, cfg)
+	for k, v := range expanded {
+		env[k], _ = expressions.Interpolate(v, ctx, expressions.StatusSuccess)
+	}
+	return env
+}
+
+// includeVariables returns the variables include rules see: predefined
+// CI variables of the checkout holding the workflow file, and --env
+func includeVariables(workflowFile string, cfg *config.RunnerConfig) map[string]string {
+	vars := predefinedVariables(filepath.Dir(workflowFile), cfg)
+	if cfg != nil {
+		for k, v := range cfg.Environment {
+			vars[k] = v
+		}
+	}
+	return vars
+}
+
+// predefinedVariables returns the predefined CI_* variables of a GitLab
+// pipeline, computed from the local git checkout; values only a server
+// knows are placeholders. CI_PIPELINE_SOURCE comes from --event, the
+// merge request variables from --mr.
+func predefinedVariables(workdir string, cfg *config.RunnerConfig) map[string]string {
+	event := "push"
+	if cfg != nil && cfg.Event != "" {
+		event = cfg.Event
+	}
+
+	vars := map[string]string{
+		"CI":                 "true",
+		"CI_PIPELINE_SOURCE": event,
+		"CI_DEFAULT_BRANCH":  defaultBranch(workdir),
+	}
+
+	if sha := gitOutput(workdir, "rev-parse", "HEAD"); sha != "" {
+		vars["CI_COMMIT_SHA"] = sha
+		if len(sha) >= 8 {
+			vars["CI_COMMIT_SHORT_SHA"] = sha[:8]
+		}
+	}
+
+	// As on GitLab, branch pipelines have CI_COMMIT_BRANCH and tag
+	// pipelines CI_COMMIT_TAG, never both
+	if branch := gitOutput(workdir, "symbolic-ref", "--short", "HEAD"); branch != "" {
+		vars["CI_COMMIT_BRANCH"] = branch
+		vars["CI_COMMIT_REF_NAME"] = branch
+	} else if tag := gitOutput(workdir, "describe", "--tags", "--exact-match"); tag != "" {
+		vars["CI_COMMIT_TAG"] = tag
+		vars["CI_COMMIT_REF_NAME"] = tag
+	}
+
+	addCommitVariables(vars, workdir)
+	addProjectVariables(vars, workdir)
+	if cfg != nil && cfg.MRTarget != "" {
+		addMergeRequestVariables(vars, workdir, cfg.MRTarget)
+	}
+	return vars
+}
+
+// defaultBranch returns the default branch of the origin remote, main
+// when unknown
+func defaultBranch(workdir string) string {
+	if head := gitOutput(workdir, "symbolic-ref", "--short", "refs/remotes/origin/HEAD"); head != "" {
+		return strings.TrimPrefix(head, "origin/")
+	}
+	return "main"
+}
+
+// gitOutput runs a git command in workdir, returning "" on failure
+func gitOutput(workdir string, args ...string) string {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = workdir
+	output, err := cmd.Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(output))
+}
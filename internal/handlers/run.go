@@ -1,13 +1,18 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
+	"os"
 	"runtime"
+	"sort"
+	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/sanix-darker/git-ci/internal/config"
+	"github.com/sanix-darker/git-ci/internal/expressions"
+	"github.com/sanix-darker/git-ci/internal/parsers"
 	"github.com/sanix-darker/git-ci/internal/runners"
 	"github.com/sanix-darker/git-ci/pkg/types"
 	cli "github.com/urfave/cli/v2"
@@ -15,16 +20,70 @@ import (
 
 // CmdRun handles the run command
 func CmdRun(c *cli.Context) error {
+	switch format := c.String("output"); format {
+	case "", "text":
+		return runPipeline(c, nil)
+	case "json":
+		return runJSON(c)
+	default:
+		return fmt.Errorf("unknown output format '%s' (text, json)", format)
+	}
+}
+
+// runPipeline runs the pipeline of the run command; started receives the
+// bookkeeping of the run once it exists
+func runPipeline(c *cli.Context, started func(*runState)) error {
 	// Get file path
 	filePath := c.String("file")
 
+	// Build runner configuration
+	cfg := buildRunnerConfig(c)
+
+	// --print-script renders the jobs, nothing runs or is recorded
+	if c.Bool("print-script") {
+		cfg.DryRun = true
+	}
+	if _, err := runners.LookupTheme(cfg.Theme); err != nil {
+		return err
+	}
+	if err := checkReportFormat(c.String("report")); err != nil {
+		return err
+	}
+	if err := checkVolumes(c, cfg); err != nil {
+		return err
+	}
+	if err := checkNetwork(c, cfg); err != nil {
+		return err
+	}
+	if err := applyMergeRequest(c, cfg); err != nil {
+		return err
+	}
+	if err := applyChanges(c, cfg); err != nil {
+		return err
+	}
+
+	if disabled := cfg.Cache.Disabled(); len(disabled) > 0 {
+		printVerbose(c, "Caches disabled for this run: %s\n", strings.Join(disabled, ", "))
+	}
+
 	// Parse pipeline
-	pipeline, err := parseInput(filePath)
+	pipeline, err := parseInput(filePath, cfg)
 	if err != nil {
 		return fmt.Errorf("failed to parse pipeline: %w", err)
 	}
 
 	printVerbose(c, "Parsed pipeline: %s\n", pipeline.Name)
+	cfg.Provider = pipeline.Provider
+	cfg.PipelineEnv = pipelineEnvironment(pipeline, cfg)
+
+	if err := checkPipelineVars(c, pipeline); err != nil {
+		return err
+	}
+
+	// Continue in the background, detached from the terminal
+	if c.Bool("detach") && !isDetachedRun() {
+		return detachRun(c)
+	}
 
 	// Get working directory
 	workdir, err := getWorkdir(c)
@@ -32,8 +91,17 @@ func CmdRun(c *cli.Context) error {
 		return err
 	}
 
-	// Build runner configuration
-	cfg := buildRunnerConfig(c)
+	// Workflow rules may keep the whole pipeline from being created
+	created, err := applyWorkflowRules(c, pipeline, workdir, cfg)
+	if err != nil {
+		return err
+	}
+	if !created {
+		if c.Bool("fail-on-filtered") {
+			return fmt.Errorf("pipeline filtered out by its workflow rules")
+		}
+		return nil
+	}
 
 	// Determine which jobs to run
 	jobs := selectJobsToRun(c, pipeline)
@@ -41,13 +109,255 @@ func CmdRun(c *cli.Context) error {
 		return fmt.Errorf("no jobs to run")
 	}
 
-	// Check if running in parallel
+	// Apply local-only hints from the pipeline file
+	applyLocalHints(c, jobs)
+
+	// Artifacts and results are tracked per run
+	state := newRunState(pipeline, cfg)
+	cfg.RunID = state.id
+	defer state.removeWorkspaces()
+	if started != nil {
+		started(state)
+	}
+
+	// A detached run publishes its process so attach and cancel find it
+	if isDetachedRun() {
+		state.record.run.Metadata["pid"] = strconv.Itoa(os.Getpid())
+		state.record.run.Metadata["log"] = runLogPath(state.id)
+	}
+	if !cfg.DryRun {
+		if err := state.record.start(); err != nil {
+			printVerbose(c, "Warning: failed to save run record: %v\n", err)
+		}
+	}
+	stopInterrupt := handleInterrupt(state, !cfg.DryRun)
+	defer stopInterrupt()
+
+	// Drop jobs whose rules keep them out of the pipeline
+	jobs, err = applyRules(c, pipeline, jobs, workdir, cfg, state)
+	if err != nil {
+		return err
+	}
+
+	// Restrict the run to part of the pipeline
+	jobs, err = selectPartialRun(c, pipeline, jobs, state)
+	if err != nil {
+		return err
+	}
+	if len(jobs) == 0 {
+		state.printSkipped()
+		return fmt.Errorf("no jobs to run")
+	}
+
+	// Expand variables in images, caches, artifacts and environments
+	if err := expandJobVariables(c, pipeline, jobs, cfg); err != nil {
+		return err
+	}
+
+	// Environment names are known once expanded
+	jobs, err = selectEnvironment(c, jobs)
+	if err != nil {
+		return err
+	}
+
+	if c.Bool("print-script") {
+		return printJobScripts(c, pipeline, jobs, cfg)
+	}
+
+	// Read the job secrets, masked from here on
+	removeSecrets, err := resolveJobSecrets(c, jobs, workdir, cfg)
+	defer removeSecrets()
+	if err != nil {
+		return err
+	}
+	cfg.Secrets = append(cfg.Secrets, secretValues(pipeline, jobs, cfg)...)
+	warnUnmaskedSecrets(cfg.Secrets)
+
+	// Reuse the image digests recorded by previous runs
+	if c.Bool("pin-images") {
+		cfg.PinImages = pinnedImages(c, jobs, state)
+	}
+
+	// Bring up the --compose stack for the whole run
+	err = startCompose(c, jobs, cfg, state)
+	defer state.stopCompose()
+	if err != nil {
+		return err
+	}
+
+	// Both modes run jobs in dependency order
+	graph, err := newJobGraph(pipeline, jobs)
+	if err != nil {
+		return err
+	}
+
 	if c.Bool("parallel") {
-		return runJobsParallel(c, jobs, workdir, cfg)
+		err = runJobsParallel(c, jobs, graph, workdir, cfg, state)
+	} else {
+		err = runJobsSequential(c, jobs, graph, workdir, cfg, state)
+	}
+
+	if !cfg.DryRun {
+		if saveErr := state.record.save(); saveErr != nil {
+			printVerbose(c, "Warning: failed to save run record: %v\n", saveErr)
+		}
+	}
+
+	if format := c.String("report"); format != "" {
+		path := c.String("report-file")
+		if reportErr := state.record.writeReport(format, path); reportErr != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", reportErr)
+		} else {
+			printVerbose(c, "Test report written to %s\n", path)
+		}
+	}
+
+	return err
+}
+
+// checkVolumes rejects malformed --volume mounts before the run; they
+// only apply to container jobs
+func checkVolumes(c *cli.Context, cfg *config.RunnerConfig) error {
+	for _, spec := range cfg.Volumes {
+		if _, err := runners.ParseVolume(spec); err != nil {
+			return err
+		}
+	}
+	if len(cfg.Volumes) > 0 && !c.Bool("docker") && !c.Bool("podman") {
+		fmt.Fprintf(os.Stderr, "Warning: --volume only applies to the Docker and Podman runners\n")
+	}
+	return nil
+}
+
+// checkNetwork rejects a malformed --network before the run; it only
+// applies to container jobs
+func checkNetwork(c *cli.Context, cfg *config.RunnerConfig) error {
+	if err := runners.CheckNetwork(cfg.Network); err != nil {
+		return err
+	}
+	if cfg.Network != "" && cfg.Network != "bridge" && !c.Bool("docker") && !c.Bool("podman") {
+		fmt.Fprintf(os.Stderr, "Warning: --network only applies to the Docker and Podman runners\n")
+	}
+	return nil
+}
+
+// applyLocalHints applies the env and artifact overrides of x-git-ci hints.
+// Variables from --env still win since runners merge them last.
+func applyLocalHints(c *cli.Context, jobs map[string]*types.Job) {
+	for name, job := range jobs {
+		if job.Local == nil {
+			continue
+		}
+
+		if len(job.Local.Env) > 0 {
+			env := make(map[string]string, len(job.Environment)+len(job.Local.Env))
+			for k, v := range job.Environment {
+				env[k] = v
+			}
+			for k, v := range job.Local.Env {
+				env[k] = v
+			}
+			job.Environment = env
+		}
+
+		if len(job.Local.ArtifactPaths) > 0 {
+			if job.Artifacts == nil {
+				job.Artifacts = &types.ArtifactConfig{}
+			}
+			job.Artifacts.Paths = job.Local.ArtifactPaths
+		}
+
+		printVerbose(c, "Applied local hints to job '%s'\n", name)
+	}
+}
+
+// selectPartialRun applies --from and --until. Jobs before --from are
+// assumed successful in the last run and their artifacts are borrowed
+// from it; jobs not leading to --until are dropped.
+func selectPartialRun(c *cli.Context, pipeline *types.Pipeline, jobs map[string]*types.Job, state *runState) (map[string]*types.Job, error) {
+	from := c.String("from")
+	until := c.String("until")
+
+	if until != "" {
+		if _, exists := pipeline.Jobs[until]; !exists {
+			return nil, fmt.Errorf("job '%s' given to --until not found", until)
+		}
+		keep := jobAncestors(pipeline, until)
+		keep[until] = true
+
+		selected := make(map[string]*types.Job)
+		for name, job := range jobs {
+			if keep[name] {
+				selected[name] = job
+			}
+		}
+		jobs = selected
+	}
+
+	if from == "" {
+		return jobs, nil
+	}
+
+	if _, exists := pipeline.Jobs[from]; !exists {
+		return nil, fmt.Errorf("job '%s' given to --from not found", from)
+	}
+
+	assumed := jobAncestors(pipeline, from)
+	if len(assumed) == 0 {
+		return jobs, nil
+	}
+
+	previous, err := loadLastRun(pipeline.Name)
+	if err != nil {
+		return nil, fmt.Errorf("--from %s needs a previous run: %w", from, err)
+	}
+
+	selected := make(map[string]*types.Job)
+	for name, job := range jobs {
+		if !assumed[name] {
+			selected[name] = job
+		}
+	}
+
+	for name := range assumed {
+		if status, ok := previous.Jobs[name]; ok && status.Status == types.StatusFailed {
+			fmt.Printf("Warning: job '%s' failed in previous run %s\n", name, previous.ID)
+		} else if !ok {
+			printVerbose(c, "Job '%s' is not part of previous run %s\n", name, previous.ID)
+		}
+
+		if err := state.store.borrow(name, pipeline.Jobs[name], previous.ID); err != nil {
+			return nil, err
+		}
+
+		state.assumed[name] = previous.ID
+		state.record.jobBorrowed(name, previous.ID)
 	}
 
-	// Run jobs sequentially
-	return runJobsSequential(c, jobs, workdir, cfg)
+	return selected, nil
+}
+
+// selectEnvironment keeps the jobs deploying to the environment given to
+// --environment
+func selectEnvironment(c *cli.Context, jobs map[string]*types.Job) (map[string]*types.Job, error) {
+	environment := c.String("environment")
+	if environment == "" {
+		return jobs, nil
+	}
+
+	selected := make(map[string]*types.Job)
+	for name, job := range jobs {
+		if job.EnvironmentName != "" && matchPattern(job.EnvironmentName, environment) {
+			selected[name] = job
+		} else {
+			printVerbose(c, "Skipping job '%s': does not deploy to environment '%s'\n", name, environment)
+		}
+	}
+
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("no job deploys to environment '%s'", environment)
+	}
+	return selected, nil
 }
 
 // selectJobsToRun selects which jobs to run based on flags
@@ -57,13 +367,13 @@ func selectJobsToRun(c *cli.Context, pipeline *types.Pipeline) map[string]*types
 	// Filter by specific job name
 	if jobName := c.String("job"); jobName != "" {
 		if job, exists := jobs[jobName]; exists {
-			fmt.Println(job)
+			printVerbose(c, "Selected job '%s'\n", jobName)
 			return map[string]*types.Job{jobName: job}
 		}
 		// Try pattern matching
 		matchedJobs := make(map[string]*types.Job)
 		for name, j := range jobs {
-			if matchPattern(name, jobName) {
+			if matchPattern(name, jobName) || j.MatrixOf == jobName {
 				matchedJobs[name] = j
 			}
 		}
@@ -89,11 +399,50 @@ func selectJobsToRun(c *cli.Context, pipeline *types.Pipeline) map[string]*types
 	except := c.StringSlice("except")
 	jobs = filterJobs(jobs, only, except)
 
-	return jobs
+	// Manual and environment stop jobs only run when asked for with --job
+	return excludeNonDefaultJobs(c, jobs)
+}
+
+// excludeNonDefaultJobs drops jobs that are not part of a regular pipeline
+// execution: manual jobs, environment stop jobs and jobs skipped locally
+func excludeNonDefaultJobs(c *cli.Context, jobs map[string]*types.Job) map[string]*types.Job {
+	selected := make(map[string]*types.Job)
+	for name, job := range jobs {
+		switch {
+		case job.IsStopJob():
+			printVerbose(c, "Skipping job '%s': stops environment '%s' (use --job to run it)\n", name, job.EnvironmentName)
+		case job.When == "manual":
+			printVerbose(c, "Skipping job '%s': manual job (use --job to run it)\n", name)
+		case job.When == "delayed" && !c.Bool("include-delayed"):
+			printVerbose(c, "Skipping job '%s': delayed job (use --include-delayed to run it)\n", name)
+		case job.Local != nil && job.Local.Skip:
+			printVerbose(c, "Skipping job '%s': skipped locally by x-git-ci (use --job to run it)\n", name)
+		default:
+			selected[name] = job
+		}
+	}
+	return selected
+}
+
+// waitDelayed waits before a delayed job run with --include-delayed, its
+// start_in scaled down by --delay-scale to keep the pipeline timing
+func waitDelayed(c *cli.Context, job *types.Job) {
+	if job.When != "delayed" || !c.Bool("include-delayed") {
+		return
+	}
+	delay, err := parsers.ParseDuration(job.StartIn)
+	if err != nil {
+		return
+	}
+	wait := time.Duration(float64(delay) * c.Float64("delay-scale"))
+	fmt.Printf("Delaying job '%s' by %s (start_in: %s)\n", job.Name, wait.Round(time.Millisecond), job.StartIn)
+	time.Sleep(wait)
 }
 
-// runJobsSequential runs jobs one by one
-func runJobsSequential(c *cli.Context, jobs map[string]*types.Job, workdir string, cfg *config.RunnerConfig) error {
+// runJobsSequential runs jobs one by one. The downstream jobs of a failed
+// job are skipped; the first failure stops the pipeline unless
+// --continue-on-error, which runs the other jobs and fails at the end.
+func runJobsSequential(c *cli.Context, jobs map[string]*types.Job, graph *jobGraph, workdir string, cfg *config.RunnerConfig, state *runState) error {
 	continueOnError := c.Bool("continue-on-error")
 
 	fmt.Printf("Running %d job(s) sequentially\n", len(jobs))
@@ -102,25 +451,101 @@ func runJobsSequential(c *cli.Context, jobs map[string]*types.Job, workdir strin
 	startTime := time.Now()
 	successCount := 0
 	failureCount := 0
+	blockingCount := 0 // Failures of jobs not allowed to fail
+	skippedCount := 0
+	store := state.artifacts()
+	caches := state.jobCaches()
+	record := state.recorder()
+
+	// Failed jobs not allowed to fail; their downstream jobs are skipped
+	failed := make(map[string]bool)
+
+	// Without --continue-on-error, the first failure skips the jobs left
+	var stopErr error
+	stoppedBy := ""
+
+	// Fail-fast matrices stop at the first failure of one of their jobs
+	failFast := matrixFailures{}
+	results := jobResults{}
+
+	for _, jobName := range graph.order {
+		job := jobs[jobName]
 
-	for jobName, job := range jobs {
 		// Set job name if not set
 		if job.Name == "" {
 			job.Name = jobName
 		}
 
+		stopped := failFast.skipReason(job)
+		reason := stopped
+		if dep := graph.blockedBy(jobName, failed); dep != "" {
+			reason = skipReason(jobs, jobName, dep)
+		} else if stopErr != nil && reason == "" {
+			reason = fmt.Sprintf("pipeline stopped after job '%s' failed", stoppedBy)
+		}
+
+		// The `if:` of a job may run it after a failure, or skip it
+		if job.If != "" && stopped == "" {
+			run, err := results.allows(jobName, jobs, reason != "", cfg)
+			if err != nil {
+				return fmt.Errorf("job '%s': %w", jobName, err)
+			}
+			if run {
+				reason = ""
+			} else if reason == "" {
+				reason = "condition not met"
+			}
+		}
+
+		if reason != "" {
+			fmt.Printf("Skipping job '%s': %s\n", jobName, reason)
+			record.jobSkipped(jobName, reason)
+			failed[jobName] = true
+			results[jobName] = "skipped"
+			skippedCount++
+			continue
+		}
+
 		printVerbose(c, "\nStarting job: %s\n", jobName)
+		waitDelayed(c, job)
+		results.interpolate(c, jobName, jobs, cfg)
 
 		// Create runner
-		runner, err := createRunner(c, cfg)
+		runner, err := createRunner(c, cfg, job, state)
 		if err != nil {
 			return fmt.Errorf("failed to create runner for job %s: %w", jobName, err)
 		}
+		setRunContexts(runner, results.runContexts(jobName, jobs, cfg))
+
+		// The job runs in a workspace of its own, which receives the
+		// upstream artifacts and the caches of earlier runs
+		jobDir, err := state.workspace(jobName, job, workdir, cfg)
+		if err != nil {
+			return err
+		}
+		if err := store.restore(c, jobName, job, jobDir); err != nil {
+			return err
+		}
+		if err := caches.restore(c, jobName, job, jobDir, cfg); err != nil {
+			return err
+		}
 
 		// Run job
 		jobStart := time.Now()
-		err = runner.RunJob(job, workdir)
+		err = runner.RunJob(job, jobDir)
 		jobDuration := time.Since(jobStart)
+		record.jobFinished(jobName, jobStart, err)
+		results.finished(jobName, job, err)
+		state.recordImage(jobName, runner)
+		state.recordCoverage(jobName, runner)
+		state.recordSteps(jobName, runner)
+
+		if storeErr := store.collect(c, jobName, job, jobDir, err == nil); storeErr != nil {
+			fmt.Printf("Warning: %v\n", storeErr)
+		}
+		if cacheErr := caches.save(c, jobName, job, jobDir, cfg, err == nil); cacheErr != nil {
+			fmt.Printf("Warning: %v\n", cacheErr)
+		}
 
 		// Cleanup
 		if cleanupErr := runner.Cleanup(); cleanupErr != nil {
@@ -129,14 +554,20 @@ func runJobsSequential(c *cli.Context, jobs map[string]*types.Job, workdir strin
 
 		if err != nil {
 			failureCount++
-			fmt.Printf("Job '%s' failed after %s: %v\n", jobName, formatDuration(jobDuration), err)
-
-			if !continueOnError && !job.AllowFailure {
-				return fmt.Errorf("job '%s' failed: %w", jobName, err)
+			fmt.Printf("Job '%s' failed after %s%s%s: %v\n", jobName, formatDuration(jobDuration), state.coverageSuffix(jobName), allowedFailureSuffix(job, err), err)
+
+			if !allowedToFail(job, err) {
+				failed[jobName] = true
+				failFast.record(jobName, job)
+				blockingCount++
+				if !continueOnError && stopErr == nil {
+					stopErr = fmt.Errorf("job '%s' failed: %w", jobName, err)
+					stoppedBy = jobName
+				}
 			}
 		} else {
 			successCount++
-			fmt.Printf("Job '%s' succeeded in %s\n", jobName, formatDuration(jobDuration))
+			fmt.Printf("Job '%s' succeeded in %s%s\n", jobName, formatDuration(jobDuration), state.coverageSuffix(jobName))
 		}
 	}
 
@@ -144,17 +575,31 @@ func runJobsSequential(c *cli.Context, jobs map[string]*types.Job, workdir strin
 
 	fmt.Println(strings.Repeat("-", 80))
 	fmt.Printf("Pipeline completed in %s\n", formatDuration(totalDuration))
-	fmt.Printf("Success: %d, Failed: %d, Total: %d\n", successCount, failureCount, len(jobs))
+	if skippedCount > 0 {
+		fmt.Printf("Success: %d, Failed: %d, Skipped: %d, Total: %d\n", successCount, failureCount, skippedCount, len(jobs))
+	} else {
+		fmt.Printf("Success: %d, Failed: %d, Total: %d\n", successCount, failureCount, len(jobs))
+	}
+	state.printAssumed()
+	state.printSkipped()
+	state.printCoverage()
 
-	if failureCount > 0 && !continueOnError {
-		return fmt.Errorf("%d job(s) failed", failureCount)
+	if stopErr != nil {
+		return stopErr
 	}
 
-	return nil
+	if blockingCount > 0 {
+		return fmt.Errorf("%d job(s) failed", blockingCount)
+	}
+
+	return state.checkCoverage(c.Float64("coverage-threshold"))
 }
 
-// runJobsParallel runs jobs in parallel
-func runJobsParallel(c *cli.Context, jobs map[string]*types.Job, workdir string, cfg *config.RunnerConfig) error {
+// runJobsParallel runs jobs concurrently, at most --max-parallel at a
+// time. A job starts once all its upstream jobs are done; the downstream
+// jobs of a failed job are skipped, and no job starts after the first
+// failure unless --continue-on-error, as when running sequentially.
+func runJobsParallel(c *cli.Context, jobs map[string]*types.Job, graph *jobGraph, workdir string, cfg *config.RunnerConfig, state *runState) error {
 	maxParallel := c.Int("max-parallel")
 	if maxParallel <= 0 {
 		maxParallel = runtime.NumCPU()
@@ -162,118 +607,314 @@ func runJobsParallel(c *cli.Context, jobs map[string]*types.Job, workdir string,
 
 	continueOnError := c.Bool("continue-on-error")
 
+	// Jobs running side by side can't publish the same host port
+	if err := checkHostPortConflicts(jobs, c.Bool("docker") || c.Bool("podman")); err != nil {
+		return err
+	}
+
 	fmt.Printf("Running %d job(s) in parallel (max %d)\n", len(jobs), maxParallel)
 	fmt.Println(strings.Repeat("-", 80))
 
 	startTime := time.Now()
+	record := state.recorder()
 
-	// Create semaphore for limiting parallelism
-	sem := make(chan struct{}, maxParallel)
+	queue := newJobQueue(jobs, graph, maxParallel)
 
-	// Create wait group
-	var wg sync.WaitGroup
-
-	// Results channel
-	type jobResult struct {
-		name     string
-		err      error
-		duration time.Duration
+	// Show what the queue waits for now and then, and on every change
+	// when attached to a terminal
+	var status <-chan time.Time
+	if interval := cfg.HeartbeatInterval(); interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		status = ticker.C
 	}
-	results := make(chan jobResult, len(jobs))
-
-	// Run jobs
-	for jobName, job := range jobs {
-		wg.Add(1)
-
-		go func(name string, j *types.Job) {
-			defer wg.Done()
+	onTerminal := cfg.HeartbeatInterval() > 0 && runners.IsTerminal(os.Stdout)
 
-			// Acquire semaphore
-			sem <- struct{}{}
-			defer func() { <-sem }()
+	results := make(chan jobResult, len(jobs))
+	failed := make(map[string]bool)
+	failFast := matrixFailures{}
+	jobsDone := jobResults{}
 
-			// Set job name if not set
-			if j.Name == "" {
-				j.Name = name
+	successCount := 0
+	failureCount := 0
+	blockingCount := 0 // Failures of jobs not allowed to fail
+	skippedCount := 0
+	var firstError error
+	stoppedBy := ""
+
+	changed := false
+	for !queue.idle() {
+		// Start ready jobs in pipeline order while slots are free
+		for {
+			name, ok := queue.next()
+			if !ok {
+				break
 			}
 
-			printVerbose(c, "Starting parallel job: %s\n", name)
-
-			// Create runner
-			runner, err := createRunner(c, cfg)
-			if err != nil {
-				results <- jobResult{
-					name:     name,
-					err:      fmt.Errorf("failed to create runner: %w", err),
-					duration: 0,
-				}
-				return
+			stopped := failFast.skipReason(jobs[name])
+			reason := stopped
+			dep := graph.blockedBy(name, failed)
+			if dep != "" {
+				reason = skipReason(jobs, name, dep)
+			} else if firstError != nil && reason == "" {
+				reason = fmt.Sprintf("pipeline stopped after job '%s' failed", stoppedBy)
 			}
 
-			// Run job
-			jobStart := time.Now()
-			err = runner.RunJob(j, workdir)
-			jobDuration := time.Since(jobStart)
-
-			// Cleanup
-			if cleanupErr := runner.Cleanup(); cleanupErr != nil {
-				printVerbose(c, "Warning: cleanup failed for job %s: %v\n", name, cleanupErr)
+			// The `if:` of a job may run it after a failure, or skip it.
+			// An invalid condition fails the job.
+			if jobs[name].If != "" && stopped == "" {
+				run, err := jobsDone.allows(name, jobs, dep != "", cfg)
+				if err != nil {
+					queue.start(name)
+					changed = true
+					results <- jobResult{name: name, err: fmt.Errorf("invalid if: %w", err)}
+					continue
+				}
+				if run {
+					reason = ""
+				} else if reason == "" {
+					reason = "condition not met"
+				}
 			}
 
-			results <- jobResult{
-				name:     name,
-				err:      err,
-				duration: jobDuration,
+			if reason != "" {
+				fmt.Printf("Skipping job '%s': %s\n", name, reason)
+				record.jobSkipped(name, reason)
+				failed[name] = true
+				jobsDone[name] = "skipped"
+				skippedCount++
+				queue.release(name)
+				continue
 			}
-		}(jobName, job)
-	}
 
-	// Wait for all jobs to complete
-	go func() {
-		wg.Wait()
-		close(results)
-	}()
+			jobsDone.interpolate(c, name, jobs, cfg)
+			contexts := jobsDone.runContexts(name, jobs, cfg)
+			queue.start(name)
+			changed = true
+			go func(name string, j *types.Job) {
+				results <- parallelJob(c, name, j, workdir, cfg, state, contexts)
+			}(name, jobs[name])
+		}
 
-	// Collect results
-	successCount := 0
-	failureCount := 0
-	var firstError error
+		if len(queue.running) == 0 {
+			continue
+		}
+		if changed && onTerminal {
+			queue.printStatus()
+		}
+		changed = false
+
+		var result jobResult
+		select {
+		case result = <-results:
+		case <-status:
+			queue.printStatus()
+			continue
+		}
+		jobsDone.finished(result.name, jobs[result.name], result.err)
 
-	for result := range results {
 		if result.err != nil {
 			failureCount++
-			fmt.Printf("Job '%s' failed after %s: %v\n", result.name, formatDuration(result.duration), result.err)
-
-			if firstError == nil && !continueOnError {
-				firstError = result.err
+			fmt.Printf("Job '%s' failed after %s%s%s: %v\n", result.name, formatDuration(result.duration), state.coverageSuffix(result.name), allowedFailureSuffix(jobs[result.name], result.err), result.err)
+
+			if !allowedToFail(jobs[result.name], result.err) {
+				failed[result.name] = true
+				failFast.record(result.name, jobs[result.name])
+				blockingCount++
+				if firstError == nil && !continueOnError {
+					firstError = result.err
+					stoppedBy = result.name
+				}
 			}
 		} else {
 			successCount++
-			fmt.Printf("Job '%s' succeeded in %s\n", result.name, formatDuration(result.duration))
+			fmt.Printf("Job '%s' succeeded in %s%s\n", result.name, formatDuration(result.duration), state.coverageSuffix(result.name))
 		}
+		queue.release(result.name)
+		changed = true
 	}
 
 	totalDuration := time.Since(startTime)
 
 	fmt.Println(strings.Repeat("-", 80))
 	fmt.Printf("Pipeline completed in %s\n", formatDuration(totalDuration))
-	fmt.Printf("Success: %d, Failed: %d, Total: %d\n", successCount, failureCount, len(jobs))
+	if skippedCount > 0 {
+		fmt.Printf("Success: %d, Failed: %d, Skipped: %d, Total: %d\n", successCount, failureCount, skippedCount, len(jobs))
+	} else {
+		fmt.Printf("Success: %d, Failed: %d, Total: %d\n", successCount, failureCount, len(jobs))
+	}
+	queue.printResourceWaits()
+	state.printAssumed()
+	state.printSkipped()
+	state.printCoverage()
 
 	if firstError != nil && !continueOnError {
 		return fmt.Errorf("pipeline failed: %w", firstError)
 	}
 
-	if failureCount > 0 {
-		return fmt.Errorf("%d job(s) failed", failureCount)
+	if blockingCount > 0 {
+		return fmt.Errorf("%d job(s) failed", blockingCount)
+	}
+
+	return state.checkCoverage(c.Float64("coverage-threshold"))
+}
+
+// allowedToFail reports whether the failure of a job leaves the pipeline
+// going: the job allows failures, or the exit code is one of those its
+// allow_failure lists
+func allowedToFail(job *types.Job, err error) bool {
+	var allowed *runners.AllowedFailureError
+	return job.AllowFailure || job.ContinueOnErr || errors.As(err, &allowed)
+}
+
+// allowedFailureSuffix marks the result line of a job allowed to fail
+func allowedFailureSuffix(job *types.Job, err error) string {
+	if allowedToFail(job, err) {
+		return " (allowed to fail)"
+	}
+	return ""
+}
+
+// jobResult is the outcome of a job run by runJobsParallel
+type jobResult struct {
+	name     string
+	err      error
+	duration time.Duration
+}
+
+// parallelJob runs the jobs runJobsParallel starts; tests replace it to
+// drive the scheduler without runners
+var parallelJob = runParallelJob
+
+// runParallelJob runs one job of runJobsParallel with its own runner
+func runParallelJob(c *cli.Context, name string, j *types.Job, workdir string, cfg *config.RunnerConfig, state *runState, contexts expressions.Context) jobResult {
+	store := state.artifacts()
+	caches := state.jobCaches()
+	record := state.recorder()
+
+	// Set job name if not set
+	if j.Name == "" {
+		j.Name = name
+	}
+
+	printVerbose(c, "Starting parallel job: %s\n", name)
+	waitDelayed(c, j)
+
+	// Create runner
+	runner, err := createRunner(c, cfg, j, state)
+	if err != nil {
+		return jobResult{
+			name:     name,
+			err:      fmt.Errorf("failed to create runner: %w", err),
+			duration: 0,
+		}
+	}
+	setRunContexts(runner, contexts)
+
+	// The job runs in a workspace of its own, which receives the
+	// upstream artifacts and the caches of earlier runs
+	jobDir, err := state.workspace(name, j, workdir, cfg)
+	if err != nil {
+		return jobResult{name: name, err: err}
+	}
+	if err := store.restore(c, name, j, jobDir); err != nil {
+		return jobResult{name: name, err: err}
+	}
+	if err := caches.restore(c, name, j, jobDir, cfg); err != nil {
+		return jobResult{name: name, err: err}
+	}
+
+	// Run job
+	jobStart := time.Now()
+	err = runner.RunJob(j, jobDir)
+	jobDuration := time.Since(jobStart)
+	record.jobFinished(name, jobStart, err)
+	state.recordImage(name, runner)
+	state.recordCoverage(name, runner)
+	state.recordSteps(name, runner)
+
+	if storeErr := store.collect(c, name, j, jobDir, err == nil); storeErr != nil {
+		fmt.Printf("Warning: %v\n", storeErr)
+	}
+	if cacheErr := caches.save(c, name, j, jobDir, cfg, err == nil); cacheErr != nil {
+		fmt.Printf("Warning: %v\n", cacheErr)
+	}
+
+	// Cleanup
+	if cleanupErr := runner.Cleanup(); cleanupErr != nil {
+		printVerbose(c, "Warning: cleanup failed for job %s: %v\n", name, cleanupErr)
+	}
+
+	return jobResult{
+		name:     name,
+		err:      err,
+		duration: jobDuration,
+	}
+}
+
+// checkHostPortConflicts detects fixed host ports published by more than one
+// job. Services are published on the host for native jobs, the job
+// container ports for Docker and Podman jobs.
+func checkHostPortConflicts(jobs map[string]*types.Job, containers bool) error {
+	type publisher struct {
+		job  string
+		spec string
+	}
+	used := make(map[string]publisher)
+
+	names := make([]string, 0, len(jobs))
+	for name := range jobs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		job := jobs[name]
+
+		var specs []string
+		if containers {
+			if job.Container != nil {
+				specs = job.Container.Ports
+			}
+		} else {
+			for _, svc := range job.Services {
+				specs = append(specs, svc.Ports...)
+			}
+		}
+
+		for _, spec := range specs {
+			mapping, err := types.ParsePortMapping(spec)
+			if err != nil {
+				return fmt.Errorf("job '%s': %w", name, err)
+			}
+			if mapping.HostPort == "" {
+				continue
+			}
+
+			key := mapping.HostIP + ":" + mapping.HostPort + "/" + mapping.Protocol
+			if other, exists := used[key]; exists && other.job != name {
+				return fmt.Errorf("jobs '%s' and '%s' both publish host port %s (%s, %s); use a random host port (e.g. '%s') to run them in parallel",
+					other.job, name, mapping.HostPort, other.spec, spec, mapping.ContainerPort)
+			}
+			used[key] = publisher{job: name, spec: spec}
+		}
 	}
 
 	return nil
 }
 
-// createRunner creates the appropriate runner based on flags
-func createRunner(c *cli.Context, cfg *config.RunnerConfig) (types.Runner, error) {
+// createRunner creates the appropriate runner based on flags, falling back
+// to the job's local runner hint when neither --docker nor --podman is given.
+// Trigger jobs including a child pipeline run it as part of the run.
+func createRunner(c *cli.Context, cfg *config.RunnerConfig, job *types.Job, state *runState) (types.Runner, error) {
+	if job != nil && job.Trigger.IsChildPipeline() {
+		return newChildPipelineRunner(c, cfg, state)
+	}
+
+	useDocker, usePodman := containerEngine(c, job)
+
 	// Check for Docker runner
-	if c.Bool("docker") {
+	if useDocker {
 		runner, err := runners.NewDockerRunner(cfg)
 		if err != nil {
 			return nil, fmt.Errorf("failed to create Docker runner: %w", err)
@@ -282,22 +923,39 @@ func createRunner(c *cli.Context, cfg *config.RunnerConfig) (types.Runner, error
 	}
 
 	// Check for Podman runner
-	if c.Bool("podman") {
-		// If Podman runner is implemented
-		// runner, err := runners.NewPodmanRunner(cfg)
-		// if err != nil {
-		//     return nil, fmt.Errorf("failed to create Podman runner: %w", err)
-		// }
-		// return runner, nil
+	if usePodman {
+		runner, err := runners.NewPodmanRunner(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create Podman runner: %w", err)
+		}
+		return runner, nil
+	}
 
-		// For now, fallback to Docker with podman command
-		return nil, fmt.Errorf("podman runner not yet implemented")
+	// Check for SSH runner
+	if cfg.SSHHost != "" {
+		runner, err := runners.NewSSHRunner(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create SSH runner: %w", err)
+		}
+		return runner, nil
 	}
 
 	// Default to Bash runner
 	return runners.NewBashRunner(cfg), nil
 }
 
+// containerEngine returns whether a job runs with Docker or Podman: the
+// --docker and --podman flags, else the runner hint of the job
+func containerEngine(c *cli.Context, job *types.Job) (useDocker, usePodman bool) {
+	useDocker = c.Bool("docker")
+	usePodman = c.Bool("podman")
+	if !c.IsSet("docker") && !c.IsSet("podman") && job != nil && job.Local != nil && job.Local.Runner != "" {
+		useDocker = job.Local.Runner == "docker"
+		usePodman = job.Local.Runner == "podman"
+	}
+	return useDocker, usePodman
+}
+
 // formatDuration formats a duration in a human-readable way
 func formatDuration(d time.Duration) string {
 	if d < time.Second {
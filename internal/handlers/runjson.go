@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/sanix-darker/git-ci/pkg/types"
+	cli "github.com/urfave/cli/v2"
+)
+
+// runJSON runs the pipeline for --output json: the human output goes to
+// stderr and the run record, jobs and steps with their timing and exit
+// codes, is printed to stdout as one JSON document, whether the run
+// succeeds or not
+func runJSON(c *cli.Context) error {
+	stdout := os.Stdout
+	os.Stdout = os.Stderr
+	defer func() { os.Stdout = stdout }()
+
+	var state *runState
+	err := runPipeline(c, func(s *runState) { state = s })
+
+	var run *types.PipelineRun
+	if state != nil {
+		run = state.record.result(err)
+	} else {
+		// The run stopped before it started, e.g. on a parse error
+		now := time.Now()
+		run = &types.PipelineRun{
+			Status:    types.StatusFailed,
+			Trigger:   "local",
+			StartTime: now,
+			EndTime:   &now,
+			Jobs:      make(map[string]*types.JobStatus),
+			Metadata:  map[string]string{"error": err.Error()},
+		}
+	}
+
+	data, encodeErr := json.MarshalIndent(run, "", "  ")
+	if encodeErr != nil {
+		return fmt.Errorf("failed to encode run record: %w", encodeErr)
+	}
+	fmt.Fprintln(stdout, string(data))
+	return err
+}
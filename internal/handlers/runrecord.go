@@ -0,0 +1,477 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/sanix-darker/git-ci/internal/config"
+	"github.com/sanix-darker/git-ci/internal/runners"
+	"github.com/sanix-darker/git-ci/pkg/types"
+)
+
+// runState carries the per-run bookkeeping shared by the job loops
+type runState struct {
+	id       string
+	pipeline *types.Pipeline
+	store    *artifactStore
+	caches   *jobCache
+	record   *runRecorder
+
+	// Jobs skipped by --from, mapped to the run they were borrowed from
+	assumed map[string]string
+
+	// Jobs left out by their rules, mapped to the reason
+	skipped map[string]string
+
+	// Last recorded image of each job, for drift detection and pinning
+	images map[string]*types.JobStatus
+
+	// Coverage reported by each job, guarded by mu
+	mu       sync.Mutex
+	coverage map[string]float64
+
+	// Compose stack of --compose, removed at the end unless kept
+	compose     *runners.ComposeStack
+	keepCompose bool
+
+	// Nesting level of a child pipeline run, 0 for the pipeline run
+	depth int
+}
+
+// newRunState creates the bookkeeping for a new pipeline run. A detached
+// run keeps the ID its parent announced.
+func newRunState(pipeline *types.Pipeline, cfg *config.RunnerConfig) *runState {
+	id := os.Getenv(runIDEnv)
+	if id == "" {
+		id = time.Now().Format("20060102-150405.000")
+	}
+	return &runState{
+		id:       id,
+		pipeline: pipeline,
+		store:    newArtifactStore(id, pipeline, cfg),
+		caches:   newJobCache(cfg),
+		record:   newRunRecorder(id, pipeline),
+		assumed:  make(map[string]string),
+		skipped:  make(map[string]string),
+		images:   lastImages(pipeline.Name),
+		coverage: make(map[string]float64),
+	}
+}
+
+// newChildRunState creates the bookkeeping of the child pipeline started
+// by a trigger job. Its artifacts are stored under the parent run; its
+// jobs are reported by the trigger job, not recorded.
+func newChildRunState(parent *runState, trigger string, pipeline *types.Pipeline, cfg *config.RunnerConfig) *runState {
+	id := time.Now().Format("20060102-150405.000")
+	depth := 1
+	if parent != nil {
+		id = parent.id
+		depth = parent.depth + 1
+	}
+	id = filepath.Join(id, "child", trigger)
+
+	return &runState{
+		id:       id,
+		pipeline: pipeline,
+		store:    newArtifactStore(id, pipeline, cfg),
+		caches:   newJobCache(cfg),
+		assumed:  make(map[string]string),
+		skipped:  make(map[string]string),
+		coverage: make(map[string]float64),
+		depth:    depth,
+	}
+}
+
+// artifacts returns the artifact store of the run (nil-safe)
+func (s *runState) artifacts() *artifactStore {
+	if s == nil {
+		return nil
+	}
+	return s.store
+}
+
+// jobCaches returns the cache store of the run (nil-safe)
+func (s *runState) jobCaches() *jobCache {
+	if s == nil {
+		return nil
+	}
+	return s.caches
+}
+
+// recorder returns the run recorder (nil-safe)
+func (s *runState) recorder() *runRecorder {
+	if s == nil {
+		return nil
+	}
+	return s.record
+}
+
+// printAssumed lists the jobs that were not run because of --from
+func (s *runState) printAssumed() {
+	if s == nil || len(s.assumed) == 0 {
+		return
+	}
+	names := make([]string, 0, len(s.assumed))
+	for name := range s.assumed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("Job '%s' assumed successful (from previous run %s)\n", name, s.assumed[name])
+	}
+}
+
+// printSkipped lists the jobs that were not run because of their rules
+func (s *runState) printSkipped() {
+	if s == nil || len(s.skipped) == 0 {
+		return
+	}
+	names := make([]string, 0, len(s.skipped))
+	for name := range s.skipped {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("Job '%s' skipped (%s)\n", name, s.skipped[name])
+	}
+}
+
+// runRecorder tracks job results and persists them as a PipelineRun
+type runRecorder struct {
+	mu  sync.Mutex
+	run *types.PipelineRun
+
+	// live writes the record after each job, for `attach`
+	live bool
+}
+
+// runsDir returns the directory holding run records
+func runsDir() string {
+	return filepath.Join(config.GetCacheDir(), "runs")
+}
+
+// newRunRecorder creates a recorder for the given run
+func newRunRecorder(id string, pipeline *types.Pipeline) *runRecorder {
+	return &runRecorder{
+		run: &types.PipelineRun{
+			ID:         id,
+			PipelineID: pipeline.Name,
+			Status:     types.StatusRunning,
+			Trigger:    "local",
+			StartTime:  time.Now(),
+			Jobs:       make(map[string]*types.JobStatus),
+			Metadata:   make(map[string]string),
+		},
+	}
+}
+
+// jobFinished records the outcome of a job
+func (r *runRecorder) jobFinished(name string, start time.Time, err error) {
+	if r == nil {
+		return
+	}
+
+	end := time.Now()
+	duration := end.Sub(start)
+	status := &types.JobStatus{
+		Name:      name,
+		Status:    types.StatusSuccess,
+		StartTime: &start,
+		EndTime:   &end,
+		Duration:  &duration,
+		Attempts:  1,
+	}
+	if err != nil {
+		status.Status = types.StatusFailed
+		status.Message = err.Error()
+		status.ExitCode = runners.ExitCode(err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.run.Jobs[name] = status
+	if r.live {
+		_ = r.write()
+	}
+}
+
+// jobImage records the image digest a job ran with
+func (r *runRecorder) jobImage(name, image, digest string) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	status, ok := r.run.Jobs[name]
+	if !ok {
+		status = &types.JobStatus{Name: name}
+		r.run.Jobs[name] = status
+	}
+	status.Image = image
+	status.ImageDigest = digest
+	if r.live {
+		_ = r.write()
+	}
+}
+
+// jobCoverage records the coverage a job reported
+func (r *runRecorder) jobCoverage(name string, coverage float64) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	status, ok := r.run.Jobs[name]
+	if !ok {
+		status = &types.JobStatus{Name: name}
+		r.run.Jobs[name] = status
+	}
+	status.Coverage = &coverage
+	if r.live {
+		_ = r.write()
+	}
+}
+
+// jobSteps records the step results of a job
+func (r *runRecorder) jobSteps(name string, steps []types.StepStatus) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	status, ok := r.run.Jobs[name]
+	if !ok {
+		status = &types.JobStatus{Name: name}
+		r.run.Jobs[name] = status
+	}
+	status.Steps = steps
+	if r.live {
+		_ = r.write()
+	}
+}
+
+// jobBorrowed records a job whose result comes from a previous run
+func (r *runRecorder) jobBorrowed(name, fromRun string) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.run.Jobs[name] = &types.JobStatus{
+		Name:    name,
+		Status:  types.StatusSkipped,
+		Message: fmt.Sprintf("assumed successful (from previous run %s)", fromRun),
+	}
+	r.run.Metadata["borrowed."+name] = fromRun
+}
+
+// jobSkipped records a job left out of the run
+func (r *runRecorder) jobSkipped(name, reason string) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.run.Jobs[name] = &types.JobStatus{
+		Name:    name,
+		Status:  types.StatusSkipped,
+		Message: reason,
+	}
+}
+
+// save writes the final run record to disk
+func (r *runRecorder) save() error {
+	if r == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.finishJobs()
+
+	defer r.unlock()
+	return r.write()
+}
+
+// cancel writes the run record of an interrupted run
+func (r *runRecorder) cancel() error {
+	if r == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.finish(types.StatusCancelled)
+	defer r.unlock()
+	return r.write()
+}
+
+// result returns the record of a run for --output json, finished when
+// the run stopped before saving it; err is the error the run ended with
+func (r *runRecorder) result(err error) *types.PipelineRun {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.run.EndTime == nil {
+		r.finishJobs()
+	}
+	if err != nil {
+		r.run.Status = types.StatusFailed
+		r.run.Metadata["error"] = err.Error()
+	}
+	return r.run
+}
+
+// start writes the record of the ongoing run and keeps it updated after
+// each job, so `attach` and `cancel` can find it
+func (r *runRecorder) start() error {
+	if r == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.live = true
+	if err := r.write(); err != nil {
+		return err
+	}
+
+	// The lock outlives the process of a crashed run, see findStaleRuns
+	return os.WriteFile(runLockPath(r.run.ID), []byte(strconv.Itoa(os.Getpid())), 0644)
+}
+
+// unlock removes the lock of a run that started. Callers hold r.mu.
+func (r *runRecorder) unlock() {
+	if r.live {
+		_ = os.Remove(runLockPath(r.run.ID))
+	}
+}
+
+// runLockPath returns the lock file marking a run in progress
+func runLockPath(id string) string {
+	return filepath.Join(runsDir(), id+".lock")
+}
+
+// finish marks the end of the run. Callers hold r.mu.
+func (r *runRecorder) finish(status types.PipelineStatus) {
+	end := time.Now()
+	duration := end.Sub(r.run.StartTime)
+	r.run.EndTime = &end
+	r.run.Duration = &duration
+	r.run.Status = status
+}
+
+// finishJobs marks the end of the run, failed when one of its jobs
+// failed. Callers hold r.mu.
+func (r *runRecorder) finishJobs() {
+	r.finish(types.StatusSuccess)
+	for _, job := range r.run.Jobs {
+		if job.Status == types.StatusFailed {
+			r.run.Status = types.StatusFailed
+			break
+		}
+	}
+}
+
+// write stores the record in the runs directory. Callers hold r.mu.
+func (r *runRecorder) write() error {
+	if err := os.MkdirAll(runsDir(), 0755); err != nil {
+		return fmt.Errorf("failed to create runs directory: %w", err)
+	}
+
+	data, err := json.MarshalIndent(r.run, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode run record: %w", err)
+	}
+
+	// Write then rename, so readers never see a partial record
+	path := filepath.Join(runsDir(), r.run.ID+".json")
+	if err := os.WriteFile(path+".tmp", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// loadRun returns the record of a run
+func loadRun(id string) (*types.PipelineRun, error) {
+	data, err := os.ReadFile(filepath.Join(runsDir(), id+".json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("run %s not found", id)
+		}
+		return nil, fmt.Errorf("failed to read run %s: %w", id, err)
+	}
+
+	var run types.PipelineRun
+	if err := json.Unmarshal(data, &run); err != nil {
+		return nil, fmt.Errorf("failed to decode run %s: %w", id, err)
+	}
+	return &run, nil
+}
+
+// loadLastRun returns the most recent finished run of a pipeline
+func loadLastRun(pipelineID string) (*types.PipelineRun, error) {
+	runs, err := listRuns(pipelineID)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, run := range runs {
+		if run.Status != types.StatusRunning {
+			return run, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no previous run found for pipeline '%s'", pipelineID)
+}
+
+// listRuns returns the run records of a pipeline (all pipelines when
+// pipelineID is empty), newest first
+func listRuns(pipelineID string) ([]*types.PipelineRun, error) {
+	entries, err := os.ReadDir(runsDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("no previous run found")
+		}
+		return nil, fmt.Errorf("failed to read runs directory: %w", err)
+	}
+
+	// Run IDs are timestamps, so the newest sorts last
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() && filepath.Ext(entry.Name()) == ".json" {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(names)))
+
+	var runs []*types.PipelineRun
+	for _, name := range names {
+		data, err := os.ReadFile(filepath.Join(runsDir(), name))
+		if err != nil {
+			continue
+		}
+		var run types.PipelineRun
+		if err := json.Unmarshal(data, &run); err != nil {
+			continue
+		}
+		if pipelineID == "" || run.PipelineID == pipelineID {
+			runs = append(runs, &run)
+		}
+	}
+
+	return runs, nil
+}
@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sanix-darker/git-ci/pkg/types"
+	cli "github.com/urfave/cli/v2"
+)
+
+// CmdSchema prints the JSON schema of an exported document
+func CmdSchema(c *cli.Context) error {
+	name := c.Args().First()
+	if name == "" {
+		return fmt.Errorf("schema name required (%s)", strings.Join(types.SchemaNames(), " or "))
+	}
+
+	var (
+		schema []byte
+		err    error
+	)
+	if c.Bool("generate") {
+		// Schema from the current Go types, to refresh the committed one
+		schema, err = types.GenerateSchema(name)
+	} else {
+		schema, err = types.Schema(name)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Print(string(schema))
+	return nil
+}
@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/sanix-darker/git-ci/internal/config"
+	"github.com/sanix-darker/git-ci/internal/runners"
+	"github.com/sanix-darker/git-ci/pkg/types"
+	cli "github.com/urfave/cli/v2"
+)
+
+// unsafeFileChars matches what a job name can't keep in a file name
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// printJobScripts renders each job as a shell script for --print-script:
+// on stdout in pipeline order, or as <job>.sh files in --script-dir
+func printJobScripts(c *cli.Context, pipeline *types.Pipeline, jobs map[string]*types.Job, cfg *config.RunnerConfig) error {
+	graph, err := newJobGraph(pipeline, jobs)
+	if err != nil {
+		return err
+	}
+
+	dir := c.String("script-dir")
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create script directory: %w", err)
+		}
+	}
+
+	for i, name := range graph.order {
+		job := jobs[name]
+		if job.Name == "" {
+			job.Name = name
+		}
+
+		script, err := runners.JobScript(job, cfg)
+		if err != nil {
+			return fmt.Errorf("job '%s': %w", name, err)
+		}
+
+		if dir == "" {
+			if i > 0 {
+				fmt.Println()
+			}
+			fmt.Print(script)
+			continue
+		}
+
+		path := filepath.Join(dir, unsafeFileChars.ReplaceAllString(name, "_")+".sh")
+		if err := os.WriteFile(path, []byte(script), 0755); err != nil {
+			return fmt.Errorf("failed to write script of job '%s': %w", name, err)
+		}
+		fmt.Printf("%s Wrote %s\n", okMark(c), path)
+	}
+
+	return nil
+}
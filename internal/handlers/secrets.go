@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/sanix-darker/git-ci/internal/config"
+	"github.com/sanix-darker/git-ci/internal/runners"
+	"github.com/sanix-darker/git-ci/internal/secrets"
+	"github.com/sanix-darker/git-ci/pkg/types"
+	cli "github.com/urfave/cli/v2"
+)
+
+// warnUnmaskedSecrets reports the secret values too short to be masked
+func warnUnmaskedSecrets(values []string) {
+	unmasked := 0
+	for _, value := range values {
+		if value != "" && !runners.Maskable(value) {
+			unmasked++
+		}
+	}
+	if unmasked > 0 {
+		fmt.Printf("Warning: %d secret value(s) shorter than 4 characters are not masked in the output\n", unmasked)
+	}
+}
+
+// resolveJobSecrets resolves the `secrets:` of the jobs into their
+// variables and adds the values to the ones masked in the output. File
+// secrets are written in the working directory, where container runners
+// see them too; the returned function removes them, even on error.
+func resolveJobSecrets(c *cli.Context, jobs map[string]*types.Job, workdir string, cfg *config.RunnerConfig) (cleanup func(), err error) {
+	var dir string
+	cleanup = func() {
+		if dir != "" {
+			os.RemoveAll(dir)
+		}
+	}
+
+	names := make([]string, 0, len(jobs))
+	for name, job := range jobs {
+		if len(job.SecretRefs) > 0 {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return cleanup, nil
+	}
+	sort.Strings(names)
+
+	// VAULT_ADDR and VAULT_TOKEN may also be given with --env
+	env := make(map[string]string)
+	for _, kv := range os.Environ() {
+		if k, v, ok := strings.Cut(kv, "="); ok {
+			env[k] = v
+		}
+	}
+	for k, v := range cfg.Environment {
+		env[k] = v
+	}
+
+	resolver, err := secrets.NewResolver(cfg.SecretsFile, env)
+	if err != nil {
+		return cleanup, err
+	}
+
+	for _, name := range names {
+		job := jobs[name]
+		vars := make(map[string]string, len(job.Environment)+len(job.SecretRefs))
+		for k, v := range job.Environment {
+			vars[k] = v
+		}
+
+		for _, secret := range job.SecretRefs {
+			value, err := resolver.Resolve(secret)
+			if err != nil {
+				return cleanup, fmt.Errorf("job '%s': %w", name, err)
+			}
+			cfg.Secrets = append(cfg.Secrets, value)
+
+			if !secret.File {
+				vars[secret.Name] = value
+				continue
+			}
+
+			if dir == "" {
+				if dir, err = os.MkdirTemp(workdir, ".git-ci-secrets-"); err != nil {
+					return cleanup, fmt.Errorf("failed to create the secrets directory: %w", err)
+				}
+			}
+			path := filepath.Join(dir, name, secret.Name)
+			if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+				return cleanup, fmt.Errorf("failed to write secret '%s': %w", secret.Name, err)
+			}
+			if err := os.WriteFile(path, []byte(value), 0o600); err != nil {
+				return cleanup, fmt.Errorf("failed to write secret '%s': %w", secret.Name, err)
+			}
+			vars[secret.Name] = secretFilePath(c, job, workdir, path)
+		}
+
+		job.Environment = vars
+		printVerbose(c, "Resolved %d secret(s) of job '%s'\n", len(job.SecretRefs), name)
+	}
+
+	return cleanup, nil
+}
+
+// secretValues returns the values masked in the output besides the
+// resolved secrets: pipeline variables marked secret, the secrets passed
+// to the jobs and the ${{ secrets.NAME }} their variables and steps
+// read, from --env or the environment
+func secretValues(pipeline *types.Pipeline, jobs map[string]*types.Job, cfg *config.RunnerConfig) []string {
+	lookup := func(name string) string {
+		if value, ok := cfg.Environment[name]; ok {
+			return value
+		}
+		return os.Getenv(name)
+	}
+
+	var values []string
+	for name, variable := range pipeline.Variables {
+		if variable == nil || !variable.Secret {
+			continue
+		}
+		value, ok := cfg.Environment[name]
+		if !ok {
+			value, ok = cfg.PipelineEnv[name]
+		}
+		if !ok && variable.Value != nil {
+			value = fmt.Sprint(variable.Value)
+		}
+		values = append(values, value)
+	}
+
+	for _, job := range jobs {
+		for _, value := range job.Secrets {
+			refs := runners.GithubSecretRefs(value)
+			if len(refs) == 0 {
+				values = append(values, value)
+				continue
+			}
+			for _, name := range refs {
+				values = append(values, lookup(name))
+			}
+		}
+		for _, name := range jobSecretRefs(job) {
+			values = append(values, lookup(name))
+		}
+	}
+	return values
+}
+
+// jobSecretRefs returns the names of the ${{ secrets.NAME }} expressions
+// of the variables, container and steps of a job
+func jobSecretRefs(job *types.Job) []string {
+	var refs []string
+	add := func(values ...string) {
+		for _, value := range values {
+			refs = append(refs, runners.GithubSecretRefs(value)...)
+		}
+	}
+	addMap := func(values map[string]string) {
+		for _, value := range values {
+			add(value)
+		}
+	}
+
+	addMap(job.Environment)
+	if job.Container != nil {
+		addMap(job.Container.Env)
+		addMap(job.Container.Credentials)
+	}
+	for _, step := range job.Steps {
+		add(step.Run)
+		addMap(step.Env)
+		addMap(step.With)
+	}
+	return refs
+}
+
+// secretFilePath returns the path of a secret file as the job sees it:
+// under the workspace of its container, or on the host
+func secretFilePath(c *cli.Context, job *types.Job, workdir, path string) string {
+	if useDocker, usePodman := containerEngine(c, job); useDocker || usePodman {
+		if rel, err := filepath.Rel(workdir, path); err == nil {
+			return runners.ContainerWorkspace + "/" + filepath.ToSlash(rel)
+		}
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/client"
+	"github.com/sanix-darker/git-ci/internal/runners"
+	"github.com/sanix-darker/git-ci/pkg/types"
+	cli "github.com/urfave/cli/v2"
+)
+
+// staleRun is a run whose process died without finishing it
+type staleRun struct {
+	id  string
+	pid int // 0 when the lock can't be read
+}
+
+// findStaleRuns returns the runs whose lock outlived their process. It
+// only reads the lock files, so it is cheap enough for every start.
+func findStaleRuns() []staleRun {
+	locks, _ := filepath.Glob(filepath.Join(runsDir(), "*.lock"))
+
+	var stale []staleRun
+	for _, lock := range locks {
+		data, err := os.ReadFile(lock)
+		if err != nil {
+			continue
+		}
+		pid, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+		if pid > 0 && processAlive(pid) {
+			continue
+		}
+		stale = append(stale, staleRun{
+			id:  strings.TrimSuffix(filepath.Base(lock), ".lock"),
+			pid: pid,
+		})
+	}
+	return stale
+}
+
+// findPartialRecords returns the run records left half-written by runs
+// that are not running anymore
+func findPartialRecords() []string {
+	tmps, _ := filepath.Glob(filepath.Join(runsDir(), "*.json.tmp"))
+
+	var partial []string
+	for _, tmp := range tmps {
+		id := strings.TrimSuffix(filepath.Base(tmp), ".json.tmp")
+		if data, err := os.ReadFile(runLockPath(id)); err == nil {
+			if pid, _ := strconv.Atoi(strings.TrimSpace(string(data))); pid > 0 && processAlive(pid) {
+				continue
+			}
+		}
+		partial = append(partial, tmp)
+	}
+	return partial
+}
+
+// WarnStaleRuns prints a notice when crashed runs left resources behind
+func WarnStaleRuns(c *cli.Context) {
+	if c.Args().First() == "clean" {
+		return
+	}
+
+	if stale := findStaleRuns(); len(stale) > 0 {
+		fmt.Fprintf(os.Stderr, "Note: %d run(s) ended without cleaning up; use 'git-ci clean --stale' to remove their leftovers\n", len(stale))
+	}
+}
+
+// findLegacyStepFiles returns the step file directories older versions
+// created in the checkout, left there by crashed runs
+func findLegacyStepFiles(workdir string) []string {
+	dirs, _ := filepath.Glob(filepath.Join(workdir, ".git-ci-step-*"))
+	return dirs
+}
+
+// cleanStale removes what crashed runs left behind: their labelled
+// containers, job workspaces, step files, lock and half-written run
+// records, and the step files older versions left in the checkout at
+// workdir. Their records are kept, marked as cancelled.
+func cleanStale(workdir string) error {
+	fmt.Println("  Cleaning leftovers of crashed runs...")
+
+	stale := findStaleRuns()
+	partial := findPartialRecords()
+	legacy := findLegacyStepFiles(workdir)
+	if len(stale) == 0 && len(partial) == 0 && len(legacy) == 0 {
+		fmt.Println("    No leftovers found")
+		return nil
+	}
+
+	for _, dir := range legacy {
+		fmt.Printf("    Removing step files %s...\n", filepath.Base(dir))
+		if err := os.RemoveAll(dir); err != nil {
+			fmt.Printf("    Warning: failed to remove %s: %v\n", dir, err)
+		}
+	}
+
+	for _, path := range partial {
+		fmt.Printf("    Removing half-written record %s...\n", filepath.Base(path))
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			fmt.Printf("    Warning: failed to remove %s: %v\n", path, err)
+		}
+	}
+
+	if len(stale) == 0 {
+		return nil
+	}
+
+	docker, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		return fmt.Errorf("failed to create Docker client: %w", err)
+	}
+	defer docker.Close()
+
+	for _, run := range stale {
+		// Keep the lock while containers remain, so a later clean retries
+		if err := removeRunResources(docker, run.id); err != nil {
+			fmt.Printf("    Warning: run %s: %v\n", run.id, err)
+			continue
+		}
+
+		if err := os.RemoveAll(workspacesDir(run.id)); err != nil {
+			fmt.Printf("    Warning: failed to remove the workspaces of run %s: %v\n", run.id, err)
+		}
+		if err := os.RemoveAll(runners.StepFilesDir(run.id)); err != nil {
+			fmt.Printf("    Warning: failed to remove the step files of run %s: %v\n", run.id, err)
+		}
+
+		if record, err := loadRun(run.id); err == nil && record.Status == types.StatusRunning {
+			record.Status = types.StatusCancelled
+			recorder := &runRecorder{run: record}
+			if err := recorder.write(); err != nil {
+				fmt.Printf("    Warning: failed to update run %s: %v\n", run.id, err)
+			}
+		}
+
+		if err := os.Remove(runLockPath(run.id)); err != nil && !os.IsNotExist(err) {
+			fmt.Printf("    Warning: failed to remove lock of run %s: %v\n", run.id, err)
+			continue
+		}
+		fmt.Printf("    Cleaned up run %s\n", run.id)
+	}
+
+	return nil
+}
+
+// removeRunResources removes the containers labelled with a run, then its
+// networks
+func removeRunResources(docker *client.Client, id string) error {
+	ctx := context.Background()
+	label := filters.NewArgs(filters.Arg("label", runners.LabelRun+"="+id))
+
+	containers, err := docker.ContainerList(ctx, container.ListOptions{
+		All:     true,
+		Filters: label,
+	})
+	if err != nil {
+		if client.IsErrConnectionFailed(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to list containers: %w", err)
+	}
+
+	for _, c := range containers {
+		name := strings.TrimPrefix(c.Names[0], "/")
+		fmt.Printf("    Removing container %s...\n", name)
+		if err := docker.ContainerRemove(ctx, c.ID, container.RemoveOptions{
+			Force:         true,
+			RemoveVolumes: true,
+		}); err != nil {
+			return fmt.Errorf("failed to remove container %s: %w", name, err)
+		}
+	}
+
+	networks, err := docker.NetworkList(ctx, network.ListOptions{Filters: label})
+	if err != nil {
+		return fmt.Errorf("failed to list networks: %w", err)
+	}
+	for _, n := range networks {
+		fmt.Printf("    Removing network %s...\n", n.Name)
+		if err := docker.NetworkRemove(ctx, n.ID); err != nil {
+			return fmt.Errorf("failed to remove network %s: %w", n.Name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/sanix-darker/git-ci/internal/runners"
+	"github.com/sanix-darker/git-ci/pkg/types"
+)
+
+func TestCleanStaleRemovesLegacyStepFiles(t *testing.T) {
+	t.Setenv("GIT_CI_CACHE_DIR", t.TempDir())
+	checkout := t.TempDir()
+
+	legacy := filepath.Join(checkout, ".git-ci-step-123")
+	if err := os.MkdirAll(legacy, 0o777); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(legacy, "output"), []byte("a=b\n"), 0o666); err != nil {
+		t.Fatal(err)
+	}
+	kept := filepath.Join(checkout, "src")
+	if err := os.Mkdir(kept, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cleanStale(checkout); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(legacy); !os.IsNotExist(err) {
+		t.Errorf("step files left in the checkout: %v", err)
+	}
+	if _, err := os.Stat(kept); err != nil {
+		t.Errorf("files of the checkout removed: %v", err)
+	}
+}
+
+// deadPID returns the PID of a process that has exited
+func deadPID(t *testing.T) int {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	return cmd.Process.Pid
+}
+
+// fakeRun writes what a run leaves while it runs: its lock, running
+// record, half-written record, workspaces and step files
+func fakeRun(t *testing.T, id string, pid int) {
+	t.Helper()
+	recorder := &runRecorder{run: &types.PipelineRun{ID: id, Status: types.StatusRunning}}
+	if err := recorder.write(); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		runLockPath(id):                                         strconv.Itoa(pid),
+		filepath.Join(runsDir(), id+".json.tmp"):                "{",
+		filepath.Join(workspacesDir(id), "build", "main.go"):    "package main\n",
+		filepath.Join(runners.StepFilesDir(id), "build", "env"): "A=b\n",
+	}
+	for path, content := range files {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCleanStaleRemovesCrashedRuns(t *testing.T) {
+	t.Setenv("GIT_CI_CACHE_DIR", t.TempDir())
+	// No daemon: the runs have no containers to remove
+	t.Setenv("DOCKER_HOST", "unix://"+filepath.Join(t.TempDir(), "docker.sock"))
+
+	fakeRun(t, "crashed", deadPID(t))
+	fakeRun(t, "live", os.Getpid())
+
+	if stale := findStaleRuns(); len(stale) != 1 || stale[0].id != "crashed" {
+		t.Fatalf("stale runs %v, want only crashed", stale)
+	}
+
+	if err := cleanStale(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{
+		runLockPath("crashed"),
+		filepath.Join(runsDir(), "crashed.json.tmp"),
+		workspacesDir("crashed"),
+		runners.StepFilesDir("crashed"),
+	} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s left behind: %v", path, err)
+		}
+	}
+	if run, err := loadRun("crashed"); err != nil || run.Status != types.StatusCancelled {
+		t.Errorf("crashed run record: %v, %v, want it kept, cancelled", run, err)
+	}
+
+	for _, path := range []string{
+		runLockPath("live"),
+		filepath.Join(runsDir(), "live.json.tmp"),
+		workspacesDir("live"),
+		runners.StepFilesDir("live"),
+	} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("resource of the live run removed: %v", err)
+		}
+	}
+	if run, err := loadRun("live"); err != nil || run.Status != types.StatusRunning {
+		t.Errorf("live run record: %v, %v, want it still running", run, err)
+	}
+}
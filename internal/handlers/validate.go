@@ -2,8 +2,10 @@ package handlers
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 
+	"github.com/sanix-darker/git-ci/internal/config"
 	"github.com/sanix-darker/git-ci/pkg/types"
 	cli "github.com/urfave/cli/v2"
 )
@@ -14,7 +16,13 @@ func CmdValidate(c *cli.Context) error {
 	strict := c.Bool("strict")
 
 	// Parse pipeline
-	pipeline, err := parseInput(filePath)
+	pipeline, err := parseInput(filePath, &config.RunnerConfig{
+		Offline:     c.Bool("offline"),
+		YAMLAnchors: c.Bool("merge-yaml-anchors"),
+		Strict:      strict,
+		NoFetch:     true,
+		Cache:       resolveCachePolicy(c),
+	})
 	if err != nil {
 		return fmt.Errorf("validation failed: %w", err)
 	}
@@ -22,7 +30,11 @@ func CmdValidate(c *cli.Context) error {
 	printVerbose(c, "Validating pipeline: %s\n", pipeline.Name)
 
 	// Perform validation
-	errors := validatePipeline(pipeline, strict)
+	errors, warnings := validatePipeline(pipeline, strict)
+
+	for _, warning := range warnings {
+		fmt.Printf("Warning: %s\n", warning)
+	}
 
 	if len(errors) > 0 {
 		fmt.Println("Validation errors found:")
@@ -34,7 +46,7 @@ func CmdValidate(c *cli.Context) error {
 		return fmt.Errorf("validation failed with %d error(s)", len(errors))
 	}
 
-	fmt.Printf("✓ Pipeline '%s' is valid\n", pipeline.Name)
+	fmt.Printf("%s Pipeline '%s' is valid\n", okMark(c), pipeline.Name)
 
 	// Print summary
 	fmt.Printf("\nSummary:\n")
@@ -54,12 +66,13 @@ func CmdValidate(c *cli.Context) error {
 	return nil
 }
 
-// validatePipeline performs validation on the pipeline
-func validatePipeline(pipeline *types.Pipeline, strict bool) []string {
-	var errors []string
+// validatePipeline performs validation on the pipeline. Warnings point
+// at things that work but are likely mistakes.
+func validatePipeline(pipeline *types.Pipeline, strict bool) ([]string, []string) {
+	var errors, warnings []string
 
 	if pipeline == nil {
-		return []string{"pipeline is nil"}
+		return []string{"pipeline is nil"}, nil
 	}
 
 	// Validate pipeline name
@@ -74,8 +87,24 @@ func validatePipeline(pipeline *types.Pipeline, strict bool) []string {
 
 	// Validate job stages
 	stageMap := make(map[string]bool)
-	for _, stage := range pipeline.Stages {
+	stageIndex := make(map[string]int, len(pipeline.Stages))
+	for i, stage := range pipeline.Stages {
 		stageMap[stage] = true
+		stageIndex[stage] = i
+	}
+
+	// GitLab creates no pipeline whose jobs all are in .pre and .post
+	if pipeline.Provider == "gitlab" && len(pipeline.Jobs) > 0 {
+		implicitOnly := true
+		for _, job := range pipeline.Jobs {
+			if job.Stage != ".pre" && job.Stage != ".post" {
+				implicitOnly = false
+				break
+			}
+		}
+		if implicitOnly {
+			warnings = append(warnings, "all jobs are in the .pre and .post stages; GitLab would not create the pipeline")
+		}
 	}
 
 	// Track job names for dependency validation
@@ -97,9 +126,24 @@ func validatePipeline(pipeline *types.Pipeline, strict bool) []string {
 		}
 
 		// Validate job dependencies exist
+		needed := make(map[string]bool)
 		for _, need := range job.Needs {
-			if !jobNames[need] {
-				errors = append(errors, fmt.Sprintf("job '%s' depends on non-existent job '%s'", jobName, need))
+			needed[need.Job] = true
+			switch {
+			case jobNames[need.Job]:
+			case need.Optional:
+				warnings = append(warnings, fmt.Sprintf("job '%s' optionally needs job '%s', which is not in the pipeline", jobName, need.Job))
+			default:
+				errors = append(errors, fmt.Sprintf("job '%s' depends on non-existent job '%s'", jobName, need.Job))
+			}
+		}
+		for _, dep := range job.Dependencies {
+			if !jobNames[dep] && !needed[dep] {
+				errors = append(errors, fmt.Sprintf("job '%s' dependencies reference non-existent job '%s'", jobName, dep))
+			}
+			// Artifacts only come from jobs that ran before
+			if upstream, ok := pipeline.Jobs[dep]; ok && stageMap[job.Stage] && stageMap[upstream.Stage] && stageIndex[upstream.Stage] > stageIndex[job.Stage] {
+				warnings = append(warnings, fmt.Sprintf("job '%s' dependencies reference job '%s' of the later stage '%s'", jobName, dep, upstream.Stage))
 			}
 		}
 
@@ -141,16 +185,21 @@ func validatePipeline(pipeline *types.Pipeline, strict bool) []string {
 				}
 			}
 
-			// Validate cache
-			if job.Cache != nil {
-				if len(job.Cache.Paths) == 0 {
-					errors = append(errors, fmt.Sprintf("job '%s' has cache defined but no paths", jobName))
+			// Validate caches (untracked files need no paths)
+			for _, cache := range job.CacheConfigs() {
+				if len(cache.Paths) == 0 && !cache.Untracked {
+					if cache.Key != "" {
+						errors = append(errors, fmt.Sprintf("job '%s' has cache '%s' defined but no paths", jobName, cache.Key))
+					} else {
+						errors = append(errors, fmt.Sprintf("job '%s' has cache defined but no paths", jobName))
+					}
 				}
 			}
 		}
 	}
 
-	return errors
+	sort.Strings(warnings)
+	return errors, warnings
 }
 
 // checkCircularDependencies checks for circular job dependencies
@@ -164,8 +213,8 @@ func checkCircularDependencies(jobName string, job *types.Job, allJobs map[strin
 
 	visited = append(visited, jobName)
 
-	// Check dependencies recursively
-	for _, need := range job.Needs {
+	// Check needs and dependencies recursively
+	for _, need := range job.UpstreamNames() {
 		if dependentJob, exists := allJobs[need]; exists {
 			if err := checkCircularDependencies(need, dependentJob, allJobs, visited); err != nil {
 				return err
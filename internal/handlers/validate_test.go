@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sanix-darker/git-ci/pkg/types"
+)
+
+func TestValidateDependenciesCycle(t *testing.T) {
+	step := []types.Step{{Name: "run", Run: "true"}}
+	pipeline := &types.Pipeline{
+		Name:     "ci",
+		Provider: "gitlab",
+		Jobs: map[string]*types.Job{
+			"a": {Name: "a", Dependencies: []string{"b"}, Steps: step},
+			"b": {Name: "b", Dependencies: []string{"a"}, Steps: step},
+			"c": {Name: "c", Dependencies: []string{"missing"}, Steps: step},
+		},
+	}
+
+	errors, _ := validatePipeline(pipeline, false)
+	all := strings.Join(errors, "\n")
+	for _, want := range []string{
+		"circular dependency detected: a -> b -> a",
+		"circular dependency detected: b -> a -> b",
+		"job 'c' dependencies reference non-existent job 'missing'",
+	} {
+		if !strings.Contains(all, want) {
+			t.Errorf("errors:\n%s\nwant %q", all, want)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sanix-darker/git-ci/internal/config"
+	"github.com/sanix-darker/git-ci/internal/runners"
+	"github.com/sanix-darker/git-ci/pkg/types"
+	cli "github.com/urfave/cli/v2"
+)
+
+// expandJobVariables expands the variables GitLab expands before a job
+// runs: in its image, service images, cache key and paths, artifact name
+// and paths, and environment name. Variables come from the pipeline, the
+// job, its matching rule and --env; unknown ones expand to nothing.
+func expandJobVariables(c *cli.Context, pipeline *types.Pipeline, jobs map[string]*types.Job, cfg *config.RunnerConfig) error {
+	if pipeline.Provider != "gitlab" {
+		return nil
+	}
+
+	for name, job := range jobs {
+		vars, err := runners.ExpandEnv(runners.JobVariables(job, cfg), nil, pipeline.Provider)
+		if err != nil {
+			return fmt.Errorf("job '%s': %w", name, err)
+		}
+		// Predefined variables describing the job itself
+		for k, v := range map[string]string{"CI_JOB_NAME": name, "CI_JOB_STAGE": job.Stage} {
+			if _, ok := vars[k]; !ok {
+				vars[k] = v
+			}
+		}
+
+		expand := func(what, value string) string {
+			expanded, unknown := runners.ExpandVariables(value, vars)
+			if len(unknown) > 0 {
+				printVerbose(c, "Warning: job '%s': %s uses unset variable(s) %s\n", name, what, strings.Join(unknown, ", "))
+			}
+			return expanded
+		}
+		expandAll := func(what string, values []string) {
+			for i, value := range values {
+				values[i] = expand(what, value)
+			}
+		}
+
+		if job.Image != "" {
+			image := expand("image", job.Image)
+			if job.RunsOn == job.Image {
+				job.RunsOn = image
+			}
+			job.Image = image
+		}
+		if job.Container != nil {
+			job.Container.Image = expand("image", job.Container.Image)
+		}
+		for serviceName, service := range job.Services {
+			service.Image = expand(fmt.Sprintf("service '%s'", serviceName), service.Image)
+		}
+		for _, cache := range job.CacheConfigs() {
+			cache.Key = expand("cache key", cache.Key)
+			expandAll("cache paths", cache.Paths)
+			expandAll("cache fallback keys", cache.Fallback)
+		}
+		if job.Artifacts != nil {
+			job.Artifacts.Name = expand("artifacts name", job.Artifacts.Name)
+			expandAll("artifacts paths", job.Artifacts.Paths)
+			expandAll("artifacts exclude", job.Artifacts.Exclude)
+		}
+		job.EnvironmentName = expand("environment", job.EnvironmentName)
+		if job.Deployment != nil {
+			job.Deployment.Name = job.EnvironmentName
+			job.Deployment.URL = expand("environment url", job.Deployment.URL)
+		}
+		job.ResourceGroup = expand("resource_group", job.ResourceGroup)
+	}
+
+	return nil
+}
@@ -0,0 +1,242 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/sanix-darker/git-ci/internal/config"
+	"github.com/sanix-darker/git-ci/pkg/types"
+)
+
+// workspacesDir returns where the jobs of a run get their workspace
+func workspacesDir(runID string) string {
+	return filepath.Join(config.GetCacheDir(), "workspaces", runID)
+}
+
+// workspace returns the directory a job runs in: a copy of the checkout
+// of its own, so its files, the artifacts and caches it receives and
+// what it leaves behind stay out of the checkout and of the other jobs.
+// Dry runs and trigger jobs, which run nothing, use the checkout, and so
+// do all jobs with --no-workspace.
+func (s *runState) workspace(jobName string, job *types.Job, workdir string, cfg *config.RunnerConfig) (string, error) {
+	if s == nil || cfg.DryRun || cfg.NoWorkspace || job.Trigger != nil {
+		return workdir, nil
+	}
+
+	dir := filepath.Join(workspacesDir(s.id), url.PathEscape(jobName))
+	if err := os.RemoveAll(dir); err != nil {
+		return "", fmt.Errorf("failed to prepare the workspace of job '%s': %w", jobName, err)
+	}
+	if err := copyCheckout(workdir, dir); err != nil {
+		os.RemoveAll(dir)
+		return "", fmt.Errorf("failed to prepare the workspace of job '%s': %w", jobName, err)
+	}
+	return dir, nil
+}
+
+// removeWorkspaces removes the workspaces of the jobs of the run, child
+// pipelines included
+func (s *runState) removeWorkspaces() {
+	if s == nil {
+		return
+	}
+	os.RemoveAll(workspacesDir(s.id))
+}
+
+// copyCheckout copies the checkout src into dst. Of a git checkout, only
+// the files git knows of are copied, tracked or untracked but not ignored,
+// with a .git sharing the objects of the checkout; any other directory is
+// copied whole. The cache directory is left out when it lives in the
+// checkout.
+func copyCheckout(src, dst string) error {
+	src, err := filepath.Abs(src)
+	if err != nil {
+		return err
+	}
+	files, err := checkoutFiles(src)
+	if err != nil {
+		return err
+	}
+	if files == nil {
+		return copyCheckoutTree(src, dst, src, dst)
+	}
+
+	if err := cloneCheckout(src, dst); err != nil {
+		return err
+	}
+	cacheDir, _ := filepath.Abs(config.GetCacheDir())
+	for _, rel := range files {
+		path := filepath.Join(src, rel)
+		if path == cacheDir || strings.HasPrefix(path, cacheDir+string(filepath.Separator)) {
+			continue
+		}
+		info, err := os.Lstat(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			// Deleted in the checkout, and so in the workspace
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return err
+		}
+		if info.IsDir() {
+			// A submodule, copied whole
+			if err := copyCheckoutTree(path, target, src, dst); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := copyEntry(path, target, info.Mode(), src, dst); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkoutFiles returns the files of the git checkout at src, relative to
+// it: tracked ones and untracked ones git does not ignore. It returns nil
+// when src is not the root of a git checkout.
+func checkoutFiles(src string) ([]string, error) {
+	top := gitOutput(src, "rev-parse", "--show-toplevel")
+	if top == "" {
+		return nil, nil
+	}
+	if resolved, err := filepath.EvalSymlinks(src); err != nil || filepath.Clean(top) != resolved {
+		return nil, nil
+	}
+
+	cmd := exec.Command("git", "ls-files", "-z", "--cached", "--others", "--exclude-standard")
+	cmd.Dir = src
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the files of %s: %w", src, err)
+	}
+
+	files := []string{}
+	seen := make(map[string]bool)
+	for _, file := range strings.Split(string(output), "\x00") {
+		// Unmerged files are listed once per stage
+		if file == "" || seen[file] {
+			continue
+		}
+		seen[file] = true
+		files = append(files, filepath.FromSlash(file))
+	}
+	return files, nil
+}
+
+// cloneCheckout gives dst a .git of its own, sharing the objects of the
+// checkout at src: same branch, commit and origin remote, and an index of
+// the commit, so that git in the workspace sees the changes of the
+// checkout and writes nothing to it.
+func cloneCheckout(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	if err := runGit(filepath.Dir(dst), "clone", "--quiet", "--shared", "--no-checkout", src, dst); err != nil {
+		return err
+	}
+	if origin := gitOutput(src, "remote", "get-url", "origin"); origin != "" {
+		if err := runGit(dst, "remote", "set-url", "origin", origin); err != nil {
+			return err
+		}
+	} else if err := runGit(dst, "remote", "remove", "origin"); err != nil {
+		return err
+	}
+	if gitOutput(dst, "rev-parse", "--verify", "--quiet", "HEAD") == "" {
+		// No commit yet, nothing to index
+		return nil
+	}
+	return runGit(dst, "reset", "--quiet")
+}
+
+// runGit runs a git command in dir, its error carrying what git printed
+func runGit(dir string, args ...string) error {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("git %s: %w: %s", args[0], err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// copyCheckoutTree copies the directory from into to, keeping symlinks as
+// such. The cache directory and the workspaces are left out when they live
+// in it.
+func copyCheckoutTree(from, to, src, dst string) error {
+	cacheDir, _ := filepath.Abs(config.GetCacheDir())
+
+	return filepath.WalkDir(from, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && (path == cacheDir || path == dst) {
+			return filepath.SkipDir
+		}
+
+		rel, err := filepath.Rel(from, path)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		return copyEntry(path, filepath.Join(to, rel), info.Mode(), src, dst)
+	})
+}
+
+// copyEntry copies a directory (without its contents), a symlink or a
+// regular file of the checkout src into the workspace dst. An absolute
+// symlink into the checkout is pointed at the same file of the workspace.
+func copyEntry(path, target string, mode os.FileMode, src, dst string) error {
+	switch {
+	case mode.IsDir():
+		return os.MkdirAll(target, mode.Perm()|0o700)
+	case mode&os.ModeSymlink != 0:
+		link, err := os.Readlink(path)
+		if err != nil {
+			return err
+		}
+		if filepath.IsAbs(link) {
+			if rel, err := filepath.Rel(src, link); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				link = filepath.Join(dst, rel)
+			}
+		}
+		return os.Symlink(link, target)
+	case mode.IsRegular():
+		return copyFile(path, target, mode.Perm())
+	default:
+		// Sockets, pipes and devices have no place in a workspace
+		return nil
+	}
+}
+
+// copyFile copies a regular file, created with perm
+func copyFile(src, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
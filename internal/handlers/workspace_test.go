@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeFiles writes files, relative to dir
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// gitRun runs git in dir, failing the test on error
+func gitRun(t *testing.T, dir string, args ...string) {
+	t.Helper()
+
+	if err := runGit(dir, args...); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCopyCheckoutCopiesTheFilesGitKnows(t *testing.T) {
+	t.Setenv("GIT_CI_CACHE_DIR", t.TempDir())
+	src := t.TempDir()
+	writeFiles(t, src, map[string]string{
+		".gitignore":  "build/\n*.log\n",
+		"main.go":     "package main\n",
+		"lib/lib.go":  "package lib\n",
+		"deleted.txt": "gone\n",
+		"build/out":   "binary\n",
+		"debug.log":   "noise\n",
+	})
+	gitRun(t, src, "init", "-q", "-b", "work")
+	gitRun(t, src, "remote", "add", "origin", "https://example.com/group/project.git")
+	gitRun(t, src, "add", "-A")
+	gitRun(t, src, "-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-qm", "init")
+
+	// Uncommitted changes of the checkout: modified, deleted, untracked
+	writeFiles(t, src, map[string]string{
+		"main.go": "package main // changed\n",
+		"new.go":  "package main\n",
+	})
+	if err := os.Remove(filepath.Join(src, "deleted.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(src, "lib", "lib.go"), filepath.Join(src, "abs-link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join("lib", "lib.go"), filepath.Join(src, "rel-link")); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := filepath.Join(t.TempDir(), "workspaces", "job")
+	if err := copyCheckout(src, dst); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]string{
+		"main.go":    "package main // changed\n",
+		"new.go":     "package main\n",
+		"lib/lib.go": "package lib\n",
+		"abs-link":   "package lib\n",
+		"rel-link":   "package lib\n",
+	} {
+		got, err := os.ReadFile(filepath.Join(dst, filepath.FromSlash(name)))
+		if err != nil || string(got) != want {
+			t.Errorf("%s = %q, %v; want %q", name, got, err, want)
+		}
+	}
+	for _, name := range []string{"build", "debug.log", "deleted.txt"} {
+		if _, err := os.Lstat(filepath.Join(dst, name)); !os.IsNotExist(err) {
+			t.Errorf("%s copied into the workspace: %v", name, err)
+		}
+	}
+
+	// An absolute symlink into the checkout points into the workspace
+	if link, err := os.Readlink(filepath.Join(dst, "abs-link")); err != nil || link != filepath.Join(dst, "lib", "lib.go") {
+		t.Errorf("abs-link -> %q, %v; want the workspace file", link, err)
+	}
+
+	// git in the workspace sees the commit, branch, remote and changes
+	// of the checkout
+	for _, args := range [][]string{
+		{"rev-parse", "HEAD"},
+		{"rev-parse", "--abbrev-ref", "HEAD"},
+		{"remote", "get-url", "origin"},
+		{"status", "--porcelain", "--untracked-files=no"},
+	} {
+		if got, want := gitOutput(dst, args...), gitOutput(src, args...); got != want {
+			t.Errorf("git %v = %q in the workspace, %q in the checkout", args, got, want)
+		}
+	}
+	if got := gitOutput(dst, "status", "--porcelain", "--", "main.go"); got != "M main.go" {
+		t.Errorf("git status of a changed file = %q", got)
+	}
+}
+
+func TestCopyCheckoutOfAPlainDirectory(t *testing.T) {
+	t.Setenv("GIT_CI_CACHE_DIR", t.TempDir())
+	src := t.TempDir()
+	writeFiles(t, src, map[string]string{
+		"script.sh":  "echo hi\n",
+		"build/out":  "binary\n",
+		".gitignore": "build/\n",
+	})
+
+	dst := filepath.Join(t.TempDir(), "job")
+	if err := copyCheckout(src, dst); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"script.sh", "build/out", ".gitignore"} {
+		if _, err := os.Stat(filepath.Join(dst, filepath.FromSlash(name))); err != nil {
+			t.Errorf("%s not copied: %v", name, err)
+		}
+	}
+}
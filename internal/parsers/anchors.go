@@ -0,0 +1,138 @@
+package parsers
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+// mergedAnchorDocs decodes the root file together with the local files it
+// includes, directly or not, as one YAML document so that an anchor
+// defined in a file resolves in the files coming after it. Each file is
+// an item of a sequence, included files before the file including them,
+// so keys defined in several files don't clash. The decoded files are
+// returned by include key.
+func (p *GitlabParser) mergedAnchorDocs(rootPath string, rootData []byte) (map[string]*decodedFile, error) {
+	var keys []string
+	contents := make(map[string][]byte)
+
+	var collect func(key string, data []byte)
+	collect = func(key string, data []byte) {
+		contents[key] = data
+		for _, path := range p.scanLocalIncludes(data) {
+			includeKey := p.cacheKey(path)
+			if _, seen := contents[includeKey]; seen {
+				continue
+			}
+			// Unreadable files are reported when the include is merged
+			if included, err := p.readFile(path); err == nil {
+				collect(includeKey, included)
+			}
+		}
+		keys = append(keys, key)
+	}
+	collect(p.cacheKey(rootPath), rootData)
+
+	var doc strings.Builder
+	for _, key := range keys {
+		doc.WriteString("-\n")
+		lines := strings.Split(strings.TrimRight(string(contents[key]), "\n"), "\n")
+		for len(lines) > 0 && strings.TrimSpace(lines[0]) == "---" {
+			lines = lines[1:]
+		}
+		for _, line := range lines {
+			doc.WriteString("  " + line + "\n")
+		}
+	}
+
+	var node yaml.Node
+	if err := yaml.Unmarshal([]byte(doc.String()), &node); err != nil {
+		return nil, fmt.Errorf("merging the anchors of %s: %w", strings.Join(p.includeNames(keys), ", "), err)
+	}
+	markReferences(&node)
+
+	var items []*yaml.Node
+	if len(node.Content) > 0 {
+		items = node.Content[0].Content
+	}
+	if len(items) != len(keys) {
+		return nil, fmt.Errorf("merging the anchors of %s: multi-document files are not supported", strings.Join(p.includeNames(keys), ", "))
+	}
+
+	docs := make(map[string]*decodedFile, len(keys))
+	for i, key := range keys {
+		file, err := decodeNode(items[i])
+		if err != nil {
+			return nil, fmt.Errorf("merging the anchors of %s: %w", strings.Join(p.includeNames(keys), ", "), err)
+		}
+		docs[key] = file
+	}
+	return docs, nil
+}
+
+// scanLocalIncludes returns the local files a GitLab file includes, read
+// from its top-level include: block alone since the rest of the file may
+// use anchors it doesn't define
+func (p *GitlabParser) scanLocalIncludes(data []byte) []string {
+	lines := strings.Split(string(data), "\n")
+	var block []string
+	for i, line := range lines {
+		if !strings.HasPrefix(line, "include:") {
+			continue
+		}
+		block = append(block, line)
+		for _, next := range lines[i+1:] {
+			if next != "" && !strings.HasPrefix(next, " ") && !strings.HasPrefix(next, "\t") &&
+				!strings.HasPrefix(next, "#") && !strings.HasPrefix(next, "- ") {
+				break
+			}
+			block = append(block, next)
+		}
+		break
+	}
+	if block == nil {
+		return nil
+	}
+
+	var parsed struct {
+		Include interface{} `yaml:"include"`
+	}
+	if err := yaml.Unmarshal([]byte(strings.Join(block, "\n")), &parsed); err != nil {
+		return nil
+	}
+
+	var entries []interface{}
+	switch v := parsed.Include.(type) {
+	case []interface{}:
+		entries = v
+	case nil:
+	default:
+		entries = []interface{}{v}
+	}
+
+	var paths []string
+	for _, entry := range entries {
+		switch v := entry.(type) {
+		case string:
+			if !strings.HasPrefix(v, "http://") && !strings.HasPrefix(v, "https://") {
+				paths = append(paths, filepath.Join(p.baseDir, v))
+			}
+		case map[string]interface{}:
+			if local, ok := v["local"].(string); ok {
+				paths = append(paths, filepath.Join(p.baseDir, local))
+			}
+		}
+	}
+	return paths
+}
+
+// includeNames returns include keys for display
+func (p *GitlabParser) includeNames(keys []string) []string {
+	names := make([]string, len(keys))
+	for i, key := range keys {
+		names[i] = p.includeName(key)
+	}
+	return names
+}
@@ -0,0 +1,787 @@
+package parsers
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/sanix-darker/git-ci/pkg/types"
+	yaml "gopkg.in/yaml.v3"
+)
+
+// bitbucketDefaultImage is the image of steps that don't name one
+const bitbucketDefaultImage = "atlassian/default-image:4"
+
+// bitbucketDefaultMaxTime is the step timeout in minutes when neither the
+// step nor the global options set max-time
+const bitbucketDefaultMaxTime = 120
+
+// bitbucketPredefinedCaches are the caches steps may use without defining
+// them, with the path Bitbucket caches for each
+var bitbucketPredefinedCaches = map[string]string{
+	"composer":   "~/.composer/cache",
+	"dotnetcore": "~/.nuget/packages",
+	"gradle":     "~/.gradle/caches",
+	"ivy2":       "~/.ivy2/cache",
+	"maven":      "~/.m2/repository",
+	"node":       "node_modules",
+	"pip":        "~/.cache/pip",
+	"sbt":        "~/.sbt",
+}
+
+type BitbucketParser struct {
+	// Variables telling which pipeline the checkout would start
+	variables map[string]string
+}
+
+// NewBitbucketParser creates a new Bitbucket Pipelines parser
+func NewBitbucketParser() *BitbucketParser {
+	return &BitbucketParser{}
+}
+
+// SetVariables sets the predefined CI variables (CI_COMMIT_BRANCH,
+// CI_COMMIT_TAG, CI_PIPELINE_SOURCE) used to pick the pipeline Bitbucket
+// would start for the checkout
+func (p *BitbucketParser) SetVariables(vars map[string]string) {
+	p.variables = vars
+}
+
+// Bitbucket Pipelines structures
+type BitbucketConfig struct {
+	Image       interface{}           `yaml:"image,omitempty"`
+	Options     *BitbucketOptions     `yaml:"options,omitempty"`
+	Definitions *BitbucketDefinitions `yaml:"definitions,omitempty"`
+	Pipelines   BitbucketPipelines    `yaml:"pipelines"`
+
+	// Local-only job hints, ignored by Bitbucket
+	LocalHints map[string]*types.LocalHints `yaml:"x-git-ci,omitempty"`
+}
+
+type BitbucketOptions struct {
+	MaxTime int `yaml:"max-time,omitempty"`
+}
+
+type BitbucketDefinitions struct {
+	Caches   map[string]interface{}       `yaml:"caches,omitempty"`
+	Services map[string]*BitbucketService `yaml:"services,omitempty"`
+}
+
+type BitbucketService struct {
+	Image     interface{}       `yaml:"image"`
+	Variables map[string]string `yaml:"variables,omitempty"`
+	Type      string            `yaml:"type,omitempty"`
+}
+
+type BitbucketPipelines struct {
+	Default      []BitbucketItem            `yaml:"default,omitempty"`
+	Branches     map[string][]BitbucketItem `yaml:"branches,omitempty"`
+	PullRequests map[string][]BitbucketItem `yaml:"pull-requests,omitempty"`
+	Tags         map[string][]BitbucketItem `yaml:"tags,omitempty"`
+	Custom       map[string][]BitbucketItem `yaml:"custom,omitempty"`
+}
+
+// BitbucketItem is an entry of a pipeline: a step, a parallel group, a
+// stage, or for custom pipelines the variables asked for when starting it
+type BitbucketItem struct {
+	Step      *BitbucketStep      `yaml:"step,omitempty"`
+	Parallel  interface{}         `yaml:"parallel,omitempty"`
+	Stage     *BitbucketStage     `yaml:"stage,omitempty"`
+	Variables []BitbucketVariable `yaml:"variables,omitempty"`
+}
+
+type BitbucketStage struct {
+	Name       string          `yaml:"name,omitempty"`
+	Deployment string          `yaml:"deployment,omitempty"`
+	Trigger    string          `yaml:"trigger,omitempty"`
+	Steps      []BitbucketItem `yaml:"steps"`
+}
+
+type BitbucketStep struct {
+	Name        string        `yaml:"name,omitempty"`
+	Image       interface{}   `yaml:"image,omitempty"`
+	Script      []interface{} `yaml:"script"`
+	AfterScript []interface{} `yaml:"after-script,omitempty"`
+	Caches      []string      `yaml:"caches,omitempty"`
+	Artifacts   interface{}   `yaml:"artifacts,omitempty"`
+	Services    []string      `yaml:"services,omitempty"`
+	Trigger     string        `yaml:"trigger,omitempty"`
+	Deployment  string        `yaml:"deployment,omitempty"`
+	MaxTime     int           `yaml:"max-time,omitempty"`
+	Size        string        `yaml:"size,omitempty"`
+}
+
+type BitbucketVariable struct {
+	Name          string   `yaml:"name"`
+	Default       string   `yaml:"default,omitempty"`
+	Description   string   `yaml:"description,omitempty"`
+	AllowedValues []string `yaml:"allowed-values,omitempty"`
+}
+
+// Parse parses a bitbucket-pipelines.yml file
+func (p *BitbucketParser) Parse(ciFilePath string) (*types.Pipeline, error) {
+	// Check if file exists
+	if _, err := os.Stat(ciFilePath); os.IsNotExist(err) {
+		return nil, fmt.Errorf("pipelines file not found: %s", ciFilePath)
+	}
+
+	// Read file content
+	data, err := os.ReadFile(ciFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pipelines file: %w", err)
+	}
+
+	// Handle empty files
+	if len(data) == 0 {
+		return nil, fmt.Errorf("pipelines file is empty: %s", ciFilePath)
+	}
+
+	var config BitbucketConfig
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse YAML: %w", err)
+	}
+
+	// Convert to generic Pipeline
+	pipeline, err := p.convertToPipeline(&config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert pipelines: %w", err)
+	}
+
+	// Attach local-only hints
+	if err := attachLocalHints(pipeline, config.LocalHints); err != nil {
+		return nil, fmt.Errorf("pipelines validation failed: %w", err)
+	}
+
+	// Validate the pipeline
+	if err := p.Validate(pipeline); err != nil {
+		return nil, fmt.Errorf("pipelines validation failed: %w", err)
+	}
+
+	return pipeline, nil
+}
+
+// bitbucketSection is one pipeline of the file: default, or a branch,
+// pull request, tag or custom pipeline with its pattern or name
+type bitbucketSection struct {
+	label string // Stage of its jobs: default, branches:main, custom:deploy...
+	items []BitbucketItem
+}
+
+// convertToPipeline converts the pipelines of the file to a generic
+// Pipeline. Each pipeline is a stage and each of its steps a job needing
+// the steps before it; the steps of a parallel group share their needs.
+// Only the pipeline Bitbucket would start for the checkout runs by
+// default, the jobs of the others are manual.
+func (p *BitbucketParser) convertToPipeline(config *BitbucketConfig) (*types.Pipeline, error) {
+	pipeline := &types.Pipeline{
+		Name:        "Bitbucket Pipelines",
+		Description: "Bitbucket Pipelines configuration",
+		Provider:    "bitbucket",
+		Jobs:        make(map[string]*types.Job),
+	}
+
+	sections := p.sections(&config.Pipelines)
+	active := p.activeSection(&config.Pipelines)
+
+	for _, section := range sections {
+		pipeline.Stages = append(pipeline.Stages, section.label)
+
+		conv := &bitbucketConversion{
+			parser:   p,
+			config:   config,
+			pipeline: pipeline,
+			section:  section,
+			manual:   section.label != active,
+		}
+		if err := conv.convertItems(section.items); err != nil {
+			return nil, fmt.Errorf("pipeline '%s': %w", section.label, err)
+		}
+	}
+
+	return pipeline, nil
+}
+
+// sections returns the pipelines of the file in a stable order: default,
+// then branches, pull requests, tags and custom pipelines by pattern
+func (p *BitbucketParser) sections(pipelines *BitbucketPipelines) []bitbucketSection {
+	var sections []bitbucketSection
+	if len(pipelines.Default) > 0 {
+		sections = append(sections, bitbucketSection{label: "default", items: pipelines.Default})
+	}
+
+	add := func(kind string, byPattern map[string][]BitbucketItem) {
+		for _, pattern := range sortedKeys(byPattern) {
+			sections = append(sections, bitbucketSection{label: kind + ":" + pattern, items: byPattern[pattern]})
+		}
+	}
+	add("branches", pipelines.Branches)
+	add("pull-requests", pipelines.PullRequests)
+	add("tags", pipelines.Tags)
+	add("custom", pipelines.Custom)
+
+	return sections
+}
+
+// activeSection returns the label of the pipeline Bitbucket would start
+// for the checkout: the tag pipeline of a tag, the pull request pipeline
+// for a merge request event, else the branch pipeline, falling back to
+// default. Custom pipelines only run when asked for.
+func (p *BitbucketParser) activeSection(pipelines *BitbucketPipelines) string {
+	tag := p.variables["CI_COMMIT_TAG"]
+	branch := p.variables["CI_COMMIT_BRANCH"]
+	source := p.variables["CI_PIPELINE_SOURCE"]
+
+	var label string
+	switch {
+	case tag != "":
+		label = p.matchSection("tags", pipelines.Tags, tag)
+	case branch != "" && (source == "merge_request_event" || source == "pull_request"):
+		label = p.matchSection("pull-requests", pipelines.PullRequests, branch)
+	case branch != "":
+		label = p.matchSection("branches", pipelines.Branches, branch)
+	}
+
+	if label == "" && len(pipelines.Default) > 0 {
+		return "default"
+	}
+	return label
+}
+
+// matchSection returns the label of the pipeline whose pattern matches
+// ref, an exact name winning over glob patterns, or "" if none does
+func (p *BitbucketParser) matchSection(kind string, byPattern map[string][]BitbucketItem, ref string) string {
+	if _, ok := byPattern[ref]; ok {
+		return kind + ":" + ref
+	}
+	for _, pattern := range sortedKeys(byPattern) {
+		if bitbucketGlob(pattern).MatchString(ref) {
+			return kind + ":" + pattern
+		}
+	}
+	return ""
+}
+
+// bitbucketGlob compiles a Bitbucket branch or tag pattern: * matches
+// within a path segment, ** across them, and {a,b} either alternative
+func bitbucketGlob(pattern string) *regexp.Regexp {
+	var expr strings.Builder
+	expr.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				expr.WriteString(".*")
+				i++
+			} else {
+				expr.WriteString("[^/]*")
+			}
+		case '?':
+			expr.WriteString("[^/]")
+		case '{':
+			expr.WriteString("(")
+		case '}':
+			expr.WriteString(")")
+		case ',':
+			expr.WriteString("|")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	expr.WriteString("$")
+
+	re, err := regexp.Compile(expr.String())
+	if err != nil {
+		// Unbalanced braces: match the pattern literally
+		return regexp.MustCompile("^" + regexp.QuoteMeta(pattern) + "$")
+	}
+	return re
+}
+
+// bitbucketConversion holds the state of converting one pipeline
+type bitbucketConversion struct {
+	parser   *BitbucketParser
+	config   *BitbucketConfig
+	pipeline *types.Pipeline
+	section  bitbucketSection
+	manual   bool
+
+	variables map[string]string // Defaults of custom pipeline variables
+	previous  []string          // Jobs of the last step or parallel group
+	artifacts []string          // Earlier jobs declaring artifacts
+	count     int               // Steps converted so far
+}
+
+// convertItems converts the entries of a pipeline in order
+func (c *bitbucketConversion) convertItems(items []BitbucketItem) error {
+	for _, item := range items {
+		switch {
+		case item.Step != nil:
+			if err := c.convertGroup([]*BitbucketStep{item.Step}, nil); err != nil {
+				return err
+			}
+		case item.Parallel != nil:
+			steps, err := c.parallelSteps(item.Parallel)
+			if err != nil {
+				return err
+			}
+			if err := c.convertGroup(steps, nil); err != nil {
+				return err
+			}
+		case item.Stage != nil:
+			// The steps of a stage run one after the other
+			for _, entry := range item.Stage.Steps {
+				if entry.Step == nil {
+					return fmt.Errorf("stage '%s' may only contain steps", item.Stage.Name)
+				}
+				if err := c.convertGroup([]*BitbucketStep{entry.Step}, item.Stage); err != nil {
+					return err
+				}
+			}
+		case item.Variables != nil:
+			c.variables = make(map[string]string)
+			for _, variable := range item.Variables {
+				c.variables[variable.Name] = variable.Default
+			}
+		}
+	}
+	return nil
+}
+
+// parallelSteps returns the steps of a parallel group, given as a list
+// or as a map with steps and fail-fast
+func (c *bitbucketConversion) parallelSteps(parallel interface{}) ([]*BitbucketStep, error) {
+	raw := parallel
+	if group, ok := parallel.(map[string]interface{}); ok {
+		raw = group["steps"]
+	}
+
+	data, err := yaml.Marshal(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid parallel group: %w", err)
+	}
+	var items []BitbucketItem
+	if err := yaml.Unmarshal(data, &items); err != nil {
+		return nil, fmt.Errorf("invalid parallel group: %w", err)
+	}
+
+	var steps []*BitbucketStep
+	for _, item := range items {
+		if item.Step == nil {
+			return nil, fmt.Errorf("parallel groups may only contain steps")
+		}
+		steps = append(steps, item.Step)
+	}
+	return steps, nil
+}
+
+// convertGroup converts steps running side by side after the previous
+// group. stage is the stage holding them, if any.
+func (c *bitbucketConversion) convertGroup(steps []*BitbucketStep, stage *BitbucketStage) error {
+	var names, withArtifacts []string
+	for _, bbStep := range steps {
+		c.count++
+		name := c.jobName(bbStep)
+
+		job, err := c.convertStep(name, bbStep, stage)
+		if err != nil {
+			return fmt.Errorf("step '%s': %w", name, err)
+		}
+		c.pipeline.Jobs[name] = job
+
+		names = append(names, name)
+		if job.Artifacts != nil {
+			withArtifacts = append(withArtifacts, name)
+		}
+	}
+
+	c.previous = names
+	c.artifacts = append(c.artifacts, withArtifacts...)
+	return nil
+}
+
+// jobName returns a unique job name for a step: its name, or its position
+// in the pipeline, prefixed by the pipeline unless it is the default one
+func (c *bitbucketConversion) jobName(step *BitbucketStep) string {
+	base := step.Name
+	if base == "" {
+		base = fmt.Sprintf("Step %d", c.count)
+	}
+	if c.section.label != "default" {
+		base = c.section.label + "/" + base
+	}
+
+	name := base
+	for i := 2; c.pipeline.Jobs[name] != nil; i++ {
+		name = fmt.Sprintf("%s (%d)", base, i)
+	}
+	return name
+}
+
+// convertStep converts a Bitbucket step to a generic Job
+func (c *bitbucketConversion) convertStep(name string, bbStep *BitbucketStep, stage *BitbucketStage) (*types.Job, error) {
+	job := &types.Job{
+		Name:       name,
+		Stage:      c.section.label,
+		Needs:      types.NeedsOf(c.previous...),
+		TimeoutMin: bbStep.MaxTime,
+	}
+
+	// Steps receive the artifacts of every earlier step
+	job.Dependencies = append([]string(nil), c.artifacts...)
+	job.DependenciesSet = true
+
+	if job.TimeoutMin == 0 && c.config.Options != nil {
+		job.TimeoutMin = c.config.Options.MaxTime
+	}
+	if job.TimeoutMin == 0 {
+		job.TimeoutMin = bitbucketDefaultMaxTime
+	}
+
+	if len(c.variables) > 0 {
+		job.Environment = make(map[string]string, len(c.variables))
+		for k, v := range c.variables {
+			job.Environment[k] = v
+		}
+	}
+
+	// Image of the step, the global one, or Bitbucket's default
+	image := c.parser.parseImage(bbStep.Image)
+	if image == "" {
+		image = c.parser.parseImage(c.config.Image)
+	}
+	if image == "" {
+		image = bitbucketDefaultImage
+	}
+	job.Image = image
+	job.RunsOn = image
+
+	// Manual steps, and every step of a pipeline that isn't started for
+	// the checkout, only run when asked for
+	trigger := bbStep.Trigger
+	if trigger == "" && stage != nil {
+		trigger = stage.Trigger
+	}
+	if trigger == "manual" || c.manual {
+		job.When = "manual"
+	}
+
+	job.EnvironmentName = bbStep.Deployment
+	if job.EnvironmentName == "" && stage != nil {
+		job.EnvironmentName = stage.Deployment
+	}
+
+	caches, err := c.convertCaches(bbStep.Caches)
+	if err != nil {
+		return nil, err
+	}
+	if len(caches) > 0 {
+		job.Caches = caches
+		job.Cache = caches[0]
+	}
+
+	artifacts, download, err := c.parser.parseArtifacts(bbStep.Artifacts)
+	if err != nil {
+		return nil, err
+	}
+	job.Artifacts = artifacts
+	if !download {
+		job.Dependencies = nil
+	}
+
+	services, err := c.convertServices(bbStep.Services)
+	if err != nil {
+		return nil, err
+	}
+	if len(services) > 0 {
+		job.Services = services
+	}
+
+	job.Steps, err = c.parser.convertScript(bbStep.Script, bbStep.AfterScript)
+	if err != nil {
+		return nil, err
+	}
+
+	return job, nil
+}
+
+// convertCaches returns the caches a step uses, predefined or from
+// definitions. The docker layer cache has no local equivalent.
+func (c *bitbucketConversion) convertCaches(names []string) ([]*types.CacheConfig, error) {
+	var caches []*types.CacheConfig
+	for _, name := range names {
+		var defined interface{}
+		if c.config.Definitions != nil {
+			defined = c.config.Definitions.Caches[name]
+		}
+
+		switch v := defined.(type) {
+		case string:
+			caches = append(caches, &types.CacheConfig{Key: name, Paths: []string{v}})
+		case map[string]interface{}:
+			path, _ := v["path"].(string)
+			if path == "" {
+				return nil, fmt.Errorf("cache '%s' has no path", name)
+			}
+			caches = append(caches, &types.CacheConfig{Key: name, Paths: []string{path}})
+		default:
+			if name == "docker" {
+				continue
+			}
+			path, ok := bitbucketPredefinedCaches[name]
+			if !ok {
+				return nil, fmt.Errorf("undefined cache '%s'", name)
+			}
+			caches = append(caches, &types.CacheConfig{Key: name, Paths: []string{path}})
+		}
+	}
+	return caches, nil
+}
+
+// convertServices returns the services a step uses, from definitions.
+// The docker service only gives steps a Docker daemon, so it is skipped
+// unless defined.
+func (c *bitbucketConversion) convertServices(names []string) (map[string]*types.Service, error) {
+	services := make(map[string]*types.Service)
+	for _, name := range names {
+		var defined *BitbucketService
+		if c.config.Definitions != nil {
+			defined = c.config.Definitions.Services[name]
+		}
+
+		if defined == nil {
+			if name == "docker" {
+				continue
+			}
+			return nil, fmt.Errorf("undefined service '%s'", name)
+		}
+
+		image := c.parser.parseImage(defined.Image)
+		if image == "" {
+			return nil, fmt.Errorf("service '%s' has no image", name)
+		}
+		services[name] = &types.Service{
+			Image: image,
+			Name:  name,
+			Env:   defined.Variables,
+		}
+	}
+	return services, nil
+}
+
+// parseImage returns the name of an image given as a string or as a map
+// with a name and credentials
+func (p *BitbucketParser) parseImage(image interface{}) string {
+	switch v := image.(type) {
+	case string:
+		return v
+	case map[string]interface{}:
+		if name, ok := v["name"].(string); ok {
+			return name
+		}
+	}
+	return ""
+}
+
+// parseArtifacts returns the artifacts of a step, given as a list of
+// paths or as a map with paths and download, and whether the step
+// downloads the artifacts of earlier steps (true unless download: false)
+func (p *BitbucketParser) parseArtifacts(artifacts interface{}) (*types.ArtifactConfig, bool, error) {
+	var paths []interface{}
+	download := true
+
+	switch v := artifacts.(type) {
+	case nil:
+		return nil, true, nil
+	case []interface{}:
+		paths = v
+	case map[string]interface{}:
+		if value, ok := v["download"]; ok {
+			flag, ok := value.(bool)
+			if !ok {
+				return nil, false, fmt.Errorf("artifacts download must be true or false")
+			}
+			download = flag
+		}
+		if list, ok := v["paths"].([]interface{}); ok {
+			paths = list
+		}
+	default:
+		return nil, false, fmt.Errorf("artifacts must be a list of paths or a map")
+	}
+
+	if len(paths) == 0 {
+		return nil, download, nil
+	}
+
+	config := &types.ArtifactConfig{}
+	for _, path := range paths {
+		config.Paths = append(config.Paths, fmt.Sprintf("%v", path))
+	}
+	return config, download, nil
+}
+
+// convertScript converts the script of a step to Steps. Consecutive
+// commands share a step, as they share a shell on Bitbucket; each pipe
+// is a step of its own running the pipe image. after-script runs even
+// when the script fails.
+func (p *BitbucketParser) convertScript(script, afterScript []interface{}) ([]types.Step, error) {
+	var steps []types.Step
+	var commands []string
+
+	flush := func() {
+		if len(commands) == 0 {
+			return
+		}
+		name := "Script"
+		if len(steps) > 0 {
+			name = fmt.Sprintf("Script %d", len(steps)+1)
+		}
+		steps = append(steps, types.Step{
+			Name:   name,
+			Run:    strings.Join(commands, "\n"),
+			Script: commands,
+		})
+		commands = nil
+	}
+
+	for _, entry := range script {
+		switch v := entry.(type) {
+		case string:
+			commands = append(commands, v)
+		case map[string]interface{}:
+			pipe, ok := v["pipe"].(string)
+			if !ok || pipe == "" {
+				return nil, fmt.Errorf("script entries must be commands or pipes")
+			}
+			flush()
+			steps = append(steps, types.Step{
+				Name: "Pipe " + pipe,
+				Uses: "docker://" + p.pipeImage(pipe),
+				With: p.pipeVariables(v["variables"]),
+			})
+		default:
+			return nil, fmt.Errorf("script entries must be commands or pipes")
+		}
+	}
+	flush()
+
+	var after []string
+	for _, entry := range afterScript {
+		if command, ok := entry.(string); ok {
+			after = append(after, command)
+		}
+	}
+	if len(after) > 0 {
+		steps = append(steps, types.Step{
+			Name:          "After Script",
+			Run:           strings.Join(after, "\n"),
+			Script:        after,
+			ContinueOnErr: true,
+			Phase:         types.StepPhaseAfterScript,
+		})
+	}
+
+	return steps, nil
+}
+
+// pipeImage returns the image of a pipe: atlassian/ pipes are published
+// as bitbucketpipelines/ images, others name their image
+func (p *BitbucketParser) pipeImage(pipe string) string {
+	pipe = strings.TrimPrefix(pipe, "docker://")
+	if name, ok := strings.CutPrefix(pipe, "atlassian/"); ok {
+		return "bitbucketpipelines/" + name
+	}
+	return pipe
+}
+
+// pipeVariables returns the variables of a pipe as strings
+func (p *BitbucketParser) pipeVariables(variables interface{}) map[string]string {
+	vars, ok := variables.(map[string]interface{})
+	if !ok || len(vars) == 0 {
+		return nil
+	}
+
+	result := make(map[string]string, len(vars))
+	for k, v := range vars {
+		switch value := v.(type) {
+		case []interface{}:
+			parts := make([]string, len(value))
+			for i, part := range value {
+				parts[i] = fmt.Sprintf("%v", part)
+			}
+			result[k] = strings.Join(parts, " ")
+		default:
+			result[k] = fmt.Sprintf("%v", value)
+		}
+	}
+	return result
+}
+
+// Validate validates the parsed pipeline
+func (p *BitbucketParser) Validate(pipeline *types.Pipeline) error {
+	if pipeline == nil {
+		return fmt.Errorf("pipeline is nil")
+	}
+
+	var errors []string
+
+	if len(pipeline.Jobs) == 0 {
+		errors = append(errors, "no pipelines defined")
+	}
+
+	for _, name := range sortedKeys(pipeline.Jobs) {
+		job := pipeline.Jobs[name]
+
+		if len(job.Steps) == 0 {
+			errors = append(errors, fmt.Sprintf("step '%s' has no script", name))
+		}
+
+		if job.TimeoutMin < 0 {
+			errors = append(errors, fmt.Sprintf("step '%s' has a negative max-time", name))
+		}
+
+		for _, need := range job.NeedNames() {
+			if _, exists := pipeline.Jobs[need]; !exists {
+				errors = append(errors, fmt.Sprintf("step '%s' depends on non-existent step '%s'", name, need))
+			}
+		}
+	}
+
+	if len(errors) > 0 {
+		return fmt.Errorf("validation errors:\n  - %s", strings.Join(errors, "\n  - "))
+	}
+
+	return nil
+}
+
+// GetProviderName returns the name of this parser
+func (p *BitbucketParser) GetProviderName() string {
+	return "bitbucket"
+}
+
+// ParseDirectory parses the bitbucket-pipelines.yml file of a directory
+func (p *BitbucketParser) ParseDirectory(dir string) ([]*types.Pipeline, error) {
+	file := filepath.Join(dir, "bitbucket-pipelines.yml")
+	if _, err := os.Stat(file); os.IsNotExist(err) {
+		return nil, fmt.Errorf("no bitbucket-pipelines.yml found in %s", dir)
+	}
+
+	pipeline, err := p.Parse(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", file, err)
+	}
+
+	return []*types.Pipeline{pipeline}, nil
+}
+
+// sortedKeys returns the keys of a map in order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
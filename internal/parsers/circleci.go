@@ -0,0 +1,1166 @@
+package parsers
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/sanix-darker/git-ci/pkg/types"
+	yaml "gopkg.in/yaml.v3"
+)
+
+// circleciMaxDepth bounds the nesting of commands calling commands
+const circleciMaxDepth = 20
+
+// circleciParameterRef matches << parameters.x >> and
+// << pipeline.parameters.x >>
+var circleciParameterRef = regexp.MustCompile(`<<\s*(pipeline\.)?parameters\.([A-Za-z0-9_-]+)\s*>>`)
+
+// circleciWorkflowKeys are the keys of a workflow job entry that are not
+// parameters of the job
+var circleciWorkflowKeys = map[string]bool{
+	"requires": true, "name": true, "context": true, "filters": true, "type": true,
+	"matrix": true, "pre-steps": true, "post-steps": true, "serial-group": true,
+}
+
+type CircleCIParser struct{}
+
+// NewCircleCIParser creates a new CircleCI parser
+func NewCircleCIParser() *CircleCIParser {
+	return &CircleCIParser{}
+}
+
+// CircleCI structures. Jobs, executors and commands stay raw until their
+// parameters are known, then decode into the typed forms below.
+type CircleCIConfig struct {
+	Version    interface{}                       `yaml:"version"`
+	Parameters map[string]*CircleCIParameter     `yaml:"parameters,omitempty"`
+	Orbs       map[string]interface{}            `yaml:"orbs,omitempty"`
+	Executors  map[string]map[string]interface{} `yaml:"executors,omitempty"`
+	Commands   map[string]map[string]interface{} `yaml:"commands,omitempty"`
+	Jobs       map[string]map[string]interface{} `yaml:"jobs"`
+	Workflows  map[string]interface{}            `yaml:"workflows,omitempty"`
+
+	// Local-only job hints, ignored by CircleCI
+	LocalHints map[string]*types.LocalHints `yaml:"x-git-ci,omitempty"`
+}
+
+type CircleCIParameter struct {
+	Type        string      `yaml:"type,omitempty"`
+	Default     interface{} `yaml:"default,omitempty"`
+	Description string      `yaml:"description,omitempty"`
+}
+
+type CircleCIExecutor struct {
+	Docker        []CircleCIImage   `yaml:"docker,omitempty"`
+	Machine       interface{}       `yaml:"machine,omitempty"`
+	Macos         interface{}       `yaml:"macos,omitempty"`
+	Environment   map[string]string `yaml:"environment,omitempty"`
+	ResourceClass string            `yaml:"resource_class,omitempty"`
+	Shell         string            `yaml:"shell,omitempty"`
+}
+
+type CircleCIImage struct {
+	Image       string            `yaml:"image"`
+	Name        string            `yaml:"name,omitempty"`
+	Entrypoint  interface{}       `yaml:"entrypoint,omitempty"`
+	Command     interface{}       `yaml:"command,omitempty"`
+	User        string            `yaml:"user,omitempty"`
+	Environment map[string]string `yaml:"environment,omitempty"`
+}
+
+type CircleCIJob struct {
+	CircleCIExecutor `yaml:",inline"`
+	Executor         interface{}   `yaml:"executor,omitempty"`
+	Parameters       interface{}   `yaml:"parameters,omitempty"`
+	Steps            []interface{} `yaml:"steps"`
+	Parallelism      int           `yaml:"parallelism,omitempty"`
+	WorkingDirectory string        `yaml:"working_directory,omitempty"`
+}
+
+type CircleCICommand struct {
+	Parameters map[string]*CircleCIParameter `yaml:"parameters,omitempty"`
+	Steps      []interface{}                 `yaml:"steps"`
+}
+
+type CircleCIRun struct {
+	Name             string            `yaml:"name,omitempty"`
+	Command          string            `yaml:"command"`
+	Environment      map[string]string `yaml:"environment,omitempty"`
+	WorkingDirectory string            `yaml:"working_directory,omitempty"`
+	Shell            string            `yaml:"shell,omitempty"`
+	When             string            `yaml:"when,omitempty"`
+	Background       bool              `yaml:"background,omitempty"`
+}
+
+// Parse parses a .circleci/config.yml file
+func (p *CircleCIParser) Parse(ciFilePath string) (*types.Pipeline, error) {
+	// Check if file exists
+	if _, err := os.Stat(ciFilePath); os.IsNotExist(err) {
+		return nil, fmt.Errorf("config file not found: %s", ciFilePath)
+	}
+
+	// Read file content
+	data, err := os.ReadFile(ciFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	// Handle empty files
+	if len(data) == 0 {
+		return nil, fmt.Errorf("config file is empty: %s", ciFilePath)
+	}
+
+	// Pipeline parameters apply to the whole file, so they are replaced
+	// by their defaults before decoding the rest
+	var config CircleCIConfig
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse YAML: %w", err)
+	}
+	if len(config.Parameters) > 0 {
+		var raw interface{}
+		if err := yaml.Unmarshal(data, &raw); err != nil {
+			return nil, fmt.Errorf("failed to parse YAML: %w", err)
+		}
+		defaults := make(map[string]interface{})
+		for name, param := range config.Parameters {
+			if param != nil {
+				defaults[name] = param.Default
+			}
+		}
+		config = CircleCIConfig{}
+		if err := decodeRaw(substituteParameters(raw, defaults, true), &config); err != nil {
+			return nil, fmt.Errorf("failed to parse YAML: %w", err)
+		}
+	}
+
+	// Convert to generic Pipeline
+	pipeline, err := p.convertToPipeline(&config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert config: %w", err)
+	}
+
+	// Attach local-only hints
+	if err := attachLocalHints(pipeline, config.LocalHints); err != nil {
+		return nil, fmt.Errorf("config validation failed: %w", err)
+	}
+
+	// Validate the pipeline
+	if err := p.Validate(pipeline); err != nil {
+		return nil, fmt.Errorf("config validation failed: %w", err)
+	}
+
+	return pipeline, nil
+}
+
+// decodeRaw decodes a raw YAML value into out
+func decodeRaw(raw interface{}, out interface{}) error {
+	data, err := yaml.Marshal(raw)
+	if err != nil {
+		return err
+	}
+	return yaml.Unmarshal(data, out)
+}
+
+// substituteParameters replaces << parameters.x >> references (or the
+// << pipeline.parameters.x >> ones) in a raw YAML value. A string that is
+// a single reference takes the value as is, so a `steps` parameter can be
+// spliced into a list of steps.
+func substituteParameters(raw interface{}, params map[string]interface{}, pipeline bool) interface{} {
+	switch v := raw.(type) {
+	case string:
+		matches := circleciParameterRef.FindAllStringSubmatchIndex(v, -1)
+		if len(matches) == 0 {
+			return v
+		}
+		lookup := func(match []int) (interface{}, bool) {
+			if (match[3] > match[2]) != pipeline {
+				return nil, false
+			}
+			value, ok := params[v[match[4]:match[5]]]
+			return value, ok
+		}
+		if len(matches) == 1 && matches[0][0] == 0 && matches[0][1] == len(v) {
+			if value, ok := lookup(matches[0]); ok {
+				return value
+			}
+			return v
+		}
+
+		var result strings.Builder
+		last := 0
+		for _, match := range matches {
+			result.WriteString(v[last:match[0]])
+			if value, ok := lookup(match); ok {
+				if value != nil {
+					result.WriteString(fmt.Sprintf("%v", value))
+				}
+			} else {
+				result.WriteString(v[match[0]:match[1]])
+			}
+			last = match[1]
+		}
+		result.WriteString(v[last:])
+		return result.String()
+	case []interface{}:
+		result := make([]interface{}, 0, len(v))
+		for _, item := range v {
+			value := substituteParameters(item, params, pipeline)
+			// A steps parameter used as a step expands to its steps
+			if steps, ok := value.([]interface{}); ok && isParameterRef(item) {
+				result = append(result, steps...)
+				continue
+			}
+			result = append(result, value)
+		}
+		return result
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			result[key] = substituteParameters(item, params, pipeline)
+		}
+		return result
+	}
+	return raw
+}
+
+// isParameterRef reports whether a raw value is a single parameter
+// reference
+func isParameterRef(raw interface{}) bool {
+	s, ok := raw.(string)
+	if !ok {
+		return false
+	}
+	match := circleciParameterRef.FindStringIndex(s)
+	return match != nil && match[0] == 0 && match[1] == len(s)
+}
+
+// parameterValues returns the values of declared parameters: the given
+// arguments, else their defaults
+func parameterValues(declared map[string]*CircleCIParameter, args map[string]interface{}) map[string]interface{} {
+	values := make(map[string]interface{}, len(declared))
+	for name, param := range declared {
+		if param != nil {
+			values[name] = param.Default
+		}
+	}
+	for name, value := range args {
+		values[name] = value
+	}
+	return values
+}
+
+// circleciRef splits a step or workflow job reference, given as a name
+// or as a single-key map of arguments, into its name and arguments
+func circleciRef(raw interface{}) (string, map[string]interface{}, error) {
+	switch v := raw.(type) {
+	case string:
+		return v, nil, nil
+	case map[string]interface{}:
+		if len(v) != 1 {
+			return "", nil, fmt.Errorf("expected a single key, got %d", len(v))
+		}
+		for name, args := range v {
+			switch a := args.(type) {
+			case nil:
+				return name, nil, nil
+			case map[string]interface{}:
+				return name, a, nil
+			default:
+				// Short forms, e.g. `run: make`
+				return name, map[string]interface{}{"": a}, nil
+			}
+		}
+	}
+	return "", nil, fmt.Errorf("invalid reference %v", raw)
+}
+
+// circleciInstance is a job of a workflow with its arguments
+type circleciInstance struct {
+	workflow string
+	id       string // Job definition
+	name     string // Name within the workflow
+	args     map[string]interface{}
+	requires []string
+	approval bool
+	pre      []interface{}
+	post     []interface{}
+}
+
+// convertToPipeline converts the config to a generic Pipeline. Each
+// workflow is a stage and each of its jobs a job needing the jobs it
+// requires; approval jobs are treated as granted. Without workflows
+// every job runs on its own.
+func (p *CircleCIParser) convertToPipeline(config *CircleCIConfig) (*types.Pipeline, error) {
+	pipeline := &types.Pipeline{
+		Name:        "CircleCI Pipeline",
+		Description: fmt.Sprintf("CircleCI config version %v", config.Version),
+		Provider:    "circleci",
+		Jobs:        make(map[string]*types.Job),
+	}
+
+	instances, err := p.workflowInstances(config)
+	if err != nil {
+		return nil, err
+	}
+
+	// Jobs named alike in several workflows are prefixed by the workflow
+	seen := make(map[string]string)
+	collision := make(map[string]bool)
+	for _, inst := range instances {
+		if wf, ok := seen[inst.name]; ok && wf != inst.workflow {
+			collision[inst.name] = true
+		}
+		seen[inst.name] = inst.workflow
+	}
+
+	// Names of the jobs of each workflow, approval jobs standing for the
+	// jobs they require
+	keys := make(map[string]map[string][]string)
+	approvals := make(map[string]map[string][]string)
+	var converted []*circleciInstance
+	for _, inst := range instances {
+		if keys[inst.workflow] == nil {
+			keys[inst.workflow] = make(map[string][]string)
+			approvals[inst.workflow] = make(map[string][]string)
+			pipeline.Stages = append(pipeline.Stages, inst.workflow)
+		}
+		if inst.approval {
+			approvals[inst.workflow][inst.name] = inst.requires
+			continue
+		}
+
+		key := inst.name
+		if collision[inst.name] {
+			key = inst.workflow + "/" + inst.name
+		}
+		if _, exists := pipeline.Jobs[key]; exists {
+			return nil, fmt.Errorf("workflow '%s': duplicate job name '%s'", inst.workflow, inst.name)
+		}
+
+		job, err := p.convertJob(config, key, inst)
+		if err != nil {
+			return nil, fmt.Errorf("job '%s': %w", key, err)
+		}
+		pipeline.Jobs[key] = job
+		keys[inst.workflow][inst.name] = append(keys[inst.workflow][inst.name], key)
+		converted = append(converted, inst)
+	}
+
+	// Requires name jobs of the same workflow; once all are known, resolve
+	// them, matrix aliases meaning every variant
+	for _, inst := range converted {
+		key := inst.name
+		if collision[inst.name] {
+			key = inst.workflow + "/" + inst.name
+		}
+		job := pipeline.Jobs[key]
+
+		var needs []string
+		for _, req := range p.resolveApprovals(inst.requires, approvals[inst.workflow], 0) {
+			if resolved, ok := keys[inst.workflow][req]; ok {
+				needs = append(needs, resolved...)
+			} else {
+				// Kept for validation to report
+				needs = append(needs, req)
+			}
+		}
+		job.Requires = inst.requires
+		job.Needs = types.NeedsOf(needs...)
+	}
+
+	// Jobs attaching the workspace receive what upstream jobs persisted
+	for _, job := range pipeline.Jobs {
+		if job.DependenciesSet {
+			job.Dependencies = p.persistingAncestors(pipeline, job)
+		}
+	}
+
+	return pipeline, nil
+}
+
+// resolveApprovals replaces approval jobs in requires by the jobs they
+// require
+func (p *CircleCIParser) resolveApprovals(requires []string, approvals map[string][]string, depth int) []string {
+	if depth > circleciMaxDepth {
+		return requires
+	}
+
+	var result []string
+	for _, req := range requires {
+		if upstream, ok := approvals[req]; ok {
+			result = append(result, p.resolveApprovals(upstream, approvals, depth+1)...)
+		} else {
+			result = append(result, req)
+		}
+	}
+	return result
+}
+
+// persistingAncestors returns the upstream jobs of a job that persist
+// files to the workspace
+func (p *CircleCIParser) persistingAncestors(pipeline *types.Pipeline, job *types.Job) []string {
+	seen := make(map[string]bool)
+	var result []string
+
+	var visit func(j *types.Job)
+	visit = func(j *types.Job) {
+		for _, need := range j.NeedNames() {
+			upstream, ok := pipeline.Jobs[need]
+			if !ok || seen[need] {
+				continue
+			}
+			seen[need] = true
+			if upstream.Artifacts != nil {
+				result = append(result, need)
+			}
+			visit(upstream)
+		}
+	}
+	visit(job)
+
+	sort.Strings(result)
+	return result
+}
+
+// workflowInstances returns the jobs of every workflow, workflows by
+// name and jobs in their order, with matrix jobs expanded
+func (p *CircleCIParser) workflowInstances(config *CircleCIConfig) ([]*circleciInstance, error) {
+	var workflows []string
+	for name, wf := range config.Workflows {
+		if _, ok := wf.(map[string]interface{}); ok {
+			workflows = append(workflows, name)
+		}
+	}
+	sort.Strings(workflows)
+
+	var instances []*circleciInstance
+	if len(workflows) == 0 {
+		// No workflow: each job stands alone
+		for _, id := range sortedKeys(config.Jobs) {
+			instances = append(instances, &circleciInstance{workflow: "jobs", id: id, name: id})
+		}
+		return instances, nil
+	}
+
+	for _, wfName := range workflows {
+		wf := config.Workflows[wfName].(map[string]interface{})
+		entries, _ := wf["jobs"].([]interface{})
+		if len(entries) == 0 {
+			return nil, fmt.Errorf("workflow '%s' has no jobs", wfName)
+		}
+
+		for _, entry := range entries {
+			id, args, err := circleciRef(entry)
+			if err != nil {
+				return nil, fmt.Errorf("workflow '%s': %w", wfName, err)
+			}
+			expanded, err := p.expandInstance(wfName, id, args)
+			if err != nil {
+				return nil, fmt.Errorf("workflow '%s' job '%s': %w", wfName, id, err)
+			}
+			instances = append(instances, expanded...)
+		}
+	}
+
+	return instances, nil
+}
+
+// expandInstance returns the jobs of a workflow entry: one, or one per
+// combination of its matrix, named <name>-<value>-<value> with the values
+// in parameter order
+func (p *CircleCIParser) expandInstance(workflow, id string, args map[string]interface{}) ([]*circleciInstance, error) {
+	base := &circleciInstance{workflow: workflow, id: id, name: id, args: make(map[string]interface{})}
+
+	for key, value := range args {
+		switch key {
+		case "name":
+			base.name = fmt.Sprintf("%v", value)
+		case "requires":
+			list, ok := value.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("requires must be a list")
+			}
+			for _, req := range list {
+				// Entries may be a name or a map of names to statuses
+				if m, ok := req.(map[string]interface{}); ok {
+					for _, name := range sortedKeys(m) {
+						base.requires = append(base.requires, name)
+					}
+				} else {
+					base.requires = append(base.requires, fmt.Sprintf("%v", req))
+				}
+			}
+		case "type":
+			base.approval = value == "approval"
+		case "pre-steps":
+			base.pre, _ = value.([]interface{})
+		case "post-steps":
+			base.post, _ = value.([]interface{})
+		default:
+			if !circleciWorkflowKeys[key] {
+				base.args[key] = value
+			}
+		}
+	}
+
+	matrix, ok := args["matrix"].(map[string]interface{})
+	if !ok {
+		return []*circleciInstance{base}, nil
+	}
+
+	params, _ := matrix["parameters"].(map[string]interface{})
+	if len(params) == 0 {
+		return nil, fmt.Errorf("matrix has no parameters")
+	}
+	// Variants are named after the job, or the alias requires refer to
+	if alias, ok := matrix["alias"].(string); ok && alias != "" {
+		base.name = alias
+	}
+
+	names := sortedKeys(params)
+	combinations := []map[string]interface{}{{}}
+	for _, name := range names {
+		values, ok := params[name].([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("matrix parameter '%s' must be a list", name)
+		}
+		var next []map[string]interface{}
+		for _, combination := range combinations {
+			for _, value := range values {
+				c := make(map[string]interface{}, len(combination)+1)
+				for k, v := range combination {
+					c[k] = v
+				}
+				c[name] = value
+				next = append(next, c)
+			}
+		}
+		combinations = next
+	}
+
+	excluded, _ := matrix["exclude"].([]interface{})
+	var instances []*circleciInstance
+	for _, combination := range combinations {
+		skip := false
+		for _, ex := range excluded {
+			if reflect.DeepEqual(normalizeMatrixValues(ex), normalizeMatrixValues(combination)) {
+				skip = true
+				break
+			}
+		}
+		if skip {
+			continue
+		}
+
+		inst := *base
+		inst.args = make(map[string]interface{}, len(base.args)+len(combination))
+		for k, v := range base.args {
+			inst.args[k] = v
+		}
+		parts := []string{base.name}
+		for _, name := range names {
+			inst.args[name] = combination[name]
+			parts = append(parts, fmt.Sprintf("%v", combination[name]))
+		}
+		inst.name = strings.Join(parts, "-")
+		instances = append(instances, &inst)
+	}
+
+	// Requires on the alias mean every variant
+	alias := &circleciInstance{workflow: workflow, name: base.name, approval: true}
+	for _, inst := range instances {
+		alias.requires = append(alias.requires, inst.name)
+	}
+	return append(instances, alias), nil
+}
+
+// normalizeMatrixValues returns a matrix combination with values as
+// strings, for exclusions to compare
+func normalizeMatrixValues(raw interface{}) map[string]string {
+	m, _ := raw.(map[string]interface{})
+	result := make(map[string]string, len(m))
+	for k, v := range m {
+		result[k] = fmt.Sprintf("%v", v)
+	}
+	return result
+}
+
+// circleciJobConversion holds the state of converting one job
+type circleciJobConversion struct {
+	parser *CircleCIParser
+	config *CircleCIConfig
+	job    *types.Job
+}
+
+// convertJob converts a workflow job to a generic Job
+func (p *CircleCIParser) convertJob(config *CircleCIConfig, key string, inst *circleciInstance) (*types.Job, error) {
+	job := &types.Job{
+		Name:  key,
+		Stage: inst.workflow,
+	}
+
+	raw, ok := config.Jobs[inst.id]
+	if !ok {
+		// A job of an orb can only be referenced
+		if strings.Contains(inst.id, "/") {
+			job.RunsOn = "ubuntu-latest"
+			job.Executor = inst.id
+			job.Steps = []types.Step{p.orbStep(config, inst.id, inst.args)}
+			return job, nil
+		}
+		return nil, fmt.Errorf("undefined job '%s'", inst.id)
+	}
+
+	// Parameters of the job: its declared ones with the entry arguments
+	var declared map[string]*CircleCIParameter
+	if err := decodeRaw(raw["parameters"], &declared); err != nil {
+		return nil, fmt.Errorf("invalid parameters: %w", err)
+	}
+	for name := range inst.args {
+		if _, ok := declared[name]; !ok {
+			return nil, fmt.Errorf("unknown parameter '%s'", name)
+		}
+	}
+	params := parameterValues(declared, inst.args)
+
+	var def CircleCIJob
+	if err := decodeRaw(substituteParameters(raw, params, false), &def); err != nil {
+		return nil, fmt.Errorf("invalid job: %w", err)
+	}
+
+	executor := def.CircleCIExecutor
+	if def.Executor != nil {
+		// Executors are a name, or a map naming one with its arguments
+		var name string
+		var args map[string]interface{}
+		switch v := def.Executor.(type) {
+		case string:
+			name = v
+		case map[string]interface{}:
+			name, _ = v["name"].(string)
+			args = v
+		}
+		if name == "" {
+			return nil, fmt.Errorf("invalid executor %v", def.Executor)
+		}
+		job.Executor = name
+
+		resolved, ok, err := p.resolveExecutor(config, name, args)
+		if err != nil {
+			return nil, fmt.Errorf("executor '%s': %w", name, err)
+		}
+		if ok {
+			// Job keys override those of the executor
+			if len(def.Docker) == 0 && def.Machine == nil && def.Macos == nil {
+				executor.Docker, executor.Machine, executor.Macos = resolved.Docker, resolved.Machine, resolved.Macos
+			}
+			executor.Environment = mergeEnv(resolved.Environment, executor.Environment)
+			if executor.ResourceClass == "" {
+				executor.ResourceClass = resolved.ResourceClass
+			}
+			if executor.Shell == "" {
+				executor.Shell = resolved.Shell
+			}
+		}
+	}
+
+	p.applyExecutor(job, &executor)
+
+	conv := &circleciJobConversion{parser: p, config: config, job: job}
+	steps := append(append(append([]interface{}{}, inst.pre...), def.Steps...), inst.post...)
+	converted, err := conv.convertSteps(steps, params, executor.Shell, 0)
+	if err != nil {
+		return nil, err
+	}
+	job.Steps = converted
+
+	return job, nil
+}
+
+// resolveExecutor returns a named executor with its parameters applied.
+// Executors of orbs can't be resolved and report false.
+func (p *CircleCIParser) resolveExecutor(config *CircleCIConfig, name string, args map[string]interface{}) (*CircleCIExecutor, bool, error) {
+	raw, ok := config.Executors[name]
+	if !ok {
+		if orb, exec, found := strings.Cut(name, "/"); found {
+			if inline, ok := config.Orbs[orb].(map[string]interface{}); ok {
+				executors, _ := inline["executors"].(map[string]interface{})
+				raw, ok = executors[exec].(map[string]interface{})
+				if ok {
+					return p.decodeExecutor(raw, args)
+				}
+			}
+			return nil, false, nil
+		}
+		return nil, false, fmt.Errorf("undefined executor")
+	}
+	return p.decodeExecutor(raw, args)
+}
+
+// decodeExecutor decodes a raw executor with its parameters applied
+func (p *CircleCIParser) decodeExecutor(raw map[string]interface{}, args map[string]interface{}) (*CircleCIExecutor, bool, error) {
+	var declared map[string]*CircleCIParameter
+	if err := decodeRaw(raw["parameters"], &declared); err != nil {
+		return nil, false, fmt.Errorf("invalid parameters: %w", err)
+	}
+	values := make(map[string]interface{})
+	for name, value := range args {
+		if name != "name" {
+			values[name] = value
+		}
+	}
+
+	var executor CircleCIExecutor
+	if err := decodeRaw(substituteParameters(raw, parameterValues(declared, values), false), &executor); err != nil {
+		return nil, false, fmt.Errorf("invalid executor: %w", err)
+	}
+	return &executor, true, nil
+}
+
+// applyExecutor sets the image, services and environment of a job from
+// its executor. The first docker image runs the steps, the others are
+// services; machine and macos executors run on a matching runner.
+func (p *CircleCIParser) applyExecutor(job *types.Job, executor *CircleCIExecutor) {
+	job.ResourceClass = executor.ResourceClass
+	job.Environment = executor.Environment
+
+	switch {
+	case len(executor.Docker) > 0:
+		primary := executor.Docker[0]
+		job.Image = primary.Image
+		job.RunsOn = primary.Image
+		if job.Executor == "" {
+			job.Executor = "docker"
+		}
+		job.Environment = mergeEnv(primary.Environment, job.Environment)
+		if entrypoint := stringList(primary.Entrypoint); len(entrypoint) > 0 || primary.User != "" {
+			job.Container = &types.Container{Image: primary.Image, Entrypoint: entrypoint, User: primary.User}
+		}
+
+		for i, image := range executor.Docker[1:] {
+			name := image.Name
+			if name == "" {
+				name = serviceName(image.Image, i+1)
+			}
+			if job.Services == nil {
+				job.Services = make(map[string]*types.Service)
+			}
+			job.Services[name] = &types.Service{
+				Image:      image.Image,
+				Name:       name,
+				Alias:      image.Name,
+				Env:        image.Environment,
+				Command:    stringList(image.Command),
+				Entrypoint: stringList(image.Entrypoint),
+			}
+		}
+	case executor.Machine != nil:
+		job.RunsOn = "ubuntu-latest"
+		if m, ok := executor.Machine.(map[string]interface{}); ok {
+			if image, ok := m["image"].(string); ok && image != "" {
+				job.RunsOn = image
+			}
+		}
+		if job.Executor == "" {
+			job.Executor = "machine"
+		}
+	case executor.Macos != nil:
+		job.RunsOn = "macos"
+		if job.Executor == "" {
+			job.Executor = "macos"
+		}
+	default:
+		// Orb executors can't be resolved locally
+		job.RunsOn = "ubuntu-latest"
+	}
+
+	if len(job.Environment) == 0 {
+		job.Environment = nil
+	}
+}
+
+// serviceName returns a service name from its image: its base name
+// without tag, or service-N
+func serviceName(image string, index int) string {
+	name := path.Base(image)
+	if i := strings.IndexAny(name, ":@"); i >= 0 {
+		name = name[:i]
+	}
+	if name == "" || name == "." {
+		return fmt.Sprintf("service-%d", index)
+	}
+	return name
+}
+
+// mergeEnv returns base overridden by override
+func mergeEnv(base, override map[string]string) map[string]string {
+	if len(base) == 0 {
+		return override
+	}
+	result := make(map[string]string, len(base)+len(override))
+	for k, v := range base {
+		result[k] = v
+	}
+	for k, v := range override {
+		result[k] = v
+	}
+	return result
+}
+
+// stringList returns a string or list of strings as a list
+func stringList(raw interface{}) []string {
+	switch v := raw.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		result := make([]string, len(v))
+		for i, item := range v {
+			result[i] = fmt.Sprintf("%v", item)
+		}
+		return result
+	}
+	return nil
+}
+
+// convertSteps converts CircleCI steps to generic Steps, expanding
+// commands with their parameters and when/unless blocks. params are the
+// parameters of the job or command the steps belong to.
+func (c *circleciJobConversion) convertSteps(raw []interface{}, params map[string]interface{}, shell string, depth int) ([]types.Step, error) {
+	if depth > circleciMaxDepth {
+		return nil, fmt.Errorf("commands nested more than %d levels", circleciMaxDepth)
+	}
+
+	var steps []types.Step
+	for _, entry := range substituteParameters(raw, params, false).([]interface{}) {
+		name, args, err := circleciRef(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid step: %w", err)
+		}
+
+		switch name {
+		case "run":
+			step, err := c.runStep(args, shell)
+			if err != nil {
+				return nil, err
+			}
+			steps = append(steps, step)
+
+		case "when", "unless":
+			if truthy(args["condition"]) == (name == "when") {
+				inner, _ := args["steps"].([]interface{})
+				converted, err := c.convertSteps(inner, params, shell, depth+1)
+				if err != nil {
+					return nil, err
+				}
+				steps = append(steps, converted...)
+			}
+
+		case "checkout":
+			// The workspace already is the checkout
+			steps = append(steps, types.Step{Name: "Checkout code", Command: name})
+
+		case "save_cache", "restore_cache":
+			c.addCache(name, args)
+			steps = append(steps, types.Step{Name: builtinStepName(name, args), Command: name})
+
+		case "persist_to_workspace":
+			c.persist(args)
+			steps = append(steps, types.Step{Name: builtinStepName(name, args), Command: name})
+
+		case "attach_workspace":
+			c.job.DependenciesSet = true
+			steps = append(steps, types.Step{Name: builtinStepName(name, args), Command: name})
+
+		case "setup_remote_docker", "add_ssh_keys", "store_artifacts", "store_test_results":
+			steps = append(steps, types.Step{Name: builtinStepName(name, args), Command: name})
+
+		default:
+			command, found, err := c.command(name)
+			if err != nil {
+				return nil, err
+			}
+			if !found {
+				if !strings.Contains(name, "/") {
+					return nil, fmt.Errorf("unknown step or command '%s'", name)
+				}
+				steps = append(steps, c.parser.orbStep(c.config, name, args))
+				continue
+			}
+
+			for arg := range args {
+				if _, ok := command.Parameters[arg]; !ok {
+					return nil, fmt.Errorf("command '%s' has no parameter '%s'", name, arg)
+				}
+			}
+			converted, err := c.convertSteps(command.Steps, parameterValues(command.Parameters, args), shell, depth+1)
+			if err != nil {
+				return nil, fmt.Errorf("command '%s': %w", name, err)
+			}
+			steps = append(steps, converted...)
+		}
+	}
+
+	return steps, nil
+}
+
+// command returns a command of the config or of an inline orb
+func (c *circleciJobConversion) command(name string) (*CircleCICommand, bool, error) {
+	raw, ok := c.config.Commands[name]
+	if !ok {
+		orb, cmd, found := strings.Cut(name, "/")
+		if !found {
+			return nil, false, nil
+		}
+		inline, ok := c.config.Orbs[orb].(map[string]interface{})
+		if !ok {
+			return nil, false, nil
+		}
+		commands, _ := inline["commands"].(map[string]interface{})
+		if raw, ok = commands[cmd].(map[string]interface{}); !ok {
+			return nil, false, nil
+		}
+	}
+
+	// Parameters are substituted once the arguments are known, so the
+	// steps stay raw here
+	command := &CircleCICommand{}
+	if err := decodeRaw(raw["parameters"], &command.Parameters); err != nil {
+		return nil, false, fmt.Errorf("command '%s': invalid parameters: %w", name, err)
+	}
+	command.Steps, _ = raw["steps"].([]interface{})
+	return command, true, nil
+}
+
+// runStep converts a run step, given as a command or a map
+func (c *circleciJobConversion) runStep(args map[string]interface{}, shell string) (types.Step, error) {
+	var run CircleCIRun
+	if command, ok := args[""]; ok {
+		run.Command = fmt.Sprintf("%v", command)
+	} else if err := decodeRaw(args, &run); err != nil {
+		return types.Step{}, fmt.Errorf("invalid run step: %w", err)
+	}
+	if strings.TrimSpace(run.Command) == "" {
+		return types.Step{}, fmt.Errorf("run step has no command")
+	}
+
+	name := run.Name
+	if name == "" {
+		name = strings.TrimSpace(strings.SplitN(strings.TrimSpace(run.Command), "\n", 2)[0])
+		if len(name) > 50 {
+			name = name[:47] + "..."
+		}
+	}
+
+	step := types.Step{
+		Name:       name,
+		Run:        run.Command,
+		Env:        run.Environment,
+		WorkingDir: run.WorkingDirectory,
+		When:       run.When,
+		Background: run.Background,
+		Type:       types.StepTypeCommand,
+	}
+	if run.Shell != "" {
+		step.Shell = run.Shell
+	} else if shell != "" {
+		step.Shell = shell
+	}
+	return step, nil
+}
+
+// addCache records the cache a save_cache or restore_cache step uses. A
+// restore names the keys to try, a save the key and paths; both share a
+// cache when the saved key is one of those restored.
+func (c *circleciJobConversion) addCache(name string, args map[string]interface{}) {
+	var keys []string
+	if key, ok := args["key"].(string); ok {
+		keys = append(keys, key)
+	}
+	keys = append(keys, stringList(args["keys"])...)
+	if len(keys) == 0 {
+		return
+	}
+
+	for _, cache := range c.job.Caches {
+		if cache.Key == keys[0] || containsString(cache.Fallback, keys[0]) {
+			if name == "save_cache" {
+				cache.Paths = stringList(args["paths"])
+			}
+			return
+		}
+	}
+
+	cache := &types.CacheConfig{Key: keys[0], Fallback: keys[1:]}
+	if name == "save_cache" {
+		cache.Paths = stringList(args["paths"])
+	}
+	c.job.Caches = append(c.job.Caches, cache)
+	c.job.Cache = c.job.Caches[0]
+}
+
+// persist records the paths a persist_to_workspace step shares with
+// downstream jobs, relative to the workspace
+func (c *circleciJobConversion) persist(args map[string]interface{}) {
+	root, _ := args["root"].(string)
+	if c.job.Artifacts == nil {
+		c.job.Artifacts = &types.ArtifactConfig{}
+	}
+	for _, p := range stringList(args["paths"]) {
+		if root != "" && root != "." && !strings.HasPrefix(root, "/") && !strings.HasPrefix(root, "~") {
+			p = path.Join(root, p)
+		}
+		c.job.Artifacts.Paths = append(c.job.Artifacts.Paths, p)
+	}
+}
+
+// orbStep returns a step running a command (or job) of an orb, which
+// only CircleCI can resolve
+func (p *CircleCIParser) orbStep(config *CircleCIConfig, name string, args map[string]interface{}) types.Step {
+	step := types.Step{
+		Name: name,
+		Uses: name,
+		Type: types.StepTypeOrb,
+	}
+	if orb, _, found := strings.Cut(name, "/"); found {
+		if ref, ok := config.Orbs[orb].(string); ok {
+			step.Name = fmt.Sprintf("%s (%s)", name, ref)
+		}
+	}
+	if len(args) > 0 {
+		step.Parameters = make(map[string]string, len(args))
+		for k, v := range args {
+			step.Parameters[k] = fmt.Sprintf("%v", v)
+		}
+	}
+	return step
+}
+
+// builtinStepName returns the name of a built-in step: its name key, or
+// the step capitalized, with spaces for underscores
+func builtinStepName(name string, args map[string]interface{}) string {
+	if n, ok := args["name"].(string); ok && n != "" {
+		return n
+	}
+	return strings.ToUpper(name[:1]) + strings.ReplaceAll(name[1:], "_", " ")
+}
+
+// truthy evaluates a when/unless condition: a value, or an equal, not,
+// and or or expression
+func truthy(condition interface{}) bool {
+	switch v := condition.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case string:
+		return v != "" && v != "false"
+	case int:
+		return v != 0
+	case float64:
+		return v != 0
+	case []interface{}:
+		return len(v) > 0
+	case map[string]interface{}:
+		if operands, ok := v["equal"].([]interface{}); ok {
+			for _, operand := range operands[min(1, len(operands)):] {
+				if fmt.Sprintf("%v", operand) != fmt.Sprintf("%v", operands[0]) {
+					return false
+				}
+			}
+			return true
+		}
+		if operand, ok := v["not"]; ok {
+			return !truthy(operand)
+		}
+		if operands, ok := v["and"].([]interface{}); ok {
+			for _, operand := range operands {
+				if !truthy(operand) {
+					return false
+				}
+			}
+			return true
+		}
+		if operands, ok := v["or"].([]interface{}); ok {
+			for _, operand := range operands {
+				if truthy(operand) {
+					return true
+				}
+			}
+			return false
+		}
+		return len(v) > 0
+	}
+	return true
+}
+
+// containsString reports whether list holds s
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate validates the parsed pipeline
+func (p *CircleCIParser) Validate(pipeline *types.Pipeline) error {
+	if pipeline == nil {
+		return fmt.Errorf("pipeline is nil")
+	}
+
+	var errors []string
+
+	if len(pipeline.Jobs) == 0 {
+		errors = append(errors, "no jobs defined in config")
+	}
+
+	for _, name := range sortedKeys(pipeline.Jobs) {
+		job := pipeline.Jobs[name]
+
+		if len(job.Steps) == 0 {
+			errors = append(errors, fmt.Sprintf("job '%s' has no steps", name))
+		}
+
+		for _, need := range job.NeedNames() {
+			if _, exists := pipeline.Jobs[need]; !exists {
+				errors = append(errors, fmt.Sprintf("job '%s' requires non-existent job '%s'", name, need))
+			}
+		}
+	}
+
+	if len(errors) > 0 {
+		return fmt.Errorf("validation errors:\n  - %s", strings.Join(errors, "\n  - "))
+	}
+
+	return nil
+}
+
+// GetProviderName returns the name of this parser
+func (p *CircleCIParser) GetProviderName() string {
+	return "circleci"
+}
+
+// ParseDirectory parses the .circleci/config.yml file of a directory
+func (p *CircleCIParser) ParseDirectory(dir string) ([]*types.Pipeline, error) {
+	for _, name := range []string{"config.yml", "config.yaml"} {
+		file := filepath.Join(dir, ".circleci", name)
+		if _, err := os.Stat(file); err != nil {
+			continue
+		}
+
+		pipeline, err := p.Parse(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", file, err)
+		}
+		return []*types.Pipeline{pipeline}, nil
+	}
+
+	return nil, fmt.Errorf("no CircleCI config found in %s", filepath.Join(dir, ".circleci"))
+}
@@ -0,0 +1,107 @@
+package parsers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// durationUnits maps the unit words of GitLab durations (timeout,
+// artifacts:expire_in, start_in) to their length. Months and years are
+// 30 and 365 days, as in GitLab.
+var durationUnits = map[string]time.Duration{
+	"s": time.Second, "sec": time.Second, "secs": time.Second, "second": time.Second, "seconds": time.Second,
+	"m": time.Minute, "min": time.Minute, "mins": time.Minute, "minute": time.Minute, "minutes": time.Minute,
+	"h": time.Hour, "hr": time.Hour, "hrs": time.Hour, "hour": time.Hour, "hours": time.Hour,
+	"d": 24 * time.Hour, "day": 24 * time.Hour, "days": 24 * time.Hour,
+	"w": 7 * 24 * time.Hour, "wk": 7 * 24 * time.Hour, "week": 7 * 24 * time.Hour, "weeks": 7 * 24 * time.Hour,
+	"mo": 30 * 24 * time.Hour, "month": 30 * 24 * time.Hour, "months": 30 * 24 * time.Hour,
+	"y": 365 * 24 * time.Hour, "yr": 365 * 24 * time.Hour, "year": 365 * 24 * time.Hour, "years": 365 * 24 * time.Hour,
+}
+
+// ParseDuration parses a GitLab human-readable duration: "30 minutes",
+// "1h 30m", "3 hours 30 minutes", "1 day and 2 hours", "1.5h", "01:30:00"
+// or a bare number of seconds. All components are added up. Job timeouts
+// read a bare number as minutes instead (parseTimeout).
+func ParseDuration(value string) (time.Duration, error) {
+	s := strings.ToLower(strings.TrimSpace(value))
+	if s == "" {
+		return 0, fmt.Errorf("empty duration")
+	}
+
+	if strings.Contains(s, ":") {
+		return parseClockDuration(s)
+	}
+
+	// A bare number counts seconds
+	if n, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.Duration(n * float64(time.Second)), nil
+	}
+
+	var total time.Duration
+	components := 0
+	for i := 0; i < len(s); {
+		r := rune(s[i])
+		switch {
+		case unicode.IsSpace(r) || r == ',':
+			i++
+			continue
+		case strings.HasPrefix(s[i:], "and") && (i+3 == len(s) || !unicode.IsLetter(rune(s[i+3]))):
+			i += 3
+			continue
+		case !unicode.IsDigit(r) && r != '.':
+			return 0, fmt.Errorf("invalid duration '%s'", value)
+		}
+
+		// Number
+		start := i
+		for i < len(s) && (unicode.IsDigit(rune(s[i])) || s[i] == '.') {
+			i++
+		}
+		n, err := strconv.ParseFloat(s[start:i], 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration '%s'", value)
+		}
+
+		// Unit, possibly separated by spaces
+		for i < len(s) && s[i] == ' ' {
+			i++
+		}
+		start = i
+		for i < len(s) && unicode.IsLetter(rune(s[i])) {
+			i++
+		}
+		unit, ok := durationUnits[s[start:i]]
+		if !ok {
+			return 0, fmt.Errorf("invalid duration '%s': unknown unit '%s'", value, s[start:i])
+		}
+
+		total += time.Duration(n * float64(unit))
+		components++
+	}
+
+	if components == 0 {
+		return 0, fmt.Errorf("invalid duration '%s'", value)
+	}
+	return total, nil
+}
+
+// parseClockDuration parses "mm:ss" and "hh:mm:ss"
+func parseClockDuration(s string) (time.Duration, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) > 3 {
+		return 0, fmt.Errorf("invalid duration '%s'", s)
+	}
+
+	var total time.Duration
+	for _, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid duration '%s'", s)
+		}
+		total = total*60 + time.Duration(n)
+	}
+	return total * time.Second, nil
+}
@@ -0,0 +1,71 @@
+package parsers
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"30", 30 * time.Second, false},
+		{"1.5", 1500 * time.Millisecond, false},
+		{"90 seconds", 90 * time.Second, false},
+		{"30 minutes", 30 * time.Minute, false},
+		{"45m", 45 * time.Minute, false},
+		{"1h 30m", 90 * time.Minute, false},
+		{"1h30m", 90 * time.Minute, false},
+		{"3 hours 30 minutes", 210 * time.Minute, false},
+		{"1.5h", 90 * time.Minute, false},
+		{"1 day and 2 hours", 26 * time.Hour, false},
+		{"2 days, 3 hrs", 51 * time.Hour, false},
+		{"1 week", 7 * 24 * time.Hour, false},
+		{"1 month", 30 * 24 * time.Hour, false},
+		{"1 year", 365 * 24 * time.Hour, false},
+		{"  2 Hours  ", 2 * time.Hour, false},
+		{"01:30:00", 90 * time.Minute, false},
+		{"05:30", 330 * time.Second, false},
+		{"", 0, true},
+		{"   ", 0, true},
+		{"soon", 0, true},
+		{"10 fortnights", 0, true},
+		{"and", 0, true},
+		{"1:2:3:4", 0, true},
+		{"01:xx", 0, true},
+		{"1..5h", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseDuration(tt.value)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("ParseDuration(%q) = %s, want an error", tt.value, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseDuration(%q): %v", tt.value, err)
+			}
+			if got != tt.want {
+				t.Errorf("ParseDuration(%q) = %s, want %s", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTimeoutBareNumberIsMinutes(t *testing.T) {
+	p := NewGitlabParser()
+	for value, want := range map[string]int{
+		"90":         90,
+		"1h 30m":     90,
+		"90 seconds": 2,
+	} {
+		if got := p.parseTimeout(value); got != want {
+			t.Errorf("parseTimeout(%q) = %d minutes, want %d", value, got, want)
+		}
+	}
+}
@@ -5,17 +5,33 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
 
+	"github.com/sanix-darker/git-ci/internal/actions"
 	"github.com/sanix-darker/git-ci/pkg/types"
 	yaml "gopkg.in/yaml.v3"
 )
 
+// maxWorkflowNesting is how many levels of reusable workflows GitHub
+// lets a workflow call
+const maxWorkflowNesting = 4
+
 type GithubParser struct {
 	// Cache for reusable workflows
 	workflowCache map[string]*GithubWorkflow
 	// Base directory for resolving relative paths
 	baseDir string
+	// Reusable workflows being inlined, outermost first
+	calling []string
+	// Where the repositories of remote reusable workflows are checked
+	// out; remote workflows aren't fetched without it
+	remoteDir string
+	// Remote reusable workflows come from remoteDir only
+	offline bool
+	// Remote reusable workflows are inlined when remoteDir has them,
+	// never fetched
+	cachedOnly bool
 }
 
 // NewGithubParser creates a new GitHub Actions parser
@@ -34,6 +50,10 @@ type GithubWorkflow struct {
 	Jobs        map[string]*GithubJob `yaml:"jobs"`
 	Permissions interface{}           `yaml:"permissions,omitempty"`
 	Concurrency *GithubConcurrency    `yaml:"concurrency,omitempty"`
+
+	// Local-only job hints. GitHub rejects the workflow with this key,
+	// jobs should rather set their hints in the GIT_CI_HINTS env variable.
+	LocalHints map[string]*types.LocalHints `yaml:"x-git-ci,omitempty"`
 }
 
 type GithubDefaults struct {
@@ -73,9 +93,9 @@ type GithubJob struct {
 }
 
 type GithubStrategy struct {
-	Matrix      interface{} `yaml:"matrix,omitempty"`
-	FailFast    *bool       `yaml:"fail-fast,omitempty"`
-	MaxParallel int         `yaml:"max-parallel,omitempty"`
+	Matrix      yaml.Node `yaml:"matrix,omitempty"`
+	FailFast    *bool     `yaml:"fail-fast,omitempty"`
+	MaxParallel int       `yaml:"max-parallel,omitempty"`
 }
 
 type GithubMatrix struct {
@@ -115,6 +135,24 @@ type GithubStep struct {
 	WorkingDirectory string                 `yaml:"working-directory,omitempty"`
 }
 
+// SetRemoteCacheDir checks the repositories of remote reusable workflows
+// out under dir, which keeps them for later runs
+func (p *GithubParser) SetRemoteCacheDir(dir string) {
+	p.remoteDir = dir
+}
+
+// SetOffline makes remote reusable workflows come from the on-disk cache
+// only; a workflow missing from it fails the parse
+func (p *GithubParser) SetOffline(offline bool) {
+	p.offline = offline
+}
+
+// SetCachedOnly makes the parser fetch no remote reusable workflow: those
+// of the on-disk cache are inlined, the others stay single jobs
+func (p *GithubParser) SetCachedOnly(cachedOnly bool) {
+	p.cachedOnly = cachedOnly
+}
+
 // Parse parses a GitHub Actions workflow file
 func (p *GithubParser) Parse(ciFilePath string) (*types.Pipeline, error) {
 	// Store base directory for relative path resolution
@@ -151,6 +189,15 @@ func (p *GithubParser) Parse(ciFilePath string) (*types.Pipeline, error) {
 		return nil, fmt.Errorf("failed to convert workflow: %w", err)
 	}
 
+	// Attach local-only hints
+	hints, err := githubLocalHints(&workflow, pipeline)
+	if err != nil {
+		return nil, fmt.Errorf("workflow validation failed: %w", err)
+	}
+	if err := attachLocalHints(pipeline, hints); err != nil {
+		return nil, fmt.Errorf("workflow validation failed: %w", err)
+	}
+
 	// Validate the pipeline
 	if err := p.Validate(pipeline); err != nil {
 		return nil, fmt.Errorf("workflow validation failed: %w", err)
@@ -159,19 +206,82 @@ func (p *GithubParser) Parse(ciFilePath string) (*types.Pipeline, error) {
 	return pipeline, nil
 }
 
+// githubLocalHints collects the hints of the jobs: the GIT_CI_HINTS env
+// variable of each job, removed from the env the job runs with, then the
+// top-level x-git-ci map for jobs without it
+func githubLocalHints(workflow *GithubWorkflow, pipeline *types.Pipeline) (map[string]*types.LocalHints, error) {
+	if len(workflow.LocalHints) > 0 {
+		fmt.Fprintf(os.Stderr, "Warning: GitHub rejects workflows with a top-level %s key; set the hints in the %s env variable of each job\n", LocalHintsKey, LocalHintsEnv)
+	}
+
+	hints := make(map[string]*types.LocalHints, len(workflow.LocalHints))
+	for jobID, job := range workflow.Jobs {
+		value, ok := job.Env[LocalHintsEnv]
+		if !ok {
+			continue
+		}
+		hint, err := decodeHintsValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("job '%s': %w", jobID, err)
+		}
+		hints[jobID] = hint
+	}
+	for name, hint := range workflow.LocalHints {
+		if _, set := hints[name]; !set {
+			hints[name] = hint
+		}
+	}
+
+	for _, job := range pipeline.Jobs {
+		delete(job.Environment, LocalHintsEnv)
+	}
+	return hints, nil
+}
+
 // convertToPipeline converts GitHub workflow to generic Pipeline
 func (p *GithubParser) convertToPipeline(workflow *GithubWorkflow) (*types.Pipeline, error) {
 	pipeline := &types.Pipeline{
 		Name:        workflow.Name,
 		Description: fmt.Sprintf("GitHub Actions workflow: %s", workflow.Name),
+		Provider:    "github",
 		Jobs:        make(map[string]*types.Job),
 		Environment: workflow.Env,
 		Triggers:    p.parseTriggers(workflow.On),
 	}
 
+	// Cron schedules and the branch and path filters of events
+	schedules, rules := p.parseTriggerFilters(workflow.On)
+	if len(schedules) > 0 {
+		pipeline.Metadata = map[string]string{"schedules": strings.Join(schedules, ", ")}
+	}
+	pipeline.Rules = rules
+
+	// Jobs replaced by the jobs they expand into, for needs to follow
+	variants := jobVariants{}
+
 	// Process each job
 	for jobID, ghJob := range workflow.Jobs {
-		// Handle reusable workflows
+		// Reusable workflows have their jobs inlined, once remote ones
+		// are fetched
+		if isLocalWorkflow(ghJob.Uses) || p.inlinesRemote(ghJob.Uses) {
+			jobs, err := p.inlineReusableWorkflow(jobID, ghJob)
+			if err != nil {
+				return nil, fmt.Errorf("failed to inline reusable workflow in job %s: %w", jobID, err)
+			}
+			// Sorted, so needs on the call list its jobs in a stable order
+			ids := make([]string, 0, len(jobs))
+			for id := range jobs {
+				ids = append(ids, id)
+			}
+			sort.Strings(ids)
+			for _, id := range ids {
+				pipeline.Jobs[id] = jobs[id]
+				variants.add(jobID, id)
+			}
+			continue
+		}
+
+		// Handle remote reusable workflows
 		if ghJob.Uses != "" {
 			job, err := p.parseReusableWorkflow(jobID, ghJob)
 			if err != nil {
@@ -185,9 +295,25 @@ func (p *GithubParser) convertToPipeline(workflow *GithubWorkflow) (*types.Pipel
 		if err != nil {
 			return nil, fmt.Errorf("failed to convert job %s: %w", jobID, err)
 		}
-		pipeline.Jobs[jobID] = job
+
+		// A strategy matrix runs one job per combination
+		ids, expanded, err := p.expandMatrix(jobID, ghJob, job)
+		if err != nil {
+			return nil, fmt.Errorf("failed to expand the matrix of job %s: %w", jobID, err)
+		}
+		if expanded == nil {
+			pipeline.Jobs[jobID] = job
+			continue
+		}
+		for _, id := range ids {
+			pipeline.Jobs[id] = expanded[id]
+			variants.add(jobID, id)
+		}
 	}
 
+	// Once all jobs are known, needs on an expanded job mean all its jobs
+	variants.resolveNeeds(pipeline)
+
 	return pipeline, nil
 }
 
@@ -200,7 +326,8 @@ func (p *GithubParser) convertJob(jobID string, ghJob *GithubJob, globalDefaults
 		If:            ghJob.If,
 		TimeoutMin:    ghJob.TimeoutMinutes,
 		ContinueOnErr: p.parseContinueOnError(ghJob.ContinueOnError),
-		Needs:         p.parseNeeds(ghJob.Needs),
+		Needs:         types.NeedsOf(p.parseNeeds(ghJob.Needs)...),
+		Outputs:       ghJob.Outputs,
 	}
 
 	// Set default timeout if not specified
@@ -224,7 +351,11 @@ func (p *GithubParser) convertJob(jobID string, ghJob *GithubJob, globalDefaults
 
 	// Parse strategy for matrix builds
 	if ghJob.Strategy != nil {
-		job.Strategy = p.parseStrategy(ghJob.Strategy)
+		strategy, err := p.parseStrategy(ghJob.Strategy)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse strategy: %w", err)
+		}
+		job.Strategy = strategy
 	}
 
 	// Determine default shell and working directory
@@ -361,11 +492,78 @@ func (p *GithubParser) parseTriggers(on interface{}) []string {
 		for trigger := range v {
 			triggers = append(triggers, trigger)
 		}
+		sort.Strings(triggers)
 	}
 
 	return triggers
 }
 
+// parseTriggerFilters returns the cron expressions of `on.schedule` and a
+// rule per event filtered by branches, tags or paths, its If matching the
+// event (github.event_name == 'push')
+func (p *GithubParser) parseTriggerFilters(on interface{}) ([]string, []types.Rule) {
+	events, ok := on.(map[string]interface{})
+	if !ok {
+		return nil, nil
+	}
+
+	var schedules []string
+	if schedule, ok := events["schedule"].([]interface{}); ok {
+		for _, entry := range schedule {
+			if m, ok := entry.(map[string]interface{}); ok {
+				if cron, ok := m["cron"].(string); ok {
+					schedules = append(schedules, cron)
+				}
+			}
+		}
+	}
+
+	names := make([]string, 0, len(events))
+	for name := range events {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var rules []types.Rule
+	for _, name := range names {
+		filters, ok := events[name].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		rule := types.Rule{
+			If:             fmt.Sprintf("github.event_name == '%s'", name),
+			Branches:       p.parseStringList(filters["branches"]),
+			BranchesIgnore: p.parseStringList(filters["branches-ignore"]),
+			Tags:           p.parseStringList(filters["tags"]),
+			TagsIgnore:     p.parseStringList(filters["tags-ignore"]),
+			Changes:        p.parseStringList(filters["paths"]),
+			ChangesIgnore:  p.parseStringList(filters["paths-ignore"]),
+		}
+		if len(rule.Branches)+len(rule.BranchesIgnore)+len(rule.Tags)+len(rule.TagsIgnore)+len(rule.Changes)+len(rule.ChangesIgnore) > 0 {
+			rules = append(rules, rule)
+		}
+	}
+
+	return schedules, rules
+}
+
+// parseStringList reads a string or a list of strings
+func (p *GithubParser) parseStringList(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var result []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				result = append(result, s)
+			}
+		}
+		return result
+	}
+	return nil
+}
+
 func (p *GithubParser) parseRunsOn(runsOn interface{}) string {
 	switch v := runsOn.(type) {
 	case string:
@@ -465,6 +663,14 @@ func (p *GithubParser) parseContainer(container interface{}) (*types.Container,
 			}
 		}
 
+		// Registry credentials of a private image
+		if credentials, ok := v["credentials"].(map[string]interface{}); ok {
+			c.Credentials = make(map[string]string, len(credentials))
+			for k, val := range credentials {
+				c.Credentials[k] = fmt.Sprintf("%v", val)
+			}
+		}
+
 		return c, nil
 	}
 
@@ -493,7 +699,7 @@ func (p *GithubParser) parseServices(services map[string]*GithubService) map[str
 	return result
 }
 
-func (p *GithubParser) parseStrategy(strategy *GithubStrategy) *types.Strategy {
+func (p *GithubParser) parseStrategy(strategy *GithubStrategy) (*types.Strategy, error) {
 	s := &types.Strategy{
 		MaxParallel: strategy.MaxParallel,
 	}
@@ -505,55 +711,295 @@ func (p *GithubParser) parseStrategy(strategy *GithubStrategy) *types.Strategy {
 	}
 
 	// Parse matrix
-	if strategy.Matrix != nil {
-		s.Matrix = p.parseMatrix(strategy.Matrix)
+	matrix, err := p.parseMatrix(&strategy.Matrix)
+	if err != nil {
+		return nil, err
+	}
+	if matrix != nil {
+		s.Matrix = matrix.values
+		for _, entry := range matrix.include {
+			s.Include = append(s.Include, entry.toMap())
+		}
+		for _, entry := range matrix.exclude {
+			s.Exclude = append(s.Exclude, entry.toMap())
+		}
 	}
 
-	return s
+	return s, nil
 }
 
-func (p *GithubParser) parseMatrix(matrix interface{}) map[string][]interface{} {
-	result := make(map[string][]interface{})
+func (p *GithubParser) convertWith(with map[string]interface{}) map[string]string {
+	result := make(map[string]string)
+	for k, v := range with {
+		result[k] = fmt.Sprintf("%v", v)
+	}
+	return result
+}
 
-	switch v := matrix.(type) {
+// isLocalWorkflow reports whether a job calls a reusable workflow of the
+// repository (./.github/workflows/build.yml)
+func isLocalWorkflow(uses string) bool {
+	return strings.HasPrefix(uses, "./")
+}
+
+// inlineReusableWorkflow returns the jobs of the reusable workflow called
+// by a job, keyed "caller/job". Its jobs without needs wait for the needs
+// of the caller, and its `with:` inputs (or their defaults) reach them as
+// INPUT_<NAME> variables. Their WorkflowCall carries the inputs, secrets
+// and outputs of the call, linked to the call of the workflow calling
+// the caller when nested.
+func (p *GithubParser) inlineReusableWorkflow(jobID string, ghJob *GithubJob) (map[string]*types.Job, error) {
+	path, err := p.resolveWorkflow(ghJob.Uses)
+	if err != nil {
+		return nil, err
+	}
+	for _, calling := range p.calling {
+		if calling == path {
+			return nil, fmt.Errorf("reusable workflow %s calls itself", ghJob.Uses)
+		}
+	}
+	if len(p.calling) >= maxWorkflowNesting {
+		return nil, fmt.Errorf("reusable workflows can't be nested more than %d levels deep", maxWorkflowNesting)
+	}
+
+	workflow, err := p.loadWorkflow(path)
+	if err != nil {
+		return nil, err
+	}
+	inputs, err := p.workflowCallInputs(workflow, ghJob.With)
+	if err != nil {
+		return nil, err
+	}
+	vars := make(map[string]string, len(inputs))
+	for name, value := range inputs {
+		vars["INPUT_"+strings.ToUpper(strings.ReplaceAll(name, "-", "_"))] = value
+	}
+
+	p.calling = append(p.calling, path)
+	called, err := p.convertToPipeline(workflow)
+	p.calling = p.calling[:len(p.calling)-1]
+	if err != nil {
+		return nil, err
+	}
+
+	call := &types.WorkflowCall{
+		Uses:    ghJob.Uses,
+		With:    ghJob.With,
+		Inputs:  inputs,
+		Outputs: workflowCallOutputs(workflow),
+	}
+	switch secrets := ghJob.Secrets.(type) {
+	case string:
+		call.InheritSecrets = secrets == "inherit"
 	case map[string]interface{}:
-		for key, value := range v {
-			// Skip include/exclude as they're special keys
-			if key == "include" || key == "exclude" {
-				continue
+		call.Secrets = make(map[string]string, len(secrets))
+		for k, v := range secrets {
+			call.Secrets[k] = fmt.Sprintf("%v", v)
+		}
+	}
+
+	callerName := p.getJobName(jobID, ghJob)
+	callerNeeds := p.parseNeeds(ghJob.Needs)
+	jobs := make(map[string]*types.Job, len(called.Jobs))
+	for id, job := range called.Jobs {
+		job.Name = callerName + types.CallSeparator + job.Name
+
+		// Needs stay within the called workflow
+		for i := range job.Needs {
+			job.Needs[i].Job = jobID + types.CallSeparator + job.Needs[i].Job
+		}
+		if len(job.Needs) == 0 {
+			job.Needs = types.NeedsOf(callerNeeds...)
+		}
+
+		// Workflow env and inputs, below the variables of the job
+		env := make(map[string]string, len(called.Environment)+len(inputs)+len(job.Environment))
+		for _, vars := range []map[string]string{called.Environment, vars, job.Environment} {
+			for k, v := range vars {
+				env[k] = v
 			}
+		}
+		job.Environment = env
 
-			switch val := value.(type) {
-			case []interface{}:
-				result[key] = val
-			case interface{}:
-				result[key] = []interface{}{val}
+		switch {
+		case ghJob.If != "" && job.If != "":
+			job.If = fmt.Sprintf("(%s) && (%s)", ghJob.If, job.If)
+		case ghJob.If != "":
+			job.If = ghJob.If
+		}
+
+		if job.MatrixOf != "" {
+			job.MatrixOf = jobID + types.CallSeparator + job.MatrixOf
+		}
+		// Jobs of a nested call keep their call, under this one
+		if job.WorkflowCall == nil {
+			job.WorkflowCall = call
+		} else {
+			outer := job.WorkflowCall
+			for outer.Caller != nil {
+				outer = outer.Caller
+			}
+			if outer != call {
+				outer.Caller = call
 			}
 		}
+		if !call.InheritSecrets {
+			job.Secrets = call.Secrets
+		}
+		jobs[jobID+types.CallSeparator+id] = job
 	}
 
-	return result
+	return jobs, nil
 }
 
-func (p *GithubParser) convertWith(with map[string]interface{}) map[string]string {
-	result := make(map[string]string)
-	for k, v := range with {
-		result[k] = fmt.Sprintf("%v", v)
+// resolveWorkflow returns the file of a reusable workflow: local ones are
+// in the repository of the calling workflow, remote ones
+// (owner/repo/.github/workflows/build.yml@ref) in their repository,
+// fetched once like remote actions
+func (p *GithubParser) resolveWorkflow(uses string) (string, error) {
+	if isLocalWorkflow(uses) {
+		return p.workflowPath(uses), nil
 	}
-	return result
+	if _, _, ok := actions.SplitUses(uses); !ok || strings.Count(uses, "/") < 2 {
+		return "", fmt.Errorf("invalid reusable workflow '%s', expected owner/repo/path@ref or ./path", uses)
+	}
+	return actions.Download(uses, p.remoteDir, p.offline || p.cachedOnly)
 }
 
-func (p *GithubParser) parseReusableWorkflow(jobID string, ghJob *GithubJob) (*types.Job, error) {
-	// Parse reusable workflow reference
-	// Format: owner/repo/.github/workflows/workflow.yml@ref
-	// or: ./.github/workflows/workflow.yml
+// inlinesRemote reports whether the jobs of the remote reusable workflow
+// of uses are inlined: once fetched, or when cached if nothing is fetched
+func (p *GithubParser) inlinesRemote(uses string) bool {
+	if uses == "" || p.remoteDir == "" {
+		return false
+	}
+	if !p.cachedOnly {
+		return true
+	}
+	_, err := actions.Download(uses, p.remoteDir, true)
+	return err == nil
+}
 
+// workflowPath returns the file of a local reusable workflow. Paths are
+// relative to the root of the repository of the calling workflow, the
+// parent of .github.
+func (p *GithubParser) workflowPath(uses string) string {
+	base := p.baseDir
+	if n := len(p.calling); n > 0 {
+		base = filepath.Dir(p.calling[n-1])
+	}
+	root := base
+	for dir := base; ; dir = filepath.Dir(dir) {
+		if filepath.Base(dir) == ".github" {
+			root = filepath.Dir(dir)
+			break
+		}
+		if parent := filepath.Dir(dir); parent == dir {
+			break
+		}
+	}
+
+	path := filepath.Join(root, filepath.FromSlash(uses))
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
+
+// loadWorkflow reads a reusable workflow, once per parser
+func (p *GithubParser) loadWorkflow(path string) (*GithubWorkflow, error) {
+	if workflow, ok := p.workflowCache[path]; ok {
+		return workflow, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read reusable workflow: %w", err)
+	}
+	var workflow GithubWorkflow
+	if err := yaml.Unmarshal(data, &workflow); err != nil {
+		return nil, fmt.Errorf("failed to parse reusable workflow %s: %w", path, err)
+	}
+	if !p.hasTrigger(workflow.On, "workflow_call") {
+		return nil, fmt.Errorf("workflow %s is not reusable (no workflow_call trigger)", path)
+	}
+
+	p.workflowCache[path] = &workflow
+	return &workflow, nil
+}
+
+// hasTrigger reports whether an `on:` value lists the event
+func (p *GithubParser) hasTrigger(on interface{}, event string) bool {
+	for _, trigger := range p.parseTriggers(on) {
+		if trigger == event {
+			return true
+		}
+	}
+	return false
+}
+
+// workflowCallInputs returns the inputs of a reusable workflow by name:
+// the `with:` values of the caller, else the defaults. A required input
+// without value is an error.
+func (p *GithubParser) workflowCallInputs(workflow *GithubWorkflow, with map[string]interface{}) (map[string]string, error) {
+	vars := make(map[string]string)
+	declared, _ := workflowCallTrigger(workflow)["inputs"].(map[string]interface{})
+
+	for name := range with {
+		if _, ok := declared[name]; !ok {
+			return nil, fmt.Errorf("input '%s' is not defined by the reusable workflow", name)
+		}
+	}
+	for name, def := range declared {
+		spec, _ := def.(map[string]interface{})
+		if value, ok := with[name]; ok {
+			vars[name] = fmt.Sprintf("%v", value)
+		} else if value, ok := spec["default"]; ok {
+			vars[name] = fmt.Sprintf("%v", value)
+		} else if required, _ := spec["required"].(bool); required {
+			return nil, fmt.Errorf("input '%s' of the reusable workflow is required", name)
+		}
+	}
+
+	return vars, nil
+}
+
+// workflowCallOutputs returns the outputs a reusable workflow declares,
+// by name: the expressions of their value, reading the jobs context
+func workflowCallOutputs(workflow *GithubWorkflow) map[string]string {
+	declared, _ := workflowCallTrigger(workflow)["outputs"].(map[string]interface{})
+	if len(declared) == 0 {
+		return nil
+	}
+	outputs := make(map[string]string, len(declared))
+	for name, def := range declared {
+		spec, _ := def.(map[string]interface{})
+		if value, ok := spec["value"]; ok {
+			outputs[name] = fmt.Sprintf("%v", value)
+		}
+	}
+	return outputs
+}
+
+// workflowCallTrigger returns the `on.workflow_call` of a workflow, nil
+// when it has no settings
+func workflowCallTrigger(workflow *GithubWorkflow) map[string]interface{} {
+	if on, ok := workflow.On.(map[string]interface{}); ok {
+		call, _ := on["workflow_call"].(map[string]interface{})
+		return call
+	}
+	return nil
+}
+
+// parseReusableWorkflow stands for a call to a remote reusable workflow
+// (owner/repo/.github/workflows/workflow.yml@ref) when the parser fetches
+// none: its single step only names the workflow.
+func (p *GithubParser) parseReusableWorkflow(jobID string, ghJob *GithubJob) (*types.Job, error) {
 	job := &types.Job{
 		Name:   p.getJobName(jobID, ghJob),
 		RunsOn: "ubuntu-latest", // Default for reusable workflows
 		Steps: []types.Step{
 			{
-				Name: fmt.Sprintf("Call reusable workflow: %s", ghJob.Uses),
+				Name: fmt.Sprintf("Call remote reusable workflow (not supported locally): %s", ghJob.Uses),
 				Uses: ghJob.Uses,
 				With: p.convertWith(ghJob.With),
 			},
@@ -619,7 +1065,7 @@ func (p *GithubParser) Validate(pipeline *types.Pipeline) error {
 		}
 
 		// Validate job dependencies
-		for _, need := range job.Needs {
+		for _, need := range job.NeedNames() {
 			if !jobIDs[need] {
 				errors = append(errors, fmt.Sprintf("job '%s' depends on non-existent job '%s'", jobID, need))
 			}
@@ -677,8 +1123,8 @@ func (p *GithubParser) checkCircularDependencies(jobID string, job *types.Job, a
 
 	visited = append(visited, jobID)
 
-	// Check dependencies recursively
-	for _, need := range job.Needs {
+	// Check needs and dependencies recursively
+	for _, need := range job.UpstreamNames() {
 		if dependentJob, exists := allJobs[need]; exists {
 			if err := p.checkCircularDependencies(need, dependentJob, allJobs, visited); err != nil {
 				return err
@@ -708,7 +1154,7 @@ func (p *GithubParser) validateActionReference(uses string) error {
 	}
 
 	// GitHub action
-	pattern := `^[a-zA-Z0-9\-_]+/[a-zA-Z0-9\-_]+(/[a-zA-Z0-9\-_/]+)?@.+$`
+	pattern := `^[a-zA-Z0-9\-_.]+/[a-zA-Z0-9\-_.]+(/[a-zA-Z0-9\-_./]+)?@.+$`
 	matched, err := regexp.MatchString(pattern, uses)
 	if err != nil {
 		return fmt.Errorf("failed to validate action reference: %w", err)
@@ -0,0 +1,401 @@
+package parsers
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/sanix-darker/git-ci/pkg/types"
+)
+
+// parseGithub parses a workflow file holding content
+func parseGithub(t *testing.T, content string) (*types.Pipeline, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "ci.yml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return NewGithubParser().Parse(path)
+}
+
+// writeRepo writes files into a new repository and returns its root
+func writeRepo(t *testing.T, files map[string]string) string {
+	t.Helper()
+	root := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+// parseGithubRepo writes files into a repository and parses its workflow
+// .github/workflows/ci.yml
+func parseGithubRepo(t *testing.T, files map[string]string) (*types.Pipeline, error) {
+	t.Helper()
+	root := writeRepo(t, files)
+	return NewGithubParser().Parse(filepath.Join(root, ".github", "workflows", "ci.yml"))
+}
+
+func TestGithubReusableWorkflowJobNames(t *testing.T) {
+	pipeline, err := parseGithubRepo(t, map[string]string{
+		".github/workflows/ci.yml": `
+name: CI
+on: push
+jobs:
+  build:
+    name: Build
+    uses: ./.github/workflows/reusable.yml
+  deploy:
+    runs-on: ubuntu-latest
+    needs: build
+    steps:
+      - run: echo deploy
+`,
+		".github/workflows/reusable.yml": `
+name: Reusable
+on: workflow_call
+jobs:
+  compile:
+    name: Compile
+    runs-on: ubuntu-latest
+    steps:
+      - run: echo compile
+  test:
+    runs-on: ubuntu-latest
+    needs: compile
+    steps:
+      - run: echo test
+`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var ids []string
+	for id := range pipeline.Jobs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	if got := strings.Join(ids, ","); got != "build / compile,build / test,deploy" {
+		t.Fatalf("jobs %s, want the jobs of the call namespaced as caller / job", got)
+	}
+	if got := pipeline.Jobs["build / compile"].Name; got != "Build / Compile" {
+		t.Errorf("name %q, want Build / Compile", got)
+	}
+	if got := pipeline.Jobs["build / test"].NeedNames(); !slices.Equal(got, []string{"build / compile"}) {
+		t.Errorf("the called test job needs %v", got)
+	}
+	if got := pipeline.Jobs["deploy"].NeedNames(); !slices.Equal(got, []string{"build / compile", "build / test"}) {
+		t.Errorf("the job needing the caller needs %v", got)
+	}
+}
+
+func TestGithubNeedsOnExpandedJobs(t *testing.T) {
+	pipeline, err := parseGithubRepo(t, map[string]string{
+		".github/workflows/ci.yml": `
+name: CI
+on: push
+jobs:
+  build:
+    runs-on: ${{ matrix.os }}
+    strategy:
+      matrix:
+        os: [ubuntu-latest, macos-latest]
+    steps:
+      - run: echo build
+  lint:
+    uses: ./.github/workflows/lint.yml
+  package:
+    runs-on: ubuntu-latest
+    needs: [build, lint]
+    steps:
+      - run: echo package
+  publish:
+    runs-on: ubuntu-latest
+    needs: ["build (ubuntu-latest)", "lint / eslint"]
+    steps:
+      - run: echo publish
+`,
+		".github/workflows/lint.yml": `
+name: Lint
+on: workflow_call
+jobs:
+  eslint:
+    runs-on: ubuntu-latest
+    steps:
+      - run: echo eslint
+  stylelint:
+    runs-on: ubuntu-latest
+    steps:
+      - run: echo stylelint
+`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A need on the matrix or the call means all of its jobs
+	want := []string{"build (ubuntu-latest)", "build (macos-latest)", "lint / eslint", "lint / stylelint"}
+	got := pipeline.Jobs["package"].NeedNames()
+	sort.Strings(got)
+	sort.Strings(want)
+	if !slices.Equal(got, want) {
+		t.Errorf("package needs %v, want %v", got, want)
+	}
+
+	// A need on one of them, just that one
+	want = []string{"build (ubuntu-latest)", "lint / eslint"}
+	if got := pipeline.Jobs["publish"].NeedNames(); !slices.Equal(got, want) {
+		t.Errorf("publish needs %v, want %v", got, want)
+	}
+}
+
+func TestGithubNeedsTypo(t *testing.T) {
+	_, err := parseGithub(t, `
+name: CI
+on: push
+jobs:
+  build:
+    runs-on: ${{ matrix.os }}
+    strategy:
+      matrix:
+        os: [ubuntu-latest]
+    steps:
+      - run: echo build
+  deploy:
+    runs-on: ubuntu-latest
+    needs: biuld
+    steps:
+      - run: echo deploy
+`)
+	if err == nil || !strings.Contains(err.Error(), "job 'deploy' depends on non-existent job 'biuld'") {
+		t.Errorf("error %v, want the unknown need biuld reported", err)
+	}
+}
+
+func TestGithubHintsFromJobEnv(t *testing.T) {
+	pipeline, err := parseGithub(t, `
+name: CI
+on: push
+jobs:
+  integration:
+    runs-on: ubuntu-latest
+    env:
+      API_URL: http://localhost:8080
+      GIT_CI_HINTS: "{runner: docker, memory: 4g, env: {DEBUG: '1'}}"
+    steps:
+      - run: echo test
+  matrix:
+    runs-on: ubuntu-latest
+    strategy:
+      matrix:
+        go: ["1.23", "1.24"]
+    env:
+      GIT_CI_HINTS: "skip: true"
+    steps:
+      - run: echo ${{ matrix.go }}
+  plain:
+    runs-on: ubuntu-latest
+    steps:
+      - run: echo plain
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	job := pipeline.Jobs["integration"]
+	if job.Local == nil || job.Local.Runner != "docker" || job.Local.Memory != "4g" || job.Local.Env["DEBUG"] != "1" {
+		t.Errorf("integration hints %+v", job.Local)
+	}
+	if job.Environment["API_URL"] == "" {
+		t.Error("the other env variables of the job were lost")
+	}
+
+	variants := 0
+	for name, job := range pipeline.Jobs {
+		if _, set := job.Environment[LocalHintsEnv]; set {
+			t.Errorf("%s runs with %s", name, LocalHintsEnv)
+		}
+		if job.MatrixOf == "matrix" {
+			variants++
+			if job.Local == nil || !job.Local.Skip {
+				t.Errorf("matrix variant %s lost the hints of its job", name)
+			}
+		}
+	}
+	if variants != 2 {
+		t.Errorf("got %d matrix variants, want 2", variants)
+	}
+	if pipeline.Jobs["plain"].Local != nil {
+		t.Error("a job without hints got some")
+	}
+}
+
+func TestGithubHintsInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		hints string
+		want  string
+	}{
+		{"not a mapping", `"[docker]"`, "invalid GIT_CI_HINTS"},
+		{"unknown runner", `"runner: vm"`, "unknown runner 'vm'"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseGithub(t, `
+name: CI
+on: push
+jobs:
+  build:
+    runs-on: ubuntu-latest
+    env:
+      GIT_CI_HINTS: `+tt.hints+`
+    steps:
+      - run: echo build
+`)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("got %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestGithubLocalReusableWorkflow(t *testing.T) {
+	root := writeRepo(t, map[string]string{
+		".github/workflows/ci.yml": `
+name: CI
+on: push
+jobs:
+  staging:
+    uses: ./.github/workflows/deploy.yml
+    with:
+      environment: staging
+  production:
+    uses: ./.github/workflows/deploy.yml
+    needs: staging
+    with:
+      environment: production
+      dry-run: false
+  lint:
+    uses: octo-org/shared/.github/workflows/lint.yml@v1
+    with:
+      strict: true
+`,
+		".github/workflows/deploy.yml": `
+on:
+  workflow_call:
+    inputs:
+      environment:
+        required: true
+        type: string
+      dry-run:
+        type: boolean
+        default: true
+env:
+  REGION: eu
+jobs:
+  deploy:
+    runs-on: ubuntu-latest
+    steps:
+      - run: ./deploy.sh "$INPUT_ENVIRONMENT"
+`,
+	})
+
+	parser := NewGithubParser()
+	pipeline, err := parser.Parse(filepath.Join(root, ".github", "workflows", "ci.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each call inlines the jobs with its own inputs, defaults included
+	tests := []struct {
+		job, environment, dryRun string
+	}{
+		{"staging / deploy", "staging", "true"},
+		{"production / deploy", "production", "false"},
+	}
+	for _, tt := range tests {
+		job := pipeline.Jobs[tt.job]
+		if job == nil {
+			t.Fatalf("no job %s", tt.job)
+		}
+		env := job.Environment
+		if env["INPUT_ENVIRONMENT"] != tt.environment || env["INPUT_DRY_RUN"] != tt.dryRun || env["REGION"] != "eu" {
+			t.Errorf("%s: environment %v", tt.job, env)
+		}
+	}
+	if got := pipeline.Jobs["production / deploy"].NeedNames(); !slices.Equal(got, []string{"staging / deploy"}) {
+		t.Errorf("production / deploy needs %v, want staging / deploy", got)
+	}
+
+	// The workflow called twice is read once
+	if len(parser.workflowCache) != 1 {
+		t.Errorf("%d cached workflows, want 1", len(parser.workflowCache))
+	}
+
+	// A remote workflow that isn't fetched stays a labeled placeholder
+	lint := pipeline.Jobs["lint"]
+	if lint == nil || len(lint.Steps) != 1 || !strings.Contains(lint.Steps[0].Name, "not supported locally") {
+		t.Fatalf("lint: %+v, want a placeholder step", lint)
+	}
+	if lint.Steps[0].With["strict"] != "true" {
+		t.Errorf("lint: with %v", lint.Steps[0].With)
+	}
+}
+
+func TestGithubScheduleAndPushFilters(t *testing.T) {
+	pipeline, err := parseGithub(t, `
+name: Nightly
+on:
+  schedule:
+    - cron: "0 3 * * *"
+    - cron: "30 12 * * 1-5"
+  push:
+    branches: [main, "release/**"]
+    paths-ignore: [docs/**]
+  pull_request:
+    paths: src/**
+  workflow_dispatch:
+jobs:
+  build:
+    runs-on: ubuntu-latest
+    steps:
+      - run: make
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	triggers := slices.Clone(pipeline.Triggers)
+	sort.Strings(triggers)
+	if got := strings.Join(triggers, ","); got != "pull_request,push,schedule,workflow_dispatch" {
+		t.Errorf("triggers %s", got)
+	}
+	if got := pipeline.Metadata["schedules"]; got != "0 3 * * *, 30 12 * * 1-5" {
+		t.Errorf("schedules %q", got)
+	}
+
+	// One rule per event with filters, in event order
+	if len(pipeline.Rules) != 2 {
+		t.Fatalf("rules %+v, want the pull_request and push filters", pipeline.Rules)
+	}
+	pr, push := pipeline.Rules[0], pipeline.Rules[1]
+	if pr.If != "github.event_name == 'pull_request'" || !slices.Equal(pr.Changes, []string{"src/**"}) {
+		t.Errorf("pull_request rule %+v", pr)
+	}
+	if push.If != "github.event_name == 'push'" ||
+		!slices.Equal(push.Branches, []string{"main", "release/**"}) ||
+		!slices.Equal(push.ChangesIgnore, []string{"docs/**"}) {
+		t.Errorf("push rule %+v", push)
+	}
+}
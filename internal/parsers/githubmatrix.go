@@ -0,0 +1,466 @@
+package parsers
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/sanix-darker/git-ci/pkg/types"
+	yaml "gopkg.in/yaml.v3"
+)
+
+// maxGithubMatrixJobs caps the jobs a strategy matrix expands to, as
+// GitHub does
+const maxGithubMatrixJobs = 256
+
+// githubMatrix is a strategy matrix with its dimensions in file order
+type githubMatrix struct {
+	keys    []string
+	values  map[string][]interface{}
+	include []*matrixCombination
+	exclude []*matrixCombination
+}
+
+// matrixCombination holds matrix values by key, the keys in the order
+// they name the job
+type matrixCombination struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+// set sets a value, a new key naming the job after the previous ones
+func (c *matrixCombination) set(key string, value interface{}) {
+	if _, ok := c.values[key]; !ok {
+		c.keys = append(c.keys, key)
+	}
+	c.values[key] = value
+}
+
+// clone returns a copy of the combination
+func (c *matrixCombination) clone() *matrixCombination {
+	result := &matrixCombination{
+		keys:   append([]string(nil), c.keys...),
+		values: make(map[string]interface{}, len(c.values)+1),
+	}
+	for k, v := range c.values {
+		result.values[k] = v
+	}
+	return result
+}
+
+// with returns a copy of the combination with a value set
+func (c *matrixCombination) with(key string, value interface{}) *matrixCombination {
+	result := c.clone()
+	result.set(key, value)
+	return result
+}
+
+// toMap returns the values of the combination
+func (c *matrixCombination) toMap() map[string]interface{} {
+	result := make(map[string]interface{}, len(c.values))
+	for k, v := range c.values {
+		result[k] = v
+	}
+	return result
+}
+
+// parseMatrix reads a strategy matrix. A matrix given as an expression
+// (`${{ fromJSON(...) }}`) can't be expanded and is nil.
+func (p *GithubParser) parseMatrix(node *yaml.Node) (*githubMatrix, error) {
+	if node.Kind != yaml.MappingNode {
+		return nil, nil
+	}
+
+	m := &githubMatrix{values: make(map[string][]interface{})}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i].Value, node.Content[i+1]
+
+		switch key {
+		case "include", "exclude":
+			entries, err := p.matrixEntries(key, value)
+			if err != nil {
+				return nil, err
+			}
+			if key == "include" {
+				m.include = entries
+			} else {
+				m.exclude = entries
+			}
+			continue
+		}
+
+		var decoded interface{}
+		if err := value.Decode(&decoded); err != nil {
+			return nil, fmt.Errorf("matrix '%s': %w", key, err)
+		}
+		list, ok := decoded.([]interface{})
+		if !ok {
+			// An expression can't be expanded here
+			return nil, nil
+		}
+		m.keys = append(m.keys, key)
+		m.values[key] = list
+	}
+	return m, nil
+}
+
+// matrixEntries reads the include or exclude entries of a matrix
+func (p *GithubParser) matrixEntries(key string, node *yaml.Node) ([]*matrixCombination, error) {
+	if node.Kind != yaml.SequenceNode {
+		return nil, fmt.Errorf("matrix %s must be a list", key)
+	}
+
+	entries := make([]*matrixCombination, 0, len(node.Content))
+	for _, item := range node.Content {
+		if item.Kind != yaml.MappingNode {
+			return nil, fmt.Errorf("matrix %s entries must be maps", key)
+		}
+		entry := &matrixCombination{values: make(map[string]interface{})}
+		for i := 0; i+1 < len(item.Content); i += 2 {
+			var value interface{}
+			if err := item.Content[i+1].Decode(&value); err != nil {
+				return nil, fmt.Errorf("matrix %s '%s': %w", key, item.Content[i].Value, err)
+			}
+			entry.set(item.Content[i].Value, value)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// combinations returns the jobs of a matrix as GitHub computes them: the
+// product of its dimensions less the excluded combinations, each include
+// entry then extending the combinations whose original values it doesn't
+// change, or adding a combination of its own when it extends none
+func (m *githubMatrix) combinations() ([]*matrixCombination, error) {
+	var product []*matrixCombination
+	if len(m.keys) > 0 {
+		product = []*matrixCombination{{values: make(map[string]interface{})}}
+		for _, key := range m.keys {
+			var next []*matrixCombination
+			for _, combination := range product {
+				for _, value := range m.values[key] {
+					next = append(next, combination.with(key, value))
+				}
+			}
+			product = next
+		}
+	}
+
+	result := make([]*matrixCombination, 0, len(product))
+	for _, combination := range product {
+		if !m.excluded(combination) {
+			result = append(result, combination)
+		}
+	}
+
+	extendable := len(result)
+	for _, entry := range m.include {
+		extended := false
+		for _, combination := range result[:extendable] {
+			if m.extends(entry, combination) {
+				for _, key := range entry.keys {
+					combination.set(key, entry.values[key])
+				}
+				extended = true
+			}
+		}
+		if !extended {
+			result = append(result, entry.clone())
+		}
+	}
+
+	if len(result) == 0 {
+		return nil, fmt.Errorf("strategy matrix has no combinations")
+	}
+	if len(result) > maxGithubMatrixJobs {
+		return nil, fmt.Errorf("strategy matrix expands to %d jobs, more than the limit of %d", len(result), maxGithubMatrixJobs)
+	}
+	return result, nil
+}
+
+// excluded reports whether an exclude entry matches all its values
+func (m *githubMatrix) excluded(combination *matrixCombination) bool {
+	for _, entry := range m.exclude {
+		matches := true
+		for key, value := range entry.values {
+			if !matrixValuesEqual(combination.values[key], value) {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			return true
+		}
+	}
+	return false
+}
+
+// extends reports whether an include entry extends a combination: it
+// keeps the values the combination has for the matrix dimensions
+func (m *githubMatrix) extends(entry, combination *matrixCombination) bool {
+	for key, value := range entry.values {
+		if _, dimension := m.values[key]; dimension && !matrixValuesEqual(combination.values[key], value) {
+			return false
+		}
+	}
+	return true
+}
+
+// matrixValuesEqual compares matrix values, scalars by their text
+func matrixValuesEqual(a, b interface{}) bool {
+	switch a.(type) {
+	case map[string]interface{}, []interface{}:
+		return reflect.DeepEqual(a, b)
+	}
+	return fmt.Sprint(a) == fmt.Sprint(b)
+}
+
+// matrixName returns the name of a combination's job: GitHub appends the
+// values to the name unless the name itself uses the matrix
+func (c *matrixCombination) matrixName(name string, usesMatrix bool) string {
+	if usesMatrix {
+		return c.substitute(name)
+	}
+	values := make([]string, len(c.keys))
+	for i, key := range c.keys {
+		values[i] = matrixString(c.values[key])
+	}
+	return fmt.Sprintf("%s (%s)", name, strings.Join(values, ", "))
+}
+
+var (
+	// githubExpression matches a `${{ }}` expression
+	githubExpression = regexp.MustCompile(`\$\{\{\s*(.*?)\s*\}\}`)
+
+	// matrixReference matches a property of the matrix context
+	matrixReference = regexp.MustCompile(`^(?i:matrix)((?:\.[A-Za-z_][A-Za-z0-9_-]*)+)`)
+)
+
+// lookup returns the value of a matrix property path (".os.name"), nil
+// when the combination has none
+func (c *matrixCombination) lookup(path string) interface{} {
+	var value interface{} = c.values
+	for _, name := range strings.Split(strings.TrimPrefix(path, "."), ".") {
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		found, exists := obj[name]
+		if !exists {
+			// Property names are case insensitive
+			for k, v := range obj {
+				if strings.EqualFold(k, name) {
+					found = v
+				}
+			}
+		}
+		value = found
+	}
+	return value
+}
+
+// substitute replaces the matrix references of a string: a `${{ matrix.x }}`
+// expression by the value, references within other expressions by
+// literals for those to be evaluated with the remaining contexts
+func (c *matrixCombination) substitute(s string) string {
+	if !strings.Contains(strings.ToLower(s), "matrix.") {
+		return s
+	}
+	return githubExpression.ReplaceAllStringFunc(s, func(expr string) string {
+		inner := githubExpression.FindStringSubmatch(expr)[1]
+		if ref := matrixReference.FindStringSubmatch(inner); ref != nil && len(ref[0]) == len(inner) {
+			return matrixString(c.lookup(ref[1]))
+		}
+		return "${{ " + c.literals(inner) + " }}"
+	})
+}
+
+// condition substitutes the matrix references of an `if:` condition, which
+// stays an expression with or without its `${{ }}`
+func (c *matrixCombination) condition(cond string) string {
+	if !strings.Contains(strings.ToLower(cond), "matrix.") {
+		return cond
+	}
+	if !strings.Contains(cond, "${{") {
+		return c.literals(cond)
+	}
+	return githubExpression.ReplaceAllStringFunc(cond, func(expr string) string {
+		return "${{ " + c.literals(githubExpression.FindStringSubmatch(expr)[1]) + " }}"
+	})
+}
+
+// literals replaces the matrix references of an expression by literals,
+// leaving its quoted strings alone
+func (c *matrixCombination) literals(expr string) string {
+	var b strings.Builder
+	for i := 0; i < len(expr); {
+		ch := expr[i]
+		if ch == '\'' {
+			end := i + 1
+			for end < len(expr) {
+				if expr[end] == '\'' {
+					if end+1 < len(expr) && expr[end+1] == '\'' {
+						end += 2
+						continue
+					}
+					break
+				}
+				end++
+			}
+			end = min(end+1, len(expr))
+			b.WriteString(expr[i:end])
+			i = end
+			continue
+		}
+		if (ch == 'm' || ch == 'M') && (i == 0 || !isNameChar(expr[i-1])) {
+			if ref := matrixReference.FindStringSubmatch(expr[i:]); ref != nil {
+				b.WriteString(matrixLiteral(c.lookup(ref[1])))
+				i += len(ref[0])
+				continue
+			}
+		}
+		b.WriteByte(ch)
+		i++
+	}
+	return b.String()
+}
+
+// isNameChar reports whether ch may be part of an expression name
+func isNameChar(ch byte) bool {
+	return ch == '_' || ch == '-' || ch == '.' ||
+		(ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z') || (ch >= '0' && ch <= '9')
+}
+
+// matrixString converts a matrix value the way GitHub prints it
+func matrixString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case map[string]interface{}, []interface{}:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return string(data)
+	}
+	return fmt.Sprint(value)
+}
+
+// matrixLiteral returns the expression literal of a matrix value
+func matrixLiteral(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "'" + strings.ReplaceAll(v, "'", "''") + "'"
+	case map[string]interface{}, []interface{}:
+		return "fromJSON(" + matrixLiteral(matrixString(v)) + ")"
+	}
+	return fmt.Sprint(value)
+}
+
+// substituteMap returns a copy of m with its values substituted
+func (c *matrixCombination) substituteMap(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	result := make(map[string]string, len(m))
+	for k, v := range m {
+		result[k] = c.substitute(v)
+	}
+	return result
+}
+
+// expandMatrix returns the jobs of a job with a strategy matrix, one per
+// combination, keyed "id (value1, value2)", and their keys in matrix
+// order; nil for a job without matrix
+func (p *GithubParser) expandMatrix(jobID string, ghJob *GithubJob, job *types.Job) ([]string, map[string]*types.Job, error) {
+	if ghJob.Strategy == nil {
+		return nil, nil, nil
+	}
+	matrix, err := p.parseMatrix(&ghJob.Strategy.Matrix)
+	if err != nil || matrix == nil {
+		return nil, nil, err
+	}
+	combinations, err := matrix.combinations()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	usesMatrix := strings.Contains(strings.ToLower(ghJob.Name), "matrix.")
+	ids := make([]string, 0, len(combinations))
+	jobs := make(map[string]*types.Job, len(combinations))
+	for _, combination := range combinations {
+		key := combination.matrixName(jobID, false)
+		if _, exists := jobs[key]; exists {
+			return nil, nil, fmt.Errorf("strategy matrix produces duplicate job '%s'", key)
+		}
+
+		variant := combination.apply(job)
+		variant.Name = combination.matrixName(job.Name, usesMatrix)
+		variant.MatrixOf = jobID
+		if s, ok := ghJob.ContinueOnError.(string); ok {
+			variant.ContinueOnErr = p.parseContinueOnError(combination.substitute(s))
+		}
+		ids = append(ids, key)
+		jobs[key] = variant
+	}
+	return ids, jobs, nil
+}
+
+// apply returns a copy of a job with the matrix references of the
+// combination substituted. The copy keeps fail-fast and max-parallel,
+// which apply to the combinations together.
+func (c *matrixCombination) apply(job *types.Job) *types.Job {
+	variant := *job
+	variant.RunsOn = c.substitute(job.RunsOn)
+	variant.If = c.condition(job.If)
+	variant.Environment = c.substituteMap(job.Environment)
+	variant.Outputs = c.substituteMap(job.Outputs)
+	variant.EnvironmentName = c.substitute(job.EnvironmentName)
+
+	if job.Strategy != nil {
+		variant.Strategy = &types.Strategy{
+			FailFast:    job.Strategy.FailFast,
+			MaxParallel: job.Strategy.MaxParallel,
+		}
+	}
+
+	if job.Container != nil {
+		container := *job.Container
+		container.Image = c.substitute(container.Image)
+		container.Env = c.substituteMap(container.Env)
+		container.Options = c.substitute(container.Options)
+		variant.Container = &container
+	}
+
+	if job.Services != nil {
+		variant.Services = make(map[string]*types.Service, len(job.Services))
+		for name, svc := range job.Services {
+			service := *svc
+			service.Image = c.substitute(service.Image)
+			service.Env = c.substituteMap(service.Env)
+			service.Options = c.substitute(service.Options)
+			variant.Services[name] = &service
+		}
+	}
+
+	variant.Steps = make([]types.Step, len(job.Steps))
+	for i, step := range job.Steps {
+		step.Name = c.substitute(step.Name)
+		step.Run = c.substitute(step.Run)
+		step.Uses = c.substitute(step.Uses)
+		step.With = c.substituteMap(step.With)
+		step.Env = c.substituteMap(step.Env)
+		step.If = c.condition(step.If)
+		step.WorkingDir = c.substitute(step.WorkingDir)
+		step.Shell = c.substitute(step.Shell)
+		variant.Steps[i] = step
+	}
+	return &variant
+}
@@ -1,27 +1,165 @@
 package parsers
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
 	"os"
 	"path/filepath"
-	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/sanix-darker/git-ci/internal/rules"
 	"github.com/sanix-darker/git-ci/pkg/types"
 	yaml "gopkg.in/yaml.v3"
 )
 
 type GitlabParser struct {
 	baseDir      string
-	includeCache map[string]*GitlabCI
+	includeCache map[string]*cachedInclude // Keyed by absolute, cleaned path or URL
+	noCache      bool
+	fsys         fs.FS // Read files from here instead of the OS when set
+	httpClient   *http.Client
+	remoteDir    string // On-disk copies of remote includes ("" = none)
+	offline      bool   // Remote includes only come from remoteDir
+
+	variables    map[string]string // Pipeline variables given to the parser (predefined, --env)
+	includeVars  map[string]string // Variables include rules see during Parse
+	includeStack []string          // Includes being merged, root file first
+
+	// With mergeAnchors, local includes are decoded with the root file so
+	// they share anchors; anchorDocs holds the decoded files by key
+	mergeAnchors bool
+	anchorDocs   map[string]*decodedFile
+
+	// Strict checks of the keys and keyword values (SetStrict), and the
+	// problems they found, reported by Validate
+	strict         bool
+	strictProblems []string
 }
 
+// cachedInclude is a parsed include with its own includes merged, and
+// the keys of those nested includes
+type cachedInclude struct {
+	ci     *GitlabCI
+	nested []string
+}
+
+// decodedFile is a decoded GitLab file and its top-level keys in order
+type decodedFile struct {
+	data map[string]interface{}
+	keys []string
+}
+
+// DefaultIncludeTimeout bounds the fetch of a remote include
+const DefaultIncludeTimeout = 30 * time.Second
+
+// DefaultTemplateBaseURL is where `include: template:` files are fetched
+// from, the template catalog of the GitLab repository
+const DefaultTemplateBaseURL = "https://gitlab.com/gitlab-org/gitlab/-/raw/master/lib/gitlab/ci/templates/"
+
 // NewGitlabParser creates a new GitLab CI parser
 func NewGitlabParser() *GitlabParser {
 	return &GitlabParser{
-		includeCache: make(map[string]*GitlabCI),
+		includeCache: make(map[string]*cachedInclude),
+		httpClient:   &http.Client{Timeout: includeTimeoutFromEnv()},
+	}
+}
+
+// SetIncludeTimeout sets the timeout of remote include fetches
+func (p *GitlabParser) SetIncludeTimeout(timeout time.Duration) {
+	p.httpClient = &http.Client{Timeout: timeout}
+}
+
+// includeTimeoutFromEnv reads GIT_CI_INCLUDE_TIMEOUT ("10s" or seconds)
+func includeTimeoutFromEnv() time.Duration {
+	value := os.Getenv("GIT_CI_INCLUDE_TIMEOUT")
+	if value == "" {
+		return DefaultIncludeTimeout
+	}
+	if timeout, err := time.ParseDuration(value); err == nil {
+		return timeout
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	return DefaultIncludeTimeout
+}
+
+// SetNoCache disables reading and writing the include cache
+func (p *GitlabParser) SetNoCache(disabled bool) {
+	p.noCache = disabled
+}
+
+// SetRemoteCacheDir keeps a copy of each remote include in dir, reused in
+// offline mode
+func (p *GitlabParser) SetRemoteCacheDir(dir string) {
+	p.remoteDir = dir
+}
+
+// SetOffline makes remote and template includes come from the on-disk
+// cache only; an include missing from it fails the parse
+func (p *GitlabParser) SetOffline(offline bool) {
+	p.offline = offline
+}
+
+// SetMergeAnchors makes YAML anchors defined in a local include usable
+// in the files merged after it. Included files are then parsed again on
+// each Parse instead of coming from the include cache.
+func (p *GitlabParser) SetMergeAnchors(enabled bool) {
+	p.mergeAnchors = enabled
+}
+
+// SetVariables sets the variables available to include rules besides the
+// global variables of the file (predefined CI variables, --env)
+func (p *GitlabParser) SetVariables(vars map[string]string) {
+	p.variables = vars
+}
+
+// templateURL returns the URL of a GitLab CI template
+// (GIT_CI_TEMPLATE_URL overrides the catalog location)
+func templateURL(template string) string {
+	base := os.Getenv("GIT_CI_TEMPLATE_URL")
+	if base == "" {
+		base = DefaultTemplateBaseURL
+	}
+	return strings.TrimSuffix(base, "/") + "/" + strings.TrimPrefix(template, "/")
+}
+
+// SetFS makes the parser read files from fsys, with paths taken relative
+// to its root. A nil fsys reads from the OS again.
+func (p *GitlabParser) SetFS(fsys fs.FS) {
+	p.fsys = fsys
+}
+
+// readFile reads a file through the configured file system
+func (p *GitlabParser) readFile(path string) ([]byte, error) {
+	if p.fsys != nil {
+		return fs.ReadFile(p.fsys, p.cacheKey(path))
+	}
+	return os.ReadFile(path)
+}
+
+// cacheKey normalizes a path so relative and absolute spellings of the
+// same file share one include cache entry
+func (p *GitlabParser) cacheKey(path string) string {
+	if p.fsys != nil {
+		key := strings.TrimPrefix(filepath.ToSlash(filepath.Clean(path)), "/")
+		if key == "" {
+			return "."
+		}
+		return key
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
 	}
+	return filepath.Clean(path)
 }
 
 // GitLab CI structures with full feature support
@@ -46,6 +184,17 @@ type GitlabCI struct {
 
 	// Jobs - everything else that's not a keyword
 	Jobs map[string]*GitlabJob `yaml:",inline"`
+
+	// Raw definitions kept to resolve `extends`: hidden jobs (starting
+	// with .) are templates only, RawJobs are the regular jobs
+	HiddenJobs map[string]map[string]interface{} `yaml:"-"`
+	RawJobs    map[string]map[string]interface{} `yaml:"-"`
+
+	// Names of the regular jobs in file order, included files last
+	JobOrder []string `yaml:"-"`
+
+	// Raw local-only job hints (x-git-ci or .x-git-ci)
+	LocalHints interface{} `yaml:"-"`
 }
 
 type GitlabWorkflow struct {
@@ -53,16 +202,17 @@ type GitlabWorkflow struct {
 }
 
 type GitlabDefault struct {
-	Image         interface{}      `yaml:"image,omitempty"`
-	Services      []interface{}    `yaml:"services,omitempty"`
-	BeforeScript  []interface{}    `yaml:"before_script,omitempty"`
-	AfterScript   []interface{}    `yaml:"after_script,omitempty"`
-	Tags          []string         `yaml:"tags,omitempty"`
-	Cache         interface{}      `yaml:"cache,omitempty"`
-	Artifacts     *GitlabArtifacts `yaml:"artifacts,omitempty"`
-	Retry         interface{}      `yaml:"retry,omitempty"`
-	Timeout       string           `yaml:"timeout,omitempty"`
-	Interruptible bool             `yaml:"interruptible,omitempty"`
+	Image         interface{}            `yaml:"image,omitempty"`
+	Services      []interface{}          `yaml:"services,omitempty"`
+	BeforeScript  []interface{}          `yaml:"before_script,omitempty"`
+	AfterScript   []interface{}          `yaml:"after_script,omitempty"`
+	Tags          []string               `yaml:"tags,omitempty"`
+	Cache         interface{}            `yaml:"cache,omitempty"`
+	Artifacts     *GitlabArtifacts       `yaml:"artifacts,omitempty"`
+	Retry         interface{}            `yaml:"retry,omitempty"`
+	Timeout       string                 `yaml:"timeout,omitempty"`
+	Interruptible bool                   `yaml:"interruptible,omitempty"`
+	IDTokens      map[string]interface{} `yaml:"id_tokens,omitempty"`
 }
 
 type GitlabJob struct {
@@ -80,6 +230,7 @@ type GitlabJob struct {
 
 	// Job behavior
 	When         string      `yaml:"when,omitempty"`
+	StartIn      string      `yaml:"start_in,omitempty"`
 	Manual       bool        `yaml:"manual,omitempty"`
 	AllowFailure interface{} `yaml:"allow_failure,omitempty"`
 	Retry        interface{} `yaml:"retry,omitempty"`
@@ -90,13 +241,18 @@ type GitlabJob struct {
 	AfterScript  []interface{} `yaml:"after_script,omitempty"`
 
 	// Variables and secrets
-	Variables        map[string]interface{} `yaml:"variables,omitempty"`
-	Secrets          map[string]interface{} `yaml:"secrets,omitempty"`
-	InheritVariables *bool                  `yaml:"inherit,omitempty"`
+	Variables map[string]interface{} `yaml:"variables,omitempty"`
+	Secrets   map[string]interface{} `yaml:"secrets,omitempty"`
+	IDTokens  map[string]interface{} `yaml:"id_tokens,omitempty"`
+
+	// Which global defaults the job gets
+	Inherit *GitlabInherit `yaml:"inherit,omitempty"`
 
 	// Dependencies
-	Needs        interface{} `yaml:"needs,omitempty"`
-	Dependencies []string    `yaml:"dependencies,omitempty"`
+	Needs           interface{} `yaml:"needs,omitempty"`
+	NeedsSet        bool        `yaml:"-"` // needs key present (even if empty)
+	Dependencies    []string    `yaml:"dependencies,omitempty"`
+	DependenciesSet bool        `yaml:"-"` // dependencies key present (even if empty)
 
 	// Artifacts and cache
 	Artifacts *GitlabArtifacts `yaml:"artifacts,omitempty"`
@@ -118,7 +274,11 @@ type GitlabJob struct {
 	Release *GitlabRelease `yaml:"release,omitempty"`
 
 	// Pages
-	Pages interface{} `yaml:"pages,omitempty"`
+	Pages   interface{} `yaml:"pages,omitempty"`
+	Publish string      `yaml:"publish,omitempty"`
+
+	// DAST site and scanner profiles
+	DastConfiguration map[string]interface{} `yaml:"dast_configuration,omitempty"`
 
 	// Downstream pipelines
 	Trigger interface{} `yaml:"trigger,omitempty"`
@@ -130,6 +290,13 @@ type GitlabJob struct {
 	Interruptible *bool `yaml:"interruptible,omitempty"`
 }
 
+// GitlabInherit is a job's `inherit:`. Each key is true/false or the list
+// of names to inherit.
+type GitlabInherit struct {
+	Default   interface{} `yaml:"default,omitempty"`
+	Variables interface{} `yaml:"variables,omitempty"`
+}
+
 type GitlabRule struct {
 	If           string                 `yaml:"if,omitempty"`
 	Changes      interface{}            `yaml:"changes,omitempty"`
@@ -203,14 +370,12 @@ type GitlabAssetLink struct {
 func (p *GitlabParser) Parse(ciFilePath string) (*types.Pipeline, error) {
 	p.baseDir = filepath.Dir(ciFilePath)
 
-	// Check if file exists
-	if _, err := os.Stat(ciFilePath); os.IsNotExist(err) {
-		return nil, fmt.Errorf("GitLab CI file not found: %s", ciFilePath)
-	}
-
 	// Read file content
-	data, err := os.ReadFile(ciFilePath)
+	data, err := p.readFile(ciFilePath)
 	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("GitLab CI file not found: %s", ciFilePath)
+		}
 		return nil, fmt.Errorf("failed to read GitLab CI file: %w", err)
 	}
 
@@ -220,21 +385,63 @@ func (p *GitlabParser) Parse(ciFilePath string) (*types.Pipeline, error) {
 	}
 
 	// Parse YAML into raw map first
-	var rawData map[string]interface{}
-	if err := yaml.Unmarshal(data, &rawData); err != nil {
+	var root *decodedFile
+	p.anchorDocs = nil
+	p.strictProblems = nil
+	if p.mergeAnchors {
+		docs, err := p.mergedAnchorDocs(ciFilePath, data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse YAML: %w", err)
+		}
+		p.anchorDocs = docs
+		root = docs[p.cacheKey(ciFilePath)]
+	} else if root, err = p.decodeFile(data); err != nil {
 		return nil, fmt.Errorf("failed to parse YAML: %w", err)
 	}
 
+	// Resolve !reference tags of the global sections
+	if err := p.resolveGlobalReferences(root.data); err != nil {
+		return nil, fmt.Errorf("validation failed: %w", err)
+	}
+
 	// Extract GitLab CI structure
-	gitlabCI := p.parseRawData(rawData)
+	gitlabCI := p.parseRawData(root)
 
-	// Process includes if any
-	if err := p.processIncludes(gitlabCI); err != nil {
+	// Process includes if any. The root file counts as visited so an
+	// include pointing back at it is not read again.
+	visited := map[string]bool{p.cacheKey(ciFilePath): true}
+	p.includeStack = []string{p.cacheKey(ciFilePath)}
+	p.includeVars = p.convertVariables(gitlabCI.Variables)
+	for k, v := range p.variables {
+		p.includeVars[k] = v
+	}
+	if err := p.processIncludes(gitlabCI, visited); err != nil {
 		return nil, fmt.Errorf("failed to process includes: %w", err)
 	}
 
+	// Resolve !reference tags and merge extended jobs, now that included
+	// templates are known
+	if err := p.resolveReferences(gitlabCI); err != nil {
+		return nil, fmt.Errorf("validation failed: %w", err)
+	}
+	if err := p.resolveExtends(gitlabCI); err != nil {
+		return nil, fmt.Errorf("validation failed: %w", err)
+	}
+
 	// Convert to generic Pipeline
-	pipeline := p.convertToPipeline(gitlabCI)
+	pipeline, err := p.convertToPipeline(gitlabCI)
+	if err != nil {
+		return nil, fmt.Errorf("validation failed: %w", err)
+	}
+
+	// Attach local-only hints
+	hints, err := decodeLocalHints(gitlabCI.LocalHints)
+	if err != nil {
+		return nil, err
+	}
+	if err := attachLocalHints(pipeline, hints); err != nil {
+		return nil, fmt.Errorf("validation failed: %w", err)
+	}
 
 	// Validate the pipeline
 	if err := p.Validate(pipeline); err != nil {
@@ -245,9 +452,12 @@ func (p *GitlabParser) Parse(ciFilePath string) (*types.Pipeline, error) {
 }
 
 // parseRawData converts raw YAML data to GitlabCI structure
-func (p *GitlabParser) parseRawData(rawData map[string]interface{}) *GitlabCI {
+func (p *GitlabParser) parseRawData(file *decodedFile) *GitlabCI {
+	rawData := file.data
 	ci := &GitlabCI{
-		Jobs: make(map[string]*GitlabJob),
+		Jobs:       make(map[string]*GitlabJob),
+		HiddenJobs: make(map[string]map[string]interface{}),
+		RawJobs:    make(map[string]map[string]interface{}),
 	}
 
 	// Reserved keywords that are not jobs
@@ -255,7 +465,7 @@ func (p *GitlabParser) parseRawData(rawData map[string]interface{}) *GitlabCI {
 		"image": true, "services": true, "stages": true,
 		"variables": true, "cache": true, "before_script": true,
 		"after_script": true, "workflow": true, "include": true,
-		"default": true,
+		"default": true, LocalHintsKey: true, "." + LocalHintsKey: true,
 	}
 
 	// Process global configuration
@@ -301,18 +511,51 @@ func (p *GitlabParser) parseRawData(rawData map[string]interface{}) *GitlabCI {
 		ci.Default = p.parseDefault(defaultConfig)
 	}
 
+	// Local-only hints, preferably hidden so GitLab ignores them
+	if hints := rawData["."+LocalHintsKey]; hints != nil {
+		ci.LocalHints = hints
+	} else if hints := rawData[LocalHintsKey]; hints != nil {
+		ci.LocalHints = hints
+	}
+
 	// Process jobs (everything that's not a reserved keyword)
 	for name, jobData := range rawData {
-		// Skip reserved keywords and hidden jobs (starting with .)
-		if reservedKeywords[name] || strings.HasPrefix(name, ".") {
+		if reservedKeywords[name] {
 			continue
 		}
 
-		if jobMap, ok := jobData.(map[string]interface{}); ok {
-			job := p.parseJob(jobMap)
-			if job != nil {
-				ci.Jobs[name] = job
+		jobMap, ok := jobData.(map[string]interface{})
+		if !ok {
+			// Hidden keys may hold anything, anchors to reuse often
+			if !strings.HasPrefix(name, ".") {
+				p.strictProblem("top-level key '%s' is neither a keyword nor a job (a job is a hash)", name)
 			}
+			continue
+		}
+
+		// Hidden jobs are only templates for extends
+		if strings.HasPrefix(name, ".") {
+			ci.HiddenJobs[name] = jobMap
+			continue
+		}
+
+		ci.RawJobs[name] = jobMap
+
+		// Jobs using extends or !reference are parsed once every
+		// definition, included ones too, is known
+		if jobMap["extends"] != nil || containsReference(jobMap) {
+			continue
+		}
+
+		job := p.parseJob(name, jobMap)
+		if job != nil {
+			ci.Jobs[name] = job
+		}
+	}
+
+	for _, name := range file.keys {
+		if _, ok := ci.RawJobs[name]; ok {
+			ci.JobOrder = append(ci.JobOrder, name)
 		}
 	}
 
@@ -320,7 +563,8 @@ func (p *GitlabParser) parseRawData(rawData map[string]interface{}) *GitlabCI {
 }
 
 // parseJob parses a GitLab job definition
-func (p *GitlabParser) parseJob(jobData map[string]interface{}) *GitlabJob {
+func (p *GitlabParser) parseJob(name string, jobData map[string]interface{}) *GitlabJob {
+	p.checkJobKeys(name, jobData)
 	job := &GitlabJob{}
 
 	// Parse basic fields
@@ -340,8 +584,10 @@ func (p *GitlabParser) parseJob(jobData map[string]interface{}) *GitlabJob {
 	if script := jobData["script"]; script != nil {
 		job.Script = p.parseScriptArray(script)
 	} else {
-		// Job without script is not valid (unless it's a trigger or pages job)
-		if jobData["trigger"] == nil && jobData["pages"] == nil {
+		// Job without script is not valid, unless it's a trigger job or a
+		// pages job publishing artifacts built earlier
+		pages := jobData["pages"] != nil || (name == "pages" && jobData["artifacts"] != nil)
+		if jobData["trigger"] == nil && !pages {
 			return nil
 		}
 	}
@@ -364,6 +610,10 @@ func (p *GitlabParser) parseJob(jobData map[string]interface{}) *GitlabJob {
 		job.When = when
 	}
 
+	if startIn, ok := jobData["start_in"].(string); ok {
+		job.StartIn = startIn
+	}
+
 	if manual, ok := jobData["manual"].(bool); ok {
 		job.Manual = manual
 	}
@@ -373,10 +623,12 @@ func (p *GitlabParser) parseJob(jobData map[string]interface{}) *GitlabJob {
 	// Parse dependencies
 	if needs := jobData["needs"]; needs != nil {
 		job.Needs = needs
+		job.NeedsSet = true
 	}
 
 	if dependencies, ok := jobData["dependencies"].([]interface{}); ok {
 		job.Dependencies = p.parseStringArray(dependencies)
+		job.DependenciesSet = true
 	}
 
 	// Parse rules
@@ -385,11 +637,11 @@ func (p *GitlabParser) parseJob(jobData map[string]interface{}) *GitlabJob {
 	}
 
 	// Parse only/except (deprecated but still supported)
-	if only, ok := jobData["only"].(map[string]interface{}); ok {
+	if only, ok := jobData["only"]; ok {
 		job.Only = p.parseOnlyExcept(only)
 	}
 
-	if except, ok := jobData["except"].(map[string]interface{}); ok {
+	if except, ok := jobData["except"]; ok {
 		job.Except = p.parseOnlyExcept(except)
 	}
 
@@ -407,9 +659,7 @@ func (p *GitlabParser) parseJob(jobData map[string]interface{}) *GitlabJob {
 	}
 
 	// Parse timeout
-	if timeout, ok := jobData["timeout"].(string); ok {
-		job.Timeout = timeout
-	}
+	job.Timeout = timeoutString(jobData["timeout"])
 
 	// Parse retry
 	job.Retry = jobData["retry"]
@@ -438,6 +688,14 @@ func (p *GitlabParser) parseJob(jobData map[string]interface{}) *GitlabJob {
 		job.Interruptible = &interruptible
 	}
 
+	// Parse inherit
+	if inherit, ok := jobData["inherit"].(map[string]interface{}); ok {
+		job.Inherit = &GitlabInherit{
+			Default:   inherit["default"],
+			Variables: inherit["variables"],
+		}
+	}
+
 	// Parse extends
 	job.Extends = jobData["extends"]
 
@@ -446,6 +704,10 @@ func (p *GitlabParser) parseJob(jobData map[string]interface{}) *GitlabJob {
 		job.Secrets = secrets
 	}
 
+	if idTokens, ok := jobData["id_tokens"].(map[string]interface{}); ok {
+		job.IDTokens = idTokens
+	}
+
 	// Parse release
 	if release, ok := jobData["release"].(map[string]interface{}); ok {
 		job.Release = p.parseRelease(release)
@@ -453,32 +715,46 @@ func (p *GitlabParser) parseJob(jobData map[string]interface{}) *GitlabJob {
 
 	// Parse pages
 	job.Pages = jobData["pages"]
+	if publish, ok := jobData["publish"].(string); ok {
+		job.Publish = publish
+	}
+
+	if dast, ok := jobData["dast_configuration"].(map[string]interface{}); ok {
+		job.DastConfiguration = dast
+	}
 
 	return job
 }
 
 // convertToPipeline converts GitLab CI to generic Pipeline
-func (p *GitlabParser) convertToPipeline(ci *GitlabCI) *types.Pipeline {
+func (p *GitlabParser) convertToPipeline(ci *GitlabCI) (*types.Pipeline, error) {
 	pipeline := &types.Pipeline{
 		Name:        "GitLab CI Pipeline",
 		Provider:    "gitlab",
 		Jobs:        make(map[string]*types.Job),
 		Stages:      ci.Stages,
 		Environment: p.convertVariables(ci.Variables),
+		Variables:   p.convertVariableDefinitions(ci.Variables),
 	}
 
-	// Extract pipeline name from workflow if available
+	// Workflow rules decide whether the pipeline is created at all
 	if ci.Workflow != nil && len(ci.Workflow.Rules) > 0 {
 		pipeline.Description = "GitLab CI Workflow"
+		pipeline.Rules = p.convertRules(ci.Workflow.Rules)
 	}
 
 	// Set global defaults
-	var globalImage string
+	var globalImage interface{}
+	var globalServices []interface{}
 	var globalBeforeScript []string
 	var globalAfterScript []string
 
 	if ci.Image != nil {
-		globalImage = p.parseImage(ci.Image)
+		globalImage = ci.Image
+	}
+
+	if ci.Services != nil {
+		globalServices = ci.Services
 	}
 
 	if ci.BeforeScript != nil {
@@ -492,7 +768,10 @@ func (p *GitlabParser) convertToPipeline(ci *GitlabCI) *types.Pipeline {
 	// Apply defaults if specified
 	if ci.Default != nil {
 		if ci.Default.Image != nil {
-			globalImage = p.parseImage(ci.Default.Image)
+			globalImage = ci.Default.Image
+		}
+		if ci.Default.Services != nil {
+			globalServices = ci.Default.Services
 		}
 		if ci.Default.BeforeScript != nil {
 			globalBeforeScript = p.convertScriptToStrings(ci.Default.BeforeScript)
@@ -504,122 +783,324 @@ func (p *GitlabParser) convertToPipeline(ci *GitlabCI) *types.Pipeline {
 
 	// Process jobs
 	for jobName, glJob := range ci.Jobs {
-		job := p.convertJob(jobName, glJob, globalImage, globalBeforeScript, globalAfterScript)
+		job := p.convertJob(jobName, glJob, globalImage, globalServices, globalBeforeScript, globalAfterScript, ci.Default)
+		job.ExcludedVariables = p.excludedVariables(glJob.Inherit, ci.Variables)
 		pipeline.Jobs[jobName] = job
 	}
 
-	// If no stages defined, create them from jobs
+	// Expand parallel:matrix jobs into one job per combination
+	if err := p.expandMatrixJobs(pipeline); err != nil {
+		return nil, err
+	}
+
+	// If no stages defined, create them from jobs. Declared stages get
+	// the implicit .pre and .post around them.
 	if len(pipeline.Stages) == 0 {
-		pipeline.Stages = p.extractStages(ci.Jobs)
+		pipeline.Stages = p.extractStages(ci)
+	} else {
+		pipeline.Stages = withImplicitStages(pipeline.Stages, pipeline.Jobs)
+	}
+
+	return pipeline, nil
+}
+
+// maxMatrixJobs caps the jobs a single parallel:matrix expands to, as
+// GitLab does
+const maxMatrixJobs = 200
+
+// expandMatrixJobs replaces each job with a parallel:matrix by one job per
+// combination, named `job: [value1, value2]` with the values ordered by
+// variable name. The values are set in the job's environment, and needs
+// or dependencies on the original job point at all of its variants.
+func (p *GitlabParser) expandMatrixJobs(pipeline *types.Pipeline) error {
+	variants := jobVariants{}
+
+	names := make([]string, 0, len(pipeline.Jobs))
+	for name := range pipeline.Jobs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		job := pipeline.Jobs[name]
+		if job.Parallel == nil || len(job.Parallel.Matrix) == 0 {
+			continue
+		}
+
+		combinations, err := p.matrixCombinations(job.Parallel.Matrix)
+		if err != nil {
+			return fmt.Errorf("job '%s': %w", name, err)
+		}
+
+		delete(pipeline.Jobs, name)
+		for _, combination := range combinations {
+			variantName := p.matrixJobName(name, combination)
+			if _, exists := pipeline.Jobs[variantName]; exists {
+				return fmt.Errorf("job '%s': parallel:matrix produces duplicate job '%s'", name, variantName)
+			}
+
+			variant := *job
+			variant.Name = variantName
+			variant.Parallel = nil
+			variant.Environment = make(map[string]string, len(job.Environment)+len(combination))
+			for k, v := range job.Environment {
+				variant.Environment[k] = v
+			}
+			for k, v := range combination {
+				variant.Environment[k] = v
+			}
+			if job.Deployment != nil {
+				// The environment name may use the matrix variables
+				deployment := *job.Deployment
+				variant.Deployment = &deployment
+			}
+
+			pipeline.Jobs[variantName] = &variant
+			variants.add(name, variantName)
+		}
+	}
+
+	variants.resolveNeeds(pipeline)
+	return nil
+}
+
+// matrixCombinations returns the variable sets of a parallel:matrix. Each
+// entry contributes the cartesian product of its variables' values.
+func (p *GitlabParser) matrixCombinations(matrix []map[string]interface{}) ([]map[string]string, error) {
+	total := 0
+	for _, entry := range matrix {
+		count := 1
+		for _, values := range entry {
+			count *= len(p.matrixValues(values))
+		}
+		total += count
+	}
+	if total > maxMatrixJobs {
+		return nil, fmt.Errorf("parallel:matrix expands to %d jobs, more than the limit of %d", total, maxMatrixJobs)
+	}
+
+	var result []map[string]string
+	for _, entry := range matrix {
+		keys := make([]string, 0, len(entry))
+		for key := range entry {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		combinations := []map[string]string{{}}
+		for _, key := range keys {
+			var next []map[string]string
+			for _, combination := range combinations {
+				for _, value := range p.matrixValues(entry[key]) {
+					extended := make(map[string]string, len(combination)+1)
+					for k, v := range combination {
+						extended[k] = v
+					}
+					extended[key] = value
+					next = append(next, extended)
+				}
+			}
+			combinations = next
+		}
+
+		if len(keys) > 0 {
+			result = append(result, combinations...)
+		}
+	}
+
+	return result, nil
+}
+
+// matrixValues returns the values of a matrix variable, a scalar or a list
+func (p *GitlabParser) matrixValues(values interface{}) []string {
+	if list, ok := values.([]interface{}); ok {
+		result := make([]string, 0, len(list))
+		for _, v := range list {
+			result = append(result, fmt.Sprint(v))
+		}
+		return result
+	}
+	return []string{fmt.Sprint(values)}
+}
+
+// matrixJobName names a matrix variant like GitLab: `job: [v1, v2]`
+func (p *GitlabParser) matrixJobName(name string, combination map[string]string) string {
+	keys := make([]string, 0, len(combination))
+	for key := range combination {
+		keys = append(keys, key)
 	}
+	sort.Strings(keys)
 
-	return pipeline
+	values := make([]string, len(keys))
+	for i, key := range keys {
+		values[i] = combination[key]
+	}
+	return fmt.Sprintf("%s: [%s]", name, strings.Join(values, ", "))
 }
 
 // convertJob converts GitLab job to generic Job
 func (p *GitlabParser) convertJob(
 	jobName string,
 	glJob *GitlabJob,
-	globalImage string,
+	globalImage interface{},
+	globalServices []interface{},
 	globalBeforeScript []string,
 	globalAfterScript []string,
+	defaults *GitlabDefault,
 ) *types.Job {
+	// As on GitLab, a job without stage runs in the test stage
+	stage := glJob.Stage
+	if stage == "" {
+		stage = defaultJobStage
+	}
+
 	job := &types.Job{
 		Name:        jobName,
-		Stage:       glJob.Stage,
+		Stage:       stage,
 		Environment: p.convertVariables(glJob.Variables),
 		Tags:        glJob.Tags,
 		When:        glJob.When,
+		StartIn:     glJob.StartIn,
+	}
+
+	// `inherit: default` keeps some or none of the defaults, the global
+	// image, services, cache and scripts included
+	inherits := p.inheritsDefault(glJob.Inherit)
+	if !inherits("image") {
+		globalImage = nil
+	}
+	if !inherits("services") {
+		globalServices = nil
+	}
+	if !inherits("before_script") {
+		globalBeforeScript = nil
+	}
+	if !inherits("after_script") {
+		globalAfterScript = nil
 	}
 
-	// Set image/runs-on
-	if glJob.Image != nil {
-		job.Image = p.parseImage(glJob.Image)
+	// Settings the job doesn't specify fall back to `default:`
+	timeout, retry, artifacts, cache := glJob.Timeout, glJob.Retry, glJob.Artifacts, glJob.Cache
+	if defaults != nil {
+		if len(job.Tags) == 0 && inherits("tags") {
+			job.Tags = defaults.Tags
+		}
+		if timeout == "" && inherits("timeout") {
+			timeout = defaults.Timeout
+		}
+		if retry == nil && inherits("retry") {
+			retry = defaults.Retry
+		}
+		if artifacts == nil && inherits("artifacts") {
+			artifacts = defaults.Artifacts
+		}
+		if cache == nil && inherits("cache") {
+			cache = defaults.Cache
+		}
+	}
+
+	// Set image/runs-on; the job image replaces the global one
+	image := glJob.Image
+	if image == nil {
+		image = globalImage
+	}
+	if image != nil {
+		job.Image = p.parseImage(image)
 		job.RunsOn = job.Image
-	} else if globalImage != "" {
-		job.Image = globalImage
-		job.RunsOn = globalImage
-	} else if len(glJob.Tags) > 0 {
-		job.RunsOn = glJob.Tags[0]
+	} else if len(job.Tags) > 0 {
+		job.RunsOn = job.Tags[0]
 	} else {
 		job.RunsOn = "gitlab-runner"
 	}
 
+	// Job services are added to the global ones
+	var services []interface{}
+	services = append(services, globalServices...)
+	services = append(services, glJob.Services...)
+
 	// Parse container configuration
-	if glJob.Image != nil || glJob.Services != nil {
+	if image != nil || len(services) > 0 {
 		job.Container = &types.Container{
-			Image: job.Image,
+			Image:      job.Image,
+			Entrypoint: p.parseImageEntrypoint(image),
 		}
 
 		// Add services
-		if glJob.Services != nil {
-			job.Services = p.convertServices(glJob.Services)
+		if len(services) > 0 {
+			job.Services = p.convertServices(services)
 		}
 	}
 
-	// Handle allow_failure
-	switch v := glJob.AllowFailure.(type) {
-	case bool:
-		job.AllowFailure = v
-		job.ContinueOnErr = v
-	case map[string]interface{}:
-		// Complex allow_failure with exit_codes
-		job.AllowFailure = true
-		job.ContinueOnErr = true
-	}
+	// Handle allow_failure; with exit_codes, only those are soft failures
+	job.AllowFailure, job.AllowExitCodes = p.parseAllowFailure(glJob.AllowFailure)
+	job.ContinueOnErr = job.AllowFailure
 
 	// Parse timeout
-	if glJob.Timeout != "" {
-		if minutes := p.parseTimeout(glJob.Timeout); minutes > 0 {
+	if timeout != "" {
+		if minutes := p.parseTimeout(timeout); minutes > 0 {
 			job.TimeoutMin = minutes
 		}
 	}
 
 	// Parse retry
-	if glJob.Retry != nil {
-		job.Retry = p.parseRetry(glJob.Retry)
+	if retry != nil {
+		job.Retry = p.parseRetry(retry)
 	}
 
 	// Parse needs
 	job.Needs = p.parseNeeds(glJob.Needs)
-	if len(job.Needs) == 0 && len(glJob.Dependencies) > 0 {
-		job.Needs = glJob.Dependencies
+	job.NeedsSet = glJob.NeedsSet
+	if !job.NeedsSet && len(glJob.Dependencies) > 0 {
+		job.Needs = types.NeedsOf(glJob.Dependencies...)
 	}
 
+	// Keep dependencies separately: they decide which artifacts are received
+	job.Dependencies = glJob.Dependencies
+	job.DependenciesSet = glJob.DependenciesSet
+
 	// Parse parallel
 	if glJob.Parallel != nil {
 		job.Parallel = p.parseParallel(glJob.Parallel)
 	}
 
 	// Parse artifacts
-	if glJob.Artifacts != nil {
-		job.Artifacts = p.convertArtifacts(glJob.Artifacts)
+	if artifacts != nil {
+		job.Artifacts = p.convertArtifacts(artifacts)
 	}
 
-	// Parse cache
-	if glJob.Cache != nil {
-		job.Cache = p.parseCache(glJob.Cache)
+	// Parse cache, a single block or a list of them
+	if cache != nil {
+		job.Caches = p.parseCaches(cache)
+		if len(job.Caches) > 0 {
+			job.Cache = job.Caches[0]
+		}
 	}
 
+	// Record the parents resolved by resolveExtends
+	job.Extends = p.parseExtends(glJob.Extends)
+
 	// Parse environment
-	if glJob.Environment != nil {
-		job.EnvironmentName = p.parseEnvironment(glJob.Environment)
+	if env := p.parseEnvironment(glJob.Environment); env != nil {
+		job.Deployment = env
+		job.EnvironmentName = env.Name
+		job.EnvironmentAction = env.Action
+		job.DeploymentTier = env.DeploymentTier
 	}
 
 	// Convert scripts to steps
 	job.Steps = p.convertScriptsToSteps(
+		jobName,
 		glJob,
 		globalBeforeScript,
 		globalAfterScript,
 	)
+	if dir := p.pagesDir(jobName, glJob); dir != "" && !artifactsInclude(job.Artifacts, dir) {
+		fmt.Fprintf(os.Stderr, "Warning: pages job '%s' does not keep %s/ in its artifacts\n", jobName, dir)
+	}
 
 	// Parse rules for conditional execution
 	if len(glJob.Rules) > 0 {
 		job.Rules = p.convertRules(glJob.Rules)
-		// Set If condition from first rule if available
-		if len(glJob.Rules) > 0 && glJob.Rules[0].If != "" {
-			job.If = glJob.Rules[0].If
-		}
 	}
 
 	// Parse only/except (deprecated but still supported)
@@ -635,17 +1116,84 @@ func (p *GitlabParser) convertJob(
 		job.Trigger = p.parseTrigger(glJob.Trigger)
 	}
 
-	// Set interruptible
+	job.Coverage = glJob.Coverage
+	job.ResourceGroup = glJob.ResourceGroup
+	job.SecretRefs = p.parseSecrets(glJob.Secrets)
+
+	// ID tokens fall back to `default:`
+	idTokens := glJob.IDTokens
+	if idTokens == nil && defaults != nil && inherits("id_tokens") {
+		idTokens = defaults.IDTokens
+	}
+	job.IDTokens = p.parseIDTokens(jobName, idTokens)
+
+	// Set interruptible, falling back to `default:`
 	if glJob.Interruptible != nil {
-		// Copy the value
-		job.ContinueOnErr = !*glJob.Interruptible
+		job.Interruptible = *glJob.Interruptible
+	} else if defaults != nil && inherits("interruptible") {
+		job.Interruptible = defaults.Interruptible
 	}
 
 	return job
 }
 
+// excludedVariables returns the global variables a job with the given
+// `inherit:` doesn't get: all of them with `variables: false`, the ones
+// not listed with a list. Names are sorted.
+func (p *GitlabParser) excludedVariables(inherit *GitlabInherit, globals map[string]interface{}) []string {
+	if inherit == nil {
+		return nil
+	}
+
+	var keep map[string]bool
+	switch v := inherit.Variables.(type) {
+	case bool:
+		if v {
+			return nil
+		}
+	case []interface{}:
+		keep = make(map[string]bool, len(v))
+		for _, name := range p.parseStringArray(v) {
+			keep[name] = true
+		}
+	default:
+		return nil
+	}
+
+	var excluded []string
+	for name := range globals {
+		if !keep[name] {
+			excluded = append(excluded, name)
+		}
+	}
+	sort.Strings(excluded)
+	return excluded
+}
+
+// inheritsDefault returns whether the job with the given `inherit:` gets
+// a `default:` keyword: `default: false` drops them all, a list keeps the
+// keywords listed
+func (p *GitlabParser) inheritsDefault(inherit *GitlabInherit) func(keyword string) bool {
+	if inherit == nil {
+		return func(string) bool { return true }
+	}
+
+	switch v := inherit.Default.(type) {
+	case bool:
+		return func(string) bool { return v }
+	case []interface{}:
+		keep := make(map[string]bool, len(v))
+		for _, keyword := range p.parseStringArray(v) {
+			keep[keyword] = true
+		}
+		return func(keyword string) bool { return keep[keyword] }
+	}
+	return func(string) bool { return true }
+}
+
 // convertScriptsToSteps converts GitLab scripts to generic Steps
 func (p *GitlabParser) convertScriptsToSteps(
+	jobName string,
 	job *GitlabJob,
 	globalBeforeScript []string,
 	globalAfterScript []string,
@@ -659,11 +1207,17 @@ func (p *GitlabParser) convertScriptsToSteps(
 		beforeScript = globalBeforeScript
 	}
 
+	// DAST profiles are applied before the job, like on GitLab
+	if step := p.dastStep(job.DastConfiguration); step != nil {
+		steps = append(steps, *step)
+	}
+
 	if len(beforeScript) > 0 {
 		steps = append(steps, types.Step{
 			Name:   "Before Script",
 			Run:    strings.Join(beforeScript, "\n"),
 			Script: beforeScript,
+			Phase:  types.StepPhaseBeforeScript,
 		})
 		stepCounter++
 	}
@@ -692,7 +1246,16 @@ func (p *GitlabParser) convertScriptsToSteps(
 		}
 	}
 
-	// Add after_script as steps
+	// The release is created once the script succeeded, and the pages
+	// site must have been built by then
+	if job.Release != nil {
+		steps = append(steps, p.releaseStep(job.Release))
+	}
+	if dir := p.pagesDir(jobName, job); dir != "" {
+		steps = append(steps, pagesStep(dir))
+	}
+
+	// Add after_script as steps, run even when the job fails
 	afterScript := p.convertScriptToStrings(job.AfterScript)
 	if len(afterScript) == 0 && len(globalAfterScript) > 0 {
 		afterScript = globalAfterScript
@@ -703,7 +1266,8 @@ func (p *GitlabParser) convertScriptsToSteps(
 			Name:          "After Script",
 			Run:           strings.Join(afterScript, "\n"),
 			Script:        afterScript,
-			ContinueOnErr: true, // after_script typically runs regardless
+			ContinueOnErr: true, // A failed after_script doesn't fail the job
+			Phase:         types.StepPhaseAfterScript,
 		})
 	}
 
@@ -725,7 +1289,16 @@ func (p *GitlabParser) parseStringArray(data []interface{}) []string {
 func (p *GitlabParser) parseScriptArray(data interface{}) []interface{} {
 	switch v := data.(type) {
 	case []interface{}:
-		return v
+		// Nested lists (anchors, references) are flattened
+		var result []interface{}
+		for _, item := range v {
+			if nested, ok := item.([]interface{}); ok {
+				result = append(result, p.parseScriptArray(nested)...)
+				continue
+			}
+			result = append(result, item)
+		}
+		return result
 	case string:
 		return []interface{}{v}
 	case []string:
@@ -771,14 +1344,17 @@ func (p *GitlabParser) convertServices(services []interface{}) map[string]*types
 			}
 		case map[string]interface{}:
 			svc := &types.Service{}
+			// GitLab names the service by its image; the alias, when
+			// given, is its host name
 			if name, ok := v["name"].(string); ok {
-				serviceName = name
+				svc.Image = name
 			}
 			if image, ok := v["image"].(string); ok {
 				svc.Image = image
 			}
 			if alias, ok := v["alias"].(string); ok {
 				svc.Alias = alias
+				serviceName = alias
 			}
 			if command, ok := v["command"].([]interface{}); ok {
 				svc.Command = p.parseStringArray(command)
@@ -786,6 +1362,15 @@ func (p *GitlabParser) convertServices(services []interface{}) map[string]*types
 			if entrypoint, ok := v["entrypoint"].([]interface{}); ok {
 				svc.Entrypoint = p.parseStringArray(entrypoint)
 			}
+			if variables, ok := v["variables"].(map[string]interface{}); ok {
+				svc.Env = p.convertVariables(variables)
+			}
+			switch policy := v["pull_policy"].(type) {
+			case string:
+				svc.PullPolicy = []string{policy}
+			case []interface{}:
+				svc.PullPolicy = p.parseStringArray(policy)
+			}
 			result[serviceName] = svc
 		}
 	}
@@ -793,6 +1378,20 @@ func (p *GitlabParser) convertServices(services []interface{}) map[string]*types
 	return result
 }
 
+// parseImageEntrypoint returns the entrypoint of an image given in the
+// map form (name, entrypoint)
+func (p *GitlabParser) parseImageEntrypoint(data interface{}) []string {
+	image, ok := data.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	entrypoint, ok := image["entrypoint"].([]interface{})
+	if !ok {
+		return nil
+	}
+	return p.parseStringArray(entrypoint)
+}
+
 func (p *GitlabParser) parseImage(data interface{}) string {
 	switch v := data.(type) {
 	case string:
@@ -805,37 +1404,31 @@ func (p *GitlabParser) parseImage(data interface{}) string {
 	return "alpine:latest"
 }
 
-func (p *GitlabParser) parseTimeout(timeout string) int {
-	// Parse GitLab timeout format (e.g., "30 minutes", "1h 30m", "30m")
-	timeout = strings.ToLower(timeout)
-
-	// Simple parsing for common formats
-	if strings.Contains(timeout, "hour") || strings.Contains(timeout, "h") {
-		// Extract hours
-		re := regexp.MustCompile(`(\d+)\s*(hours?|h)`)
-		if matches := re.FindStringSubmatch(timeout); len(matches) > 1 {
-			if hours, err := strconv.Atoi(matches[1]); err == nil {
-				return hours * 60
-			}
-		}
+// timeoutString returns a timeout given as a string or a bare number
+func timeoutString(timeout interface{}) string {
+	switch v := timeout.(type) {
+	case string:
+		return v
+	case int, float64:
+		return fmt.Sprint(v)
 	}
+	return ""
+}
 
-	if strings.Contains(timeout, "minute") || strings.Contains(timeout, "m") {
-		// Extract minutes
-		re := regexp.MustCompile(`(\d+)\s*(minutes?|m)`)
-		if matches := re.FindStringSubmatch(timeout); len(matches) > 1 {
-			if minutes, err := strconv.Atoi(matches[1]); err == nil {
-				return minutes
-			}
-		}
+// parseTimeout converts a job timeout to minutes, rounding up; a bare
+// number is a number of minutes. Invalid values are reported and ignored.
+func (p *GitlabParser) parseTimeout(timeout string) int {
+	// ParseDuration reads a bare number as seconds, a job timeout reads it
+	// as minutes: spell the unit out before parsing
+	if n, err := strconv.ParseFloat(strings.TrimSpace(timeout), 64); err == nil {
+		timeout = fmt.Sprintf("%g minutes", n)
 	}
-
-	// Try to parse as simple number (assumes minutes)
-	if minutes, err := strconv.Atoi(timeout); err == nil {
-		return minutes
+	d, err := ParseDuration(timeout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: ignoring timeout: %v\n", err)
+		return 0
 	}
-
-	return 0
+	return int((d + time.Minute - 1) / time.Minute)
 }
 
 func (p *GitlabParser) parseRetry(retry interface{}) *types.RetryPolicy {
@@ -852,26 +1445,81 @@ func (p *GitlabParser) parseRetry(retry interface{}) *types.RetryPolicy {
 		if when, ok := v["when"].([]interface{}); ok {
 			policy.When = p.parseStringArray(when)
 		}
+		policy.ExitCodes = p.parseExitCodes(v["exit_codes"])
 		return policy
 	}
 	return nil
 }
 
-func (p *GitlabParser) parseNeeds(needs interface{}) []string {
-	var result []string
+// parseAllowFailure parses `allow_failure`: a boolean, or a hash whose
+// exit_codes are the only failures allowed
+func (p *GitlabParser) parseAllowFailure(allowFailure interface{}) (bool, []int) {
+	switch v := allowFailure.(type) {
+	case bool:
+		return v, nil
+	case map[string]interface{}:
+		if codes := p.parseExitCodes(v["exit_codes"]); len(codes) > 0 {
+			return false, codes
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// parseExitCodes parses an exit code or a list of them
+func (p *GitlabParser) parseExitCodes(codes interface{}) []int {
+	switch v := codes.(type) {
+	case int:
+		return []int{v}
+	case []interface{}:
+		var result []int
+		for _, code := range v {
+			if n, ok := code.(int); ok {
+				result = append(result, n)
+			}
+		}
+		return result
+	}
+	return nil
+}
+
+// parseNeeds parses `needs:` entries: job names, or hashes with `job`,
+// `optional`, `artifacts` and `parallel:matrix`
+func (p *GitlabParser) parseNeeds(needs interface{}) []types.Need {
+	var result []types.Need
 
 	switch v := needs.(type) {
 	case string:
-		result = append(result, v)
+		result = append(result, types.NeedsOf(v)...)
 	case []interface{}:
 		for _, need := range v {
 			switch n := need.(type) {
 			case string:
-				result = append(result, n)
+				result = append(result, types.NeedsOf(n)...)
 			case map[string]interface{}:
 				// Handle complex needs with job/project/ref
-				if job, ok := n["job"].(string); ok {
-					result = append(result, job)
+				job, ok := n["job"].(string)
+				if !ok {
+					continue
+				}
+				optional, _ := n["optional"].(bool)
+				artifacts := true
+				if value, ok := n["artifacts"].(bool); ok {
+					artifacts = value
+				}
+
+				names := []string{job}
+				// needs:parallel:matrix picks variants of a matrix job
+				if parallel := p.parseParallel(n["parallel"]); parallel != nil && len(parallel.Matrix) > 0 {
+					if combinations, err := p.matrixCombinations(parallel.Matrix); err == nil {
+						names = names[:0]
+						for _, combination := range combinations {
+							names = append(names, p.matrixJobName(job, combination))
+						}
+					}
+				}
+				for _, name := range names {
+					result = append(result, types.Need{Job: name, Optional: optional, Artifacts: artifacts})
 				}
 			}
 		}
@@ -904,13 +1552,32 @@ func (p *GitlabParser) parseParallel(parallel interface{}) *types.Parallel {
 	return nil
 }
 
-func (p *GitlabParser) parseCache(cache interface{}) *types.CacheConfig {
+// parseCaches returns the cache blocks of a job, GitLab allowing a list
+// of up to four caches, each with its own key, policy and fallback keys
+func (p *GitlabParser) parseCaches(cache interface{}) []*types.CacheConfig {
+	var caches []*types.CacheConfig
 	switch v := cache.(type) {
 	case map[string]interface{}:
-		c := &types.CacheConfig{}
-
-		if key := v["key"]; key != nil {
-			c.Key = fmt.Sprintf("%v", key)
+		if c := p.parseCache(v); c != nil {
+			caches = append(caches, c)
+		}
+	case []interface{}:
+		for _, entry := range v {
+			if c := p.parseCache(entry); c != nil {
+				caches = append(caches, c)
+			}
+		}
+	}
+	return caches
+}
+
+func (p *GitlabParser) parseCache(cache interface{}) *types.CacheConfig {
+	switch v := cache.(type) {
+	case map[string]interface{}:
+		c := &types.CacheConfig{}
+
+		if key := v["key"]; key != nil {
+			c.Key = fmt.Sprintf("%v", key)
 		}
 
 		if paths, ok := v["paths"].([]interface{}); ok {
@@ -929,26 +1596,154 @@ func (p *GitlabParser) parseCache(cache interface{}) *types.CacheConfig {
 			c.When = when
 		}
 
-		return c
-	case []interface{}:
-		// Multiple caches - return first one for simplicity
-		if len(v) > 0 {
-			return p.parseCache(v[0])
+		if fallback, ok := v["fallback_keys"].([]interface{}); ok {
+			c.Fallback = p.parseStringArray(fallback)
 		}
+
+		return c
 	}
 	return nil
 }
 
-func (p *GitlabParser) parseEnvironment(env interface{}) string {
+// parseEnvironment parses the `environment:` of a job, a name or a
+// hash with name, url, on_stop, action, auto_stop_in and deployment_tier
+func (p *GitlabParser) parseEnvironment(env interface{}) *types.Environment {
 	switch v := env.(type) {
 	case string:
-		return v
+		return &types.Environment{Name: v}
 	case map[string]interface{}:
-		if name, ok := v["name"].(string); ok {
-			return name
+		result := &types.Environment{}
+		result.Name, _ = v["name"].(string)
+		result.URL, _ = v["url"].(string)
+		result.OnStop, _ = v["on_stop"].(string)
+		result.AutoStopIn, _ = v["auto_stop_in"].(string)
+		result.DeploymentTier, _ = v["deployment_tier"].(string)
+		if action, ok := v["action"].(string); ok {
+			result.Action = strings.ToLower(action)
 		}
+		result.Production = result.DeploymentTier == "production"
+		return result
 	}
-	return ""
+	return nil
+}
+
+// parseSecrets converts the `secrets:` of a job, sorted by variable name.
+// As on GitLab, a secret is written to a file unless `file: false`.
+func (p *GitlabParser) parseSecrets(secrets map[string]interface{}) []*types.Secret {
+	var result []*types.Secret
+	for name, value := range secrets {
+		def, ok := value.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		secret := &types.Secret{Name: name, Required: true, File: true}
+		if file, ok := def["file"].(bool); ok {
+			secret.File = file
+		}
+
+		switch {
+		case def["vault"] != nil:
+			secret.ValueFrom = p.parseVaultSecret(def["vault"])
+		case def["azure_key_vault"] != nil:
+			secret.ValueFrom = p.parseNamedSecret("azure-keyvault", def["azure_key_vault"])
+		case def["gcp_secret_manager"] != nil:
+			secret.ValueFrom = p.parseNamedSecret("gcp-secret-manager", def["gcp_secret_manager"])
+		case def["aws_secrets_manager"] != nil:
+			secret.ValueFrom = p.parseNamedSecret("aws-secrets", def["aws_secrets_manager"])
+		}
+		result = append(result, secret)
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+// parseIDTokens converts the `id_tokens:` of a job; each token has an
+// `aud:` string or list
+func (p *GitlabParser) parseIDTokens(jobName string, idTokens map[string]interface{}) map[string]*types.IDToken {
+	if len(idTokens) == 0 {
+		return nil
+	}
+
+	result := make(map[string]*types.IDToken, len(idTokens))
+	for name, value := range idTokens {
+		token := &types.IDToken{}
+		if def, ok := value.(map[string]interface{}); ok {
+			switch aud := def["aud"].(type) {
+			case string:
+				token.Aud = []string{aud}
+			case []interface{}:
+				token.Aud = p.parseStringArray(aud)
+			}
+		}
+		if len(token.Aud) == 0 {
+			p.strictProblem("job '%s' id_tokens:%s has no aud", jobName, name)
+		}
+		result[name] = token
+	}
+	return result
+}
+
+// parseVaultSecret converts a `vault:` secret, either the short
+// path/to/secret/field@engine_path form or a map. The engine defaults to
+// kv-v2 mounted at kv-v2.
+func (p *GitlabParser) parseVaultSecret(vault interface{}) *types.SecretSource {
+	source := &types.SecretSource{
+		Provider: "vault",
+		Config:   map[string]string{"engine": "kv-v2", "engine_path": "kv-v2"},
+	}
+
+	switch v := vault.(type) {
+	case string:
+		path := v
+		if i := strings.LastIndex(path, "@"); i >= 0 {
+			source.Config["engine_path"] = path[i+1:]
+			path = path[:i]
+		}
+		if i := strings.LastIndex(path, "/"); i >= 0 {
+			source.Path, source.Key = path[:i], path[i+1:]
+		} else {
+			source.Key = path
+		}
+	case map[string]interface{}:
+		source.Path, _ = v["path"].(string)
+		source.Key, _ = v["field"].(string)
+		if engine, ok := v["engine"].(map[string]interface{}); ok {
+			if name, ok := engine["name"].(string); ok {
+				source.Config["engine"] = name
+			}
+			if path, ok := engine["path"].(string); ok {
+				source.Config["engine_path"] = path
+			}
+		}
+	}
+	return source
+}
+
+// parseNamedSecret converts a secret of a cloud secret manager: its name
+// (or AWS secret_id), version and field
+func (p *GitlabParser) parseNamedSecret(provider string, def interface{}) *types.SecretSource {
+	source := &types.SecretSource{Provider: provider}
+	switch v := def.(type) {
+	case string:
+		source.Path = v
+	case map[string]interface{}:
+		source.Path, _ = v["name"].(string)
+		if id, ok := v["secret_id"].(string); ok {
+			source.Path = id
+		}
+		source.Key, _ = v["field"].(string)
+		switch version := v["version"].(type) {
+		case string:
+			source.Version = version
+		case int:
+			source.Version = strconv.Itoa(version)
+		}
+		if id, ok := v["version_id"].(string); ok {
+			source.Version = id
+		}
+	}
+	return source
 }
 
 func (p *GitlabParser) parseTrigger(trigger interface{}) *types.TriggerConfig {
@@ -956,9 +1751,10 @@ func (p *GitlabParser) parseTrigger(trigger interface{}) *types.TriggerConfig {
 	case string:
 		return &types.TriggerConfig{
 			Project: v,
+			Forward: types.DefaultTriggerForward(),
 		}
 	case map[string]interface{}:
-		t := &types.TriggerConfig{}
+		t := &types.TriggerConfig{Forward: types.DefaultTriggerForward()}
 		if project, ok := v["project"].(string); ok {
 			t.Project = project
 		}
@@ -968,19 +1764,134 @@ func (p *GitlabParser) parseTrigger(trigger interface{}) *types.TriggerConfig {
 		if strategy, ok := v["strategy"].(string); ok {
 			t.Strategy = strategy
 		}
+		t.Include = p.parseTriggerInclude(v["include"])
+		if forward, ok := v["forward"].(map[string]interface{}); ok {
+			if yamlVars, ok := forward["yaml_variables"].(bool); ok {
+				t.Forward.YAMLVariables = yamlVars
+			}
+			if pipelineVars, ok := forward["pipeline_variables"].(bool); ok {
+				t.Forward.PipelineVariables = pipelineVars
+			}
+		}
 		return t
 	}
 	return nil
 }
 
+// parseTriggerInclude parses the child pipeline files of a trigger: a
+// local path or a list of paths and include maps
+func (p *GitlabParser) parseTriggerInclude(include interface{}) []types.TriggerInclude {
+	var items []interface{}
+	switch v := include.(type) {
+	case nil:
+		return nil
+	case []interface{}:
+		items = v
+	default:
+		items = []interface{}{v}
+	}
+
+	var result []types.TriggerInclude
+	for _, item := range items {
+		switch v := item.(type) {
+		case string:
+			result = append(result, types.TriggerInclude{Local: v})
+		case map[string]interface{}:
+			inc := types.TriggerInclude{}
+			inc.Local, _ = v["local"].(string)
+			inc.Artifact, _ = v["artifact"].(string)
+			inc.Job, _ = v["job"].(string)
+			inc.Project, _ = v["project"].(string)
+			inc.Ref, _ = v["ref"].(string)
+			inc.Template, _ = v["template"].(string)
+			switch file := v["file"].(type) {
+			case string:
+				inc.File = file
+				result = append(result, inc)
+			case []interface{}:
+				// One include per file of the project
+				for _, f := range file {
+					if name, ok := f.(string); ok {
+						inc.File = name
+						result = append(result, inc)
+					}
+				}
+			default:
+				result = append(result, inc)
+			}
+		}
+	}
+	return result
+}
+
 func (p *GitlabParser) convertVariables(vars map[string]interface{}) map[string]string {
 	result := make(map[string]string)
 	for k, v := range vars {
-		result[k] = fmt.Sprintf("%v", v)
+		// Only the value of the map form (value, description, options, expand)
+		if def, ok := v.(map[string]interface{}); ok {
+			result[k] = p.variableValue(def["value"])
+			continue
+		}
+		// Lists are not variable values
+		if _, ok := v.([]interface{}); ok {
+			fmt.Fprintf(os.Stderr, "Warning: variable %s: a list is not a valid value, ignored\n", k)
+			continue
+		}
+		result[k] = p.variableValue(v)
 	}
 	return result
 }
 
+// variableKeys are the keys of a variable given in the map form
+var variableKeys = map[string]bool{"value": true, "description": true, "options": true, "expand": true}
+
+// convertVariableDefinitions returns the variables defined with the map
+// form, keeping their description, options and expand setting
+func (p *GitlabParser) convertVariableDefinitions(vars map[string]interface{}) map[string]*types.Variable {
+	result := make(map[string]*types.Variable)
+	for k, v := range vars {
+		def, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for key := range def {
+			if !variableKeys[key] {
+				fmt.Fprintf(os.Stderr, "Warning: variable %s: unknown key '%s' ignored\n", k, key)
+			}
+		}
+
+		variable := &types.Variable{
+			Value:  p.variableValue(def["value"]),
+			Expand: true,
+		}
+		if description, ok := def["description"].(string); ok {
+			variable.Description = description
+		}
+		if options, ok := def["options"].([]interface{}); ok {
+			for _, option := range options {
+				variable.Options = append(variable.Options, p.variableValue(option))
+			}
+		}
+		if expand, ok := def["expand"].(bool); ok {
+			variable.Expand = expand
+		}
+		result[k] = variable
+	}
+
+	if len(result) == 0 {
+		return nil
+	}
+	return result
+}
+
+// variableValue stringifies a scalar variable value ("" when unset)
+func (p *GitlabParser) variableValue(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprintf("%v", v)
+}
+
 func (p *GitlabParser) convertArtifacts(artifacts *GitlabArtifacts) *types.ArtifactConfig {
 	return &types.ArtifactConfig{
 		Name:      artifacts.Name,
@@ -990,33 +1901,69 @@ func (p *GitlabParser) convertArtifacts(artifacts *GitlabArtifacts) *types.Artif
 		When:      artifacts.When,
 		Untracked: artifacts.Untracked,
 		Public:    artifacts.Public != nil && *artifacts.Public,
+		Reports:   p.convertReports(artifacts.Reports),
 	}
 }
 
+// convertReports converts artifacts:reports, whose entries are a path, a
+// list of paths, or for coverage_report a map with a format and a path.
+// Reports are ordered by type.
+func (p *GitlabParser) convertReports(reports map[string]interface{}) []types.ArtifactReport {
+	names := make([]string, 0, len(reports))
+	for reportType := range reports {
+		names = append(names, reportType)
+	}
+	sort.Strings(names)
+
+	var result []types.ArtifactReport
+	for _, reportType := range names {
+		report := types.ArtifactReport{Type: reportType}
+		switch v := reports[reportType].(type) {
+		case string:
+			report.Paths = []string{v}
+		case []interface{}:
+			report.Paths = p.parseStringArray(v)
+		case map[string]interface{}:
+			if path, ok := v["path"].(string); ok {
+				report.Paths = []string{path}
+			}
+			if format, ok := v["coverage_format"].(string); ok {
+				report.Format = format
+			}
+		}
+		if len(report.Paths) > 0 {
+			result = append(result, report)
+		}
+	}
+	return result
+}
+
 func (p *GitlabParser) convertRules(rules []GitlabRule) []types.Rule {
 	var result []types.Rule
 	for _, r := range rules {
 		rule := types.Rule{
 			If:        r.If,
 			When:      r.When,
+			StartIn:   r.StartIn,
 			Variables: p.convertVariables(r.Variables),
 		}
 
-		// Parse changes
+		// Parse changes, a list or paths: with compare_to
 		switch v := r.Changes.(type) {
 		case []interface{}:
 			rule.Changes = p.parseStringArray(v)
 		case string:
 			rule.Changes = []string{v}
+		case map[string]interface{}:
+			if paths, ok := v["paths"].([]interface{}); ok {
+				rule.Changes = p.parseStringArray(paths)
+			}
 		}
 
 		rule.Exists = r.Exists
 
 		// Parse allow_failure
-		switch af := r.AllowFailure.(type) {
-		case bool:
-			rule.AllowFailure = af
-		}
+		rule.AllowFailure, rule.AllowExitCodes = p.parseAllowFailure(r.AllowFailure)
 
 		result = append(result, rule)
 	}
@@ -1065,14 +2012,28 @@ func (p *GitlabParser) parseDefault(defaultConfig map[string]interface{}) *Gitla
 		d.Tags = p.parseStringArray(tags)
 	}
 
-	if timeout, ok := defaultConfig["timeout"].(string); ok {
-		d.Timeout = timeout
+	if cache := defaultConfig["cache"]; cache != nil {
+		d.Cache = cache
+	}
+
+	if artifacts, ok := defaultConfig["artifacts"].(map[string]interface{}); ok {
+		d.Artifacts = p.parseArtifacts(artifacts)
 	}
 
+	if retry := defaultConfig["retry"]; retry != nil {
+		d.Retry = retry
+	}
+
+	d.Timeout = timeoutString(defaultConfig["timeout"])
+
 	if interruptible, ok := defaultConfig["interruptible"].(bool); ok {
 		d.Interruptible = interruptible
 	}
 
+	if idTokens, ok := defaultConfig["id_tokens"].(map[string]interface{}); ok {
+		d.IDTokens = idTokens
+	}
+
 	return d
 }
 
@@ -1114,9 +2075,24 @@ func (p *GitlabParser) parseRules(rules []interface{}) []GitlabRule {
 	return result
 }
 
-func (p *GitlabParser) parseOnlyExcept(data map[string]interface{}) *GitlabOnlyExcept {
+func (p *GitlabParser) parseOnlyExcept(value interface{}) *GitlabOnlyExcept {
 	oe := &GitlabOnlyExcept{}
 
+	// The short forms only list refs
+	switch v := value.(type) {
+	case string:
+		oe.Refs = []string{v}
+		return oe
+	case []interface{}:
+		oe.Refs = p.parseStringArray(v)
+		return oe
+	}
+
+	data, ok := value.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
 	if refs, ok := data["refs"].([]interface{}); ok {
 		oe.Refs = p.parseStringArray(refs)
 	}
@@ -1239,6 +2215,124 @@ func (p *GitlabParser) parseRelease(release map[string]interface{}) *GitlabRelea
 	return r
 }
 
+// releaseStep returns the step standing for the release GitLab creates
+// after the script: it validates and prints the release, which is not
+// published from a local run. Variables in the fields are expanded.
+func (p *GitlabParser) releaseStep(release *GitlabRelease) types.Step {
+	script := []string{
+		"release_tag=" + doubleQuote(release.TagName),
+		`test -n "$release_tag" || { echo "release: tag_name is empty" >&2; exit 1; }`,
+	}
+	if release.ReleasedAt != "" && !strings.Contains(release.ReleasedAt, "$") {
+		if _, err := time.Parse(time.RFC3339, release.ReleasedAt); err != nil {
+			script = append(script, fmt.Sprintf("echo %s >&2; exit 1",
+				doubleQuote("release: released_at '"+release.ReleasedAt+"' is not an ISO 8601 date")))
+		}
+	}
+
+	script = append(script, `echo "Release $release_tag"`)
+	for _, field := range []struct{ label, value string }{
+		{"Name", release.Name},
+		{"Ref", release.Ref},
+		{"Milestones", strings.Join(release.Milestones, ", ")},
+		{"Released at", release.ReleasedAt},
+		{"Description", release.Description},
+	} {
+		if field.value != "" {
+			script = append(script, "echo "+doubleQuote("  "+field.label+": "+field.value))
+		}
+	}
+	if release.Assets != nil {
+		for _, link := range release.Assets.Links {
+			script = append(script, "echo "+doubleQuote("  Asset: "+link.Name+" "+link.URL))
+		}
+	}
+	script = append(script, `echo "Release not published: runs are local"`)
+
+	return types.Step{
+		Name:   "Create Release",
+		Run:    strings.Join(script, "\n"),
+		Script: script,
+	}
+}
+
+// pagesDir returns the directory a pages job publishes, "" for other jobs.
+// A job is a pages job when named pages or with the `pages:` keyword.
+func (p *GitlabParser) pagesDir(jobName string, job *GitlabJob) string {
+	dir := "public"
+	switch v := job.Pages.(type) {
+	case bool:
+		if !v {
+			return ""
+		}
+	case map[string]interface{}:
+		if publish, ok := v["publish"].(string); ok && publish != "" {
+			dir = publish
+		}
+	case nil:
+		if jobName != "pages" {
+			return ""
+		}
+	}
+	if job.Publish != "" {
+		dir = job.Publish
+	}
+	return strings.TrimPrefix(filepath.ToSlash(filepath.Clean(dir)), "./")
+}
+
+// pagesStep returns the step checking that the script built the site
+func pagesStep(dir string) types.Step {
+	check := fmt.Sprintf("test -d %s || { echo %s >&2; exit 1; }",
+		doubleQuote(dir), doubleQuote("pages: "+dir+"/ was not built by the script"))
+	return types.Step{
+		Name:   "Verify Pages Output",
+		Run:    check,
+		Script: []string{check},
+	}
+}
+
+// dastStep returns a step reporting the DAST profiles of a job, nil when
+// it has none. The profiles live in GitLab, so their variables are not set.
+func (p *GitlabParser) dastStep(config map[string]interface{}) *types.Step {
+	if len(config) == 0 {
+		return nil
+	}
+
+	var script []string
+	for _, key := range []string{"site_profile", "scanner_profile"} {
+		if profile, ok := config[key].(string); ok {
+			label := strings.ReplaceAll(key, "_", " ")
+			script = append(script, "echo "+doubleQuote("DAST "+label+": "+profile))
+		}
+	}
+	script = append(script, `echo "DAST profiles are stored in GitLab: set DAST_WEBSITE and the other scanner variables with --env"`)
+
+	return &types.Step{
+		Name:   "DAST Configuration",
+		Run:    strings.Join(script, "\n"),
+		Script: script,
+	}
+}
+
+// artifactsInclude reports whether the artifacts keep dir
+func artifactsInclude(artifacts *types.ArtifactConfig, dir string) bool {
+	if artifacts == nil {
+		return false
+	}
+	for _, path := range artifacts.Paths {
+		path = strings.TrimSuffix(strings.TrimPrefix(filepath.ToSlash(filepath.Clean(path)), "./"), "/")
+		if path == "." || path == dir || strings.HasPrefix(dir, path+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// doubleQuote quotes s for a shell, leaving its variables expanded
+func doubleQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "`", "\\`").Replace(s) + `"`
+}
+
 func (p *GitlabParser) generateStepName(cmd string, index int) string {
 	// Clean and truncate command for step name
 	cmd = strings.TrimSpace(cmd)
@@ -1264,27 +2358,97 @@ func (p *GitlabParser) generateStepName(cmd string, index int) string {
 	return cmd
 }
 
-func (p *GitlabParser) extractStages(jobs map[string]*GitlabJob) []string {
-	stageMap := make(map[string]bool)
-	var stages []string
+// defaultStages are GitLab's stages, in order, when stages: is not given
+var defaultStages = []string{".pre", "build", "test", "deploy", ".post"}
+
+// defaultJobStage is the stage of the jobs without stage:
+const defaultJobStage = "test"
+
+// extractStages derives the stages of a file without stages: from its
+// jobs: GitLab's default stages in their order, other stages in the order
+// the jobs declaring them come in the file
+func (p *GitlabParser) extractStages(ci *GitlabCI) []string {
+	used := make(map[string]bool)
+	var custom []string
+	for _, name := range ci.JobOrder {
+		job, ok := ci.Jobs[name]
+		if !ok {
+			continue
+		}
+		stage := job.Stage
+		if stage == "" {
+			stage = defaultJobStage
+		}
+		if used[stage] {
+			continue
+		}
+		used[stage] = true
+		if !isDefaultStage(stage) {
+			custom = append(custom, stage)
+		}
+	}
 
-	// Collect unique stages
-	for _, job := range jobs {
-		if job.Stage != "" && !stageMap[job.Stage] {
-			stageMap[job.Stage] = true
-			stages = append(stages, job.Stage)
+	// GitLab's stages keep their order, .post staying last
+	var stages []string
+	for _, stage := range defaultStages {
+		if stage == ".post" {
+			stages = append(stages, custom...)
+		}
+		if used[stage] {
+			stages = append(stages, stage)
 		}
 	}
 
 	// If no stages defined, use default
 	if len(stages) == 0 {
-		stages = []string{"test", "build", "deploy"}
+		stages = []string{"build", "test", "deploy"}
 	}
 
 	return stages
 }
 
-func (p *GitlabParser) processIncludes(ci *GitlabCI) error {
+// withImplicitStages returns declared stages with .pre first and .post
+// last, when declared or used by a job: GitLab always has them, in that
+// position, without stages: listing them
+func withImplicitStages(stages []string, jobs map[string]*types.Job) []string {
+	used := make(map[string]bool)
+	for _, job := range jobs {
+		used[job.Stage] = true
+	}
+
+	var declared []string
+	for _, stage := range stages {
+		if stage == ".pre" || stage == ".post" {
+			used[stage] = true
+			continue
+		}
+		declared = append(declared, stage)
+	}
+
+	var result []string
+	if used[".pre"] {
+		result = append(result, ".pre")
+	}
+	result = append(result, declared...)
+	if used[".post"] {
+		result = append(result, ".post")
+	}
+	return result
+}
+
+// isDefaultStage reports whether stage is one of GitLab's default stages
+func isDefaultStage(stage string) bool {
+	for _, s := range defaultStages {
+		if s == stage {
+			return true
+		}
+	}
+	return false
+}
+
+// processIncludes resolves the include directives of ci. visited holds
+// the files already merged during the current Parse call.
+func (p *GitlabParser) processIncludes(ci *GitlabCI, visited map[string]bool) error {
 	// Process include directives
 	if ci.Include == nil {
 		return nil
@@ -1293,68 +2457,251 @@ func (p *GitlabParser) processIncludes(ci *GitlabCI) error {
 	// Handle different include formats
 	switch v := ci.Include.(type) {
 	case string:
-		return p.includeFile(v, ci)
+		return p.includeString(v, ci, visited)
 	case []interface{}:
 		for _, include := range v {
-			if err := p.processInclude(include, ci); err != nil {
+			if err := p.processInclude(include, ci, visited); err != nil {
 				return err
 			}
 		}
 	case map[string]interface{}:
-		return p.processInclude(v, ci)
+		return p.processInclude(v, ci, visited)
 	}
 
 	return nil
 }
 
-func (p *GitlabParser) processInclude(include interface{}, ci *GitlabCI) error {
+func (p *GitlabParser) processInclude(include interface{}, ci *GitlabCI, visited map[string]bool) error {
 	switch v := include.(type) {
 	case string:
-		return p.includeFile(v, ci)
+		return p.includeString(v, ci, visited)
 	case map[string]interface{}:
+		// Include-level rules decide whether the file is merged
+		if raw, ok := v["rules"].([]interface{}); ok {
+			matched, err := p.includeRulesMatch(raw)
+			if err != nil {
+				return err
+			}
+			if !matched {
+				return nil
+			}
+		}
+
+		// A missing local file fails the parse unless it is optional
+		optional, _ := v["optional"].(bool)
+
 		// Handle different include types
 		if local, ok := v["local"].(string); ok {
-			return p.includeFile(filepath.Join(p.baseDir, local), ci)
+			return p.includeFile(filepath.Join(p.baseDir, local), optional, ci, visited)
 		}
 		if file, ok := v["file"].(string); ok {
-			// Handle project file includes
-			return p.includeFile(file, ci)
+			// Files of other projects are merged only when present locally
+			return p.includeFile(file, true, ci, visited)
 		}
 		if template, ok := v["template"].(string); ok {
-			// Handle template includes (would need template resolution)
-			fmt.Printf("Template include not yet supported: %s\n", template)
+			return p.includeRemote(templateURL(template), ci, visited)
 		}
 		if remote, ok := v["remote"].(string); ok {
-			// Handle remote includes (would need HTTP fetch)
-			fmt.Printf("Remote include not yet supported: %s\n", remote)
+			return p.includeRemote(remote, ci, visited)
 		}
 	}
 	return nil
 }
 
-func (p *GitlabParser) includeFile(path string, ci *GitlabCI) error {
-	// Check cache first
-	if cached, ok := p.includeCache[path]; ok {
-		p.mergeCI(ci, cached)
-		return nil
+// includeRulesMatch evaluates the rules of an include against the
+// pipeline variables; `exists` is checked from the project root
+func (p *GitlabParser) includeRulesMatch(raw []interface{}) (bool, error) {
+	result, err := rules.Evaluate(p.convertRules(p.parseRules(raw)), p.includeVars, p.baseDir, nil)
+	if err != nil {
+		return false, fmt.Errorf("include rules: %w", err)
+	}
+	return result.Runs(), nil
+}
+
+// includeString handles the short include form, a URL or a local path
+// from the project root
+func (p *GitlabParser) includeString(include string, ci *GitlabCI, visited map[string]bool) error {
+	if strings.HasPrefix(include, "http://") || strings.HasPrefix(include, "https://") {
+		return p.includeRemote(include, ci, visited)
+	}
+	return p.includeFile(filepath.Join(p.baseDir, include), false, ci, visited)
+}
+
+// includeFile merges a local include; a file that can't be read is an
+// error, or skipped when optional
+func (p *GitlabParser) includeFile(path string, optional bool, ci *GitlabCI, visited map[string]bool) error {
+	key := p.cacheKey(path)
+	return p.include(key, path, ci, visited, func() ([]byte, error) {
+		data, err := p.readFile(path)
+		if err == nil {
+			return data, nil
+		}
+		if optional {
+			return nil, nil
+		}
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("include %s not found%s", p.includeName(key), p.includedFrom())
+		}
+		return nil, fmt.Errorf("failed to read include %s%s: %w", p.includeName(key), p.includedFrom(), err)
+	})
+}
+
+// includeRemote fetches an include over HTTP(S), keeping a copy on disk
+// for offline mode
+func (p *GitlabParser) includeRemote(url string, ci *GitlabCI, visited map[string]bool) error {
+	return p.include(url, url, ci, visited, func() ([]byte, error) {
+		if p.offline {
+			return p.readRemoteCopy(url)
+		}
+
+		data, err := p.fetchRemote(url)
+		if err != nil {
+			return nil, err
+		}
+		p.writeRemoteCopy(url, data)
+		return data, nil
+	})
+}
+
+// fetchRemote downloads a remote include
+func (p *GitlabParser) fetchRemote(url string) ([]byte, error) {
+	client := p.httpClient
+	if client == nil {
+		client = &http.Client{Timeout: includeTimeoutFromEnv()}
 	}
 
-	// Read and parse included file
-	data, err := os.ReadFile(path)
+	resp, err := client.Get(url)
 	if err != nil {
-		// Non-fatal: included file might not exist
+		return nil, fmt.Errorf("failed to fetch remote include %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch remote include %s: %s", url, resp.Status)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read remote include %s: %w", url, err)
+	}
+	return data, nil
+}
+
+// remoteCopyPath returns where the copy of a remote include is kept
+func (p *GitlabParser) remoteCopyPath(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return filepath.Join(p.remoteDir, hex.EncodeToString(sum[:])+".yml")
+}
+
+// readRemoteCopy reads the on-disk copy of a remote include
+func (p *GitlabParser) readRemoteCopy(url string) ([]byte, error) {
+	if p.remoteDir == "" || p.noCache {
+		return nil, fmt.Errorf("remote include %s is not available offline (include cache disabled)", url)
+	}
+	data, err := os.ReadFile(p.remoteCopyPath(url))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("remote include %s is not cached; run once without --offline to fetch it", url)
+		}
+		return nil, fmt.Errorf("failed to read cached include %s: %w", url, err)
+	}
+	return data, nil
+}
+
+// writeRemoteCopy stores a fetched remote include; failures only cost
+// offline availability
+func (p *GitlabParser) writeRemoteCopy(url string, data []byte) {
+	if p.remoteDir == "" || p.noCache {
+		return
+	}
+	if err := os.MkdirAll(p.remoteDir, 0755); err != nil {
+		return
+	}
+	_ = os.WriteFile(p.remoteCopyPath(url), data, 0644)
+}
+
+// include loads, parses and merges one included document. key identifies
+// it in the cache; load returns nil data to skip it silently.
+func (p *GitlabParser) include(key, path string, ci *GitlabCI, visited map[string]bool, load func() ([]byte, error)) error {
+	// Reaching a file that is still being merged is a cycle
+	for i, open := range p.includeStack {
+		if open == key {
+			cycle := append(append([]string{}, p.includeStack[i:]...), key)
+			for j := range cycle {
+				cycle[j] = p.includeName(cycle[j])
+			}
+			return fmt.Errorf("include cycle: %s", strings.Join(cycle, " → "))
+		}
+	}
+
+	// Each file is merged once per Parse call
+	if visited[key] {
 		return nil
 	}
+	visited[key] = true
+
+	p.includeStack = append(p.includeStack, key)
+	defer func() { p.includeStack = p.includeStack[:len(p.includeStack)-1] }()
+
+	// Check cache first; with merged anchors a parsed file depends on the
+	// files decoded before it, so it is never reused
+	useCache := !p.noCache && !p.mergeAnchors
+	if useCache {
+		if cached, ok := p.includeCache[key]; ok {
+			// Its nested includes are merged with it, so they count as
+			// visited as if they had been read
+			for _, nested := range cached.nested {
+				visited[nested] = true
+			}
+			p.mergeCI(ci, cached.ci)
+			return nil
+		}
+	}
+
+	// Read and parse included file, unless decoded with the root file
+	file, decoded := p.anchorDocs[key]
+	if !decoded {
+		data, err := load()
+		if err != nil {
+			return err
+		}
+		if data == nil {
+			return nil
+		}
+
+		if file, err = p.decodeFile(data); err != nil {
+			return fmt.Errorf("failed to parse included file %s%s: %w", path, p.includedFrom(), err)
+		}
+	}
 
-	var rawData map[string]interface{}
-	if err := yaml.Unmarshal(data, &rawData); err != nil {
-		return fmt.Errorf("failed to parse included file %s: %w", path, err)
+	if err := p.resolveGlobalReferences(file.data); err != nil {
+		return fmt.Errorf("failed to parse included file %s%s: %w", path, p.includedFrom(), err)
 	}
 
-	includedCI := p.parseRawData(rawData)
+	includedCI := p.parseRawData(file)
+
+	// Resolve nested includes with the same visited set
+	seen := make(map[string]bool, len(visited))
+	for k := range visited {
+		seen[k] = true
+	}
+	if err := p.processIncludes(includedCI, visited); err != nil {
+		return err
+	}
 
-	// Cache for future use
-	p.includeCache[path] = includedCI
+	// Cache for future use, with the includes visited through it
+	if useCache {
+		var nested []string
+		for k := range visited {
+			if !seen[k] {
+				nested = append(nested, k)
+			}
+		}
+		if p.includeCache == nil {
+			p.includeCache = make(map[string]*cachedInclude)
+		}
+		p.includeCache[key] = &cachedInclude{ci: includedCI, nested: nested}
+	}
 
 	// Merge into main CI
 	p.mergeCI(ci, includedCI)
@@ -1362,32 +2709,529 @@ func (p *GitlabParser) includeFile(path string, ci *GitlabCI) error {
 	return nil
 }
 
+// includeName returns an include key for display, relative to the
+// project root when it is a local file
+func (p *GitlabParser) includeName(key string) string {
+	if strings.Contains(key, "://") {
+		return key
+	}
+	if rel, err := filepath.Rel(p.cacheKey(p.baseDir), key); err == nil {
+		return rel
+	}
+	return key
+}
+
+// includedFrom describes the include chain leading to the include being
+// merged, root file first
+func (p *GitlabParser) includedFrom() string {
+	if len(p.includeStack) < 2 {
+		return ""
+	}
+	chain := make([]string, len(p.includeStack)-1)
+	for i, key := range p.includeStack[:len(p.includeStack)-1] {
+		chain[i] = p.includeName(key)
+	}
+	return " (included from " + strings.Join(chain, " → ") + ")"
+}
+
+// mergeCI merges an included file (source) into the file including it
+// (target). Keys are merged one by one, the including file winning on
+// conflicts. source may be cached and is never modified.
 func (p *GitlabParser) mergeCI(target, source *GitlabCI) {
-	// Merge jobs
+	// Merge jobs; a job defined on both sides keeps the including file's
 	for name, job := range source.Jobs {
 		if target.Jobs == nil {
 			target.Jobs = make(map[string]*GitlabJob)
 		}
-		// Don't override existing jobs
-		if _, exists := target.Jobs[name]; !exists {
-			target.Jobs[name] = job
+		if _, exists := target.Jobs[name]; exists {
+			fmt.Fprintf(os.Stderr, "Warning: job '%s' is also defined in an included file; keeping the including file's definition\n", name)
+			continue
+		}
+		target.Jobs[name] = job
+	}
+
+	// Merge raw definitions used by extends
+	for name, raw := range source.HiddenJobs {
+		if target.HiddenJobs == nil {
+			target.HiddenJobs = make(map[string]map[string]interface{})
+		}
+		if _, exists := target.HiddenJobs[name]; !exists {
+			target.HiddenJobs[name] = raw
+		}
+	}
+	for _, name := range source.JobOrder {
+		if _, exists := target.RawJobs[name]; !exists {
+			target.JobOrder = append(target.JobOrder, name)
+		}
+	}
+	for name, raw := range source.RawJobs {
+		if target.RawJobs == nil {
+			target.RawJobs = make(map[string]map[string]interface{})
+		}
+		if _, exists := target.RawJobs[name]; !exists {
+			target.RawJobs[name] = raw
+		}
+	}
+
+	// Merge variables key by key
+	for name, value := range source.Variables {
+		if target.Variables == nil {
+			target.Variables = make(map[string]interface{})
+		}
+		if _, exists := target.Variables[name]; !exists {
+			target.Variables[name] = value
+		}
+	}
+
+	// Merge stages, appending the ones the including file doesn't list
+	known := make(map[string]bool, len(target.Stages))
+	for _, stage := range target.Stages {
+		known[stage] = true
+	}
+	for _, stage := range source.Stages {
+		if !known[stage] {
+			known[stage] = true
+			target.Stages = append(target.Stages, stage)
+		}
+	}
+
+	// Merge global keywords
+	if target.Image == nil {
+		target.Image = source.Image
+	}
+	if target.Services == nil {
+		target.Services = source.Services
+	}
+	if target.Cache == nil {
+		target.Cache = source.Cache
+	}
+	if target.BeforeScript == nil {
+		target.BeforeScript = source.BeforeScript
+	}
+	if target.AfterScript == nil {
+		target.AfterScript = source.AfterScript
+	}
+
+	// Merge defaults field by field
+	if source.Default != nil {
+		if target.Default == nil {
+			target.Default = &GitlabDefault{}
+		}
+		p.mergeDefault(target.Default, source.Default)
+	}
+
+	// Merge workflow rules; the including file's rules are evaluated first
+	if source.Workflow != nil && len(source.Workflow.Rules) > 0 {
+		workflow := &GitlabWorkflow{}
+		if target.Workflow != nil {
+			workflow.Rules = append(workflow.Rules, target.Workflow.Rules...)
+		}
+		workflow.Rules = append(workflow.Rules, source.Workflow.Rules...)
+		target.Workflow = workflow
+	}
+
+	// Merge local hints
+	if target.LocalHints == nil && source.LocalHints != nil {
+		target.LocalHints = source.LocalHints
+	}
+}
+
+// mergeDefault fills the `default:` settings target doesn't set from source
+func (p *GitlabParser) mergeDefault(target, source *GitlabDefault) {
+	if target.Image == nil {
+		target.Image = source.Image
+	}
+	if target.Services == nil {
+		target.Services = source.Services
+	}
+	if target.BeforeScript == nil {
+		target.BeforeScript = source.BeforeScript
+	}
+	if target.AfterScript == nil {
+		target.AfterScript = source.AfterScript
+	}
+	if len(target.Tags) == 0 {
+		target.Tags = source.Tags
+	}
+	if target.Cache == nil {
+		target.Cache = source.Cache
+	}
+	if target.Artifacts == nil {
+		target.Artifacts = source.Artifacts
+	}
+	if target.Retry == nil {
+		target.Retry = source.Retry
+	}
+	if target.Timeout == "" {
+		target.Timeout = source.Timeout
+	}
+	if !target.Interruptible {
+		target.Interruptible = source.Interruptible
+	}
+	if target.IDTokens == nil {
+		target.IDTokens = source.IDTokens
+	}
+}
+
+// resolveExtends builds the jobs deferred by parseRawData from their merged
+// definition. Parents are applied left to right, so the right-most parent
+// wins, and the job's own keys win over all of them. Hashes are merged
+// deeply; scalars and lists are replaced, as GitLab does.
+func (p *GitlabParser) resolveExtends(ci *GitlabCI) error {
+	resolved := make(map[string]map[string]interface{})
+
+	names := make([]string, 0, len(ci.RawJobs))
+	for name, raw := range ci.RawJobs {
+		if _, parsed := ci.Jobs[name]; !parsed || raw["extends"] != nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		merged, err := p.extendJob(ci, name, resolved, nil)
+		if err != nil {
+			return err
+		}
+
+		job := p.parseJob(name, merged)
+		if job == nil {
+			delete(ci.Jobs, name)
+			continue
+		}
+		job.Extends = ci.RawJobs[name]["extends"]
+		ci.Jobs[name] = job
+	}
+
+	return nil
+}
+
+// extendJob returns the definition of a job with its extends chain merged
+func (p *GitlabParser) extendJob(ci *GitlabCI, name string, resolved map[string]map[string]interface{}, chain []string) (map[string]interface{}, error) {
+	if merged, ok := resolved[name]; ok {
+		return merged, nil
+	}
+
+	for _, seen := range chain {
+		if seen == name {
+			return nil, fmt.Errorf("circular extends: %s -> %s", strings.Join(chain, " -> "), name)
+		}
+	}
+	chain = append(chain, name)
+
+	raw, ok := ci.HiddenJobs[name]
+	if !ok {
+		raw, ok = ci.RawJobs[name]
+	}
+	if !ok {
+		return nil, fmt.Errorf("job '%s' extends unknown job '%s'", chain[0], name)
+	}
+
+	merged := make(map[string]interface{})
+	for _, parent := range p.parseExtends(raw["extends"]) {
+		parentDef, err := p.extendJob(ci, parent, resolved, chain)
+		if err != nil {
+			return nil, err
+		}
+		merged = deepMerge(merged, parentDef)
+	}
+
+	own := make(map[string]interface{}, len(raw))
+	for k, v := range raw {
+		if k != "extends" {
+			own[k] = v
+		}
+	}
+	merged = deepMerge(merged, own)
+
+	resolved[name] = merged
+	return merged, nil
+}
+
+// referenceKey marks a `!reference [job, key, ...]` tag in decoded YAML.
+// decodeFile turns each tag into a single-key map {referenceKey: path}.
+const referenceKey = "!reference"
+
+// decodeFile decodes a GitLab file, keeping !reference tags so they can
+// be resolved once all files are loaded
+func (p *GitlabParser) decodeFile(data []byte) (*decodedFile, error) {
+	var node yaml.Node
+	if err := yaml.Unmarshal(data, &node); err != nil {
+		return nil, err
+	}
+	markReferences(&node)
+	return decodeNode(&node)
+}
+
+// decodeNode decodes the node of a GitLab file, !reference tags marked
+func decodeNode(node *yaml.Node) (*decodedFile, error) {
+	file := &decodedFile{}
+	if err := node.Decode(&file.data); err != nil {
+		return nil, err
+	}
+
+	if node.Kind == yaml.DocumentNode && len(node.Content) > 0 {
+		node = node.Content[0]
+	}
+	if node.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if key := node.Content[i].Value; key != "<<" {
+				file.keys = append(file.keys, key)
+			}
+		}
+	}
+	return file, nil
+}
+
+// markReferences rewrites !reference sequences into marker maps
+func markReferences(node *yaml.Node) {
+	for _, child := range node.Content {
+		markReferences(child)
+	}
+
+	if node.Tag != referenceKey || node.Kind != yaml.SequenceNode {
+		return
+	}
+
+	path := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq", Content: node.Content}
+	key := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: referenceKey}
+	node.Kind = yaml.MappingNode
+	node.Tag = "!!map"
+	node.Content = []*yaml.Node{key, path}
+}
+
+// referencePath returns the path of a reference marker
+func referencePath(value interface{}) ([]string, bool) {
+	m, ok := value.(map[string]interface{})
+	if !ok || len(m) != 1 {
+		return nil, false
+	}
+	raw, ok := m[referenceKey].([]interface{})
+	if !ok {
+		return nil, false
+	}
+
+	path := make([]string, 0, len(raw))
+	for _, part := range raw {
+		path = append(path, fmt.Sprintf("%v", part))
+	}
+	return path, true
+}
+
+// containsReference reports whether a decoded value holds a !reference
+func containsReference(value interface{}) bool {
+	if _, ok := referencePath(value); ok {
+		return true
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for _, item := range v {
+			if containsReference(item) {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			if containsReference(item) {
+				return true
+			}
 		}
 	}
+	return false
+}
+
+// maxReferenceDepth bounds nested references, like GitLab does
+const maxReferenceDepth = 10
+
+// resolveReferences replaces !reference tags in every job and hidden job
+// with the value they point to
+func (p *GitlabParser) resolveReferences(ci *GitlabCI) error {
+	for _, defs := range []map[string]map[string]interface{}{ci.HiddenJobs, ci.RawJobs} {
+		names := make([]string, 0, len(defs))
+		for name, def := range defs {
+			if containsReference(def) {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
 
-	// Merge variables
-	if target.Variables == nil && source.Variables != nil {
-		target.Variables = source.Variables
+		for _, name := range names {
+			resolved, err := p.resolveValue(ci, defs[name], name, 0)
+			if err != nil {
+				return err
+			}
+			defs[name] = resolved.(map[string]interface{})
+		}
 	}
 
-	// Merge stages
-	if len(target.Stages) == 0 && len(source.Stages) > 0 {
-		target.Stages = source.Stages
+	return nil
+}
+
+// resolveGlobalReferences resolves !reference tags in the top-level
+// sections of a document (global scripts, variables, default, ...)
+// against the jobs of that same document. References inside jobs are
+// resolved later by resolveReferences, across included files.
+func (p *GitlabParser) resolveGlobalReferences(rawData map[string]interface{}) error {
+	doc := &GitlabCI{
+		HiddenJobs: make(map[string]map[string]interface{}),
+		RawJobs:    make(map[string]map[string]interface{}),
+	}
+	for name, value := range rawData {
+		if m, ok := value.(map[string]interface{}); ok {
+			if strings.HasPrefix(name, ".") {
+				doc.HiddenJobs[name] = m
+			} else {
+				doc.RawJobs[name] = m
+			}
+		}
 	}
 
-	// Merge defaults
-	if target.Default == nil && source.Default != nil {
-		target.Default = source.Default
+	for _, key := range []string{"image", "services", "variables", "cache", "before_script", "after_script", "default"} {
+		value, ok := rawData[key]
+		if !ok || !containsReference(value) {
+			continue
+		}
+
+		resolved, err := p.resolveValue(doc, value, key, 0)
+		if err != nil {
+			return err
+		}
+		rawData[key] = resolved
 	}
+
+	return nil
+}
+
+// resolveValue returns value with its references resolved. References
+// inside lists that point at lists are spliced in place.
+func (p *GitlabParser) resolveValue(ci *GitlabCI, value interface{}, where string, depth int) (interface{}, error) {
+	if path, ok := referencePath(value); ok {
+		if depth >= maxReferenceDepth {
+			return nil, fmt.Errorf("!reference [%s] in '%s': too many nested references", strings.Join(path, ", "), where)
+		}
+
+		target, err := p.lookupReference(ci, path)
+		if err != nil {
+			return nil, fmt.Errorf("!reference [%s] in '%s': %w", strings.Join(path, ", "), where, err)
+		}
+		return p.resolveValue(ci, target, path[0], depth+1)
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			resolved, err := p.resolveValue(ci, item, where, depth)
+			if err != nil {
+				return nil, err
+			}
+			result[key] = resolved
+		}
+		return result, nil
+
+	case []interface{}:
+		result := make([]interface{}, 0, len(v))
+		for _, item := range v {
+			_, isReference := referencePath(item)
+			resolved, err := p.resolveValue(ci, item, where, depth)
+			if err != nil {
+				return nil, err
+			}
+			if list, ok := resolved.([]interface{}); ok && isReference {
+				result = append(result, list...)
+				continue
+			}
+			result = append(result, resolved)
+		}
+		return result, nil
+	}
+
+	return value, nil
+}
+
+// lookupReference finds the value at path ([job, key, ...]) in the
+// definitions of jobs and hidden jobs
+func (p *GitlabParser) lookupReference(ci *GitlabCI, path []string) (interface{}, error) {
+	if len(path) < 2 {
+		return nil, fmt.Errorf("a reference needs a job and a key")
+	}
+
+	def, ok := ci.HiddenJobs[path[0]]
+	if !ok {
+		def, ok = ci.RawJobs[path[0]]
+	}
+	if !ok {
+		return nil, fmt.Errorf("job '%s' not found", path[0])
+	}
+
+	var current interface{} = def
+	for i, key := range path[1:] {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("'%s' is not a map", strings.Join(path[:i+1], "."))
+		}
+		current, ok = m[key]
+		if !ok {
+			return nil, fmt.Errorf("key '%s' not found", strings.Join(path[:i+2], "."))
+		}
+	}
+
+	return current, nil
+}
+
+// parseExtends returns the parents named by an extends value
+func (p *GitlabParser) parseExtends(extends interface{}) []string {
+	switch v := extends.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		return p.parseStringArray(v)
+	}
+	return nil
+}
+
+// deepMerge returns base overlaid with override: nested hashes are
+// merged, every other value is replaced
+func deepMerge(base, override map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{}, len(base)+len(override))
+	for k, v := range base {
+		result[k] = v
+	}
+
+	for k, v := range override {
+		overrideMap, isMap := v.(map[string]interface{})
+		baseMap, baseIsMap := result[k].(map[string]interface{})
+		if isMap && baseIsMap {
+			result[k] = deepMerge(baseMap, overrideMap)
+			continue
+		}
+		result[k] = v
+	}
+
+	return result
+}
+
+// maxStartIn is the longest delay GitLab allows for a delayed job
+const maxStartIn = 7 * 24 * time.Hour
+
+// checkStartIn describes what is wrong with the start_in of a `when`, ""
+// when nothing is
+func checkStartIn(when, startIn string) string {
+	if when != rules.WhenDelayed {
+		return ""
+	}
+	if startIn == "" {
+		return "when: delayed requires start_in"
+	}
+	d, err := ParseDuration(startIn)
+	if err != nil {
+		return fmt.Sprintf("start_in: %v", err)
+	}
+	if d > maxStartIn {
+		return fmt.Sprintf("start_in '%s' exceeds 1 week", startIn)
+	}
+	return ""
 }
 
 // Validate validates the parsed pipeline
@@ -1403,12 +3247,23 @@ func (p *GitlabParser) Validate(pipeline *types.Pipeline) error {
 		errors = append(errors, "no jobs defined in pipeline")
 	}
 
+	// Problems found by the strict checks while parsing
+	errors = append(errors, p.strictProblems...)
+
 	// Validate job stages
 	stageMap := make(map[string]bool)
 	for _, stage := range pipeline.Stages {
 		stageMap[stage] = true
 	}
 
+	// Validate variable defaults against their options
+	for name, variable := range pipeline.Variables {
+		if value := fmt.Sprintf("%v", variable.Value); !variable.Allows(value) {
+			errors = append(errors, fmt.Sprintf("variable '%s' value '%s' is not one of its options (%s)",
+				name, value, strings.Join(variable.Options, ", ")))
+		}
+	}
+
 	for jobName, job := range pipeline.Jobs {
 		// Validate job has steps or is a trigger
 		if len(job.Steps) == 0 && job.Trigger == nil {
@@ -1420,10 +3275,17 @@ func (p *GitlabParser) Validate(pipeline *types.Pipeline) error {
 			errors = append(errors, fmt.Sprintf("job '%s' references undefined stage '%s'", jobName, job.Stage))
 		}
 
-		// Validate job dependencies exist
+		// Validate job dependencies exist; optional needs may be missing
+		needed := make(map[string]bool)
 		for _, need := range job.Needs {
-			if _, exists := pipeline.Jobs[need]; !exists {
-				errors = append(errors, fmt.Sprintf("job '%s' depends on non-existent job '%s'", jobName, need))
+			needed[need.Job] = true
+			if _, exists := pipeline.Jobs[need.Job]; !exists && !need.Optional {
+				errors = append(errors, fmt.Sprintf("job '%s' depends on non-existent job '%s'", jobName, need.Job))
+			}
+		}
+		for _, dep := range job.Dependencies {
+			if _, exists := pipeline.Jobs[dep]; !exists && !needed[dep] {
+				errors = append(errors, fmt.Sprintf("job '%s' dependencies reference non-existent job '%s'", jobName, dep))
 			}
 		}
 
@@ -1431,6 +3293,38 @@ func (p *GitlabParser) Validate(pipeline *types.Pipeline) error {
 		if err := p.checkCircularDependencies(jobName, job, pipeline.Jobs, []string{}); err != nil {
 			errors = append(errors, err.Error())
 		}
+
+		// Validate the job stopping the environment
+		if job.Deployment != nil && job.Deployment.OnStop != "" {
+			if _, exists := pipeline.Jobs[job.Deployment.OnStop]; !exists {
+				errors = append(errors, fmt.Sprintf("job '%s' environment on_stop references non-existent job '%s'", jobName, job.Deployment.OnStop))
+			}
+		}
+
+		// Validate artifacts:expire_in
+		if job.Artifacts != nil && job.Artifacts.ExpireIn != "" && job.Artifacts.ExpireIn != "never" {
+			if _, err := ParseDuration(job.Artifacts.ExpireIn); err != nil {
+				errors = append(errors, fmt.Sprintf("job '%s' artifacts:expire_in: %v", jobName, err))
+			}
+		}
+
+		// Validate the delay of delayed jobs
+		if err := checkStartIn(job.When, job.StartIn); err != "" {
+			errors = append(errors, fmt.Sprintf("job '%s' %s", jobName, err))
+		}
+
+		// Validate rules:if syntax
+		for i, rule := range job.Rules {
+			if err := checkStartIn(rule.When, rule.StartIn); err != "" {
+				errors = append(errors, fmt.Sprintf("job '%s' rule %d: %s", jobName, i+1, err))
+			}
+			if rule.If == "" {
+				continue
+			}
+			if err := rules.CheckIf(rule.If); err != nil {
+				errors = append(errors, fmt.Sprintf("job '%s' rule %d: %v", jobName, i+1, err))
+			}
+		}
 	}
 
 	if len(errors) > 0 {
@@ -1450,8 +3344,8 @@ func (p *GitlabParser) checkCircularDependencies(jobName string, job *types.Job,
 
 	visited = append(visited, jobName)
 
-	// Check dependencies recursively
-	for _, need := range job.Needs {
+	// Check needs and dependencies recursively
+	for _, need := range job.UpstreamNames() {
 		if dependentJob, exists := allJobs[need]; exists {
 			if err := p.checkCircularDependencies(need, dependentJob, allJobs, visited); err != nil {
 				return err
@@ -1465,23 +3359,33 @@ func (p *GitlabParser) checkCircularDependencies(jobName string, job *types.Job,
 // ParseDirectory parses all GitLab CI files in a directory
 func (p *GitlabParser) ParseDirectory(dir string) ([]*types.Pipeline, error) {
 	var pipelines []*types.Pipeline
+	var parsed []os.FileInfo
+
+	// Check for .gitlab-ci.yml in root, then .gitlab-ci.yaml
+	for _, name := range []string{".gitlab-ci.yml", ".gitlab-ci.yaml"} {
+		file := filepath.Join(dir, name)
 
-	// Check for .gitlab-ci.yml in root
-	mainFile := filepath.Join(dir, ".gitlab-ci.yml")
-	if _, err := os.Stat(mainFile); err == nil {
-		pipeline, err := p.Parse(mainFile)
+		info, err := p.stat(file)
 		if err != nil {
-			return nil, fmt.Errorf("failed to parse %s: %w", mainFile, err)
+			continue
 		}
-		pipelines = append(pipelines, pipeline)
-	}
 
-	// Also check for .gitlab-ci.yaml
-	altFile := filepath.Join(dir, ".gitlab-ci.yaml")
-	if _, err := os.Stat(altFile); err == nil {
-		pipeline, err := p.Parse(altFile)
+		// Both names may point at the same file (symlink, case-insensitive FS)
+		duplicate := false
+		for _, seen := range parsed {
+			if os.SameFile(seen, info) {
+				duplicate = true
+				break
+			}
+		}
+		if duplicate {
+			continue
+		}
+		parsed = append(parsed, info)
+
+		pipeline, err := p.Parse(file)
 		if err != nil {
-			return nil, fmt.Errorf("failed to parse %s: %w", altFile, err)
+			return nil, fmt.Errorf("failed to parse %s: %w", file, err)
 		}
 		pipelines = append(pipelines, pipeline)
 	}
@@ -1493,6 +3397,14 @@ func (p *GitlabParser) ParseDirectory(dir string) ([]*types.Pipeline, error) {
 	return pipelines, nil
 }
 
+// stat returns file info through the configured file system
+func (p *GitlabParser) stat(path string) (os.FileInfo, error) {
+	if p.fsys != nil {
+		return fs.Stat(p.fsys, p.cacheKey(path))
+	}
+	return os.Stat(path)
+}
+
 // GetProviderName returns the name of this parser
 func (p *GitlabParser) GetProviderName() string {
 	return "gitlab"
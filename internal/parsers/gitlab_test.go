@@ -0,0 +1,836 @@
+package parsers
+
+import (
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/sanix-darker/git-ci/pkg/types"
+)
+
+// countingFS counts the files opened through it
+type countingFS struct {
+	fs.FS
+	opens map[string]int
+}
+
+func newCountingFS(files map[string]string) *countingFS {
+	mapFS := fstest.MapFS{}
+	for name, content := range files {
+		mapFS[name] = &fstest.MapFile{Data: []byte(content)}
+	}
+	return &countingFS{FS: mapFS, opens: map[string]int{}}
+}
+
+func (c *countingFS) Open(name string) (fs.File, error) {
+	c.opens[name]++
+	return c.FS.Open(name)
+}
+
+// reads returns the number of files opened
+func (c *countingFS) reads() int {
+	total := 0
+	for _, n := range c.opens {
+		total += n
+	}
+	return total
+}
+
+// includeTree returns a root file with n includes, each defining a job
+// and including one shared file
+func includeTree(n int) map[string]string {
+	var root strings.Builder
+	root.WriteString("include:\n")
+	files := map[string]string{
+		"ci/shared.yml": "variables:\n  SHARED: \"1\"\n",
+	}
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("ci/job%d.yml", i)
+		fmt.Fprintf(&root, "  - local: %s\n", name)
+		files[name] = fmt.Sprintf("include:\n  - local: ci/shared.yml\njob%d:\n  script: [echo %d]\n", i, i)
+	}
+	files[".gitlab-ci.yml"] = root.String()
+	return files
+}
+
+// TestGitlabIncludesReadOnce parses the tree of BenchmarkGitlabIncludes
+func TestGitlabIncludesReadOnce(t *testing.T) {
+	files := includeTree(50)
+	fsys := newCountingFS(files)
+	p := NewGitlabParser()
+	p.SetFS(fsys)
+
+	pipeline, err := p.Parse(".gitlab-ci.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pipeline.Jobs) != 50 {
+		t.Errorf("got %d jobs, want 50", len(pipeline.Jobs))
+	}
+	for name, n := range fsys.opens {
+		if n != 1 {
+			t.Errorf("%s read %d times", name, n)
+		}
+	}
+	if fsys.reads() != len(files) {
+		t.Errorf("read %d files, want %d", fsys.reads(), len(files))
+	}
+}
+
+func TestGitlabCachedIncludeVisitsItsIncludes(t *testing.T) {
+	fsys := newCountingFS(map[string]string{
+		".gitlab-ci.yml": "include:\n  - local: a.yml\n",
+		"a.yml":          "include:\n  - local: nested.yml\na:\n  script: [echo a]\n",
+		"nested.yml":     "nested:\n  script: [echo nested]\n",
+	})
+	p := NewGitlabParser()
+	p.SetFS(fsys)
+	if _, err := p.Parse(".gitlab-ci.yml"); err != nil {
+		t.Fatal(err)
+	}
+
+	// a.yml now comes from the cache; nested.yml, merged with it, must
+	// count as visited all the same
+	visited := map[string]bool{}
+	if err := p.processIncludes(&GitlabCI{Include: "a.yml"}, visited); err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for key := range visited {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	if got := strings.Join(keys, ","); got != "a.yml,nested.yml" {
+		t.Errorf("visited %s, want a.yml,nested.yml", got)
+	}
+	if fsys.opens["a.yml"] != 1 || fsys.opens["nested.yml"] != 1 {
+		t.Errorf("cached includes read again: %v", fsys.opens)
+	}
+}
+
+func TestGitlabIncludeCycle(t *testing.T) {
+	p := NewGitlabParser()
+	p.SetFS(fstest.MapFS{
+		".gitlab-ci.yml": &fstest.MapFile{Data: []byte("include:\n  - local: a.yml\n")},
+		"a.yml":          &fstest.MapFile{Data: []byte("include:\n  - local: b.yml\n")},
+		"b.yml":          &fstest.MapFile{Data: []byte("include:\n  - local: c.yml\n")},
+		"c.yml":          &fstest.MapFile{Data: []byte("include:\n  - local: a.yml\njob:\n  script: [echo]\n")},
+	})
+	_, err := p.Parse(".gitlab-ci.yml")
+	if err == nil {
+		t.Fatal("parsed an include cycle")
+	}
+	if want := "include cycle: a.yml → b.yml → c.yml → a.yml"; !strings.Contains(err.Error(), want) {
+		t.Errorf("error %q, want it to contain %q", err, want)
+	}
+}
+
+func TestGitlabIncludeRules(t *testing.T) {
+	files := fstest.MapFS{
+		".gitlab-ci.yml": &fstest.MapFile{Data: []byte(`
+include:
+  - local: deploy.yml
+    rules:
+      - if: $CI_COMMIT_BRANCH == "main"
+build:
+  script: [echo build]
+`)},
+		"deploy.yml": &fstest.MapFile{Data: []byte("deploy:\n  script: [echo deploy]\n")},
+	}
+
+	for branch, included := range map[string]bool{"main": true, "feature": false} {
+		p := NewGitlabParser()
+		p.SetFS(files)
+		p.SetVariables(map[string]string{"CI_COMMIT_BRANCH": branch})
+		pipeline, err := p.Parse(".gitlab-ci.yml")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := pipeline.Jobs["deploy"]; ok != included {
+			t.Errorf("branch %s: deploy.yml included: %v, want %v", branch, ok, included)
+		}
+		if _, ok := pipeline.Jobs["build"]; !ok {
+			t.Errorf("branch %s: build job missing", branch)
+		}
+	}
+}
+
+// BenchmarkGitlabIncludes parses a tree of 50 includes sharing one file;
+// each distinct file is read once per parse
+func BenchmarkGitlabIncludes(b *testing.B) {
+	files := includeTree(50)
+
+	for i := 0; i < b.N; i++ {
+		fsys := newCountingFS(files)
+		p := NewGitlabParser()
+		p.SetFS(fsys)
+		if _, err := p.Parse(".gitlab-ci.yml"); err != nil {
+			b.Fatal(err)
+		}
+		if fsys.reads() != len(files) {
+			b.Fatalf("read %d files, want %d", fsys.reads(), len(files))
+		}
+		b.ReportMetric(float64(fsys.reads()), "reads/op")
+	}
+}
+
+// parseGitlab parses a .gitlab-ci.yml holding content
+func parseGitlab(t *testing.T, content string) (*types.Pipeline, error) {
+	t.Helper()
+	p := NewGitlabParser()
+	p.SetFS(fstest.MapFS{".gitlab-ci.yml": &fstest.MapFile{Data: []byte(content)}})
+	return p.Parse(".gitlab-ci.yml")
+}
+
+func TestGitlabExtendsChain(t *testing.T) {
+	pipeline, err := parseGitlab(t, `
+.base:
+  image: alpine:3.19
+  variables:
+    LEVEL: base
+    BASE: "1"
+  script: [echo base]
+.middle:
+  extends: .base
+  variables:
+    LEVEL: middle
+    MIDDLE: "1"
+  before_script: [echo setup]
+build:
+  extends: .middle
+  image: golang:1.24
+  variables:
+    LEVEL: build
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	job := pipeline.Jobs["build"]
+	if job == nil {
+		t.Fatalf("no build job in %v", pipeline.Jobs)
+	}
+	if _, hidden := pipeline.Jobs[".base"]; hidden {
+		t.Error("a hidden template became a job")
+	}
+	if job.Image != "golang:1.24" {
+		t.Errorf("image %q, want the job's own golang:1.24", job.Image)
+	}
+	for key, want := range map[string]string{"LEVEL": "build", "BASE": "1", "MIDDLE": "1"} {
+		if got := job.Environment[key]; got != want {
+			t.Errorf("%s = %q, want %q", key, got, want)
+		}
+	}
+	runs := map[types.StepPhase][]string{}
+	for _, step := range job.Steps {
+		runs[step.Phase] = append(runs[step.Phase], step.Run)
+	}
+	if got := strings.Join(runs[""], ";"); got != "echo base" {
+		t.Errorf("script %q, want the one of .base", got)
+	}
+	if got := strings.Join(runs[types.StepPhaseBeforeScript], ";"); got != "echo setup" {
+		t.Errorf("before_script %q, want the one of .middle", got)
+	}
+	if strings.Join(job.Extends, ",") != ".middle" {
+		t.Errorf("extends %v, want .middle", job.Extends)
+	}
+}
+
+func TestGitlabExtendsCycle(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"self", `
+build:
+  extends: build
+  script: [echo build]
+`},
+		{"two templates", `
+.a:
+  extends: .b
+.b:
+  extends: .a
+build:
+  extends: .a
+  script: [echo build]
+`},
+		{"through a job", `
+.base:
+  extends: test
+test:
+  extends: .base
+  script: [echo test]
+`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseGitlab(t, tt.content)
+			if err == nil || !strings.Contains(err.Error(), "circular extends") {
+				t.Errorf("got %v, want a circular extends error", err)
+			}
+		})
+	}
+}
+
+// remoteIncludeServer serves the files of a remote include catalog; the
+// "/slow.yml" path answers only once the client is gone
+func remoteIncludeServer(t *testing.T, files map[string]string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow.yml" {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+			return
+		}
+		content, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, content)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// gitlabParserFor returns a parser reading the root file content and
+// keeping remote includes in a directory of the test
+func gitlabParserFor(t *testing.T, content string) *GitlabParser {
+	t.Helper()
+	p := NewGitlabParser()
+	p.SetFS(fstest.MapFS{".gitlab-ci.yml": &fstest.MapFile{Data: []byte(content)}})
+	p.SetRemoteCacheDir(t.TempDir())
+	return p
+}
+
+func TestGitlabRemoteInclude(t *testing.T) {
+	files := map[string]string{}
+	srv := remoteIncludeServer(t, files)
+	files["/remote.yml"] = "include: " + srv.URL + "/nested.yml\nremote:\n  script: [echo remote]\n"
+	files["/nested.yml"] = "nested:\n  script: [echo nested]\n"
+	files["/Jobs/Build.gitlab-ci.yml"] = "template:\n  script: [echo template]\n"
+	t.Setenv("GIT_CI_TEMPLATE_URL", srv.URL)
+
+	root := fmt.Sprintf("include:\n  - remote: %s/remote.yml\n  - template: Jobs/Build.gitlab-ci.yml\n", srv.URL)
+	p := gitlabParserFor(t, root)
+	pipeline, err := p.Parse(".gitlab-ci.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"remote", "nested", "template"} {
+		if pipeline.Jobs[name] == nil {
+			t.Errorf("no %s job", name)
+		}
+	}
+
+	// The copies kept on disk serve offline parses once the server is gone
+	srv.Close()
+	offline := gitlabParserFor(t, root)
+	offline.SetRemoteCacheDir(p.remoteDir)
+	offline.SetOffline(true)
+	pipeline, err = offline.Parse(".gitlab-ci.yml")
+	if err != nil {
+		t.Fatalf("offline parse: %v", err)
+	}
+	if len(pipeline.Jobs) != 3 {
+		t.Errorf("offline parse got %d jobs, want 3", len(pipeline.Jobs))
+	}
+}
+
+func TestGitlabRemoteIncludeErrors(t *testing.T) {
+	srv := remoteIncludeServer(t, map[string]string{
+		"/invalid.yml": "job: [unclosed\n",
+	})
+
+	tests := []struct {
+		name    string
+		path    string
+		offline bool
+		want    string
+	}{
+		{"not found", "/missing.yml", false, "404 Not Found"},
+		{"timeout", "/slow.yml", false, "failed to fetch remote include"},
+		{"invalid YAML", "/invalid.yml", false, "failed to parse included file"},
+		{"offline without a copy", "/missing.yml", true, "is not cached"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url := srv.URL + tt.path
+			p := gitlabParserFor(t, "include:\n  - remote: "+url+"\n")
+			p.SetIncludeTimeout(100 * time.Millisecond)
+			p.SetOffline(tt.offline)
+
+			start := time.Now()
+			_, err := p.Parse(".gitlab-ci.yml")
+			if err == nil || !strings.Contains(err.Error(), tt.want) || !strings.Contains(err.Error(), url) {
+				t.Errorf("got %v, want an error about %s containing %q", err, url, tt.want)
+			}
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Errorf("the include failed after %s, past its timeout", elapsed)
+			}
+		})
+	}
+}
+
+func TestGitlabInterruptibleKeepsFailureHandling(t *testing.T) {
+	pipeline, err := parseGitlab(t, `
+default:
+  interruptible: true
+lint:
+  script: [echo lint]
+test:
+  interruptible: false
+  script: [echo test]
+flaky:
+  allow_failure: true
+  script: [echo flaky]
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		job           string
+		interruptible bool
+		continueOnErr bool
+	}{
+		{"lint", true, false},
+		{"test", false, false},
+		{"flaky", true, true},
+	}
+	for _, tt := range tests {
+		job := pipeline.Jobs[tt.job]
+		if job.Interruptible != tt.interruptible {
+			t.Errorf("%s: interruptible %v, want %v", tt.job, job.Interruptible, tt.interruptible)
+		}
+		if job.ContinueOnErr != tt.continueOnErr {
+			t.Errorf("%s: continue on error %v, want %v (allow_failure only)", tt.job, job.ContinueOnErr, tt.continueOnErr)
+		}
+	}
+}
+
+func TestGitlabEmptyNeeds(t *testing.T) {
+	pipeline, err := parseGitlab(t, `
+stages: [build, test]
+compile:
+  stage: build
+  script: [echo compile]
+lint:
+  stage: test
+  needs: []
+  script: [echo lint]
+unit:
+  stage: test
+  script: [echo unit]
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job := pipeline.Jobs["lint"]; !job.NeedsSet || len(job.Needs) != 0 {
+		t.Errorf("lint: needs %v (set: %v), want an empty list set", job.Needs, job.NeedsSet)
+	}
+	if pipeline.Jobs["unit"].NeedsSet {
+		t.Error("unit: needs set without a needs key")
+	}
+}
+
+func TestGitlabDefaultTagsAndTimeout(t *testing.T) {
+	pipeline, err := parseGitlab(t, `
+default:
+  tags: [docker, linux]
+  timeout: 30m
+build:
+  script: [echo build]
+deploy:
+  tags: [deploy]
+  timeout: 2h
+  script: [echo deploy]
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	build, deploy := pipeline.Jobs["build"], pipeline.Jobs["deploy"]
+	if got := strings.Join(build.Tags, ","); got != "docker,linux" {
+		t.Errorf("build: tags %q, want the default docker,linux", got)
+	}
+	if build.TimeoutMin != 30 {
+		t.Errorf("build: timeout %d minutes, want the default 30", build.TimeoutMin)
+	}
+	if got := strings.Join(deploy.Tags, ","); got != "deploy" {
+		t.Errorf("deploy: tags %q, want its own deploy", got)
+	}
+	if deploy.TimeoutMin != 120 {
+		t.Errorf("deploy: timeout %d minutes, want its own 120", deploy.TimeoutMin)
+	}
+}
+
+// beforeScript returns the before_script of a job, if any
+func beforeScript(job *types.Job) string {
+	for _, step := range job.Steps {
+		if step.Phase == types.StepPhaseBeforeScript {
+			return step.Run
+		}
+	}
+	return ""
+}
+
+func TestGitlabDefaultPrecedence(t *testing.T) {
+	pipeline, err := parseGitlab(t, `
+image: alpine:3
+before_script: [echo global]
+default:
+  image: node:20
+  before_script: [echo default]
+  retry: 2
+plain:
+  script: [echo plain]
+own:
+  image: golang:1.22
+  before_script: [echo own]
+  retry: 1
+  script: [echo own]
+opted-out:
+  inherit:
+    default: false
+  script: [echo opted-out]
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		job, image, beforeScript string
+		retry                    int
+	}{
+		{"plain", "node:20", "echo default", 2},
+		{"own", "golang:1.22", "echo own", 1},
+		{"opted-out", "", "", 0},
+	}
+	for _, tt := range tests {
+		job := pipeline.Jobs[tt.job]
+		if job.Image != tt.image {
+			t.Errorf("%s: image %q, want %q", tt.job, job.Image, tt.image)
+		}
+		if got := beforeScript(job); got != tt.beforeScript {
+			t.Errorf("%s: before_script %q, want %q", tt.job, got, tt.beforeScript)
+		}
+		retry := 0
+		if job.Retry != nil {
+			retry = job.Retry.MaxAttempts
+		}
+		if retry != tt.retry {
+			t.Errorf("%s: retry %d, want %d", tt.job, retry, tt.retry)
+		}
+	}
+
+	// Without a default block, the global keywords apply
+	pipeline, err = parseGitlab(t, `
+image: alpine:3
+before_script: [echo global]
+plain:
+  script: [echo plain]
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job := pipeline.Jobs["plain"]; job.Image != "alpine:3" || beforeScript(job) != "echo global" {
+		t.Errorf("plain: image %q, before_script %q, want the global ones", job.Image, beforeScript(job))
+	}
+}
+
+func TestGitlabInherit(t *testing.T) {
+	pipeline, err := parseGitlab(t, `
+variables:
+  A: "1"
+  B: "2"
+  C: "3"
+default:
+  image: node:20
+  before_script: [echo default]
+  tags: [docker]
+all:
+  script: [echo all]
+no-variables:
+  inherit:
+    variables: false
+  script: [echo none]
+some-variables:
+  inherit:
+    variables: [A, C]
+  script: [echo some]
+some-defaults:
+  inherit:
+    default: [image, before_script]
+  script: [echo some]
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	excluded := map[string]string{
+		"all":            "",
+		"no-variables":   "A,B,C",
+		"some-variables": "B",
+		"some-defaults":  "",
+	}
+	for name, want := range excluded {
+		if got := strings.Join(pipeline.Jobs[name].ExcludedVariables, ","); got != want {
+			t.Errorf("%s: excluded variables %q, want %q", name, got, want)
+		}
+	}
+
+	job := pipeline.Jobs["some-defaults"]
+	if job.Image != "node:20" || beforeScript(job) != "echo default" {
+		t.Errorf("some-defaults: image %q, before_script %q, want the listed defaults", job.Image, beforeScript(job))
+	}
+	if len(job.Tags) != 0 {
+		t.Errorf("some-defaults: tags %v, want none as tags isn't listed", job.Tags)
+	}
+	if got := strings.Join(pipeline.Jobs["all"].Tags, ","); got != "docker" {
+		t.Errorf("all: tags %q, want the default docker", got)
+	}
+}
+
+func TestGitlabDependenciesCycle(t *testing.T) {
+	_, err := parseGitlab(t, `
+stages: [build]
+a:
+  stage: build
+  dependencies: [b]
+  script: [echo a]
+b:
+  stage: build
+  dependencies: [c]
+  script: [echo b]
+c:
+  stage: build
+  dependencies: [a]
+  script: [echo c]
+`)
+	if err == nil {
+		t.Fatal("parsed a cycle of dependencies")
+	}
+	if want := "circular dependency detected: a -> b -> c -> a"; !strings.Contains(err.Error(), want) {
+		t.Errorf("error %q, want it to contain %q", err, want)
+	}
+
+	_, err = parseGitlab(t, `
+a:
+  dependencies: [missing]
+  script: [echo a]
+`)
+	// Without needs, dependencies order the jobs as needs would
+	if want := "job 'a' depends on non-existent job 'missing'"; err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("error %v, want it to contain %q", err, want)
+	}
+}
+
+func TestGitlabPreAndPostStages(t *testing.T) {
+	pipeline, err := parseGitlab(t, `
+stages: [build, test]
+lint:
+  stage: .pre
+  script: [echo lint]
+build:
+  stage: build
+  script: [echo build]
+unit:
+  stage: test
+  script: [echo unit]
+notify:
+  stage: .post
+  script: [echo notify]
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(pipeline.Stages, ","); got != ".pre,build,test,.post" {
+		t.Errorf("stages %s, want .pre,build,test,.post", got)
+	}
+}
+
+// serviceImages returns the images of the services of a job, sorted
+func serviceImages(job *types.Job) string {
+	var images []string
+	for _, service := range job.Services {
+		images = append(images, service.Image)
+	}
+	sort.Strings(images)
+	return strings.Join(images, ",")
+}
+
+func TestGitlabDefaultDindServices(t *testing.T) {
+	pipeline, err := parseGitlab(t, `
+default:
+  image:
+    name: docker:24
+    entrypoint: [""]
+  services:
+    - name: docker:24-dind
+      alias: docker
+build:
+  script: [docker build .]
+integration:
+  services:
+    - postgres:16
+  script: [docker compose up --wait]
+own-image:
+  image: alpine:3
+  script: [echo own]
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		job, image, services string
+		entrypoint           []string
+	}{
+		{"build", "docker:24", "docker:24-dind", []string{""}},
+		{"integration", "docker:24", "docker:24-dind,postgres:16", []string{""}},
+		{"own-image", "alpine:3", "docker:24-dind", nil},
+	}
+	for _, tt := range tests {
+		job := pipeline.Jobs[tt.job]
+		if job.Image != tt.image {
+			t.Errorf("%s: image %q, want %q", tt.job, job.Image, tt.image)
+		}
+		if got := serviceImages(job); got != tt.services {
+			t.Errorf("%s: services %s, want %s", tt.job, got, tt.services)
+		}
+		if job.Container == nil || !slices.Equal(job.Container.Entrypoint, tt.entrypoint) {
+			t.Errorf("%s: container %+v, want entrypoint %q", tt.job, job.Container, tt.entrypoint)
+		}
+	}
+	if alias := pipeline.Jobs["integration"].Services["docker"]; alias == nil || alias.Alias != "docker" {
+		t.Errorf("integration: dind service %+v, want it under its alias docker", alias)
+	}
+
+	// Top-level image and services work the same
+	pipeline, err = parseGitlab(t, `
+image: docker:24
+services: [docker:24-dind]
+integration:
+  services: [postgres:16]
+  script: [docker compose up --wait]
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := serviceImages(pipeline.Jobs["integration"]); got != "docker:24-dind,postgres:16" {
+		t.Errorf("integration: services %s, want docker:24-dind,postgres:16", got)
+	}
+}
+
+func TestGitlabVariableForms(t *testing.T) {
+	pipeline, err := parseGitlab(t, `
+variables:
+  PLAIN: bar
+  NUMBER: 3
+  DEPLOY_ENV:
+    value: staging
+    description: Where to deploy
+    options: [staging, production]
+  RAW:
+    value: $HOME
+    expand: false
+build:
+  variables:
+    JOB_LEVEL:
+      value: from-job
+      description: Ignored outside the pipeline level
+  script: [echo $PLAIN]
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every variable reaches the environment as a scalar
+	env := map[string]string{"PLAIN": "bar", "NUMBER": "3", "DEPLOY_ENV": "staging", "RAW": "$HOME"}
+	for name, want := range env {
+		if got := pipeline.Environment[name]; got != want {
+			t.Errorf("environment %s = %q, want %q", name, got, want)
+		}
+	}
+	if got := pipeline.Jobs["build"].Environment["JOB_LEVEL"]; got != "from-job" {
+		t.Errorf("build: JOB_LEVEL = %q, want from-job", got)
+	}
+
+	// Only the map form is kept as a variable definition
+	if _, ok := pipeline.Variables["PLAIN"]; ok {
+		t.Error("plain variable PLAIN has a definition")
+	}
+	deploy := pipeline.Variables["DEPLOY_ENV"]
+	if deploy == nil {
+		t.Fatal("DEPLOY_ENV has no definition")
+	}
+	if deploy.Value != "staging" || deploy.Description != "Where to deploy" ||
+		!slices.Equal(deploy.Options, []string{"staging", "production"}) || !deploy.Expand {
+		t.Errorf("DEPLOY_ENV = %+v", deploy)
+	}
+	if raw := pipeline.Variables["RAW"]; raw == nil || raw.Expand {
+		t.Errorf("RAW = %+v, want expand false", raw)
+	}
+}
+
+func TestGitlabMergeAnchorsSkipsIncludeCache(t *testing.T) {
+	fsys := newCountingFS(map[string]string{
+		".gitlab-ci.yml": `
+include:
+  - local: templates.yml
+test:
+  <<: *defaults
+  script: [make test]
+`,
+		"templates.yml": `
+.defaults: &defaults
+  image: golang:1.23
+  tags: [docker]
+`,
+	})
+
+	// Each file is decoded alone by default, like GitLab does
+	p := NewGitlabParser()
+	p.SetFS(fsys)
+	if _, err := p.Parse(".gitlab-ci.yml"); err == nil || !strings.Contains(err.Error(), "defaults") {
+		t.Fatalf("got %v, want an unknown anchor error", err)
+	}
+
+	p.SetMergeAnchors(true)
+	pipeline, err := p.Parse(".gitlab-ci.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if test := pipeline.Jobs["test"]; test.Image != "golang:1.23" || !slices.Equal(test.Tags, []string{"docker"}) {
+		t.Fatalf("test: image %q tags %v, want those of the anchor", test.Image, test.Tags)
+	}
+
+	// A changed include is read again on the next parse, not cached
+	fsys.FS.(fstest.MapFS)["templates.yml"].Data = []byte(`
+.defaults: &defaults
+  image: golang:1.24
+`)
+	if pipeline, err = p.Parse(".gitlab-ci.yml"); err != nil {
+		t.Fatal(err)
+	}
+	if got := pipeline.Jobs["test"].Image; got != "golang:1.24" {
+		t.Errorf("test: image %q after the include changed, want golang:1.24", got)
+	}
+	if len(p.includeCache) != 0 {
+		t.Errorf("%d cached includes, want none with merged anchors", len(p.includeCache))
+	}
+	if got := fsys.opens["templates.yml"]; got != 2 {
+		t.Errorf("templates.yml read %d times by the two merged parses, want 2", got)
+	}
+}
@@ -0,0 +1,83 @@
+package parsers
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/sanix-darker/git-ci/pkg/types"
+	yaml "gopkg.in/yaml.v3"
+)
+
+// LocalHintsKey is the top-level key holding local-only job hints. GitLab
+// configs use the hidden form (.x-git-ci) so GitLab itself ignores it.
+const LocalHintsKey = "x-git-ci"
+
+// LocalHintsEnv is the job env variable holding the hints of a GitHub
+// job, as a YAML mapping ("{runner: docker, memory: 4g}"). GitHub passes
+// it to the steps like any variable and otherwise ignores it.
+const LocalHintsEnv = "GIT_CI_HINTS"
+
+// decodeHintsValue decodes the hints of one job from a YAML string
+func decodeHintsValue(value string) (*types.LocalHints, error) {
+	var hint types.LocalHints
+	if err := yaml.Unmarshal([]byte(value), &hint); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", LocalHintsEnv, err)
+	}
+	return &hint, nil
+}
+
+// decodeLocalHints converts a raw `x-git-ci:` block to hints per job
+func decodeLocalHints(raw interface{}) (map[string]*types.LocalHints, error) {
+	if raw == nil {
+		return nil, nil
+	}
+
+	data, err := yaml.Marshal(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s block: %w", LocalHintsKey, err)
+	}
+
+	var hints map[string]*types.LocalHints
+	if err := yaml.Unmarshal(data, &hints); err != nil {
+		return nil, fmt.Errorf("invalid %s block: %w", LocalHintsKey, err)
+	}
+
+	return hints, nil
+}
+
+// attachLocalHints sets the hints on their jobs and rejects hints for
+// jobs that don't exist
+func attachLocalHints(pipeline *types.Pipeline, hints map[string]*types.LocalHints) error {
+	var unknown []string
+	for name, hint := range hints {
+		if hint != nil && hint.Runner != "" && hint.Runner != "bash" && hint.Runner != "docker" && hint.Runner != "podman" {
+			return fmt.Errorf("%s: job '%s' has unknown runner '%s' (expected bash, docker or podman)", LocalHintsKey, name, hint.Runner)
+		}
+
+		if job, exists := pipeline.Jobs[name]; exists {
+			job.Local = hint
+			continue
+		}
+
+		// A hint on a parallel:matrix or strategy matrix job applies to
+		// each of its variants
+		matched := false
+		for jobName, job := range pipeline.Jobs {
+			if strings.HasPrefix(jobName, name+": [") || job.MatrixOf == name {
+				job.Local = hint
+				matched = true
+			}
+		}
+		if !matched {
+			unknown = append(unknown, name)
+		}
+	}
+
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("%s: unknown job(s): %s", LocalHintsKey, strings.Join(unknown, ", "))
+	}
+
+	return nil
+}
@@ -0,0 +1,68 @@
+package parsers
+
+import "github.com/sanix-darker/git-ci/pkg/types"
+
+// jobVariants maps the ID of a job the parser replaced to the jobs it was
+// expanded into: the variants of a matrix job, or the jobs of an inlined
+// reusable workflow. A need on the original ID means all of them, a need
+// on one of them just that one. Outputs of an expanded job read through
+// needs are those of its variants merged in order, the last variant
+// setting an output winning, as on GitHub.
+type jobVariants map[string][]string
+
+// add records that the job id was expanded into the job name
+func (v jobVariants) add(id, name string) {
+	v[id] = append(v[id], name)
+}
+
+// resolve returns refs with the references to expanded jobs replaced by
+// their variants. References to jobs that don't exist are kept for
+// validation to report.
+func (v jobVariants) resolve(refs []string) []string {
+	if len(v) == 0 || len(refs) == 0 {
+		return refs
+	}
+
+	var result []string
+	for _, ref := range refs {
+		if expanded, ok := v[ref]; ok {
+			result = append(result, expanded...)
+		} else {
+			result = append(result, ref)
+		}
+	}
+	return result
+}
+
+// resolveNeedList is resolve for needs, variants keeping the optional
+// and artifacts flags of the need on their job
+func (v jobVariants) resolveNeedList(needs []types.Need) []types.Need {
+	if len(v) == 0 || len(needs) == 0 {
+		return needs
+	}
+
+	var result []types.Need
+	for _, need := range needs {
+		expanded, ok := v[need.Job]
+		if !ok {
+			result = append(result, need)
+			continue
+		}
+		for _, name := range expanded {
+			variant := need
+			variant.Job = name
+			result = append(result, variant)
+		}
+	}
+	return result
+}
+
+// resolveNeeds points the needs and dependencies of the jobs of a pipeline
+// at the jobs they stand for once all jobs are expanded, so validation and
+// scheduling only see job names that exist
+func (v jobVariants) resolveNeeds(pipeline *types.Pipeline) {
+	for _, job := range pipeline.Jobs {
+		job.Needs = v.resolveNeedList(job.Needs)
+		job.Dependencies = v.resolve(job.Dependencies)
+	}
+}
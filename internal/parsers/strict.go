@@ -0,0 +1,159 @@
+package parsers
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// gitlabJobKeywords are the keys a GitLab job may have
+var gitlabJobKeywords = []string{
+	"after_script", "allow_failure", "artifacts", "before_script", "cache",
+	"coverage", "dast_configuration", "dependencies", "environment", "except",
+	"extends", "hooks", "id_tokens", "identity", "image", "inherit",
+	"interruptible", "manual", "manual_confirmation", "needs", "only", "pages",
+	"parallel", "publish", "release", "resource_group", "retry", "rules", "run",
+	"script", "secrets", "services", "stage", "start_in", "tags", "timeout",
+	"trigger", "variables", "when",
+}
+
+// Values allowed for the enum keywords of GitLab jobs
+var (
+	gitlabWhenValues          = []string{"on_success", "on_failure", "always", "manual", "delayed", "never"}
+	gitlabArtifactsWhenValues = []string{"on_success", "on_failure", "always"}
+	gitlabCachePolicyValues   = []string{"pull", "push", "pull-push"}
+	gitlabPullPolicyValues    = []string{"always", "if-not-present", "never"}
+)
+
+// SetStrict enables the strict checks of GitLab files: unknown job keys,
+// values of enum keywords and top-level keys that are not jobs are errors
+func (p *GitlabParser) SetStrict(strict bool) {
+	p.strict = strict
+}
+
+// strictProblem records a problem found by the strict checks, reported
+// by Validate. Without strict mode it is only a warning.
+func (p *GitlabParser) strictProblem(format string, args ...interface{}) {
+	problem := fmt.Sprintf(format, args...)
+	if !p.strict {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", problem)
+		return
+	}
+	for _, known := range p.strictProblems {
+		if known == problem {
+			return
+		}
+	}
+	p.strictProblems = append(p.strictProblems, problem)
+}
+
+// checkJobKeys reports the unknown keys of a job and the invalid values of
+// its enum keywords
+func (p *GitlabParser) checkJobKeys(name string, jobData map[string]interface{}) {
+	if !p.strict {
+		return
+	}
+
+	keys := make([]string, 0, len(jobData))
+	for key := range jobData {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if containsString(gitlabJobKeywords, key) {
+			continue
+		}
+		if suggestion := suggestKeyword(key, gitlabJobKeywords); suggestion != "" {
+			p.strictProblem("job '%s' has unknown key '%s' (did you mean '%s'?)", name, key, suggestion)
+		} else {
+			p.strictProblem("job '%s' has unknown key '%s'", name, key)
+		}
+	}
+
+	p.checkEnum(name, "when", jobData["when"], gitlabWhenValues)
+	if rules, ok := jobData["rules"].([]interface{}); ok {
+		for i, rule := range rules {
+			if ruleMap, ok := rule.(map[string]interface{}); ok {
+				p.checkEnum(name, fmt.Sprintf("rules[%d]:when", i), ruleMap["when"], gitlabWhenValues)
+			}
+		}
+	}
+
+	if artifacts, ok := jobData["artifacts"].(map[string]interface{}); ok {
+		p.checkEnum(name, "artifacts:when", artifacts["when"], gitlabArtifactsWhenValues)
+	}
+
+	caches, _ := jobData["cache"].([]interface{})
+	if cache, ok := jobData["cache"].(map[string]interface{}); ok {
+		caches = []interface{}{cache}
+	}
+	for _, cache := range caches {
+		if cacheMap, ok := cache.(map[string]interface{}); ok {
+			p.checkEnum(name, "cache:policy", cacheMap["policy"], gitlabCachePolicyValues)
+			p.checkEnum(name, "cache:when", cacheMap["when"], gitlabArtifactsWhenValues)
+		}
+	}
+
+	if image, ok := jobData["image"].(map[string]interface{}); ok {
+		p.checkEnum(name, "image:pull_policy", image["pull_policy"], gitlabPullPolicyValues)
+	}
+	if services, ok := jobData["services"].([]interface{}); ok {
+		for _, service := range services {
+			if serviceMap, ok := service.(map[string]interface{}); ok {
+				p.checkEnum(name, "services:pull_policy", serviceMap["pull_policy"], gitlabPullPolicyValues)
+			}
+		}
+	}
+}
+
+// checkEnum reports a value (or list of values) of a job keyword that is
+// not one of the allowed ones; values using variables are not checked
+func (p *GitlabParser) checkEnum(job, keyword string, value interface{}, allowed []string) {
+	values, _ := value.([]interface{})
+	if value, ok := value.(string); ok {
+		values = []interface{}{value}
+	}
+
+	for _, v := range values {
+		s, ok := v.(string)
+		if !ok || strings.Contains(s, "$") || containsString(allowed, s) {
+			continue
+		}
+		p.strictProblem("job '%s' %s: invalid value '%s' (allowed: %s)", job, keyword, s, strings.Join(allowed, ", "))
+	}
+}
+
+// suggestKeyword returns the keyword closest to an unknown key, or ""
+// when none is close enough
+func suggestKeyword(key string, keywords []string) string {
+	best, bestDistance := "", -1
+	for _, keyword := range keywords {
+		distance := editDistance(key, keyword)
+		if distance <= max(2, len(key)/3) && (bestDistance < 0 || distance < bestDistance) {
+			best, bestDistance = keyword, distance
+		}
+	}
+	return best
+}
+
+// editDistance returns the Levenshtein distance between two strings
+func editDistance(a, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
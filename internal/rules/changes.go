@@ -0,0 +1,77 @@
+package rules
+
+import (
+	"os"
+	"regexp"
+	"strings"
+)
+
+// AnyChanged reports whether one of the changed files matches a pattern
+// of `changes:`. Patterns may use variables from env. Unknown changes
+// (nil) always match, as they can't rule a job out.
+func AnyChanged(patterns, changed []string, env map[string]string) bool {
+	if changed == nil {
+		return true
+	}
+	for _, pattern := range patterns {
+		pattern = os.Expand(pattern, func(name string) string { return env[name] })
+		re, err := globRegexp(strings.TrimPrefix(pattern, "./"))
+		if err != nil {
+			continue
+		}
+		for _, file := range changed {
+			if re.MatchString(file) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// globRegexp compiles a glob pattern to an anchored regular expression:
+// * and ? within a directory, ** across directories, [...] classes and
+// {a,b} alternatives
+func globRegexp(pattern string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("^")
+	braces := 0
+	for i := 0; i < len(pattern); i++ {
+		ch := pattern[i]
+		switch {
+		case ch == '*' && strings.HasPrefix(pattern[i:], "**/"):
+			b.WriteString("(?:.*/)?")
+			i += 2
+		case ch == '*' && strings.HasPrefix(pattern[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case ch == '*':
+			b.WriteString("[^/]*")
+		case ch == '?':
+			b.WriteString("[^/]")
+		case ch == '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + class + "]")
+			i += end + 1
+		case ch == '{':
+			braces++
+			b.WriteString("(?:")
+		case ch == '}' && braces > 0:
+			braces--
+			b.WriteString(")")
+		case ch == ',' && braces > 0:
+			b.WriteString("|")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(ch)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
@@ -0,0 +1,337 @@
+package rules
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// CheckIf reports syntax errors in a `rules:if` expression without
+// evaluating it
+func CheckIf(expr string) error {
+	tokens, err := tokenize(expr)
+	if err != nil {
+		return fmt.Errorf("invalid expression '%s': %w", expr, err)
+	}
+
+	p := &exprParser{tokens: tokens, checkOnly: true}
+	if _, err := p.parseOr(); err != nil {
+		return fmt.Errorf("invalid expression '%s': %w", expr, err)
+	}
+	if p.pos < len(p.tokens) {
+		return fmt.Errorf("invalid expression '%s': unexpected '%s'", expr, p.tokens[p.pos].text)
+	}
+	return nil
+}
+
+// EvalIf evaluates a GitLab `rules:if` expression. It supports variables
+// ($VAR, ${VAR}), quoted strings, /regex/ literals, null, the ==, !=, =~
+// and !~ operators, && (binding tighter than ||) and parentheses. A lone
+// variable is true when it is set and not empty.
+func EvalIf(expr string, env map[string]string) (bool, error) {
+	tokens, err := tokenize(expr)
+	if err != nil {
+		return false, fmt.Errorf("invalid expression '%s': %w", expr, err)
+	}
+
+	p := &exprParser{tokens: tokens, env: env}
+	result, err := p.parseOr()
+	if err != nil {
+		return false, fmt.Errorf("invalid expression '%s': %w", expr, err)
+	}
+	if p.pos < len(p.tokens) {
+		return false, fmt.Errorf("invalid expression '%s': unexpected '%s'", expr, p.tokens[p.pos].text)
+	}
+
+	return result.truthy(), nil
+}
+
+type tokenKind int
+
+const (
+	tokVariable tokenKind = iota
+	tokString
+	tokRegex
+	tokNull
+	tokOperator
+	tokLParen
+	tokRParen
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+func tokenize(expr string) ([]token, error) {
+	var tokens []token
+
+	for i := 0; i < len(expr); {
+		ch := expr[i]
+		switch {
+		case ch == ' ' || ch == '\t' || ch == '\n':
+			i++
+
+		case ch == '(':
+			tokens = append(tokens, token{kind: tokLParen, text: "("})
+			i++
+
+		case ch == ')':
+			tokens = append(tokens, token{kind: tokRParen, text: ")"})
+			i++
+
+		case ch == '$':
+			i++
+			braced := i < len(expr) && expr[i] == '{'
+			if braced {
+				i++
+			}
+			start := i
+			for i < len(expr) && isNameChar(expr[i]) {
+				i++
+			}
+			if start == i {
+				return nil, fmt.Errorf("empty variable name")
+			}
+			name := expr[start:i]
+			if braced {
+				if i >= len(expr) || expr[i] != '}' {
+					return nil, fmt.Errorf("unterminated ${%s", name)
+				}
+				i++
+			}
+			tokens = append(tokens, token{kind: tokVariable, text: name})
+
+		case ch == '"' || ch == '\'':
+			end := strings.IndexByte(expr[i+1:], ch)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			tokens = append(tokens, token{kind: tokString, text: expr[i+1 : i+1+end]})
+			i += end + 2
+
+		case ch == '/':
+			// Regex literal: /pattern/flags
+			j := i + 1
+			for j < len(expr) && expr[j] != '/' {
+				if expr[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(expr) {
+				return nil, fmt.Errorf("unterminated regex")
+			}
+			j++
+			for j < len(expr) && expr[j] >= 'a' && expr[j] <= 'z' {
+				j++
+			}
+			tokens = append(tokens, token{kind: tokRegex, text: expr[i:j]})
+			i = j
+
+		case strings.HasPrefix(expr[i:], "=="), strings.HasPrefix(expr[i:], "!="),
+			strings.HasPrefix(expr[i:], "=~"), strings.HasPrefix(expr[i:], "!~"),
+			strings.HasPrefix(expr[i:], "&&"), strings.HasPrefix(expr[i:], "||"):
+			tokens = append(tokens, token{kind: tokOperator, text: expr[i : i+2]})
+			i += 2
+
+		case strings.HasPrefix(expr[i:], "null"):
+			tokens = append(tokens, token{kind: tokNull, text: "null"})
+			i += 4
+
+		default:
+			return nil, fmt.Errorf("unexpected character '%c'", ch)
+		}
+	}
+
+	return tokens, nil
+}
+
+func isNameChar(ch byte) bool {
+	return ch == '_' || (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z') || (ch >= '0' && ch <= '9')
+}
+
+// value is an operand or the result of a comparison
+type value struct {
+	str     string
+	null    bool
+	regex   string // Set for /regex/ literals
+	boolean *bool  // Set for comparison results
+}
+
+func (v value) truthy() bool {
+	if v.boolean != nil {
+		return *v.boolean
+	}
+	return !v.null && (v.str != "" || v.regex != "")
+}
+
+func boolValue(b bool) value {
+	return value{boolean: &b}
+}
+
+type exprParser struct {
+	tokens []token
+	pos    int
+	env    map[string]string
+
+	// checkOnly skips regex matching, whose operands are only known at
+	// evaluation
+	checkOnly bool
+}
+
+func (p *exprParser) peek() *token {
+	if p.pos < len(p.tokens) {
+		return &p.tokens[p.pos]
+	}
+	return nil
+}
+
+func (p *exprParser) parseOr() (value, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return value{}, err
+	}
+	for tok := p.peek(); tok != nil && tok.kind == tokOperator && tok.text == "||"; tok = p.peek() {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return value{}, err
+		}
+		left = boolValue(left.truthy() || right.truthy())
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseAnd() (value, error) {
+	left, err := p.parseComparison()
+	if err != nil {
+		return value{}, err
+	}
+	for tok := p.peek(); tok != nil && tok.kind == tokOperator && tok.text == "&&"; tok = p.peek() {
+		p.pos++
+		right, err := p.parseComparison()
+		if err != nil {
+			return value{}, err
+		}
+		left = boolValue(left.truthy() && right.truthy())
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseComparison() (value, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return value{}, err
+	}
+
+	tok := p.peek()
+	if tok == nil || tok.kind != tokOperator || tok.text == "&&" || tok.text == "||" {
+		return left, nil
+	}
+	p.pos++
+
+	right, err := p.parseOperand()
+	if err != nil {
+		return value{}, err
+	}
+
+	switch tok.text {
+	case "==":
+		return boolValue(equal(left, right)), nil
+	case "!=":
+		return boolValue(!equal(left, right)), nil
+	case "=~", "!~":
+		if p.checkOnly {
+			return boolValue(false), nil
+		}
+		matched, err := match(left, right)
+		if err != nil {
+			return value{}, err
+		}
+		if tok.text == "!~" {
+			matched = !matched
+		}
+		return boolValue(matched), nil
+	}
+
+	return value{}, fmt.Errorf("unknown operator '%s'", tok.text)
+}
+
+func (p *exprParser) parseOperand() (value, error) {
+	tok := p.peek()
+	if tok == nil {
+		return value{}, fmt.Errorf("unexpected end of expression")
+	}
+	p.pos++
+
+	switch tok.kind {
+	case tokVariable:
+		v, ok := p.env[tok.text]
+		if !ok {
+			return value{null: true}, nil
+		}
+		return value{str: v}, nil
+	case tokString:
+		return value{str: tok.text}, nil
+	case tokRegex:
+		return value{regex: tok.text}, nil
+	case tokNull:
+		return value{null: true}, nil
+	case tokLParen:
+		inner, err := p.parseOr()
+		if err != nil {
+			return value{}, err
+		}
+		if next := p.peek(); next == nil || next.kind != tokRParen {
+			return value{}, fmt.Errorf("missing ')'")
+		}
+		p.pos++
+		return inner, nil
+	}
+
+	return value{}, fmt.Errorf("unexpected '%s'", tok.text)
+}
+
+func equal(a, b value) bool {
+	if a.null || b.null {
+		return a.null == b.null
+	}
+	return a.str == b.str
+}
+
+// match applies a regex to a value. The pattern is a /regex/ literal or a
+// variable holding one.
+func match(subject, pattern value) (bool, error) {
+	literal := pattern.regex
+	if literal == "" {
+		literal = pattern.str
+	}
+	if !strings.HasPrefix(literal, "/") || strings.LastIndex(literal, "/") == 0 {
+		return false, fmt.Errorf("right side of =~ must be a /regex/")
+	}
+	if subject.null {
+		return false, nil
+	}
+
+	re, err := compileRegex(literal)
+	if err != nil {
+		return false, err
+	}
+	return re.MatchString(subject.str), nil
+}
+
+// compileRegex compiles a /pattern/flags literal, honoring the i flag
+func compileRegex(literal string) (*regexp.Regexp, error) {
+	end := strings.LastIndex(literal, "/")
+	expr, flags := literal[1:end], literal[end+1:]
+	if strings.Contains(flags, "i") {
+		expr = "(?i)" + expr
+	}
+
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regex %s: %w", literal, err)
+	}
+	return re, nil
+}
@@ -0,0 +1,148 @@
+package rules
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sanix-darker/git-ci/pkg/types"
+)
+
+// refKeywords maps the `only`/`except` ref keywords to the pipeline
+// source they select. branches and tags are matched on the ref itself.
+var refKeywords = map[string]string{
+	"api":                    "api",
+	"chat":                   "chat",
+	"external":               "external",
+	"external_pull_requests": "external_pull_request_event",
+	"merge_requests":         "merge_request_event",
+	"pipelines":              "pipeline",
+	"pushes":                 "push",
+	"schedules":              "schedule",
+	"triggers":               "trigger",
+	"web":                    "web",
+}
+
+// EvaluateOnlyExcept applies GitLab `only`/`except` to the pipeline
+// described by env (CI_COMMIT_BRANCH, CI_COMMIT_TAG, CI_COMMIT_REF_NAME,
+// CI_PIPELINE_SOURCE). Within a keyword any entry may match; the keywords
+// of a block must all match. It returns whether the job runs and, if not,
+// why. `changes` is matched against the changed files, matching when they
+// are unknown (nil); `kubernetes` can't be checked locally and matches.
+// Unlike GitLab, a job without `only` is not limited to branches and tags,
+// so it still runs outside of a git checkout.
+func EvaluateOnlyExcept(only, except *types.OnlyExcept, env map[string]string, changed []string) (bool, string, error) {
+	if only != nil {
+		matched, err := matchOnlyExcept(only, env, changed)
+		if err != nil {
+			return false, "", fmt.Errorf("only: %w", err)
+		}
+		if !matched {
+			return false, fmt.Sprintf("only: %s", describeOnlyExcept(only)), nil
+		}
+	}
+
+	if except != nil {
+		matched, err := matchOnlyExcept(except, env, changed)
+		if err != nil {
+			return false, "", fmt.Errorf("except: %w", err)
+		}
+		if matched {
+			return false, fmt.Sprintf("except: %s", describeOnlyExcept(except)), nil
+		}
+	}
+
+	return true, "", nil
+}
+
+// matchOnlyExcept reports whether all keywords of a block match
+func matchOnlyExcept(oe *types.OnlyExcept, env map[string]string, changed []string) (bool, error) {
+	if len(oe.Changes) > 0 && !AnyChanged(oe.Changes, changed, env) {
+		return false, nil
+	}
+	if len(oe.Refs) == 0 && len(oe.Variables) == 0 {
+		// Only changes or kubernetes, which always matches locally
+		return true, nil
+	}
+
+	if len(oe.Refs) > 0 {
+		matched := false
+		for _, ref := range oe.Refs {
+			ok, err := matchRef(ref, env)
+			if err != nil {
+				return false, err
+			}
+			if ok {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false, nil
+		}
+	}
+
+	if len(oe.Variables) > 0 {
+		matched := false
+		for _, expr := range oe.Variables {
+			ok, err := EvalIf(expr, env)
+			if err != nil {
+				return false, err
+			}
+			if ok {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// matchRef matches one `refs` entry: a keyword, a ref name or a /regex/
+func matchRef(ref string, env map[string]string) (bool, error) {
+	source := env["CI_PIPELINE_SOURCE"]
+
+	switch ref {
+	case "branches":
+		return env["CI_COMMIT_BRANCH"] != "" && source != "merge_request_event", nil
+	case "tags":
+		return env["CI_COMMIT_TAG"] != "", nil
+	}
+	if want, ok := refKeywords[ref]; ok {
+		return source == want, nil
+	}
+
+	name := env["CI_COMMIT_REF_NAME"]
+	if strings.HasPrefix(ref, "/") && strings.LastIndex(ref, "/") > 0 {
+		re, err := compileRegex(ref)
+		if err != nil {
+			return false, err
+		}
+		return re.MatchString(name), nil
+	}
+
+	// Refs of other projects (ref@group/project) are never this one
+	if strings.Contains(ref, "@") {
+		return false, nil
+	}
+
+	return ref == name, nil
+}
+
+// describeOnlyExcept summarizes a block for skip reasons
+func describeOnlyExcept(oe *types.OnlyExcept) string {
+	var parts []string
+	if len(oe.Refs) > 0 {
+		parts = append(parts, "refs "+strings.Join(oe.Refs, ", "))
+	}
+	if len(oe.Variables) > 0 {
+		parts = append(parts, "variables "+strings.Join(oe.Variables, ", "))
+	}
+	if len(oe.Changes) > 0 {
+		parts = append(parts, "changes "+strings.Join(oe.Changes, ", "))
+	}
+	return strings.Join(parts, "; ")
+}
@@ -0,0 +1,153 @@
+package rules
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sanix-darker/git-ci/pkg/types"
+)
+
+// Values of `when` a rule can resolve to
+const (
+	WhenOnSuccess = "on_success"
+	WhenAlways    = "always"
+	WhenManual    = "manual"
+	WhenDelayed   = "delayed"
+	WhenNever     = "never"
+)
+
+// Result is the outcome of a job's rules
+type Result struct {
+	When           string            // How the job runs, WhenNever if it doesn't
+	Variables      map[string]string // Variables of the matching rule
+	AllowFailure   bool
+	AllowExitCodes []int  // Exit codes allowed to fail with, from allow_failure:exit_codes
+	StartIn        string // Delay of a delayed job
+	Rule           int    // Index of the matching rule, -1 when none matched
+}
+
+// Runs reports whether the job is part of the pipeline
+func (r *Result) Runs() bool {
+	return r.When != WhenNever
+}
+
+// Evaluate applies GitLab rules: the first rule whose conditions all match
+// decides how the job runs; when none matches, the job is not added.
+// `changes` is matched against the changed files, and always matches when
+// they are unknown (nil); `exists` is checked against workdir.
+func Evaluate(rules []types.Rule, env map[string]string, workdir string, changed []string) (*Result, error) {
+	for i, rule := range rules {
+		if rule.If != "" {
+			ok, err := EvalIf(rule.If, env)
+			if err != nil {
+				return nil, fmt.Errorf("rule %d: %w", i+1, err)
+			}
+			if !ok {
+				continue
+			}
+		}
+
+		if len(rule.Exists) > 0 && !anyExists(rule.Exists, workdir) {
+			continue
+		}
+
+		if len(rule.Changes) > 0 && !AnyChanged(rule.Changes, changed, env) {
+			continue
+		}
+
+		when := rule.When
+		if when == "" {
+			when = WhenOnSuccess
+		}
+		return &Result{
+			When:           when,
+			Variables:      rule.Variables,
+			AllowFailure:   rule.AllowFailure,
+			AllowExitCodes: rule.AllowExitCodes,
+			StartIn:        rule.StartIn,
+			Rule:           i,
+		}, nil
+	}
+
+	return &Result{When: WhenNever, Rule: -1}, nil
+}
+
+// anyExists reports whether one of the glob patterns matches a file;
+// patterns with ** match at any depth below workdir
+func anyExists(patterns []string, workdir string) bool {
+	for _, pattern := range patterns {
+		if strings.Contains(pattern, "**") {
+			if existsDeep(pattern, workdir) {
+				return true
+			}
+			continue
+		}
+		matches, err := filepath.Glob(filepath.Join(workdir, pattern))
+		if err == nil && len(matches) > 0 {
+			return true
+		}
+		if _, err := os.Stat(filepath.Join(workdir, pattern)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Glob returns the files and directories below workdir a pattern
+// matches: those of filepath.Glob, or for patterns with ** those a walk of
+// workdir finds, the .git directory left out
+func Glob(pattern, workdir string) ([]string, error) {
+	if !strings.Contains(pattern, "**") {
+		return filepath.Glob(filepath.Join(workdir, pattern))
+	}
+	re, err := globRegexp(strings.TrimPrefix(pattern, "./"))
+	if err != nil {
+		return nil, err
+	}
+
+	var matches []string
+	err = filepath.WalkDir(workdir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() && d.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		rel, relErr := filepath.Rel(workdir, path)
+		if relErr == nil && rel != "." && re.MatchString(filepath.ToSlash(rel)) {
+			matches = append(matches, path)
+		}
+		return nil
+	})
+	return matches, err
+}
+
+// errFound stops the walk of existsDeep at the first match
+var errFound = errors.New("found")
+
+// existsDeep reports whether a file below workdir matches a pattern using
+// **, the .git directory left out
+func existsDeep(pattern, workdir string) bool {
+	re, err := globRegexp(strings.TrimPrefix(pattern, "./"))
+	if err != nil {
+		return false
+	}
+	err = filepath.WalkDir(workdir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() && d.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		rel, relErr := filepath.Rel(workdir, path)
+		if relErr == nil && rel != "." && re.MatchString(filepath.ToSlash(rel)) {
+			return errFound
+		}
+		return nil
+	})
+	return errors.Is(err, errFound)
+}
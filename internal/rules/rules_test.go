@@ -0,0 +1,45 @@
+package rules
+
+import (
+	"testing"
+
+	"github.com/sanix-darker/git-ci/pkg/types"
+)
+
+func TestEvaluate(t *testing.T) {
+	env := map[string]string{"CI_COMMIT_BRANCH": "main"}
+	tests := []struct {
+		name    string
+		rules   []types.Rule
+		when    string
+		rule    int
+		wantErr bool
+	}{
+		{"first match", []types.Rule{
+			{If: `$CI_COMMIT_BRANCH == "dev"`, When: "always"},
+			{If: `$CI_COMMIT_BRANCH == "main"`, When: "manual"},
+		}, WhenManual, 1, false},
+		{"default when", []types.Rule{{If: `$CI_COMMIT_BRANCH =~ /^ma/`}}, WhenOnSuccess, 0, false},
+		{"null", []types.Rule{{If: `$CI_COMMIT_TAG != null`}, {When: "always"}}, WhenAlways, 1, false},
+		{"no match", []types.Rule{{If: `$CI_COMMIT_TAG`}}, WhenNever, -1, false},
+		{"invalid expression", []types.Rule{{If: `$CI_COMMIT_BRANCH == (`}}, "", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := Evaluate(tt.rules, env, t.TempDir(), nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error %v, want error: %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if result.When != tt.when || result.Rule != tt.rule {
+				t.Errorf("got %s (rule %d), want %s (rule %d)", result.When, result.Rule, tt.when, tt.rule)
+			}
+			if runs := tt.when != WhenNever; result.Runs() != runs {
+				t.Errorf("Runs() = %v, want %v", result.Runs(), runs)
+			}
+		})
+	}
+}
@@ -0,0 +1,581 @@
+package runners
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/docker/api/types/build"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/sanix-darker/git-ci/internal/actions"
+	"github.com/sanix-darker/git-ci/internal/config"
+	"github.com/sanix-darker/git-ci/internal/expressions"
+	"github.com/sanix-darker/git-ci/pkg/types"
+)
+
+// containerActions is where the job container sees the remote actions
+// its steps use
+const containerActions = "/tmp/git-ci/actions"
+
+// SkippedStepError is a step that can't run where the job runs, such as
+// a node action in a container without node: the step is skipped
+type SkippedStepError struct {
+	Reason string
+}
+
+func (e *SkippedStepError) Error() string {
+	return e.Reason
+}
+
+// resolvedAction is the action of a step: its action.yml and where its
+// files are on the host ("" for docker:// images)
+type resolvedAction struct {
+	uses     string
+	dir      string
+	local    bool
+	metadata *actions.Metadata
+}
+
+// runAction runs a step using an action in the job container. Remote
+// (owner/repo@ref) actions are fetched into the cache, local (./path)
+// ones read from the workspace; composite, docker and node actions run,
+// as do docker:// images. env is the step env.
+func (r *DockerRunner) runAction(step *types.Step, gate *stepGate, env map[string]string, workdir string) error {
+	if r.commands == nil {
+		return &SkippedStepError{Reason: "actions only run in GitHub workflows"}
+	}
+
+	action, err := r.resolveAction(step.Uses, workdir)
+	if err != nil {
+		return err
+	}
+	runs := action.metadata.Runs
+	if runs.Pre != "" || runs.Post != "" {
+		r.formatter.PrintDebug(fmt.Sprintf("The pre and post scripts of %s are not run", step.Uses))
+	}
+
+	inputs := r.actionInputs(action, step, gate)
+	if r.config.Verbose {
+		for _, name := range sortedNames(inputs) {
+			r.formatter.PrintKeyValue(name, inputs[name], 2)
+		}
+	}
+	switch using := strings.ToLower(runs.Using); {
+	case using == "composite":
+		return r.runCompositeAction(action, inputs, gate, env, workdir)
+	case using == "docker":
+		return r.runDockerAction(action, inputs, step, gate, env, workdir)
+	case strings.HasPrefix(using, "node"):
+		return r.runNodeAction(action, inputs, step, env, workdir)
+	default:
+		return &SkippedStepError{Reason: fmt.Sprintf("%s runs using '%s', which is not supported", step.Uses, runs.Using)}
+	}
+}
+
+// resolveAction returns the action of a `uses:` reference
+func (r *DockerRunner) resolveAction(uses, workdir string) (*resolvedAction, error) {
+	action := &resolvedAction{uses: uses}
+	switch {
+	case strings.HasPrefix(uses, "docker://"):
+		action.metadata = &actions.Metadata{Runs: actions.RunsMetadata{Using: "docker", Image: uses}}
+		return action, nil
+	case strings.HasPrefix(uses, "./"):
+		action.dir = filepath.Join(workdir, filepath.FromSlash(uses))
+		action.local = true
+	default:
+		cacheDir, err := r.actionCacheDir()
+		if err != nil {
+			return nil, err
+		}
+		progress := r.formatter.NewProgress(fmt.Sprintf("Fetching action %s", uses))
+		dir, err := actions.Download(uses, cacheDir, r.config.Offline)
+		progress.Complete(err == nil)
+		if err != nil {
+			return nil, err
+		}
+		action.dir = dir
+	}
+
+	metadata, err := actions.ReadMetadata(action.dir)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", uses, err)
+	}
+	action.metadata = metadata
+	return action, nil
+}
+
+// actionCacheDir returns where remote actions are checked out: the
+// action cache, or a directory of the run removed by Cleanup when the
+// metadata cache is disabled
+func (r *DockerRunner) actionCacheDir() (string, error) {
+	if r.config.CacheEnabled(config.CacheKindMetadata) {
+		return filepath.Join(config.GetCacheDir(), "actions"), nil
+	}
+	if r.actionsDir == "" {
+		dir, err := os.MkdirTemp("", "git-ci-actions-")
+		if err != nil {
+			return "", fmt.Errorf("failed to create the action directory: %w", err)
+		}
+		r.actionsDir = dir
+	}
+	return r.actionsDir, nil
+}
+
+// actionInputs returns the inputs of an action: the `with:` of its step,
+// else the defaults of its action.yml resolved with the job contexts.
+// Names are case-insensitive; missing required inputs are reported.
+func (r *DockerRunner) actionInputs(action *resolvedAction, step *types.Step, gate *stepGate) map[string]string {
+	inputs := make(map[string]string)
+	for name, input := range action.metadata.Inputs {
+		if value, ok := input.DefaultValue(); ok {
+			inputs[name] = gate.expand(value)
+		}
+	}
+
+	for key, value := range step.With {
+		name := key
+		for declared := range action.metadata.Inputs {
+			if strings.EqualFold(declared, key) {
+				name = declared
+			}
+		}
+		inputs[name] = value
+	}
+
+	for name, input := range action.metadata.Inputs {
+		if _, ok := inputs[name]; !ok && input.IsRequired() {
+			r.formatter.PrintWarning(fmt.Sprintf("Input required and not supplied: %s", name))
+		}
+	}
+	return inputs
+}
+
+// inputVariables returns the INPUT_<NAME> variables of inputs
+func inputVariables(inputs map[string]string) map[string]string {
+	vars := make(map[string]string, len(inputs))
+	for name, value := range inputs {
+		vars["INPUT_"+strings.ToUpper(strings.ReplaceAll(name, " ", "_"))] = value
+	}
+	return vars
+}
+
+// mergeVariables returns the variables of sets, the last ones winning
+func mergeVariables(sets ...map[string]string) map[string]string {
+	merged := make(map[string]string)
+	for _, vars := range sets {
+		for k, v := range vars {
+			merged[k] = v
+		}
+	}
+	return merged
+}
+
+// runCompositeAction runs the steps of a composite action like those of
+// the job: after a failure only the steps whose condition calls a status
+// function run. The outputs of the action resolve from its steps.
+func (r *DockerRunner) runCompositeAction(action *resolvedAction, inputs map[string]string, gate *stepGate, env map[string]string, workdir string) error {
+	actionPath, err := r.actionPath(action, workdir)
+	if err != nil {
+		return err
+	}
+	inner := gate.withInputs(inputs, actionPath)
+	env = mergeVariables(env, map[string]string{"GITHUB_ACTION_PATH": actionPath})
+
+	var firstErr error
+	for i := range action.metadata.Runs.Steps {
+		step := action.metadata.Runs.Steps[i]
+		name := step.Name
+		if name == "" {
+			name = step.Uses
+		}
+		if name == "" {
+			name = strings.SplitN(strings.TrimSpace(step.Run), "\n", 2)[0]
+		}
+
+		run, err := expressions.EvalCondition(step.If, inner.ctx, inner.status)
+		if err != nil {
+			return fmt.Errorf("%s, step '%s': %w", action.uses, name, err)
+		}
+		if !run {
+			r.formatter.PrintDebug(fmt.Sprintf("Skipping '%s' of %s", name, action.uses))
+			inner.setStep(&step, "skipped", "skipped", nil)
+			continue
+		}
+		inner.interpolate(&step, r.commands.stepEnv(env, ""))
+		r.formatter.PrintInfo(fmt.Sprintf("Run %s", name))
+
+		stepEnv := mergeVariables(env, step.Env)
+		if step.Uses != "" {
+			err = r.runAction(&step, inner, stepEnv, workdir)
+		} else {
+			err = r.RunStep(&step, stepEnv, workdir)
+		}
+
+		var skipped *SkippedStepError
+		if errors.As(err, &skipped) {
+			r.formatter.PrintWarning(fmt.Sprintf("Skipping '%s': %s", name, skipped.Reason))
+			inner.setStep(&step, "skipped", "skipped", nil)
+			continue
+		}
+		inner.finished(&step, r.commands.take(), err)
+		if err != nil {
+			if step.ContinueOnErr {
+				r.formatter.PrintWarning(fmt.Sprintf("Step failed but continuing: %v", err))
+				continue
+			}
+			if firstErr == nil {
+				firstErr = fmt.Errorf("step '%s' failed: %w", name, err)
+			}
+			inner.fail()
+		}
+	}
+
+	outputs := make(map[string]string, len(action.metadata.Outputs))
+	for name, output := range action.metadata.Outputs {
+		outputs[name] = inner.expand(output.Value)
+	}
+	r.commands.add(&stepCommands{outputs: outputs})
+	return firstErr
+}
+
+// runNodeAction runs the main script of a node action with the node of
+// the job container; without node, the step is skipped
+func (r *DockerRunner) runNodeAction(action *resolvedAction, inputs map[string]string, step *types.Step, env map[string]string, workdir string) error {
+	if code, err := r.execQuiet([]string{"/bin/sh", "-c", "command -v node"}); err != nil || code != 0 {
+		reason := fmt.Sprintf("%s runs using %s, and the job container has no node", action.uses, action.metadata.Runs.Using)
+		r.formatter.PrintWarning(reason)
+		return &SkippedStepError{Reason: reason}
+	}
+
+	actionPath, err := r.actionPath(action, workdir)
+	if err != nil {
+		return err
+	}
+	main := &types.Step{
+		Name:        step.Name,
+		Run:         "node " + shellQuote(path.Join(actionPath, action.metadata.Runs.Main)),
+		TimeoutMin:  step.TimeoutMin,
+		RetryPolicy: step.RetryPolicy,
+	}
+	return r.RunStep(main, mergeVariables(env, inputVariables(inputs), map[string]string{"GITHUB_ACTION_PATH": actionPath}), workdir)
+}
+
+// actionPath returns where the job container sees the files of an action:
+// local ones are in the workspace, remote ones are copied once per job
+func (r *DockerRunner) actionPath(action *resolvedAction, workdir string) (string, error) {
+	if action.local {
+		rel, err := filepath.Rel(workdir, action.dir)
+		if err != nil {
+			return "", err
+		}
+		return path.Join(ContainerWorkspace, filepath.ToSlash(rel)), nil
+	}
+
+	if target, ok := r.copiedActions[action.dir]; ok {
+		return target, nil
+	}
+	sum := sha256.Sum256([]byte(action.dir))
+	name := hex.EncodeToString(sum[:6])
+	target := path.Join(containerActions, name)
+
+	// The archive lays the action out under containerActions, from /
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(writeTar(pw, action.dir, strings.TrimPrefix(target, "/")))
+	}()
+	err := r.client.CopyToContainer(context.Background(), r.container, "/", pr, container.CopyToContainerOptions{})
+	pr.Close()
+	if err != nil {
+		return "", fmt.Errorf("failed to copy %s into the container: %w", action.uses, err)
+	}
+
+	if r.copiedActions == nil {
+		r.copiedActions = make(map[string]string)
+	}
+	r.copiedActions[action.dir] = target
+	return target, nil
+}
+
+// execQuiet runs a command in the job container without printing its
+// output, and returns its exit code
+func (r *DockerRunner) execQuiet(cmd []string) (int, error) {
+	ctx := context.Background()
+	exec, err := r.client.ContainerExecCreate(ctx, r.container, container.ExecOptions{
+		Cmd:          cmd,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to create exec: %w", err)
+	}
+	attach, err := r.client.ContainerExecAttach(ctx, exec.ID, container.ExecAttachOptions{})
+	if err != nil {
+		return 0, fmt.Errorf("failed to attach to exec: %w", err)
+	}
+	_, _ = io.Copy(io.Discard, attach.Reader)
+	attach.Close()
+
+	inspect, err := r.client.ContainerExecInspect(ctx, exec.ID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to inspect exec: %w", err)
+	}
+	return inspect.ExitCode, nil
+}
+
+// runDockerAction runs a docker action in a container of its own, on the
+// network of the job and with its workspace: the variables of the job
+// container, the step env, INPUT_* and the env of the action. The args
+// and entrypoint of the step override those of action.yml.
+func (r *DockerRunner) runDockerAction(action *resolvedAction, inputs map[string]string, step *types.Step, gate *stepGate, env map[string]string, workdir string) error {
+	ctx := r.jobCtx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	imageName, err := r.actionImage(ctx, action)
+	if err != nil {
+		return err
+	}
+
+	runs := action.metadata.Runs
+	inner := gate.withInputs(inputs, "")
+	args := make([]string, 0, len(runs.Args))
+	for _, arg := range runs.Args {
+		args = append(args, inner.expand(arg))
+	}
+	if value, ok := step.With["args"]; ok {
+		if args, err = splitOptions(value); err != nil {
+			return fmt.Errorf("invalid args: %w", err)
+		}
+	}
+	entrypoint := runs.Entrypoint
+	if value, ok := step.With["entrypoint"]; ok {
+		entrypoint = value
+	}
+
+	files, err := newContainerStepFiles(r.stepsDir)
+	if err != nil {
+		return err
+	}
+	defer files.remove()
+
+	// The PATH of the job image doesn't apply to the action image
+	vars := r.containerVariables(ctx)
+	delete(vars, "PATH")
+	vars = mergeVariables(vars, r.commands.env, env, inputVariables(inputs), inner.expandValues(runs.Env), files.vars())
+	delete(vars, "PATH")
+	envList := make([]string, 0, len(vars))
+	for _, name := range sortedNames(vars) {
+		envList = append(envList, name+"="+vars[name])
+	}
+
+	config := &container.Config{
+		Image:      imageName,
+		Cmd:        args,
+		Env:        envList,
+		WorkingDir: ContainerWorkspace,
+		Labels:     ContainerLabels(r.config),
+	}
+	if entrypoint != "" {
+		config.Entrypoint = []string{entrypoint}
+	}
+	hostConfig := &container.HostConfig{
+		Mounts: []mount.Mount{
+			{Type: mount.TypeBind, Source: workdir, Target: ContainerWorkspace},
+			{Type: mount.TypeBind, Source: r.stepsDir, Target: ContainerStepFiles},
+		},
+	}
+	if r.networkName != "" {
+		hostConfig.NetworkMode = container.NetworkMode(r.networkName)
+	}
+
+	hb := r.formatter.NewHeartbeat(step.Name, r.config.HeartbeatInterval(), r.effectiveTimeout(step))
+	hb.Start()
+	defer hb.Stop()
+
+	err = r.runActionContainer(ctx, step, config, hostConfig, hb)
+	if filesErr := r.applyStepFiles(files); err == nil {
+		err = filesErr
+	}
+	return err
+}
+
+// runActionContainer runs the container of a docker action to its end,
+// streaming its output, and removes it
+func (r *DockerRunner) runActionContainer(ctx context.Context, step *types.Step, config *container.Config, hostConfig *container.HostConfig, hb *Heartbeat) error {
+	if step.TimeoutMin > 0 {
+		limit := r.config.Minutes(step.TimeoutMin)
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, limit, &TimeoutError{Limit: limit})
+		defer cancel()
+	}
+
+	resp, err := r.client.ContainerCreate(context.Background(), config, hostConfig, nil, nil, "")
+	if err != nil {
+		return fmt.Errorf("failed to create the action container: %w", err)
+	}
+	// Listed for Cleanup should the run be interrupted
+	r.mu.Lock()
+	r.containers = append(r.containers, resp.ID)
+	r.mu.Unlock()
+	defer r.removeActionContainer(resp.ID)
+
+	attach, err := r.client.ContainerAttach(context.Background(), resp.ID, container.AttachOptions{
+		Stream: true,
+		Stdout: true,
+		Stderr: true,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to attach to the action container: %w", err)
+	}
+	defer attach.Close()
+
+	if err := r.client.ContainerStart(context.Background(), resp.ID, container.StartOptions{}); err != nil {
+		return fmt.Errorf("failed to start the action container: %w", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		stdout := newHeartbeatWriter(os.Stdout, hb, r.output, r.formatter)
+		stderr := newHeartbeatWriter(os.Stderr, hb, r.output, r.formatter)
+		_, err := stdcopy.StdCopy(stdout, stderr, attach.Reader)
+		_ = stdout.Flush()
+		_ = stderr.Flush()
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("error streaming output: %w", err)
+		}
+	case <-ctx.Done():
+		_ = r.client.ContainerKill(context.Background(), resp.ID, "KILL")
+		return context.Cause(ctx)
+	}
+
+	statusC, errC := r.client.ContainerWait(context.Background(), resp.ID, container.WaitConditionNotRunning)
+	select {
+	case status := <-statusC:
+		if status.StatusCode != 0 {
+			code := int(status.StatusCode)
+			return &ExitError{Code: code, Message: fmt.Sprintf("action exited with status %d", code)}
+		}
+	case err := <-errC:
+		return fmt.Errorf("failed to wait for the action container: %w", err)
+	}
+	return nil
+}
+
+// removeActionContainer removes the container of a docker action once
+// done, and from those Cleanup removes
+func (r *DockerRunner) removeActionContainer(containerID string) {
+	err := r.client.ContainerRemove(context.Background(), containerID, container.RemoveOptions{Force: true})
+	if err != nil {
+		r.formatter.PrintDebug(fmt.Sprintf("Failed to remove action container %s: %v", containerID[:12], err))
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, id := range r.containers {
+		if id == containerID {
+			r.containers = append(r.containers[:i], r.containers[i+1:]...)
+			break
+		}
+	}
+}
+
+// containerVariables returns the variables of the job container
+func (r *DockerRunner) containerVariables(ctx context.Context) map[string]string {
+	vars := make(map[string]string)
+	if info, err := r.client.ContainerInspect(ctx, r.container); err == nil && info.Config != nil {
+		for _, kv := range info.Config.Env {
+			if k, v, ok := strings.Cut(kv, "="); ok {
+				vars[k] = v
+			}
+		}
+	}
+	return vars
+}
+
+// actionImage returns the image of a docker action: a docker:// image,
+// pulled unless present, or one built from its Dockerfile. Images of
+// remote actions are built once while the image cache is enabled.
+func (r *DockerRunner) actionImage(ctx context.Context, action *resolvedAction) (string, error) {
+	image := action.metadata.Runs.Image
+	if ref, ok := strings.CutPrefix(image, "docker://"); ok {
+		if r.imageExists(ctx, ref) && r.config.CacheEnabled(config.CacheKindImage) {
+			return ref, nil
+		}
+		if r.config.Offline {
+			return "", fmt.Errorf("image %s of %s is not available offline", ref, action.uses)
+		}
+		progress := r.formatter.NewProgress(fmt.Sprintf("Pulling image %s", ref))
+		err := r.pullImage(ctx, ref, nil)
+		progress.Complete(err == nil)
+		return ref, err
+	}
+
+	sum := sha256.Sum256([]byte(action.dir))
+	tag := "git-ci-action:" + hex.EncodeToString(sum[:6])
+	if !action.local && r.imageExists(ctx, tag) && r.config.CacheEnabled(config.CacheKindImage) {
+		return tag, nil
+	}
+
+	progress := r.formatter.NewProgress(fmt.Sprintf("Building image of %s", action.uses))
+	err := r.buildImage(ctx, action.dir, image, tag)
+	progress.Complete(err == nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to build the image of %s: %w", action.uses, err)
+	}
+	return tag, nil
+}
+
+// buildImage builds the image tag from a Dockerfile of dir, its context
+func (r *DockerRunner) buildImage(ctx context.Context, dir, dockerfile, tag string) error {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(writeTar(pw, dir, ""))
+	}()
+	defer pr.Close()
+
+	resp, err := r.client.ImageBuild(ctx, pr, build.ImageBuildOptions{
+		Tags:       []string{tag},
+		Dockerfile: filepath.ToSlash(dockerfile),
+		Remove:     true,
+	})
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// The build reports its progress and its error as JSON messages
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var message struct {
+			Stream string `json:"stream"`
+			Error  string `json:"error"`
+		}
+		if err := decoder.Decode(&message); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if message.Error != "" {
+			return errors.New(message.Error)
+		}
+		if line := strings.TrimSpace(message.Stream); line != "" {
+			r.formatter.PrintDebug(line)
+		}
+	}
+}
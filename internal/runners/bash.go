@@ -22,7 +22,18 @@ type BashRunner struct {
 	config      *config.RunnerConfig
 	environment map[string]string
 	formatter   *OutputFormatter
+	services    *ServiceManager
 	mu          sync.Mutex
+
+	// What the steps of the job wrote to their GITHUB_* files
+	commands *jobCommands
+
+	// Done at the time limit of the running job, killing its step
+	jobCtx context.Context
+
+	coverageTracker
+	stepTracker
+	runContexts
 }
 
 // NewBashRunner creates a new bash runner with configuration
@@ -34,7 +45,7 @@ func NewBashRunner(cfg *config.RunnerConfig) *BashRunner {
 	return &BashRunner{
 		config:      cfg,
 		environment: make(map[string]string),
-		formatter:   NewOutputFormatter(cfg.Verbose),
+		formatter:   newFormatter(cfg),
 	}
 }
 
@@ -60,9 +71,21 @@ func (r *BashRunner) RunJob(job *types.Job, workdir string) error {
 		r.formatter.PrintDryRun()
 	}
 
-	// Setup job environment
-	jobEnv := r.mergeEnvironments(job.Environment, r.config.Environment)
+	// Setup job environment, expanding variables that refer to others
 	r.setupJobEnvironment(job, absWorkdir)
+	jobEnv, err := ExpandEnv(JobVariables(job, r.config), r.environment, r.config.Provider)
+	if err != nil {
+		return fmt.Errorf("job variables: %w", err)
+	}
+
+	// Start services, published on the host like on a GitHub runner
+	if len(job.Services) > 0 {
+		serviceEnv, err := r.startServices(job)
+		if err != nil {
+			return err
+		}
+		jobEnv = r.mergeEnvironments(jobEnv, serviceEnv)
+	}
 
 	// Print environment variables if verbose
 	if r.config.Verbose && len(jobEnv) > 0 {
@@ -70,41 +93,72 @@ func (r *BashRunner) RunJob(job *types.Job, workdir string) error {
 	}
 
 	// Initialize job summary
+	r.beginCoverage(job)
+	r.commands = newJobCommands()
+
 	summary := &JobSummary{
 		JobName:    job.Name,
 		TotalSteps: len(job.Steps),
 		Success:    true,
 	}
 
+	// Past its timeout-minutes or the configured timeout, the job stops
+	// and its running step is killed
+	r.jobCtx = context.Background()
+	limit := jobTimeout(job, r.config)
+	if limit > 0 {
+		var cancel context.CancelFunc
+		r.jobCtx, cancel = context.WithTimeoutCause(r.jobCtx, limit, &TimeoutError{Limit: limit, Job: true})
+		defer cancel()
+	}
+
 	// Execute steps
+	gate := newStepGate(jobEnv, r.config, r.formatter, &r.runContexts)
+	afterScript := false
 	for i, step := range job.Steps {
 		stepNum := i + 1
 		stepStart := time.Now()
 
+		// after_script runs whatever happened to the steps before it,
+		// past the job timeout too, within a limit of its own
+		if step.Phase == types.StepPhaseAfterScript && !afterScript {
+			afterScript = true
+			var cancel context.CancelFunc
+			r.jobCtx, cancel = afterScriptContext()
+			defer cancel()
+		}
+
 		// Check for timeout
-		if r.config.Timeout > 0 {
-			elapsed := time.Since(startTime).Minutes()
-			if elapsed > float64(r.config.Timeout) {
-				summary.Success = false
-				summary.Errors = append(summary.Errors, fmt.Sprintf("Job timeout exceeded (%d minutes)", r.config.Timeout))
-				break
+		if r.jobCtx.Err() != nil {
+			if !summary.TimedOut {
+				summary.timedOut(limit)
 			}
+			continue
 		}
 
 		// Check if step should run
-		if !r.shouldRunStep(&step, jobEnv) {
-			r.formatter.PrintStepHeader(step.Name, stepNum, len(job.Steps))
-			r.formatter.PrintStepSkipped("condition not met")
-			summary.SkippedSteps++
+		if !gate.allows(&step, stepNum, len(job.Steps), summary) {
 			continue
 		}
+		gate.interpolate(&step, jobEnv)
 
 		// Print step header
 		r.formatter.PrintStepHeader(step.Name, stepNum, len(job.Steps))
 
 		// Execute step
 		err := r.RunStep(&step, jobEnv, absWorkdir)
+
+		var skipped *SkippedStepError
+		if errors.As(err, &skipped) {
+			r.formatter.PrintStepSkipped(skipped.Reason)
+			summary.SkippedSteps++
+			summary.stepSkipped(step.Name, skipped.Reason)
+			gate.setStep(&step, "skipped", "skipped", nil)
+			continue
+		}
 		stepDuration := time.Since(stepStart)
+		summary.stepFinished(step.Name, stepStart, err)
+		gate.finished(&step, r.commands.take(), err)
 
 		if err != nil {
 			summary.FailedSteps++
@@ -115,12 +169,25 @@ func (r *BashRunner) RunJob(job *types.Job, workdir string) error {
 				r.formatter.PrintStepFailed(err, stepDuration)
 				summary.Success = false
 				summary.Errors = append(summary.Errors, fmt.Sprintf("Step '%s' failed: %v", step.Name, err))
-				break
+				summary.ExitCode = ExitCode(err)
+				gate.fail()
 			}
 		} else {
 			summary.CompletedSteps++
 			r.formatter.PrintStepComplete(stepDuration)
 		}
+
+		if jobTimedOut(err) {
+			summary.timedOut(limit)
+		}
+	}
+
+	r.finishCoverage(job, r.formatter)
+	r.finishSteps(summary)
+
+	// Hand the job outputs to the jobs needing this one
+	if outputs := gate.jobOutputs(job.Outputs, r.commands.outputs); len(outputs) > 0 {
+		job.Outputs = outputs
 	}
 
 	// Print job summary
@@ -131,7 +198,7 @@ func (r *BashRunner) RunJob(job *types.Job, workdir string) error {
 		r.formatter.PrintJobComplete(job.Name, summary.Duration, summary.Success)
 	}
 
-	return nil
+	return summary.Err(job)
 }
 
 func (r *BashRunner) RunStep(step *types.Step, env map[string]string, workdir string) error {
@@ -145,9 +212,15 @@ func (r *BashRunner) RunStep(step *types.Step, env map[string]string, workdir st
 		return nil
 	}
 
+	// Expand the step env against the job environment
+	stepEnv, err := ExpandEnv(step.Env, r.mergeEnvironments(r.environment, env), r.config.Provider)
+	if err != nil {
+		return err
+	}
+
 	// Dry run mode
 	if r.config.DryRun {
-		r.printDryRun(step)
+		r.printDryRun(step, stepEnv)
 		return nil
 	}
 
@@ -162,18 +235,27 @@ func (r *BashRunner) RunStep(step *types.Step, env map[string]string, workdir st
 		cmd.Dir = workdir
 	}
 
+	// The step sets variables and outputs through GITHUB_ENV and GITHUB_OUTPUT
+	files, err := newStepFiles()
+	if err != nil {
+		return err
+	}
+	defer files.remove()
+	stepEnv = r.mergeEnvironments(stepEnv, files.vars())
+
 	// Setup environment
-	cmd.Env = r.buildStepEnvironment(env, step.Env)
+	cmd.Env = r.buildStepEnvironment(env, stepEnv)
 
-	// Setup timeout for step
-	ctx := context.Background()
+	// Setup timeout for step, within that of the job
+	ctx := r.jobCtx
+	if ctx == nil {
+		ctx = context.Background()
+	}
 	if step.TimeoutMin > 0 {
+		limit := r.config.Minutes(step.TimeoutMin)
 		var cancel context.CancelFunc
-		ctx, cancel = context.WithTimeout(ctx, time.Duration(step.TimeoutMin)*time.Minute)
+		ctx, cancel = context.WithTimeoutCause(ctx, limit, &TimeoutError{Limit: limit})
 		defer cancel()
-		cmd = exec.CommandContext(ctx, cmd.Path, cmd.Args[1:]...)
-		cmd.Dir = workdir
-		cmd.Env = r.buildStepEnvironment(env, step.Env)
 	}
 
 	// Print command if verbose
@@ -181,13 +263,49 @@ func (r *BashRunner) RunStep(step *types.Step, env map[string]string, workdir st
 		r.formatter.PrintCommand(step.Run, 2)
 	}
 
+	// Report progress while the step stays silent
+	hb := r.formatter.NewHeartbeat(step.Name, r.config.HeartbeatInterval(), r.effectiveTimeout(step))
+	hb.Start()
+	defer hb.Stop()
+
 	// Execute with retry if configured
 	if step.RetryPolicy != nil && step.RetryPolicy.MaxAttempts > 1 {
-		return r.executeWithRetry(cmd, step)
+		err = r.executeWithRetry(ctx, cmd, step, hb)
+	} else {
+		err = r.executeCommand(ctx, cmd, step.Name, hb)
+	}
+
+	if filesErr := r.applyStepFiles(files, env); err == nil {
+		err = filesErr
+	}
+	return err
+}
+
+// applyStepFiles reads what a step wrote to its files: variables join env
+// and PATH directories that of the next steps, outputs those of the step,
+// and the summary is printed
+func (r *BashRunner) applyStepFiles(files *stepFiles, env map[string]string) error {
+	cmds, err := files.read()
+	if err != nil {
+		return err
 	}
 
-	// Normal execution
-	return r.executeCommand(cmd, step.Name)
+	if env != nil {
+		for k, v := range cmds.env {
+			env[k] = v
+		}
+	}
+	if r.commands != nil {
+		r.commands.add(cmds)
+	}
+
+	printStepSummary(r.formatter, cmds.summary)
+	return nil
+}
+
+// effectiveTimeout returns the timeout that applies to a step
+func (r *BashRunner) effectiveTimeout(step *types.Step) time.Duration {
+	return stepTimeout(step, r.config, r.jobCtx)
 }
 
 func (r *BashRunner) runActionStep(step *types.Step, env map[string]string, workdir string) error {
@@ -207,15 +325,19 @@ func (r *BashRunner) runActionStep(step *types.Step, env map[string]string, work
 		return r.runCheckoutAction(step, workdir)
 	case "actions/setup-go", "actions/setup-node", "actions/setup-python":
 		return r.runSetupAction(action, step, version)
+	case "actions/cache", "actions/cache/restore", "actions/cache/save":
+		if !r.config.CacheEnabled(config.CacheKindJob) {
+			return &SkippedStepError{Reason: "job cache disabled (--no-cache)"}
+		}
+		return &SkippedStepError{Reason: fmt.Sprintf("%s@%s is not supported by the bash runner", action, version)}
 	default:
-		r.formatter.PrintWarning(fmt.Sprintf("Unsupported action: %s@%s (skipping)", action, version))
 		if r.config.Verbose && len(step.With) > 0 {
 			r.formatter.PrintSection("Action Parameters")
 			for k, v := range step.With {
 				r.formatter.PrintKeyValue(k, v, 2)
 			}
 		}
-		return nil
+		return &SkippedStepError{Reason: fmt.Sprintf("%s@%s is not supported by the bash runner", action, version)}
 	}
 }
 
@@ -297,7 +419,7 @@ func (r *BashRunner) prepareCommand(shell, script string) *exec.Cmd {
 	}
 }
 
-func (r *BashRunner) executeCommand(cmd *exec.Cmd, stepName string) error {
+func (r *BashRunner) executeCommand(ctx context.Context, cmd *exec.Cmd, stepName string, hb *Heartbeat) error {
 	// Create pipes for output streaming
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
@@ -309,10 +431,14 @@ func (r *BashRunner) executeCommand(cmd *exec.Cmd, stepName string) error {
 		return fmt.Errorf("failed to create stderr pipe: %w", err)
 	}
 
-	// Start the command
+	// Start the command in its own process group, killed as a whole at
+	// the timeout so that what it runs in background doesn't outlive it
+	startProcessGroup(cmd)
 	if err := cmd.Start(); err != nil {
 		return fmt.Errorf("failed to start command: %w", err)
 	}
+	stop := context.AfterFunc(ctx, func() { killProcessGroup(cmd) })
+	defer stop()
 
 	// Stream output in real-time
 	var wg sync.WaitGroup
@@ -320,24 +446,31 @@ func (r *BashRunner) executeCommand(cmd *exec.Cmd, stepName string) error {
 
 	var stdoutBuf, stderrBuf bytes.Buffer
 
-	go r.streamOutput(stdout, &stdoutBuf, &wg, 2)
-	go r.streamOutput(stderr, &stderrBuf, &wg, 2)
+	go r.streamOutput(stdout, &stdoutBuf, &wg, 2, hb)
+	go r.streamOutput(stderr, &stderrBuf, &wg, 2, hb)
 
 	wg.Wait()
 
 	// Wait for command to complete
 	if err := cmd.Wait(); err != nil {
+		if ctx.Err() != nil {
+			return context.Cause(ctx)
+		}
 		errMsg := fmt.Sprintf("command failed: %v", err)
 		if stderrBuf.Len() > 0 && r.config.Verbose {
 			errMsg += fmt.Sprintf("\nStderr output:\n%s", stderrBuf.String())
 		}
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return &ExitError{Code: exitErr.ExitCode(), Message: errMsg}
+		}
 		return errors.New(errMsg)
 	}
 
 	return nil
 }
 
-func (r *BashRunner) executeWithRetry(cmd *exec.Cmd, step *types.Step) error {
+func (r *BashRunner) executeWithRetry(ctx context.Context, cmd *exec.Cmd, step *types.Step, hb *Heartbeat) error {
 	policy := step.RetryPolicy
 	maxAttempts := policy.MaxAttempts
 	if maxAttempts <= 0 {
@@ -349,11 +482,9 @@ func (r *BashRunner) executeWithRetry(cmd *exec.Cmd, step *types.Step) error {
 		if attempt > 1 {
 			r.formatter.PrintInfo(fmt.Sprintf("Retry attempt %d/%d", attempt, maxAttempts))
 
-			// Parse and apply delay
-			if policy.Delay != "" {
-				if duration, err := time.ParseDuration(policy.Delay); err == nil {
-					time.Sleep(duration)
-				}
+			// Parse and apply delay, cut short by the timeout
+			if err := waitRetryDelay(ctx, policy.Delay); err != nil {
+				return err
 			}
 		}
 
@@ -362,7 +493,11 @@ func (r *BashRunner) executeWithRetry(cmd *exec.Cmd, step *types.Step) error {
 		retryCmd.Dir = cmd.Dir
 		retryCmd.Env = cmd.Env
 
-		if err := r.executeCommand(retryCmd, step.Name); err != nil {
+		if err := r.executeCommand(ctx, retryCmd, step.Name, hb); err != nil {
+			// A timed out step isn't retried
+			if ctx.Err() != nil {
+				return err
+			}
 			lastErr = err
 			r.formatter.PrintWarning(fmt.Sprintf("Attempt %d failed: %v", attempt, err))
 		} else {
@@ -373,13 +508,16 @@ func (r *BashRunner) executeWithRetry(cmd *exec.Cmd, step *types.Step) error {
 	return fmt.Errorf("all %d attempts failed, last error: %w", maxAttempts, lastErr)
 }
 
-func (r *BashRunner) streamOutput(reader io.Reader, capture *bytes.Buffer, wg *sync.WaitGroup, indent int) {
+func (r *BashRunner) streamOutput(reader io.Reader, capture *bytes.Buffer, wg *sync.WaitGroup, indent int, hb *Heartbeat) {
 	defer wg.Done()
 
 	scanner := bufio.NewScanner(reader)
 	for scanner.Scan() {
-		line := scanner.Text()
+		// Masked here too since the line is also kept for errors
+		line := r.formatter.Mask(scanner.Text())
+		hb.Touch()
 		r.formatter.PrintOutput(line, indent)
+		_, _ = r.output.Write([]byte(line + "\n"))
 
 		if capture != nil {
 			capture.WriteString(line + "\n")
@@ -406,6 +544,9 @@ func (r *BashRunner) setupJobEnvironment(job *types.Job, workdir string) {
 	if gitCommit := r.getGitCommit(workdir); gitCommit != "" {
 		r.environment["GIT_COMMIT"] = gitCommit
 	}
+	for k, v := range gitlabJobVariables(job, r.config, workdir) {
+		r.environment[k] = v
+	}
 }
 
 func (r *BashRunner) buildStepEnvironment(jobEnv map[string]string, stepEnv map[string]string) []string {
@@ -427,6 +568,17 @@ func (r *BashRunner) buildStepEnvironment(jobEnv map[string]string, stepEnv map[
 		env = append(env, fmt.Sprintf("%s=%s", k, v))
 	}
 
+	// Directories earlier steps added to GITHUB_PATH come first
+	if r.commands != nil && len(r.commands.path) > 0 {
+		path := os.Getenv("PATH")
+		for _, vars := range []map[string]string{r.environment, jobEnv, stepEnv} {
+			if value, ok := vars["PATH"]; ok {
+				path = value
+			}
+		}
+		env = append(env, "PATH="+r.commands.pathValue(path, string(os.PathListSeparator)))
+	}
+
 	return env
 }
 
@@ -440,28 +592,6 @@ func (r *BashRunner) mergeEnvironments(envs ...map[string]string) map[string]str
 	return result
 }
 
-func (r *BashRunner) shouldRunStep(step *types.Step, env map[string]string) bool {
-	if step.If == "" {
-		return true
-	}
-
-	// Simple condition evaluation
-	condition := step.If
-
-	switch condition {
-	case "always()":
-		return true
-	case "success()":
-		return true
-	case "failure()":
-		return false
-	case "cancelled()":
-		return false
-	default:
-		return true
-	}
-}
-
 func (r *BashRunner) getShell(specified string) string {
 	if specified != "" {
 		return specified
@@ -501,7 +631,7 @@ func (r *BashRunner) getGitCommit(workdir string) string {
 	return strings.TrimSpace(string(output))
 }
 
-func (r *BashRunner) printDryRun(step *types.Step) {
+func (r *BashRunner) printDryRun(step *types.Step, stepEnv map[string]string) {
 	r.formatter.PrintSection("Would execute")
 	r.formatter.PrintKeyValue("Shell", r.getShell(step.Shell), 2)
 
@@ -509,9 +639,9 @@ func (r *BashRunner) printDryRun(step *types.Step) {
 		r.formatter.PrintKeyValue("Working Dir", step.WorkingDir, 2)
 	}
 
-	if len(step.Env) > 0 {
+	if len(stepEnv) > 0 {
 		r.formatter.PrintSubSection("Environment:")
-		for k, v := range step.Env {
+		for k, v := range stepEnv {
 			r.formatter.PrintKeyValue(k, v, 4)
 		}
 	}
@@ -520,9 +650,31 @@ func (r *BashRunner) printDryRun(step *types.Step) {
 	r.formatter.PrintCommand(step.Run, 4)
 }
 
+// startServices runs the job services and returns their port variables
+func (r *BashRunner) startServices(job *types.Job) (map[string]string, error) {
+	if r.config.DryRun {
+		services := make(map[string]string)
+		for name, svc := range job.Services {
+			services[name] = fmt.Sprintf("%s %s", svc.Image, strings.Join(svc.Ports, " "))
+		}
+		r.formatter.PrintServices(services)
+		return nil, nil
+	}
+
+	if r.services == nil {
+		manager, err := NewServiceManager(r.config, r.formatter)
+		if err != nil {
+			return nil, err
+		}
+		r.services = manager
+	}
+
+	return r.services.Start(job.Name, job.Services)
+}
+
 func (r *BashRunner) Cleanup() error {
-	// Clean up any temporary resources
-	return nil
+	// Stop the services of the job
+	return r.services.Stop()
 }
 
 // GetRunnerType returns the type of this runner
@@ -0,0 +1,155 @@
+package runners
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sanix-darker/git-ci/internal/config"
+	"github.com/sanix-darker/git-ci/pkg/types"
+)
+
+// quietBashRunner returns a bash runner printing nothing in color
+func quietBashRunner() *BashRunner {
+	cfg := config.DefaultConfig()
+	cfg.Quiet = true
+	cfg.NoColor = true
+	return NewBashRunner(cfg)
+}
+
+// withTimeout gives the steps of r a time limit, as RunJob does with the
+// timeout of the job, shorter than the minutes a step timeout counts
+func withTimeout(t *testing.T, r *BashRunner, limit time.Duration) {
+	ctx, cancel := context.WithTimeoutCause(context.Background(), limit, &TimeoutError{Limit: limit})
+	t.Cleanup(cancel)
+	r.jobCtx = ctx
+}
+
+func TestBashStepTimeoutKillsTheStep(t *testing.T) {
+	r := quietBashRunner()
+	withTimeout(t, r, time.Second)
+
+	start := time.Now()
+	err := r.RunStep(&types.Step{Name: "sleep", Run: "sleep 60"}, nil, t.TempDir())
+
+	var timeoutErr *TimeoutError
+	if !errors.As(err, &timeoutErr) {
+		t.Fatalf("got %v, want a timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("the step stopped %s after its 1s timeout", elapsed)
+	}
+}
+
+func TestBashRetryDelayStopsAtTimeout(t *testing.T) {
+	r := quietBashRunner()
+	withTimeout(t, r, time.Second)
+
+	step := &types.Step{
+		Name:        "flaky",
+		Run:         "exit 1",
+		RetryPolicy: &types.RetryPolicy{MaxAttempts: 3, Delay: "1m"},
+	}
+	start := time.Now()
+	err := r.RunStep(step, nil, t.TempDir())
+
+	var timeoutErr *TimeoutError
+	if !errors.As(err, &timeoutErr) {
+		t.Fatalf("got %v, want a timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("the retry delay went on %s past the 1s timeout", elapsed)
+	}
+}
+
+func TestBashUnsupportedActionIsSkipped(t *testing.T) {
+	r := quietBashRunner()
+	r.config.Provider = "github"
+	job := &types.Job{
+		Name: "build",
+		Steps: []types.Step{
+			{Name: "Lint", Uses: "golangci/golangci-lint-action@v6"},
+			{Name: "Build", Run: "true"},
+		},
+	}
+
+	if err := r.RunJob(job, t.TempDir()); err != nil {
+		t.Fatalf("the job failed: %v", err)
+	}
+	steps := r.StepStatuses()
+	if len(steps) != 2 {
+		t.Fatalf("got %d step results, want 2", len(steps))
+	}
+	if steps[0].Status != types.StatusSkipped || !strings.Contains(steps[0].Error, "golangci/golangci-lint-action@v6") {
+		t.Errorf("action step: got %s (%s), want it skipped", steps[0].Status, steps[0].Error)
+	}
+	if steps[1].Status != types.StatusSuccess {
+		t.Errorf("run step: got %s, want success", steps[1].Status)
+	}
+}
+
+func TestBashAfterScriptRunsAfterFailure(t *testing.T) {
+	r := quietBashRunner()
+	r.config.Provider = "gitlab"
+	workdir := t.TempDir()
+	job := &types.Job{
+		Name: "test",
+		Steps: []types.Step{
+			{Name: "script", Run: "exit 3"},
+			{Name: "never", Run: "echo never >> ran"},
+			{Name: "after_script", Run: "echo after >> ran", Phase: types.StepPhaseAfterScript},
+		},
+	}
+
+	err := r.RunJob(job, workdir)
+	if code := ExitCode(err); code != 3 {
+		t.Fatalf("got %v, want the job to fail with exit code 3", err)
+	}
+	data, err := os.ReadFile(filepath.Join(workdir, "ran"))
+	if err != nil {
+		t.Fatalf("after_script didn't run: %v", err)
+	}
+	if got := string(data); got != "after\n" {
+		t.Errorf("the steps after the failure ran %q, want only after_script", got)
+	}
+
+	steps := r.StepStatuses()
+	if len(steps) != 3 || steps[2].Status != types.StatusSuccess {
+		t.Errorf("got step results %+v, want after_script to succeed", steps)
+	}
+}
+
+func TestBashStepEnvReachesLaterSteps(t *testing.T) {
+	r := quietBashRunner()
+	r.config.Provider = "github"
+	workdir := t.TempDir()
+	job := &types.Job{
+		Name: "build",
+		Steps: []types.Step{
+			{Name: "set", Run: `echo "GREETING=hello" >> "$GITHUB_ENV"
+printf 'NOTES<<EOF\nline one\nline two\nEOF\n' >> "$GITHUB_ENV"
+echo "version=1.2" >> "$GITHUB_OUTPUT"
+echo "## Built" >> "$GITHUB_STEP_SUMMARY"`},
+			{Name: "read", Run: `printf '%s|%s\n' "$GREETING" "$NOTES" >> seen`},
+			{Name: "override", Run: `printf '%s\n' "$GREETING" >> seen`, Env: map[string]string{"GREETING": "from step"}},
+		},
+	}
+
+	if err := r.RunJob(job, workdir); err != nil {
+		t.Fatalf("the job failed: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(workdir, "seen"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(data), "hello|line one\nline two\nfrom step\n"; got != want {
+		t.Errorf("the later steps saw %q, want %q", got, want)
+	}
+	if got := job.Outputs["version"]; got != "1.2" {
+		t.Errorf("job outputs %v, want version 1.2", job.Outputs)
+	}
+}
@@ -0,0 +1,283 @@
+package runners
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/sanix-darker/git-ci/internal/config"
+)
+
+// stepFileNames are the files of a step by the variable naming them
+var stepFileNames = map[string]string{
+	"GITHUB_ENV":          "env",
+	"GITHUB_OUTPUT":       "output",
+	"GITHUB_PATH":         "path",
+	"GITHUB_STEP_SUMMARY": "summary.md",
+}
+
+// stepFiles are the files a GitHub step appends to, named by GITHUB_ENV,
+// GITHUB_OUTPUT, GITHUB_PATH and GITHUB_STEP_SUMMARY, read back once the
+// step is done
+type stepFiles struct {
+	dir  string // On the host
+	seen string // As the step sees it, in a container
+}
+
+// newStepFiles creates empty step files in a temporary directory
+func newStepFiles() (*stepFiles, error) {
+	dir, err := os.MkdirTemp("", "git-ci-step-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the step files: %w", err)
+	}
+	return createStepFiles(&stepFiles{dir: dir}, 0o600)
+}
+
+// ContainerStepFiles is where job containers mount the step files of
+// their job
+const ContainerStepFiles = "/git-ci/steps"
+
+// StepFilesDir returns the directory holding the step files of the
+// containers of a run, out of the checkout and of the workspaces
+func StepFilesDir(runID string) string {
+	return filepath.Join(config.GetCacheDir(), "steps", runID)
+}
+
+// newJobStepFilesDir creates the directory of a job the step files of its
+// containers go to, mounted at ContainerStepFiles. Only the user may reach
+// it on the host; inside, the directories of the steps are open to the
+// users of the containers.
+func newJobStepFilesDir(runID string) (string, error) {
+	parent := StepFilesDir(runID)
+	if err := os.MkdirAll(parent, 0o700); err != nil {
+		return "", fmt.Errorf("failed to create the step files directory: %w", err)
+	}
+	dir, err := os.MkdirTemp(parent, "job-")
+	if err != nil {
+		return "", fmt.Errorf("failed to create the step files directory: %w", err)
+	}
+	if err := os.Chmod(dir, 0o755); err != nil {
+		os.RemoveAll(dir)
+		return "", fmt.Errorf("failed to create the step files directory: %w", err)
+	}
+	return dir, nil
+}
+
+// newContainerStepFiles creates empty step files in a temporary directory
+// of jobDir, the directory of the job mounted at ContainerStepFiles: any
+// user of the container may write them
+func newContainerStepFiles(jobDir string) (*stepFiles, error) {
+	if jobDir == "" {
+		return nil, fmt.Errorf("failed to create the step files: no step files directory for the job")
+	}
+	dir, err := os.MkdirTemp(jobDir, "step-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the step files: %w", err)
+	}
+	if err := os.Chmod(dir, 0o777); err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("failed to create the step files: %w", err)
+	}
+	f := &stepFiles{dir: dir, seen: path.Join(ContainerStepFiles, filepath.Base(dir))}
+	return createStepFiles(f, 0o666)
+}
+
+// createStepFiles creates the empty files of f with mode
+func createStepFiles(f *stepFiles, mode os.FileMode) (*stepFiles, error) {
+	for _, name := range stepFileNames {
+		file := filepath.Join(f.dir, name)
+		err := os.WriteFile(file, nil, mode)
+		if err == nil {
+			err = os.Chmod(file, mode)
+		}
+		if err != nil {
+			f.remove()
+			return nil, fmt.Errorf("failed to create the step files: %w", err)
+		}
+	}
+	return f, nil
+}
+
+// vars returns the variables pointing the step at its files
+func (f *stepFiles) vars() map[string]string {
+	vars := make(map[string]string, len(stepFileNames))
+	for variable, name := range stepFileNames {
+		if f.seen != "" {
+			vars[variable] = path.Join(f.seen, name)
+		} else {
+			vars[variable] = filepath.Join(f.dir, name)
+		}
+	}
+	return vars
+}
+
+// stepCommands is what a step wrote to its files
+type stepCommands struct {
+	env     map[string]string
+	outputs map[string]string
+	path    []string
+	summary string
+}
+
+// read returns what the step wrote to its files
+func (f *stepFiles) read() (*stepCommands, error) {
+	var cmds stepCommands
+	var err error
+	if cmds.env, err = readKeyValueFile(filepath.Join(f.dir, "env")); err != nil {
+		return nil, fmt.Errorf("invalid GITHUB_ENV: %w", err)
+	}
+	if cmds.outputs, err = readKeyValueFile(filepath.Join(f.dir, "output")); err != nil {
+		return nil, fmt.Errorf("invalid GITHUB_OUTPUT: %w", err)
+	}
+	data, _ := os.ReadFile(filepath.Join(f.dir, "path"))
+	for _, line := range strings.Split(string(data), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			cmds.path = append(cmds.path, line)
+		}
+	}
+	data, _ = os.ReadFile(filepath.Join(f.dir, "summary.md"))
+	cmds.summary = strings.TrimSpace(string(data))
+	return &cmds, nil
+}
+
+// jobCommands is what the steps of a job wrote to their files so far
+type jobCommands struct {
+	env     map[string]string // Variables of the next steps
+	path    []string          // Directories prepended to PATH, last added first
+	outputs map[string]string // Outputs of all the steps
+	last    map[string]string // Outputs of the last step
+}
+
+// newJobCommands returns the commands of a job starting
+func newJobCommands() *jobCommands {
+	return &jobCommands{env: make(map[string]string), outputs: make(map[string]string)}
+}
+
+// add records what a step wrote; nil is a step that wrote nothing
+func (c *jobCommands) add(cmds *stepCommands) {
+	c.last = nil
+	if cmds == nil {
+		return
+	}
+	for k, v := range cmds.env {
+		c.env[k] = v
+	}
+	for _, dir := range cmds.path {
+		c.path = append([]string{dir}, c.path...)
+	}
+	for k, v := range cmds.outputs {
+		c.outputs[k] = v
+	}
+	c.last = cmds.outputs
+}
+
+// take returns the outputs of the last step, once
+func (c *jobCommands) take() map[string]string {
+	if c == nil {
+		return nil
+	}
+	outputs := c.last
+	c.last = nil
+	return outputs
+}
+
+// stepEnv returns the env of a step under the variables earlier steps
+// wrote, with their PATH directories before the PATH of env, else
+// basePath
+func (c *jobCommands) stepEnv(env map[string]string, basePath string) map[string]string {
+	if c == nil || (len(c.env) == 0 && len(c.path) == 0) {
+		return env
+	}
+	merged := make(map[string]string, len(c.env)+len(env)+1)
+	for _, vars := range []map[string]string{c.env, env} {
+		for k, v := range vars {
+			merged[k] = v
+		}
+	}
+	if len(c.path) > 0 {
+		if value, ok := merged["PATH"]; ok {
+			basePath = value
+		}
+		merged["PATH"] = c.pathValue(basePath, ":")
+	}
+	return merged
+}
+
+// printStepSummary prints what a step wrote to GITHUB_STEP_SUMMARY
+func printStepSummary(f *OutputFormatter, summary string) {
+	if summary == "" {
+		return
+	}
+	f.PrintSection("Step summary")
+	for _, line := range strings.Split(summary, "\n") {
+		f.PrintOutput(line, 2)
+	}
+}
+
+// pathValue returns base with the GITHUB_PATH directories before it,
+// separated by sep
+func (c *jobCommands) pathValue(base, sep string) string {
+	if len(c.path) == 0 {
+		return base
+	}
+	value := strings.Join(c.path, sep)
+	if base != "" {
+		value += sep + base
+	}
+	return value
+}
+
+// remove deletes the step files
+func (f *stepFiles) remove() {
+	os.RemoveAll(f.dir)
+}
+
+// readKeyValueFile parses a GITHUB_ENV or GITHUB_OUTPUT file: NAME=value
+// lines, and NAME<<DELIMITER blocks for multiline values
+func readKeyValueFile(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		eq := strings.Index(line, "=")
+		heredoc := strings.Index(line, "<<")
+		if heredoc > 0 && (eq < 0 || heredoc < eq) {
+			name, delimiter := line[:heredoc], line[heredoc+2:]
+			var lines []string
+			closed := false
+			for scanner.Scan() {
+				text := strings.TrimSuffix(scanner.Text(), "\r")
+				if text == delimiter {
+					closed = true
+					break
+				}
+				lines = append(lines, text)
+			}
+			if !closed {
+				return nil, fmt.Errorf("missing delimiter %s of %s", delimiter, name)
+			}
+			values[name] = strings.Join(lines, "\n")
+			continue
+		}
+
+		if eq <= 0 {
+			return nil, fmt.Errorf("invalid line %q", line)
+		}
+		values[line[:eq]] = line[eq+1:]
+	}
+	return values, scanner.Err()
+}
@@ -0,0 +1,55 @@
+package runners
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestContainerStepFilesOutOfTheCheckout(t *testing.T) {
+	cache := t.TempDir()
+	t.Setenv("GIT_CI_CACHE_DIR", cache)
+
+	jobDir, err := newJobStepFilesDir("run-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(jobDir, filepath.Join(cache, "steps", "run-1")+string(filepath.Separator)) {
+		t.Errorf("job step files directory %s is not under the steps of the run", jobDir)
+	}
+
+	files, err := newContainerStepFiles(jobDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Dir(files.dir) != jobDir {
+		t.Errorf("step files in %s, want them in %s", files.dir, jobDir)
+	}
+	if got, want := files.vars()["GITHUB_OUTPUT"], path.Join(ContainerStepFiles, filepath.Base(files.dir), "output"); got != want {
+		t.Errorf("GITHUB_OUTPUT = %s, want %s", got, want)
+	}
+
+	if runtime.GOOS != "windows" {
+		info, err := os.Stat(StepFilesDir("run-1"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mode := info.Mode().Perm(); mode != 0o700 {
+			t.Errorf("steps of the run have mode %o, want 700", mode)
+		}
+	}
+
+	files.remove()
+	if _, err := os.Stat(files.dir); !os.IsNotExist(err) {
+		t.Errorf("step files left behind: %v", err)
+	}
+}
+
+func TestContainerStepFilesNeedAJobDirectory(t *testing.T) {
+	if _, err := newContainerStepFiles(""); err == nil {
+		t.Error("step files created without a job directory")
+	}
+}
@@ -1,22 +1,32 @@
 package runners
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/sanix-darker/git-ci/internal/config"
+	"github.com/sanix-darker/git-ci/pkg/types"
 )
 
 // ANSI color codes - subtle/muted versions
 const (
 	ColorReset   = "\033[0m"
-	ColorRed     = "\033[31m"     // Red for errors
-	ColorGreen   = "\033[32m"     // Green for success (subtle)
-	ColorYellow  = "\033[33m"     // Yellow for warnings
-	ColorBlue    = "\033[34m"     // Blue for info
-	ColorGray    = "\033[90m"     // Gray for secondary info
-	ColorDimGray = "\033[2;37m"   // Dim gray for less important
-	ColorBold    = "\033[1m"      // Bold
-	ColorDim     = "\033[2m"      // Dim
+	ColorRed     = "\033[31m"   // Red for errors
+	ColorGreen   = "\033[32m"   // Green for success (subtle)
+	ColorYellow  = "\033[33m"   // Yellow for warnings
+	ColorBlue    = "\033[34m"   // Blue for info
+	ColorGray    = "\033[90m"   // Gray for secondary info
+	ColorDimGray = "\033[2;37m" // Dim gray for less important
+	ColorBold    = "\033[1m"    // Bold
+	ColorDim     = "\033[2m"    // Dim
 
 	// Additional muted colors
 	ColorDarkBlue  = "\033[34;2m" // Darker blue
@@ -28,9 +38,9 @@ const (
 type IndentLevel int
 
 const (
-	IndentNone  IndentLevel = 0
-	IndentJob   IndentLevel = 1
-	IndentStep  IndentLevel = 2
+	IndentNone   IndentLevel = 0
+	IndentJob    IndentLevel = 1
+	IndentStep   IndentLevel = 2
 	IndentDetail IndentLevel = 3
 	IndentOutput IndentLevel = 4
 )
@@ -38,26 +48,92 @@ const (
 // OutputFormatter provides consistent output formatting for all runners
 type OutputFormatter struct {
 	Verbose    bool
-	Width      int
+	Width      int // Fallback width when stdout is not a terminal
 	UseColor   bool
 	IndentSize int
+	Theme      *Theme
+	ASCII      bool // Plain ASCII status symbols instead of Unicode glyphs
+
+	// SharedOutput is set when jobs run side by side: their lines
+	// follow each other, so nothing is redrawn in place
+	SharedOutput bool
+
+	// Secret values replaced with *** in everything printed, longest first
+	secrets []string
 }
 
+// outMu serializes the lines written concurrently by the formatters of
+// all jobs (streamed output, heartbeats)
+var outMu sync.Mutex
+
+// minMaskedLine is the length below which a secret, or a line of a
+// multi-line secret, is not masked: it can't be told apart from regular
+// output ("1" would mask every digit)
+const minMaskedLine = 4
+
+// liveWidth is the current terminal width, updated on resize (0 when
+// stdout is not a terminal)
+var (
+	liveWidth     atomic.Int32
+	liveWidthOnce sync.Once
+)
+
 // NewOutputFormatter creates a new output formatter
 func NewOutputFormatter(verbose bool) *OutputFormatter {
+	liveWidthOnce.Do(func() {
+		liveWidth.Store(int32(terminalWidth()))
+		watchResize(func(width int) { liveWidth.Store(int32(width)) })
+	})
+
+	width := 80
+	if columns, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && columns > 0 {
+		width = columns
+	}
+
+	theme, _ := LookupTheme(DefaultThemeName)
 	return &OutputFormatter{
 		Verbose:    verbose,
-		Width:      80,
-		UseColor:   true,  // Can be made configurable
-		IndentSize: 2,     // Spaces per indent level
+		Width:      width,
+		UseColor:   ColorEnabled(),
+		IndentSize: 2, // Spaces per indent level
+		Theme:      theme,
 	}
 }
 
+// ColorEnabled reports whether output is colored by default: when stdout
+// is a terminal and NO_COLOR is not set
+func ColorEnabled() bool {
+	return os.Getenv("NO_COLOR") == "" && IsTerminal(os.Stdout)
+}
+
+// newFormatter creates the output formatter of a runner from its
+// configuration (theme, ASCII symbols, colors)
+func newFormatter(cfg *config.RunnerConfig) *OutputFormatter {
+	f := NewOutputFormatter(cfg.Verbose)
+	f.ASCII = cfg.ASCII
+	f.UseColor = !cfg.NoColor
+	f.SharedOutput = cfg.Parallel
+	f.MaskSecrets(cfg.Secrets...)
+	if theme, err := LookupTheme(cfg.Theme); err == nil {
+		f.Theme = theme
+	}
+	return f
+}
+
 // GetIndent returns the indentation string for a given level
 func (f *OutputFormatter) GetIndent(level IndentLevel) string {
 	return strings.Repeat(" ", int(level)*f.IndentSize)
 }
 
+// TermWidth returns the width output is laid out for: the terminal width
+// when stdout is a terminal, the configured width otherwise
+func (f *OutputFormatter) TermWidth() int {
+	if width := int(liveWidth.Load()); width > 0 {
+		return width
+	}
+	return f.Width
+}
+
 // Color applies color to text if colors are enabled
 func (f *OutputFormatter) Color(text string, color string) string {
 	if !f.UseColor {
@@ -66,20 +142,88 @@ func (f *OutputFormatter) Color(text string, color string) string {
 	return color + text + ColorReset
 }
 
+// MaskSecrets adds values to mask in the output
+func (f *OutputFormatter) MaskSecrets(values ...string) {
+	for _, value := range values {
+		value = strings.TrimRight(value, "\r\n")
+		if !Maskable(value) {
+			continue
+		}
+		f.addSecret(value)
+
+		// Output is printed line by line, so each line of a multi-line
+		// secret is masked on its own, except lines too short to tell
+		// apart from regular output
+		if strings.Contains(value, "\n") {
+			for _, line := range strings.Split(value, "\n") {
+				if Maskable(line) {
+					f.addSecret(strings.TrimSpace(line))
+				}
+			}
+		}
+	}
+	// A secret containing another one is masked whole
+	sort.SliceStable(f.secrets, func(i, j int) bool { return len(f.secrets[i]) > len(f.secrets[j]) })
+}
+
+// Maskable reports whether a secret value is long enough to be masked
+func Maskable(value string) bool {
+	return len(strings.TrimSpace(value)) >= minMaskedLine
+}
+
+// addSecret adds a value to mask, once
+func (f *OutputFormatter) addSecret(value string) {
+	for _, secret := range f.secrets {
+		if secret == value {
+			return
+		}
+	}
+	f.secrets = append(f.secrets, value)
+}
+
+// HasSecrets reports whether the formatter masks any value
+func (f *OutputFormatter) HasSecrets() bool {
+	return len(f.secrets) > 0
+}
+
+// Mask replaces the secret values in text with ***
+func (f *OutputFormatter) Mask(text string) string {
+	for _, secret := range f.secrets {
+		text = strings.ReplaceAll(text, secret, "***")
+	}
+	return text
+}
+
+// Style renders text with the theme style of a role. Every text printed
+// goes through it, so secrets are masked here.
+func (f *OutputFormatter) Style(text string, role Role) string {
+	text = f.Mask(text)
+	style := f.Theme.Style(role)
+	if style == "" {
+		return text
+	}
+	return f.Color(text, style)
+}
+
+// Glyph returns a status symbol, in ASCII when requested
+func (f *OutputFormatter) Glyph(g Glyph) string {
+	return GlyphText(g, f.ASCII)
+}
+
 // PrintHeader prints the job execution header
 func (f *OutputFormatter) PrintHeader(jobName, workdir, runner string) {
 	fmt.Println()
 	fmt.Println(f.Line('='))
 	fmt.Printf("%s Running Job: %s\n",
 		f.GetIndent(IndentNone),
-		f.Color(jobName, ColorBold))
+		f.Style(jobName, RoleEmphasis))
 	fmt.Println(f.Line('-'))
 	fmt.Printf("%s Working Directory: %s\n",
 		f.GetIndent(IndentJob),
-		f.Color(workdir, ColorGray))
+		f.Style(workdir, RoleMuted))
 	fmt.Printf("%s Runner: %s\n",
 		f.GetIndent(IndentJob),
-		f.Color(runner, ColorGray))
+		f.Style(runner, RoleMuted))
 	fmt.Println(f.Line('='))
 }
 
@@ -89,36 +233,36 @@ func (f *OutputFormatter) PrintStepHeader(stepName string, current, total int) {
 	progress := fmt.Sprintf("[%d/%d]", current, total)
 	fmt.Printf("%s%s %s\n",
 		f.GetIndent(IndentStep),
-		f.Color(progress, ColorDarkGray),
-		f.Color(stepName, ColorBlue))
+		f.Style(progress, RoleLabel),
+		f.Style(stepName, RoleInfo))
 	fmt.Printf("%s%s\n",
 		f.GetIndent(IndentStep),
-		f.Color(f.Line('-'), ColorDimGray))
+		f.Style(f.Line('-'), RoleFaint))
 }
 
 // PrintStepComplete prints step completion
 func (f *OutputFormatter) PrintStepComplete(duration time.Duration) {
 	fmt.Printf("%s%s %s\n",
 		f.GetIndent(IndentStep),
-		f.Color("✓", ColorGreen),
-		f.Color(fmt.Sprintf("Step completed in %s", f.FormatDuration(duration)), ColorGray))
+		f.Style(f.Glyph(GlyphOK), RoleSuccess),
+		f.Style(fmt.Sprintf("Step completed in %s", f.FormatDuration(duration)), RoleMuted))
 }
 
 // PrintStepFailed prints step failure
 func (f *OutputFormatter) PrintStepFailed(err error, duration time.Duration) {
 	fmt.Printf("%s%s Step FAILED after %s: %s\n",
 		f.GetIndent(IndentStep),
-		f.Color("✗", ColorRed),
+		f.Style(f.Glyph(GlyphFail), RoleError),
 		f.FormatDuration(duration),
-		f.Color(err.Error(), ColorRed))
+		f.Style(err.Error(), RoleError))
 }
 
 // PrintStepSkipped prints that a step was skipped
 func (f *OutputFormatter) PrintStepSkipped(reason string) {
 	fmt.Printf("%s%s Step skipped: %s\n",
 		f.GetIndent(IndentStep),
-		f.Color("○", ColorYellow),
-		f.Color(reason, ColorDimGray))
+		f.Style(f.Glyph(GlyphSkip), RoleWarning),
+		f.Style(reason, RoleFaint))
 }
 
 // PrintJobComplete prints job completion summary
@@ -127,19 +271,19 @@ func (f *OutputFormatter) PrintJobComplete(jobName string, duration time.Duratio
 	fmt.Println(f.Line('='))
 
 	status := "completed successfully"
-	color := ColorGreen
+	role := RoleSuccess
 	if !success {
 		status = "FAILED"
-		color = ColorRed
+		role = RoleError
 	}
 
 	fmt.Printf("%s Job '%s' %s\n",
 		f.GetIndent(IndentJob),
-		f.Color(jobName, ColorBold),
-		f.Color(status, color))
+		f.Style(jobName, RoleEmphasis),
+		f.Style(status, role))
 	fmt.Printf("%s Total duration: %s\n",
 		f.GetIndent(IndentJob),
-		f.Color(f.FormatDuration(duration), ColorGray))
+		f.Style(f.FormatDuration(duration), RoleMuted))
 	fmt.Println(f.Line('='))
 	fmt.Println()
 }
@@ -150,38 +294,71 @@ func (f *OutputFormatter) PrintOutput(line string, indent int) {
 	indentStr := strings.Repeat(" ", indent)
 
 	// Mute the output color to gray for less distraction
-	fmt.Printf("%s%s\n", indentStr, f.Color(line, ColorDimGray))
+	outMu.Lock()
+	defer outMu.Unlock()
+	fmt.Printf("%s%s\n", indentStr, f.Style(line, RoleFaint))
+}
+
+// WriteOutput writes the raw output of a step to w, between the lines
+// printed by the other jobs
+func (f *OutputFormatter) WriteOutput(w io.Writer, p []byte) (int, error) {
+	outMu.Lock()
+	defer outMu.Unlock()
+	return w.Write(p)
+}
+
+// PrintHeartbeat prints a dim status line for a silent step. When inPlace
+// is set the line is redrawn over the previous heartbeat without a newline.
+func (f *OutputFormatter) PrintHeartbeat(message string, inPlace bool) {
+	outMu.Lock()
+	defer outMu.Unlock()
+
+	line := f.GetIndent(IndentStep) + f.Style(message, RoleSubtle)
+	if inPlace {
+		fmt.Printf("\r\033[K%s", line)
+		return
+	}
+	fmt.Println(line)
+}
+
+// ClearLine erases the current terminal line (used after in-place updates)
+func (f *OutputFormatter) ClearLine() {
+	outMu.Lock()
+	defer outMu.Unlock()
+	fmt.Print("\r\033[K")
 }
 
 // PrintOutputWithLevel prints output with specific indent level
 func (f *OutputFormatter) PrintOutputWithLevel(line string, level IndentLevel) {
+	outMu.Lock()
+	defer outMu.Unlock()
 	fmt.Printf("%s%s\n",
 		f.GetIndent(level),
-		f.Color(line, ColorDimGray))
+		f.Style(line, RoleFaint))
 }
 
 // PrintInfo prints an informational message
 func (f *OutputFormatter) PrintInfo(message string) {
 	fmt.Printf("%s%s %s\n",
 		f.GetIndent(IndentDetail),
-		f.Color("ℹ", ColorBlue),
-		f.Color(message, ColorLightGray))
+		f.Style(f.Glyph(GlyphInfo), RoleInfo),
+		f.Style(message, RoleValue))
 }
 
 // PrintWarning prints a warning message
 func (f *OutputFormatter) PrintWarning(message string) {
 	fmt.Printf("%s%s %s\n",
 		f.GetIndent(IndentDetail),
-		f.Color("⚠", ColorYellow),
-		f.Color(message, ColorYellow))
+		f.Style(f.Glyph(GlyphWarn), RoleWarning),
+		f.Style(message, RoleWarning))
 }
 
 // PrintError prints an error message
 func (f *OutputFormatter) PrintError(message string) {
 	fmt.Printf("%s%s %s\n",
 		f.GetIndent(IndentDetail),
-		f.Color("✗", ColorRed),
-		f.Color(message, ColorRed))
+		f.Style(f.Glyph(GlyphFail), RoleError),
+		f.Style(message, RoleError))
 }
 
 // PrintDebug prints a debug message if verbose mode is enabled
@@ -189,19 +366,19 @@ func (f *OutputFormatter) PrintDebug(message string) {
 	if f.Verbose {
 		fmt.Printf("%s%s %s\n",
 			f.GetIndent(IndentOutput),
-			f.Color("[DEBUG]", ColorDarkGray),
-			f.Color(message, ColorDimGray))
+			f.Style("[DEBUG]", RoleLabel),
+			f.Style(message, RoleFaint))
 	}
 }
 
 // PrintDryRun prints dry run header
 func (f *OutputFormatter) PrintDryRun() {
 	fmt.Println()
-	fmt.Println(f.Color(f.Line('*'), ColorYellow))
+	fmt.Println(f.Style(f.Line('*'), RoleWarning))
 	fmt.Printf("%s %s\n",
 		f.GetIndent(IndentJob),
-		f.Color("DRY RUN MODE - Commands will be displayed but not executed", ColorYellow))
-	fmt.Println(f.Color(f.Line('*'), ColorYellow))
+		f.Style("DRY RUN MODE - Commands will be displayed but not executed", RoleWarning))
+	fmt.Println(f.Style(f.Line('*'), RoleWarning))
 }
 
 // PrintSection prints a section header
@@ -209,17 +386,17 @@ func (f *OutputFormatter) PrintSection(title string) {
 	fmt.Println()
 	fmt.Printf("%s%s\n",
 		f.GetIndent(IndentJob),
-		f.Color(title, ColorBold))
+		f.Style(title, RoleEmphasis))
 	fmt.Printf("%s%s\n",
 		f.GetIndent(IndentJob),
-		f.Color(f.Line('-'), ColorDimGray))
+		f.Style(f.Line('-'), RoleFaint))
 }
 
 // PrintSubSection prints a subsection with indent
 func (f *OutputFormatter) PrintSubSection(title string) {
 	fmt.Printf("%s%s\n",
 		f.GetIndent(IndentStep),
-		f.Color(title, ColorBlue))
+		f.Style(title, RoleInfo))
 }
 
 // PrintKeyValue prints a key-value pair with proper indentation
@@ -227,16 +404,16 @@ func (f *OutputFormatter) PrintKeyValue(key, value string, indent int) {
 	prefix := strings.Repeat(" ", indent)
 	fmt.Printf("%s%s: %s\n",
 		prefix,
-		f.Color(key, ColorDarkGray),
-		f.Color(value, ColorLightGray))
+		f.Style(key, RoleLabel),
+		f.Style(value, RoleValue))
 }
 
 // PrintKeyValueWithLevel prints a key-value pair at specific indent level
 func (f *OutputFormatter) PrintKeyValueWithLevel(key, value string, level IndentLevel) {
 	fmt.Printf("%s%s: %s\n",
 		f.GetIndent(level),
-		f.Color(key, ColorDarkGray),
-		f.Color(value, ColorLightGray))
+		f.Style(key, RoleLabel),
+		f.Style(value, RoleValue))
 }
 
 // PrintList prints a list item with proper indentation
@@ -244,16 +421,16 @@ func (f *OutputFormatter) PrintList(item string, indent int) {
 	prefix := strings.Repeat(" ", indent)
 	fmt.Printf("%s%s %s\n",
 		prefix,
-		f.Color("•", ColorDarkGray),
-		f.Color(item, ColorLightGray))
+		f.Style(f.Glyph(GlyphBullet), RoleLabel),
+		f.Style(item, RoleValue))
 }
 
 // PrintListWithLevel prints a list item at specific indent level
 func (f *OutputFormatter) PrintListWithLevel(item string, level IndentLevel) {
 	fmt.Printf("%s%s %s\n",
 		f.GetIndent(level),
-		f.Color("•", ColorDarkGray),
-		f.Color(item, ColorLightGray))
+		f.Style(f.Glyph(GlyphBullet), RoleLabel),
+		f.Style(item, RoleValue))
 }
 
 // PrintCommand prints a command that will be or was executed
@@ -261,25 +438,26 @@ func (f *OutputFormatter) PrintCommand(cmd string, indent int) {
 	prefix := strings.Repeat(" ", indent)
 
 	// Split long commands for readability
-	if len(cmd) > (f.Width - indent - 4) {
-		lines := f.WrapText(cmd, f.Width-indent-4)
+	width := f.TermWidth() - indent - 4
+	if len(cmd) > width {
+		lines := f.WrapText(cmd, width)
 		for i, line := range lines {
 			if i == 0 {
 				fmt.Printf("%s%s %s\n",
 					prefix,
-					f.Color("$", ColorBlue),
-					f.Color(line, ColorGray))
+					f.Style("$", RoleInfo),
+					f.Style(line, RoleMuted))
 			} else {
 				fmt.Printf("%s  %s\n",
 					prefix,
-					f.Color(line, ColorGray))
+					f.Style(line, RoleMuted))
 			}
 		}
 	} else {
 		fmt.Printf("%s%s %s\n",
 			prefix,
-			f.Color("$", ColorBlue),
-			f.Color(cmd, ColorGray))
+			f.Style("$", RoleInfo),
+			f.Style(cmd, RoleMuted))
 	}
 }
 
@@ -291,7 +469,10 @@ func (f *OutputFormatter) PrintCommandWithLevel(cmd string, level IndentLevel) {
 // Line generates a line of the specified character
 func (f *OutputFormatter) Line(char rune) string {
 	// Make lines slightly shorter for cleaner look
-	width := f.Width - (f.IndentSize * 2)
+	width := f.TermWidth() - (f.IndentSize * 2)
+	if width < 1 {
+		width = 1
+	}
 	return strings.Repeat(string(char), width)
 }
 
@@ -313,8 +494,12 @@ func (f *OutputFormatter) FormatDuration(d time.Duration) string {
 	return fmt.Sprintf("%dh %dm", hours, minutes)
 }
 
-// WrapText wraps text to fit within the specified width
+// WrapText wraps text to fit within the specified width (the terminal
+// width when width is 0)
 func (f *OutputFormatter) WrapText(text string, width int) []string {
+	if width == 0 {
+		width = f.TermWidth()
+	}
 	if width <= 0 {
 		return []string{text}
 	}
@@ -344,8 +529,15 @@ func (f *OutputFormatter) WrapText(text string, width int) []string {
 	return lines
 }
 
-// TruncateText truncates text to fit within the specified width
+// TruncateText truncates text to fit within the specified width (the
+// terminal width when width is 0)
 func (f *OutputFormatter) TruncateText(text string, width int) string {
+	if width == 0 {
+		width = f.TermWidth()
+	}
+	if width < 0 {
+		width = 0
+	}
 	if len(text) <= width {
 		return text
 	}
@@ -378,7 +570,7 @@ func (f *OutputFormatter) NewProgressWithLevel(message string, level IndentLevel
 	}
 	fmt.Printf("%s%s... ",
 		f.GetIndent(level),
-		f.Color(message, ColorGray))
+		f.Style(message, RoleMuted))
 	return p
 }
 
@@ -387,12 +579,12 @@ func (p *Progress) Complete(success bool) {
 	duration := time.Since(p.start)
 	if success {
 		fmt.Printf("%s (%s)\n",
-			p.formatter.Color("done", ColorGreen),
-			p.formatter.Color(p.formatter.FormatDuration(duration), ColorDimGray))
+			p.formatter.Style("done", RoleSuccess),
+			p.formatter.Style(p.formatter.FormatDuration(duration), RoleFaint))
 	} else {
 		fmt.Printf("%s (%s)\n",
-			p.formatter.Color("FAILED", ColorRed),
-			p.formatter.Color(p.formatter.FormatDuration(duration), ColorDimGray))
+			p.formatter.Style("FAILED", RoleError),
+			p.formatter.Style(p.formatter.FormatDuration(duration), RoleFaint))
 	}
 }
 
@@ -400,7 +592,7 @@ func (p *Progress) Complete(success bool) {
 func (p *Progress) Update(message string) {
 	fmt.Printf("\r%s%s... ",
 		p.formatter.GetIndent(p.level),
-		p.formatter.Color(message, ColorGray))
+		p.formatter.Style(message, RoleMuted))
 }
 
 // JobSummary represents a summary of job execution (in order to track the execution of job)
@@ -413,16 +605,81 @@ type JobSummary struct {
 	Duration       time.Duration
 	Success        bool
 	Errors         []string
+	ExitCode       int  // Exit status of the failed step, 0 when unknown
+	TimedOut       bool // The job was stopped at its time limit
+	Steps          []types.StepStatus
+}
+
+// timedOut records that a job was stopped at its time limit
+func (s *JobSummary) timedOut(limit time.Duration) {
+	s.Success = false
+	s.TimedOut = true
+	s.Errors = append(s.Errors, fmt.Sprintf("Job timeout exceeded (%d minutes)", int(limit.Minutes())))
+}
+
+// jobTimeout returns the time limit of a job, the lower of its
+// timeout-minutes and the configured timeout; 0 when it has none
+func jobTimeout(job *types.Job, cfg *config.RunnerConfig) time.Duration {
+	minutes := job.TimeoutMin
+	if cfg != nil && cfg.Timeout > 0 && (minutes <= 0 || cfg.Timeout < minutes) {
+		minutes = cfg.Timeout
+	}
+	if minutes <= 0 {
+		return 0
+	}
+	return cfg.Minutes(minutes)
+}
+
+// waitRetryDelay waits the delay of a retry policy before the next
+// attempt, returning the cause of ctx when it ends first
+func waitRetryDelay(ctx context.Context, delay string) error {
+	duration, err := time.ParseDuration(delay)
+	if err != nil {
+		return nil
+	}
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	case <-timer.C:
+		return nil
+	}
+}
+
+// afterScriptTimeout bounds the after_script steps of a job, which run
+// even past its timeout, as GitLab does by default
+const afterScriptTimeout = 5 * time.Minute
+
+// afterScriptContext returns the context of the after_script steps of a
+// job, within afterScriptTimeout
+func afterScriptContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeoutCause(context.Background(), afterScriptTimeout, &TimeoutError{Limit: afterScriptTimeout})
+}
+
+// stepTimeout returns the timeout that applies to a step, bounded by the
+// time left before the deadline of its job
+func stepTimeout(step *types.Step, cfg *config.RunnerConfig, job context.Context) time.Duration {
+	timeout := cfg.Minutes(cfg.Timeout)
+	if step.TimeoutMin > 0 {
+		timeout = cfg.Minutes(step.TimeoutMin)
+	}
+	if job != nil {
+		if deadline, ok := job.Deadline(); ok && (timeout <= 0 || time.Until(deadline) < timeout) {
+			timeout = time.Until(deadline)
+		}
+	}
+	return timeout
 }
 
 // PrintJobSummary prints a detailed job summary
 func (f *OutputFormatter) PrintJobSummary(summary *JobSummary) {
 	fmt.Println()
-	fmt.Println(f.Color(f.Line('='), ColorDimGray))
+	fmt.Println(f.Style(f.Line('='), RoleFaint))
 	fmt.Printf("%s %s\n",
 		f.GetIndent(IndentJob),
-		f.Color("JOB SUMMARY", ColorBold))
-	fmt.Println(f.Color(f.Line('-'), ColorDimGray))
+		f.Style("JOB SUMMARY", RoleEmphasis))
+	fmt.Println(f.Style(f.Line('-'), RoleFaint))
 
 	f.PrintKeyValueWithLevel("Job Name", summary.JobName, IndentStep)
 	f.PrintKeyValueWithLevel("Total Steps", fmt.Sprintf("%d", summary.TotalSteps), IndentStep)
@@ -430,21 +687,23 @@ func (f *OutputFormatter) PrintJobSummary(summary *JobSummary) {
 
 	if summary.FailedSteps > 0 {
 		f.PrintKeyValueWithLevel("Failed",
-			f.Color(fmt.Sprintf("%d", summary.FailedSteps), ColorRed),
+			f.Style(fmt.Sprintf("%d", summary.FailedSteps), RoleError),
 			IndentStep)
 	}
 
 	if summary.SkippedSteps > 0 {
 		f.PrintKeyValueWithLevel("Skipped",
-			f.Color(fmt.Sprintf("%d", summary.SkippedSteps), ColorYellow),
+			f.Style(fmt.Sprintf("%d", summary.SkippedSteps), RoleWarning),
 			IndentStep)
 	}
 
 	f.PrintKeyValueWithLevel("Duration", f.FormatDuration(summary.Duration), IndentStep)
 
-	status := f.Color("SUCCESS", ColorGreen)
-	if !summary.Success {
-		status = f.Color("FAILED", ColorRed)
+	status := f.Style("SUCCESS", RoleSuccess)
+	if summary.TimedOut {
+		status = f.Style("TIMED OUT", RoleError)
+	} else if !summary.Success {
+		status = f.Style("FAILED", RoleError)
 	}
 	f.PrintKeyValueWithLevel("Status", status, IndentStep)
 
@@ -452,13 +711,13 @@ func (f *OutputFormatter) PrintJobSummary(summary *JobSummary) {
 		fmt.Println()
 		fmt.Printf("%s %s:\n",
 			f.GetIndent(IndentStep),
-			f.Color("Errors", ColorRed))
+			f.Style("Errors", RoleError))
 		for _, err := range summary.Errors {
 			f.PrintListWithLevel(err, IndentDetail)
 		}
 	}
 
-	fmt.Println(f.Color(f.Line('='), ColorDimGray))
+	fmt.Println(f.Style(f.Line('='), RoleFaint))
 }
 
 // StepResult represents the result of a step execution
@@ -473,21 +732,31 @@ type StepResult struct {
 
 // PrintStepResult prints a formatted step result
 func (f *OutputFormatter) PrintStepResult(result *StepResult, current, total int) {
-	status := f.Color("OK", ColorGreen)
+	status := f.Style("OK", RoleSuccess)
 	if result.Skipped {
-		status = f.Color("SKIPPED", ColorYellow)
+		status = f.Style("SKIPPED", RoleWarning)
 	} else if !result.Success {
-		status = f.Color("FAILED", ColorRed)
+		status = f.Style("FAILED", RoleError)
 	}
 
 	progress := fmt.Sprintf("[%d/%d]", current, total)
 
-	fmt.Printf("%s%s %-50s [%s] %s\n",
+	// The name column takes what is left of the line after the progress,
+	// status and duration, up to 50 characters
+	nameWidth := f.TermWidth() - int(IndentStep)*f.IndentSize - len(progress) - 24
+	if nameWidth > 50 {
+		nameWidth = 50
+	} else if nameWidth < 10 {
+		nameWidth = 10
+	}
+
+	fmt.Printf("%s%s %-*s [%s] %s\n",
 		f.GetIndent(IndentStep),
-		f.Color(progress, ColorDarkGray),
-		f.TruncateText(result.Name, 50),
+		f.Style(progress, RoleLabel),
+		nameWidth,
+		f.TruncateText(result.Name, nameWidth),
 		status,
-		f.Color(f.FormatDuration(result.Duration), ColorDimGray))
+		f.Style(f.FormatDuration(result.Duration), RoleFaint))
 
 	if f.Verbose && result.Output != "" {
 		lines := strings.Split(strings.TrimSpace(result.Output), "\n")
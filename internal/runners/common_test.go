@@ -0,0 +1,63 @@
+package runners
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sanix-darker/git-ci/internal/config"
+	"github.com/sanix-darker/git-ci/pkg/types"
+)
+
+func TestMaskSecrets(t *testing.T) {
+	f := NewOutputFormatter(false)
+	f.MaskSecrets("s3cret-token", "1", "abc", "-----BEGIN KEY-----\nMIIEvQ\nab\n-----END KEY-----\n")
+
+	tests := map[string]string{
+		"token=s3cret-token":      "token=***",
+		"step 1 of 3, abc":        "step 1 of 3, abc",
+		"  MIIEvQ":                "  ***",
+		"ab":                      "ab",
+		"-----BEGIN KEY-----":     "***",
+		"s3cret-token-s3cret-tok": "***-s3cret-tok",
+	}
+	for text, want := range tests {
+		if got := f.Mask(text); got != want {
+			t.Errorf("Mask(%q) = %q, want %q", text, got, want)
+		}
+	}
+}
+
+func TestSecretsNeverPrinted(t *testing.T) {
+	const secret = "hunter2-Zx81"
+
+	cfg := config.DefaultConfig()
+	cfg.NoColor = true
+	cfg.Verbose = true
+	cfg.Secrets = []string{secret}
+	cfg.Environment = map[string]string{"API_TOKEN": secret}
+
+	job := &types.Job{
+		Name: "leak",
+		Steps: []types.Step{
+			{Name: "echo " + secret, Run: "echo token=$API_TOKEN"},
+			{Name: "stderr", Run: "echo \"$API_TOKEN\" >&2"},
+			{Name: "split", Run: "printf 'a %s b\\n' \"$API_TOKEN\"; exit 3"},
+		},
+	}
+
+	var err error
+	out := captureStdout(t, func() {
+		err = NewBashRunner(cfg).RunJob(job, t.TempDir())
+	})
+	if err == nil {
+		t.Error("the failing step did not fail the job")
+	} else if strings.Contains(err.Error(), secret) {
+		t.Errorf("the secret is in the job error: %v", err)
+	}
+	if strings.Contains(out, secret) {
+		t.Errorf("the secret was printed:\n%s", out)
+	}
+	if !strings.Contains(out, "token=***") {
+		t.Errorf("the masked output is missing:\n%s", out)
+	}
+}
@@ -0,0 +1,212 @@
+package runners
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sanix-darker/git-ci/internal/config"
+)
+
+// DefaultComposeWaitTimeout bounds the wait for compose services to be
+// healthy
+const DefaultComposeWaitTimeout = 2 * time.Minute
+
+// ComposeStack is a docker compose project started for the duration of a
+// run. Job containers join its network so compose service names resolve.
+type ComposeStack struct {
+	File    string
+	Project string
+	Network string // Network job containers join, known after Up
+
+	command   []string // "docker compose" or "docker-compose"
+	formatter *OutputFormatter
+}
+
+// NewComposeStack prepares the compose project of file, using the compose
+// CLI found on the host
+func NewComposeStack(file, project string, cfg *config.RunnerConfig) (*ComposeStack, error) {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return nil, fmt.Errorf("invalid compose file: %w", err)
+	}
+	if _, err := os.Stat(abs); err != nil {
+		return nil, fmt.Errorf("compose file not found: %s", file)
+	}
+
+	command, err := composeCommand()
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg == nil {
+		cfg = config.DefaultConfig()
+	}
+
+	return &ComposeStack{
+		File:      abs,
+		Project:   project,
+		command:   command,
+		formatter: newFormatter(cfg),
+	}, nil
+}
+
+// composeCommand returns the compose CLI: the docker plugin when present,
+// the standalone docker-compose otherwise
+func composeCommand() ([]string, error) {
+	if err := exec.Command("docker", "compose", "version").Run(); err == nil {
+		return []string{"docker", "compose"}, nil
+	}
+	if _, err := exec.LookPath("docker-compose"); err == nil {
+		return []string{"docker-compose"}, nil
+	}
+	return nil, fmt.Errorf("--compose needs 'docker compose' or 'docker-compose'")
+}
+
+// run executes a compose subcommand of the project and returns its output
+func (s *ComposeStack) run(args ...string) (string, error) {
+	full := append([]string{}, s.command[1:]...)
+	full = append(full, "-f", s.File, "-p", s.Project)
+	full = append(full, args...)
+
+	cmd := exec.Command(s.command[0], full...)
+	cmd.Dir = filepath.Dir(s.File)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%s %s: %w: %s", strings.Join(s.command, " "), args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// Services returns the service names of the compose file
+func (s *ComposeStack) Services() ([]string, error) {
+	output, err := s.run("config", "--services")
+	if err != nil {
+		return nil, err
+	}
+	services := strings.Fields(output)
+	sort.Strings(services)
+	return services, nil
+}
+
+// Up starts the stack, waits for its healthchecks and finds the network
+// job containers join
+func (s *ComposeStack) Up(timeout time.Duration) error {
+	s.formatter.PrintSection("Compose Stack")
+	s.formatter.PrintKeyValueWithLevel("File", s.File, IndentStep)
+	s.formatter.PrintKeyValueWithLevel("Project", s.Project, IndentStep)
+
+	if _, err := s.run("up", "-d"); err != nil {
+		return fmt.Errorf("failed to start compose stack: %w", err)
+	}
+
+	containers, err := s.containers()
+	if err != nil {
+		return err
+	}
+	if err := s.waitHealthy(containers, timeout); err != nil {
+		return err
+	}
+
+	s.Network = s.findNetwork(containers)
+	if s.Network != "" {
+		s.formatter.PrintKeyValueWithLevel("Network", s.Network, IndentStep)
+	}
+	return nil
+}
+
+// containers returns the container IDs of the stack
+func (s *ComposeStack) containers() ([]string, error) {
+	output, err := s.run("ps", "-q")
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(output), nil
+}
+
+// waitHealthy waits until every container with a healthcheck is healthy
+// and the others are running
+func (s *ComposeStack) waitHealthy(containers []string, timeout time.Duration) error {
+	if len(containers) == 0 {
+		return nil
+	}
+	if timeout <= 0 {
+		timeout = DefaultComposeWaitTimeout
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		args := append([]string{"inspect", "-f",
+			"{{.Name}} {{if .State.Health}}{{.State.Health.Status}}{{else}}{{.State.Status}}{{end}}"}, containers...)
+		output, err := exec.Command("docker", args...).Output()
+		if err != nil {
+			return fmt.Errorf("failed to inspect compose services: %w", err)
+		}
+
+		var waiting []string
+		for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) != 2 {
+				continue
+			}
+			name, status := strings.TrimPrefix(fields[0], "/"), fields[1]
+			switch status {
+			case "healthy", "running":
+			case "unhealthy", "exited", "dead":
+				return fmt.Errorf("compose service %s is %s", name, status)
+			default:
+				waiting = append(waiting, name)
+			}
+		}
+
+		if len(waiting) == 0 {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("compose services not healthy after %s: %s", timeout, strings.Join(waiting, ", "))
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// findNetwork returns the network job containers join: the project
+// default network, or the first network of the stack's first container
+func (s *ComposeStack) findNetwork(containers []string) string {
+	if len(containers) == 0 {
+		return ""
+	}
+	output, err := exec.Command("docker", "inspect", "-f",
+		"{{range $name, $_ := .NetworkSettings.Networks}}{{$name}} {{end}}", containers[0]).Output()
+	if err != nil {
+		return ""
+	}
+	networks := strings.Fields(string(output))
+	if len(networks) == 0 {
+		return ""
+	}
+	sort.Strings(networks)
+	for _, network := range networks {
+		if network == s.Project+"_default" {
+			return network
+		}
+	}
+	return networks[0]
+}
+
+// Down stops and removes the stack, its volumes included
+func (s *ComposeStack) Down() error {
+	if s == nil {
+		return nil
+	}
+	if _, err := s.run("down", "-v", "--remove-orphans"); err != nil {
+		return fmt.Errorf("failed to stop compose stack: %w", err)
+	}
+	return nil
+}
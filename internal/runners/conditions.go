@@ -0,0 +1,243 @@
+package runners
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sanix-darker/git-ci/internal/config"
+	"github.com/sanix-darker/git-ci/internal/expressions"
+	"github.com/sanix-darker/git-ci/pkg/types"
+)
+
+// stepGate decides which steps of a job run from their `if:` and the
+// status of the job so far. After a failed step, only the steps whose
+// condition calls a status function (always(), failure()) and the
+// after_script steps still run.
+type stepGate struct {
+	ctx       expressions.Context
+	status    expressions.Status
+	github    bool // Steps have `${{ }}` expressions to interpolate
+	formatter *OutputFormatter
+}
+
+// newStepGate returns the gate of a job whose variables are env, with
+// the contexts of the run
+func newStepGate(env map[string]string, cfg *config.RunnerConfig, formatter *OutputFormatter, run *runContexts) *stepGate {
+	ctx := expressionContext(env, cfg)
+	for name, value := range run.contexts {
+		ctx[name] = value
+	}
+	return &stepGate{
+		ctx:       ctx,
+		status:    expressions.StatusSuccess,
+		github:    cfg.Provider == "github",
+		formatter: formatter,
+	}
+}
+
+// runContexts are the contexts the runner of a job gets from the run,
+// such as needs, for the expressions of its steps
+type runContexts struct {
+	contexts expressions.Context
+}
+
+// SetContexts sets the contexts of the run for the next job
+func (r *runContexts) SetContexts(ctx expressions.Context) {
+	r.contexts = ctx
+}
+
+// allows reports whether a step runs. Steps skipped by their condition
+// are printed; an invalid condition fails the step.
+func (g *stepGate) allows(step *types.Step, stepNum, total int, summary *JobSummary) bool {
+	run, err := expressions.EvalCondition(step.If, g.ctx, g.status)
+	if step.Phase == types.StepPhaseAfterScript && err == nil {
+		run = true
+	}
+	if err != nil {
+		g.formatter.PrintStepHeader(step.Name, stepNum, total)
+		g.formatter.PrintStepFailed(err, 0)
+		summary.FailedSteps++
+		summary.stepFinished(step.Name, time.Now(), err)
+		g.setStep(step, "failure", "failure", nil)
+		summary.Success = false
+		summary.Errors = append(summary.Errors, fmt.Sprintf("Step '%s' failed: %v", step.Name, err))
+		g.fail()
+		return false
+	}
+
+	if !run {
+		// Steps left after a failure are skipped silently
+		reason := "previous step failed"
+		if step.If != "" {
+			reason = "condition not met"
+			g.formatter.PrintStepHeader(step.Name, stepNum, total)
+			g.formatter.PrintStepSkipped(reason)
+		}
+		summary.SkippedSteps++
+		summary.stepSkipped(step.Name, reason)
+		g.setStep(step, "skipped", "skipped", nil)
+	}
+	return run
+}
+
+// withInputs returns the gate of the steps and arguments of an action
+// run by a step: the contexts of the job with the inputs of the action,
+// github.action_path and steps of its own
+func (g *stepGate) withInputs(inputs map[string]string, actionPath string) *stepGate {
+	ctx := make(expressions.Context, len(g.ctx))
+	for k, v := range g.ctx {
+		ctx[k] = v
+	}
+
+	values := make(map[string]interface{}, len(inputs))
+	for k, v := range inputs {
+		values[k] = v
+	}
+	ctx["inputs"] = values
+	ctx["steps"] = make(map[string]interface{})
+
+	github := map[string]interface{}{"action_path": actionPath}
+	if current, ok := g.ctx["github"].(map[string]interface{}); ok {
+		for k, v := range current {
+			if k != "action_path" {
+				github[k] = v
+			}
+		}
+	}
+	ctx["github"] = github
+
+	return &stepGate{ctx: ctx, status: g.status, github: g.github, formatter: g.formatter}
+}
+
+// fail records a failed step
+func (g *stepGate) fail() {
+	g.status = expressions.StatusFailure
+	g.ctx["job"] = map[string]interface{}{"status": "failure"}
+}
+
+// finished records the outcome and outputs of a step that ran in the
+// steps context. A failed step allowed to continue concludes as a success.
+func (g *stepGate) finished(step *types.Step, outputs map[string]string, err error) {
+	outcome, conclusion := "success", "success"
+	if err != nil {
+		outcome = "failure"
+		if !step.ContinueOnErr {
+			conclusion = "failure"
+		}
+	}
+	g.setStep(step, outcome, conclusion, outputs)
+}
+
+// setStep sets the outcome, conclusion and outputs of a step with an id
+func (g *stepGate) setStep(step *types.Step, outcome, conclusion string, outputs map[string]string) {
+	if step.ID == "" {
+		return
+	}
+	values := make(map[string]interface{}, len(outputs))
+	for k, v := range outputs {
+		values[k] = v
+	}
+	steps := g.ctx["steps"].(map[string]interface{})
+	steps[step.ID] = map[string]interface{}{
+		"outcome":    outcome,
+		"conclusion": conclusion,
+		"outputs":    values,
+	}
+}
+
+// jobOutputs returns the outputs of a job: its `outputs:` resolved once
+// its steps ran, else all the outputs its steps wrote
+func (g *stepGate) jobOutputs(declared, written map[string]string) map[string]string {
+	if len(declared) == 0 {
+		return written
+	}
+	outputs := make(map[string]string, len(declared))
+	for name, value := range declared {
+		outputs[name], _ = expressions.Interpolate(value, g.ctx, g.status)
+	}
+	return outputs
+}
+
+// interpolate resolves the `${{ }}` expressions of the run, working
+// directory, with and env of a step, env being the variables it sees.
+// Unresolved expressions expand to nothing and are reported in verbose
+// mode.
+func (g *stepGate) interpolate(step *types.Step, env map[string]string) {
+	if !g.github {
+		return
+	}
+	g.setEnv(env)
+	step.Env = g.expandValues(step.Env)
+	g.setEnv(env, step.Env)
+
+	step.Run = g.expand(step.Run)
+	step.WorkingDir = g.expand(step.WorkingDir)
+	step.With = g.expandValues(step.With)
+}
+
+// expandValues returns values with their expressions resolved
+func (g *stepGate) expandValues(values map[string]string) map[string]string {
+	if !g.github || len(values) == 0 {
+		return values
+	}
+	expanded := make(map[string]string, len(values))
+	for k, v := range values {
+		expanded[k] = g.expand(v)
+	}
+	return expanded
+}
+
+// expand returns value with its expressions resolved
+func (g *stepGate) expand(value string) string {
+	expanded, unresolved := expressions.Interpolate(value, g.ctx, g.status)
+	for _, expr := range unresolved {
+		g.formatter.PrintDebug(fmt.Sprintf("Unresolved expression '${{ %s }}', expanded to an empty string", expr))
+	}
+	return expanded
+}
+
+// setEnv sets the env context from variables, the last ones winning
+func (g *stepGate) setEnv(variables ...map[string]string) {
+	env := make(map[string]interface{})
+	for _, vars := range variables {
+		for k, v := range vars {
+			env[k] = v
+		}
+	}
+	g.ctx["env"] = env
+}
+
+// expressionContext returns the contexts conditions read: those of the
+// job variables, job with the status of the job and steps with the
+// outcome of the steps so far. Matrix references are already replaced
+// by their values when the job is expanded.
+func expressionContext(env map[string]string, cfg *config.RunnerConfig) expressions.Context {
+	ctx := ExpressionContext(env, cfg)
+	ctx["job"] = map[string]interface{}{"status": "success"}
+	ctx["steps"] = make(map[string]interface{})
+	return ctx
+}
+
+// ExpressionContext returns the contexts of the expressions of a job
+// whose variables are env: those of expressions.EnvContext, vars from
+// --env and secrets from --env or the environment
+func ExpressionContext(env map[string]string, cfg *config.RunnerConfig) expressions.Context {
+	ctx := expressions.EnvContext(env)
+
+	vars := make(map[string]interface{}, len(cfg.Environment))
+	secrets := make(map[string]interface{})
+	for _, kv := range os.Environ() {
+		if k, v, ok := strings.Cut(kv, "="); ok {
+			secrets[k] = v
+		}
+	}
+	for k, v := range cfg.Environment {
+		vars[k] = v
+		secrets[k] = v
+	}
+	ctx["vars"] = vars
+	ctx["secrets"] = secrets
+	return ctx
+}
@@ -0,0 +1,254 @@
+package runners
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/sanix-darker/git-ci/internal/config"
+	"github.com/sanix-darker/git-ci/pkg/types"
+)
+
+// keepAliveCommand keeps a job container idle while its steps are
+// executed in it
+var keepAliveCommand = []string{"/bin/sh", "-c", "trap 'exit 0' TERM; while :; do sleep 1; done"}
+
+// ContainerWorkspace is where job containers mount the working directory
+const ContainerWorkspace = "/workspace"
+
+// Labels of the containers git-ci creates
+const (
+	LabelGitCI = "git-ci"     // Always "true"
+	LabelRun   = "git-ci.run" // ID of the run that created the container
+)
+
+// ContainerLabels returns the labels of a container created for a run
+func ContainerLabels(cfg *config.RunnerConfig) map[string]string {
+	labels := map[string]string{LabelGitCI: "true"}
+	if cfg != nil && cfg.RunID != "" {
+		labels[LabelRun] = cfg.RunID
+	}
+	return labels
+}
+
+// JobImage returns the image of a job: its container image, its image or
+// an image matching its runs-on label
+func JobImage(job *types.Job) string {
+	// Use container image if specified
+	if job.Container != nil && job.Container.Image != "" {
+		return job.Container.Image
+	}
+
+	// Use job image if specified
+	if job.Image != "" {
+		return job.Image
+	}
+
+	// Map runs-on to Docker images
+	runsOn := strings.ToLower(job.RunsOn)
+
+	// Common mappings
+	imageMap := map[string]string{
+		"ubuntu-24.04":  "ubuntu:24.04",
+		"ubuntu-22.04":  "ubuntu:22.04",
+		"ubuntu-20.04":  "ubuntu:20.04",
+		"ubuntu-latest": "ubuntu:latest",
+		"debian-12":     "debian:12",
+		"debian-11":     "debian:11",
+		"alpine-3.19":   "alpine:3.19",
+		"alpine-3.18":   "alpine:3.18",
+		"node-23":       "node:23",
+		"node-22":       "node:22",
+		"node-20":       "node:20",
+		"node-18":       "node:18-slim",
+		"python-3.14":   "python:3.14-slim",
+		"python-3.13":   "python:3.13-slim",
+		"python-3.12":   "python:3.12-slim",
+		"python-3.11":   "python:3.11-slim",
+		"golang-1.23":   "golang:1.23-alpine",
+		"golang-1.22":   "golang:1.22-alpine",
+		"golang-1.20":   "golang:1.20-alpine",
+	}
+
+	if image, ok := imageMap[runsOn]; ok {
+		return image
+	}
+
+	// Pattern matching for partial matches
+	switch {
+	case strings.Contains(runsOn, "ubuntu"):
+		return "ubuntu:22.04"
+	case strings.Contains(runsOn, "debian"):
+		return "debian:latest"
+	case strings.Contains(runsOn, "alpine"):
+		return "alpine:latest"
+	case strings.Contains(runsOn, "node"):
+		return "node:lts-slim"
+	case strings.Contains(runsOn, "python"):
+		return "python:3-slim"
+	case strings.Contains(runsOn, "golang") || strings.Contains(runsOn, "go"):
+		return "golang:alpine"
+	default:
+		return "ubuntu:22.04"
+	}
+}
+
+// stepCommand returns the command running the script of a step in its
+// shell, stopping at the first failing command like the bash runner
+func stepCommand(step *types.Step) []string {
+	switch step.Shell {
+	case "", "sh":
+		return []string{"/bin/sh", "-e", "-c", step.Run}
+	case "bash":
+		return []string{"bash", "-eo", "pipefail", "-c", step.Run}
+	case "pwsh", "powershell":
+		return []string{"pwsh", "-Command", step.Run}
+	case "python", "python3":
+		return []string{"python3", "-c", step.Run}
+	case "node":
+		return []string{"node", "-e", step.Run}
+	default:
+		return []string{step.Shell, "-c", step.Run}
+	}
+}
+
+// printStepsDryRun prints what the steps of a container or remote job
+// would run
+func printStepsDryRun(f *OutputFormatter, job *types.Job, stepEnvs []map[string]string) {
+	f.PrintSection("Would execute the following steps")
+
+	for i, step := range job.Steps {
+		fmt.Printf("\n[%d/%d] %s\n", i+1, len(job.Steps), step.Name)
+
+		if step.Uses != "" {
+			f.PrintKeyValue("Action", step.Uses, 2)
+			if len(step.With) > 0 {
+				f.PrintSubSection("  Parameters:")
+				for k, v := range step.With {
+					f.PrintKeyValue(k, v, 4)
+				}
+			}
+		}
+
+		if step.Run != "" {
+			f.PrintSubSection("  Command:")
+			lines := strings.Split(step.Run, "\n")
+			for _, line := range lines {
+				if strings.TrimSpace(line) != "" {
+					f.PrintOutput(line, 4)
+				}
+			}
+		}
+
+		if len(stepEnvs[i]) > 0 {
+			f.PrintSubSection("  Environment:")
+			for k, v := range stepEnvs[i] {
+				f.PrintKeyValue(k, v, 4)
+			}
+		}
+
+		if step.WorkingDir != "" {
+			f.PrintKeyValue("Working Dir", step.WorkingDir, 2)
+		}
+	}
+
+}
+
+// VolumeMount is a bind mount of --volume
+type VolumeMount struct {
+	Source   string // Absolute path on the host
+	Target   string // Absolute path in the container
+	ReadOnly bool
+}
+
+// ParseVolume parses a src:dst[:ro|rw] bind mount. A relative source is
+// resolved from the current directory and must exist.
+func ParseVolume(spec string) (VolumeMount, error) {
+	// A Windows source starts with its drive letter (C:\data:/data)
+	rest, drive := spec, ""
+	if len(spec) > 2 && spec[1] == ':' && (spec[2] == '\\' || spec[2] == '/') {
+		drive, rest = spec[:2], spec[2:]
+	}
+
+	parts := strings.Split(rest, ":")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return VolumeMount{}, fmt.Errorf("invalid volume '%s' (expected src:dst[:ro])", spec)
+	}
+	m := VolumeMount{Source: drive + parts[0], Target: parts[1]}
+
+	if len(parts) == 3 {
+		switch parts[2] {
+		case "ro":
+			m.ReadOnly = true
+		case "rw":
+		default:
+			return VolumeMount{}, fmt.Errorf("invalid volume '%s': unknown mode '%s' (ro, rw)", spec, parts[2])
+		}
+	}
+	if !path.IsAbs(m.Target) {
+		return VolumeMount{}, fmt.Errorf("invalid volume '%s': the container path must be absolute", spec)
+	}
+
+	source, err := filepath.Abs(m.Source)
+	if err != nil {
+		return VolumeMount{}, fmt.Errorf("invalid volume '%s': %w", spec, err)
+	}
+	if _, err := os.Stat(source); err != nil {
+		return VolumeMount{}, fmt.Errorf("invalid volume '%s': %s does not exist", spec, source)
+	}
+	m.Source = source
+	return m, nil
+}
+
+// configVolumes returns the bind mounts of --volume; they were checked
+// when the run started
+func configVolumes(cfg *config.RunnerConfig) []VolumeMount {
+	var mounts []VolumeMount
+	for _, spec := range cfg.Volumes {
+		if m, err := ParseVolume(spec); err == nil {
+			mounts = append(mounts, m)
+		}
+	}
+	return mounts
+}
+
+// builtinNetworks are the Docker network modes that are not networks of
+// their own: containers on them can't reach each other by name
+var builtinNetworks = map[string]bool{"bridge": true, "host": true, "none": true}
+
+// networkName matches the names Docker accepts for networks
+var networkName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// CheckNetwork validates a network mode: bridge, host, none or the name
+// of a network
+func CheckNetwork(mode string) error {
+	if mode == "" || builtinNetworks[mode] || networkName.MatchString(mode) {
+		return nil
+	}
+	return fmt.Errorf("invalid network '%s' (expected bridge, host, none or a network name)", mode)
+}
+
+// jobNetwork returns the network mode of a job container: --network or
+// the compose stack network, else the network of the job container; ""
+// is the default bridge
+func jobNetwork(cfg *config.RunnerConfig, job *types.Job) string {
+	if cfg.Network != "" {
+		return cfg.Network
+	}
+	if job.Container == nil {
+		return ""
+	}
+	if job.Container.NetworkMode != "" {
+		return job.Container.NetworkMode
+	}
+	return job.Container.Network
+}
+
+// namedNetwork reports whether a network mode is a network of its own,
+// which services can join
+func namedNetwork(mode string) bool {
+	return mode != "" && !builtinNetworks[mode]
+}
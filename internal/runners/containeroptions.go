@@ -0,0 +1,267 @@
+package runners
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	units "github.com/docker/go-units"
+	"github.com/sanix-darker/git-ci/pkg/types"
+)
+
+// containerOptions are the settings of a job container from its fields
+// and its `options:`, docker run flags as GitHub and GitLab write them
+type containerOptions struct {
+	user        string
+	privileged  bool
+	init        bool
+	capAdd      []string
+	capDrop     []string
+	securityOpt []string
+	volumes     []string
+	env         []string
+	hostname    string
+	extraHosts  []string
+	devices     []string
+	nanoCPUs    int64
+	memory      int64
+	memorySwap  int64
+	shmSize     int64
+
+	// Flags that don't apply to a job container, such as the health
+	// check of services, or that git-ci doesn't support
+	ignored []string
+}
+
+// valueFlags are the docker run flags taking a value, by their long name
+var valueFlags = map[string]string{
+	"-u": "--user", "--user": "--user",
+	"-v": "--volume", "--volume": "--volume",
+	"-e": "--env", "--env": "--env",
+	"-h": "--hostname", "--hostname": "--hostname",
+	"-m": "--memory", "--memory": "--memory",
+	"--memory-swap":  "--memory-swap",
+	"--cpus":         "--cpus",
+	"--shm-size":     "--shm-size",
+	"--cap-add":      "--cap-add",
+	"--cap-drop":     "--cap-drop",
+	"--security-opt": "--security-opt",
+	"--add-host":     "--add-host",
+	"--device":       "--device",
+}
+
+// parseContainerOptions returns the settings of a job container: user,
+// privileged, cap_add, cap_drop and security_opt, then its options
+func parseContainerOptions(c *types.Container) (*containerOptions, error) {
+	opts := &containerOptions{
+		user:        c.User,
+		privileged:  c.Privileged,
+		capAdd:      append([]string(nil), c.CapAdd...),
+		capDrop:     append([]string(nil), c.CapDrop...),
+		securityOpt: append([]string(nil), c.SecurityOpt...),
+	}
+
+	args, err := splitOptions(c.Options)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < len(args); i++ {
+		flag, value, inline := strings.Cut(args[i], "=")
+		switch flag {
+		case "--privileged":
+			opts.privileged = true
+			continue
+		case "--init":
+			opts.init = true
+			continue
+		}
+
+		name, ok := valueFlags[flag]
+		if !ok {
+			// A flag of another value is skipped with it
+			if !inline && i+1 < len(args) && !strings.HasPrefix(args[i+1], "-") {
+				i++
+			}
+			opts.ignored = append(opts.ignored, flag)
+			continue
+		}
+		if !inline {
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("option %s needs a value", flag)
+			}
+			i++
+			value = args[i]
+		}
+		if err := opts.set(name, value); err != nil {
+			return nil, fmt.Errorf("invalid %s '%s': %w", name, value, err)
+		}
+	}
+	return opts, nil
+}
+
+// set sets the value of a docker run flag
+func (o *containerOptions) set(name, value string) error {
+	var err error
+	switch name {
+	case "--user":
+		o.user = value
+	case "--volume":
+		o.volumes = append(o.volumes, value)
+	case "--env":
+		o.env = append(o.env, value)
+	case "--hostname":
+		o.hostname = value
+	case "--cap-add":
+		o.capAdd = append(o.capAdd, value)
+	case "--cap-drop":
+		o.capDrop = append(o.capDrop, value)
+	case "--security-opt":
+		o.securityOpt = append(o.securityOpt, value)
+	case "--add-host":
+		o.extraHosts = append(o.extraHosts, value)
+	case "--device":
+		o.devices = append(o.devices, value)
+	case "--cpus":
+		var cpus float64
+		cpus, err = strconv.ParseFloat(value, 64)
+		if err == nil && cpus <= 0 {
+			err = fmt.Errorf("must be positive")
+		}
+		o.nanoCPUs = int64(cpus * 1e9)
+	case "--memory":
+		o.memory, err = units.RAMInBytes(value)
+	case "--memory-swap":
+		o.memorySwap, err = units.RAMInBytes(value)
+	case "--shm-size":
+		o.shmSize, err = units.RAMInBytes(value)
+	}
+	return err
+}
+
+// apply sets the options on the config and host config of a container
+func (o *containerOptions) apply(config *container.Config, host *container.HostConfig) error {
+	if o.user != "" {
+		config.User = o.user
+	}
+	if o.hostname != "" {
+		config.Hostname = o.hostname
+	}
+	config.Env = append(config.Env, o.env...)
+
+	host.Privileged = o.privileged
+	if o.init {
+		host.Init = &o.init
+	}
+	host.CapAdd = append(host.CapAdd, o.capAdd...)
+	host.CapDrop = append(host.CapDrop, o.capDrop...)
+	host.SecurityOpt = append(host.SecurityOpt, o.securityOpt...)
+	host.ExtraHosts = append(host.ExtraHosts, o.extraHosts...)
+
+	for _, spec := range o.devices {
+		parts := strings.Split(spec, ":")
+		device := container.DeviceMapping{PathOnHost: parts[0], PathInContainer: parts[0], CgroupPermissions: "rwm"}
+		if len(parts) > 1 && parts[1] != "" {
+			device.PathInContainer = parts[1]
+		}
+		if len(parts) > 2 {
+			device.CgroupPermissions = parts[2]
+		}
+		host.Devices = append(host.Devices, device)
+	}
+
+	for _, spec := range o.volumes {
+		m, err := optionMount(spec)
+		if err != nil {
+			return err
+		}
+		host.Mounts = append(host.Mounts, m)
+	}
+
+	if o.nanoCPUs > 0 {
+		host.Resources.NanoCPUs = o.nanoCPUs
+	}
+	if o.memory > 0 {
+		host.Resources.Memory = o.memory
+		host.Resources.MemorySwap = o.memory
+	}
+	if o.memorySwap != 0 {
+		host.Resources.MemorySwap = o.memorySwap
+	}
+	if o.shmSize > 0 {
+		host.ShmSize = o.shmSize
+	}
+	return nil
+}
+
+// optionMount returns the mount of a -v option: a bind mount of a host
+// path, or a named volume (a source without path separator, longer than
+// a Windows drive letter)
+func optionMount(spec string) (mount.Mount, error) {
+	source, _, _ := strings.Cut(spec, ":")
+	if len(source) > 1 && !strings.ContainsAny(source, `/\.~`) {
+		parts := strings.Split(spec, ":")
+		if len(parts) < 2 || parts[1] == "" {
+			return mount.Mount{}, fmt.Errorf("invalid volume '%s' (expected name:dst[:ro])", spec)
+		}
+		return mount.Mount{
+			Type:     mount.TypeVolume,
+			Source:   parts[0],
+			Target:   parts[1],
+			ReadOnly: len(parts) > 2 && parts[2] == "ro",
+		}, nil
+	}
+
+	m, err := ParseVolume(spec)
+	if err != nil {
+		return mount.Mount{}, err
+	}
+	return mount.Mount{Type: mount.TypeBind, Source: m.Source, Target: m.Target, ReadOnly: m.ReadOnly}, nil
+}
+
+// splitOptions splits options into arguments like a shell: on spaces,
+// keeping quoted strings together
+func splitOptions(s string) ([]string, error) {
+	var args []string
+	var arg strings.Builder
+	inArg := false
+	var quote rune
+	escaped := false
+
+	for _, ch := range s {
+		switch {
+		case escaped:
+			arg.WriteRune(ch)
+			escaped = false
+		case ch == '\\' && quote != '\'':
+			escaped = true
+			inArg = true
+		case quote != 0:
+			if ch == quote {
+				quote = 0
+			} else {
+				arg.WriteRune(ch)
+			}
+		case ch == '\'' || ch == '"':
+			quote = ch
+			inArg = true
+		case ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(ch)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote in options '%s'", s)
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
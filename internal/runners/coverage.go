@@ -0,0 +1,106 @@
+package runners
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/sanix-darker/git-ci/pkg/types"
+)
+
+// coverageNumber matches the number a coverage match holds
+var coverageNumber = regexp.MustCompile(`\d+(?:\.\d+)?`)
+
+// ExtractCoverage returns the coverage percentage a GitLab `coverage:`
+// regex (/.../) finds in the output of a job: the first number of its
+// last match, as GitLab reads it
+func ExtractCoverage(pattern, output string) (float64, bool, error) {
+	pattern = strings.TrimSpace(pattern)
+	if len(pattern) >= 2 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+		pattern = pattern[1 : len(pattern)-1]
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid coverage regex: %w", err)
+	}
+
+	matches := re.FindAllString(output, -1)
+	if len(matches) == 0 {
+		return 0, false, nil
+	}
+
+	number := coverageNumber.FindString(matches[len(matches)-1])
+	if number == "" {
+		return 0, false, nil
+	}
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return 0, false, nil
+	}
+	return value, true, nil
+}
+
+// outputCapture keeps the output of a job; a nil capture drops it
+type outputCapture struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (c *outputCapture) Write(p []byte) (int, error) {
+	if c == nil {
+		return len(p), nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.buf.Write(p)
+}
+
+// coverageTracker extracts the coverage of the jobs of a runner that set
+// a coverage regex from their output
+type coverageTracker struct {
+	output   *outputCapture // Output of the running job, nil without regex
+	coverage float64
+	found    bool
+}
+
+// beginCoverage starts capturing the output of job when it has a
+// coverage regex
+func (t *coverageTracker) beginCoverage(job *types.Job) {
+	t.output = nil
+	t.found = false
+	if job.Coverage != "" {
+		t.output = &outputCapture{}
+	}
+}
+
+// finishCoverage extracts the coverage of job from its captured output
+func (t *coverageTracker) finishCoverage(job *types.Job, f *OutputFormatter) {
+	if t.output == nil {
+		return
+	}
+
+	t.output.mu.Lock()
+	output := t.output.buf.String()
+	t.output.mu.Unlock()
+	t.output = nil
+
+	coverage, found, err := ExtractCoverage(job.Coverage, output)
+	if err != nil {
+		f.PrintWarning(err.Error())
+		return
+	}
+	if !found {
+		f.PrintDebug("Coverage regex matched nothing in the job output")
+		return
+	}
+	t.coverage, t.found = coverage, true
+}
+
+// Coverage returns the coverage of the last job, when its regex matched
+func (t *coverageTracker) Coverage() (float64, bool) {
+	return t.coverage, t.found
+}
@@ -3,9 +3,13 @@ package runners
 import (
 	"bufio"
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"regexp"
 	"strings"
 	"sync"
 	"time"
@@ -13,18 +17,56 @@ import (
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/image"
 	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/network"
 	"github.com/docker/docker/client"
 	"github.com/docker/docker/pkg/stdcopy"
+	units "github.com/docker/go-units"
 	"github.com/sanix-darker/git-ci/internal/config"
 	"github.com/sanix-darker/git-ci/pkg/types"
 )
 
+// invalidNameChars matches what container names can't hold
+var invalidNameChars = regexp.MustCompile(`[^a-z0-9_.-]+`)
+
 type DockerRunner struct {
 	client     *client.Client
 	config     *config.RunnerConfig
 	containers []string
 	formatter  *OutputFormatter
 	mu         sync.Mutex
+
+	// Image of the last job and its registry digest
+	image       string
+	imageDigest string
+
+	// Container of the running job, where its steps are executed, and
+	// the context done at the time limit of the job
+	container string
+	jobCtx    context.Context
+
+	// Services of the job and the network it shares with them; networkID
+	// is set when the network was created for the job
+	services    *ServiceManager
+	networkName string
+	networkID   string
+
+	// What the steps of a GitHub job wrote to their GITHUB_* files, and
+	// the PATH of its container their PATH directories go before
+	commands  *jobCommands
+	imagePath string
+
+	// Host directory of the job mounted at ContainerStepFiles, where the
+	// GitHub steps get their files
+	stepsDir string
+
+	// Remote actions copied into the job container, by their directory,
+	// and the directory of the run they are fetched to without cache
+	copiedActions map[string]string
+	actionsDir    string
+
+	coverageTracker
+	stepTracker
+	runContexts
 }
 
 // NewDockerRunner creates a new Docker runner
@@ -56,7 +98,7 @@ func NewDockerRunner(cfg *config.RunnerConfig) (*DockerRunner, error) {
 		return nil, fmt.Errorf("Docker daemon is not accessible: %w", err)
 	}
 
-	formatter := NewOutputFormatter(cfg.Verbose)
+	formatter := newFormatter(cfg)
 
 	// Show Docker version in verbose mode
 	if cfg.Verbose {
@@ -75,18 +117,31 @@ func (r *DockerRunner) RunJob(job *types.Job, workdir string) error {
 	ctx := context.Background()
 	startTime := time.Now()
 
-	imageName := r.getImageName(job)
+	imageName := JobImage(job)
+	if pinned, ok := r.config.PinImages[job.Name]; ok {
+		imageName = pinned
+	}
+	r.image = imageName
 
 	// Print job header
 	r.formatter.PrintHeader(job.Name, workdir, fmt.Sprintf("docker (%s)", imageName))
 
+	// Expand variables that refer to others
+	jobEnv, stepEnvs, err := ResolveEnv(job, r.config, r.baseEnvironment(job))
+	if err != nil {
+		return err
+	}
+
 	// Show dry run mode if enabled
 	if r.config.DryRun {
 		r.formatter.PrintDryRun()
-		return r.dryRunJob(job)
+		printStepsDryRun(r.formatter, job, stepEnvs)
+		return nil
 	}
 
 	// Initialize job summary
+	r.beginCoverage(job)
+
 	summary := &JobSummary{
 		JobName:    job.Name,
 		TotalSteps: len(job.Steps),
@@ -96,28 +151,46 @@ func (r *DockerRunner) RunJob(job *types.Job, workdir string) error {
 	// Check if image exists locally
 	imageExists := r.imageExists(ctx, imageName)
 
-	// Pull image if needed
-	if r.config.PullImages || !imageExists {
+	// Pull image if needed (a disabled image cache forces a fresh pull)
+	if r.config.PullImages || !imageExists || !r.config.CacheEnabled(config.CacheKindImage) {
 		progress := r.formatter.NewProgress(fmt.Sprintf("Pulling image %s", imageName))
-		if err := r.pullImage(ctx, imageName); err != nil {
+		if err := r.pullImage(ctx, imageName, job.Container); err != nil {
 			progress.Complete(false)
 			return err
 		}
 		progress.Complete(true)
 	}
 
-	// Print services if any
+	// Local lookup, the image is there by now
+	r.imageDigest = r.getImageDigest(ctx, imageName)
+
+	// Start the services on the network of the job
+	r.networkName = jobNetwork(r.config, job)
+	if err := r.checkNetwork(ctx, r.networkName, len(job.Services) > 0); err != nil {
+		return err
+	}
 	if len(job.Services) > 0 {
-		services := make(map[string]string)
-		for name, svc := range job.Services {
-			services[name] = svc.Image
+		if err := r.startServices(ctx, job, jobEnv); err != nil {
+			return err
+		}
+	}
+
+	// The step files of a GitHub job live out of its workspace, removed
+	// with the job (clean --stale removes those of crashed runs)
+	r.stepsDir = ""
+	if r.config.Provider == "github" {
+		if r.stepsDir, err = newJobStepFilesDir(r.config.RunID); err != nil {
+			return err
 		}
-		r.formatter.PrintServices(services)
+		defer func() {
+			os.RemoveAll(r.stepsDir)
+			r.stepsDir = ""
+		}()
 	}
 
 	// Create and run container
 	r.formatter.PrintInfo("Creating container")
-	containerID, err := r.createContainer(ctx, job, imageName, workdir)
+	containerID, err := r.createContainer(ctx, job, imageName, workdir, jobEnv)
 	if err != nil {
 		return err
 	}
@@ -132,37 +205,119 @@ func (r *DockerRunner) RunJob(job *types.Job, workdir string) error {
 		return fmt.Errorf("failed to start container: %w", err)
 	}
 
-	// Stream logs
-	r.formatter.PrintSection("Container Output")
-	if err := r.streamLogs(ctx, containerID); err != nil {
-		summary.Success = false
-		summary.Errors = append(summary.Errors, fmt.Sprintf("Log streaming error: %v", err))
+	r.container = containerID
+	r.commands = nil
+	r.copiedActions = nil
+	if r.config.Provider == "github" {
+		r.commands = newJobCommands()
+		r.imagePath = r.containerPath(ctx, containerID)
+	}
+
+	// Past its timeout-minutes or the configured timeout, the job stops:
+	// its container is killed, ending the running step, and Cleanup
+	// removes it like the others
+	r.jobCtx = ctx
+	limit := jobTimeout(job, r.config)
+	stopKill := func() bool { return true }
+	if limit > 0 {
+		var cancel context.CancelFunc
+		r.jobCtx, cancel = context.WithTimeoutCause(ctx, limit, &TimeoutError{Limit: limit, Job: true})
+		defer cancel()
+		jobCtx := r.jobCtx
+		stopKill = context.AfterFunc(jobCtx, func() {
+			if errors.Is(jobCtx.Err(), context.DeadlineExceeded) {
+				r.killContainer(containerID)
+			}
+		})
+		defer stopKill()
 	}
 
-	// Wait for container to finish
-	statusCh, errCh := r.client.ContainerWait(ctx, containerID, container.WaitConditionNotRunning)
-	select {
-	case err := <-errCh:
+	// Run each step in the container
+	gate := newStepGate(jobEnv, r.config, r.formatter, &r.runContexts)
+	afterScript := false
+	for i, step := range job.Steps {
+		stepNum := i + 1
+		stepStart := time.Now()
+
+		// after_script runs whatever happened to the steps before it,
+		// past the job timeout too (in the container started again),
+		// within a limit of its own
+		if step.Phase == types.StepPhaseAfterScript && !afterScript {
+			afterScript = true
+			if !stopKill() || r.jobCtx.Err() != nil {
+				if err := r.client.ContainerStart(ctx, containerID, container.StartOptions{}); err != nil {
+					r.formatter.PrintWarning(fmt.Sprintf("Failed to restart the container for after_script: %v", err))
+				}
+			}
+			var cancel context.CancelFunc
+			r.jobCtx, cancel = afterScriptContext()
+			defer cancel()
+		}
+
+		// Check for timeout
+		if r.jobCtx.Err() != nil {
+			if !summary.TimedOut {
+				summary.timedOut(limit)
+			}
+			continue
+		}
+
+		if !gate.allows(&step, stepNum, len(job.Steps), summary) {
+			continue
+		}
+		gate.interpolate(&step, r.commands.stepEnv(jobEnv, ""))
+
+		r.formatter.PrintStepHeader(step.Name, stepNum, len(job.Steps))
+
+		var err error
+		if step.Uses != "" {
+			err = r.runAction(&step, gate, gate.expandValues(stepEnvs[i]), workdir)
+		} else {
+			err = r.RunStep(&step, gate.expandValues(stepEnvs[i]), workdir)
+		}
+
+		var skipped *SkippedStepError
+		if errors.As(err, &skipped) {
+			r.formatter.PrintStepSkipped(skipped.Reason)
+			summary.SkippedSteps++
+			summary.stepSkipped(step.Name, skipped.Reason)
+			gate.setStep(&step, "skipped", "skipped", nil)
+			continue
+		}
+		stepDuration := time.Since(stepStart)
+		summary.stepFinished(step.Name, stepStart, err)
+		gate.finished(&step, r.commands.take(), err)
+
 		if err != nil {
+			summary.FailedSteps++
+			if step.ContinueOnErr && !jobTimedOut(err) {
+				r.formatter.PrintWarning(fmt.Sprintf("Step failed but continuing: %v", err))
+				r.formatter.PrintStepComplete(stepDuration)
+				continue
+			}
+			r.formatter.PrintStepFailed(err, stepDuration)
 			summary.Success = false
-			summary.Errors = append(summary.Errors, fmt.Sprintf("Container wait error: %v", err))
-			return fmt.Errorf("container wait error: %w", err)
+			summary.Errors = append(summary.Errors, fmt.Sprintf("Step '%s' failed: %v", step.Name, err))
+			summary.ExitCode = ExitCode(err)
+			gate.fail()
+			if jobTimedOut(err) {
+				summary.timedOut(limit)
+			}
+			continue
 		}
-	case status := <-statusCh:
-		if status.StatusCode != 0 {
-			summary.Success = false
-			summary.Errors = append(summary.Errors, fmt.Sprintf("Container exited with status %d", status.StatusCode))
 
-			// Get last logs for debugging
-			logs, _ := r.getContainerLogs(ctx, containerID, 20)
-			if logs != "" {
-				r.formatter.PrintSection("Last 20 lines of output")
-				fmt.Print(logs)
-			}
+		summary.CompletedSteps++
+		r.formatter.PrintStepComplete(stepDuration)
+	}
+
+	r.finishCoverage(job, r.formatter)
+	r.finishSteps(summary)
 
-			return fmt.Errorf("container exited with status %d", status.StatusCode)
+	// Hand the job outputs to the jobs needing this one
+	if r.commands != nil {
+		if outputs := gate.jobOutputs(job.Outputs, r.commands.outputs); len(outputs) > 0 {
+			job.Outputs = outputs
 		}
-		summary.CompletedSteps = len(job.Steps)
 	}
 
 	// Print job summary
@@ -173,17 +328,256 @@ func (r *DockerRunner) RunJob(job *types.Job, workdir string) error {
 		r.formatter.PrintJobComplete(job.Name, summary.Duration, summary.Success)
 	}
 
-	return nil
+	return summary.Err(job)
 }
 
+// RunStep runs a step in the job container started by RunJob. env is the
+// step env; the job variables are already set on the container.
+// step.WorkingDir is relative to the workspace.
 func (r *DockerRunner) RunStep(step *types.Step, env map[string]string, workdir string) error {
-	// TODO:
-	// Steps are executed as part of the job script in Docker
-	// This could be enhanced to support individual step containers
-	// for later
+	if step.Run == "" {
+		return nil
+	}
+	if r.container == "" {
+		return fmt.Errorf("no running container for step '%s'", step.Name)
+	}
+
+	if r.config.Verbose {
+		r.formatter.PrintCommand(step.Run, 2)
+	}
+
+	// A GitHub step sees what earlier steps wrote to their files, and
+	// gets its own, in the step files directory the container mounts
+	var files *stepFiles
+	if r.commands != nil {
+		var err error
+		if files, err = newContainerStepFiles(r.stepsDir); err != nil {
+			return err
+		}
+		defer files.remove()
+
+		stepEnv := make(map[string]string)
+		for _, vars := range []map[string]string{r.commands.stepEnv(env, r.imagePath), files.vars()} {
+			for k, v := range vars {
+				stepEnv[k] = v
+			}
+		}
+		env = stepEnv
+	}
+
+	inv := newStepInvocation(step, ContainerWorkspace, env)
+	options := container.ExecOptions{
+		Cmd:          inv.command,
+		WorkingDir:   inv.dir,
+		Env:          inv.envList(),
+		AttachStdout: true,
+		AttachStderr: true,
+	}
+
+	// Report progress while the step stays silent
+	hb := r.formatter.NewHeartbeat(step.Name, r.config.HeartbeatInterval(), r.effectiveTimeout(step))
+	hb.Start()
+	defer hb.Stop()
+
+	attempts := 1
+	if step.RetryPolicy != nil && step.RetryPolicy.MaxAttempts > 1 {
+		attempts = step.RetryPolicy.MaxAttempts
+	}
+
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if attempt > 1 {
+			r.formatter.PrintWarning(fmt.Sprintf("Attempt %d failed: %v", attempt-1, err))
+			r.formatter.PrintInfo(fmt.Sprintf("Retry attempt %d/%d", attempt, attempts))
+			if err = waitRetryDelay(r.stepContext(), step.RetryPolicy.Delay); err != nil {
+				break
+			}
+		}
+
+		if err = r.execStep(step, options, hb); err == nil {
+			break
+		}
+	}
+
+	if err != nil && attempts > 1 {
+		err = fmt.Errorf("all %d attempts failed, last error: %w", attempts, err)
+	}
+	if files != nil {
+		if filesErr := r.applyStepFiles(files); err == nil {
+			err = filesErr
+		}
+	}
+	return err
+}
+
+// applyStepFiles reads what a step wrote to its files for the next steps
+// and the outputs, and prints its summary
+func (r *DockerRunner) applyStepFiles(files *stepFiles) error {
+	cmds, err := files.read()
+	if err != nil {
+		return err
+	}
+	r.commands.add(cmds)
+	printStepSummary(r.formatter, cmds.summary)
+	return nil
+}
+
+// containerPath returns the PATH of a container, which GITHUB_PATH
+// directories go before
+func (r *DockerRunner) containerPath(ctx context.Context, containerID string) string {
+	if info, err := r.client.ContainerInspect(ctx, containerID); err == nil && info.Config != nil {
+		for _, kv := range info.Config.Env {
+			if value, ok := strings.CutPrefix(kv, "PATH="); ok {
+				return value
+			}
+		}
+	}
+	return "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+}
+
+// execStep runs a step once in the job container and returns an error
+// for a non-zero exit code or a step or job timeout
+func (r *DockerRunner) execStep(step *types.Step, options container.ExecOptions, hb *Heartbeat) error {
+	ctx := r.stepContext()
+	if step.TimeoutMin > 0 {
+		limit := r.config.Minutes(step.TimeoutMin)
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, limit, &TimeoutError{Limit: limit})
+		defer cancel()
+	}
+
+	// The processes of the step inherit the marker, which finds them at
+	// the timeout
+	marker := newExecMarker()
+	options.Env = append(options.Env[:len(options.Env):len(options.Env)], marker)
+
+	exec, err := r.client.ContainerExecCreate(ctx, r.container, options)
+	if err != nil {
+		return fmt.Errorf("failed to create exec: %w", err)
+	}
+
+	attach, err := r.client.ContainerExecAttach(ctx, exec.ID, container.ExecAttachOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to attach to exec: %w", err)
+	}
+	defer attach.Close()
+
+	// Stream the output until the command exits or the step times out
+	done := make(chan error, 1)
+	go func() {
+		stdout := newHeartbeatWriter(os.Stdout, hb, r.output, r.formatter)
+		stderr := newHeartbeatWriter(os.Stderr, hb, r.output, r.formatter)
+		_, err := stdcopy.StdCopy(stdout, stderr, attach.Reader)
+		_ = stdout.Flush()
+		_ = stderr.Flush()
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		// The output also ends when the container is killed at the timeout
+		if ctx.Err() != nil {
+			return context.Cause(ctx)
+		}
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("error streaming output: %w", err)
+		}
+	case <-ctx.Done():
+		attach.Close()
+		r.killExec(exec.ID, marker, options.User)
+		return context.Cause(ctx)
+	}
+
+	inspect, err := r.client.ContainerExecInspect(context.Background(), exec.ID)
+	if err != nil {
+		return fmt.Errorf("failed to inspect exec: %w", err)
+	}
+	if inspect.ExitCode != 0 {
+		return &ExitError{Code: inspect.ExitCode, Message: fmt.Sprintf("command exited with status %d", inspect.ExitCode)}
+	}
+
 	return nil
 }
 
+// execMarkerVar marks the processes of a step exec'd in a container
+const execMarkerVar = "GIT_CI_EXEC"
+
+// newExecMarker returns the variable marking the processes of a step
+func newExecMarker() string {
+	id := make([]byte, 8)
+	_, _ = rand.Read(id)
+	return execMarkerVar + "=" + hex.EncodeToString(id)
+}
+
+// killExecScript kills the processes of the container whose environment
+// holds the marker given as $1
+const killExecScript = `for p in /proc/[0-9]*; do
+	if tr '\0' '\n' 2>/dev/null <"$p/environ" | grep -qxF "$1"; then
+		kill -KILL "${p#/proc/}" 2>/dev/null
+	fi
+done`
+
+// killExecWait bounds the wait for a killed step to be gone
+const killExecWait = 10 * time.Second
+
+// killExec kills a step still running in the job container at its
+// timeout, with the processes it started: closing the stream leaves them
+// running next to the later steps. The PID of the exec is one of the
+// host, so they are found by the marker they inherit instead.
+func (r *DockerRunner) killExec(execID, marker, user string) {
+	ctx, cancel := context.WithTimeout(context.Background(), killExecWait)
+	defer cancel()
+
+	if inspect, err := r.client.ContainerExecInspect(ctx, execID); err != nil || !inspect.Running {
+		return
+	}
+	kill, err := r.client.ContainerExecCreate(ctx, r.container, container.ExecOptions{
+		Cmd:  []string{"sh", "-c", killExecScript, "sh", marker},
+		User: user,
+	})
+	if err == nil {
+		err = r.client.ContainerExecStart(ctx, kill.ID, container.ExecStartOptions{Detach: true})
+	}
+	if err != nil {
+		r.formatter.PrintWarning(fmt.Sprintf("Failed to kill the timed out step: %v", err))
+		return
+	}
+
+	for {
+		inspect, err := r.client.ContainerExecInspect(ctx, execID)
+		if err != nil || !inspect.Running {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			r.formatter.PrintWarning("The timed out step is still running in the container")
+			return
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+// stepContext returns the context the steps of the job run in, done at
+// the time limit of the job
+func (r *DockerRunner) stepContext() context.Context {
+	if r.jobCtx == nil {
+		return context.Background()
+	}
+	return r.jobCtx
+}
+
+// effectiveTimeout returns the timeout that applies to a step
+func (r *DockerRunner) effectiveTimeout(step *types.Step) time.Duration {
+	return stepTimeout(step, r.config, r.jobCtx)
+}
+
+// killContainer kills the container of a job at its timeout
+func (r *DockerRunner) killContainer(containerID string) {
+	if err := r.client.ContainerKill(context.Background(), containerID, "KILL"); err != nil {
+		r.formatter.PrintWarning(fmt.Sprintf("Failed to kill container %s: %v", containerID[:12], err))
+	}
+}
+
 func (r *DockerRunner) imageExists(ctx context.Context, imageName string) bool {
 	images, err := r.client.ImageList(ctx, image.ListOptions{})
 	if err != nil {
@@ -196,72 +590,51 @@ func (r *DockerRunner) imageExists(ctx context.Context, imageName string) bool {
 				return true
 			}
 		}
+		for _, digest := range img.RepoDigests {
+			if digest == imageName {
+				return true
+			}
+		}
 	}
 	return false
 }
 
-func (r *DockerRunner) getImageName(job *types.Job) string {
-	// Use container image if specified
-	if job.Container != nil && job.Container.Image != "" {
-		return job.Container.Image
-	}
-
-	// Use job image if specified
-	if job.Image != "" {
-		return job.Image
-	}
-
-	// Map runs-on to Docker images
-	runsOn := strings.ToLower(job.RunsOn)
-
-	// Common mappings
-	imageMap := map[string]string{
-		"ubuntu-24.04":  "ubuntu:24.04",
-		"ubuntu-22.04":  "ubuntu:22.04",
-		"ubuntu-20.04":  "ubuntu:20.04",
-		"ubuntu-latest": "ubuntu:latest",
-		"debian-12":     "debian:12",
-		"debian-11":     "debian:11",
-		"alpine-3.19":   "alpine:3.19",
-		"alpine-3.18":   "alpine:3.18",
-		"node-23":       "node:23",
-		"node-22":       "node:22",
-		"node-20":       "node:20",
-		"node-18":       "node:18-slim",
-		"python-3.14":   "python:3.14-slim",
-		"python-3.13":   "python:3.13-slim",
-		"python-3.12":   "python:3.12-slim",
-		"python-3.11":   "python:3.11-slim",
-		"golang-1.23":   "golang:1.23-alpine",
-		"golang-1.22":   "golang:1.22-alpine",
-		"golang-1.20":   "golang:1.20-alpine",
-	}
-
-	if image, ok := imageMap[runsOn]; ok {
-		return image
-	}
-
-	// Pattern matching for partial matches
-	switch {
-	case strings.Contains(runsOn, "ubuntu"):
-		return "ubuntu:22.04"
-	case strings.Contains(runsOn, "debian"):
-		return "debian:latest"
-	case strings.Contains(runsOn, "alpine"):
-		return "alpine:latest"
-	case strings.Contains(runsOn, "node"):
-		return "node:lts-slim"
-	case strings.Contains(runsOn, "python"):
-		return "python:3-slim"
-	case strings.Contains(runsOn, "golang") || strings.Contains(runsOn, "go"):
-		return "golang:alpine"
-	default:
-		return "ubuntu:22.04"
+// getImageDigest returns the registry digest (sha256:...) of a local
+// image, or "" for images that never came from a registry
+func (r *DockerRunner) getImageDigest(ctx context.Context, imageName string) string {
+	info, err := r.client.ImageInspect(ctx, imageName)
+	if err != nil || len(info.RepoDigests) == 0 {
+		return ""
 	}
+
+	// Prefer the digest of the repository the image was asked from
+	repository := types.ImageRepository(imageName)
+	for _, repoDigest := range info.RepoDigests {
+		if name, digest, ok := strings.Cut(repoDigest, "@"); ok && name == repository {
+			return digest
+		}
+	}
+	_, digest, _ := strings.Cut(info.RepoDigests[0], "@")
+	return digest
 }
 
-func (r *DockerRunner) pullImage(ctx context.Context, imageName string) error {
-	reader, err := r.client.ImagePull(ctx, imageName, image.PullOptions{})
+// ResolvedImage returns the image of the last job and its digest
+func (r *DockerRunner) ResolvedImage() (string, string) {
+	return r.image, r.imageDigest
+}
+
+// pullImage pulls the image of a job, with the registry credentials of its
+// container or of the configuration file when they match its registry
+func (r *DockerRunner) pullImage(ctx context.Context, imageName string, c *types.Container) error {
+	auth, err := encodedRegistryAuth(imageName, c, r.config)
+	if err != nil {
+		return fmt.Errorf("invalid registry credentials: %w", err)
+	}
+	if auth != "" {
+		r.formatter.PrintDebug(fmt.Sprintf("Authenticating to %s", imageRegistry(imageName)))
+	}
+
+	reader, err := r.client.ImagePull(ctx, imageName, image.PullOptions{RegistryAuth: auth})
 	if err != nil {
 		return fmt.Errorf("failed to pull image %s: %w", imageName, err)
 	}
@@ -281,33 +654,60 @@ func (r *DockerRunner) pullImage(ctx context.Context, imageName string) error {
 	return nil
 }
 
-func (r *DockerRunner) createContainer(ctx context.Context, job *types.Job, imageName, workdir string) (string, error) {
-	// Build script from steps
-	script := r.buildJobScript(job)
+func (r *DockerRunner) createContainer(ctx context.Context, job *types.Job, imageName, workdir string, jobEnv map[string]string) (string, error) {
+	containerConfig, hostConfig, err := r.containerSpec(job, imageName, workdir, jobEnv)
+	if err != nil {
+		return "", err
+	}
+
+	// Job names such as "build / test (1.22)" hold characters container
+	// names can't
+	containerName := fmt.Sprintf("git-ci-%s-%d",
+		invalidNameChars.ReplaceAllString(strings.ToLower(job.Name), "-"),
+		time.Now().Unix())
 
-	// Log script in debug mode
-	if r.config.Verbose {
-		r.formatter.PrintSection("Generated Script")
-		fmt.Println(script)
-		r.formatter.PrintSection("Container Configuration")
+	resp, err := r.client.ContainerCreate(
+		ctx,
+		containerConfig,
+		hostConfig,
+		nil,
+		nil,
+		containerName,
+	)
+	if err != nil {
+		return "", fmt.Errorf("failed to create container: %w", err)
 	}
 
-	// Prepare container config
+	r.formatter.PrintDebug(fmt.Sprintf("Container created: %s", resp.ID[:12]))
+	return resp.ID, nil
+}
+
+// containerSpec returns the configuration of the container of a job:
+// its image, environment, mounts, user, privileges and network
+func (r *DockerRunner) containerSpec(job *types.Job, imageName, workdir string, jobEnv map[string]string) (*container.Config, *container.HostConfig, error) {
+	// Prepare container config; the container idles while steps are
+	// executed in it
 	containerConfig := &container.Config{
 		Image:      imageName,
-		Cmd:        []string{"/bin/sh", "-c", script},
-		WorkingDir: "/workspace",
-		Env:        r.buildEnvironment(job),
+		Cmd:        keepAliveCommand,
+		WorkingDir: ContainerWorkspace,
+		Env:        r.buildEnvironment(job, jobEnv),
+		Labels:     ContainerLabels(r.config),
 		Tty:        false,
 	}
 
+	// Entrypoint override of the job image ([""] clears the image's one)
+	if job.Container != nil && job.Container.Entrypoint != nil {
+		containerConfig.Entrypoint = job.Container.Entrypoint
+	}
+
 	// Prepare host config
 	hostConfig := &container.HostConfig{
 		Mounts: []mount.Mount{
 			{
 				Type:   mount.TypeBind,
 				Source: workdir,
-				Target: "/workspace",
+				Target: ContainerWorkspace,
 			},
 		},
 		AutoRemove: false,
@@ -318,6 +718,25 @@ func (r *DockerRunner) createContainer(ctx context.Context, job *types.Job, imag
 		},
 	}
 
+	// Step files of a GitHub job
+	if r.stepsDir != "" {
+		hostConfig.Mounts = append(hostConfig.Mounts, mount.Mount{
+			Type:   mount.TypeBind,
+			Source: r.stepsDir,
+			Target: ContainerStepFiles,
+		})
+	}
+
+	// Local memory hint overrides the default limit
+	if job.Local != nil && job.Local.Memory != "" {
+		memory, err := units.RAMInBytes(job.Local.Memory)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid memory hint '%s': %w", job.Local.Memory, err)
+		}
+		hostConfig.Resources.Memory = memory
+		hostConfig.Resources.MemorySwap = memory
+	}
+
 	// Add additional volumes if specified
 	if job.Container != nil {
 		for _, vol := range job.Container.Volumes {
@@ -333,111 +752,113 @@ func (r *DockerRunner) createContainer(ctx context.Context, job *types.Job, imag
 		}
 	}
 
-	containerName := fmt.Sprintf("git-ci-%s-%d",
-		strings.ReplaceAll(strings.ToLower(job.Name), " ", "-"),
-		time.Now().Unix())
-
-	resp, err := r.client.ContainerCreate(
-		ctx,
-		containerConfig,
-		hostConfig,
-		nil,
-		nil,
-		containerName,
-	)
-	if err != nil {
-		return "", fmt.Errorf("failed to create container: %w", err)
-	}
-
-	r.formatter.PrintDebug(fmt.Sprintf("Container created: %s", resp.ID[:12]))
-	return resp.ID, nil
-}
-
-func (r *DockerRunner) buildJobScript(job *types.Job) string {
-	var commands []string
-
-	// Add shebang and shell options
-	commands = append(commands, "#!/bin/sh")
-	commands = append(commands, "set -e") // Exit on error
-
-	if r.config.Verbose {
-		commands = append(commands, "set -x") // Print commands
+	// Bind mounts of --volume, next to the workspace
+	for _, m := range configVolumes(r.config) {
+		hostConfig.Mounts = append(hostConfig.Mounts, mount.Mount{
+			Type:     mount.TypeBind,
+			Source:   m.Source,
+			Target:   m.Target,
+			ReadOnly: m.ReadOnly,
+		})
 	}
 
-	commands = append(commands, "")
-	commands = append(commands, "echo 'Setting up environment...'")
-	commands = append(commands, "")
-
-	totalSteps := len(job.Steps)
-	stepNum := 0
-
-	for _, step := range job.Steps {
-		if step.Uses != "" {
-			stepNum++
-			commands = append(commands, fmt.Sprintf("echo ''"))
-			commands = append(commands, fmt.Sprintf("echo '[%d/%d] %s'", stepNum, totalSteps, step.Name))
-			commands = append(commands, fmt.Sprintf("echo '%s'", strings.Repeat("-", 60)))
-			commands = append(commands, fmt.Sprintf("echo 'Skipping action: %s (not supported in Docker runner)'", step.Name))
-			continue
-		}
-
-		if step.Run == "" {
-			continue
+	// User, privileges and the docker run flags of the container options
+	if job.Container != nil {
+		opts, err := parseContainerOptions(job.Container)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid container options: %w", err)
 		}
-
-		stepNum++
-		commands = append(commands, fmt.Sprintf("echo ''"))
-		commands = append(commands, fmt.Sprintf("echo '[%d/%d] %s'", stepNum, totalSteps, step.Name))
-		commands = append(commands, fmt.Sprintf("echo '%s'", strings.Repeat("-", 60)))
-
-		// Handle working directory
-		if step.WorkingDir != "" {
-			commands = append(commands, fmt.Sprintf("cd %s", step.WorkingDir))
+		if err := opts.apply(containerConfig, hostConfig); err != nil {
+			return nil, nil, fmt.Errorf("invalid container options: %w", err)
 		}
-
-		// Add environment variables for this step
-		for k, v := range step.Env {
-			commands = append(commands, fmt.Sprintf("export %s='%s'", k, v))
+		if len(opts.ignored) > 0 {
+			r.formatter.PrintWarning(fmt.Sprintf("Ignoring container options: %s", strings.Join(opts.ignored, ", ")))
 		}
+	}
 
-		// Add the actual command
-		commands = append(commands, step.Run)
+	// Join the network of the compose stack or of the job services so
+	// they resolve, or the --network mode
+	if r.networkName != "" {
+		hostConfig.NetworkMode = container.NetworkMode(r.networkName)
+	}
 
-		// Handle continue-on-error
-		if step.ContinueOnErr {
-			commands = append(commands, "|| true")
+	// Publish the job container ports on the host
+	if job.Container != nil && len(job.Container.Ports) > 0 {
+		exposed, bindings, err := portBindings(job.Container.Ports)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid container ports: %w", err)
 		}
+		containerConfig.ExposedPorts = exposed
+		hostConfig.PortBindings = bindings
+	}
 
-		commands = append(commands, "echo 'Step completed'")
+	return containerConfig, hostConfig, nil
+}
 
-		// Reset directory if changed
-		if step.WorkingDir != "" {
-			commands = append(commands, "cd /workspace")
+// checkNetwork validates the network mode of a job: a named network must
+// exist, and services can't reach a job on the host or none network
+func (r *DockerRunner) checkNetwork(ctx context.Context, mode string, services bool) error {
+	if err := CheckNetwork(mode); err != nil {
+		return err
+	}
+	if services && (mode == "host" || mode == "none") {
+		return fmt.Errorf("services can't reach a job on the %s network", mode)
+	}
+	if namedNetwork(mode) {
+		if _, err := r.client.NetworkInspect(ctx, mode, network.InspectOptions{}); err != nil {
+			return fmt.Errorf("network '%s' not found: %w", mode, err)
 		}
 	}
+	return nil
+}
 
-	commands = append(commands, "")
-	commands = append(commands, "echo ''")
-	commands = append(commands, "echo 'All steps completed successfully!'")
+// startServices starts the services of a job on the network its container
+// joins: the named --network one, or one created for the job
+func (r *DockerRunner) startServices(ctx context.Context, job *types.Job, jobEnv map[string]string) error {
+	if !namedNetwork(r.networkName) {
+		name := fmt.Sprintf("git-ci-%s-%d",
+			strings.ReplaceAll(strings.ToLower(job.Name), " ", "-"),
+			time.Now().Unix())
+		resp, err := r.client.NetworkCreate(ctx, name, network.CreateOptions{
+			Driver: "bridge",
+			Labels: ContainerLabels(r.config),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create the job network: %w", err)
+		}
+		r.networkName, r.networkID = name, resp.ID
+	}
 
-	return strings.Join(commands, "\n")
+	if r.services == nil {
+		r.services = &ServiceManager{client: r.client, config: r.config, formatter: r.formatter}
+	}
+	return r.services.StartOnNetwork(job.Name, job.Services, r.networkName, jobEnv)
 }
 
-func (r *DockerRunner) buildEnvironment(job *types.Job) []string {
-	env := []string{
-		"CI=true",
-		"GIT_CI=true",
-		"DOCKER_RUNNER=true",
-		fmt.Sprintf("JOB_NAME=%s", job.Name),
+// baseEnvironment returns the variables the runner sets in every container
+func (r *DockerRunner) baseEnvironment(job *types.Job) map[string]string {
+	env := map[string]string{
+		"CI":            "true",
+		"GIT_CI":        "true",
+		"DOCKER_RUNNER": "true",
+		"JOB_NAME":      job.Name,
+	}
+	for k, v := range gitlabJobVariables(job, r.config, ContainerWorkspace) {
+		env[k] = v
 	}
+	return env
+}
 
-	// Add job environment variables
-	for k, v := range job.Environment {
+// buildEnvironment returns the container environment: the runner
+// variables, then the expanded job variables (--env included)
+func (r *DockerRunner) buildEnvironment(job *types.Job, jobEnv map[string]string) []string {
+	var env []string
+	for k, v := range r.baseEnvironment(job) {
 		env = append(env, fmt.Sprintf("%s=%s", k, v))
 	}
 
-	// Add runner config environment variables
-	for k, v := range r.config.Environment {
+	// Add job environment variables
+	for k, v := range jobEnv {
 		env = append(env, fmt.Sprintf("%s=%s", k, v))
 	}
 
@@ -451,93 +872,32 @@ func (r *DockerRunner) buildEnvironment(job *types.Job) []string {
 	return env
 }
 
-func (r *DockerRunner) streamLogs(ctx context.Context, containerID string) error {
-	options := container.LogsOptions{
-		ShowStdout: true,
-		ShowStderr: true,
-		Follow:     true,
-		Timestamps: false,
-	}
-
-	reader, err := r.client.ContainerLogs(ctx, containerID, options)
-	if err != nil {
-		return fmt.Errorf("failed to get container logs: %w", err)
-	}
-	defer reader.Close()
-
-	// Use stdcopy to properly demultiplex stdout/stderr
-	_, err = stdcopy.StdCopy(os.Stdout, os.Stderr, reader)
-	if err != nil && err != io.EOF {
-		return fmt.Errorf("error streaming logs: %w", err)
-	}
-
-	return nil
-}
-
-func (r *DockerRunner) getContainerLogs(ctx context.Context, containerID string, tailLines int) (string, error) {
-	options := container.LogsOptions{
-		ShowStdout: true,
-		ShowStderr: true,
-		Tail:       fmt.Sprintf("%d", tailLines),
-	}
-
-	reader, err := r.client.ContainerLogs(ctx, containerID, options)
-	if err != nil {
-		return "", err
+// Cleanup removes the job containers, then the services and the network
+// created for the job
+func (r *DockerRunner) Cleanup() error {
+	err := r.removeContainers()
+	if r.actionsDir != "" {
+		os.RemoveAll(r.actionsDir)
+		r.actionsDir = ""
 	}
-	defer reader.Close()
-
-	var output strings.Builder
-	scanner := bufio.NewScanner(reader)
-	for scanner.Scan() {
-		output.WriteString(scanner.Text() + "\n")
+	if stopErr := r.services.Stop(); stopErr != nil && err == nil {
+		err = stopErr
 	}
 
-	return output.String(), nil
-}
-
-func (r *DockerRunner) dryRunJob(job *types.Job) error {
-	r.formatter.PrintSection("Would execute the following steps")
-
-	for i, step := range job.Steps {
-		fmt.Printf("\n[%d/%d] %s\n", i+1, len(job.Steps), step.Name)
-
-		if step.Uses != "" {
-			r.formatter.PrintKeyValue("Action", step.Uses, 2)
-			if len(step.With) > 0 {
-				r.formatter.PrintSubSection("  Parameters:")
-				for k, v := range step.With {
-					r.formatter.PrintKeyValue(k, v, 4)
-				}
-			}
-		}
-
-		if step.Run != "" {
-			r.formatter.PrintSubSection("  Command:")
-			lines := strings.Split(step.Run, "\n")
-			for _, line := range lines {
-				if strings.TrimSpace(line) != "" {
-					r.formatter.PrintOutput(line, 4)
-				}
+	if r.networkID != "" {
+		if netErr := r.client.NetworkRemove(context.Background(), r.networkID); netErr != nil {
+			r.formatter.PrintWarning(fmt.Sprintf("Failed to remove network %s", r.networkName))
+			if err == nil {
+				err = fmt.Errorf("failed to remove network %s: %w", r.networkName, netErr)
 			}
 		}
-
-		if len(step.Env) > 0 {
-			r.formatter.PrintSubSection("  Environment:")
-			for k, v := range step.Env {
-				r.formatter.PrintKeyValue(k, v, 4)
-			}
-		}
-
-		if step.WorkingDir != "" {
-			r.formatter.PrintKeyValue("Working Dir", step.WorkingDir, 2)
-		}
+		r.networkID = ""
 	}
-
-	return nil
+	return err
 }
 
-func (r *DockerRunner) Cleanup() error {
+// removeContainers removes the containers of the jobs run
+func (r *DockerRunner) removeContainers() error {
 	if len(r.containers) == 0 {
 		return nil
 	}
@@ -0,0 +1,211 @@
+package runners
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/sanix-darker/git-ci/internal/config"
+	"github.com/sanix-darker/git-ci/pkg/types"
+)
+
+// startTestContainer starts the container of job in image for the steps
+// of r, removed at the end of the test; it skips the test without a daemon
+func startTestContainer(t *testing.T, image string, job *types.Job) *DockerRunner {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("the Docker runner pulls images")
+	}
+
+	cfg := config.DefaultConfig()
+	cfg.Quiet = true
+	cfg.NoColor = true
+	r, err := NewDockerRunner(cfg)
+	if err != nil {
+		t.Skipf("no Docker daemon: %v", err)
+	}
+	t.Cleanup(func() { r.Cleanup() })
+
+	ctx := context.Background()
+	if !r.imageExists(ctx, image) {
+		if err := r.pullImage(ctx, image, nil); err != nil {
+			t.Skipf("cannot pull %s: %v", image, err)
+		}
+	}
+	id, err := r.createContainer(ctx, job, image, t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.containers = append(r.containers, id)
+	if err := r.client.ContainerStart(ctx, id, container.StartOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	r.container = id
+	return r
+}
+
+func TestDockerStepTimeoutKillsTheStep(t *testing.T) {
+	r := startTestContainer(t, "alpine:3", &types.Job{Name: "test"})
+
+	ctx, cancel := context.WithTimeoutCause(context.Background(), time.Second, &TimeoutError{Limit: time.Second})
+	defer cancel()
+	r.jobCtx = ctx
+
+	start := time.Now()
+	err := r.RunStep(&types.Step{Name: "sleep", Run: "sleep 60 & sleep 60"}, nil, "")
+	var timeoutErr *TimeoutError
+	if !errors.As(err, &timeoutErr) {
+		t.Fatalf("got %v, want a timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 20*time.Second {
+		t.Errorf("the step stopped %s after its 1s timeout", elapsed)
+	}
+
+	// Nothing of the step runs on next to the later steps
+	r.jobCtx = context.Background()
+	check := &types.Step{Name: "check", Run: "! grep -lx sleep /proc/[0-9]*/comm"}
+	if err := r.RunStep(check, nil, ""); err != nil {
+		t.Errorf("the timed out step is still running: %v", err)
+	}
+}
+
+// specRunner returns a Docker runner without client, enough to build the
+// configuration of job containers
+func specRunner(cfg *config.RunnerConfig) *DockerRunner {
+	return &DockerRunner{config: cfg, formatter: newFormatter(cfg)}
+}
+
+func TestDockerContainerSpecVolumes(t *testing.T) {
+	data, cache := t.TempDir(), t.TempDir()
+	cfg := config.DefaultConfig()
+	cfg.Volumes = []string{data + ":/data:ro", cache + ":/cache"}
+
+	workdir := t.TempDir()
+	_, host, err := specRunner(cfg).containerSpec(&types.Job{Name: "build"}, "alpine:3", workdir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []mount.Mount{
+		{Type: mount.TypeBind, Source: workdir, Target: ContainerWorkspace},
+		{Type: mount.TypeBind, Source: data, Target: "/data", ReadOnly: true},
+		{Type: mount.TypeBind, Source: cache, Target: "/cache"},
+	}
+	if len(host.Mounts) != len(want) {
+		t.Fatalf("mounts %+v, want %+v", host.Mounts, want)
+	}
+	for i := range want {
+		if host.Mounts[i] != want[i] {
+			t.Errorf("mount %d: %+v, want %+v", i, host.Mounts[i], want[i])
+		}
+	}
+}
+
+func TestParseVolume(t *testing.T) {
+	dir := t.TempDir()
+	missing := filepath.Join(dir, "missing")
+
+	valid := map[string]VolumeMount{
+		dir + ":/data":    {Source: dir, Target: "/data"},
+		dir + ":/data:ro": {Source: dir, Target: "/data", ReadOnly: true},
+		dir + ":/data:rw": {Source: dir, Target: "/data"},
+	}
+	for spec, want := range valid {
+		if got, err := ParseVolume(spec); err != nil || got != want {
+			t.Errorf("ParseVolume(%q) = %+v, %v, want %+v", spec, got, err, want)
+		}
+	}
+
+	invalid := map[string]string{
+		dir:                "expected src:dst[:ro]",
+		":/data":           "expected src:dst[:ro]",
+		dir + ":/a:ro:x":   "expected src:dst[:ro]",
+		dir + ":/data:rx":  "unknown mode 'rx'",
+		dir + ":data":      "the container path must be absolute",
+		missing + ":/data": "does not exist",
+	}
+	for spec, want := range invalid {
+		if _, err := ParseVolume(spec); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("ParseVolume(%q) error %v, want %q", spec, err, want)
+		}
+	}
+}
+
+func TestDockerContainerSpecNetwork(t *testing.T) {
+	tests := []struct {
+		name    string
+		network string // --network
+		job     *types.Container
+		want    container.NetworkMode
+	}{
+		{"default bridge", "", nil, ""},
+		{"--network", "host", nil, "host"},
+		{"job network", "", &types.Container{Network: "ci-net"}, "ci-net"},
+		{"job network mode", "", &types.Container{Network: "ci-net", NetworkMode: "none"}, "none"},
+		{"--network wins", "bridge", &types.Container{NetworkMode: "host"}, "bridge"},
+	}
+	for _, tt := range tests {
+		cfg := config.DefaultConfig()
+		cfg.Network = tt.network
+		job := &types.Job{Name: "build", Container: tt.job}
+
+		r := specRunner(cfg)
+		r.networkName = jobNetwork(cfg, job)
+		_, host, err := r.containerSpec(job, "alpine:3", t.TempDir(), nil)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if host.NetworkMode != tt.want {
+			t.Errorf("%s: network mode %q, want %q", tt.name, host.NetworkMode, tt.want)
+		}
+	}
+}
+
+func TestCheckNetwork(t *testing.T) {
+	for _, mode := range []string{"", "bridge", "host", "none", "ci-net", "project_default"} {
+		if err := CheckNetwork(mode); err != nil {
+			t.Errorf("CheckNetwork(%q): %v", mode, err)
+		}
+	}
+	for _, mode := range []string{"-net", "my net", "container:db"} {
+		if err := CheckNetwork(mode); err == nil {
+			t.Errorf("CheckNetwork(%q) accepted", mode)
+		}
+	}
+}
+
+func TestDockerContainerSpecUser(t *testing.T) {
+	job := &types.Job{Name: "build", Container: &types.Container{
+		Image:   "alpine:3",
+		User:    "1001",
+		CapAdd:  []string{"NET_ADMIN"},
+		Options: "--cpus 1.5 --privileged -v gomod:/go/pkg/mod",
+	}}
+	containerConfig, host, err := specRunner(config.DefaultConfig()).containerSpec(job, "alpine:3", t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if containerConfig.User != "1001" {
+		t.Errorf("user %q, want 1001", containerConfig.User)
+	}
+	if !host.Privileged || host.Resources.NanoCPUs != 1.5e9 || strings.Join(host.CapAdd, ",") != "NET_ADMIN" {
+		t.Errorf("privileged %v, CPUs %d, cap_add %v, want the options applied", host.Privileged, host.Resources.NanoCPUs, host.CapAdd)
+	}
+	if last := host.Mounts[len(host.Mounts)-1]; last.Target != "/go/pkg/mod" {
+		t.Errorf("last mount %+v, want the -v option", last)
+	}
+}
+
+func TestDockerContainerRunsAsUser(t *testing.T) {
+	r := startTestContainer(t, "alpine:3", &types.Job{Name: "test", Container: &types.Container{User: "1001"}})
+	r.jobCtx = context.Background()
+
+	if err := r.RunStep(&types.Step{Name: "id", Run: `test "$(id -u)" = 1001`}, nil, ""); err != nil {
+		t.Errorf("step not run as user 1001: %v", err)
+	}
+}
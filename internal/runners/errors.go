@@ -0,0 +1,83 @@
+package runners
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sanix-darker/git-ci/pkg/types"
+)
+
+// ExitError is the failure of a step command with a non-zero exit status
+type ExitError struct {
+	Code    int
+	Message string
+}
+
+func (e *ExitError) Error() string {
+	return e.Message
+}
+
+// AllowedFailureError is the failure of a job with one of the exit codes
+// its allow_failure lists: the job failed, but the pipeline doesn't
+type AllowedFailureError struct {
+	ExitCode int
+	Err      error
+}
+
+func (e *AllowedFailureError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *AllowedFailureError) Unwrap() error {
+	return e.Err
+}
+
+// TimeoutError is the failure of a step killed at its timeout-minutes, or
+// at the time limit of its job
+type TimeoutError struct {
+	Limit time.Duration
+	Job   bool
+}
+
+func (e *TimeoutError) Error() string {
+	scope := "step"
+	if e.Job {
+		scope = "job"
+	}
+	return fmt.Sprintf("%s timed out after %d minute(s)", scope, int(e.Limit.Minutes()))
+}
+
+// jobTimedOut reports whether a step was killed at the time limit of its job
+func jobTimedOut(err error) bool {
+	var timeoutErr *TimeoutError
+	return errors.As(err, &timeoutErr) && timeoutErr.Job
+}
+
+// ExitCode returns the exit status of a failed step or job, 0 when unknown
+func ExitCode(err error) int {
+	var exitErr *ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.Code
+	}
+	return 0
+}
+
+// Err returns the error of a failed job, nil when it succeeded
+func (s *JobSummary) Err(job *types.Job) error {
+	if s.Success {
+		return nil
+	}
+
+	err := errors.New(strings.Join(s.Errors, "; "))
+	if s.ExitCode != 0 {
+		err = &ExitError{Code: s.ExitCode, Message: err.Error()}
+	}
+	for _, code := range job.AllowExitCodes {
+		if s.ExitCode != 0 && s.ExitCode == code {
+			return &AllowedFailureError{ExitCode: code, Err: err}
+		}
+	}
+	return err
+}
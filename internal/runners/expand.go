@@ -0,0 +1,265 @@
+package runners
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/sanix-darker/git-ci/internal/config"
+	"github.com/sanix-darker/git-ci/pkg/types"
+)
+
+// githubEnvRef matches a ${{ env.NAME }} expression
+var githubEnvRef = regexp.MustCompile(`\$\{\{\s*env\.([A-Za-z_][A-Za-z0-9_]*)\s*\}\}`)
+
+// ExpandEnv resolves the references the values of env make to each other
+// and to base, in dependency order. GitLab values use $VAR and ${VAR}
+// ($$ is a literal $); GitHub values use ${{ env.VAR }}, a plain $VAR
+// being left for the shell. A variable referring to itself sees its base
+// value. Shell references to unknown variables are kept as written.
+func ExpandEnv(env, base map[string]string, provider string) (map[string]string, error) {
+	if len(env) == 0 {
+		return env, nil
+	}
+
+	e := &envExpander{
+		env:      env,
+		base:     base,
+		github:   provider == "github",
+		resolved: make(map[string]string, len(env)),
+	}
+
+	names := make([]string, 0, len(env))
+	for name := range env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if _, err := e.resolve(name); err != nil {
+			return nil, err
+		}
+	}
+	return e.resolved, nil
+}
+
+// ResolveEnv expands the variables of a job against base, then the env of
+// each step against base and the job variables. It returns the job
+// variables and the env of each step.
+func ResolveEnv(job *types.Job, cfg *config.RunnerConfig, base map[string]string) (map[string]string, []map[string]string, error) {
+	jobEnv, err := ExpandEnv(JobVariables(job, cfg), base, cfg.Provider)
+	if err != nil {
+		return nil, nil, fmt.Errorf("job variables: %w", err)
+	}
+
+	stepBase := make(map[string]string, len(base)+len(jobEnv))
+	for k, v := range base {
+		stepBase[k] = v
+	}
+	for k, v := range jobEnv {
+		stepBase[k] = v
+	}
+
+	stepEnvs := make([]map[string]string, len(job.Steps))
+	for i, step := range job.Steps {
+		stepEnvs[i], err = ExpandEnv(step.Env, stepBase, cfg.Provider)
+		if err != nil {
+			return nil, nil, fmt.Errorf("step '%s': %w", step.Name, err)
+		}
+	}
+
+	return jobEnv, stepEnvs, nil
+}
+
+// JobVariables returns the variables of a job, from lowest to highest
+// precedence: pipeline variables (but those it doesn't inherit), job
+// variables and --env
+func JobVariables(job *types.Job, cfg *config.RunnerConfig) map[string]string {
+	vars := make(map[string]string)
+	for k, v := range cfg.PipelineEnv {
+		vars[k] = v
+	}
+	for _, name := range job.ExcludedVariables {
+		delete(vars, name)
+	}
+	for _, env := range []map[string]string{job.Environment, cfg.Environment} {
+		for k, v := range env {
+			vars[k] = v
+		}
+	}
+	return vars
+}
+
+// envExpander resolves the variables of one env map
+type envExpander struct {
+	env      map[string]string
+	base     map[string]string
+	github   bool
+	resolved map[string]string
+	stack    []string // Variables being resolved, for cycle reports
+}
+
+// resolve returns the expanded value of the env variable name
+func (e *envExpander) resolve(name string) (string, error) {
+	if value, ok := e.resolved[name]; ok {
+		return value, nil
+	}
+
+	for i, pending := range e.stack {
+		if pending == name {
+			cycle := append(append([]string{}, e.stack[i:]...), name)
+			return "", fmt.Errorf("variable cycle: %s", strings.Join(cycle, " → "))
+		}
+	}
+
+	e.stack = append(e.stack, name)
+	defer func() { e.stack = e.stack[:len(e.stack)-1] }()
+
+	var value string
+	var err error
+	if e.github {
+		value, err = e.expandGithub(name, e.env[name])
+	} else {
+		value, err = e.expandShell(name, e.env[name])
+	}
+	if err != nil {
+		return "", err
+	}
+
+	e.resolved[name] = value
+	return value, nil
+}
+
+// lookup returns the value a reference to ref made from variable name
+// stands for
+func (e *envExpander) lookup(name, ref string) (string, bool, error) {
+	if ref != name {
+		if _, ok := e.env[ref]; ok {
+			value, err := e.resolve(ref)
+			return value, true, err
+		}
+	}
+	value, ok := e.base[ref]
+	return value, ok, nil
+}
+
+// expandGithub replaces the ${{ env.VAR }} expressions of value. Unknown
+// variables expand to an empty string, as on GitHub.
+func (e *envExpander) expandGithub(name, value string) (string, error) {
+	var err error
+	expanded := githubEnvRef.ReplaceAllStringFunc(value, func(match string) string {
+		if err != nil {
+			return match
+		}
+		ref := githubEnvRef.FindStringSubmatch(match)[1]
+		var resolved string
+		resolved, _, err = e.lookup(name, ref)
+		return resolved
+	})
+	return expanded, err
+}
+
+// expandShell replaces the $VAR and ${VAR} references of value
+func (e *envExpander) expandShell(name, value string) (string, error) {
+	return expandDollar(value, func(ref string) (string, bool, error) {
+		return e.lookup(name, ref)
+	})
+}
+
+// ExpandVariables expands the $VAR and ${VAR} references of value like
+// GitLab does before a job runs: $$ is a literal $ and unknown variables
+// expand to nothing. It also returns the unknown variables.
+func ExpandVariables(value string, vars map[string]string) (string, []string) {
+	var unknown []string
+	expanded, _ := expandDollar(value, func(ref string) (string, bool, error) {
+		resolved, ok := vars[ref]
+		if !ok {
+			unknown = append(unknown, ref)
+		}
+		return resolved, true, nil
+	})
+	return expanded, unknown
+}
+
+// expandDollar replaces the $VAR and ${VAR} references of value with
+// what lookup returns, keeping those it doesn't know as written
+func expandDollar(value string, lookup func(ref string) (string, bool, error)) (string, error) {
+	if !strings.Contains(value, "$") {
+		return value, nil
+	}
+
+	var out strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '$' || i+1 == len(value) {
+			out.WriteByte(value[i])
+			continue
+		}
+
+		// $$ escapes a dollar sign
+		if value[i+1] == '$' {
+			out.WriteByte('$')
+			i++
+			continue
+		}
+
+		var ref, raw string
+		if value[i+1] == '{' {
+			end := strings.IndexByte(value[i+2:], '}')
+			if end < 0 || !isVariableName(value[i+2:i+2+end]) {
+				out.WriteByte(value[i])
+				continue
+			}
+			ref = value[i+2 : i+2+end]
+			raw = value[i : i+3+end]
+		} else {
+			end := i + 1
+			for end < len(value) && isVariableChar(value[end], end == i+1) {
+				end++
+			}
+			if end == i+1 {
+				out.WriteByte(value[i])
+				continue
+			}
+			ref = value[i+1 : end]
+			raw = value[i:end]
+		}
+
+		resolved, ok, err := lookup(ref)
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			out.WriteString(resolved)
+		} else {
+			out.WriteString(raw)
+		}
+		i += len(raw) - 1
+	}
+	return out.String(), nil
+}
+
+// isVariableName reports whether s is a valid variable name
+func isVariableName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !isVariableChar(s[i], i == 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// isVariableChar reports whether c may appear in a variable name, at its
+// start when first is set
+func isVariableChar(c byte, first bool) bool {
+	switch {
+	case c == '_', c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z':
+		return true
+	case c >= '0' && c <= '9':
+		return !first
+	}
+	return false
+}
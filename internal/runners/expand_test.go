@@ -0,0 +1,119 @@
+package runners
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sanix-darker/git-ci/internal/config"
+	"github.com/sanix-darker/git-ci/pkg/types"
+)
+
+func TestExpandEnvChain(t *testing.T) {
+	tests := []struct {
+		provider string
+		env      map[string]string
+		want     map[string]string
+	}{
+		{
+			provider: "gitlab",
+			env: map[string]string{
+				"DIST":  "${OUT}/dist",
+				"OUT":   "$ROOT/out",
+				"ROOT":  "/builds/$PROJECT",
+				"PRICE": "$$5 $UNKNOWN",
+			},
+			want: map[string]string{
+				"DIST":  "/builds/app/out/dist",
+				"OUT":   "/builds/app/out",
+				"ROOT":  "/builds/app",
+				"PRICE": "$5 $UNKNOWN",
+			},
+		},
+		{
+			provider: "github",
+			env: map[string]string{
+				"DIST":  "${{ env.OUT }}/dist",
+				"OUT":   "${{env.ROOT}}/out",
+				"ROOT":  "/home/${{ env.PROJECT }}",
+				"SHELL": "$HOME/${{ env.MISSING }}x",
+			},
+			want: map[string]string{
+				"DIST":  "/home/app/out/dist",
+				"OUT":   "/home/app/out",
+				"ROOT":  "/home/app",
+				"SHELL": "$HOME/x",
+			},
+		},
+	}
+	base := map[string]string{"PROJECT": "app"}
+
+	for _, tt := range tests {
+		got, err := ExpandEnv(tt.env, base, tt.provider)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.provider, err)
+		}
+		for name, want := range tt.want {
+			if got[name] != want {
+				t.Errorf("%s: %s = %q, want %q", tt.provider, name, got[name], want)
+			}
+		}
+	}
+}
+
+func TestExpandEnvSelfReferenceSeesBase(t *testing.T) {
+	got, err := ExpandEnv(map[string]string{"PATH": "/opt/bin:$PATH"}, map[string]string{"PATH": "/usr/bin"}, "gitlab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got["PATH"] != "/opt/bin:/usr/bin" {
+		t.Errorf("PATH = %q, want /opt/bin:/usr/bin", got["PATH"])
+	}
+}
+
+func TestExpandEnvCycle(t *testing.T) {
+	env := map[string]string{
+		"A": "$B",
+		"B": "${C}-b",
+		"C": "c-$A",
+	}
+	_, err := ExpandEnv(env, nil, "gitlab")
+	if err == nil {
+		t.Fatal("expanded a variable cycle")
+	}
+	if want := "variable cycle: A → B → C → A"; err.Error() != want {
+		t.Errorf("error %q, want %q", err, want)
+	}
+}
+
+func TestResolveEnvStepSeesJobVariables(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Provider = "gitlab"
+	cfg.PipelineEnv = map[string]string{"REGISTRY": "registry.example.com"}
+
+	job := &types.Job{
+		Name:        "build",
+		Environment: map[string]string{"IMAGE": "$REGISTRY/app"},
+		Steps: []types.Step{
+			{Name: "tag", Env: map[string]string{"IMAGE_TAG": "$IMAGE:$CI_COMMIT_SHORT_SHA"}},
+			{Name: "loop", Env: map[string]string{"X": "$Y", "Y": "$X"}},
+		},
+	}
+	base := map[string]string{"CI_COMMIT_SHORT_SHA": "abc1234"}
+
+	_, _, err := ResolveEnv(job, cfg, base)
+	if err == nil || !strings.Contains(err.Error(), "step 'loop': variable cycle: X → Y → X") {
+		t.Errorf("error %v, want the cycle of step loop", err)
+	}
+
+	job.Steps = job.Steps[:1]
+	jobEnv, stepEnvs, err := ResolveEnv(job, cfg, base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jobEnv["IMAGE"] != "registry.example.com/app" {
+		t.Errorf("IMAGE = %q, want registry.example.com/app", jobEnv["IMAGE"])
+	}
+	if got := stepEnvs[0]["IMAGE_TAG"]; got != "registry.example.com/app:abc1234" {
+		t.Errorf("IMAGE_TAG = %q, want registry.example.com/app:abc1234", got)
+	}
+}
@@ -0,0 +1,123 @@
+package runners
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sanix-darker/git-ci/internal/config"
+	"github.com/sanix-darker/git-ci/pkg/types"
+)
+
+// lastJobID numbers the jobs of a run, giving them a CI_JOB_ID
+var lastJobID atomic.Int64
+
+// gitlabJobStart is the ID and start time of a job, the same each time
+// its variables are built
+type gitlabJobStart struct {
+	id        string
+	startedAt string
+}
+
+// gitlabJobStarts holds the gitlabJobStart of each job, by name
+var gitlabJobStarts sync.Map
+
+// gitlabJobVariables returns the predefined variables of a GitLab job, its
+// project directory being projectDir. They are only returned for GitLab
+// pipelines; pipeline variables and --env override them.
+func gitlabJobVariables(job *types.Job, cfg *config.RunnerConfig, projectDir string) map[string]string {
+	if cfg.Provider != "gitlab" {
+		return nil
+	}
+
+	start, ok := gitlabJobStarts.Load(job.Name)
+	if !ok {
+		start, _ = gitlabJobStarts.LoadOrStore(job.Name, &gitlabJobStart{
+			id:        strconv.FormatInt(lastJobID.Add(1), 10),
+			startedAt: time.Now().UTC().Format(time.RFC3339),
+		})
+	}
+	id := start.(*gitlabJobStart).id
+	vars := map[string]string{
+		"CI_JOB_NAME":       job.Name,
+		"CI_JOB_NAME_SLUG":  jobNameSlug(job.Name),
+		"CI_JOB_STAGE":      job.Stage,
+		"CI_JOB_ID":         id,
+		"CI_JOB_STARTED_AT": start.(*gitlabJobStart).startedAt,
+		"CI_PROJECT_DIR":    projectDir,
+		"CI_BUILDS_DIR":     projectDir,
+		"CI_RUNNER_ID":      "0",
+	}
+	if projectURL := cfg.PipelineEnv["CI_PROJECT_URL"]; projectURL != "" {
+		vars["CI_JOB_URL"] = projectURL + "/-/jobs/" + id
+	}
+	if image := job.Image; image != "" {
+		vars["CI_JOB_IMAGE"] = image
+	} else if job.Container != nil {
+		vars["CI_JOB_IMAGE"] = job.Container.Image
+	}
+	for name, token := range job.IDTokens {
+		vars[name] = localIDToken(job, cfg, token, id)
+	}
+	return vars
+}
+
+// localIDToken returns an unsigned JWT standing for an ID token: it has
+// the claims of a GitLab token, so scripts decoding it work, but no
+// service accepts it. A real token can be given with --env.
+func localIDToken(job *types.Job, cfg *config.RunnerConfig, token *types.IDToken, jobID string) string {
+	env := cfg.PipelineEnv
+	now := time.Now().Unix()
+
+	refType, ref := "branch", env["CI_COMMIT_BRANCH"]
+	if tag := env["CI_COMMIT_TAG"]; tag != "" {
+		refType, ref = "tag", tag
+	}
+
+	claims := map[string]interface{}{
+		"iss":             env["CI_SERVER_URL"],
+		"sub":             fmt.Sprintf("project_path:%s:ref_type:%s:ref:%s", env["CI_PROJECT_PATH"], refType, ref),
+		"project_path":    env["CI_PROJECT_PATH"],
+		"namespace_path":  env["CI_PROJECT_NAMESPACE"],
+		"ref":             ref,
+		"ref_type":        refType,
+		"pipeline_id":     env["CI_PIPELINE_ID"],
+		"pipeline_source": env["CI_PIPELINE_SOURCE"],
+		"job_id":          jobID,
+		"sha":             env["CI_COMMIT_SHA"],
+		"iat":             now,
+		"nbf":             now,
+		"exp":             now + 3600,
+	}
+	if len(token.Aud) == 1 {
+		claims["aud"] = token.Aud[0]
+	} else {
+		claims["aud"] = token.Aud
+	}
+
+	header, _ := json.Marshal(map[string]string{"alg": "none", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	return base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload) + "."
+}
+
+// jobNameSlug returns a job name as GitLab slugs it: lowercased, with
+// anything but 0-9 and a-z replaced by -, at most 63 bytes
+func jobNameSlug(name string) string {
+	slug := []byte(name)
+	for i, c := range slug {
+		switch {
+		case c >= 'A' && c <= 'Z':
+			slug[i] = c + 'a' - 'A'
+		case (c < 'a' || c > 'z') && (c < '0' || c > '9'):
+			slug[i] = '-'
+		}
+	}
+	if len(slug) > 63 {
+		slug = slug[:63]
+	}
+	return string(slug)
+}
@@ -0,0 +1,221 @@
+package runners
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Heartbeat prints a short status line when a step produces no output for
+// a while, so long silent steps don't look hung
+type Heartbeat struct {
+	formatter *OutputFormatter
+	stepName  string
+	interval  time.Duration
+	timeout   time.Duration // Effective timeout of the step (0 = none)
+
+	// now is the clock used by the heartbeat (replaceable for tests)
+	now func() time.Time
+
+	mu         sync.Mutex
+	start      time.Time
+	lastOutput time.Time
+	inPlace    bool // Update the same line instead of printing new ones (a job alone on a terminal)
+	printed    bool // A heartbeat line is currently displayed (in-place mode)
+	stop       chan struct{}
+	done       chan struct{}
+}
+
+// NewHeartbeat creates a heartbeat for a step. An interval <= 0 disables it.
+func (f *OutputFormatter) NewHeartbeat(stepName string, interval, timeout time.Duration) *Heartbeat {
+	return &Heartbeat{
+		formatter: f,
+		stepName:  stepName,
+		interval:  interval,
+		timeout:   timeout,
+		now:       time.Now,
+		inPlace:   IsTerminal(os.Stdout) && !f.SharedOutput,
+	}
+}
+
+// Start begins watching the step in the background
+func (h *Heartbeat) Start() {
+	if h == nil || h.interval <= 0 {
+		return
+	}
+
+	h.mu.Lock()
+	h.start = h.now()
+	h.lastOutput = h.start
+	h.stop = make(chan struct{})
+	h.done = make(chan struct{})
+	h.mu.Unlock()
+
+	// Check a few times per interval so the line is printed close to the deadline
+	tick := h.interval / 4
+	if tick < 100*time.Millisecond {
+		tick = 100 * time.Millisecond
+	}
+
+	go func() {
+		defer close(h.done)
+		ticker := time.NewTicker(tick)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-h.stop:
+				return
+			case <-ticker.C:
+				h.Check()
+			}
+		}
+	}()
+}
+
+// Touch records that the step just produced output
+func (h *Heartbeat) Touch() {
+	if h == nil || h.interval <= 0 {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.lastOutput = h.now()
+	h.clearLocked()
+}
+
+// Check prints a heartbeat line if the step has been silent for at least
+// one interval. It is called periodically by Start, and can be called
+// directly with a fake clock.
+func (h *Heartbeat) Check() {
+	if h == nil || h.interval <= 0 {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := h.now()
+	if now.Sub(h.lastOutput) < h.interval {
+		return
+	}
+
+	line := h.format(now)
+	if h.inPlace {
+		h.formatter.PrintHeartbeat(line, true)
+		h.printed = true
+		return
+	}
+
+	h.formatter.PrintHeartbeat(line, false)
+	// Print at most one line per interval of silence
+	h.lastOutput = now
+}
+
+// Stop stops the heartbeat and clears any in-place line
+func (h *Heartbeat) Stop() {
+	if h == nil || h.stop == nil {
+		return
+	}
+
+	close(h.stop)
+	<-h.done
+
+	h.mu.Lock()
+	h.clearLocked()
+	h.stop = nil
+	h.mu.Unlock()
+}
+
+// format builds the heartbeat message
+func (h *Heartbeat) format(now time.Time) string {
+	elapsed := now.Sub(h.start)
+	msg := fmt.Sprintf("… %s still running (%s elapsed",
+		h.stepName, h.formatter.FormatDuration(elapsed))
+
+	if h.timeout > 0 {
+		remaining := h.timeout - elapsed
+		if remaining < 0 {
+			remaining = 0
+		}
+		msg += fmt.Sprintf(", %s until timeout", h.formatter.FormatDuration(remaining))
+	}
+
+	return msg + ")"
+}
+
+// clearLocked erases the in-place heartbeat line (h.mu must be held)
+func (h *Heartbeat) clearLocked() {
+	if h.printed {
+		h.formatter.ClearLine()
+		h.printed = false
+	}
+}
+
+// heartbeatWriter forwards writes through the formatter, keeping a copy
+// in capture, and marks the heartbeat as alive. When the formatter has
+// secrets to mask, output is forwarded line by line so a secret split
+// across writes is masked too; Flush writes the last unterminated line.
+type heartbeatWriter struct {
+	w         io.Writer
+	hb        *Heartbeat
+	capture   *outputCapture
+	formatter *OutputFormatter
+	pending   []byte
+}
+
+// newHeartbeatWriter returns a writer forwarding to w with the secrets of
+// formatter masked
+func newHeartbeatWriter(w io.Writer, hb *Heartbeat, capture *outputCapture, formatter *OutputFormatter) *heartbeatWriter {
+	return &heartbeatWriter{w: w, hb: hb, capture: capture, formatter: formatter}
+}
+
+func (w *heartbeatWriter) Write(p []byte) (int, error) {
+	w.hb.Touch()
+	if w.formatter == nil || !w.formatter.HasSecrets() {
+		_, _ = w.capture.Write(p)
+		return w.formatter.WriteOutput(w.w, p)
+	}
+
+	w.pending = append(w.pending, p...)
+	end := bytes.LastIndexByte(w.pending, '\n')
+	if end < 0 {
+		return len(p), nil
+	}
+	if err := w.forward(w.pending[:end+1]); err != nil {
+		return 0, err
+	}
+	w.pending = append(w.pending[:0], w.pending[end+1:]...)
+	return len(p), nil
+}
+
+// Flush writes the output held back waiting for the end of its line
+func (w *heartbeatWriter) Flush() error {
+	if len(w.pending) == 0 {
+		return nil
+	}
+	err := w.forward(w.pending)
+	w.pending = nil
+	return err
+}
+
+// forward writes complete lines, secrets masked
+func (w *heartbeatWriter) forward(lines []byte) error {
+	masked := []byte(w.formatter.Mask(string(lines)))
+	_, _ = w.capture.Write(masked)
+	_, err := w.formatter.WriteOutput(w.w, masked)
+	return err
+}
+
+// IsTerminal reports whether the file is attached to a terminal
+func IsTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,135 @@
+package runners
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// captureStdout returns what fn prints to stdout
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	done := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		done <- string(data)
+	}()
+
+	fn()
+	w.Close()
+	return <-done
+}
+
+// fakeClock is a clock tests move forward by hand
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time          { return c.t }
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+// newTestHeartbeat returns a heartbeat started on clock, printing plain
+// lines
+func newTestHeartbeat(clock *fakeClock, interval, timeout time.Duration) *Heartbeat {
+	f := NewOutputFormatter(false)
+	f.UseColor = false
+	hb := f.NewHeartbeat("build", interval, timeout)
+	hb.now = clock.now
+	hb.inPlace = false
+	hb.start = clock.now()
+	hb.lastOutput = hb.start
+	return hb
+}
+
+func TestHeartbeatReportsSilentSteps(t *testing.T) {
+	clock := &fakeClock{t: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+	hb := newTestHeartbeat(clock, 30*time.Second, 10*time.Minute)
+
+	steps := []struct {
+		name    string
+		advance time.Duration
+		touch   bool
+		want    string // "" when no line is expected
+	}{
+		{"before the interval", 29 * time.Second, false, ""},
+		{"silent for an interval", 1 * time.Second, false, "build still running (30.0s elapsed, 9m 30s until timeout)"},
+		{"once per interval", 10 * time.Second, false, ""},
+		{"next interval", 20 * time.Second, false, "build still running (1m 0s elapsed, 9m 0s until timeout)"},
+		{"output resets the interval", 20 * time.Second, true, ""},
+		{"silent again", 30 * time.Second, false, "build still running (1m 50s elapsed, 8m 10s until timeout)"},
+	}
+
+	for _, step := range steps {
+		clock.advance(step.advance)
+		if step.touch {
+			hb.Touch()
+		}
+		out := captureStdout(t, hb.Check)
+
+		if step.want == "" {
+			if out != "" {
+				t.Errorf("%s: unexpected heartbeat %q", step.name, out)
+			}
+			continue
+		}
+		if !strings.Contains(out, step.want) {
+			t.Errorf("%s: got %q, want %q", step.name, out, step.want)
+		}
+	}
+}
+
+func TestHeartbeatWithoutTimeout(t *testing.T) {
+	clock := &fakeClock{t: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+	hb := newTestHeartbeat(clock, time.Minute, 0)
+
+	clock.advance(90 * time.Second)
+	out := captureStdout(t, hb.Check)
+	if !strings.Contains(out, "build still running (1m 30s elapsed)") {
+		t.Errorf("got %q", out)
+	}
+}
+
+func TestHeartbeatNotInPlaceWhenJobsShareOutput(t *testing.T) {
+	f := NewOutputFormatter(false)
+	f.SharedOutput = true
+	if hb := f.NewHeartbeat("build", time.Second, 0); hb.inPlace {
+		t.Error("a heartbeat of jobs running side by side is redrawn in place")
+	}
+}
+
+func TestHeartbeatWriterWaitsForTheOutputLock(t *testing.T) {
+	for _, secrets := range [][]string{nil, {"s3cr3t-value"}} {
+		f := NewOutputFormatter(false)
+		f.MaskSecrets(secrets...)
+		var out strings.Builder
+		w := newHeartbeatWriter(&out, nil, nil, f)
+
+		// A line of another job is being printed
+		outMu.Lock()
+		written := make(chan struct{})
+		go func() {
+			w.Write([]byte("step output\n"))
+			close(written)
+		}()
+
+		select {
+		case <-written:
+			t.Fatalf("secrets %v: the output was written while another job printed", secrets)
+		case <-time.After(50 * time.Millisecond):
+		}
+		outMu.Unlock()
+		<-written
+		if out.String() != "step output\n" {
+			t.Errorf("secrets %v: wrote %q", secrets, out.String())
+		}
+	}
+}
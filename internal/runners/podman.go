@@ -0,0 +1,537 @@
+package runners
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	units "github.com/docker/go-units"
+	"github.com/sanix-darker/git-ci/internal/config"
+	"github.com/sanix-darker/git-ci/pkg/types"
+)
+
+// PodmanRunner runs jobs in containers through the podman CLI
+type PodmanRunner struct {
+	podman     string // Path of the podman binary
+	config     *config.RunnerConfig
+	containers []string
+	formatter  *OutputFormatter
+	mu         sync.Mutex
+
+	// Image of the last job and its registry digest
+	image       string
+	imageDigest string
+
+	// Container of the running job, where its steps are executed
+	container string
+
+	coverageTracker
+	stepTracker
+	runContexts
+}
+
+// NewPodmanRunner creates a new Podman runner
+func NewPodmanRunner(cfg *config.RunnerConfig) (*PodmanRunner, error) {
+	if cfg == nil {
+		cfg = config.DefaultConfig()
+	}
+
+	podman, err := exec.LookPath("podman")
+	if err != nil {
+		return nil, fmt.Errorf("podman is not installed or not in PATH. See https://podman.io/docs/installation")
+	}
+
+	formatter := newFormatter(cfg)
+
+	// Verify Podman is usable, and show its version in verbose mode
+	out, err := exec.Command(podman, "version", "--format", "{{.Client.Version}}").Output()
+	if err != nil {
+		return nil, fmt.Errorf("podman is not usable: %w", podmanError(err))
+	}
+	if cfg.Verbose {
+		formatter.PrintDebug(fmt.Sprintf("Podman version: %s", strings.TrimSpace(string(out))))
+	}
+
+	return &PodmanRunner{
+		podman:     podman,
+		config:     cfg,
+		containers: []string{},
+		formatter:  formatter,
+	}, nil
+}
+
+func (r *PodmanRunner) RunJob(job *types.Job, workdir string) error {
+	startTime := time.Now()
+
+	imageName := JobImage(job)
+	if pinned, ok := r.config.PinImages[job.Name]; ok {
+		imageName = pinned
+	}
+	r.image = imageName
+
+	// Print job header
+	r.formatter.PrintHeader(job.Name, workdir, fmt.Sprintf("podman (%s)", imageName))
+
+	// Expand variables that refer to others
+	jobEnv, stepEnvs, err := ResolveEnv(job, r.config, r.baseEnvironment(job))
+	if err != nil {
+		return err
+	}
+
+	// Show dry run mode if enabled
+	if r.config.DryRun {
+		r.formatter.PrintDryRun()
+		printStepsDryRun(r.formatter, job, stepEnvs)
+		return nil
+	}
+
+	// Initialize job summary
+	r.beginCoverage(job)
+
+	summary := &JobSummary{
+		JobName:    job.Name,
+		TotalSteps: len(job.Steps),
+		Success:    true,
+	}
+
+	// Pull image if needed (a disabled image cache forces a fresh pull)
+	if r.config.PullImages || !r.imageExists(imageName) || !r.config.CacheEnabled(config.CacheKindImage) {
+		progress := r.formatter.NewProgress(fmt.Sprintf("Pulling image %s", imageName))
+		if err := r.pullImage(imageName); err != nil {
+			progress.Complete(false)
+			return err
+		}
+		progress.Complete(true)
+	}
+
+	// Local lookup, the image is there by now
+	r.imageDigest = r.getImageDigest(imageName)
+
+	if len(job.Services) > 0 {
+		r.formatter.PrintWarning("Services are not supported by the Podman runner, skipping them")
+	}
+
+	// Create and run container
+	r.formatter.PrintInfo("Creating container")
+	containerID, err := r.createContainer(job, imageName, workdir, jobEnv)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.containers = append(r.containers, containerID)
+	r.mu.Unlock()
+
+	// Start container
+	r.formatter.PrintInfo("Starting container")
+	if _, err := r.run("start", containerID); err != nil {
+		return fmt.Errorf("failed to start container: %w", err)
+	}
+
+	r.container = containerID
+
+	// Run each step in the container
+	gate := newStepGate(jobEnv, r.config, r.formatter, &r.runContexts)
+	for i, step := range job.Steps {
+		stepNum := i + 1
+		stepStart := time.Now()
+
+		// Check for timeout
+		if r.config.Timeout > 0 && time.Since(startTime).Minutes() > float64(r.config.Timeout) {
+			summary.Success = false
+			summary.Errors = append(summary.Errors, fmt.Sprintf("Job timeout exceeded (%d minutes)", r.config.Timeout))
+			break
+		}
+
+		if !gate.allows(&step, stepNum, len(job.Steps), summary) {
+			continue
+		}
+		gate.interpolate(&step, jobEnv)
+
+		r.formatter.PrintStepHeader(step.Name, stepNum, len(job.Steps))
+
+		if step.Uses != "" {
+			r.formatter.PrintStepSkipped("actions are not supported in the Podman runner")
+			summary.SkippedSteps++
+			summary.stepSkipped(step.Name, "actions are not supported in the Podman runner")
+			continue
+		}
+
+		err := r.RunStep(&step, gate.expandValues(stepEnvs[i]), workdir)
+		stepDuration := time.Since(stepStart)
+		summary.stepFinished(step.Name, stepStart, err)
+		gate.finished(&step, nil, err)
+
+		if err != nil {
+			summary.FailedSteps++
+			if step.ContinueOnErr {
+				r.formatter.PrintWarning(fmt.Sprintf("Step failed but continuing: %v", err))
+				r.formatter.PrintStepComplete(stepDuration)
+				continue
+			}
+			r.formatter.PrintStepFailed(err, stepDuration)
+			summary.Success = false
+			summary.Errors = append(summary.Errors, fmt.Sprintf("Step '%s' failed: %v", step.Name, err))
+			summary.ExitCode = ExitCode(err)
+			gate.fail()
+			continue
+		}
+
+		summary.CompletedSteps++
+		r.formatter.PrintStepComplete(stepDuration)
+	}
+
+	r.finishCoverage(job, r.formatter)
+	r.finishSteps(summary)
+
+	// Print job summary
+	summary.Duration = time.Since(startTime)
+	if r.config.Verbose {
+		r.formatter.PrintJobSummary(summary)
+	} else {
+		r.formatter.PrintJobComplete(job.Name, summary.Duration, summary.Success)
+	}
+
+	return summary.Err(job)
+}
+
+// RunStep runs a step in the job container started by RunJob. env is the
+// step env; the job variables are already set on the container.
+// step.WorkingDir is relative to the workspace.
+func (r *PodmanRunner) RunStep(step *types.Step, env map[string]string, workdir string) error {
+	if step.Run == "" {
+		return nil
+	}
+	if r.container == "" {
+		return fmt.Errorf("no running container for step '%s'", step.Name)
+	}
+
+	if r.config.Verbose {
+		r.formatter.PrintCommand(step.Run, 2)
+	}
+
+	inv := newStepInvocation(step, ContainerWorkspace, env)
+	args := []string{"exec", "--workdir", inv.dir}
+	for _, variable := range inv.envList() {
+		args = append(args, "--env", variable)
+	}
+	args = append(args, r.container)
+	args = append(args, inv.command...)
+
+	// Report progress while the step stays silent
+	hb := r.formatter.NewHeartbeat(step.Name, r.config.HeartbeatInterval(), r.effectiveTimeout(step))
+	hb.Start()
+	defer hb.Stop()
+
+	attempts := 1
+	if step.RetryPolicy != nil && step.RetryPolicy.MaxAttempts > 1 {
+		attempts = step.RetryPolicy.MaxAttempts
+	}
+
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if attempt > 1 {
+			r.formatter.PrintWarning(fmt.Sprintf("Attempt %d failed: %v", attempt-1, err))
+			r.formatter.PrintInfo(fmt.Sprintf("Retry attempt %d/%d", attempt, attempts))
+			if duration, parseErr := time.ParseDuration(step.RetryPolicy.Delay); parseErr == nil {
+				time.Sleep(duration)
+			}
+		}
+
+		if err = r.execStep(step, args, hb); err == nil {
+			return nil
+		}
+	}
+
+	if attempts > 1 {
+		return fmt.Errorf("all %d attempts failed, last error: %w", attempts, err)
+	}
+	return err
+}
+
+// execStep runs a step once in the job container and returns an error
+// for a non-zero exit code or a step timeout
+func (r *PodmanRunner) execStep(step *types.Step, args []string, hb *Heartbeat) error {
+	ctx := context.Background()
+	if step.TimeoutMin > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.config.Minutes(step.TimeoutMin))
+		defer cancel()
+	}
+
+	cmd := exec.CommandContext(ctx, r.podman, args...)
+	stdout := newHeartbeatWriter(os.Stdout, hb, r.output, r.formatter)
+	stderr := newHeartbeatWriter(os.Stderr, hb, r.output, r.formatter)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	err := cmd.Run()
+	_ = stdout.Flush()
+	_ = stderr.Flush()
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("step timed out after %d minute(s)", step.TimeoutMin)
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return &ExitError{Code: exitErr.ExitCode(), Message: fmt.Sprintf("command exited with status %d", exitErr.ExitCode())}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to run podman exec: %w", err)
+	}
+
+	return nil
+}
+
+// effectiveTimeout returns the timeout that applies to a step
+func (r *PodmanRunner) effectiveTimeout(step *types.Step) time.Duration {
+	if step.TimeoutMin > 0 {
+		return r.config.Minutes(step.TimeoutMin)
+	}
+	return r.config.Minutes(r.config.Timeout)
+}
+
+// run runs a podman command and returns its trimmed output
+func (r *PodmanRunner) run(args ...string) (string, error) {
+	out, err := exec.Command(r.podman, args...).Output()
+	if err != nil {
+		return "", podmanError(err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// podmanError adds what podman printed on stderr to the error of a command
+func podmanError(err error) error {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+		return fmt.Errorf("%s", strings.TrimSpace(string(exitErr.Stderr)))
+	}
+	return err
+}
+
+func (r *PodmanRunner) imageExists(imageName string) bool {
+	return exec.Command(r.podman, "image", "exists", imageName).Run() == nil
+}
+
+// getImageDigest returns the registry digest (sha256:...) of a local
+// image, or "" for images that never came from a registry
+func (r *PodmanRunner) getImageDigest(imageName string) string {
+	digest, err := r.run("image", "inspect", "--format", "{{.Digest}}", imageName)
+	if err != nil || !strings.HasPrefix(digest, "sha256:") {
+		return ""
+	}
+	return digest
+}
+
+// ResolvedImage returns the image of the last job and its digest
+func (r *PodmanRunner) ResolvedImage() (string, string) {
+	return r.image, r.imageDigest
+}
+
+func (r *PodmanRunner) pullImage(imageName string) error {
+	cmd := exec.Command(r.podman, "pull", imageName)
+
+	// Show pull progress if verbose
+	var stderr bytes.Buffer
+	if r.config.Verbose {
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+	} else {
+		cmd.Stderr = &stderr
+	}
+
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("failed to pull image %s: %s", imageName, msg)
+		}
+		return fmt.Errorf("failed to pull image %s: %w", imageName, err)
+	}
+
+	return nil
+}
+
+func (r *PodmanRunner) createContainer(job *types.Job, imageName, workdir string, jobEnv map[string]string) (string, error) {
+	containerName := fmt.Sprintf("git-ci-%s-%d",
+		invalidNameChars.ReplaceAllString(strings.ToLower(job.Name), "-"),
+		time.Now().Unix())
+
+	// Local memory hint overrides the default limit
+	memory := int64(2 * 1024 * 1024 * 1024) // 2GB
+	if job.Local != nil && job.Local.Memory != "" {
+		var err error
+		memory, err = units.RAMInBytes(job.Local.Memory)
+		if err != nil {
+			return "", fmt.Errorf("invalid memory hint '%s': %w", job.Local.Memory, err)
+		}
+	}
+
+	args := []string{
+		"create",
+		"--name", containerName,
+		"--volume", workdir + ":/workspace",
+		"--workdir", ContainerWorkspace,
+		"--memory", strconv.FormatInt(memory, 10),
+		"--memory-swap", strconv.FormatInt(memory, 10),
+		"--cpu-shares", "1024",
+	}
+
+	for k, v := range ContainerLabels(r.config) {
+		args = append(args, "--label", fmt.Sprintf("%s=%s", k, v))
+	}
+	// Bind mounts of --volume, next to the workspace
+	for _, m := range configVolumes(r.config) {
+		spec := m.Source + ":" + m.Target
+		if m.ReadOnly {
+			spec += ":ro"
+		}
+		args = append(args, "--volume", spec)
+	}
+	for _, env := range r.buildEnvironment(job, jobEnv) {
+		args = append(args, "--env", env)
+	}
+
+	if job.Container != nil {
+		// Entrypoint override of the job image ([""] clears the image's one)
+		if job.Container.Entrypoint != nil {
+			args = append(args, "--entrypoint", entrypointArg(job.Container.Entrypoint))
+		}
+
+		// Add additional volumes if specified
+		for _, vol := range job.Container.Volumes {
+			if strings.Contains(vol, ":") {
+				args = append(args, "--volume", vol)
+			}
+		}
+
+		// Publish the job container ports on the host
+		for _, spec := range job.Container.Ports {
+			if _, err := types.ParsePortMapping(spec); err != nil {
+				return "", fmt.Errorf("invalid container ports: %w", err)
+			}
+			args = append(args, "--publish", spec)
+		}
+	}
+
+	// Join the network of the compose stack so its services resolve, or
+	// the --network mode
+	if mode := jobNetwork(r.config, job); mode != "" {
+		if err := CheckNetwork(mode); err != nil {
+			return "", err
+		}
+		args = append(args, "--network", mode)
+	}
+
+	args = append(args, imageName)
+	args = append(args, keepAliveCommand...)
+
+	id, err := r.run(args...)
+	if err != nil {
+		return "", fmt.Errorf("failed to create container: %w", err)
+	}
+
+	r.formatter.PrintDebug(fmt.Sprintf("Container created: %s", shortContainerID(id)))
+	return id, nil
+}
+
+// entrypointArg returns the --entrypoint value of an entrypoint, a JSON
+// array podman reads as the exact command
+func entrypointArg(entrypoint []string) string {
+	quoted := make([]string, len(entrypoint))
+	for i, arg := range entrypoint {
+		quoted[i] = strconv.Quote(arg)
+	}
+	return "[" + strings.Join(quoted, ",") + "]"
+}
+
+// shortContainerID returns the short form of a container ID
+func shortContainerID(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
+
+// baseEnvironment returns the variables the runner sets in every container
+func (r *PodmanRunner) baseEnvironment(job *types.Job) map[string]string {
+	env := map[string]string{
+		"CI":            "true",
+		"GIT_CI":        "true",
+		"PODMAN_RUNNER": "true",
+		"JOB_NAME":      job.Name,
+	}
+	for k, v := range gitlabJobVariables(job, r.config, ContainerWorkspace) {
+		env[k] = v
+	}
+	return env
+}
+
+// buildEnvironment returns the container environment: the runner
+// variables, then the expanded job variables (--env included)
+func (r *PodmanRunner) buildEnvironment(job *types.Job, jobEnv map[string]string) []string {
+	var env []string
+	for k, v := range r.baseEnvironment(job) {
+		env = append(env, fmt.Sprintf("%s=%s", k, v))
+	}
+
+	// Add job environment variables
+	for k, v := range jobEnv {
+		env = append(env, fmt.Sprintf("%s=%s", k, v))
+	}
+
+	// Add container-specific environment variables
+	if job.Container != nil {
+		for k, v := range job.Container.Env {
+			env = append(env, fmt.Sprintf("%s=%s", k, v))
+		}
+	}
+
+	return env
+}
+
+func (r *PodmanRunner) Cleanup() error {
+	if len(r.containers) == 0 {
+		return nil
+	}
+
+	r.formatter.PrintSection("Cleaning up containers")
+
+	r.mu.Lock()
+	containersToRemove := make([]string, len(r.containers))
+	copy(containersToRemove, r.containers)
+	r.mu.Unlock()
+
+	var errors []string
+	for _, containerID := range containersToRemove {
+		shortID := shortContainerID(containerID)
+
+		// Remove container, stopping it first
+		if _, err := r.run("rm", "--force", "--volumes", containerID); err != nil {
+			errors = append(errors, fmt.Sprintf("Failed to remove %s: %v", shortID, err))
+			r.formatter.PrintWarning(fmt.Sprintf("Failed to remove container %s", shortID))
+		} else {
+			r.formatter.PrintInfo(fmt.Sprintf("Removed container %s", shortID))
+		}
+	}
+
+	// Clear the container list
+	r.mu.Lock()
+	r.containers = []string{}
+	r.mu.Unlock()
+
+	if len(errors) > 0 {
+		return fmt.Errorf("cleanup completed with %d errors", len(errors))
+	}
+
+	return nil
+}
+
+// GetRunnerType returns the type of this runner
+func (r *PodmanRunner) GetRunnerType() types.RunnerType {
+	return types.RunnerTypePodman
+}
@@ -0,0 +1,27 @@
+//go:build !windows
+
+package runners
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// startProcessGroup makes a command the leader of its own process group,
+// so that killProcessGroup reaches the processes it starts
+func startProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+}
+
+// killProcessGroup kills a started command and the processes it started
+func killProcessGroup(cmd *exec.Cmd) {
+	if cmd.Process == nil {
+		return
+	}
+	if err := syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL); err != nil {
+		_ = cmd.Process.Kill()
+	}
+}
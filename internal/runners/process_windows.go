@@ -0,0 +1,16 @@
+//go:build windows
+
+package runners
+
+import "os/exec"
+
+// startProcessGroup is a no-op on Windows
+func startProcessGroup(cmd *exec.Cmd) {}
+
+// killProcessGroup kills a started command; the processes it started are
+// not tracked on Windows
+func killProcessGroup(cmd *exec.Cmd) {
+	if cmd.Process != nil {
+		_ = cmd.Process.Kill()
+	}
+}
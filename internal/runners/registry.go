@@ -0,0 +1,83 @@
+package runners
+
+import (
+	"strings"
+
+	"github.com/docker/docker/api/types/registry"
+	"github.com/sanix-darker/git-ci/internal/config"
+	"github.com/sanix-darker/git-ci/pkg/types"
+)
+
+// dockerHub is the registry of images without a registry host
+const dockerHub = "docker.io"
+
+// imageRegistry returns the registry host of an image reference: its
+// first component when it looks like a host (has a dot or a port, or is
+// localhost), else Docker Hub
+func imageRegistry(imageName string) string {
+	host, _, ok := strings.Cut(imageName, "/")
+	if !ok || (!strings.ContainsAny(host, ".:") && host != "localhost") {
+		return dockerHub
+	}
+	return normalizeRegistry(host)
+}
+
+// normalizeRegistry returns the host of a registry address, the aliases
+// of Docker Hub being docker.io
+func normalizeRegistry(address string) string {
+	address = strings.TrimPrefix(strings.TrimPrefix(address, "https://"), "http://")
+	address, _, _ = strings.Cut(address, "/")
+	switch address {
+	case "index.docker.io", "registry-1.docker.io", "registry.hub.docker.com":
+		return dockerHub
+	}
+	return strings.ToLower(address)
+}
+
+// registryAuth returns the credentials pulling an image: those of the job
+// container (auth, then credentials), then the docker.auth ones of the
+// configuration file. Credentials for another registry don't match; nil
+// pulls anonymously.
+func registryAuth(imageName string, c *types.Container, cfg *config.RunnerConfig) *registry.AuthConfig {
+	host := imageRegistry(imageName)
+	matches := func(address string) bool {
+		return address == "" || normalizeRegistry(address) == host
+	}
+
+	if c != nil && c.Auth != nil && matches(c.Auth.ServerAddress) {
+		return &registry.AuthConfig{
+			Username:      c.Auth.Username,
+			Password:      c.Auth.Password,
+			ServerAddress: host,
+			IdentityToken: c.Auth.IdentityToken,
+			RegistryToken: c.Auth.RegistryToken,
+		}
+	}
+	if c != nil && c.Credentials["username"] != "" {
+		return &registry.AuthConfig{
+			Username:      c.Credentials["username"],
+			Password:      c.Credentials["password"],
+			ServerAddress: host,
+		}
+	}
+	if cfg != nil && len(cfg.RegistryAuth) > 0 && matches(cfg.Registry) {
+		return &registry.AuthConfig{
+			Username:      cfg.RegistryAuth["username"],
+			Password:      cfg.RegistryAuth["password"],
+			ServerAddress: host,
+			IdentityToken: cfg.RegistryAuth["identity_token"],
+			RegistryToken: cfg.RegistryAuth["registry_token"],
+		}
+	}
+	return nil
+}
+
+// encodedRegistryAuth returns the RegistryAuth of a pull of an image, ""
+// for an anonymous pull
+func encodedRegistryAuth(imageName string, c *types.Container, cfg *config.RunnerConfig) (string, error) {
+	auth := registryAuth(imageName, c, cfg)
+	if auth == nil {
+		return "", nil
+	}
+	return registry.EncodeAuthConfig(*auth)
+}
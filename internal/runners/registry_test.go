@@ -0,0 +1,124 @@
+package runners
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types/registry"
+	"github.com/docker/docker/client"
+	"github.com/sanix-darker/git-ci/internal/config"
+	"github.com/sanix-darker/git-ci/pkg/types"
+)
+
+// pullAuth pulls imageName from a fake Docker daemon and returns the
+// credentials the pull sent, nil for an anonymous pull
+func pullAuth(t *testing.T, imageName string, c *types.Container, cfg *config.RunnerConfig) *registry.AuthConfig {
+	t.Helper()
+
+	var header string
+	pulled := false
+	daemon := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if strings.HasSuffix(req.URL.Path, "/images/create") {
+			pulled = true
+			header = req.Header.Get(registry.AuthHeader)
+		}
+		w.Write([]byte("{}\n"))
+	}))
+	defer daemon.Close()
+
+	docker, err := client.NewClientWithOpts(client.WithHost("tcp://"+daemon.Listener.Addr().String()), client.WithVersion("1.43"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer docker.Close()
+
+	r := &DockerRunner{client: docker, config: cfg, formatter: newFormatter(cfg)}
+	if err := r.pullImage(context.Background(), imageName, c); err != nil {
+		t.Fatal(err)
+	}
+	if !pulled {
+		t.Fatal("image not pulled")
+	}
+	if header == "" {
+		return nil
+	}
+	auth, err := registry.DecodeAuthConfig(header)
+	if err != nil {
+		t.Fatalf("invalid %s header %q: %v", registry.AuthHeader, header, err)
+	}
+	return auth
+}
+
+func TestPullImageRegistryAuth(t *testing.T) {
+	withConfigAuth := func(address string) *config.RunnerConfig {
+		cfg := config.DefaultConfig()
+		cfg.Registry = address
+		cfg.RegistryAuth = map[string]string{"username": "ci", "password": "from-config"}
+		return cfg
+	}
+
+	tests := []struct {
+		name      string
+		image     string
+		container *types.Container
+		cfg       *config.RunnerConfig
+		want      *registry.AuthConfig // nil: anonymous
+	}{
+		{
+			name:  "container auth",
+			image: "registry.example.com/team/app:1",
+			container: &types.Container{Auth: &types.ContainerAuth{
+				Username: "deploy", Password: "s3cret", ServerAddress: "https://registry.example.com",
+			}},
+			cfg:  config.DefaultConfig(),
+			want: &registry.AuthConfig{Username: "deploy", Password: "s3cret", ServerAddress: "registry.example.com"},
+		},
+		{
+			name:      "container credentials",
+			image:     "ghcr.io/org/tool",
+			container: &types.Container{Credentials: map[string]string{"username": "bot", "password": "token"}},
+			cfg:       withConfigAuth(""),
+			want:      &registry.AuthConfig{Username: "bot", Password: "token", ServerAddress: "ghcr.io"},
+		},
+		{
+			name:  "container auth of another registry",
+			image: "ghcr.io/org/tool",
+			container: &types.Container{Auth: &types.ContainerAuth{
+				Username: "deploy", Password: "s3cret", ServerAddress: "registry.example.com",
+			}},
+			cfg:  withConfigAuth("ghcr.io"),
+			want: &registry.AuthConfig{Username: "ci", Password: "from-config", ServerAddress: "ghcr.io"},
+		},
+		{
+			name:  "configuration of Docker Hub",
+			image: "library/alpine:3",
+			cfg:   withConfigAuth("https://index.docker.io/v1/"),
+			want:  &registry.AuthConfig{Username: "ci", Password: "from-config", ServerAddress: "docker.io"},
+		},
+		{
+			name:  "configuration of another registry",
+			image: "alpine:3",
+			cfg:   withConfigAuth("registry.example.com"),
+		},
+		{
+			name:  "no credentials",
+			image: "localhost:5000/app",
+			cfg:   config.DefaultConfig(),
+		},
+	}
+
+	for _, tt := range tests {
+		got := pullAuth(t, tt.image, tt.container, tt.cfg)
+		switch {
+		case tt.want == nil && got != nil:
+			t.Errorf("%s: pulled with %+v, want anonymously", tt.name, got)
+		case tt.want != nil && got == nil:
+			t.Errorf("%s: pulled anonymously, want %+v", tt.name, tt.want)
+		case tt.want != nil && *got != *tt.want:
+			t.Errorf("%s: pulled with %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package runners
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/sanix-darker/git-ci/internal/config"
+	"github.com/sanix-darker/git-ci/pkg/types"
+)
+
+// githubSecretRef matches a ${{ secrets.NAME }} expression
+var githubSecretRef = regexp.MustCompile(`\$\{\{\s*secrets\.([A-Za-z_][A-Za-z0-9_]*)\s*\}\}`)
+
+// stepInvocation is how a step runs in a container, on a remote host or
+// in a job script: its command, its directory and its env
+type stepInvocation struct {
+	command []string
+	dir     string
+	env     map[string]string
+}
+
+// newStepInvocation returns how a step runs below workspace with env
+func newStepInvocation(step *types.Step, workspace string, env map[string]string) *stepInvocation {
+	return &stepInvocation{
+		command: stepCommand(step),
+		dir:     path.Join(workspace, step.WorkingDir),
+		env:     env,
+	}
+}
+
+// envList returns the env as KEY=VALUE pairs sorted by name
+func (s *stepInvocation) envList() []string {
+	list := make([]string, 0, len(s.env))
+	for _, name := range sortedNames(s.env) {
+		list = append(list, name+"="+s.env[name])
+	}
+	return list
+}
+
+// shellLine returns the invocation as one shell command line, run from
+// dir (a shell word, already quoted). assign renders each variable as a
+// NAME=VALUE word for env(1).
+func (s *stepInvocation) shellLine(dir string, assign func(name, value string) string) string {
+	var line strings.Builder
+	fmt.Fprintf(&line, "cd %s && exec env", dir)
+	for _, name := range sortedNames(s.env) {
+		fmt.Fprintf(&line, " %s", assign(name, s.env[name]))
+	}
+	for _, arg := range s.command {
+		fmt.Fprintf(&line, " %s", shellQuote(arg))
+	}
+	return line.String()
+}
+
+// JobScript renders a job as a standalone POSIX shell script, run from
+// the workspace, executing each step like the container runners do.
+// Secrets (sensitive variable names, ${{ secrets.X }}) are read from the
+// environment of the script, never written in it.
+func JobScript(job *types.Job, cfg *config.RunnerConfig) (string, error) {
+	base := map[string]string{
+		"CI":       "true",
+		"GIT_CI":   "true",
+		"JOB_NAME": job.Name,
+	}
+	jobEnv, stepEnvs, err := ResolveEnv(job, cfg, base)
+	if err != nil {
+		return "", err
+	}
+
+	// The script sets what a container runner sets on its container
+	env := make(map[string]string, len(base)+len(jobEnv))
+	for _, vars := range []map[string]string{base, jobEnv} {
+		for k, v := range vars {
+			env[k] = v
+		}
+	}
+	if job.Container != nil {
+		for k, v := range job.Container.Env {
+			env[k] = v
+		}
+	}
+
+	return renderJobScript(job, env, stepEnvs), nil
+}
+
+// renderJobScript writes the script of a job from its resolved variables.
+// The steps stop at the first failure; the after_script steps then run
+// whatever happened, and the script exits with the status of the steps.
+func renderJobScript(job *types.Job, jobEnv map[string]string, stepEnvs []map[string]string) string {
+	var script strings.Builder
+	indent := ""
+	w := func(format string, args ...interface{}) {
+		line := fmt.Sprintf(format, args...)
+		if line != "" {
+			line = indent + line
+		}
+		script.WriteString(line + "\n")
+	}
+
+	// Where the after_script steps start, when other steps precede them
+	afterScript := -1
+	for i, step := range job.Steps {
+		if i > 0 && step.Phase == types.StepPhaseAfterScript {
+			afterScript = i
+			break
+		}
+	}
+
+	w("#!/bin/sh")
+	w("# Job '%s', generated by git-ci. Run it from the workspace.", job.Name)
+	w("set -e")
+	w("# pipefail is not POSIX, use it where the shell has it")
+	w("if (set -o pipefail) 2>/dev/null; then set -o pipefail; fi")
+	w("")
+	w("GIT_CI_WORKSPACE=$(pwd)")
+
+	if len(jobEnv) > 0 {
+		w("")
+		w("# Job variables")
+		for _, name := range sortedNames(jobEnv) {
+			w("export %s", scriptAssignment(name, jobEnv[name]))
+		}
+	}
+
+	for i, step := range job.Steps {
+		if afterScript >= 0 && i == 0 {
+			w("")
+			w("# The steps run in a subshell stopping at the first failure,")
+			w("# the after_script steps whatever its outcome")
+			w("set +e")
+			w("(")
+			indent = "  "
+			w("set -e")
+		}
+		if i == afterScript {
+			indent = ""
+			w(")")
+			w("GIT_CI_STATUS=$?")
+			w("set -e")
+		}
+
+		w("")
+		label := fmt.Sprintf("Step %d/%d: %s", i+1, len(job.Steps), step.Name)
+		if step.ContinueOnErr {
+			label += " (failure ignored)"
+		}
+		w("# %s", strings.ReplaceAll(label, "\n", " "))
+
+		switch {
+		case step.Uses != "":
+			w("# %s: actions are not run by job scripts", step.Uses)
+			continue
+		case step.Run == "":
+			continue
+		}
+
+		step := step
+		inv := newStepInvocation(&step, "", stepEnvs[i])
+		dir := `"$GIT_CI_WORKSPACE"`
+		if inv.dir != "" && inv.dir != "." {
+			dir += "/" + shellQuote(inv.dir)
+		}
+		line := "(" + inv.shellLine(dir, scriptAssignment) + ")"
+		if step.ContinueOnErr {
+			line += fmt.Sprintf(` || echo "git-ci: step %d failed, continuing" >&2`, i+1)
+		}
+		w("%s", line)
+	}
+
+	if afterScript >= 0 {
+		w("")
+		w("exit $GIT_CI_STATUS")
+	}
+
+	return script.String()
+}
+
+// scriptAssignment renders a variable as a NAME=VALUE shell word. Secrets
+// become references to the environment of the script.
+func scriptAssignment(name, value string) string {
+	if githubSecretRef.MatchString(value) {
+		var word strings.Builder
+		last := 0
+		for _, match := range githubSecretRef.FindAllStringSubmatchIndex(value, -1) {
+			if match[0] > last {
+				word.WriteString(shellQuote(value[last:match[0]]))
+			}
+			fmt.Fprintf(&word, `"${%s}"`, value[match[2]:match[3]])
+			last = match[1]
+		}
+		if last < len(value) {
+			word.WriteString(shellQuote(value[last:]))
+		}
+		return name + "=" + word.String()
+	}
+
+	if IsSensitive(name) {
+		return fmt.Sprintf(`%s="${%s:?%s is a secret, set it before running this script}"`, name, name, name)
+	}
+	return name + "=" + shellQuote(value)
+}
+
+// GithubSecretRefs returns the names of the ${{ secrets.NAME }}
+// expressions of value
+func GithubSecretRefs(value string) []string {
+	var names []string
+	for _, match := range githubSecretRef.FindAllStringSubmatch(value, -1) {
+		names = append(names, match[1])
+	}
+	return names
+}
+
+// IsSensitive reports whether a variable name looks like it holds a secret
+func IsSensitive(key string) bool {
+	// TODO:
+	// not optimizal for now... will find a better way later on
+	sensitive := []string{
+		"PASSWORD", "SECRET", "TOKEN", "KEY", "CREDENTIAL",
+		"PRIVATE", "AUTH", "API_KEY", "ACCESS", "CERT",
+	}
+
+	upperKey := strings.ToUpper(key)
+	for _, s := range sensitive {
+		if strings.Contains(upperKey, s) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// shellQuote quotes s for a POSIX shell
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func sortedNames(env map[string]string) []string {
+	names := make([]string, 0, len(env))
+	for name := range env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
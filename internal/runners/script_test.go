@@ -0,0 +1,88 @@
+package runners
+
+import (
+	"flag"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sanix-darker/git-ci/internal/config"
+	"github.com/sanix-darker/git-ci/pkg/types"
+)
+
+// update rewrites the golden files of the tests
+var update = flag.Bool("update", false, "rewrite the golden files")
+
+// scriptJob is a job with every kind of step a job script renders
+func scriptJob() *types.Job {
+	return &types.Job{
+		Name: "build",
+		Environment: map[string]string{
+			"GREETING":  "it's me",
+			"API_TOKEN": "${{ secrets.API_TOKEN }}",
+		},
+		Steps: []types.Step{
+			{Name: "Setup", Run: "echo setup >> ran", Phase: types.StepPhaseBeforeScript},
+			{Name: "Checkout", Uses: "actions/checkout@v4"},
+			{Name: "Build", Run: "make build", WorkingDir: "src dir", Env: map[string]string{"MODE": "release"}},
+			{Name: "Lint", Run: "make lint", ContinueOnErr: true},
+			{Name: "After Script", Run: "echo after >> ran", ContinueOnErr: true, Phase: types.StepPhaseAfterScript},
+		},
+	}
+}
+
+func TestJobScriptGolden(t *testing.T) {
+	script, err := JobScript(scriptJob(), config.DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	golden := filepath.Join("testdata", "build.sh.golden")
+	if *update {
+		if err := os.WriteFile(golden, []byte(script), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("no golden script (go test ./internal/runners -run TestJobScriptGolden -update writes it): %v", err)
+	}
+	if script != string(want) {
+		t.Errorf("job script changed (-update rewrites it):\n%s", script)
+	}
+}
+
+func TestJobScriptRunsAfterScriptAfterFailure(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no sh")
+	}
+
+	job := &types.Job{
+		Name: "test",
+		Steps: []types.Step{
+			{Name: "Fail", Run: "echo main >> ran; exit 3"},
+			{Name: "Never", Run: "echo never >> ran"},
+			{Name: "After Script", Run: "echo after >> ran", ContinueOnErr: true, Phase: types.StepPhaseAfterScript},
+		},
+	}
+	script, err := JobScript(job, config.DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	cmd := exec.Command("sh", "-c", script)
+	cmd.Dir = dir
+	err = cmd.Run()
+	exitErr, ok := err.(*exec.ExitError)
+	if !ok || exitErr.ExitCode() != 3 {
+		t.Errorf("script exited with %v, want the status 3 of the failed step", err)
+	}
+
+	ran, _ := os.ReadFile(filepath.Join(dir, "ran"))
+	if got := strings.Fields(string(ran)); strings.Join(got, ",") != "main,after" {
+		t.Errorf("steps run = %v, want [main after]", got)
+	}
+}
@@ -0,0 +1,385 @@
+package runners
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/client"
+	"github.com/docker/go-connections/nat"
+	"github.com/sanix-darker/git-ci/internal/config"
+	"github.com/sanix-darker/git-ci/pkg/types"
+)
+
+// serviceReadyTimeout bounds the wait for a service to be ready, as the
+// wait_for_services_timeout of GitLab runners
+const serviceReadyTimeout = 30 * time.Second
+
+// ServiceManager runs the service containers of a job. The services of a
+// native job are reached, as on a GitHub hosted runner, through localhost
+// on the host ports published from their `ports` mappings; those of a
+// container job share its network and are reached by their host names.
+type ServiceManager struct {
+	client     *client.Client
+	config     *config.RunnerConfig
+	formatter  *OutputFormatter
+	containers []string
+	mu         sync.Mutex
+}
+
+// NewServiceManager creates a service manager backed by the Docker daemon
+func NewServiceManager(cfg *config.RunnerConfig, formatter *OutputFormatter) (*ServiceManager, error) {
+	cli, err := client.NewClientWithOpts(
+		client.FromEnv,
+		client.WithAPIVersionNegotiation(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Docker client: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := cli.Ping(ctx); err != nil {
+		return nil, fmt.Errorf("services need a Docker daemon: %w", err)
+	}
+
+	return &ServiceManager{
+		client:    cli,
+		config:    cfg,
+		formatter: formatter,
+	}, nil
+}
+
+// Start launches the services and returns the environment exposing their
+// bound host ports (<SERVICE>_PORT_<container port>=<host port>)
+func (m *ServiceManager) Start(jobName string, services map[string]*types.Service) (map[string]string, error) {
+	return m.start(jobName, services, "", nil)
+}
+
+// StartOnNetwork launches the services on the network of a job container,
+// where they resolve by their host names. As on GitLab, they get the job
+// variables besides their own.
+func (m *ServiceManager) StartOnNetwork(jobName string, services map[string]*types.Service, networkName string, jobEnv map[string]string) error {
+	_, err := m.start(jobName, services, networkName, jobEnv)
+	return err
+}
+
+// start launches the services, on a network when networkName is set, and
+// waits for them to be ready
+func (m *ServiceManager) start(jobName string, services map[string]*types.Service, networkName string, jobEnv map[string]string) (map[string]string, error) {
+	ctx := context.Background()
+	env := make(map[string]string)
+
+	names := make([]string, 0, len(services))
+	for name := range services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	m.formatter.PrintSection("Services")
+
+	for _, name := range names {
+		svc := services[name]
+
+		exposed, bindings, err := portBindings(svc.Ports)
+		if err != nil {
+			return nil, fmt.Errorf("service '%s': %w", name, err)
+		}
+
+		if err := m.ensureImage(ctx, svc.Image, svc.PullPolicy); err != nil {
+			return nil, fmt.Errorf("service '%s': %w", name, err)
+		}
+
+		var serviceEnv []string
+		for k, v := range jobEnv {
+			if _, own := svc.Env[k]; !own {
+				serviceEnv = append(serviceEnv, fmt.Sprintf("%s=%s", k, v))
+			}
+		}
+		for k, v := range svc.Env {
+			serviceEnv = append(serviceEnv, fmt.Sprintf("%s=%s", k, v))
+		}
+
+		hostConfig := &container.HostConfig{PortBindings: bindings}
+		var networking *network.NetworkingConfig
+		hosts := serviceHostnames(name, svc)
+		if networkName != "" {
+			hostConfig.NetworkMode = container.NetworkMode(networkName)
+			networking = &network.NetworkingConfig{
+				EndpointsConfig: map[string]*network.EndpointSettings{
+					networkName: {Aliases: hosts},
+				},
+			}
+		}
+
+		containerName := fmt.Sprintf("git-ci-%s-%s-%d",
+			strings.ReplaceAll(strings.ToLower(jobName), " ", "-"), name, time.Now().Unix())
+
+		resp, err := m.client.ContainerCreate(ctx,
+			&container.Config{
+				Image:        svc.Image,
+				Env:          serviceEnv,
+				Cmd:          svc.Command,
+				Entrypoint:   svc.Entrypoint,
+				ExposedPorts: exposed,
+				Labels:       ContainerLabels(m.config),
+			},
+			hostConfig, networking, nil, containerName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create service '%s': %w", name, err)
+		}
+
+		m.mu.Lock()
+		m.containers = append(m.containers, resp.ID)
+		m.mu.Unlock()
+
+		if err := m.client.ContainerStart(ctx, resp.ID, container.StartOptions{}); err != nil {
+			return nil, fmt.Errorf("failed to start service '%s': %w", name, err)
+		}
+
+		// Read back the ports actually bound, random ones included
+		info, err := m.waitReady(ctx, name, resp.ID, networkName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to inspect service '%s': %w", name, err)
+		}
+
+		m.formatter.PrintKeyValueWithLevel(name, svc.Image, IndentStep)
+		if networkName != "" {
+			m.formatter.PrintKeyValueWithLevel("Hosts", strings.Join(hosts, ", "), IndentDetail)
+		}
+		for port := range exposed {
+			bound := info.NetworkSettings.Ports[port]
+			if len(bound) == 0 {
+				continue
+			}
+			key := serviceEnvName(name, port.Port())
+			env[key] = bound[0].HostPort
+			m.formatter.PrintKeyValueWithLevel(key, fmt.Sprintf("localhost:%s -> %s", bound[0].HostPort, port), IndentDetail)
+		}
+	}
+
+	return env, nil
+}
+
+// Stop removes the service containers
+func (m *ServiceManager) Stop() error {
+	if m == nil {
+		return nil
+	}
+
+	m.mu.Lock()
+	containers := m.containers
+	m.containers = nil
+	m.mu.Unlock()
+
+	ctx := context.Background()
+	var failed int
+	for _, id := range containers {
+		_ = m.client.ContainerStop(ctx, id, container.StopOptions{})
+		if err := m.client.ContainerRemove(ctx, id, container.RemoveOptions{Force: true, RemoveVolumes: true}); err != nil {
+			failed++
+			m.formatter.PrintWarning(fmt.Sprintf("Failed to remove service container %s", id[:12]))
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("failed to remove %d service container(s)", failed)
+	}
+	return nil
+}
+
+// waitReady waits until a service is healthy or, when its image has no
+// health check, accepts connections on its exposed TCP ports. As on
+// GitLab, a service still not ready after serviceReadyTimeout is reported
+// and the job runs anyway. The last inspection of the service is returned.
+func (m *ServiceManager) waitReady(ctx context.Context, name, id, networkName string) (container.InspectResponse, error) {
+	deadline := time.Now().Add(serviceReadyTimeout)
+	for {
+		info, err := m.client.ContainerInspect(ctx, id)
+		if err != nil {
+			return info, err
+		}
+		if info.State != nil && !info.State.Running {
+			m.formatter.PrintWarning(fmt.Sprintf("Service '%s' exited with status %d", name, info.State.ExitCode))
+			return info, nil
+		}
+		if serviceReady(info, networkName) {
+			return info, nil
+		}
+		if time.Now().After(deadline) {
+			m.formatter.PrintWarning(fmt.Sprintf("Service '%s' not ready after %s, running the job anyway", name, serviceReadyTimeout))
+			return info, nil
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+// serviceReady reports whether a service container is healthy, or accepts
+// TCP connections on its exposed ports. Container addresses are only
+// reachable from the host on Linux; elsewhere only health checks count.
+func serviceReady(info container.InspectResponse, networkName string) bool {
+	if info.State != nil && info.State.Health != nil {
+		return info.State.Health.Status == container.Healthy
+	}
+	if info.Config == nil || info.NetworkSettings == nil {
+		return true
+	}
+
+	for port := range info.Config.ExposedPorts {
+		if port.Proto() != "tcp" {
+			continue
+		}
+
+		var addr string
+		if networkName != "" {
+			endpoint := info.NetworkSettings.Networks[networkName]
+			if endpoint == nil || endpoint.IPAddress == "" || runtime.GOOS != "linux" {
+				continue
+			}
+			addr = net.JoinHostPort(endpoint.IPAddress, port.Port())
+		} else if bound := info.NetworkSettings.Ports[port]; len(bound) > 0 {
+			addr = net.JoinHostPort("127.0.0.1", bound[0].HostPort)
+		} else {
+			continue
+		}
+
+		conn, err := net.DialTimeout("tcp", addr, time.Second)
+		if err != nil {
+			return false
+		}
+		conn.Close()
+	}
+	return true
+}
+
+// serviceHostnames returns the host names of a service on a job network:
+// its name, its aliases (GitLab allows several, comma or space separated)
+// and, as on GitLab, the names derived from its image (postgres:15 is
+// postgres, tutum/wordpress is tutum-wordpress and tutum__wordpress)
+func serviceHostnames(name string, svc *types.Service) []string {
+	hosts := []string{name}
+	hosts = append(hosts, strings.FieldsFunc(svc.Alias, func(r rune) bool {
+		return r == ',' || r == ' '
+	})...)
+
+	if repository := types.ImageRepository(svc.Image); repository != "" {
+		hosts = append(hosts,
+			strings.ReplaceAll(repository, "/", "-"),
+			strings.ReplaceAll(repository, "/", "__"))
+	}
+
+	seen := make(map[string]bool)
+	var unique []string
+	for _, host := range hosts {
+		if host != "" && !seen[host] {
+			seen[host] = true
+			unique = append(unique, host)
+		}
+	}
+	return unique
+}
+
+// ensureImage makes the service image available following its pull
+// policies, tried in order as on GitLab; without any, the image is pulled
+// when it is missing or caching is off
+func (m *ServiceManager) ensureImage(ctx context.Context, imageName string, policies []string) error {
+	_, inspectErr := m.client.ImageInspect(ctx, imageName)
+	present := inspectErr == nil
+
+	if len(policies) == 0 {
+		if present && !m.config.PullImages && m.config.CacheEnabled(config.CacheKindImage) {
+			return nil
+		}
+		return m.pullImage(ctx, imageName)
+	}
+
+	var err error
+	for _, policy := range policies {
+		switch policy {
+		case "always":
+			err = m.pullImage(ctx, imageName)
+		case "if-not-present":
+			if present {
+				return nil
+			}
+			err = m.pullImage(ctx, imageName)
+		case "never":
+			if present {
+				return nil
+			}
+			err = fmt.Errorf("image %s is not present and pull_policy is never", imageName)
+		default:
+			err = fmt.Errorf("unknown pull_policy '%s'", policy)
+		}
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// pullImage pulls a service image, with the registry credentials of the
+// configuration file when they match its registry
+func (m *ServiceManager) pullImage(ctx context.Context, imageName string) error {
+	auth, err := encodedRegistryAuth(imageName, nil, m.config)
+	if err != nil {
+		return fmt.Errorf("invalid registry credentials: %w", err)
+	}
+
+	reader, err := m.client.ImagePull(ctx, imageName, image.PullOptions{RegistryAuth: auth})
+	if err != nil {
+		return fmt.Errorf("failed to pull image %s: %w", imageName, err)
+	}
+	defer reader.Close()
+
+	_, _ = io.Copy(io.Discard, reader)
+	return nil
+}
+
+// portBindings converts `ports` entries to Docker port bindings
+func portBindings(ports []string) (nat.PortSet, nat.PortMap, error) {
+	exposed := nat.PortSet{}
+	bindings := nat.PortMap{}
+
+	for _, spec := range ports {
+		mapping, err := types.ParsePortMapping(spec)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		port, err := nat.NewPort(mapping.Protocol, mapping.ContainerPort)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid port mapping '%s': %w", spec, err)
+		}
+
+		exposed[port] = struct{}{}
+		bindings[port] = append(bindings[port], nat.PortBinding{
+			HostIP:   mapping.HostIP,
+			HostPort: mapping.HostPort, // Empty lets Docker pick a free port
+		})
+	}
+
+	return exposed, bindings, nil
+}
+
+// serviceEnvName returns the variable holding a service's host port
+func serviceEnvName(service, port string) string {
+	name := strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, service)
+
+	return fmt.Sprintf("%s_PORT_%s", strings.ToUpper(name), port)
+}
@@ -0,0 +1,518 @@
+package runners
+
+import (
+	"archive/tar"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sanix-darker/git-ci/internal/config"
+	"github.com/sanix-darker/git-ci/pkg/types"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// SSHRunner runs jobs on a remote host: the workdir is copied to a
+// temporary directory there and each step runs in it
+type SSHRunner struct {
+	client    *ssh.Client
+	config    *config.RunnerConfig
+	formatter *OutputFormatter
+	target    string // user@host:port
+
+	// Remote copy of the workdir of the running job
+	remoteDir string
+
+	coverageTracker
+	stepTracker
+	runContexts
+}
+
+// NewSSHRunner creates a new SSH runner connected to cfg.SSHHost
+func NewSSHRunner(cfg *config.RunnerConfig) (*SSHRunner, error) {
+	if cfg == nil {
+		cfg = config.DefaultConfig()
+	}
+	if cfg.SSHHost == "" {
+		return nil, fmt.Errorf("no SSH host given. Use --ssh [user@]host[:port]")
+	}
+
+	user, addr := parseSSHTarget(cfg.SSHHost)
+
+	auth, err := sshAuthMethods(cfg.SSHKey)
+	if err != nil {
+		return nil, err
+	}
+
+	hostKeyCallback := ssh.InsecureIgnoreHostKey()
+	if !cfg.SSHInsecure {
+		home, _ := os.UserHomeDir()
+		hostKeyCallback, err = knownhosts.New(filepath.Join(home, ".ssh", "known_hosts"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read known_hosts (use --ssh-insecure to skip the check): %w", err)
+		}
+	}
+
+	client, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            user,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         10 * time.Second,
+	})
+	if err != nil {
+		var keyErr *knownhosts.KeyError
+		if errors.As(err, &keyErr) {
+			if len(keyErr.Want) == 0 {
+				return nil, fmt.Errorf("host %s is not in known_hosts. Connect once with ssh or use --ssh-insecure", addr)
+			}
+			return nil, fmt.Errorf("host key of %s does not match known_hosts", addr)
+		}
+		return nil, fmt.Errorf("failed to connect to %s: %w", addr, err)
+	}
+
+	return &SSHRunner{
+		client:    client,
+		config:    cfg,
+		formatter: newFormatter(cfg),
+		target:    fmt.Sprintf("%s@%s", user, addr),
+	}, nil
+}
+
+// parseSSHTarget splits [user@]host[:port] into a user, defaulting to the
+// local one, and a dial address, defaulting to port 22
+func parseSSHTarget(target string) (string, string) {
+	user := os.Getenv("USER")
+	if at := strings.LastIndex(target, "@"); at >= 0 {
+		user, target = target[:at], target[at+1:]
+	}
+	if _, _, err := net.SplitHostPort(target); err != nil {
+		target = net.JoinHostPort(strings.Trim(target, "[]"), "22")
+	}
+	return user, target
+}
+
+// sshAuthMethods returns the ways to authenticate: the given key, or the
+// SSH agent and the default keys of the user
+func sshAuthMethods(keyFile string) ([]ssh.AuthMethod, error) {
+	if keyFile != "" {
+		signer, err := loadSSHKey(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		return []ssh.AuthMethod{ssh.PublicKeys(signer)}, nil
+	}
+
+	var methods []ssh.AuthMethod
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		if conn, err := net.Dial("unix", sock); err == nil {
+			methods = append(methods, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+		}
+	}
+
+	home, _ := os.UserHomeDir()
+	var signers []ssh.Signer
+	for _, name := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+		if signer, err := loadSSHKey(filepath.Join(home, ".ssh", name)); err == nil {
+			signers = append(signers, signer)
+		}
+	}
+	if len(signers) > 0 {
+		methods = append(methods, ssh.PublicKeys(signers...))
+	}
+
+	if len(methods) == 0 {
+		return nil, fmt.Errorf("no SSH agent or key found. Use --ssh-key to give one")
+	}
+	return methods, nil
+}
+
+// loadSSHKey reads an unencrypted private key
+func loadSSHKey(keyFile string) (ssh.Signer, error) {
+	data, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read SSH key: %w", err)
+	}
+	signer, err := ssh.ParsePrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse SSH key %s (encrypted keys must be loaded in the SSH agent): %w", keyFile, err)
+	}
+	return signer, nil
+}
+
+func (r *SSHRunner) RunJob(job *types.Job, workdir string) error {
+	startTime := time.Now()
+
+	// Print job header
+	r.formatter.PrintHeader(job.Name, workdir, fmt.Sprintf("ssh (%s)", r.target))
+
+	// Expand variables that refer to others
+	jobEnv, stepEnvs, err := ResolveEnv(job, r.config, r.baseEnvironment(job))
+	if err != nil {
+		return err
+	}
+
+	// Show dry run mode if enabled
+	if r.config.DryRun {
+		r.formatter.PrintDryRun()
+		printStepsDryRun(r.formatter, job, stepEnvs)
+		return nil
+	}
+
+	// Initialize job summary
+	r.beginCoverage(job)
+
+	summary := &JobSummary{
+		JobName:    job.Name,
+		TotalSteps: len(job.Steps),
+		Success:    true,
+	}
+
+	if len(job.Services) > 0 {
+		r.formatter.PrintWarning("Services are not supported by the SSH runner, skipping them")
+	}
+
+	// Copy the workdir to the remote host
+	progress := r.formatter.NewProgress(fmt.Sprintf("Copying workdir to %s", r.target))
+	if err := r.upload(workdir); err != nil {
+		progress.Complete(false)
+		return err
+	}
+	progress.Complete(true)
+	r.formatter.PrintDebug(fmt.Sprintf("Remote workdir: %s", r.remoteDir))
+
+	// Run each step on the remote host
+	gate := newStepGate(jobEnv, r.config, r.formatter, &r.runContexts)
+	for i, step := range job.Steps {
+		stepNum := i + 1
+		stepStart := time.Now()
+
+		// Check for timeout
+		if r.config.Timeout > 0 && time.Since(startTime).Minutes() > float64(r.config.Timeout) {
+			summary.Success = false
+			summary.Errors = append(summary.Errors, fmt.Sprintf("Job timeout exceeded (%d minutes)", r.config.Timeout))
+			break
+		}
+
+		if !gate.allows(&step, stepNum, len(job.Steps), summary) {
+			continue
+		}
+		gate.interpolate(&step, jobEnv)
+
+		r.formatter.PrintStepHeader(step.Name, stepNum, len(job.Steps))
+
+		if step.Uses != "" {
+			r.formatter.PrintStepSkipped("actions are not supported in the SSH runner")
+			summary.SkippedSteps++
+			summary.stepSkipped(step.Name, "actions are not supported in the SSH runner")
+			continue
+		}
+
+		// The job variables come first, the step ones override them
+		env := make(map[string]string, len(jobEnv)+len(stepEnvs[i]))
+		for k, v := range r.baseEnvironment(job) {
+			env[k] = v
+		}
+		for k, v := range jobEnv {
+			env[k] = v
+		}
+		for k, v := range gate.expandValues(stepEnvs[i]) {
+			env[k] = v
+		}
+
+		err := r.RunStep(&step, env, workdir)
+		stepDuration := time.Since(stepStart)
+		summary.stepFinished(step.Name, stepStart, err)
+		gate.finished(&step, nil, err)
+
+		if err != nil {
+			summary.FailedSteps++
+			if step.ContinueOnErr {
+				r.formatter.PrintWarning(fmt.Sprintf("Step failed but continuing: %v", err))
+				r.formatter.PrintStepComplete(stepDuration)
+				continue
+			}
+			r.formatter.PrintStepFailed(err, stepDuration)
+			summary.Success = false
+			summary.Errors = append(summary.Errors, fmt.Sprintf("Step '%s' failed: %v", step.Name, err))
+			summary.ExitCode = ExitCode(err)
+			gate.fail()
+			continue
+		}
+
+		summary.CompletedSteps++
+		r.formatter.PrintStepComplete(stepDuration)
+	}
+
+	r.finishCoverage(job, r.formatter)
+	r.finishSteps(summary)
+
+	// Print job summary
+	summary.Duration = time.Since(startTime)
+	if r.config.Verbose {
+		r.formatter.PrintJobSummary(summary)
+	} else {
+		r.formatter.PrintJobComplete(job.Name, summary.Duration, summary.Success)
+	}
+
+	return summary.Err(job)
+}
+
+// RunStep runs a step in the remote copy of the workdir made by RunJob.
+// env holds every variable of the step. step.WorkingDir is relative to
+// the workdir.
+func (r *SSHRunner) RunStep(step *types.Step, env map[string]string, workdir string) error {
+	if step.Run == "" {
+		return nil
+	}
+	if r.remoteDir == "" {
+		return fmt.Errorf("no remote workdir for step '%s'", step.Name)
+	}
+
+	if r.config.Verbose {
+		r.formatter.PrintCommand(step.Run, 2)
+	}
+
+	// Variables are passed through env(1): servers only accept the ones
+	// listed in their AcceptEnv
+	inv := newStepInvocation(step, r.remoteDir, env)
+	command := inv.shellLine(shellQuote(inv.dir), func(name, value string) string {
+		return shellQuote(name + "=" + value)
+	})
+
+	// Report progress while the step stays silent
+	hb := r.formatter.NewHeartbeat(step.Name, r.config.HeartbeatInterval(), r.effectiveTimeout(step))
+	hb.Start()
+	defer hb.Stop()
+
+	attempts := 1
+	if step.RetryPolicy != nil && step.RetryPolicy.MaxAttempts > 1 {
+		attempts = step.RetryPolicy.MaxAttempts
+	}
+
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if attempt > 1 {
+			r.formatter.PrintWarning(fmt.Sprintf("Attempt %d failed: %v", attempt-1, err))
+			r.formatter.PrintInfo(fmt.Sprintf("Retry attempt %d/%d", attempt, attempts))
+			if duration, parseErr := time.ParseDuration(step.RetryPolicy.Delay); parseErr == nil {
+				time.Sleep(duration)
+			}
+		}
+
+		if err = r.execStep(step, command, hb); err == nil {
+			return nil
+		}
+	}
+
+	if attempts > 1 {
+		return fmt.Errorf("all %d attempts failed, last error: %w", attempts, err)
+	}
+	return err
+}
+
+// execStep runs a step once on the remote host and returns an error for
+// a non-zero exit status or a step timeout
+func (r *SSHRunner) execStep(step *types.Step, command string, hb *Heartbeat) error {
+	session, err := r.client.NewSession()
+	if err != nil {
+		return fmt.Errorf("failed to open SSH session: %w", err)
+	}
+	defer session.Close()
+
+	stdout := newHeartbeatWriter(os.Stdout, hb, r.output, r.formatter)
+	stderr := newHeartbeatWriter(os.Stderr, hb, r.output, r.formatter)
+	session.Stdout = stdout
+	session.Stderr = stderr
+
+	ctx := context.Background()
+	if step.TimeoutMin > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.config.Minutes(step.TimeoutMin))
+		defer cancel()
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- session.Run(command)
+	}()
+
+	select {
+	case err = <-done:
+		_ = stdout.Flush()
+		_ = stderr.Flush()
+	case <-ctx.Done():
+		_ = session.Signal(ssh.SIGKILL)
+		return fmt.Errorf("step timed out after %d minute(s)", step.TimeoutMin)
+	}
+
+	var exitErr *ssh.ExitError
+	if errors.As(err, &exitErr) {
+		return &ExitError{Code: exitErr.ExitStatus(), Message: fmt.Sprintf("command exited with status %d", exitErr.ExitStatus())}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to run step: %w", err)
+	}
+
+	return nil
+}
+
+// effectiveTimeout returns the timeout that applies to a step
+func (r *SSHRunner) effectiveTimeout(step *types.Step) time.Duration {
+	if step.TimeoutMin > 0 {
+		return r.config.Minutes(step.TimeoutMin)
+	}
+	return r.config.Minutes(r.config.Timeout)
+}
+
+// run runs a command on the remote host and returns its trimmed output
+func (r *SSHRunner) run(command string) (string, error) {
+	session, err := r.client.NewSession()
+	if err != nil {
+		return "", fmt.Errorf("failed to open SSH session: %w", err)
+	}
+	defer session.Close()
+
+	out, err := session.CombinedOutput(command)
+	if err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return "", fmt.Errorf("%w: %s", err, msg)
+		}
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// upload copies workdir into a new temporary directory of the remote
+// host, streamed as a tar archive
+func (r *SSHRunner) upload(workdir string) error {
+	dir, err := r.run("mktemp -d /tmp/git-ci.XXXXXX")
+	if err != nil {
+		return fmt.Errorf("failed to create remote workdir: %w", err)
+	}
+	r.remoteDir = dir
+
+	session, err := r.client.NewSession()
+	if err != nil {
+		return fmt.Errorf("failed to open SSH session: %w", err)
+	}
+	defer session.Close()
+
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("failed to open SSH session input: %w", err)
+	}
+	if err := session.Start("tar -xf - -C " + shellQuote(dir)); err != nil {
+		return fmt.Errorf("failed to start remote tar: %w", err)
+	}
+
+	writeErr := writeTar(stdin, workdir, "")
+	stdin.Close()
+	if err := session.Wait(); err != nil {
+		return fmt.Errorf("failed to extract workdir on %s: %w", r.target, err)
+	}
+	if writeErr != nil {
+		return fmt.Errorf("failed to copy workdir: %w", writeErr)
+	}
+	return nil
+}
+
+// writeTar writes the files of dir to w as a tar archive, under prefix
+// when not empty
+func writeTar(w io.Writer, dir, prefix string) error {
+	tw := tar.NewWriter(w)
+
+	err := filepath.WalkDir(dir, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, file)
+		if err != nil || rel == "." {
+			return err
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		var link string
+		if info.Mode()&fs.ModeSymlink != 0 {
+			if link, err = os.Readlink(file); err != nil {
+				return err
+			}
+		} else if !info.Mode().IsRegular() && !info.IsDir() {
+			return nil // Sockets, devices...
+		}
+
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		header.Name = path.Join(prefix, filepath.ToSlash(rel))
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return tw.Close()
+}
+
+// baseEnvironment returns the variables the runner sets for every step
+func (r *SSHRunner) baseEnvironment(job *types.Job) map[string]string {
+	return map[string]string{
+		"CI":         "true",
+		"GIT_CI":     "true",
+		"SSH_RUNNER": "true",
+		"JOB_NAME":   job.Name,
+	}
+}
+
+// Cleanup removes the remote workdir and closes the connection
+func (r *SSHRunner) Cleanup() error {
+	if r.client == nil {
+		return nil
+	}
+	defer r.client.Close()
+
+	if r.remoteDir == "" {
+		return nil
+	}
+
+	r.formatter.PrintSection("Cleaning up remote workdir")
+	if _, err := r.run("rm -rf " + shellQuote(r.remoteDir)); err != nil {
+		r.formatter.PrintWarning(fmt.Sprintf("Failed to remove %s on %s", r.remoteDir, r.target))
+		return fmt.Errorf("failed to remove remote workdir: %w", err)
+	}
+	r.formatter.PrintInfo(fmt.Sprintf("Removed %s", r.remoteDir))
+	r.remoteDir = ""
+
+	return nil
+}
+
+// GetRunnerType returns the type of this runner
+func (r *SSHRunner) GetRunnerType() types.RunnerType {
+	return types.RunnerTypeSSH
+}
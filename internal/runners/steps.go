@@ -0,0 +1,51 @@
+package runners
+
+import (
+	"time"
+
+	"github.com/sanix-darker/git-ci/pkg/types"
+)
+
+// stepFinished records the outcome of a step that ran
+func (s *JobSummary) stepFinished(name string, start time.Time, err error) {
+	end := time.Now()
+	duration := end.Sub(start)
+	status := types.StepStatus{
+		Name:      name,
+		Status:    types.StatusSuccess,
+		StartTime: &start,
+		EndTime:   &end,
+		Duration:  &duration,
+	}
+	if err != nil {
+		status.Status = types.StatusFailed
+		status.Error = err.Error()
+		status.ExitCode = ExitCode(err)
+	}
+	s.Steps = append(s.Steps, status)
+}
+
+// stepSkipped records a step that did not run
+func (s *JobSummary) stepSkipped(name, reason string) {
+	s.Steps = append(s.Steps, types.StepStatus{
+		Name:    name,
+		Status:  types.StatusSkipped,
+		Error:   reason,
+		Skipped: true,
+	})
+}
+
+// stepTracker keeps the step results of the last job of a runner
+type stepTracker struct {
+	steps []types.StepStatus
+}
+
+// finishSteps keeps the step results of a job summary
+func (t *stepTracker) finishSteps(summary *JobSummary) {
+	t.steps = summary.Steps
+}
+
+// StepStatuses returns the step results of the last job, in run order
+func (t *stepTracker) StepStatuses() []types.StepStatus {
+	return t.steps
+}
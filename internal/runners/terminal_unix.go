@@ -0,0 +1,35 @@
+//go:build !windows
+
+package runners
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// terminalWidth returns the width of the terminal on stdout, 0 if stdout
+// is not a terminal
+func terminalWidth() int {
+	ws, err := unix.IoctlGetWinsize(int(os.Stdout.Fd()), unix.TIOCGWINSZ)
+	if err != nil || ws.Col == 0 {
+		return 0
+	}
+	return int(ws.Col)
+}
+
+// watchResize calls onResize with the new width when the terminal is
+// resized (SIGWINCH)
+func watchResize(onResize func(width int)) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGWINCH)
+	go func() {
+		for range signals {
+			if width := terminalWidth(); width > 0 {
+				onResize(width)
+			}
+		}
+	}()
+}
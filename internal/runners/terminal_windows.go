@@ -0,0 +1,11 @@
+//go:build windows
+
+package runners
+
+// terminalWidth is not detected on Windows; COLUMNS or the default apply
+func terminalWidth() int {
+	return 0
+}
+
+// watchResize is a no-op on Windows
+func watchResize(onResize func(width int)) {}
@@ -0,0 +1,133 @@
+package runners
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Role is the meaning of a piece of output; themes decide how it looks
+type Role int
+
+const (
+	RoleSuccess  Role = iota // Completed steps and jobs
+	RoleError                // Failures
+	RoleWarning              // Warnings, skipped items, dry runs
+	RoleInfo                 // Step names, commands prompts
+	RoleMuted                // Secondary information (paths, durations)
+	RoleFaint                // Least important output (separators, command output)
+	RoleSubtle               // Transient status lines (heartbeats)
+	RoleEmphasis             // Titles and job names
+	RoleLabel                // Keys, progress counters, bullets
+	RoleValue                // Values next to labels
+)
+
+// Theme maps roles to ANSI styles
+type Theme struct {
+	Name   string
+	Styles map[Role]string
+}
+
+// Style returns the ANSI sequence of a role, "" for plain text
+func (t *Theme) Style(role Role) string {
+	if t == nil {
+		return ""
+	}
+	return t.Styles[role]
+}
+
+// DefaultThemeName is the theme used when none is configured
+const DefaultThemeName = "default"
+
+var themes = map[string]*Theme{
+	// Subtle, muted palette
+	DefaultThemeName: {
+		Name: DefaultThemeName,
+		Styles: map[Role]string{
+			RoleSuccess:  ColorGreen,
+			RoleError:    ColorRed,
+			RoleWarning:  ColorYellow,
+			RoleInfo:     ColorBlue,
+			RoleMuted:    ColorGray,
+			RoleFaint:    ColorDimGray,
+			RoleSubtle:   ColorDim,
+			RoleEmphasis: ColorBold,
+			RoleLabel:    ColorDarkGray,
+			RoleValue:    ColorLightGray,
+		},
+	},
+	// Bright colors and no dimmed text, for low contrast terminals
+	"high-contrast": {
+		Name: "high-contrast",
+		Styles: map[Role]string{
+			RoleSuccess:  "\033[1;92m",
+			RoleError:    "\033[1;91m",
+			RoleWarning:  "\033[1;93m",
+			RoleInfo:     "\033[1;96m",
+			RoleMuted:    "\033[97m",
+			RoleFaint:    "\033[37m",
+			RoleSubtle:   "\033[37m",
+			RoleEmphasis: "\033[1;97m",
+			RoleLabel:    "\033[1;37m",
+			RoleValue:    "\033[97m",
+		},
+	},
+	// No colors, only bold titles
+	"mono": {
+		Name: "mono",
+		Styles: map[Role]string{
+			RoleEmphasis: ColorBold,
+		},
+	},
+}
+
+// ThemeNames returns the available themes
+func ThemeNames() []string {
+	names := make([]string, 0, len(themes))
+	for name := range themes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LookupTheme returns a theme by name ("" is the default theme)
+func LookupTheme(name string) (*Theme, error) {
+	if name == "" {
+		name = DefaultThemeName
+	}
+	theme, ok := themes[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown theme '%s' (expected %s)", name, strings.Join(ThemeNames(), ", "))
+	}
+	return theme, nil
+}
+
+// Glyph is a status symbol with a plain ASCII form
+type Glyph int
+
+const (
+	GlyphOK Glyph = iota
+	GlyphFail
+	GlyphSkip
+	GlyphInfo
+	GlyphWarn
+	GlyphBullet
+)
+
+var glyphs = map[Glyph][2]string{
+	GlyphOK:     {"✓", "[OK]"},
+	GlyphFail:   {"✗", "[FAIL]"},
+	GlyphSkip:   {"○", "[SKIP]"},
+	GlyphInfo:   {"ℹ", "[INFO]"},
+	GlyphWarn:   {"⚠", "[WARN]"},
+	GlyphBullet: {"•", "*"},
+}
+
+// GlyphText returns the symbol of a glyph, in its ASCII form if asked
+func GlyphText(g Glyph, ascii bool) string {
+	if ascii {
+		return glyphs[g][1]
+	}
+	return glyphs[g][0]
+}
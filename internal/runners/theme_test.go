@@ -0,0 +1,73 @@
+package runners
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// printSample prints a job with each kind of output the formatter has
+func printSample(f *OutputFormatter) {
+	f.PrintHeader("build", "/builds/app", "bash (native)")
+	f.PrintStepHeader("Compile", 1, 3)
+	f.PrintOutput("go build ./...", 4)
+	f.PrintStepComplete(1500 * time.Millisecond)
+	f.PrintStepHeader("Test", 2, 3)
+	f.PrintWarning("flaky test retried")
+	f.PrintStepFailed(errors.New("exit status 1"), 2*time.Second)
+	f.PrintStepHeader("Deploy", 3, 3)
+	f.PrintStepSkipped("a previous step failed")
+	f.PrintJobSummary(&JobSummary{
+		JobName:        "build",
+		TotalSteps:     3,
+		CompletedSteps: 1,
+		FailedSteps:    1,
+		SkippedSteps:   1,
+		Duration:       3500 * time.Millisecond,
+		Errors:         []string{"Step 'Test' failed: exit status 1"},
+	})
+	f.PrintJobComplete("build", 3500*time.Millisecond, false)
+}
+
+func TestThemeSnapshots(t *testing.T) {
+	// Lay out for the 60 columns of the formatter, not the terminal
+	defer liveWidth.Store(liveWidth.Swap(0))
+
+	for _, name := range ThemeNames() {
+		for _, ascii := range []bool{false, true} {
+			snapshot := "theme-" + name
+			if ascii {
+				snapshot += "-ascii"
+			}
+			t.Run(snapshot, func(t *testing.T) {
+				theme, err := LookupTheme(name)
+				if err != nil {
+					t.Fatal(err)
+				}
+				f := &OutputFormatter{Width: 60, UseColor: true, IndentSize: 2, Theme: theme, ASCII: ascii}
+
+				// Escape sequences are written as \e to keep the snapshots
+				// readable
+				out := captureStdout(t, func() { printSample(f) })
+				out = strings.ReplaceAll(out, "\033", `\e`)
+
+				golden := filepath.Join("testdata", snapshot+".golden")
+				if *update {
+					if err := os.WriteFile(golden, []byte(out), 0o644); err != nil {
+						t.Fatal(err)
+					}
+				}
+				want, err := os.ReadFile(golden)
+				if err != nil {
+					t.Fatalf("no snapshot (go test ./internal/runners -run TestThemeSnapshots -update writes it): %v", err)
+				}
+				if out != string(want) {
+					t.Errorf("output of theme %s changed (-update rewrites it):\n%s", name, out)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package secrets
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sanix-darker/git-ci/pkg/types"
+	"gopkg.in/yaml.v3"
+)
+
+// FileProvider reads secrets from a local JSON or YAML file. Top-level
+// keys are variable names or store paths; a path maps to its fields:
+//
+//	{"DATABASE_PASSWORD": "s3cret", "production/db": {"password": "s3cret"}}
+type FileProvider struct {
+	path   string
+	values map[string]interface{}
+}
+
+// LoadFile reads a secrets file, YAML unless its extension is .json
+func LoadFile(path string) (*FileProvider, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secrets file: %w", err)
+	}
+
+	values := make(map[string]interface{})
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		err = json.Unmarshal(data, &values)
+	} else {
+		err = yaml.Unmarshal(data, &values)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid secrets file %s: %w", path, err)
+	}
+	return &FileProvider{path: path, values: values}, nil
+}
+
+// LookupName returns the secret stored under a variable name
+func (f *FileProvider) LookupName(name string) (string, bool) {
+	return scalar(f.values[name])
+}
+
+// Lookup returns the field of a secret stored under its path, with or
+// without the engine path prefix
+func (f *FileProvider) Lookup(source *types.SecretSource) (string, bool, error) {
+	paths := []string{joinPath(source.Config["engine_path"], source.Path), joinPath(source.Path)}
+	for _, path := range paths {
+		value, exists := f.values[path]
+		if !exists {
+			continue
+		}
+		if source.Key == "" {
+			if s, ok := scalar(value); ok {
+				return s, true, nil
+			}
+			continue
+		}
+		if fields, ok := value.(map[string]interface{}); ok {
+			if s, ok := scalar(fields[source.Key]); ok {
+				return s, true, nil
+			}
+		}
+	}
+	return "", false, nil
+}
+
+// scalar returns a string, number or boolean value as a string
+func scalar(v interface{}) (string, bool) {
+	switch x := v.(type) {
+	case string:
+		return x, true
+	case int, int64, float64, bool:
+		return fmt.Sprint(x), true
+	}
+	return "", false
+}
@@ -0,0 +1,96 @@
+// Package secrets resolves the secrets jobs read from external stores
+// (GitLab `secrets:`), from a local secrets file or from the store itself.
+package secrets
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sanix-darker/git-ci/pkg/types"
+)
+
+// Provider reads secrets from one kind of store
+type Provider interface {
+	// Lookup returns the value of a secret, ok being false when the store
+	// does not hold it
+	Lookup(source *types.SecretSource) (value string, ok bool, err error)
+}
+
+// Resolver resolves secrets: the secrets file, when given, is read first
+// so any secret can be provided locally, then the store of the secret.
+type Resolver struct {
+	file      *FileProvider
+	providers map[string]Provider
+}
+
+// NewResolver returns a resolver reading secretsFile ("" for none) and the
+// stores configured in env (VAULT_ADDR, VAULT_TOKEN, ...)
+func NewResolver(secretsFile string, env map[string]string) (*Resolver, error) {
+	r := &Resolver{
+		providers: map[string]Provider{
+			"vault": NewVaultProvider(env),
+		},
+	}
+
+	if secretsFile != "" {
+		file, err := LoadFile(secretsFile)
+		if err != nil {
+			return nil, err
+		}
+		r.file = file
+	}
+	return r, nil
+}
+
+// Resolve returns the value of a secret
+func (r *Resolver) Resolve(secret *types.Secret) (string, error) {
+	if r.file != nil {
+		if value, ok := r.file.LookupName(secret.Name); ok {
+			return value, nil
+		}
+	}
+
+	source := secret.ValueFrom
+	if source == nil {
+		return "", fmt.Errorf("secret '%s' has no source%s", secret.Name, r.fileHint(secret))
+	}
+
+	// A secrets file may also hold secrets by their path in the store
+	if r.file != nil {
+		if value, ok, _ := r.file.Lookup(source); ok {
+			return value, nil
+		}
+	}
+
+	provider, ok := r.providers[source.Provider]
+	if !ok {
+		return "", fmt.Errorf("secret '%s': %s can't be read locally%s", secret.Name, source.Provider, r.fileHint(secret))
+	}
+	value, found, err := provider.Lookup(source)
+	if err != nil {
+		return "", fmt.Errorf("secret '%s': %w", secret.Name, err)
+	}
+	if !found {
+		return "", fmt.Errorf("secret '%s' not found in %s%s", secret.Name, source, r.fileHint(secret))
+	}
+	return value, nil
+}
+
+// fileHint tells how to provide a secret that can't be resolved
+func (r *Resolver) fileHint(secret *types.Secret) string {
+	if r.file != nil {
+		return fmt.Sprintf(" (nor in %s)", r.file.path)
+	}
+	return fmt.Sprintf(" (provide %s with --secrets-file)", secret.Name)
+}
+
+// joinPath joins the parts of a store path, ignoring empty ones
+func joinPath(parts ...string) string {
+	var kept []string
+	for _, part := range parts {
+		if part = strings.Trim(part, "/"); part != "" {
+			kept = append(kept, part)
+		}
+	}
+	return strings.Join(kept, "/")
+}
@@ -0,0 +1,134 @@
+package secrets
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sanix-darker/git-ci/pkg/types"
+)
+
+// vaultTimeout bounds each request to Vault
+const vaultTimeout = 30 * time.Second
+
+// VaultProvider reads secrets from the KV engines of HashiCorp Vault
+// through its HTTP API
+type VaultProvider struct {
+	addr      string
+	token     string
+	namespace string
+	client    *http.Client
+
+	// Secrets already read, by API path
+	cache map[string]map[string]interface{}
+}
+
+// NewVaultProvider returns a provider for the server of VAULT_ADDR (or
+// GitLab's VAULT_SERVER_URL), authenticated with VAULT_TOKEN or the token
+// of the vault CLI (~/.vault-token)
+func NewVaultProvider(env map[string]string) *VaultProvider {
+	v := &VaultProvider{
+		addr:      env["VAULT_ADDR"],
+		token:     env["VAULT_TOKEN"],
+		namespace: env["VAULT_NAMESPACE"],
+		client:    &http.Client{Timeout: vaultTimeout},
+		cache:     make(map[string]map[string]interface{}),
+	}
+	if v.addr == "" {
+		v.addr = env["VAULT_SERVER_URL"]
+	}
+	v.addr = strings.TrimSuffix(v.addr, "/")
+
+	if v.token == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			if data, err := os.ReadFile(filepath.Join(home, ".vault-token")); err == nil {
+				v.token = strings.TrimSpace(string(data))
+			}
+		}
+	}
+	return v
+}
+
+// Lookup reads the field of a secret. KV version 2 engines (the default)
+// nest the fields under data.data.
+func (v *VaultProvider) Lookup(source *types.SecretSource) (string, bool, error) {
+	if v.addr == "" {
+		return "", false, fmt.Errorf("vault: VAULT_ADDR is not set")
+	}
+	if v.token == "" {
+		return "", false, fmt.Errorf("vault: VAULT_TOKEN is not set")
+	}
+	if source.Key == "" {
+		return "", false, fmt.Errorf("vault: no field given for %s", source.Path)
+	}
+
+	enginePath := source.Config["engine_path"]
+	if enginePath == "" {
+		enginePath = "kv-v2"
+	}
+	kv2 := source.Config["engine"] == "" || source.Config["engine"] == "kv-v2"
+
+	apiPath := joinPath(enginePath, source.Path)
+	if kv2 {
+		apiPath = joinPath(enginePath, "data", source.Path)
+	}
+
+	fields, err := v.read(apiPath, kv2)
+	if err != nil || fields == nil {
+		return "", false, err
+	}
+	value, ok := scalar(fields[source.Key])
+	return value, ok, nil
+}
+
+// read returns the fields of the secret at an API path, nil when it does
+// not exist
+func (v *VaultProvider) read(apiPath string, kv2 bool) (map[string]interface{}, error) {
+	if fields, ok := v.cache[apiPath]; ok {
+		return fields, nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, v.addr+"/v1/"+apiPath, nil)
+	if err != nil {
+		return nil, fmt.Errorf("vault: %w", err)
+	}
+	req.Header.Set("X-Vault-Token", v.token)
+	if v.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.namespace)
+	}
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("vault: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		v.cache[apiPath] = nil
+		return nil, nil
+	case http.StatusForbidden:
+		return nil, fmt.Errorf("vault: permission denied reading %s (check VAULT_TOKEN)", apiPath)
+	default:
+		return nil, fmt.Errorf("vault: reading %s failed: %s", apiPath, resp.Status)
+	}
+
+	var body struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("vault: invalid response for %s: %w", apiPath, err)
+	}
+
+	fields := body.Data
+	if kv2 {
+		fields, _ = body.Data["data"].(map[string]interface{})
+	}
+	v.cache[apiPath] = fields
+	return fields, nil
+}
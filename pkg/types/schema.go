@@ -0,0 +1,185 @@
+package types
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SchemaVersion is the version of the exported Pipeline and PipelineRun
+// documents, written in their "version" field. New optional fields bump
+// the minor version; removing, renaming or retyping a field bumps the
+// major version. Every bump gets an entry in schema/CHANGELOG.md.
+const SchemaVersion = "4.14"
+
+// schemaBaseURL prefixes the $id of the published schemas
+const schemaBaseURL = "https://github.com/sanix-darker/git-ci/schema/"
+
+//go:embed schema/*.json
+var schemaFiles embed.FS
+
+// schemaDocuments maps the published documents to their Go type
+var schemaDocuments = map[string]reflect.Type{
+	"pipeline": reflect.TypeOf(Pipeline{}),
+	"run":      reflect.TypeOf(PipelineRun{}),
+}
+
+// SchemaNames returns the names of the documents with a schema
+func SchemaNames() []string {
+	names := make([]string, 0, len(schemaDocuments))
+	for name := range schemaDocuments {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Schema returns the committed JSON schema of a document
+func Schema(name string) ([]byte, error) {
+	if _, ok := schemaDocuments[name]; !ok {
+		return nil, fmt.Errorf("unknown schema '%s' (expected %s)", name, strings.Join(SchemaNames(), " or "))
+	}
+	return schemaFiles.ReadFile("schema/" + name + ".json")
+}
+
+// GenerateSchema builds the JSON schema of a document from the Go types.
+// The committed schema must match it (make schema-check).
+func GenerateSchema(name string) ([]byte, error) {
+	t, ok := schemaDocuments[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown schema '%s' (expected %s)", name, strings.Join(SchemaNames(), " or "))
+	}
+
+	g := &schemaGenerator{defs: make(map[string]interface{})}
+	root := g.structSchema(t)
+	root["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	root["$id"] = schemaBaseURL + name + ".json"
+	root["title"] = "git-ci " + name
+	root["properties"].(map[string]interface{})["version"] = map[string]interface{}{
+		"type":  "string",
+		"const": SchemaVersion,
+	}
+	root["required"] = append(root["required"].([]string), "version")
+	sort.Strings(root["required"].([]string))
+	if len(g.defs) > 0 {
+		root["$defs"] = g.defs
+	}
+
+	data, err := json.MarshalIndent(root, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// schemaGenerator converts Go types to JSON schema, following the
+// encoding/json rules for field names and omitempty
+type schemaGenerator struct {
+	defs map[string]interface{}
+}
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	durationType = reflect.TypeOf(time.Duration(0))
+)
+
+func (g *schemaGenerator) typeSchema(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t {
+	case timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case durationType:
+		return map[string]interface{}{"type": "integer", "description": "nanoseconds"}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": g.typeSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": g.typeSchema(t.Elem())}
+	case reflect.Struct:
+		// Named structs are shared through $defs, which also handles recursion
+		name := t.Name()
+		if _, ok := g.defs[name]; !ok {
+			g.defs[name] = nil
+			g.defs[name] = g.structSchema(t)
+		}
+		return map[string]interface{}{"$ref": "#/$defs/" + name}
+	}
+
+	// interface{} and anything else accept any value
+	return map[string]interface{}{}
+}
+
+func (g *schemaGenerator) structSchema(t reflect.Type) map[string]interface{} {
+	properties := make(map[string]interface{})
+	required := []string{}
+	g.addFields(t, properties, &required)
+	sort.Strings(required)
+
+	return map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+		"required":   required,
+	}
+}
+
+func (g *schemaGenerator) addFields(t reflect.Type, properties map[string]interface{}, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+
+		// Embedded structs without a name are flattened
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Ptr {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				g.addFields(embedded, properties, required)
+				continue
+			}
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+		schema := g.typeSchema(field.Type)
+		if !strings.Contains(opts, "omitempty") {
+			*required = append(*required, name)
+
+			// Without omitempty, nil values are written as null
+			switch field.Type.Kind() {
+			case reflect.Ptr, reflect.Map, reflect.Slice:
+				schema = map[string]interface{}{
+					"anyOf": []interface{}{schema, map[string]interface{}{"type": "null"}},
+				}
+			}
+		}
+		properties[name] = schema
+	}
+}
@@ -0,0 +1,54 @@
+package types
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+// TestSchemaUpToDate fails when the Go types changed without the
+// committed schemas being regenerated (make schema)
+func TestSchemaUpToDate(t *testing.T) {
+	for _, name := range SchemaNames() {
+		committed, err := Schema(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		generated, err := GenerateSchema(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(committed) == string(generated) {
+			continue
+		}
+
+		want := strings.Split(string(committed), "\n")
+		got := strings.Split(string(generated), "\n")
+		line := 0
+		for line < len(want) && line < len(got) && want[line] == got[line] {
+			line++
+		}
+		t.Errorf("schema '%s' is out of date from line %d: run 'make schema', bump SchemaVersion and update the changelog\n  committed: %s\n  generated: %s",
+			name, line+1, lineAt(want, line), lineAt(got, line))
+	}
+}
+
+// TestSchemaVersionInChangelog fails when SchemaVersion has no changelog
+// entry
+func TestSchemaVersionInChangelog(t *testing.T) {
+	changelog, err := os.ReadFile("schema/CHANGELOG.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(changelog), "\n## "+SchemaVersion+"\n") {
+		t.Errorf("SchemaVersion %s has no entry in schema/CHANGELOG.md", SchemaVersion)
+	}
+}
+
+// lineAt returns line i of lines, or a marker past their end
+func lineAt(lines []string, i int) string {
+	if i < len(lines) {
+		return strings.TrimSpace(lines[i])
+	}
+	return "(end of file)"
+}
@@ -2,6 +2,9 @@ package types
 
 import (
 	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -59,6 +62,9 @@ type Job struct {
 	Steps       []Step            `yaml:"steps" json:"steps"`
 	Environment map[string]string `yaml:"env,omitempty" json:"env,omitempty"`
 
+	// Pipeline variables the job doesn't see (GitLab `inherit: variables:`)
+	ExcludedVariables []string `yaml:"excluded_variables,omitempty" json:"excluded_variables,omitempty"`
+
 	// Runner specification
 	// GitHub: runs-on, GitLab: tags/image, Jenkins: agent, CircleCI: executor
 	RunsOn   string   `yaml:"runs-on,omitempty" json:"runs-on,omitempty"`
@@ -72,11 +78,22 @@ type Job struct {
 	Services  map[string]*Service `yaml:"services,omitempty" json:"services,omitempty"`
 
 	// Dependencies and ordering
-	Needs        []string `yaml:"needs,omitempty" json:"needs,omitempty"`               // GitHub/GitLab
+	Needs        []Need   `yaml:"needs,omitempty" json:"needs,omitempty"`               // GitHub/GitLab
 	Dependencies []string `yaml:"dependencies,omitempty" json:"dependencies,omitempty"` // GitLab
 	Stage        string   `yaml:"stage,omitempty" json:"stage,omitempty"`               // GitLab
 	Requires     []string `yaml:"requires,omitempty" json:"requires,omitempty"`         // CircleCI
 
+	// NeedsSet is true when `needs` was declared, so an empty list ("start
+	// right away") can be told apart from an omitted key (GitLab stages)
+	NeedsSet bool `yaml:"needs_set,omitempty" json:"needs_set,omitempty"`
+
+	// DependenciesSet is true when `dependencies` was declared, so an empty
+	// list ("no artifacts") can be told apart from an omitted key
+	DependenciesSet bool `yaml:"dependencies_set,omitempty" json:"dependencies_set,omitempty"`
+
+	// Templates the job was built from, already merged into it
+	Extends []string `yaml:"extends,omitempty" json:"extends,omitempty"` // GitLab
+
 	// Conditionals
 	If     string      `yaml:"if,omitempty" json:"if,omitempty"`         // GitHub
 	Only   *OnlyExcept `yaml:"only,omitempty" json:"only,omitempty"`     // GitLab
@@ -85,17 +102,22 @@ type Job struct {
 	When   string      `yaml:"when,omitempty" json:"when,omitempty"`     // GitLab/CircleCI
 
 	// Execution control
-	TimeoutMin    int          `yaml:"timeout-minutes,omitempty" json:"timeout-minutes,omitempty"`
-	Timeout       string       `yaml:"timeout,omitempty" json:"timeout,omitempty"` // GitLab format
-	ContinueOnErr bool         `yaml:"continue-on-error,omitempty" json:"continue-on-error,omitempty"`
-	AllowFailure  bool         `yaml:"allow_failure,omitempty" json:"allow_failure,omitempty"` // GitLab
-	Retry         *RetryPolicy `yaml:"retry,omitempty" json:"retry,omitempty"`
-	MaxRetries    int          `yaml:"max_retries,omitempty" json:"max_retries,omitempty"` // Jenkins
+	TimeoutMin     int          `yaml:"timeout-minutes,omitempty" json:"timeout-minutes,omitempty"`
+	Timeout        string       `yaml:"timeout,omitempty" json:"timeout,omitempty"` // GitLab format
+	ContinueOnErr  bool         `yaml:"continue-on-error,omitempty" json:"continue-on-error,omitempty"`
+	AllowFailure   bool         `yaml:"allow_failure,omitempty" json:"allow_failure,omitempty"`                       // GitLab
+	AllowExitCodes []int        `yaml:"allow_failure_exit_codes,omitempty" json:"allow_failure_exit_codes,omitempty"` // GitLab: exit codes the job may fail with
+	Interruptible  bool         `yaml:"interruptible,omitempty" json:"interruptible,omitempty"`                       // GitLab: may be cancelled by a newer pipeline
+	ResourceGroup  string       `yaml:"resource_group,omitempty" json:"resource_group,omitempty"`                     // GitLab: jobs of a group never run at the same time
+	StartIn        string       `yaml:"start_in,omitempty" json:"start_in,omitempty"`                                 // GitLab: delay of a `when: delayed` job
+	Retry          *RetryPolicy `yaml:"retry,omitempty" json:"retry,omitempty"`
+	MaxRetries     int          `yaml:"max_retries,omitempty" json:"max_retries,omitempty"` // Jenkins
 
 	// Parallelism and strategy
-	Strategy *Strategy                `yaml:"strategy,omitempty" json:"strategy,omitempty"` // GitHub
-	Parallel *Parallel                `yaml:"parallel,omitempty" json:"parallel,omitempty"` // GitLab
-	Matrix   map[string][]interface{} `yaml:"matrix,omitempty" json:"matrix,omitempty"`     // Jenkins/CircleCI
+	Strategy *Strategy                `yaml:"strategy,omitempty" json:"strategy,omitempty"`   // GitHub
+	MatrixOf string                   `yaml:"matrix_of,omitempty" json:"matrix_of,omitempty"` // GitHub: the job whose strategy matrix this one is a combination of
+	Parallel *Parallel                `yaml:"parallel,omitempty" json:"parallel,omitempty"`   // GitLab
+	Matrix   map[string][]interface{} `yaml:"matrix,omitempty" json:"matrix,omitempty"`       // Jenkins/CircleCI
 
 	// Scripts (GitLab style)
 	Script       []string `yaml:"script,omitempty" json:"script,omitempty"`
@@ -104,20 +126,40 @@ type Job struct {
 
 	// Artifacts and caching
 	Artifacts *ArtifactConfig `yaml:"artifacts,omitempty" json:"artifacts,omitempty"`
-	Cache     *CacheConfig    `yaml:"cache,omitempty" json:"cache,omitempty"`
+	Cache     *CacheConfig    `yaml:"cache,omitempty" json:"cache,omitempty"`   // First cache block
+	Caches    []*CacheConfig  `yaml:"caches,omitempty" json:"caches,omitempty"` // All cache blocks (GitLab lists)
 
 	// Advanced features
-	Secrets       map[string]string `yaml:"secrets,omitempty" json:"secrets,omitempty"`
-	Outputs       map[string]string `yaml:"outputs,omitempty" json:"outputs,omitempty"`
-	ResourceClass string            `yaml:"resource_class,omitempty" json:"resource_class,omitempty"` // CircleCI
+	Secrets       map[string]string   `yaml:"secrets,omitempty" json:"secrets,omitempty"`
+	SecretRefs    []*Secret           `yaml:"secret_refs,omitempty" json:"secret_refs,omitempty"` // GitLab `secrets:` read from external stores
+	IDTokens      map[string]*IDToken `yaml:"id_tokens,omitempty" json:"id_tokens,omitempty"`     // GitLab: OIDC ID tokens, by variable name
+	Outputs       map[string]string   `yaml:"outputs,omitempty" json:"outputs,omitempty"`
+	ResourceClass string              `yaml:"resource_class,omitempty" json:"resource_class,omitempty"` // CircleCI
+	Coverage      string              `yaml:"coverage,omitempty" json:"coverage,omitempty"`             // GitLab: regex reading the coverage from the output
 
 	// Workflow integration
 	WorkflowCall *WorkflowCall  `yaml:"workflow_call,omitempty" json:"workflow_call,omitempty"` // Reusable workflows
 	Trigger      *TriggerConfig `yaml:"trigger,omitempty" json:"trigger,omitempty"`             // GitLab downstream
 
 	// Environment and deployment
-	EnvironmentName string `yaml:"environment,omitempty" json:"environment,omitempty"`
-	DeploymentTier  string `yaml:"deployment_tier,omitempty" json:"deployment_tier,omitempty"`
+	EnvironmentName   string       `yaml:"environment,omitempty" json:"environment,omitempty"`
+	EnvironmentAction string       `yaml:"environment_action,omitempty" json:"environment_action,omitempty"` // GitLab: start/prepare/verify/access/stop
+	DeploymentTier    string       `yaml:"deployment_tier,omitempty" json:"deployment_tier,omitempty"`
+	Deployment        *Environment `yaml:"deployment,omitempty" json:"deployment,omitempty"` // GitLab: the whole environment block
+
+	// Local-only hints from the top-level `x-git-ci:` block
+	Local *LocalHints `yaml:"x-git-ci,omitempty" json:"x-git-ci,omitempty"`
+}
+
+// LocalHints are settings that only apply when a job runs with git-ci.
+// They come from a top-level `x-git-ci:` map keyed by job name, which real
+// CI ignores. CLI flags take precedence over them.
+type LocalHints struct {
+	Runner        string            `yaml:"runner,omitempty" json:"runner,omitempty"` // bash, docker or podman
+	Memory        string            `yaml:"memory,omitempty" json:"memory,omitempty"` // Docker memory limit (e.g. 4g)
+	Env           map[string]string `yaml:"env,omitempty" json:"env,omitempty"`
+	Skip          bool              `yaml:"skip,omitempty" json:"skip,omitempty"`
+	ArtifactPaths []string          `yaml:"artifact_paths,omitempty" json:"artifact_paths,omitempty"`
 }
 
 // Step represents a single step in a job (universal)
@@ -166,6 +208,10 @@ type Step struct {
 	// Step type hints (for parser/runner routing)
 	Type StepType `yaml:"type,omitempty" json:"type,omitempty"`
 
+	// Lifecycle phase of GitLab and Bitbucket scripts, "" for the main
+	// script: an after_script step runs even when the job failed
+	Phase StepPhase `yaml:"phase,omitempty" json:"phase,omitempty"`
+
 	// Background and services
 	Background bool `yaml:"background,omitempty" json:"background,omitempty"`
 	Detach     bool `yaml:"detach,omitempty" json:"detach,omitempty"`
@@ -207,6 +253,7 @@ type Service struct {
 	HealthCheck *HealthCheck      `yaml:"health-check,omitempty" json:"health-check,omitempty"`
 	Networks    []string          `yaml:"networks,omitempty" json:"networks,omitempty"`
 	DependsOn   []string          `yaml:"depends_on,omitempty" json:"depends_on,omitempty"`
+	PullPolicy  []string          `yaml:"pull_policy,omitempty" json:"pull_policy,omitempty"` // GitLab: always, if-not-present, never, tried in order
 }
 
 // Strategy for matrix builds (GitHub style, but universal)
@@ -254,14 +301,37 @@ type Variable struct {
 	Expand      bool        `yaml:"expand,omitempty" json:"expand,omitempty"`
 }
 
+// Allows reports whether value is accepted by the variable (any value
+// when it has no options)
+func (v *Variable) Allows(value string) bool {
+	if v == nil || len(v.Options) == 0 {
+		return true
+	}
+	for _, option := range v.Options {
+		if option == value {
+			return true
+		}
+	}
+	return false
+}
+
 // Rule for conditional execution (GitLab style, but universal)
 type Rule struct {
-	If           string            `yaml:"if,omitempty" json:"if,omitempty"`
-	When         string            `yaml:"when,omitempty" json:"when,omitempty"`
-	Changes      []string          `yaml:"changes,omitempty" json:"changes,omitempty"`
-	Exists       []string          `yaml:"exists,omitempty" json:"exists,omitempty"`
-	Variables    map[string]string `yaml:"variables,omitempty" json:"variables,omitempty"`
-	AllowFailure bool              `yaml:"allow_failure,omitempty" json:"allow_failure,omitempty"`
+	If             string            `yaml:"if,omitempty" json:"if,omitempty"`
+	When           string            `yaml:"when,omitempty" json:"when,omitempty"`
+	Changes        []string          `yaml:"changes,omitempty" json:"changes,omitempty"`
+	Exists         []string          `yaml:"exists,omitempty" json:"exists,omitempty"`
+	Variables      map[string]string `yaml:"variables,omitempty" json:"variables,omitempty"`
+	AllowFailure   bool              `yaml:"allow_failure,omitempty" json:"allow_failure,omitempty"`
+	AllowExitCodes []int             `yaml:"allow_failure_exit_codes,omitempty" json:"allow_failure_exit_codes,omitempty"`
+	StartIn        string            `yaml:"start_in,omitempty" json:"start_in,omitempty"` // Delay of `when: delayed`
+
+	// GitHub event filters (on.push, on.pull_request); Changes holds paths
+	Branches       []string `yaml:"branches,omitempty" json:"branches,omitempty"`
+	BranchesIgnore []string `yaml:"branches_ignore,omitempty" json:"branches_ignore,omitempty"`
+	Tags           []string `yaml:"tags,omitempty" json:"tags,omitempty"`
+	TagsIgnore     []string `yaml:"tags_ignore,omitempty" json:"tags_ignore,omitempty"`
+	ChangesIgnore  []string `yaml:"changes_ignore,omitempty" json:"changes_ignore,omitempty"`
 }
 
 // OnlyExcept for GitLab style conditions
@@ -282,6 +352,25 @@ type WhenCondition struct {
 	Delayed   *time.Duration `yaml:"delayed,omitempty" json:"delayed,omitempty"`
 }
 
+// Need is an upstream job a job waits for
+type Need struct {
+	Job       string `yaml:"job" json:"job"`
+	Optional  bool   `yaml:"optional,omitempty" json:"optional,omitempty"` // GitLab: ignored when the job is not in the pipeline
+	Artifacts bool   `yaml:"artifacts" json:"artifacts"`                   // Receive the artifacts of the job (GitLab)
+}
+
+// NeedsOf returns needs on the given jobs, receiving their artifacts
+func NeedsOf(names ...string) []Need {
+	if len(names) == 0 {
+		return nil
+	}
+	needs := make([]Need, len(names))
+	for i, name := range names {
+		needs[i] = Need{Job: name, Artifacts: true}
+	}
+	return needs
+}
+
 // RetryPolicy for resilient execution
 type RetryPolicy struct {
 	MaxAttempts int      `yaml:"max,omitempty" json:"max,omitempty"`
@@ -303,17 +392,28 @@ type CacheConfig struct {
 
 // ArtifactConfig for artifact handling (universal)
 type ArtifactConfig struct {
-	Name      string            `yaml:"name,omitempty" json:"name,omitempty"`
-	Paths     []string          `yaml:"paths" json:"paths"`
-	When      string            `yaml:"when,omitempty" json:"when,omitempty"`
-	ExpireIn  string            `yaml:"expire_in,omitempty" json:"expire_in,omitempty"`
-	Reports   map[string]string `yaml:"reports,omitempty" json:"reports,omitempty"` // GitLab
-	Format    string            `yaml:"format,omitempty" json:"format,omitempty"`
-	Untracked bool              `yaml:"untracked,omitempty" json:"untracked,omitempty"`
-	Public    bool              `yaml:"public,omitempty" json:"public,omitempty"` // GitLab
-	Exclude   []string          `yaml:"exclude,omitempty" json:"exclude,omitempty"`
+	Name      string           `yaml:"name,omitempty" json:"name,omitempty"`
+	Paths     []string         `yaml:"paths" json:"paths"`
+	When      string           `yaml:"when,omitempty" json:"when,omitempty"`
+	ExpireIn  string           `yaml:"expire_in,omitempty" json:"expire_in,omitempty"`
+	Reports   []ArtifactReport `yaml:"reports,omitempty" json:"reports,omitempty"` // GitLab
+	Format    string           `yaml:"format,omitempty" json:"format,omitempty"`
+	Untracked bool             `yaml:"untracked,omitempty" json:"untracked,omitempty"`
+	Public    bool             `yaml:"public,omitempty" json:"public,omitempty"` // GitLab
+	Exclude   []string         `yaml:"exclude,omitempty" json:"exclude,omitempty"`
 }
 
+// ArtifactReport is a report a job produces (GitLab artifacts:reports)
+type ArtifactReport struct {
+	Type   string   `yaml:"type" json:"type"`                         // junit, dotenv, coverage_report, sast...
+	Paths  []string `yaml:"paths" json:"paths"`                       // Files or patterns, relative to the workspace
+	Format string   `yaml:"format,omitempty" json:"format,omitempty"` // coverage_report format (cobertura, jacoco)
+}
+
+// ReportTypeDotenv is the report whose variables GitLab passes to the
+// jobs receiving the artifacts of its job
+const ReportTypeDotenv = "dotenv"
+
 // Defaults for job/step configuration
 type Defaults struct {
 	Run           *RunDefaults    `yaml:"run,omitempty" json:"run,omitempty"`
@@ -342,19 +442,81 @@ type Concurrency struct {
 	Limit            int    `yaml:"limit,omitempty" json:"limit,omitempty"`
 }
 
+// CallSeparator joins the id of a job calling a reusable workflow and the
+// ids of the jobs of the workflow: "caller / job"
+const CallSeparator = " / "
+
 // WorkflowCall for reusable workflows
 type WorkflowCall struct {
-	Uses    string                 `yaml:"uses" json:"uses"`
-	With    map[string]interface{} `yaml:"with,omitempty" json:"with,omitempty"`
-	Secrets map[string]string      `yaml:"secrets,omitempty" json:"secrets,omitempty"`
+	Uses           string                 `yaml:"uses" json:"uses"`
+	With           map[string]interface{} `yaml:"with,omitempty" json:"with,omitempty"`
+	Secrets        map[string]string      `yaml:"secrets,omitempty" json:"secrets,omitempty"`
+	InheritSecrets bool                   `yaml:"inherit_secrets,omitempty" json:"inherit_secrets,omitempty"` // secrets: inherit
+	Inputs         map[string]string      `yaml:"inputs,omitempty" json:"inputs,omitempty"`                   // The `with:` values, else the defaults, by input name
+	Outputs        map[string]string      `yaml:"outputs,omitempty" json:"outputs,omitempty"`                 // The value expressions of the workflow outputs
+	Caller         *WorkflowCall          `yaml:"caller,omitempty" json:"caller,omitempty"`                   // The call of the workflow calling this one, when nested
 }
 
 // TriggerConfig for downstream pipelines (GitLab)
 type TriggerConfig struct {
-	Project  string            `yaml:"project,omitempty" json:"project,omitempty"`
-	Branch   string            `yaml:"branch,omitempty" json:"branch,omitempty"`
-	Strategy string            `yaml:"strategy,omitempty" json:"strategy,omitempty"`
-	Forward  map[string]string `yaml:"forward,omitempty" json:"forward,omitempty"`
+	Project  string           `yaml:"project,omitempty" json:"project,omitempty"`
+	Branch   string           `yaml:"branch,omitempty" json:"branch,omitempty"`
+	Strategy string           `yaml:"strategy,omitempty" json:"strategy,omitempty"`
+	Forward  *TriggerForward  `yaml:"forward,omitempty" json:"forward,omitempty"`
+	Include  []TriggerInclude `yaml:"include,omitempty" json:"include,omitempty"` // Child pipeline configuration
+}
+
+// TriggerInclude is a file of a child pipeline configuration (GitLab
+// `trigger:include`)
+type TriggerInclude struct {
+	Local    string `yaml:"local,omitempty" json:"local,omitempty"`
+	Artifact string `yaml:"artifact,omitempty" json:"artifact,omitempty"` // File generated by Job
+	Job      string `yaml:"job,omitempty" json:"job,omitempty"`
+	Project  string `yaml:"project,omitempty" json:"project,omitempty"`
+	Ref      string `yaml:"ref,omitempty" json:"ref,omitempty"`
+	File     string `yaml:"file,omitempty" json:"file,omitempty"`
+	Template string `yaml:"template,omitempty" json:"template,omitempty"`
+}
+
+// IsChildPipeline reports whether the trigger starts a child pipeline
+// from files of the same project
+func (t *TriggerConfig) IsChildPipeline() bool {
+	return t != nil && len(t.Include) > 0
+}
+
+// TriggerForward selects the variables a trigger job passes to the
+// downstream pipeline (GitLab `trigger:forward`)
+type TriggerForward struct {
+	YAMLVariables     bool `yaml:"yaml_variables" json:"yaml_variables"`         // Variables of the trigger job and the parent file
+	PipelineVariables bool `yaml:"pipeline_variables" json:"pipeline_variables"` // Variables given to the parent run (--env)
+}
+
+// DefaultTriggerForward is GitLab's default forwarding
+func DefaultTriggerForward() *TriggerForward {
+	return &TriggerForward{YAMLVariables: true, PipelineVariables: false}
+}
+
+// ForwardedVariables returns the variables a downstream pipeline receives
+// from the parent's YAML variables and its pipeline variables. Pipeline
+// variables take precedence, as they do in the parent.
+func (t *TriggerConfig) ForwardedVariables(yamlVars, pipelineVars map[string]string) map[string]string {
+	forward := t.Forward
+	if forward == nil {
+		forward = DefaultTriggerForward()
+	}
+
+	result := make(map[string]string)
+	if forward.YAMLVariables {
+		for k, v := range yamlVars {
+			result[k] = v
+		}
+	}
+	if forward.PipelineVariables {
+		for k, v := range pipelineVars {
+			result[k] = v
+		}
+	}
+	return result
 }
 
 // HealthCheck configuration
@@ -390,16 +552,26 @@ const (
 	StepTypeTemplate  StepType = "template" // Argo
 )
 
+// StepPhase is the lifecycle phase of a script step
+type StepPhase string
+
+const (
+	StepPhaseBeforeScript StepPhase = "before_script"
+	StepPhaseAfterScript  StepPhase = "after_script"
+)
+
 // RunnerType represents the type of runner
 type RunnerType string
 
 const (
 	RunnerTypeBash       RunnerType = "bash"
 	RunnerTypeDocker     RunnerType = "docker"
+	RunnerTypePodman     RunnerType = "podman"
 	RunnerTypeKubernetes RunnerType = "kubernetes"
 	RunnerTypeSSH        RunnerType = "ssh"
 	RunnerTypeWinRM      RunnerType = "winrm"
 	RunnerTypeVagrant    RunnerType = "vagrant"
+	RunnerTypePipeline   RunnerType = "pipeline" // Child pipeline of a trigger job
 )
 
 // PipelineStatus for execution tracking
@@ -452,6 +624,13 @@ type JobStatus struct {
 	Message   string         `json:"message,omitempty"`
 	Steps     []StepStatus   `json:"steps,omitempty"`
 	Attempts  int            `json:"attempts,omitempty"`
+
+	// Container image the job ran in and its registry digest (sha256:...)
+	Image       string `json:"image,omitempty"`
+	ImageDigest string `json:"image_digest,omitempty"`
+
+	// Coverage percentage extracted by the `coverage:` regex of the job
+	Coverage *float64 `json:"coverage,omitempty"`
 }
 
 // StepStatus for tracking step execution
@@ -495,36 +674,184 @@ type Notification struct {
 	Enabled    bool              `json:"enabled"`
 }
 
+// IDToken is an OIDC ID token a GitLab job receives in a variable
+// (`id_tokens:`)
+type IDToken struct {
+	Aud []string `yaml:"aud" json:"aud"` // Audiences of the token
+}
+
 // Secret for secure values
 type Secret struct {
-	Name        string        `json:"name"`
-	Value       string        `json:"value,omitempty"`
-	ValueFrom   *SecretSource `json:"value_from,omitempty"`
-	Type        string        `json:"type,omitempty"`
-	Required    bool          `json:"required,omitempty"`
-	Description string        `json:"description,omitempty"`
+	Name        string        `yaml:"name" json:"name"`
+	Value       string        `yaml:"value,omitempty" json:"value,omitempty"`
+	ValueFrom   *SecretSource `yaml:"value_from,omitempty" json:"value_from,omitempty"`
+	Type        string        `yaml:"type,omitempty" json:"type,omitempty"`
+	Required    bool          `yaml:"required,omitempty" json:"required,omitempty"`
+	Description string        `yaml:"description,omitempty" json:"description,omitempty"`
+	File        bool          `yaml:"file,omitempty" json:"file,omitempty"` // The variable holds the path of a file with the value
 }
 
 // SecretSource for external secret stores
 type SecretSource struct {
-	Provider string            `json:"provider"` // vault, aws-secrets, azure-keyvault
-	Path     string            `json:"path,omitempty"`
-	Key      string            `json:"key,omitempty"`
-	Version  string            `json:"version,omitempty"`
-	Config   map[string]string `json:"config,omitempty"`
+	Provider string            `yaml:"provider" json:"provider"` // vault, aws-secrets, azure-keyvault, gcp-secret-manager
+	Path     string            `yaml:"path,omitempty" json:"path,omitempty"`
+	Key      string            `yaml:"key,omitempty" json:"key,omitempty"`
+	Version  string            `yaml:"version,omitempty" json:"version,omitempty"`
+	Config   map[string]string `yaml:"config,omitempty" json:"config,omitempty"` // Vault: engine, engine_path
+}
+
+// String describes where a secret is read from
+func (s *SecretSource) String() string {
+	if s == nil {
+		return ""
+	}
+	location := s.Path
+	if s.Key != "" {
+		location += "/" + s.Key
+	}
+	if engine := s.Config["engine_path"]; engine != "" {
+		location += "@" + engine
+	}
+	if s.Version != "" {
+		location += " (version " + s.Version + ")"
+	}
+	return s.Provider + " " + location
 }
 
 // Environment for deployment targets
 type Environment struct {
-	Name           string            `json:"name"`
-	URL            string            `json:"url,omitempty"`
-	Production     bool              `json:"production,omitempty"`
-	Variables      map[string]string `json:"variables,omitempty"`
-	Secrets        []string          `json:"secrets,omitempty"`
-	OnStop         string            `json:"on_stop,omitempty"`
-	AutoStopAt     *time.Time        `json:"auto_stop_at,omitempty"`
-	ReviewApps     bool              `json:"review_apps,omitempty"`
-	DeploymentTier string            `json:"deployment_tier,omitempty"`
+	Name           string            `yaml:"name" json:"name"`
+	URL            string            `yaml:"url,omitempty" json:"url,omitempty"`
+	Production     bool              `yaml:"production,omitempty" json:"production,omitempty"`
+	Variables      map[string]string `yaml:"variables,omitempty" json:"variables,omitempty"`
+	Secrets        []string          `yaml:"secrets,omitempty" json:"secrets,omitempty"`
+	OnStop         string            `yaml:"on_stop,omitempty" json:"on_stop,omitempty"` // Job stopping the environment
+	Action         string            `yaml:"action,omitempty" json:"action,omitempty"`
+	AutoStopIn     string            `yaml:"auto_stop_in,omitempty" json:"auto_stop_in,omitempty"`
+	AutoStopAt     *time.Time        `yaml:"auto_stop_at,omitempty" json:"auto_stop_at,omitempty"`
+	ReviewApps     bool              `yaml:"review_apps,omitempty" json:"review_apps,omitempty"`
+	DeploymentTier string            `yaml:"deployment_tier,omitempty" json:"deployment_tier,omitempty"`
+}
+
+// Environment actions (GitLab environment:action)
+const (
+	EnvironmentActionStart   = "start"
+	EnvironmentActionPrepare = "prepare"
+	EnvironmentActionVerify  = "verify"
+	EnvironmentActionAccess  = "access"
+	EnvironmentActionStop    = "stop"
+)
+
+// IsStopJob reports whether the job only tears down an environment
+func (j *Job) IsStopJob() bool {
+	return j.EnvironmentAction == EnvironmentActionStop
+}
+
+// NeedNames returns the names of the jobs a job needs
+func (j *Job) NeedNames() []string {
+	if len(j.Needs) == 0 {
+		return nil
+	}
+	names := make([]string, len(j.Needs))
+	for i, need := range j.Needs {
+		names[i] = need.Job
+	}
+	return names
+}
+
+// HasNeeds reports whether the job declares its needs, possibly none; a
+// GitLab job then doesn't wait for the jobs of the earlier stages
+func (j *Job) HasNeeds() bool {
+	return j.NeedsSet || len(j.Needs) > 0
+}
+
+// UpstreamNames returns the names of the jobs a job needs or takes the
+// artifacts of (GitLab dependencies), without duplicates
+func (j *Job) UpstreamNames() []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, name := range append(j.NeedNames(), j.Dependencies...) {
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// CacheConfigs returns every cache block of a job
+func (j *Job) CacheConfigs() []*CacheConfig {
+	if len(j.Caches) > 0 {
+		return j.Caches
+	}
+	if j.Cache != nil {
+		return []*CacheConfig{j.Cache}
+	}
+	return nil
+}
+
+// PortMapping is a parsed `ports` entry of a service or job container
+type PortMapping struct {
+	HostIP        string
+	HostPort      string // Empty when the host port is picked by the engine
+	ContainerPort string
+	Protocol      string
+}
+
+// ParsePortMapping parses a port entry: "5432" (random host port),
+// "5432:5432", "127.0.0.1:8080:80", optionally suffixed with "/udp"
+func ParsePortMapping(spec string) (*PortMapping, error) {
+	mapping := &PortMapping{Protocol: "tcp"}
+
+	spec = strings.TrimSpace(spec)
+	if idx := strings.LastIndex(spec, "/"); idx >= 0 {
+		mapping.Protocol = strings.ToLower(spec[idx+1:])
+		spec = spec[:idx]
+	}
+
+	parts := strings.Split(spec, ":")
+	switch len(parts) {
+	case 1:
+		mapping.ContainerPort = parts[0]
+	case 2:
+		mapping.HostPort, mapping.ContainerPort = parts[0], parts[1]
+	case 3:
+		mapping.HostIP, mapping.HostPort, mapping.ContainerPort = parts[0], parts[1], parts[2]
+	default:
+		return nil, fmt.Errorf("invalid port mapping '%s'", spec)
+	}
+
+	for _, port := range []string{mapping.HostPort, mapping.ContainerPort} {
+		if port == "" {
+			continue
+		}
+		if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+			return nil, fmt.Errorf("invalid port '%s' in mapping '%s'", port, spec)
+		}
+	}
+	if mapping.ContainerPort == "" {
+		return nil, fmt.Errorf("missing container port in mapping '%s'", spec)
+	}
+
+	return mapping, nil
+}
+
+// ImageRepository strips the tag and digest of an image reference:
+// "node:18" and "node@sha256:..." both give "node"
+func ImageRepository(image string) string {
+	if idx := strings.Index(image, "@"); idx >= 0 {
+		image = image[:idx]
+	}
+	// A colon after the last slash starts the tag; before it, a registry port
+	if idx := strings.LastIndex(image, ":"); idx > strings.LastIndex(image, "/") {
+		image = image[:idx]
+	}
+	return image
+}
+
+// PinnedImage returns the reference of an image fixed to a digest
+func PinnedImage(image, digest string) string {
+	return ImageRepository(image) + "@" + digest
 }
 
 // Compatibility check functions
@@ -566,6 +893,18 @@ func (p *Pipeline) MarshalJSON() ([]byte, error) {
 		Version string `json:"version"`
 	}{
 		Alias:   (*Alias)(p),
-		Version: "1.0",
+		Version: SchemaVersion,
+	})
+}
+
+// MarshalJSON writes the run record with its schema version
+func (r *PipelineRun) MarshalJSON() ([]byte, error) {
+	type Alias PipelineRun
+	return json.Marshal(&struct {
+		*Alias
+		Version string `json:"version"`
+	}{
+		Alias:   (*Alias)(r),
+		Version: SchemaVersion,
 	})
 }